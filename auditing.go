@@ -14,6 +14,7 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"software.sslmate.com/src/certspotter/ct"
 )
 
@@ -113,6 +114,77 @@ func VerifyConsistencyProof(proof ct.ConsistencyProof, first *ct.SignedTreeHead,
 	return true
 }
 
+// VerifyInclusionProof checks that |proof| (ordered from the leaf towards the
+// root, as returned by get-proof-by-hash) proves that the leaf with hash
+// |leafHash| is at |index| in the tree described by |sth|.
+func VerifyInclusionProof(proof ct.AuditPath, index uint64, leafHash ct.MerkleTreeNode, sth *ct.SignedTreeHead) bool {
+	if index >= sth.TreeSize {
+		return false
+	}
+
+	node := index
+	lastNode := sth.TreeSize - 1
+	hash := leafHash
+
+	for _, sibling := range proof {
+		if lastNode == 0 {
+			// Proof is longer than the path to the root
+			return false
+		}
+		if node%2 == 1 || node == lastNode {
+			hash = hashChildren(sibling, hash)
+			// If we're the rightmost node and a left child, we have no
+			// sibling at this level, so skip levels until we're a right child.
+			if node%2 == 0 {
+				for node%2 == 0 && node != 0 {
+					node /= 2
+					lastNode /= 2
+				}
+			}
+		} else {
+			hash = hashChildren(hash, sibling)
+		}
+		node /= 2
+		lastNode /= 2
+	}
+
+	return lastNode == 0 && bytes.Equal(hash, sth.SHA256RootHash[:])
+}
+
+// collapsedTreeFromInclusionProof verifies |proof| that |leafHash| is at
+// |index| in the tree described by |sth|, and returns the collapsed tree of
+// the first |index|+1 entries.  The siblings on the left of the leaf's path
+// are the roots of the complete subtrees to its left, which are the nodes of
+// the collapsed tree of size |index|.
+func collapsedTreeFromInclusionProof(proof ct.AuditPath, index uint64, leafHash ct.MerkleTreeNode, sth *ct.SignedTreeHead) (*CollapsedMerkleTree, error) {
+	if !VerifyInclusionProof(proof, index, leafHash, sth) {
+		return nil, fmt.Errorf("Inclusion proof for entry %d does not verify against STH of size %d", index, sth.TreeSize)
+	}
+
+	// Walk the path as VerifyInclusionProof does, keeping the siblings
+	// which are on the left
+	var leftSiblings []ct.MerkleTreeNode
+	node, lastNode := index, sth.TreeSize-1
+	for _, sibling := range proof {
+		if node%2 == 1 || node == lastNode {
+			leftSiblings = append(leftSiblings, sibling)
+			for node%2 == 0 && node != 0 {
+				node /= 2
+				lastNode /= 2
+			}
+		}
+		node /= 2
+		lastNode /= 2
+	}
+	reverseHashes(leftSiblings)
+	tree, err := NewCollapsedMerkleTree(leftSiblings, index)
+	if err != nil {
+		return nil, err
+	}
+	tree.Add(leafHash)
+	return tree, nil
+}
+
 func hashNothing() ct.MerkleTreeNode {
 	return sha256.New().Sum(nil)
 }
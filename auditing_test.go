@@ -0,0 +1,104 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// Reference implementations of MTH and PATH from RFC 6962 section 2.1
+func largestPowerOfTwoLessThan(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func referenceRoot(leaves []ct.MerkleTreeNode) ct.MerkleTreeNode {
+	switch len(leaves) {
+	case 0:
+		return hashNothing()
+	case 1:
+		return leaves[0]
+	}
+	k := largestPowerOfTwoLessThan(uint64(len(leaves)))
+	return hashChildren(referenceRoot(leaves[:k]), referenceRoot(leaves[k:]))
+}
+
+func referencePath(index uint64, leaves []ct.MerkleTreeNode) ct.AuditPath {
+	if len(leaves) <= 1 {
+		return ct.AuditPath{}
+	}
+	k := largestPowerOfTwoLessThan(uint64(len(leaves)))
+	if index < k {
+		return append(referencePath(index, leaves[:k]), referenceRoot(leaves[k:]))
+	}
+	return append(referencePath(index-k, leaves[k:]), referenceRoot(leaves[:k]))
+}
+
+func makeTestLeaves(n int) []ct.MerkleTreeNode {
+	leaves := make([]ct.MerkleTreeNode, n)
+	for i := range leaves {
+		leaves[i] = hashLeaf([]byte{byte(i), byte(i >> 8)})
+	}
+	return leaves
+}
+
+func makeTestSTH(leaves []ct.MerkleTreeNode) *ct.SignedTreeHead {
+	sth := &ct.SignedTreeHead{TreeSize: uint64(len(leaves))}
+	copy(sth.SHA256RootHash[:], referenceRoot(leaves))
+	return sth
+}
+
+func TestVerifyInclusionProof(t *testing.T) {
+	for size := 1; size <= 33; size++ {
+		leaves := makeTestLeaves(size)
+		sth := makeTestSTH(leaves)
+		for index := 0; index < size; index++ {
+			proof := referencePath(uint64(index), leaves)
+			if !VerifyInclusionProof(proof, uint64(index), leaves[index], sth) {
+				t.Errorf("valid proof for leaf %d in tree of size %d did not verify", index, size)
+			}
+			if VerifyInclusionProof(proof, uint64(index), hashLeaf([]byte("bogus")), sth) {
+				t.Errorf("proof for bogus leaf at %d in tree of size %d verified", index, size)
+			}
+			if len(proof) > 0 && VerifyInclusionProof(proof[:len(proof)-1], uint64(index), leaves[index], sth) {
+				t.Errorf("truncated proof for leaf %d in tree of size %d verified", index, size)
+			}
+			if VerifyInclusionProof(append(proof, leaves[0]), uint64(index), leaves[index], sth) {
+				t.Errorf("extended proof for leaf %d in tree of size %d verified", index, size)
+			}
+		}
+		if VerifyInclusionProof(ct.AuditPath{}, uint64(size), leaves[0], sth) {
+			t.Errorf("proof for out-of-range index verified in tree of size %d", size)
+		}
+	}
+}
+
+func TestCollapsedTreeFromInclusionProof(t *testing.T) {
+	for size := 1; size <= 33; size++ {
+		leaves := makeTestLeaves(size)
+		sth := makeTestSTH(leaves)
+		for index := 0; index < size; index++ {
+			tree, err := collapsedTreeFromInclusionProof(referencePath(uint64(index), leaves), uint64(index), leaves[index], sth)
+			if err != nil {
+				t.Errorf("tree at %d in tree of size %d: %s", index+1, size, err)
+				continue
+			}
+			if tree.GetSize() != uint64(index+1) || !bytes.Equal(tree.CalculateRoot(), referenceRoot(leaves[:index+1])) {
+				t.Errorf("wrong tree at %d in tree of size %d", index+1, size)
+			}
+		}
+	}
+}
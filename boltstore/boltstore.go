@@ -0,0 +1,459 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package boltstore implements certspotter.Store on top of a bbolt database,
+// which is a single file and requires no cgo.
+//
+// The database has the following buckets:
+//
+//	meta			version => schema version (see migrations)
+//				once => time of first successful run
+//	logs/LOGID		uri, verified_sth, tree => JSON
+//	logs/LOGID/unverified_sths	TREESIZE HASH => JSON STH
+//	logs/LOGID/pending_scts	LEAFHASH => JSON PendingSCT
+//	certs			FINGERPRINT => JSON CertRecord
+//	domains			REVERSEDNAME \0 FINGERPRINT => time first seen
+//	evidence		ID => JSON Evidence
+//	notifications		ID => JSON PendingNotification
+//	issuances		ISSUANCEKEY => FINGERPRINT (see certspotter.IssuanceStore)
+//	watched_certs		FINGERPRINT => JSON WatchedCert (see certspotter.RevocationStore)
+//	serials			SERIALKEY => ISSUANCEKEY FINGERPRINT (see certspotter.SerialStore)
+//
+// where REVERSEDNAME is a DNS name with its labels reversed
+// (see certspotter.ReverseDNSName), so that all names in a domain are
+// adjacent and can be found with a single cursor scan.
+package boltstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+var (
+	metaBucket          = []byte("meta")
+	logsBucket          = []byte("logs")
+	certsBucket         = []byte("certs")
+	domainsBucket       = []byte("domains")
+	evidenceBucket      = []byte("evidence")
+	notificationsBucket = []byte("notifications")
+	issuancesBucket     = []byte("issuances")
+	notifiedBucket      = []byte("notified")
+	watchedCertsBucket  = []byte("watched_certs")
+	serialsBucket       = []byte("serials")
+)
+
+type Store struct {
+	db       *bolt.DB
+	firstRun bool
+}
+
+// Open opens the database at |path|, creating it if necessary.  bbolt holds
+// an exclusive lock on the file while it is open, so Open fails if another
+// instance is using the database.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: 1 * time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%s: database is in use by another instance of Cert Spotter", path)
+	} else if err != nil {
+		return nil, err
+	}
+	store := &Store{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: error upgrading database: %s", path, err)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		store.firstRun = tx.Bucket(metaBucket).Get([]byte("once")) == nil
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// migrations[i] upgrades the database from version i to version i+1.  Never
+// modify a migration that has been released; append a new one instead.
+var migrations = []func(*bolt.Tx) error{
+	// Version 1: initial layout.  Databases created before versioning
+	// was introduced already have these buckets.
+	func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{logsBucket, certsBucket, domainsBucket, evidenceBucket, notificationsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// Version 2: pairing of precertificates with certificates
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(issuancesBucket)
+		return err
+	},
+	// Version 3: certificates notified about on each channel
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(notifiedBucket)
+		return err
+	},
+	// Version 4: certificates whose CRLs are re-checked
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(watchedCertsBucket)
+		return err
+	},
+	// Version 5: issuer and serial numbers, for detecting duplicates
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(serialsBucket)
+		return err
+	},
+}
+
+// Each migration runs in the same transaction as the update to the version,
+// so a failed migration leaves the database unchanged
+func (store *Store) migrate() error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		version := 0
+		if value := meta.Get([]byte("version")); value != nil {
+			if version, err = strconv.Atoi(string(value)); err != nil {
+				return fmt.Errorf("invalid version: %s", err)
+			}
+		}
+		if version > len(migrations) {
+			return fmt.Errorf("database has version %d, but this version of Cert Spotter only supports up to version %d", version, len(migrations))
+		}
+		for ; version < len(migrations); version++ {
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("migration to version %d: %s", version+1, err)
+			}
+		}
+		return meta.Put([]byte("version"), []byte(strconv.Itoa(version)))
+	})
+}
+
+func (store *Store) Close() error {
+	return store.db.Close()
+}
+
+// DB returns the underlying database handle
+func (store *Store) DB() *bolt.DB {
+	return store.db
+}
+
+// The database file is locked when it's opened, so there's nothing more to do
+func (store *Store) Lock() (bool, error) {
+	return true, nil
+}
+
+func (store *Store) Unlock() error {
+	return nil
+}
+
+func (store *Store) IsFirstRun() bool {
+	return store.firstRun
+}
+
+func (store *Store) WriteOnceFile() error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put([]byte("once"), []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+func putJSON(bucket *bolt.Bucket, key []byte, obj interface{}) error {
+	value, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, value)
+}
+
+func domainKey(dnsName string, fingerprint string) []byte {
+	return []byte(certspotter.ReverseDNSName(strings.ToLower(dnsName)) + "\x00" + fingerprint)
+}
+
+func (store *Store) SaveCert(isPrecert bool, certs [][]byte) (bool, string, error) {
+	if len(certs) == 0 {
+		return false, "", fmt.Errorf("Cannot save an empty certificate chain")
+	}
+	record := certspotter.MakeCertRecord(isPrecert, certs)
+
+	alreadyPresent := false
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		certsB := tx.Bucket(certsBucket)
+		if certsB.Get([]byte(record.Fingerprint)) != nil {
+			alreadyPresent = true
+			return nil
+		}
+		if err := putJSON(certsB, []byte(record.Fingerprint), record); err != nil {
+			return err
+		}
+		domainsB := tx.Bucket(domainsBucket)
+		firstSeen := []byte(record.FirstSeen.Format(time.RFC3339))
+		for _, dnsName := range record.DNSNames {
+			if err := domainsB.Put(domainKey(dnsName, record.Fingerprint), firstSeen); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("Error saving certificate %s: %s", record.Fingerprint, err)
+	}
+	return alreadyPresent, record.Fingerprint, nil
+}
+
+func getCert(tx *bolt.Tx, fingerprint string) (*certspotter.CertRecord, error) {
+	value := tx.Bucket(certsBucket).Get([]byte(fingerprint))
+	if value == nil {
+		return nil, nil
+	}
+	record := new(certspotter.CertRecord)
+	if err := json.Unmarshal(value, record); err != nil {
+		return nil, fmt.Errorf("Certificate %s: %s", fingerprint, err)
+	}
+	return record, nil
+}
+
+// GetCert returns the certificate with the given (hex-encoded SHA-256)
+// fingerprint, or nil if it is not in the store
+func (store *Store) GetCert(fingerprint string) (*certspotter.CertRecord, error) {
+	var record *certspotter.CertRecord
+	err := store.db.View(func(tx *bolt.Tx) (err error) {
+		record, err = getCert(tx, strings.ToLower(fingerprint))
+		return
+	})
+	return record, err
+}
+
+// FindCertsByDomain returns certificates for the given DNS name, and if
+// |includeSubdomains| is true, for any DNS name under it
+func (store *Store) FindCertsByDomain(domain string, includeSubdomains bool) ([]*certspotter.CertRecord, error) {
+	reversed := certspotter.ReverseDNSName(strings.ToLower(domain))
+	prefixes := [][]byte{[]byte(reversed + "\x00")}
+	if includeSubdomains {
+		prefixes = append(prefixes, []byte(reversed+"."))
+	}
+
+	records := []*certspotter.CertRecord{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		seen := make(map[string]bool)
+		cursor := tx.Bucket(domainsBucket).Cursor()
+		for _, prefix := range prefixes {
+			for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+				fingerprint := string(key[bytes.IndexByte(key, 0)+1:])
+				if seen[fingerprint] {
+					continue
+				}
+				seen[fingerprint] = true
+				record, err := getCert(tx, fingerprint)
+				if err != nil {
+					return err
+				}
+				if record != nil {
+					records = append(records, record)
+				}
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// SearchCerts returns the certificates selected by |query|.  Unless it
+// specifies a fingerprint or domain, which are indexed, every certificate is
+// read.
+func (store *Store) SearchCerts(query *certspotter.CertQuery) ([]*certspotter.CertRecord, error) {
+	var candidates []*certspotter.CertRecord
+	switch {
+	case query.Fingerprint != "":
+		record, err := store.GetCert(query.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			candidates = append(candidates, record)
+		}
+	case query.Domain != "":
+		var err error
+		if candidates, err = store.FindCertsByDomain(query.Domain, query.IncludeSubdomains); err != nil {
+			return nil, err
+		}
+	default:
+		err := store.db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(certsBucket).ForEach(func(key []byte, value []byte) error {
+				record := new(certspotter.CertRecord)
+				if err := json.Unmarshal(value, record); err != nil {
+					return fmt.Errorf("Certificate %s: %s", key, err)
+				}
+				if query.Matches(record) {
+					candidates = append(candidates, record)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return query.Apply(candidates), nil
+}
+
+func (store *Store) SaveIssuance(key []byte, fingerprint string) (string, error) {
+	var first string
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(issuancesBucket)
+		if value := bucket.Get(key); value != nil {
+			if string(value) != fingerprint {
+				first = string(value)
+			}
+			return nil
+		}
+		return bucket.Put(key, []byte(fingerprint))
+	})
+	return first, err
+}
+
+// Values in the serials bucket are the hex-encoded issuance key, a space,
+// and the fingerprint
+func (store *Store) SaveSerial(key []byte, issuanceKey []byte, fingerprint string) (string, error) {
+	var other string
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(serialsBucket)
+		if value := bucket.Get(key); value != nil {
+			fields := strings.SplitN(string(value), " ", 2)
+			if len(fields) == 2 && fields[0] != hex.EncodeToString(issuanceKey) {
+				other = fields[1]
+			}
+			return nil
+		}
+		return bucket.Put(key, []byte(hex.EncodeToString(issuanceKey)+" "+fingerprint))
+	})
+	return other, err
+}
+
+// Keys in the notified bucket are CHANNEL, a zero byte, and the fingerprint
+func notifiedKey(channel string, fingerprint string) []byte {
+	return []byte(channel + "\x00" + fingerprint)
+}
+
+func (store *Store) HasNotified(channel string, fingerprint string) (bool, error) {
+	var notified bool
+	err := store.db.View(func(tx *bolt.Tx) error {
+		notified = tx.Bucket(notifiedBucket).Get(notifiedKey(channel, fingerprint)) != nil
+		return nil
+	})
+	return notified, err
+}
+
+func (store *Store) SaveNotified(channel string, fingerprint string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(notifiedBucket).Put(notifiedKey(channel, fingerprint), []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+	})
+}
+
+func (store *Store) StoreEvidence(evidence *certspotter.Evidence) (string, error) {
+	id := fmt.Sprintf("%s-%x-%s", evidence.Time.Format("20060102T150405Z"), evidence.LogID[:], evidence.Type)
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(evidenceBucket), []byte(id), evidence)
+	})
+	if err != nil {
+		return "", err
+	}
+	return "evidence " + id, nil
+}
+
+func (store *Store) QueueNotification(notification *certspotter.PendingNotification) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(notificationsBucket), []byte(notification.ID), notification)
+	})
+}
+
+func (store *Store) GetNotifications() ([]*certspotter.PendingNotification, error) {
+	notifications := []*certspotter.PendingNotification{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(notificationsBucket).ForEach(func(key []byte, value []byte) error {
+			notification := new(certspotter.PendingNotification)
+			if err := json.Unmarshal(value, notification); err != nil {
+				return fmt.Errorf("Notification %s: %s", key, err)
+			}
+			notifications = append(notifications, notification)
+			return nil
+		})
+	})
+	return notifications, err
+}
+
+func (store *Store) RemoveNotification(notification *certspotter.PendingNotification) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(notificationsBucket).Delete([]byte(notification.ID))
+	})
+}
+
+func (store *Store) WatchCert(watched *certspotter.WatchedCert) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(watchedCertsBucket), []byte(watched.Fingerprint), watched)
+	})
+}
+
+func (store *Store) GetWatchedCerts() ([]*certspotter.WatchedCert, error) {
+	watchedCerts := []*certspotter.WatchedCert{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(watchedCertsBucket).ForEach(func(key []byte, value []byte) error {
+			watched := new(certspotter.WatchedCert)
+			if err := json.Unmarshal(value, watched); err != nil {
+				return fmt.Errorf("Watched certificate %s: %s", key, err)
+			}
+			watchedCerts = append(watchedCerts, watched)
+			return nil
+		})
+	})
+	return watchedCerts, err
+}
+
+func (store *Store) UnwatchCert(fingerprint string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(watchedCertsBucket).Delete([]byte(fingerprint))
+	})
+}
+
+func (store *Store) OpenLogState(logInfo *certspotter.LogInfo) (certspotter.LogStore, error) {
+	logStore := &LogStore{store: store, logId: logInfo.ID()}
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		logB, err := tx.Bucket(logsBucket).CreateBucketIfNotExists(logStore.logId)
+		if err != nil {
+			return err
+		}
+		if err := logB.Put([]byte("uri"), []byte(logInfo.FullURI())); err != nil {
+			return err
+		}
+		if _, err := logB.CreateBucketIfNotExists(unverifiedSTHsBucket); err != nil {
+			return err
+		}
+		if _, err := logB.CreateBucketIfNotExists(pendingSCTsBucket); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logStore, nil
+}
@@ -0,0 +1,493 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package boltstore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/internal/certtest"
+)
+
+func openTestStore(t *testing.T, path string) *Store {
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func makeCert(t *testing.T, serial int64, dnsNames ...string) []byte {
+	return certtest.Cert(t, func(template *x509.Certificate) {
+		template.SerialNumber = big.NewInt(serial)
+		template.DNSNames = dnsNames
+	})
+}
+
+func fingerprints(records []*certspotter.CertRecord) string {
+	var fps []string
+	for _, record := range records {
+		fps = append(fps, record.Fingerprint)
+	}
+	return strings.Join(fps, ",")
+}
+
+func TestCerts(t *testing.T) {
+	store := openTestStore(t, filepath.Join(t.TempDir(), "certspotter.db"))
+
+	wwwCert := makeCert(t, 1, "www.example.com", "Mail.Example.com")
+	alreadyPresent, wwwFingerprint, err := store.SaveCert(false, [][]byte{wwwCert})
+	if err != nil || alreadyPresent {
+		t.Fatalf("First SaveCert returned %v, %v", alreadyPresent, err)
+	}
+	if alreadyPresent, fingerprint, err := store.SaveCert(true, [][]byte{wwwCert}); err != nil || !alreadyPresent || fingerprint != wwwFingerprint {
+		t.Errorf("Second SaveCert returned %v, %q, %v", alreadyPresent, fingerprint, err)
+	}
+	_, orgFingerprint, err := store.SaveCert(false, [][]byte{makeCert(t, 2, "example.org")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.SaveCert(false, nil); err == nil {
+		t.Error("SaveCert of an empty chain succeeded")
+	}
+
+	record, err := store.GetCert(strings.ToUpper(wwwFingerprint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil {
+		t.Fatal("GetCert returned nil for a saved certificate")
+	}
+	if record.IsPrecert || !bytes.Equal(record.Chain[0], wwwCert) || record.Serial != "1" || strings.Join(record.DNSNames, ",") != "www.example.com,mail.example.com" {
+		t.Errorf("GetCert returned %+v", record)
+	}
+	if record, err := store.GetCert(strings.Repeat("00", 32)); err != nil || record != nil {
+		t.Errorf("GetCert of an unknown fingerprint returned %v, %v", record, err)
+	}
+
+	tests := []struct {
+		domain            string
+		includeSubdomains bool
+		want              string
+	}{
+		{"www.example.com", false, wwwFingerprint},
+		{"MAIL.example.com", false, wwwFingerprint},
+		{"example.com", false, ""},
+		{"example.com", true, wwwFingerprint},
+		{"ample.com", true, ""},
+		{"org", true, orgFingerprint},
+		{"example.org", true, orgFingerprint},
+	}
+	for _, test := range tests {
+		records, err := store.FindCertsByDomain(test.domain, test.includeSubdomains)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fingerprints(records); got != test.want {
+			t.Errorf("FindCertsByDomain(%q, %v) returned %q, expected %q", test.domain, test.includeSubdomains, got, test.want)
+		}
+	}
+}
+
+func TestSearchCerts(t *testing.T) {
+	store := openTestStore(t, filepath.Join(t.TempDir(), "certspotter.db"))
+	var all []string
+	for i := int64(1); i <= 5; i++ {
+		_, fingerprint, err := store.SaveCert(false, [][]byte{makeCert(t, i, strconv.FormatInt(i, 10)+".example.com")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, fingerprint)
+	}
+
+	records, err := store.SearchCerts(&certspotter.CertQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(all) {
+		t.Fatalf("SearchCerts returned %d certificates, expected %d", len(records), len(all))
+	}
+	ordered := fingerprints(records)
+
+	// Pages must partition the full result, in the same order
+	var paged []string
+	for offset := 0; offset < len(all); offset += 2 {
+		page, err := store.SearchCerts(&certspotter.CertQuery{Offset: offset, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			t.Fatalf("SearchCerts with offset %d returned nothing", offset)
+		}
+		paged = append(paged, fingerprints(page))
+	}
+	if got := strings.Join(paged, ","); got != ordered {
+		t.Errorf("Pages returned %q, expected %q", got, ordered)
+	}
+	if page, err := store.SearchCerts(&certspotter.CertQuery{Offset: len(all)}); err != nil || len(page) != 0 {
+		t.Errorf("SearchCerts past the end returned %d certificates, %v", len(page), err)
+	}
+
+	if records, err := store.SearchCerts(&certspotter.CertQuery{Fingerprint: all[2]}); err != nil || fingerprints(records) != all[2] {
+		t.Errorf("SearchCerts by fingerprint returned %q, %v", fingerprints(records), err)
+	}
+	if records, err := store.SearchCerts(&certspotter.CertQuery{Domain: "4.example.com"}); err != nil || fingerprints(records) != all[3] {
+		t.Errorf("SearchCerts by domain returned %q, %v", fingerprints(records), err)
+	}
+	if records, err := store.SearchCerts(&certspotter.CertQuery{Issuer: "test ca", Limit: 3}); err != nil || len(records) != 3 {
+		t.Errorf("SearchCerts by issuer returned %d certificates, %v", len(records), err)
+	}
+	after := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	if records, err := store.SearchCerts(&certspotter.CertQuery{IssuedAfter: &after}); err != nil || len(records) != 0 {
+		t.Errorf("SearchCerts by notBefore returned %d certificates, %v", len(records), err)
+	}
+}
+
+func TestIssuancesAndSerials(t *testing.T) {
+	store := openTestStore(t, filepath.Join(t.TempDir(), "certspotter.db"))
+	key := bytes.Repeat([]byte{0xab}, 32)
+	issuance := bytes.Repeat([]byte{1}, 32)
+
+	if first, err := store.SaveIssuance(issuance, "precert"); err != nil || first != "" {
+		t.Fatalf("First SaveIssuance returned %q, %v", first, err)
+	}
+	if first, err := store.SaveIssuance(issuance, "precert"); err != nil || first != "" {
+		t.Errorf("SaveIssuance of the same certificate returned %q, %v", first, err)
+	}
+	if first, err := store.SaveIssuance(issuance, "cert"); err != nil || first != "precert" {
+		t.Errorf("SaveIssuance of the paired certificate returned %q, %v, expected the precertificate", first, err)
+	}
+
+	if other, err := store.SaveSerial(key, issuance, "first"); err != nil || other != "" {
+		t.Fatalf("First SaveSerial returned %q, %v", other, err)
+	}
+	if other, err := store.SaveSerial(key, issuance, "second"); err != nil || other != "" {
+		t.Errorf("SaveSerial of the same issuance returned %q, %v", other, err)
+	}
+	if other, err := store.SaveSerial(key, bytes.Repeat([]byte{2}, 32), "third"); err != nil || other != "first" {
+		t.Errorf("SaveSerial of a different issuance returned %q, %v, expected the first fingerprint", other, err)
+	}
+
+	if notified, err := store.HasNotified("email", "abcd"); err != nil || notified {
+		t.Errorf("HasNotified before SaveNotified returned %v, %v", notified, err)
+	}
+	if err := store.SaveNotified("email", "abcd"); err != nil {
+		t.Fatal(err)
+	}
+	if notified, err := store.HasNotified("email", "abcd"); err != nil || !notified {
+		t.Errorf("HasNotified after SaveNotified returned %v, %v", notified, err)
+	}
+	if notified, err := store.HasNotified("script", "abcd"); err != nil || notified {
+		t.Errorf("HasNotified for another channel returned %v, %v", notified, err)
+	}
+}
+
+func TestNotificationQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certspotter.db")
+	store := openTestStore(t, path)
+	info := certtest.EntryInfo(t, []string{"www.example.com"}, nil)
+	email := certspotter.NewPendingNotification("email", info)
+	script := certspotter.NewPendingNotification("script", info)
+	for _, notification := range []*certspotter.PendingNotification{email, script} {
+		if err := store.QueueNotification(notification); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Requeueing updates the notification rather than adding another
+	email.Attempts = 3
+	if err := store.QueueNotification(email); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveNotification(script); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = openTestStore(t, path)
+	notifications, err := store.GetNotifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 1 || notifications[0].ID != email.ID || notifications[0].Attempts != 3 {
+		t.Fatalf("GetNotifications returned %+v, expected only the email notification", notifications)
+	}
+	restored, err := notifications[0].EntryInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Fingerprint() != info.Fingerprint() || restored.LogUri != info.LogUri || restored.Entry.Index != info.Entry.Index {
+		t.Errorf("Queued notification is for %s entry %d, expected %s entry %d", restored.LogUri, restored.Entry.Index, info.LogUri, info.Entry.Index)
+	}
+}
+
+func TestEvidence(t *testing.T) {
+	store := openTestStore(t, filepath.Join(t.TempDir(), "certspotter.db"))
+	evidence := &certspotter.Evidence{
+		Type:        "inconsistent_sths",
+		Description: "test",
+		Time:        time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		LogURI:      "https://ct.example.com/",
+		LogID:       ct.SHA256Hash{0xab},
+	}
+	id, err := store.StoreEvidence(evidence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "20240102T030405Z-ab" + strings.Repeat("00", 31) + "-inconsistent_sths"
+	if id != "evidence "+key {
+		t.Errorf("StoreEvidence returned %q", id)
+	}
+	err = store.DB().View(func(tx *bolt.Tx) error {
+		var stored certspotter.Evidence
+		if err := json.Unmarshal(tx.Bucket(evidenceBucket).Get([]byte(key)), &stored); err != nil {
+			return err
+		}
+		if stored.Description != evidence.Description || stored.LogURI != evidence.LogURI {
+			t.Errorf("Stored evidence is %+v", stored)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchedCerts(t *testing.T) {
+	store := openTestStore(t, filepath.Join(t.TempDir(), "certspotter.db"))
+	notAfter := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	for _, fingerprint := range []string{"aa", "bb"} {
+		if err := store.WatchCert(&certspotter.WatchedCert{Fingerprint: fingerprint, NotAfter: notAfter}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.UnwatchCert("aa"); err != nil {
+		t.Fatal(err)
+	}
+	watched, err := store.GetWatchedCerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watched) != 1 || watched[0].Fingerprint != "bb" || !watched[0].NotAfter.Equal(notAfter) {
+		t.Errorf("GetWatchedCerts returned %+v", watched)
+	}
+}
+
+func TestLogState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certspotter.db")
+	logInfo := &certspotter.LogInfo{Key: []byte("test log key"), Url: "ct.example.com/"}
+	store := openTestStore(t, path)
+	logStore, err := store.OpenLogState(logInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sth, err := logStore.GetVerifiedSTH(); err != nil || sth != nil {
+		t.Errorf("GetVerifiedSTH of a new log returned %v, %v", sth, err)
+	}
+	if tree, err := logStore.GetTree(); err != nil || tree != nil {
+		t.Errorf("GetTree of a new log returned %v, %v", tree, err)
+	}
+
+	verified := &ct.SignedTreeHead{TreeSize: 3, Timestamp: 1000, SHA256RootHash: ct.SHA256Hash{1}}
+	if err := logStore.StoreVerifiedSTH(verified); err != nil {
+		t.Fatal(err)
+	}
+	tree := certspotter.EmptyCollapsedMerkleTree()
+	for i := byte(0); i < 3; i++ {
+		tree.Add(ct.MerkleTreeNode(bytes.Repeat([]byte{i}, 32)))
+	}
+	if err := logStore.StoreTree(tree); err != nil {
+		t.Fatal(err)
+	}
+	// Stored out of order to check that they're returned by tree size
+	sths := []*ct.SignedTreeHead{
+		{TreeSize: 300, Timestamp: 3000, SHA256RootHash: ct.SHA256Hash{3}},
+		{TreeSize: 5, Timestamp: 2000, SHA256RootHash: ct.SHA256Hash{2}},
+		{TreeSize: 20, Timestamp: 2500, SHA256RootHash: ct.SHA256Hash{4}},
+	}
+	for _, sth := range sths {
+		if err := logStore.StoreUnverifiedSTH(sth); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := logStore.RemoveUnverifiedSTH(sths[2]); err != nil {
+		t.Fatal(err)
+	}
+	pendings := []*certspotter.PendingSCT{
+		{LeafHash: bytes.Repeat([]byte{1}, 32), Fingerprint: "one"},
+		{LeafHash: bytes.Repeat([]byte{2}, 32), Fingerprint: "two"},
+	}
+	for _, pending := range pendings {
+		if err := logStore.StorePendingSCT(pending); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := logStore.RemovePendingSCT(pendings[0]); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = openTestStore(t, path)
+	if logStore, err = store.OpenLogState(logInfo); err != nil {
+		t.Fatal(err)
+	}
+	if sth, err := logStore.GetVerifiedSTH(); err != nil {
+		t.Fatal(err)
+	} else if sth == nil || sth.TreeSize != verified.TreeSize || sth.Timestamp != verified.Timestamp || sth.SHA256RootHash != verified.SHA256RootHash {
+		t.Errorf("GetVerifiedSTH returned %+v, expected %+v", sth, verified)
+	}
+	if stored, err := logStore.GetTree(); err != nil {
+		t.Fatal(err)
+	} else if stored == nil || stored.GetSize() != 3 || !bytes.Equal(stored.CalculateRoot(), tree.CalculateRoot()) {
+		t.Errorf("GetTree returned %+v, expected a tree of size 3 with root %x", stored, tree.CalculateRoot())
+	}
+	if unverified, err := logStore.GetUnverifiedSTHs(); err != nil {
+		t.Fatal(err)
+	} else if len(unverified) != 2 || unverified[0].TreeSize != 5 || unverified[1].TreeSize != 300 {
+		t.Errorf("GetUnverifiedSTHs returned %+v, expected tree sizes 5 and 300", unverified)
+	}
+	if stored, err := logStore.GetPendingSCTs(); err != nil {
+		t.Fatal(err)
+	} else if len(stored) != 1 || stored[0].Fingerprint != "two" {
+		t.Errorf("GetPendingSCTs returned %+v, expected only the second SCT", stored)
+	}
+
+	// Each log has its own state
+	other, err := store.OpenLogState(&certspotter.LogInfo{Key: []byte("other log key"), Url: "other.example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sth, err := other.GetVerifiedSTH(); err != nil || sth != nil {
+		t.Errorf("GetVerifiedSTH of another log returned %v, %v", sth, err)
+	}
+}
+
+func TestFirstRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certspotter.db")
+	store := openTestStore(t, path)
+	if !store.IsFirstRun() {
+		t.Error("New database is not in its first run")
+	}
+	if err := store.WriteOnceFile(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	if store := openTestStore(t, path); store.IsFirstRun() {
+		t.Error("Database is still in its first run after WriteOnceFile")
+	}
+}
+
+func TestMigrateV1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certspotter.db")
+	record := certspotter.MakeCertRecord(false, [][]byte{makeCert(t, 1, "www.example.com")})
+
+	// The layout of databases created before versioning was introduced
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{logsBucket, certsBucket, domainsBucket, evidenceBucket, notificationsBucket} {
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		if err := putJSON(tx.Bucket(certsBucket), []byte(record.Fingerprint), record); err != nil {
+			return err
+		}
+		return tx.Bucket(domainsBucket).Put(domainKey("www.example.com", record.Fingerprint), []byte(record.FirstSeen.Format(time.RFC3339)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	store := openTestStore(t, path)
+	err = store.DB().View(func(tx *bolt.Tx) error {
+		if version := string(tx.Bucket(metaBucket).Get([]byte("version"))); version != strconv.Itoa(len(migrations)) {
+			t.Errorf("Database has version %q after migrating, expected %d", version, len(migrations))
+		}
+		for _, name := range [][]byte{issuancesBucket, notifiedBucket, watchedCertsBucket, serialsBucket} {
+			if tx.Bucket(name) == nil {
+				t.Errorf("Bucket %s was not created", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records, err := store.FindCertsByDomain("example.com", true); err != nil || fingerprints(records) != record.Fingerprint {
+		t.Errorf("Certificate saved before migrating was not found: %q, %v", fingerprints(records), err)
+	}
+	if first, err := store.SaveIssuance([]byte("issuance"), record.Fingerprint); err != nil || first != "" {
+		t.Errorf("SaveIssuance after migrating returned %q, %v", first, err)
+	}
+	store.Close()
+
+	// Reopening an up-to-date database changes nothing
+	store = openTestStore(t, path)
+	if records, err := store.SearchCerts(&certspotter.CertQuery{}); err != nil || len(records) != 1 {
+		t.Errorf("SearchCerts after reopening returned %d certificates, %v", len(records), err)
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certspotter.db")
+	openTestStore(t, path).Close()
+
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put([]byte("version"), []byte(strconv.Itoa(len(migrations)+1)))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store, err := Open(path); err == nil {
+		store.Close()
+		t.Fatal("Open of a database from a newer version succeeded")
+	} else if !strings.Contains(err.Error(), "only supports up to version") {
+		t.Errorf("Open of a database from a newer version failed with %q", err)
+	}
+}
+
+func TestOpenInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certspotter.db")
+	store := openTestStore(t, path)
+
+	if other, err := Open(path); err == nil {
+		other.Close()
+		t.Fatal("Second Open of a database in use succeeded")
+	} else if !strings.Contains(err.Error(), "in use by another instance") {
+		t.Errorf("Second Open failed with %q", err)
+	}
+
+	// Once the first instance exits, another can take over the database
+	store.Close()
+	openTestStore(t, path)
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package boltstore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+var (
+	unverifiedSTHsBucket = []byte("unverified_sths")
+	pendingSCTsBucket    = []byte("pending_scts")
+)
+
+type LogStore struct {
+	store *Store
+	logId []byte
+}
+
+// generate a key that uniquely identifies the STH (within the context of a
+// particular log).  Keys begin with the big-endian tree size so that STHs
+// are iterated in order of tree size.
+func sthKey(sth *ct.SignedTreeHead) []byte {
+	hasher := sha256.New()
+	binary.Write(hasher, binary.LittleEndian, sth.Timestamp)
+	binary.Write(hasher, binary.LittleEndian, sth.SHA256RootHash)
+	key := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(key, sth.TreeSize)
+	return hasher.Sum(key)
+}
+
+func (logStore *LogStore) view(fn func(*bolt.Bucket) error) error {
+	return logStore.store.db.View(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(logsBucket).Bucket(logStore.logId))
+	})
+}
+
+func (logStore *LogStore) update(fn func(*bolt.Bucket) error) error {
+	return logStore.store.db.Update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(logsBucket).Bucket(logStore.logId))
+	})
+}
+
+func (logStore *LogStore) getJSON(key string, obj interface{}) (bool, error) {
+	present := false
+	err := logStore.view(func(logB *bolt.Bucket) error {
+		value := logB.Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		present = true
+		return json.Unmarshal(value, obj)
+	})
+	return present, err
+}
+
+func (logStore *LogStore) setJSON(key string, obj interface{}) error {
+	return logStore.update(func(logB *bolt.Bucket) error {
+		return putJSON(logB, []byte(key), obj)
+	})
+}
+
+func (logStore *LogStore) GetVerifiedSTH() (*ct.SignedTreeHead, error) {
+	sth := new(ct.SignedTreeHead)
+	if present, err := logStore.getJSON("verified_sth", sth); err != nil || !present {
+		return nil, err
+	}
+	return sth, nil
+}
+
+func (logStore *LogStore) StoreVerifiedSTH(sth *ct.SignedTreeHead) error {
+	return logStore.setJSON("verified_sth", sth)
+}
+
+func (logStore *LogStore) GetTree() (*certspotter.CollapsedMerkleTree, error) {
+	tree := new(certspotter.CollapsedMerkleTree)
+	if present, err := logStore.getJSON("tree", tree); err != nil || !present {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func (logStore *LogStore) StoreTree(tree *certspotter.CollapsedMerkleTree) error {
+	return logStore.setJSON("tree", tree)
+}
+
+func (logStore *LogStore) GetUnverifiedSTHs() ([]*ct.SignedTreeHead, error) {
+	sths := []*ct.SignedTreeHead{}
+	err := logStore.view(func(logB *bolt.Bucket) error {
+		return logB.Bucket(unverifiedSTHsBucket).ForEach(func(key []byte, value []byte) error {
+			sth := new(ct.SignedTreeHead)
+			if err := json.Unmarshal(value, sth); err != nil {
+				return err
+			}
+			sths = append(sths, sth)
+			return nil
+		})
+	})
+	return sths, err
+}
+
+func (logStore *LogStore) StoreUnverifiedSTH(sth *ct.SignedTreeHead) error {
+	return logStore.update(func(logB *bolt.Bucket) error {
+		return putJSON(logB.Bucket(unverifiedSTHsBucket), sthKey(sth), sth)
+	})
+}
+
+func (logStore *LogStore) RemoveUnverifiedSTH(sth *ct.SignedTreeHead) error {
+	return logStore.update(func(logB *bolt.Bucket) error {
+		return logB.Bucket(unverifiedSTHsBucket).Delete(sthKey(sth))
+	})
+}
+
+func (logStore *LogStore) GetPendingSCTs() ([]*certspotter.PendingSCT, error) {
+	pendings := []*certspotter.PendingSCT{}
+	err := logStore.view(func(logB *bolt.Bucket) error {
+		return logB.Bucket(pendingSCTsBucket).ForEach(func(key []byte, value []byte) error {
+			pending := new(certspotter.PendingSCT)
+			if err := json.Unmarshal(value, pending); err != nil {
+				return err
+			}
+			pendings = append(pendings, pending)
+			return nil
+		})
+	})
+	return pendings, err
+}
+
+func (logStore *LogStore) StorePendingSCT(pending *certspotter.PendingSCT) error {
+	return logStore.update(func(logB *bolt.Bucket) error {
+		return putJSON(logB.Bucket(pendingSCTsBucket), pending.LeafHash, pending)
+	})
+}
+
+func (logStore *LogStore) RemovePendingSCT(pending *certspotter.PendingSCT) error {
+	return logStore.update(func(logB *bolt.Bucket) error {
+		return logB.Bucket(pendingSCTsBucket).Delete(pending.LeafHash)
+	})
+}
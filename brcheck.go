@@ -0,0 +1,183 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"time"
+)
+
+var (
+	oidExtensionExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+	oidExtKeyUsageAny             = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+	oidExtKeyUsageServerAuth      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+	oidExtKeyUsageClientAuth      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}
+	oidExtKeyUsageCodeSigning     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}
+	oidExtKeyUsageEmailProtection = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}
+	oidExtKeyUsageTimeStamping    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+	oidExtKeyUsageOCSPSigning     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 9}
+)
+
+var extKeyUsageNames = []struct {
+	oid  asn1.ObjectIdentifier
+	name string
+}{
+	{oidExtKeyUsageAny, "anyExtendedKeyUsage"},
+	{oidExtKeyUsageServerAuth, "serverAuth"},
+	{oidExtKeyUsageClientAuth, "clientAuth"},
+	{oidExtKeyUsageCodeSigning, "codeSigning"},
+	{oidExtKeyUsageEmailProtection, "emailProtection"},
+	{oidExtKeyUsageTimeStamping, "timeStamping"},
+	{oidExtKeyUsageOCSPSigning, "OCSPSigning"},
+}
+
+// Signature algorithms permitted by section 7.1.3.2 of the Baseline
+// Requirements
+var permittedSignatureAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	name string
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, "sha256WithRSAEncryption"},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, "sha384WithRSAEncryption"},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, "sha512WithRSAEncryption"},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}, "RSASSA-PSS"},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, "ecdsa-with-SHA256"},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, "ecdsa-with-SHA384"},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, "ecdsa-with-SHA512"},
+}
+
+var forbiddenSignatureAlgorithmNames = []struct {
+	oid  asn1.ObjectIdentifier
+	name string
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 2}, "md2WithRSAEncryption"},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 4}, "md5WithRSAEncryption"},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, "sha1WithRSAEncryption"},
+	{asn1.ObjectIdentifier{1, 3, 14, 3, 2, 29}, "sha1WithRSASignature"},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 14}, "sha224WithRSAEncryption"},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, "ecdsa-with-SHA1"},
+	{asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 3}, "dsa-with-sha1"},
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+func extKeyUsageName(oid asn1.ObjectIdentifier) string {
+	for _, usage := range extKeyUsageNames {
+		if usage.oid.Equal(oid) {
+			return usage.name
+		}
+	}
+	return oid.String()
+}
+
+// ParseExtKeyUsage returns the OIDs in the certificate's Extended Key Usage
+// extension(s)
+func (tbs *TBSCertificate) ParseExtKeyUsage() ([]asn1.ObjectIdentifier, error) {
+	var usages []asn1.ObjectIdentifier
+	for _, ext := range tbs.GetExtension(oidExtensionExtKeyUsage) {
+		var extUsages []asn1.ObjectIdentifier
+		if rest, err := asn1.Unmarshal(ext.Value, &extUsages); err != nil {
+			return nil, fmt.Errorf("failed to parse Extended Key Usage: %s", err)
+		} else if len(rest) > 0 {
+			return nil, fmt.Errorf("trailing data after Extended Key Usage: %v", rest)
+		}
+		usages = append(usages, extUsages...)
+	}
+	return usages, nil
+}
+
+// ParseSignatureAlgorithm returns the OID of the algorithm with which the
+// certificate is signed
+func (tbs *TBSCertificate) ParseSignatureAlgorithm() (asn1.ObjectIdentifier, error) {
+	var algorithm algorithmIdentifier
+	if rest, err := asn1.Unmarshal(tbs.SignatureAlgorithm.FullBytes, &algorithm); err != nil {
+		return nil, fmt.Errorf("failed to parse signature algorithm: %s", err)
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing data after signature algorithm: %v", rest)
+	}
+	return algorithm.Algorithm, nil
+}
+
+// BRViolations returns a description of each way in which |cert| violates
+// the CA/Browser Forum Baseline Requirements for TLS server certificates.
+// Only the signature algorithm is checked for CA certificates, since the
+// rest of the checks are for subscriber certificates.
+func BRViolations(cert *CertInfo) []string {
+	var violations []string
+
+	if algorithm, err := cert.TBS.ParseSignatureAlgorithm(); err != nil {
+		violations = append(violations, err.Error())
+	} else if !signatureAlgorithmPermitted(algorithm) {
+		violations = append(violations, "signature algorithm "+signatureAlgorithmName(algorithm)+" is not permitted")
+	}
+
+	if cert.IsCAParseError != nil {
+		return append(violations, cert.IsCAParseError.Error())
+	} else if cert.IsCA != nil && *cert.IsCA {
+		return violations
+	}
+
+	if cert.ValidityParseError == nil {
+		// RFC 5280 validity periods include both the notBefore and notAfter seconds
+		period := cert.Validity.NotAfter.Sub(cert.Validity.NotBefore) + time.Second
+		if maxValidity := BRMaxValidity(cert.Validity.NotBefore); period > maxValidity {
+			violations = append(violations, "validity period of "+formatDays(period)+" exceeds "+formatDays(maxValidity))
+		}
+	}
+
+	if len(cert.TBS.GetExtension(oidExtensionSubjectAltName)) == 0 {
+		violations = append(violations, "subjectAltName extension is missing")
+	} else if cert.SANsParseError == nil && len(cert.SANs) == 0 {
+		violations = append(violations, "subjectAltName extension is empty")
+	}
+
+	if usages, err := cert.TBS.ParseExtKeyUsage(); err != nil {
+		violations = append(violations, err.Error())
+	} else if len(cert.TBS.GetExtension(oidExtensionExtKeyUsage)) == 0 {
+		violations = append(violations, "extKeyUsage extension is missing")
+	} else {
+		hasServerAuth := false
+		for _, usage := range usages {
+			if usage.Equal(oidExtKeyUsageServerAuth) {
+				hasServerAuth = true
+			} else if !usage.Equal(oidExtKeyUsageClientAuth) {
+				violations = append(violations, "extended key usage "+extKeyUsageName(usage)+" is not permitted")
+			}
+		}
+		if !hasServerAuth {
+			violations = append(violations, "extended key usage serverAuth is missing")
+		}
+	}
+
+	return violations
+}
+
+func signatureAlgorithmPermitted(oid asn1.ObjectIdentifier) bool {
+	for _, algorithm := range permittedSignatureAlgorithms {
+		if algorithm.oid.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+func signatureAlgorithmName(oid asn1.ObjectIdentifier) string {
+	for _, algorithm := range forbiddenSignatureAlgorithmNames {
+		if algorithm.oid.Equal(oid) {
+			return algorithm.name
+		}
+	}
+	return oid.String()
+}
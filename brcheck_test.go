@@ -0,0 +1,119 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBRViolations(t *testing.T) {
+	tests := []struct {
+		name       string
+		modify     func(*x509.Certificate)
+		violations []string
+	}{
+		{"compliant", nil, nil},
+		{"long validity", func(c *x509.Certificate) {
+			c.NotAfter = c.NotBefore.AddDate(2, 0, 0)
+		}, []string{"validity period of 731.0 days exceeds 398.0 days"}},
+		{"long validity before 2020-09-01", func(c *x509.Certificate) {
+			c.NotBefore = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+			c.NotAfter = c.NotBefore.AddDate(2, 0, 0)
+		}, nil},
+		{"too long validity before 2020-09-01", func(c *x509.Certificate) {
+			c.NotBefore = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+			c.NotAfter = c.NotBefore.AddDate(3, 0, 0)
+		}, []string{"validity period of 1096.0 days exceeds 825.0 days"}},
+		{"no SAN", func(c *x509.Certificate) {
+			c.DNSNames = nil
+		}, []string{"subjectAltName extension is missing"}},
+		{"no EKU", func(c *x509.Certificate) {
+			c.ExtKeyUsage = nil
+		}, []string{"extKeyUsage extension is missing"}},
+		{"wrong EKUs", func(c *x509.Certificate) {
+			c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageAny}
+		}, []string{
+			"extended key usage codeSigning is not permitted",
+			"extended key usage anyExtendedKeyUsage is not permitted",
+			"extended key usage serverAuth is missing",
+		}},
+		{"CA", func(c *x509.Certificate) {
+			c.IsCA = true
+			c.DNSNames = nil
+			c.ExtKeyUsage = nil
+			c.NotAfter = c.NotBefore.AddDate(10, 0, 0)
+		}, nil},
+	}
+	for _, test := range tests {
+		violations := BRViolations(makeTestCertInfo(t, nil, nil, test.modify))
+		if strings.Join(violations, "\n") != strings.Join(test.violations, "\n") {
+			t.Errorf("%s: wrong violations: %q", test.name, violations)
+		}
+	}
+}
+
+func TestBRViolationsValidity(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	for _, test := range []struct {
+		notBefore time.Time
+		days      int
+		violation string
+	}{
+		{date(2020, time.September, 1).Add(-time.Second), 825, ""},
+		{date(2020, time.September, 1), 825, "validity period of 825.0 days exceeds 398.0 days"},
+		{date(2020, time.September, 1), 398, ""},
+		{date(2026, time.March, 15).Add(-time.Second), 398, ""},
+		{date(2026, time.March, 15), 398, "validity period of 398.0 days exceeds 200.0 days"},
+		{date(2026, time.March, 15), 200, ""},
+		{date(2027, time.March, 15).Add(-time.Second), 200, ""},
+		{date(2027, time.March, 15), 200, "validity period of 200.0 days exceeds 100.0 days"},
+		{date(2027, time.March, 15), 100, ""},
+		{date(2029, time.March, 15).Add(-time.Second), 100, ""},
+		{date(2029, time.March, 15), 100, "validity period of 100.0 days exceeds 47.0 days"},
+		{date(2029, time.March, 15), 47, ""},
+	} {
+		info := makeTestCertInfo(t, nil, nil, func(c *x509.Certificate) {
+			c.NotBefore = test.notBefore
+			c.NotAfter = test.notBefore.Add(time.Duration(test.days)*24*time.Hour - time.Second)
+		})
+		violations := BRViolations(info)
+		if strings.Join(violations, "\n") != test.violation {
+			t.Errorf("%s valid for %d days: wrong violations: %q", test.notBefore, test.days, violations)
+		}
+	}
+}
+
+func TestBRViolationsSignatureAlgorithm(t *testing.T) {
+	for _, test := range []struct {
+		oid       asn1.ObjectIdentifier
+		violation string
+	}{
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, "signature algorithm sha1WithRSAEncryption is not permitted"},
+		{asn1.ObjectIdentifier{1, 3, 101, 112}, "signature algorithm 1.3.101.112 is not permitted"},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, ""},
+	} {
+		info := makeTestCertInfo(t, nil, nil, nil)
+		algorithm, err := asn1.Marshal(algorithmIdentifier{Algorithm: test.oid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		info.TBS.SignatureAlgorithm.FullBytes = algorithm
+		violations := BRViolations(info)
+		if strings.Join(violations, "\n") != test.violation {
+			t.Errorf("%s: wrong violations: %q", test.oid, violations)
+		}
+	}
+}
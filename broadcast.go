@@ -0,0 +1,103 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A Subscription receives the entries written to a Broadcaster on C, which
+// is closed when the Broadcaster is closed
+type Subscription struct {
+	C       <-chan *EntryInfo
+	c       chan *EntryInfo
+	dropped uint64
+}
+
+// Dropped returns the number of entries which were dropped because the
+// subscriber's buffer was full
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// Broadcaster is a Sink which passes entries on to any number of
+// subscribers, such as clients of an API which streams matches.  Each
+// subscriber has a buffer of BufferSize entries; if it falls behind and its
+// buffer fills up, entries are dropped for it rather than holding up the
+// scan.
+type Broadcaster struct {
+	BufferSize int
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{BufferSize: 1000, subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns a new Subscription, which receives entries written from
+// now on
+func (broadcaster *Broadcaster) Subscribe() *Subscription {
+	c := make(chan *EntryInfo, broadcaster.BufferSize)
+	sub := &Subscription{C: c, c: c}
+	broadcaster.mu.Lock()
+	defer broadcaster.mu.Unlock()
+	if broadcaster.closed {
+		close(c)
+	} else {
+		broadcaster.subs[sub] = struct{}{}
+	}
+	return sub
+}
+
+// Unsubscribe stops |sub| from receiving entries, and closes its channel
+func (broadcaster *Broadcaster) Unsubscribe(sub *Subscription) {
+	broadcaster.mu.Lock()
+	defer broadcaster.mu.Unlock()
+	if _, subscribed := broadcaster.subs[sub]; subscribed {
+		delete(broadcaster.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Subscribers returns the number of current subscriptions
+func (broadcaster *Broadcaster) Subscribers() int {
+	broadcaster.mu.Lock()
+	defer broadcaster.mu.Unlock()
+	return len(broadcaster.subs)
+}
+
+func (broadcaster *Broadcaster) Write(info *EntryInfo) error {
+	broadcaster.mu.Lock()
+	defer broadcaster.mu.Unlock()
+	for sub := range broadcaster.subs {
+		select {
+		case sub.c <- info:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+	return nil
+}
+
+// Close closes every subscription's channel
+func (broadcaster *Broadcaster) Close() error {
+	broadcaster.mu.Lock()
+	defer broadcaster.mu.Unlock()
+	for sub := range broadcaster.subs {
+		close(sub.c)
+	}
+	broadcaster.subs = nil
+	broadcaster.closed = true
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"testing"
+)
+
+func TestBroadcaster(t *testing.T) {
+	broadcaster := NewBroadcaster()
+	broadcaster.BufferSize = 2
+	first := broadcaster.Subscribe()
+	second := broadcaster.Subscribe()
+
+	entries := []*EntryInfo{{LogUri: "a"}, {LogUri: "b"}, {LogUri: "c"}}
+	for _, info := range entries {
+		broadcaster.Write(info)
+	}
+	for _, sub := range []*Subscription{first, second} {
+		if info := <-sub.C; info != entries[0] {
+			t.Errorf("Wrong first entry: %v", info)
+		}
+		if info := <-sub.C; info != entries[1] {
+			t.Errorf("Wrong second entry: %v", info)
+		}
+		if sub.Dropped() != 1 {
+			t.Errorf("Dropped %d entries instead of 1", sub.Dropped())
+		}
+	}
+
+	broadcaster.Unsubscribe(first)
+	if _, ok := <-first.C; ok {
+		t.Errorf("Channel still open after unsubscribing")
+	}
+	if n := broadcaster.Subscribers(); n != 1 {
+		t.Errorf("%d subscribers instead of 1", n)
+	}
+	broadcaster.Close()
+	if _, ok := <-second.C; ok {
+		t.Errorf("Channel still open after closing")
+	}
+	broadcaster.Unsubscribe(second)
+	if _, ok := <-broadcaster.Subscribe().C; ok {
+		t.Errorf("Subscribed after closing")
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MatchCAA = "caa"
+
+// A CAARecord is a DNS CAA resource record (RFC 8659)
+type CAARecord struct {
+	Flags uint8
+	Tag   string
+	Value string
+}
+
+func (record CAARecord) String() string {
+	return fmt.Sprintf("%d %s %q", record.Flags, record.Tag, record.Value)
+}
+
+// Critical returns true if the record's issuer critical flag is set
+func (record CAARecord) Critical() bool {
+	return record.Flags&128 != 0
+}
+
+// CAAAuthorizes returns true if the CAA RRset |records| permits a CA which
+// recognizes the issuer domain names |caaDomains| to issue a certificate for
+// a name (a wildcard name if |wildcard| is true).  An empty RRset permits
+// any CA.
+func CAAAuthorizes(records []CAARecord, caaDomains []string, wildcard bool) bool {
+	var issue, issuewild []CAARecord
+	for _, record := range records {
+		switch strings.ToLower(record.Tag) {
+		case "issue":
+			issue = append(issue, record)
+		case "issuewild":
+			issuewild = append(issuewild, record)
+		case "iodef", "contactemail", "contactphone", "issuemail", "issuevmc":
+		default:
+			if record.Critical() {
+				// Unknown critical properties forbid issuance
+				return false
+			}
+		}
+	}
+	properties := issue
+	if wildcard && len(issuewild) != 0 {
+		properties = issuewild
+	}
+	if len(properties) == 0 {
+		return true
+	}
+	for _, property := range properties {
+		issuerDomain := strings.TrimSpace(strings.SplitN(property.Value, ";", 2)[0])
+		for _, domain := range caaDomains {
+			if issuerDomain != "" && strings.EqualFold(issuerDomain, domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// How long CAAResolver caches lookups
+const caaCacheTime = 1 * time.Hour
+
+type caaCacheEntry struct {
+	domain  string // the domain whose RRset applies
+	records []CAARecord
+	expires time.Time
+}
+
+// CAAResolver looks up the CAA RRset which applies to a domain, using the
+// recursive resolver at Server (host:port).  Lookups are cached for an
+// hour, since most certificates are for the same few domains.
+type CAAResolver struct {
+	Server  string
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	cache map[string]caaCacheEntry
+}
+
+func NewCAAResolver(server string) *CAAResolver {
+	return &CAAResolver{Server: server, Timeout: 5 * time.Second}
+}
+
+// Lookup returns the relevant CAA RRset for |dnsName|, found by climbing
+// the DNS tree from it until a non-empty RRset is found (RFC 8659 section
+// 3), and the domain it was found at, or "" and nil if there isn't one
+func (resolver *CAAResolver) Lookup(dnsName string) (string, []CAARecord, error) {
+	dnsName = strings.TrimSuffix(strings.ToLower(strings.TrimPrefix(dnsName, "*.")), ".")
+	if cached, ok := resolver.cached(dnsName); ok {
+		return cached.domain, cached.records, nil
+	}
+	for name := dnsName; name != ""; {
+		records, err := resolver.query(name)
+		if err != nil {
+			return "", nil, fmt.Errorf("Error looking up CAA records for %s: %s", name, err)
+		}
+		if len(records) != 0 {
+			resolver.store(dnsName, caaCacheEntry{domain: name, records: records})
+			return name, records, nil
+		}
+		if dot := strings.IndexByte(name, '.'); dot == -1 {
+			name = ""
+		} else {
+			name = name[dot+1:]
+		}
+	}
+	resolver.store(dnsName, caaCacheEntry{})
+	return "", nil, nil
+}
+
+func (resolver *CAAResolver) cached(dnsName string) (caaCacheEntry, bool) {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	entry, ok := resolver.cache[dnsName]
+	if !ok || time.Now().After(entry.expires) {
+		return caaCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (resolver *CAAResolver) store(dnsName string, entry caaCacheEntry) {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	if resolver.cache == nil {
+		resolver.cache = make(map[string]caaCacheEntry)
+	}
+	entry.expires = time.Now().Add(caaCacheTime)
+	resolver.cache[dnsName] = entry
+}
+
+func (resolver *CAAResolver) query(name string) ([]CAARecord, error) {
+	response, err := dnsExchange(resolver.Server, "udp", name, dnsTypeCAA, resolver.Timeout)
+	if err == errDNSTruncated {
+		response, err = dnsExchange(resolver.Server, "tcp", name, dnsTypeCAA, resolver.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []CAARecord
+	for _, rr := range response.answers {
+		if rr.rrtype != dnsTypeCAA {
+			continue // e.g. a CNAME, which the resolver has followed
+		}
+		record, err := parseCAARecord(rr.data)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func parseCAARecord(data []byte) (CAARecord, error) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return CAARecord{}, errors.New("malformed CAA record")
+	}
+	tagLen := int(data[1])
+	return CAARecord{Flags: data[0], Tag: string(data[2 : 2+tagLen]), Value: string(data[2+tagLen:])}, nil
+}
+
+// CAAChecker wraps a Matcher, and for each DNS name matched by it, checks
+// that the current CAA records of the name permit the certificate's CA to
+// issue for it, adding a MatchCAA match if they don't.  The CA is identified
+// by its issuer DN or key hash, using the CAs given to NewCAAChecker which
+// have CAADomains; certificates from other CAs aren't checked.  Since CAA records
+// may have changed since the certificate was issued, a violation isn't
+// proof of misissuance, but it warrants a look.
+type CAAChecker struct {
+	Matcher  Matcher
+	Resolver *CAAResolver
+
+	cas []caaIssuer
+}
+
+type caaIssuer struct {
+	ca      *CA
+	issuers *IssuerFilter
+}
+
+func NewCAAChecker(matcher Matcher, resolver *CAAResolver, cas []CA) (*CAAChecker, error) {
+	checker := &CAAChecker{Matcher: matcher, Resolver: resolver}
+	for i := range cas {
+		if len(cas[i].CAADomains) == 0 {
+			continue
+		}
+		issuers := new(IssuerFilter)
+		if err := issuers.AddCA(&cas[i]); err != nil {
+			return nil, err
+		}
+		checker.cas = append(checker.cas, caaIssuer{ca: &cas[i], issuers: issuers})
+	}
+	return checker, nil
+}
+
+// issuingCA returns the CA which issued |info|, or nil if it's unknown
+func (checker *CAAChecker) issuingCA(info *EntryInfo) *CA {
+	for _, ca := range checker.cas {
+		if _, matches, _ := ca.issuers.matchesIssuer(info); matches {
+			return ca.ca
+		}
+	}
+	return nil
+}
+
+func (checker *CAAChecker) Match(info *EntryInfo) []Match {
+	matches := checker.Matcher.Match(info)
+	if len(matches) == 0 {
+		return nil
+	}
+	ca := checker.issuingCA(info)
+	if ca == nil {
+		return matches
+	}
+	checked := make(map[string]bool)
+	for _, match := range matches {
+		if match.Category != MatchDNSName || checked[match.Value] {
+			continue
+		}
+		checked[match.Value] = true
+		domain, records, err := checker.Resolver.Lookup(match.Value)
+		if err != nil {
+			slog.Warn("CAA check failed", "fingerprint", info.Fingerprint(), "dns_name", match.Value, "error", err)
+			continue
+		}
+		if CAAAuthorizes(records, ca.CAADomains, strings.HasPrefix(match.Value, "*.")) {
+			continue
+		}
+		policy := make([]string, len(records))
+		for i, record := range records {
+			policy[i] = record.String()
+		}
+		matches = append(matches, Match{
+			Category: MatchCAA,
+			ID:       MatchCAA,
+			Pattern:  domain + " CAA " + strings.Join(policy, ", "),
+			Value:    match.Value + " (" + ca.Name + " is not authorized)",
+		})
+	}
+	return matches
+}
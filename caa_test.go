@@ -0,0 +1,145 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+func TestCAAAuthorizes(t *testing.T) {
+	le := []string{"letsencrypt.org"}
+	issue := func(value string) CAARecord { return CAARecord{Tag: "issue", Value: value} }
+	issuewild := func(value string) CAARecord { return CAARecord{Tag: "issuewild", Value: value} }
+	tests := []struct {
+		records  []CAARecord
+		wildcard bool
+		expected bool
+	}{
+		{nil, false, true},
+		{[]CAARecord{issue("letsencrypt.org")}, false, true},
+		{[]CAARecord{issue(" LetsEncrypt.org; validationmethods=dns-01")}, false, true},
+		{[]CAARecord{issue("digicert.com")}, false, false},
+		{[]CAARecord{issue("digicert.com"), issue("letsencrypt.org")}, false, true},
+		{[]CAARecord{issue(";")}, false, false},
+		{[]CAARecord{issuewild("digicert.com")}, false, true},
+		{[]CAARecord{issue("letsencrypt.org"), issuewild(";")}, true, false},
+		{[]CAARecord{issue("letsencrypt.org")}, true, true},
+		{[]CAARecord{{Tag: "iodef", Value: "mailto:security@example.com"}}, false, true},
+		{[]CAARecord{issue("letsencrypt.org"), {Flags: 128, Tag: "tbs", Value: "x"}}, false, false},
+	}
+	for _, test := range tests {
+		if actual := CAAAuthorizes(test.records, le, test.wildcard); actual != test.expected {
+			t.Errorf("CAAAuthorizes(%v, wildcard=%v) = %v", test.records, test.wildcard, actual)
+		}
+	}
+}
+
+// serveFakeDNS answers CAA queries with |zone|'s records for each name, or
+// NXDOMAIN, and returns the server's address
+func serveFakeDNS(t *testing.T, zone map[string][]CAARecord) (string, *int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	queries := new(int32)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(queries, 1)
+			query := buf[:n]
+			var labels []string
+			for offset := 12; query[offset] != 0; offset += 1 + int(query[offset]) {
+				labels = append(labels, string(query[offset+1:offset+1+int(query[offset])]))
+			}
+			records, ok := zone[strings.Join(labels, ".")]
+			response := append([]byte(nil), query...)
+			flags := uint16(dnsFlagQR | dnsFlagRD | 0x80)
+			if !ok {
+				flags |= dnsRcodeNX
+			}
+			binary.BigEndian.PutUint16(response[2:], flags)
+			binary.BigEndian.PutUint16(response[6:], uint16(len(records)))
+			for _, record := range records {
+				rdata := append([]byte{record.Flags, byte(len(record.Tag))}, record.Tag+record.Value...)
+				response = append(response, 0xC0, 12, 1, 1, 0, 1, 0, 0, 0, 60, 0, byte(len(rdata)))
+				response = append(response, rdata...)
+			}
+			conn.WriteTo(response, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), queries
+}
+
+func TestCAAResolver(t *testing.T) {
+	server, queries := serveFakeDNS(t, map[string][]CAARecord{
+		"example.com":     {{Tag: "issue", Value: "digicert.com"}},
+		"www.example.com": {},
+		"example.org":     {},
+	})
+	resolver := NewCAAResolver(server)
+
+	domain, records, err := resolver.Lookup("*.www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domain != "example.com" || len(records) != 1 || records[0].Value != "digicert.com" {
+		t.Errorf("Wrong lookup: %s %v", domain, records)
+	}
+	if atomic.LoadInt32(queries) != 2 {
+		t.Errorf("Made %d queries, expected 2", atomic.LoadInt32(queries))
+	}
+	if domain, _, _ := resolver.Lookup("www.example.com"); domain != "example.com" || atomic.LoadInt32(queries) != 2 {
+		t.Errorf("Lookup wasn't cached: %s after %d queries", domain, atomic.LoadInt32(queries))
+	}
+
+	if domain, records, err := resolver.Lookup("example.org"); err != nil || domain != "" || records != nil {
+		t.Errorf("Lookup of example.org: %s %v %v", domain, records, err)
+	}
+}
+
+func TestCAAChecker(t *testing.T) {
+	server, _ := serveFakeDNS(t, map[string][]CAARecord{
+		"example.com": {{Tag: "issue", Value: "digicert.com"}},
+	})
+	cert := makeTestCertInfo(t, nil, nil, nil)
+	cas := []CA{{Name: "Test CA", IssuerDNs: []string{cert.Issuer.String()}, CAADomains: []string{"test-ca.example"}}}
+	info := &EntryInfo{Entry: &ct.LogEntry{}, CertInfo: cert}
+
+	watchlist := MatcherFunc(func(*EntryInfo) []Match {
+		return []Match{{Category: MatchDNSName, ID: "example.com", Value: "www.example.com"}}
+	})
+	checker, err := NewCAAChecker(watchlist, NewCAAResolver(server), cas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := checker.Match(info)
+	if len(matches) != 2 || matches[1].Category != MatchCAA || matches[1].Pattern != `example.com CAA 0 issue "digicert.com"` || matches[1].Value != "www.example.com (Test CA is not authorized)" {
+		t.Errorf("Wrong matches: %+v", matches)
+	}
+
+	cas[0].CAADomains = []string{"digicert.com"}
+	if checker, err = NewCAAChecker(watchlist, NewCAAResolver(server), cas); err != nil {
+		t.Fatal(err)
+	}
+	if matches := checker.Match(info); len(matches) != 1 {
+		t.Errorf("Authorized CA has matches: %+v", matches)
+	}
+}
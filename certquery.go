@@ -0,0 +1,111 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// CertQuery selects saved certificates by any combination of its fields;
+// fields which are empty select every certificate.  Results are ordered by
+// notBefore, and then fingerprint, so that they can be paged through with
+// Offset and Limit.
+type CertQuery struct {
+	Fingerprint       string     // hex-encoded SHA-256
+	Domain            string     // a DNS name of the certificate
+	IncludeSubdomains bool       // also select DNS names under Domain
+	Issuer            string     // case-insensitive substring of the issuer's DN
+	IssuedAfter       *time.Time // notBefore is at or after this time
+	IssuedBefore      *time.Time // notBefore is before this time
+
+	Offset int // number of certificates to skip
+	Limit  int // maximum number of certificates to return, or 0 for no limit
+}
+
+// Stores which can search the certificates they've saved by arbitrary
+// criteria implement CertSearchStore
+type CertSearchStore interface {
+	Store
+
+	SearchCerts(query *CertQuery) ([]*CertRecord, error)
+}
+
+// Matches returns true if |record| satisfies the query's criteria
+func (query *CertQuery) Matches(record *CertRecord) bool {
+	if query.Fingerprint != "" && !strings.EqualFold(record.Fingerprint, query.Fingerprint) {
+		return false
+	}
+	if query.Domain != "" && !query.matchesDomain(record.DNSNames) {
+		return false
+	}
+	if query.Issuer != "" && !strings.Contains(strings.ToLower(record.Issuer), strings.ToLower(query.Issuer)) {
+		return false
+	}
+	if query.IssuedAfter != nil && (record.NotBefore == nil || record.NotBefore.Before(*query.IssuedAfter)) {
+		return false
+	}
+	if query.IssuedBefore != nil && (record.NotBefore == nil || !record.NotBefore.Before(*query.IssuedBefore)) {
+		return false
+	}
+	return true
+}
+
+func (query *CertQuery) matchesDomain(dnsNames []string) bool {
+	domain := strings.ToLower(query.Domain)
+	for _, dnsName := range dnsNames {
+		dnsName = strings.ToLower(dnsName)
+		if dnsName == domain || (query.IncludeSubdomains && strings.HasSuffix(dnsName, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the page of |records| selected by the query, sorted in its
+// order.  It's for stores which can't do the search themselves.
+func (query *CertQuery) Apply(records []*CertRecord) []*CertRecord {
+	var selected []*CertRecord
+	for _, record := range records {
+		if query.Matches(record) {
+			selected = append(selected, record)
+		}
+	}
+	sort.Sort(certRecordsByNotBefore(selected))
+	if query.Offset >= len(selected) {
+		return []*CertRecord{}
+	}
+	selected = selected[query.Offset:]
+	if query.Limit > 0 && len(selected) > query.Limit {
+		selected = selected[:query.Limit]
+	}
+	return selected
+}
+
+// Certificates without a notBefore come first, as if it were the epoch
+type certRecordsByNotBefore []*CertRecord
+
+func (s certRecordsByNotBefore) Len() int      { return len(s) }
+func (s certRecordsByNotBefore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s certRecordsByNotBefore) Less(i, j int) bool {
+	a, b := notBeforeUnix(s[i]), notBeforeUnix(s[j])
+	if a != b {
+		return a < b
+	}
+	return s[i].Fingerprint < s[j].Fingerprint
+}
+
+func notBeforeUnix(record *CertRecord) int64 {
+	if record.NotBefore == nil {
+		return 0
+	}
+	return record.NotBefore.Unix()
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCertQuery(t *testing.T) {
+	day := func(d int) *time.Time {
+		t := time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	records := []*CertRecord{
+		{Fingerprint: "cc", Issuer: "CN=R3, O=Let's Encrypt", NotBefore: day(3), DNSNames: []string{"www.example.com"}},
+		{Fingerprint: "aa", Issuer: "CN=Other CA", NotBefore: day(1), DNSNames: []string{"example.com"}},
+		{Fingerprint: "bb", Issuer: "CN=R3, O=Let's Encrypt", NotBefore: day(3), DNSNames: []string{"example.org"}},
+		{Fingerprint: "dd", Issuer: "CN=E1, O=Let's Encrypt", DNSNames: []string{"a.b.example.com"}},
+	}
+	fingerprints := func(records []*CertRecord) string {
+		var str string
+		for _, record := range records {
+			str += record.Fingerprint + " "
+		}
+		return str
+	}
+	tests := []struct {
+		query    CertQuery
+		expected string
+	}{
+		{CertQuery{}, "dd aa bb cc "},
+		{CertQuery{Fingerprint: "AA"}, "aa "},
+		{CertQuery{Domain: "example.com"}, "aa "},
+		{CertQuery{Domain: "Example.com", IncludeSubdomains: true}, "dd aa cc "},
+		{CertQuery{Issuer: "let's encrypt"}, "dd bb cc "},
+		{CertQuery{IssuedAfter: day(2)}, "bb cc "},
+		{CertQuery{IssuedBefore: day(3)}, "aa "},
+		{CertQuery{Issuer: "R3", Domain: "example.com", IncludeSubdomains: true}, "cc "},
+		{CertQuery{Offset: 1, Limit: 2}, "aa bb "},
+		{CertQuery{Offset: 3, Limit: 2}, "cc "},
+		{CertQuery{Offset: 4}, ""},
+	}
+	for _, test := range tests {
+		if actual := fingerprints(test.query.Apply(records)); actual != test.expected {
+			t.Errorf("%+v selected %q instead of %q", test.query, actual, test.expected)
+		}
+	}
+}
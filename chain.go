@@ -0,0 +1,148 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// A RootStore is a named set of trusted root certificates, such as
+// Mozilla's or Microsoft's
+type RootStore struct {
+	Name  string
+	Roots *x509.CertPool
+}
+
+// LoadRootStore loads the PEM-encoded root certificates in |filename|
+func LoadRootStore(name string, filename string) (*RootStore, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", filename)
+	}
+	return &RootStore{Name: name, Roots: roots}, nil
+}
+
+// ParseRootStoreSpec parses a root store specification of the form
+// NAME=FILENAME, or just FILENAME, in which case the store is named after
+// the file, minus its extension
+func ParseRootStoreSpec(spec string) (string, string) {
+	if i := strings.IndexByte(spec, '='); i > 0 {
+		return spec[:i], spec[i+1:]
+	}
+	base := filepath.Base(spec)
+	return strings.TrimSuffix(base, filepath.Ext(base)), spec
+}
+
+// ChainValidation is the result of validating a certificate's chain
+// against the configured root stores
+type ChainValidation struct {
+	TrustedBy []string `json:"trusted_by"`      // names of the root stores which trust it
+	Root      string   `json:"root,omitempty"`  // subject of the root it chains to
+	Error     string   `json:"error,omitempty"` // why no store trusts it
+}
+
+// Trusted returns true if the certificate chains to a root in at least one
+// store
+func (validation *ChainValidation) Trusted() bool {
+	return len(validation.TrustedBy) != 0
+}
+
+func (validation *ChainValidation) String() string {
+	if !validation.Trusted() {
+		return "untrusted (" + validation.Error + ")"
+	}
+	return strings.Join(validation.TrustedBy, ", ") + " (root: " + validation.Root + ")"
+}
+
+// ChainValidator builds the chain of a certificate from the intermediates
+// which were logged with it, and checks which root stores trust it.
+// Chains are validated as of the certificate's notBefore, so that expired
+// certificates are still considered trusted if they were when issued.
+// Extended key usages aren't checked, since precertificates may be issued
+// by a precertificate signing certificate.
+type ChainValidator struct {
+	Stores []*RootStore
+}
+
+func NewChainValidator(stores []*RootStore) *ChainValidator {
+	return &ChainValidator{Stores: stores}
+}
+
+// Validate returns the validation of the entry's chain, or nil if there are
+// no root stores
+func (validator *ChainValidator) Validate(info *EntryInfo) *ChainValidation {
+	if len(validator.Stores) == 0 {
+		return nil
+	}
+	if len(info.FullChain) == 0 {
+		return &ChainValidation{Error: "entry has no certificate"}
+	}
+	leaf, err := parseChainCertificate(info.FullChain[0])
+	if err != nil {
+		return &ChainValidation{Error: fmt.Sprintf("Error parsing certificate: %s", err)}
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range info.FullChain[1:] {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	validation := new(ChainValidation)
+	var errs []string
+	for _, store := range validator.Stores {
+		chains, err := leaf.Verify(x509.VerifyOptions{
+			Intermediates: intermediates,
+			Roots:         store.Roots,
+			CurrentTime:   leaf.NotBefore,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			errs = append(errs, store.Name+": "+err.Error())
+			continue
+		}
+		validation.TrustedBy = append(validation.TrustedBy, store.Name)
+		if validation.Root == "" {
+			chain := chains[0]
+			validation.Root = chain[len(chain)-1].Subject.String()
+		}
+	}
+	if !validation.Trusted() {
+		validation.Error = strings.Join(errs, "; ")
+	}
+	return validation
+}
+
+// parseChainCertificate parses a certificate or precertificate; the latter's
+// poison extension is critical, so it's removed from the unhandled critical
+// extensions to let it be verified
+func parseChainCertificate(der []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	var unhandled []asn1.ObjectIdentifier
+	for _, oid := range cert.UnhandledCriticalExtensions {
+		if !oid.Equal(oidExtensionCTPoison) {
+			unhandled = append(unhandled, oid)
+		}
+	}
+	cert.UnhandledCriticalExtensions = unhandled
+	return cert, nil
+}
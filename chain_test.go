@@ -0,0 +1,84 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+)
+
+func makeChainTestCA(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	return makeTestCA(t, parent, parentKey, func(template *x509.Certificate) {
+		template.Subject = pkix.Name{CommonName: name}
+	})
+}
+
+func makeChainTestLeaf(t *testing.T, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey, precert bool) []byte {
+	leaf, _ := makeTestCert(t, issuer, issuerKey, func(template *x509.Certificate) {
+		if precert {
+			template.ExtraExtensions = []pkix.Extension{{Id: oidExtensionCTPoison, Critical: true, Value: []byte{5, 0}}}
+		}
+	})
+	return leaf.Raw
+}
+
+func TestChainValidator(t *testing.T) {
+	root, rootKey := makeChainTestCA(t, "Test Root", nil, nil)
+	intermediate, intermediateKey := makeChainTestCA(t, "Test Intermediate", root, rootKey)
+	otherRoot, _ := makeChainTestCA(t, "Other Root", nil, nil)
+
+	trusting := x509.NewCertPool()
+	trusting.AddCert(root)
+	other := x509.NewCertPool()
+	other.AddCert(otherRoot)
+	validator := NewChainValidator([]*RootStore{{Name: "mozilla", Roots: trusting}, {Name: "apple", Roots: other}, {Name: "microsoft", Roots: trusting}})
+
+	// Expired, but valid when issued
+	leaf := makeChainTestLeaf(t, intermediate, intermediateKey, false)
+	validation := validator.Validate(&EntryInfo{FullChain: [][]byte{leaf, intermediate.Raw, root.Raw}})
+	if !validation.Trusted() || strings.Join(validation.TrustedBy, ",") != "mozilla,microsoft" || validation.Root != "CN=Test Root" || validation.Error != "" {
+		t.Errorf("Wrong validation: %+v", validation)
+	}
+
+	precert := makeChainTestLeaf(t, intermediate, intermediateKey, true)
+	if validation := validator.Validate(&EntryInfo{FullChain: [][]byte{precert, intermediate.Raw}}); !validation.Trusted() {
+		t.Errorf("Precertificate isn't trusted: %+v", validation)
+	}
+
+	// Without the intermediate, the chain can't be built
+	validation = validator.Validate(&EntryInfo{FullChain: [][]byte{leaf}})
+	if validation.Trusted() || !strings.Contains(validation.Error, "mozilla: ") || !strings.Contains(validation.Error, "apple: ") {
+		t.Errorf("Wrong validation: %+v", validation)
+	}
+
+	if validation := NewChainValidator(nil).Validate(&EntryInfo{FullChain: [][]byte{leaf}}); validation != nil {
+		t.Errorf("Validated without any root stores: %+v", validation)
+	}
+}
+
+func TestParseRootStoreSpec(t *testing.T) {
+	tests := []struct {
+		spec     string
+		name     string
+		filename string
+	}{
+		{"mozilla=/etc/roots/certdata.pem", "mozilla", "/etc/roots/certdata.pem"},
+		{"/etc/roots/apple.pem", "apple", "/etc/roots/apple.pem"},
+		{"microsoft", "microsoft", "microsoft"},
+	}
+	for _, test := range tests {
+		if name, filename := ParseRootStoreSpec(test.spec); name != test.name || filename != test.filename {
+			t.Errorf("%q: got %q, %q", test.spec, name, filename)
+		}
+	}
+}
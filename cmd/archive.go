@@ -0,0 +1,37 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"flag"
+
+	"software.sslmate.com/src/certspotter/s3archive"
+)
+
+var s3ArchiveURL = flag.String("s3_archive", "", "Upload matching certificates to s3://BUCKET/PREFIX")
+var s3Endpoint = flag.String("s3_endpoint", "", "Base URL of S3-compatible service to use with -s3_archive (default: Amazon S3)")
+var archive *s3archive.Archive
+
+func openArchive() error {
+	if *s3ArchiveURL == "" {
+		return nil
+	}
+	var config s3archive.Config
+	if err := config.ParseURL(*s3ArchiveURL); err != nil {
+		return err
+	}
+	config.ConfigFromEnvironment()
+	config.Endpoint = *s3Endpoint
+	config.Compression = *compressFlag
+
+	var err error
+	archive, err = s3archive.New(config)
+	return err
+}
@@ -0,0 +1,285 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/expr"
+)
+
+// stringList is a flag which may be specified more than once
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ", ")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+func (list *stringList) Values() []string {
+	return append([]string(nil), *list...)
+}
+
+func (list *stringList) Reset() {
+	*list = nil
+}
+
+var issuers stringList
+var excludeIssuers stringList
+var filterExprs stringList
+var keyBlocklists stringList
+var caaCheck = flag.Bool("caa_check", false, "Look up the current CAA records of matching DNS names, and report if the certificate's CA (from -ca_list) isn't authorized")
+var caaResolver = flag.String("caa_resolver", "", "DNS resolver (HOST:PORT) for -caa_check (default: the first nameserver in /etc/resolv.conf)")
+var caListFilename = flag.String("ca_list", "", "JSON file listing CAs which can be referred to as ca:NAME by -issuer and -exclude_issuer")
+var hashedWatchlistFilename = flag.String("hashed_watchlist", "", "Also report certificates for domains in this hashed watchlist (see the hashwatchlist command)")
+var serialsFilename = flag.String("serials", "", "Also report certificates with serial numbers (in hex) listed in this file")
+var pubkeysFilename = flag.String("pubkeys", "", "Also report certificates whose public key's SHA-256 hash (in hex) is listed in this file")
+var weakKeys = flag.Bool("weak_keys", false, "Only report certificates with weak or compromised public keys")
+var minRSABits = flag.Int("min_rsa_bits", certspotter.DefaultMinRSABits, "With -weak_keys, RSA keys smaller than this are weak")
+var weakCrypto = flag.Bool("weak_crypto", false, "Only report certificates signed with MD5 or SHA-1 or whose ECDSA key is on a deprecated curve")
+var validityAnomalies = flag.Bool("validity_anomalies", false, "Only report certificates with anomalous validity periods")
+var maxValidityDays = flag.Int("max_validity_days", 0, "With -validity_anomalies, validity periods longer than this are anomalous (default: the Baseline Requirements' limit when the certificate was issued)")
+var maxBackdateHours = flag.Int("max_backdate_hours", int(certspotter.DefaultMaxBackdate/time.Hour), "With -validity_anomalies, precertificates backdated by more than this are anomalous")
+var lookalikes = flag.Bool("lookalikes", false, "Also report certificates for names that resemble domains on the watchlist")
+var internalNames = flag.Bool("internal_names", false, "Also report certificates for reserved IP addresses, internal domains like .local, or single-label names")
+var lookalikeThreshold = flag.Float64("lookalike_threshold", certspotter.DefaultLookalikeThreshold, "With -lookalikes, the minimum score (0 to 1) to report")
+var compromisedKeysFilename = flag.String("compromised_keys", "", "With -weak_keys, file listing SHA-256 hashes (in hex) of compromised public keys")
+
+func init() {
+	flag.Var(&issuers, "issuer", "Only report certificates from this issuer (dn:DN, key:HASH, or ca:NAME; may be repeated)")
+	flag.Var(&excludeIssuers, "exclude_issuer", "Don't report certificates from this issuer (dn:DN, key:HASH, or ca:NAME; may be repeated)")
+	flag.Var(&filterExprs, "filter", "Only report certificates matching this expression (may be repeated)")
+	flag.Var(&keyBlocklists, "key_blocklist", "Always report certificates whose public key's SHA-256 hash (in hex) is listed in this file, regardless of the watchlist and filters (may be repeated)")
+}
+
+func loadCAList() ([]certspotter.CA, error) {
+	if *caListFilename == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(*caListFilename)
+	if err != nil {
+		return nil, err
+	}
+	var caList certspotter.CAListFile
+	if err := json.Unmarshal(data, &caList); err != nil {
+		return nil, fmt.Errorf("%s: %s", *caListFilename, err)
+	}
+	return caList.CAs, nil
+}
+
+func makeIssuerFilter(specs []string, exclude bool, cas []certspotter.CA) (*certspotter.IssuerFilter, error) {
+	filter := &certspotter.IssuerFilter{Exclude: exclude}
+	for _, spec := range specs {
+		if err := filter.Add(spec, cas); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// needWatchlist returns false if certificates are to be selected only by
+// hashed watchlist, serial number, public key, key blocklist, internal name, or -filter expression, in which case the
+// watchlist is not loaded unless it was explicitly specified (or is needed
+// by -lookalikes)
+func needWatchlist() bool {
+	if *lookalikes || (*hashedWatchlistFilename == "" && *serialsFilename == "" && *pubkeysFilename == "" && !*internalNames && len(keyBlocklists) == 0 && len(filterExprs) == 0) {
+		return true
+	}
+	watchlistSpecified := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "watchlist" {
+			watchlistSpecified = true
+		}
+	})
+	return watchlistSpecified
+}
+
+// makeSelector returns a matcher for the certificates to report: those
+// matching the watchlist (if not nil) or resembling its domains, or the
+// hashed watchlist, or any listed serial number or public key, or those for
+// internal names.  It returns nil if there is nothing to select by.
+func makeSelector(watchlist *certspotter.Watchlist) (certspotter.Matcher, error) {
+	var matchers []certspotter.Matcher
+	if watchlist != nil {
+		matchers = append(matchers, watchlist)
+		if *lookalikes {
+			detector := certspotter.NewLookalikeDetector(watchlist)
+			detector.Threshold = *lookalikeThreshold
+			matchers = append(matchers, detector)
+		}
+	}
+	if *hashedWatchlistFilename != "" {
+		hashedWatchlist, err := certspotter.LoadHashedWatchlist(*hashedWatchlistFilename)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading hashed watchlist: %s", err)
+		}
+		matchers = append(matchers, hashedWatchlist)
+	}
+	if *serialsFilename != "" {
+		serials := new(certspotter.SerialMatcher)
+		if err := certspotter.LoadIdentifierList(*serialsFilename, serials.Add); err != nil {
+			return nil, fmt.Errorf("Error loading serial numbers: %s", err)
+		}
+		matchers = append(matchers, serials)
+	}
+	if *pubkeysFilename != "" {
+		pubkeys := new(certspotter.PubkeyMatcher)
+		if err := certspotter.LoadIdentifierList(*pubkeysFilename, pubkeys.Add); err != nil {
+			return nil, fmt.Errorf("Error loading public key hashes: %s", err)
+		}
+		matchers = append(matchers, pubkeys)
+	}
+	if *internalNames {
+		matchers = append(matchers, &certspotter.InternalNameMatcher{})
+	}
+	switch len(matchers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matchers[0], nil
+	default:
+		return certspotter.Any(matchers...), nil
+	}
+}
+
+// loadKeyBlocklist loads the -key_blocklist files, or returns nil if there
+// are none
+func loadKeyBlocklist() (*certspotter.KeyBlocklist, error) {
+	if len(keyBlocklists) == 0 {
+		return nil, nil
+	}
+	blocklist := new(certspotter.KeyBlocklist)
+	for _, filename := range keyBlocklists {
+		if err := certspotter.LoadIdentifierList(filename, blocklist.Add); err != nil {
+			return nil, fmt.Errorf("Error loading key blocklist: %s", err)
+		}
+	}
+	return blocklist, nil
+}
+
+// makeMatcher combines the watchlist with the filters specified on the
+// command line, and the key blocklist, which bypasses both
+func makeMatcher(watchlist *certspotter.Watchlist) (certspotter.Matcher, error) {
+	selector, err := makeSelector(watchlist)
+	if err != nil {
+		return nil, err
+	}
+	var matchers []certspotter.Matcher
+	if selector != nil {
+		matchers = append(matchers, selector)
+	}
+
+	if len(issuers) != 0 || len(excludeIssuers) != 0 {
+		cas, err := loadCAList()
+		if err != nil {
+			return nil, fmt.Errorf("Error loading CA list: %s", err)
+		}
+		if len(issuers) != 0 {
+			filter, err := makeIssuerFilter(issuers, false, cas)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, filter)
+		}
+		if len(excludeIssuers) != 0 {
+			filter, err := makeIssuerFilter(excludeIssuers, true, cas)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, filter)
+		}
+	}
+
+	if *weakKeys {
+		filter := certspotter.NewWeakKeyFilter()
+		filter.MinRSABits = *minRSABits
+		if *compromisedKeysFilename != "" {
+			filter.Blocklist = new(certspotter.PubkeyMatcher)
+			if err := certspotter.LoadIdentifierList(*compromisedKeysFilename, filter.Blocklist.Add); err != nil {
+				return nil, fmt.Errorf("Error loading compromised keys: %s", err)
+			}
+		}
+		matchers = append(matchers, filter)
+	}
+
+	if *weakCrypto {
+		matchers = append(matchers, &certspotter.WeakCryptoFilter{})
+	}
+
+	if *validityAnomalies {
+		filter := certspotter.NewValidityFilter()
+		filter.MaxValidity = time.Duration(*maxValidityDays) * 24 * time.Hour
+		filter.MaxBackdate = time.Duration(*maxBackdateHours) * time.Hour
+		matchers = append(matchers, filter)
+	}
+
+	for _, source := range filterExprs {
+		filter, err := expr.Compile(source)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, filter)
+	}
+
+	// Unless there's something to select certificates besides the key
+	// blocklist, the filters have nothing to filter
+	var matcher certspotter.Matcher
+	if selector != nil || len(filterExprs) != 0 {
+		if len(matchers) == 1 {
+			matcher = matchers[0]
+		} else {
+			matcher = certspotter.All(matchers...)
+		}
+	}
+
+	if matcher != nil && *caaCheck {
+		if matcher, err = makeCAAChecker(matcher); err != nil {
+			return nil, err
+		}
+	}
+
+	blocklist, err := loadKeyBlocklist()
+	if err != nil {
+		return nil, err
+	}
+	if blocklist == nil {
+		return matcher, nil
+	} else if matcher == nil {
+		return blocklist, nil
+	}
+	return certspotter.Any(blocklist, matcher), nil
+}
+
+// makeCAAChecker wraps |matcher| with a CAA check of the DNS names it
+// matches, for -caa_check
+func makeCAAChecker(matcher certspotter.Matcher) (certspotter.Matcher, error) {
+	cas, err := loadCAList()
+	if err != nil {
+		return nil, fmt.Errorf("Error loading CA list: %s", err)
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("-caa_check requires a -ca_list with the caa_domains of each CA")
+	}
+	server := *caaResolver
+	if server == "" {
+		server = certspotter.DefaultDNSServer()
+	}
+	return certspotter.NewCAAChecker(matcher, certspotter.NewCAAResolver(server), cas)
+}
@@ -10,19 +10,13 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
-	"strings"
-
-	"golang.org/x/net/idna"
 
 	"software.sslmate.com/src/certspotter"
 	"software.sslmate.com/src/certspotter/cmd"
-	"software.sslmate.com/src/certspotter/ct"
 )
 
 func defaultStateDir() string {
@@ -40,156 +34,139 @@ func defaultConfigDir() string {
 	}
 }
 
-func trimTrailingDots(value string) string {
-	length := len(value)
-	for length > 0 && value[length-1] == '.' {
-		length--
-	}
-	return value[0:length]
-}
-
 var stateDir = flag.String("state_dir", defaultStateDir(), "Directory for storing state")
+var storeSpec = flag.String("store", "", "Keep state in the given store instead of the state directory (e.g. sqlite:PATH)")
 var watchlistFilename = flag.String("watchlist", filepath.Join(defaultConfigDir(), "watchlist"), "File containing identifiers to watch (- for stdin)")
 
-type watchlistItem struct {
-	Domain       []string
-	AcceptSuffix bool
-}
-
-var watchlist []watchlistItem
+// A watchlist read from stdin can't be reread, so it's kept for reloads
+var stdinWatchlist *certspotter.Watchlist
 
-func parseWatchlistItem(str string) (watchlistItem, error) {
-	if str == "." { // "." as in root zone (matches everything)
-		return watchlistItem{
-			Domain:       []string{},
-			AcceptSuffix: true,
-		}, nil
-	} else {
-		acceptSuffix := false
-		if strings.HasPrefix(str, ".") {
-			acceptSuffix = true
-			str = str[1:]
-		}
-		asciiDomain, err := idna.ToASCII(strings.ToLower(trimTrailingDots(str)))
-		if err != nil {
-			return watchlistItem{}, fmt.Errorf("Invalid domain `%s': %s", str, err)
-		}
-		return watchlistItem{
-			Domain:       strings.Split(asciiDomain, "."),
-			AcceptSuffix: acceptSuffix,
-		}, nil
+// loadWatchlist loads the watchlist, or returns nil if it isn't needed
+func loadWatchlist() (*certspotter.Watchlist, error) {
+	if !needWatchlist() {
+		return nil, nil
 	}
-}
-
-func readWatchlist(reader io.Reader) ([]watchlistItem, error) {
-	items := []watchlistItem{}
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		item, err := parseWatchlistItem(line)
-		if err != nil {
-			return nil, err
-		}
-		items = append(items, item)
+	if *watchlistFilename == "-" && stdinWatchlist != nil {
+		return stdinWatchlist, nil
+	}
+	watchlist, err := certspotter.LoadWatchlist(*watchlistFilename)
+	if err != nil {
+		return nil, err
+	}
+	if *watchlistFilename == "-" {
+		stdinWatchlist = watchlist
 	}
-	return items, scanner.Err()
+	return watchlist, nil
 }
 
-func dnsLabelMatches(certLabel string, watchLabel string) bool {
-	// For fail-safe behavior, if a label was unparsable, it matches everything.
-	// Similarly, redacted labels match everything, since the label _might_ be
-	// for a name we're interested in.
-
-	return certLabel == "*" ||
-		certLabel == "?" ||
-		certLabel == certspotter.UnparsableDNSLabelPlaceholder ||
-		certspotter.MatchesWildcard(watchLabel, certLabel)
+// loadMatcher loads the watchlist and filters, and returns a Matcher for
+// the entries to report
+func loadMatcher() (certspotter.Matcher, error) {
+	watchlist, err := loadWatchlist()
+	if err != nil {
+		return nil, err
+	}
+	return makeMatcher(watchlist)
 }
 
-func dnsNameMatches(dnsName []string, watchDomain []string, acceptSuffix bool) bool {
-	for len(dnsName) > 0 && len(watchDomain) > 0 {
-		certLabel := dnsName[len(dnsName)-1]
-		watchLabel := watchDomain[len(watchDomain)-1]
-
-		if !dnsLabelMatches(certLabel, watchLabel) {
-			return false
-		}
-
-		dnsName = dnsName[:len(dnsName)-1]
-		watchDomain = watchDomain[:len(watchDomain)-1]
+// makeProcessCallback returns a callback which reports the entries matched
+// by loadMatcher
+func makeProcessCallback() (certspotter.ProcessCallback, error) {
+	matcher, err := loadMatcher()
+	if err != nil {
+		return nil, err
 	}
-
-	return len(watchDomain) == 0 && (acceptSuffix || len(dnsName) == 0)
+	return certspotter.MatchingCallback(matcher, cmd.LogEntry), nil
 }
 
-func dnsNameIsWatched(dnsName string) bool {
-	labels := strings.Split(dnsName, ".")
-	for _, item := range watchlist {
-		if dnsNameMatches(labels, item.Domain, item.AcceptSuffix) {
-			return true
-		}
-	}
-	return false
+// Commands which may be given before the flags.  Without one, certspotter
+// scans the logs once, as with the scan command.
+var commands = map[string]string{
+	"scan":   "Scan the logs once for matching certificates",
+	"watch":  "Scan the logs continuously (same as scan -daemon)",
+	"query":  "Print the saved certificates with the given fingerprints or DNS names (.DOMAIN includes sub-domains)",
+	"verify": "Check that each log's latest STH is consistent with the verified STH, or verify the -verify_journal",
 }
 
-func anyDnsNameIsWatched(dnsNames []string) bool {
-	for _, dnsName := range dnsNames {
-		if dnsNameIsWatched(dnsName) {
-			return true
-		}
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [COMMAND] [FLAGS] [ARGS]\n\nCommands:\n", os.Args[0])
+	for _, name := range []string{"scan", "watch", "query", "verify"} {
+		fmt.Fprintf(out, "  %-8s%s\n", name, commands[name])
 	}
-	return false
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
 }
 
-func processEntry(scanner *certspotter.Scanner, entry *ct.LogEntry) {
-	info := certspotter.EntryInfo{
-		LogUri:    scanner.LogUri,
-		Entry:     entry,
-		IsPrecert: certspotter.IsPrecert(entry),
-		FullChain: certspotter.GetFullChain(entry),
+// parseCommandLine returns the command at the start of |args| (the
+// command-line arguments), if any, and parses the flags after it
+func parseCommandLine(args []string) (string, error) {
+	command := "scan"
+	if len(args) > 0 && commands[args[0]] != "" {
+		command, args = args[0], args[1:]
 	}
-
-	info.CertInfo, info.ParseError = certspotter.MakeCertInfoFromLogEntry(entry)
-
-	if info.CertInfo != nil {
-		info.Identifiers, info.IdentifiersParseError = info.CertInfo.ParseIdentifiers()
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return "", err
 	}
-
-	// Fail safe behavior: if info.Identifiers is nil (which is caused by a
-	// parse error), report the certificate because we can't say for sure it
-	// doesn't match a domain we care about.  We try very hard to make sure
-	// parsing identifiers always succeeds, so false alarms should be rare.
-	if info.Identifiers == nil || anyDnsNameIsWatched(info.Identifiers.DNSNames) {
-		cmd.LogEntry(&info)
+	if command == "watch" {
+		flag.Set("daemon", "true")
+	}
+	if command != "query" && flag.NArg() != 0 {
+		return "", fmt.Errorf("unexpected argument `%s' (see -help)", flag.Arg(0))
 	}
+	return command, nil
 }
 
 func main() {
-	flag.Parse()
+	flag.Usage = usage
+	command, err := parseCommandLine(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		os.Exit(2)
+	}
+	switch command {
+	case "query":
+		os.Exit(runQuery(flag.Args()))
+	case "verify":
+		os.Exit(runVerify())
+	}
 
-	if *watchlistFilename == "-" {
+	var processCallback certspotter.ProcessCallback
+	if !cmd.UsingProfiles() {
 		var err error
-		watchlist, err = readWatchlist(os.Stdin)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: (stdin): %s\n", os.Args[0], err)
+		if processCallback, err = makeProcessCallback(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
 			os.Exit(1)
 		}
-	} else {
-		file, err := os.Open(*watchlistFilename)
+	}
+	cmd.Reload = makeProcessCallback
+	cmd.MakeMatcher = loadMatcher
+	if needWatchlist() && *watchlistFilename != "-" {
+		cmd.WatchlistFilename = *watchlistFilename
+	}
+
+	if *storeSpec != "" {
+		store, err := openStore(*storeSpec)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", os.Args[0], *watchlistFilename, err)
+			fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
 			os.Exit(1)
 		}
-		defer file.Close()
-		watchlist, err = readWatchlist(file)
+		os.Exit(cmd.MainWithStore(store, processCallback))
+	}
+
+	os.Exit(cmd.Main(*stateDir, processCallback))
+}
+
+// runVerify implements the verify command, and returns the exit code
+func runVerify() int {
+	if *storeSpec != "" {
+		store, err := openStore(*storeSpec)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", os.Args[0], *watchlistFilename, err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+			return 1
 		}
+		defer closeStore(store)
+		return cmd.VerifyWithStore(store)
 	}
-
-	os.Exit(cmd.Main(*stateDir, processEntry))
+	return cmd.Verify(*stateDir)
 }
@@ -0,0 +1,59 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		args    []string
+		command string
+		daemon  bool
+		rest    []string
+		ok      bool
+	}{
+		{[]string{}, "scan", false, nil, true},
+		{[]string{"-state_dir", "/tmp/state"}, "scan", false, nil, true},
+		{[]string{"scan", "-daemon"}, "scan", true, nil, true},
+		{[]string{"watch"}, "watch", true, nil, true},
+		{[]string{"verify", "-state_dir", "/tmp/state"}, "verify", false, nil, true},
+		{[]string{"query", "-store", "sqlite:/tmp/db", "example.com", ".example.net"}, "query", false, []string{"example.com", ".example.net"}, true},
+		{[]string{"example.com"}, "", false, nil, false},
+		{[]string{"verify", "example.com"}, "", false, nil, false},
+		{[]string{"-state_dir", "/tmp/state", "watch"}, "", false, nil, false}, // commands must come first
+	}
+	for _, test := range tests {
+		flag.Set("daemon", "false")
+		command, err := parseCommandLine(test.args)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: unexpected error %v", test.args, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if command != test.command {
+			t.Errorf("%q: command is %q, expected %q", test.args, command, test.command)
+		}
+		if daemon := flag.Lookup("daemon").Value.String() == "true"; daemon != test.daemon {
+			t.Errorf("%q: -daemon is %v, expected %v", test.args, daemon, test.daemon)
+		}
+		if rest := flag.Args(); len(rest) != 0 || len(test.rest) != 0 {
+			if !reflect.DeepEqual(rest, test.rest) {
+				t.Errorf("%q: arguments are %q, expected %q", test.args, rest, test.rest)
+			}
+		}
+	}
+	flag.Set("daemon", "false")
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// isFingerprint returns true if |arg| is a hex-encoded SHA-256 hash
+func isFingerprint(arg string) bool {
+	decoded, err := hex.DecodeString(arg)
+	return err == nil && len(decoded) == 32
+}
+
+// queryCerts looks up the certificates in |store| for each argument: a
+// fingerprint, a DNS name, or a domain prefixed with a dot to include its
+// sub-domains, as in the watchlist
+func queryCerts(store certspotter.CertQueryStore, args []string) ([]*certspotter.CertRecord, error) {
+	var records []*certspotter.CertRecord
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		var found []*certspotter.CertRecord
+		if isFingerprint(arg) {
+			record, err := store.GetCert(arg)
+			if err != nil {
+				return nil, err
+			}
+			if record != nil {
+				found = append(found, record)
+			}
+		} else {
+			var err error
+			found, err = store.FindCertsByDomain(strings.TrimPrefix(arg, "."), strings.HasPrefix(arg, "."))
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, record := range found {
+			if !seen[record.Fingerprint] {
+				seen[record.Fingerprint] = true
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
+}
+
+func writeCertRecord(out io.Writer, record *certspotter.CertRecord) {
+	fmt.Fprintf(out, "%s:\n", record.Fingerprint)
+	for _, dnsName := range record.DNSNames {
+		fmt.Fprintf(out, "\t%13s = %s\n", "DNS Name", dnsName)
+	}
+	if record.Issuer != "" {
+		fmt.Fprintf(out, "\t%13s = %s\n", "Issuer", record.Issuer)
+	}
+	if record.Serial != "" {
+		fmt.Fprintf(out, "\t%13s = %s\n", "Serial", record.Serial)
+	}
+	if record.NotBefore != nil {
+		fmt.Fprintf(out, "\t%13s = %s\n", "Not Before", record.NotBefore)
+	}
+	if record.NotAfter != nil {
+		fmt.Fprintf(out, "\t%13s = %s\n", "Not After", record.NotAfter)
+	}
+	if record.IsPrecert {
+		fmt.Fprintf(out, "\t%13s = %s\n", "Type", "Pre-certificate")
+	} else {
+		fmt.Fprintf(out, "\t%13s = %s\n", "Type", "Certificate")
+	}
+	fmt.Fprintf(out, "\t%13s = %s\n", "First Seen", record.FirstSeen)
+	fmt.Fprintf(out, "\t%13s = %s\n", "crt.sh", "https://crt.sh/?sha256="+record.Fingerprint)
+}
+
+// runQuery implements the query command, and returns the exit code
+func runQuery(args []string) int {
+	if *storeSpec == "" {
+		fmt.Fprintf(os.Stderr, "%s: query requires -store, since the state directory can't be searched\n", os.Args[0])
+		return 2
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: query requires at least one fingerprint or DNS name\n", os.Args[0])
+		return 2
+	}
+	store, err := openStore(*storeSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	defer closeStore(store)
+	queryStore, ok := store.(certspotter.CertQueryStore)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: this store can't be searched\n", os.Args[0])
+		return 1
+	}
+	records, err := queryCerts(queryStore, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	for i, record := range records {
+		if i > 0 {
+			fmt.Println()
+		}
+		writeCertRecord(os.Stdout, record)
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// fakeQueryStore is a CertQueryStore containing |records|.  Its other
+// methods aren't implemented.
+type fakeQueryStore struct {
+	certspotter.Store
+	records []*certspotter.CertRecord
+}
+
+func (store *fakeQueryStore) GetCert(fingerprint string) (*certspotter.CertRecord, error) {
+	for _, record := range store.records {
+		if record.Fingerprint == fingerprint {
+			return record, nil
+		}
+	}
+	return nil, nil
+}
+
+func (store *fakeQueryStore) FindCertsByDomain(domain string, includeSubdomains bool) ([]*certspotter.CertRecord, error) {
+	if domain == "error.example" {
+		return nil, errors.New("database is locked")
+	}
+	var found []*certspotter.CertRecord
+	for _, record := range store.records {
+		for _, dnsName := range record.DNSNames {
+			if dnsName == domain || (includeSubdomains && strings.HasSuffix(dnsName, "."+domain)) {
+				found = append(found, record)
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+func TestQueryCerts(t *testing.T) {
+	fingerprintA := strings.Repeat("a", 64)
+	fingerprintB := strings.Repeat("b", 64)
+	store := &fakeQueryStore{records: []*certspotter.CertRecord{
+		{Fingerprint: fingerprintA, DNSNames: []string{"example.com", "www.example.com"}},
+		{Fingerprint: fingerprintB, DNSNames: []string{"mail.example.com"}},
+	}}
+	tests := []struct {
+		args     []string
+		expected []string // fingerprints
+	}{
+		{[]string{fingerprintA}, []string{fingerprintA}},
+		{[]string{strings.Repeat("c", 64)}, nil},
+		{[]string{"example.com"}, []string{fingerprintA}},
+		{[]string{"mail.example.com"}, []string{fingerprintB}},
+		{[]string{".example.com"}, []string{fingerprintA, fingerprintB}},
+		{[]string{"example.net"}, nil},
+		{[]string{strings.Repeat("a", 63)}, nil}, // not a fingerprint, so a DNS name
+		{[]string{"mail.example.com", ".example.com", fingerprintA}, []string{fingerprintB, fingerprintA}},
+	}
+	for _, test := range tests {
+		records, err := queryCerts(store, test.args)
+		if err != nil {
+			t.Errorf("%q: %s", test.args, err)
+			continue
+		}
+		var fingerprints []string
+		for _, record := range records {
+			fingerprints = append(fingerprints, record.Fingerprint)
+		}
+		if !reflect.DeepEqual(fingerprints, test.expected) {
+			t.Errorf("%q: found %q, expected %q", test.args, fingerprints, test.expected)
+		}
+	}
+	if _, err := queryCerts(store, []string{"example.com", "error.example"}); err == nil {
+		t.Errorf("Store error wasn't returned")
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/boltstore"
+	"software.sslmate.com/src/certspotter/sqlstore"
+)
+
+// Open the store described by |spec|, which has the form TYPE:ARGUMENT
+func openStore(spec string) (certspotter.Store, error) {
+	fields := strings.SplitN(spec, ":", 2)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("Invalid store `%s': must be of the form TYPE:ARGUMENT", spec)
+	}
+	storeType, arg := fields[0], fields[1]
+	switch storeType {
+	case "bolt":
+		return boltstore.Open(arg)
+	case "postgres":
+		return sqlstore.OpenPostgres(arg, sqlstore.DefaultPostgresOptions)
+	case "sqlite":
+		return sqlstore.OpenSQLite(arg)
+	default:
+		return nil, fmt.Errorf("Invalid store `%s': unknown store type `%s'", spec, storeType)
+	}
+}
+
+// closeStore closes |store|, if it needs to be closed, printing any error
+func closeStore(store certspotter.Store) {
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Error closing store: %s\n", os.Args[0], err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// rootStoreList is the -root_store flag, which may be specified more than once
+type rootStoreList []string
+
+func (list *rootStoreList) String() string {
+	return strings.Join(*list, ", ")
+}
+
+func (list *rootStoreList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+var rootStoreSpecs rootStoreList
+
+var chainValidator *certspotter.ChainValidator // for -root_store
+
+func init() {
+	flag.Var(&rootStoreSpecs, "root_store", "Validate the chain of each matching certificate against the roots in this PEM file (NAME=FILE, or FILE to name the store after the file; may be repeated)")
+}
+
+// loadRootStores loads the -root_store files
+func loadRootStores() error {
+	if len(rootStoreSpecs) == 0 {
+		return nil
+	}
+	var stores []*certspotter.RootStore
+	seen := make(map[string]bool)
+	for _, spec := range rootStoreSpecs {
+		name, filename := certspotter.ParseRootStoreSpec(spec)
+		if seen[name] {
+			return fmt.Errorf("-root_store %s specified more than once", name)
+		}
+		seen[name] = true
+		store, err := certspotter.LoadRootStore(name, filename)
+		if err != nil {
+			return fmt.Errorf("Error loading root store %s: %s", name, err)
+		}
+		stores = append(stores, store)
+	}
+	chainValidator = certspotter.NewChainValidator(stores)
+	return nil
+}
+
+// validateChain sets the entry's ChainValidation, for -root_store
+func validateChain(info *certspotter.EntryInfo) {
+	info.ChainValidation = chainValidator.Validate(info)
+}
@@ -11,27 +11,64 @@ package cmd
 
 import (
 	"bytes"
+	"crypto"
+	"encoding/hex"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"log/slog"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/compression"
 	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/ct/client"
+	"software.sslmate.com/src/certspotter/ctv2"
+	"software.sslmate.com/src/certspotter/loglist"
+	"software.sslmate.com/src/certspotter/tiled"
+	"software.sslmate.com/src/certspotter/tracing"
 )
 
 var batchSize = flag.Int("batch_size", 1000, "Max number of entries to request at per call to get-entries (advanced)")
 var numWorkers = flag.Int("num_workers", 2, "Number of concurrent matchers (advanced)")
 var script = flag.String("script", "", "Script to execute when a matching certificate is found")
 var logsFilename = flag.String("logs", "", "JSON file containing log information")
+var customLogsFilename = flag.String("custom_logs", "", "JSON file containing additional logs to monitor, such as private logs, along with the others")
+var logListURL = flag.String("log_list", "", "Monitor the logs in this v3 log list (URL or filename, or chrome or apple for their lists)")
+var logListKey = flag.String("log_list_key", "", "PEM file containing the public key which must have signed the -log_list (the chrome and apple lists are always verified, by default against their built-in keys)")
+var logListSig = flag.String("log_list_sig", "", "URL or filename of the -log_list's signature (default: the list's URL with .json replaced by .sig)")
+var skipExpiredShards = flag.Bool("skip_expired_shards", false, "Don't monitor temporally sharded logs (e.g. 2025h2) whose certificates have all expired")
 var underwater = flag.Bool("underwater", false, "Monitor certificates from distrusted CAs instead of trusted CAs")
 var noSave = flag.Bool("no_save", false, "Do not save a copy of matching certificates")
-var verbose = flag.Bool("verbose", false, "Be verbose")
+var forceUnlock = flag.Bool("force_unlock", false, "Remove the lock on the state before starting, if an instance which crashed left it behind (make sure no other instance is using the state)")
+var verbose = flag.Bool("verbose", false, "Be verbose (same as -log_level debug)")
+var logLevel = flag.String("log_level", "info", "Minimum level of messages to log (debug, info, warn, or error)")
+var logFormat = flag.String("log_format", "text", "Format of log messages (text, or json for one JSON object per line)")
 var allTime = flag.Bool("all_time", false, "Scan certs from all time, not just since last scan")
-var state *State
+var onlyPrecerts = flag.Bool("only_precerts", false, "Only process precertificates, not final certificates")
+var onlyCerts = flag.Bool("only_certs", false, "Only process final certificates, not precertificates")
+var compressFlag = flag.String("compress", "", "Compress saved certificates, archives, and evidence with this algorithm (gzip or zstd)")
+var pairPrecerts = flag.Bool("pair_precerts", false, "Don't report a certificate if its precertificate was already reported, or vice-versa")
+var duplicateSerials = flag.Bool("duplicate_serials", false, "Remember the issuer and serial number of matching certificates, and flag any matching certificate whose serial number its issuer already used for a different matching certificate (non-matching certificates aren't remembered)")
+var dedupFlag = flag.Bool("dedup", false, "Report each certificate once, after scanning all logs, listing every log it was found in")
+var sthRefresh = flag.Int("sth_refresh", 10, "During long scans, fetch the log's latest STH this often, in minutes, and keep scanning up to it (0 to disable)")
+var brChecks = flag.Bool("br_checks", false, "Check matching certificates for violations of the CA/Browser Forum Baseline Requirements and include them in reports")
+var ocspFlag = flag.Bool("ocsp", false, "Query the OCSP responder of each matching certificate and include its revocation status in reports")
+var ocspRateLimit = flag.Float64("ocsp_rate_limit", 1, "With -ocsp, maximum number of requests per second to each OCSP responder (0 for no limit)")
+var dnsProbe = flag.Bool("dns_probe", false, "Resolve the DNS names of each matching certificate and include whether they exist and their addresses in reports")
+var dnsProbeResolver = flag.String("dns_probe_resolver", "", "DNS resolver (HOST:PORT) for -dns_probe (default: the system's resolver)")
+var pollinationServer = flag.String("sth_pollination", "", "Base URL of an STH pollination server with which to exchange STHs")
+var state certspotter.Store
+var monitoredLogs []certspotter.LogInfo
+var dedup *certspotter.Deduplicator
+var ocspChecker *certspotter.OCSPChecker // for -ocsp
+var dnsProber *certspotter.DNSProber     // for -dns_probe
 
 var printMutex sync.Mutex
 
@@ -55,24 +92,180 @@ func DefaultConfigDir(programName string) string {
 	return filepath.Join(homedir(), "."+programName)
 }
 
-func LogEntry(info *certspotter.EntryInfo) {
+// entryPipeline processes the entries passed to LogEntry; it's built by
+// makeEntryPipeline once the command line has been processed
+var entryPipeline func(*certspotter.EntryInfo)
+
+func makeEntryPipeline() func(*certspotter.EntryInfo) {
+	return makeProfilePipeline(state, reportEntry)
+}
+
+// makeProfilePipeline makes a pipeline which saves entries in |store| and
+// reports them with |report|
+func makeProfilePipeline(store certspotter.Store, report func(*certspotter.EntryInfo)) func(*certspotter.EntryInfo) {
+	var stages []certspotter.Stage
+	if *brChecks {
+		stages = append(stages, certspotter.ProcessStage(checkBaselineRequirements))
+	}
+	if ocspChecker != nil {
+		stages = append(stages, certspotter.ProcessStage(checkRevocation))
+	}
+	if chainValidator != nil {
+		stages = append(stages, certspotter.ProcessStage(validateChain))
+	}
+	if dnsProber != nil {
+		stages = append(stages, certspotter.ProcessStage(probeDNSNames))
+	}
+	if dedup != nil {
+		stages = append(stages, certspotter.DedupStage(dedup))
+	}
 	if !*noSave {
+		stages = append(stages, saveStage(store))
+	}
+	if archive != nil {
+		stages = append(stages, certspotter.ProcessStage(archiveEntry))
+	}
+	stages = append(stages, certspotter.ProcessStage(recordPendingSCTs))
+	if *duplicateSerials {
+		stages = append(stages, certspotter.ProcessStage(serialChecker(store.(certspotter.SerialStore))))
+	}
+	if *pairPrecerts {
+		stages = append(stages, pairStage(store.(certspotter.IssuanceStore)))
+	}
+	if dedup == nil {
+		// Otherwise, entries are reported when the Deduplicator is flushed
+		stages = append(stages, certspotter.ProcessStage(report))
+	}
+	return certspotter.Pipeline(stages...)
+}
+
+func LogEntry(info *certspotter.EntryInfo) {
+	entryPipeline(info)
+}
+
+// checkBaselineRequirements sets the entry's Violations, for -br_checks
+func checkBaselineRequirements(info *certspotter.EntryInfo) {
+	if info.CertInfo != nil {
+		info.Violations = certspotter.BRViolations(info.CertInfo)
+	}
+}
+
+// checkRevocation sets the entry's Revocation, for -ocsp
+func checkRevocation(info *certspotter.EntryInfo) {
+	info.Revocation = ocspChecker.Check(info)
+}
+
+// probeDNSNames sets the entry's DNSStatuses, for -dns_probe
+func probeDNSNames(info *certspotter.EntryInfo) {
+	info.DNSStatuses = dnsProber.Probe(info)
+}
+
+// saveStage saves the certificate in |store|, and drops the entry if it was
+// saved before, since it has already been reported
+func saveStage(store certspotter.Store) certspotter.Stage {
+	return func(info *certspotter.EntryInfo, next func(*certspotter.EntryInfo)) {
 		var alreadyPresent bool
 		var err error
-		alreadyPresent, info.Filename, err = state.SaveCert(info.IsPrecert, info.FullChain)
+		alreadyPresent, info.Filename, err = store.SaveCert(info.IsPrecert, info.FullChain)
 		if err != nil {
-			log.Print(err)
+			slog.Error(err.Error(), "fingerprint", info.Fingerprint())
 		}
 		if alreadyPresent {
+			if dedup != nil {
+				dedup.Suppress(info)
+			}
 			return
 		}
+		next(info)
 	}
+}
 
-	if *script != "" {
-		if err := info.InvokeHookScript(*script); err != nil {
-			log.Print(err)
+func archiveEntry(info *certspotter.EntryInfo) {
+	if err := archive.Upload(info); err != nil {
+		slog.Error(err.Error(), "fingerprint", info.Fingerprint())
+	}
+}
+
+// pairStage drops the entry if the other half of its precertificate/
+// certificate pair has already been seen
+func pairStage(store certspotter.IssuanceStore) certspotter.Stage {
+	return func(info *certspotter.EntryInfo, next func(*certspotter.EntryInfo)) {
+		if isPaired(store, info) {
+			if dedup != nil {
+				dedup.Suppress(info)
+			}
+			return
 		}
-	} else {
+		next(info)
+	}
+}
+
+// isPaired returns true if the other half of the precertificate/certificate
+// pair for |info|'s issuance has already been seen
+func isPaired(store certspotter.IssuanceStore, info *certspotter.EntryInfo) bool {
+	key := info.IssuanceKey()
+	if key == nil {
+		return false
+	}
+	first, err := store.SaveIssuance(key, info.Fingerprint())
+	if err != nil {
+		slog.Error("Error saving issuance", "fingerprint", info.Fingerprint(), "error", err)
+		return false
+	}
+	return first != ""
+}
+
+// serialChecker returns a function which records the entry's issuer and
+// serial number in |store|, and adds a MatchDuplicateSerial match if they
+// were already used by a different certificate, for -duplicate_serials.
+// It's a stage of the pipeline, which only matching entries go through, so
+// only duplicates among matching certificates are detected; recording every
+// scanned entry would take far too much space.
+func serialChecker(store certspotter.SerialStore) func(*certspotter.EntryInfo) {
+	return func(info *certspotter.EntryInfo) {
+		key, issuanceKey := info.SerialKey(), info.IssuanceKey()
+		if key == nil || issuanceKey == nil {
+			return
+		}
+		other, err := store.SaveSerial(key, issuanceKey, info.Fingerprint())
+		if err != nil {
+			slog.Error("Error saving serial number", "fingerprint", info.Fingerprint(), "error", err)
+			return
+		}
+		if other != "" {
+			slog.Warn("Duplicate serial number", "fingerprint", info.Fingerprint(), "other_fingerprint", other)
+			info.Matches = append(info.Matches, certspotter.DuplicateSerialMatch(info, other))
+		}
+	}
+}
+
+func reportEntry(info *certspotter.EntryInfo) {
+	notifiersLock.RLock()
+	defer notifiersLock.RUnlock()
+	reportEntryTo(info, notifiers, *script)
+}
+
+// reportEntryTo reports |info| to the sinks and |notifiers|, and to |script|
+// or else standard out
+func reportEntryTo(info *certspotter.EntryInfo, notifiers []certspotter.Notifier, script string) {
+	collector.Add(metricMatches, 1, "log", info.LogUri)
+	for _, category := range info.MatchCategories() {
+		collector.Add(metricMatchCategories, 1, "log", info.LogUri, "category", category)
+	}
+	writeToSinks(info)
+	if matchBroadcaster != nil {
+		matchBroadcaster.Write(info)
+	}
+	notifyAll(notifiers, info)
+	if revocationStore != nil && !info.IsRevocationFollowUp() {
+		watchForRevocation(info)
+	}
+
+	if script != "" {
+		if err := info.InvokeHookScript(script); err != nil {
+			slog.Error(err.Error(), "fingerprint", info.Fingerprint())
+		}
+	} else if !sinksUseStdout {
 		printMutex.Lock()
 		info.Write(os.Stdout)
 		fmt.Fprintf(os.Stdout, "\n")
@@ -81,12 +274,73 @@ func LogEntry(info *certspotter.EntryInfo) {
 }
 
 func loadLogList() ([]certspotter.LogInfo, error) {
-	if *logsFilename != "" {
-		var logFileObj certspotter.LogInfoFile
-		if err := readJSONFile(*logsFilename, &logFileObj); err != nil {
-			return nil, fmt.Errorf("Error reading logs file: %s: %s", *logsFilename, err)
+	logs, err := readLogList()
+	if err != nil {
+		return nil, err
+	}
+	var monitored []certspotter.LogInfo
+	now := time.Now()
+	for _, logInfo := range logs {
+		if !logInfo.IsMonitored() {
+			slog.Debug("Not monitoring log", "log", logInfo.FullURI(), "state", logInfo.State)
+		} else if *skipExpiredShards && logInfo.IsExpiredShard(now) {
+			slog.Debug("Not monitoring log for expired certificates", "log", logInfo.FullURI(), "shard_end", logInfo.Shard().EndExclusive)
+		} else {
+			monitored = append(monitored, logInfo)
+		}
+	}
+	return monitored, nil
+}
+
+func readLogList() ([]certspotter.LogInfo, error) {
+	logs, err := readPublicLogList()
+	if err != nil {
+		return nil, err
+	}
+	if *customLogsFilename == "" {
+		return logs, nil
+	}
+	customLogs, err := readLogsFile(*customLogsFilename)
+	if err != nil {
+		return nil, err
+	}
+	for i := range customLogs {
+		if customLogs[i].Url == "" || customLogs[i].Key == nil {
+			return nil, fmt.Errorf("Error reading logs file: %s: every log needs a url and key", *customLogsFilename)
+		}
+		if _, err := customLogs[i].ParsedPublicKey(); err != nil {
+			return nil, fmt.Errorf("Error reading logs file: %s: %s: invalid key: %s", *customLogsFilename, customLogs[i].Url, err)
 		}
-		return logFileObj.Logs, nil
+		customLogs[i].Url = strings.TrimSuffix(strings.TrimPrefix(customLogs[i].Url, "https://"), "/")
+		if customLogs[i].MMD == 0 {
+			customLogs[i].MMD = 86400
+		}
+	}
+	return certspotter.MergeLogs(logs, customLogs), nil
+}
+
+func readPublicLogList() ([]certspotter.LogInfo, error) {
+	if *logListURL != "" && (*logsFilename != "" || *underwater) {
+		return nil, fmt.Errorf("-log_list can't be used with -logs or -underwater")
+	}
+	if *logListURL == "" && (*logListKey != "" || *logListSig != "") {
+		return nil, fmt.Errorf("-log_list_key and -log_list_sig require -log_list")
+	}
+	if *logListURL != "" {
+		url := *logListURL
+		switch url {
+		case "chrome":
+			url = loglist.ChromeURL
+		case "apple":
+			url = loglist.AppleURL
+		}
+		list, err := fetchLogList(url)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading log list: %w", err)
+		}
+		return list.LogInfos(), nil
+	} else if *logsFilename != "" {
+		return readLogsFile(*logsFilename)
 	} else if *underwater {
 		return certspotter.UnderwaterLogs, nil
 	} else {
@@ -94,25 +348,95 @@ func loadLogList() ([]certspotter.LogInfo, error) {
 	}
 }
 
+func readLogsFile(filename string) ([]certspotter.LogInfo, error) {
+	var logFileObj certspotter.LogInfoFile
+	if err := readJSONFile(filename, &logFileObj); err != nil {
+		return nil, fmt.Errorf("Error reading logs file: %s: %s", filename, err)
+	}
+	for _, logInfo := range logFileObj.Logs {
+		if logInfo.CTVersion < 0 || logInfo.CTVersion > 2 {
+			return nil, fmt.Errorf("Error reading logs file: %s: %s: unsupported ct_version %d", filename, logInfo.Url, logInfo.CTVersion)
+		}
+		if logInfo.Tiled && logInfo.CTVersion == 2 {
+			return nil, fmt.Errorf("Error reading logs file: %s: %s: tiled logs implement RFC 6962, not ct_version 2", filename, logInfo.Url)
+		}
+	}
+	return logFileObj.Logs, nil
+}
+
+// Whether the warning about not verifying the log list has been logged
+var warnedUnverifiedLogList bool
+
+// fetchLogList fetches the log list at |url|, checking its signature by the
+// -log_list_key, or by the key built in for Google's and Apple's lists.  The
+// log list determines which log keys are trusted, so a list which isn't
+// properly signed is refused, as is a well-known list which can't be
+// verified.
+func fetchLogList(url string) (*loglist.List, error) {
+	var key crypto.PublicKey
+	if *logListKey != "" {
+		keyPEM, err := ioutil.ReadFile(*logListKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading log list key: %s", err)
+		}
+		key, _, _, err = ct.PublicKeyFromPEM(keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading log list key: %s: %s", *logListKey, err)
+		}
+	} else {
+		var err error
+		key, err = loglist.PinnedKey(url)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if key == nil {
+		if loglist.IsWellKnown(url) {
+			return nil, fmt.Errorf("%s: no key for verifying this list is built in, so it must be specified with -log_list_key (Google publishes its key at %s)", url, loglist.ChromeKeyURL)
+		}
+		if !warnedUnverifiedLogList {
+			slog.Warn("Not verifying the log list's signature, since -log_list_key isn't specified", "log_list", url)
+			warnedUnverifiedLogList = true
+		}
+		return loglist.Fetch(url)
+	}
+	signatureURL := *logListSig
+	if signatureURL == "" {
+		signatureURL = loglist.SignatureURL(url)
+	}
+	return loglist.FetchSigned(url, signatureURL, key)
+}
+
 type logHandle struct {
+	logInfo     *certspotter.LogInfo
 	scanner     *certspotter.Scanner
-	state       *LogState
+	state       certspotter.LogStore
 	tree        *certspotter.CollapsedMerkleTree
 	verifiedSTH *ct.SignedTreeHead
+	latestSTH   *ct.SignedTreeHead
+	logger      *slog.Logger
 }
 
 func makeLogHandle(logInfo *certspotter.LogInfo) (*logHandle, error) {
 	ctlog := new(logHandle)
+	ctlog.logInfo = logInfo
+	ctlog.logger = slog.With("log", logInfo.FullURI())
 
 	logKey, err := logInfo.ParsedPublicKey()
 	if err != nil {
 		return nil, fmt.Errorf("Bad public key: %s", err)
 	}
-	ctlog.scanner = certspotter.NewScanner(logInfo.FullURI(), logInfo.ID(), logKey, &certspotter.ScannerOptions{
-		BatchSize:  *batchSize,
-		NumWorkers: *numWorkers,
-		Quiet:      !*verbose,
-	})
+	opts := scannerOptions(logInfo)
+	switch {
+	case logInfo.Tiled:
+		logClient := tiled.New(logInfo.FullURI(), logInfo.ID(), opts.Timeout)
+		ctlog.scanner = certspotter.NewScannerWithClient(logInfo.FullURI(), logInfo.ID(), logKey, logClient, opts)
+	case logInfo.CTVersion == 2:
+		logClient := ctv2.New(logInfo.FullURI(), opts.Timeout)
+		ctlog.scanner = certspotter.NewScannerWithClient(logInfo.FullURI(), logInfo.ID(), logKey, logClient, opts)
+	default:
+		ctlog.scanner = certspotter.NewScanner(logInfo.FullURI(), logInfo.ID(), logKey, opts)
+	}
 
 	ctlog.state, err = state.OpenLogState(logInfo)
 	if err != nil {
@@ -127,13 +451,13 @@ func makeLogHandle(logInfo *certspotter.LogInfo) (*logHandle, error) {
 		return nil, fmt.Errorf("Error loading verified STH: %s", err)
 	}
 
-	if ctlog.tree == nil && ctlog.verifiedSTH == nil { // This branch can be removed eventually
-		legacySTH, err := state.GetLegacySTH(logInfo)
+	if fsState, isFS := state.(*State); isFS && ctlog.tree == nil && ctlog.verifiedSTH == nil { // This branch can be removed eventually
+		legacySTH, err := fsState.GetLegacySTH(logInfo)
 		if err != nil {
 			return nil, fmt.Errorf("Error loading legacy STH: %s", err)
 		}
 		if legacySTH != nil {
-			log.Printf("Initializing log state from legacy state directory")
+			ctlog.logger.Info("Initializing log state from legacy state directory")
 			ctlog.tree, err = ctlog.scanner.MakeCollapsedMerkleTree(legacySTH)
 			if err != nil {
 				return nil, fmt.Errorf("Error reconstructing Merkle Tree for legacy STH: %s", err)
@@ -144,7 +468,7 @@ func makeLogHandle(logInfo *certspotter.LogInfo) (*logHandle, error) {
 			if err := ctlog.state.StoreVerifiedSTH(legacySTH); err != nil {
 				return nil, fmt.Errorf("Error storing verified STH: %s", err)
 			}
-			state.RemoveLegacySTH(logInfo)
+			fsState.RemoveLegacySTH(logInfo)
 		}
 	}
 
@@ -152,17 +476,15 @@ func makeLogHandle(logInfo *certspotter.LogInfo) (*logHandle, error) {
 }
 
 func (ctlog *logHandle) refresh() error {
-	if *verbose {
-		log.Printf("Retrieving latest STH from log")
-	}
+	ctlog.logger.Debug("Retrieving latest STH from log")
 	latestSTH, err := ctlog.scanner.GetSTH()
 	if err != nil {
-		return fmt.Errorf("Error retrieving STH from log: %s", err)
+		return fmt.Errorf("Error retrieving STH from log: %w", err)
 	}
+	journalSTH(ctlog.logInfo, latestSTH)
+	ctlog.latestSTH = latestSTH
 	if ctlog.verifiedSTH == nil {
-		if *verbose {
-			log.Printf("No existing STH is known; presuming latest STH (%d) is valid", latestSTH.TreeSize)
-		}
+		ctlog.logger.Debug("No existing STH is known; presuming latest STH is valid", "tree_size", latestSTH.TreeSize)
 		ctlog.verifiedSTH = latestSTH
 		if err := ctlog.state.StoreVerifiedSTH(ctlog.verifiedSTH); err != nil {
 			return fmt.Errorf("Error storing verified STH: %s", err)
@@ -175,6 +497,22 @@ func (ctlog *logHandle) refresh() error {
 	return nil
 }
 
+// Save evidence of the log's misbehavior and return an error describing it
+func (ctlog *logHandle) misbehavior(evidence *certspotter.Evidence) error {
+	filename, err := state.StoreEvidence(evidence)
+	if err != nil {
+		return fmt.Errorf("Log has misbehaved: %s (unable to save evidence: %s)", evidence.Description, err)
+	}
+	return fmt.Errorf("Log has misbehaved: %s (evidence saved in '%s')", evidence.Description, filename)
+}
+
+func (ctlog *logHandle) inconsistentSTHs(sth *ct.SignedTreeHead, proof ct.ConsistencyProof) error {
+	description := fmt.Sprintf("STH %d (%x) is not consistent with STH %d (%x)", ctlog.verifiedSTH.TreeSize, ctlog.verifiedSTH.SHA256RootHash, sth.TreeSize, sth.SHA256RootHash)
+	evidence := certspotter.NewEvidence(certspotter.EvidenceInconsistentSTHs, description, ctlog.scanner.LogUri, ctlog.verifiedSTH, sth)
+	evidence.Consistency = proof
+	return ctlog.misbehavior(evidence)
+}
+
 func (ctlog *logHandle) audit() error {
 	sths, err := ctlog.state.GetUnverifiedSTHs()
 	if err != nil {
@@ -182,35 +520,31 @@ func (ctlog *logHandle) audit() error {
 	}
 
 	for _, sth := range sths {
-		if *verbose {
-			log.Printf("Verifying consistency between STH %d (%x) and STH %d (%x)", sth.TreeSize, sth.SHA256RootHash, ctlog.verifiedSTH.TreeSize, ctlog.verifiedSTH.SHA256RootHash)
-		}
+		ctlog.logger.Debug("Verifying consistency between STHs", "tree_size", sth.TreeSize, "root_hash", sth.SHA256RootHash[:], "verified_tree_size", ctlog.verifiedSTH.TreeSize, "verified_root_hash", ctlog.verifiedSTH.SHA256RootHash[:])
 		if sth.TreeSize > ctlog.verifiedSTH.TreeSize {
-			isValid, err := ctlog.scanner.CheckConsistency(ctlog.verifiedSTH, sth)
+			isValid, proof, err := ctlog.scanner.CheckConsistencyWithProof(ctlog.verifiedSTH, sth)
 			if err != nil {
-				return fmt.Errorf("Error fetching consistency proof between %d and %d (if this error persists, it should be construed as misbehavior by the log): %s", ctlog.verifiedSTH.TreeSize, sth.TreeSize, err)
+				return fmt.Errorf("Error fetching consistency proof between %d and %d (if this error persists, it should be construed as misbehavior by the log): %w", ctlog.verifiedSTH.TreeSize, sth.TreeSize, err)
 			}
 			if !isValid {
-				return fmt.Errorf("Log has misbehaved: STH in '%s' is not consistent with STH in '%s'", ctlog.state.VerifiedSTHFilename(), ctlog.state.UnverifiedSTHFilename(sth))
-			}
-			if *verbose {
-				log.Printf("STH %d (%x) is now the latest verified STH", sth.TreeSize, sth.SHA256RootHash)
+				return ctlog.inconsistentSTHs(sth, proof)
 			}
+			ctlog.logger.Debug("STH is now the latest verified STH", "tree_size", sth.TreeSize, "root_hash", sth.SHA256RootHash[:])
 			ctlog.verifiedSTH = sth
 			if err := ctlog.state.StoreVerifiedSTH(ctlog.verifiedSTH); err != nil {
 				return fmt.Errorf("Error storing verified STH: %s", err)
 			}
 		} else if sth.TreeSize < ctlog.verifiedSTH.TreeSize {
-			isValid, err := ctlog.scanner.CheckConsistency(sth, ctlog.verifiedSTH)
+			isValid, proof, err := ctlog.scanner.CheckConsistencyWithProof(sth, ctlog.verifiedSTH)
 			if err != nil {
-				return fmt.Errorf("Error fetching consistency proof between %d and %d (if this error persists, it should be construed as misbehavior by the log): %s", ctlog.verifiedSTH.TreeSize, sth.TreeSize, err)
+				return fmt.Errorf("Error fetching consistency proof between %d and %d (if this error persists, it should be construed as misbehavior by the log): %w", ctlog.verifiedSTH.TreeSize, sth.TreeSize, err)
 			}
 			if !isValid {
-				return fmt.Errorf("Log has misbehaved: STH in '%s' is not consistent with STH in '%s'", ctlog.state.VerifiedSTHFilename(), ctlog.state.UnverifiedSTHFilename(sth))
+				return ctlog.inconsistentSTHs(sth, proof)
 			}
 		} else {
 			if !bytes.Equal(sth.SHA256RootHash[:], ctlog.verifiedSTH.SHA256RootHash[:]) {
-				return fmt.Errorf("Log has misbehaved: STH in '%s' is not consistent with STH in '%s'", ctlog.state.VerifiedSTHFilename(), ctlog.state.UnverifiedSTHFilename(sth))
+				return ctlog.inconsistentSTHs(sth, nil)
 			}
 		}
 		if err := ctlog.state.RemoveUnverifiedSTH(sth); err != nil {
@@ -221,133 +555,526 @@ func (ctlog *logHandle) audit() error {
 	return nil
 }
 
+// isFinished returns true if the log is read-only and has been scanned up
+// to its final tree head
+func (ctlog *logHandle) isFinished() bool {
+	final := ctlog.logInfo.FinalTreeHead
+	return final != nil && ctlog.tree != nil && ctlog.tree.GetSize() >= final.TreeSize
+}
+
+// checkFinalTreeHead checks that a read-only log's latest STH matches the
+// final tree head in the log list
+func (ctlog *logHandle) checkFinalTreeHead() error {
+	final := ctlog.logInfo.FinalTreeHead
+	sth := ctlog.latestSTH
+	if sth.TreeSize < final.TreeSize {
+		return fmt.Errorf("Read-only log returned STH %d, which is smaller than its final tree head %d", sth.TreeSize, final.TreeSize)
+	}
+	if sth.TreeSize == final.TreeSize && bytes.Equal(sth.SHA256RootHash[:], final.SHA256RootHash) {
+		return nil
+	}
+	description := fmt.Sprintf("STH %d (%x) of read-only log does not match its final tree head %d (%x)", sth.TreeSize, sth.SHA256RootHash, final.TreeSize, final.SHA256RootHash)
+	return ctlog.misbehavior(certspotter.NewEvidence(certspotter.EvidenceFinalTreeHead, description, ctlog.scanner.LogUri, sth))
+}
+
+// With -sth_refresh, scans are done this many entries at a time, so that
+// the STH can be refreshed in between
+const scanSegmentSize = 100000
+
+// scan scans the log up to the verified STH.  With -sth_refresh, the STH is
+// refreshed every so often during a long scan, and once it's been verified
+// to be consistent, the scan continues up to it, so that the scan finishes
+// at the log's latest tree size rather than the one when it started.
 func (ctlog *logHandle) scan(processCallback certspotter.ProcessCallback) error {
-	startIndex := int64(ctlog.tree.GetSize())
-	endIndex := int64(ctlog.verifiedSTH.TreeSize)
+	refreshInterval := time.Duration(*sthRefresh) * time.Minute
+	// A read-only log doesn't grow
+	refreshing := refreshInterval > 0 && ctlog.logInfo.FinalTreeHead == nil
+	lastRefresh := time.Now()
+	verifiedStart := ctlog.tree.GetSize()
+
+	for {
+		startIndex := int64(ctlog.tree.GetSize())
+		endIndex := int64(ctlog.verifiedSTH.TreeSize)
+		if endIndex <= startIndex {
+			return nil
+		}
+		segmentEnd := endIndex
+		if refreshing && endIndex-startIndex > scanSegmentSize {
+			segmentEnd = startIndex + scanSegmentSize
+		}
 
-	if endIndex > startIndex {
 		tree := certspotter.CloneCollapsedMerkleTree(ctlog.tree)
 
-		if err := ctlog.scanner.Scan(startIndex, endIndex, processCallback, tree); err != nil {
-			return fmt.Errorf("Error scanning log (if this error persists, it should be construed as misbehavior by the log): %s", err)
+		if err := ctlog.scanner.Scan(startIndex, segmentEnd, processCallback, tree); err == certspotter.ErrScanStopped {
+			// Every entry in the tree has been processed, so save our
+			// progress; the root is checked once the scan is resumed
+			ctlog.tree = tree
+			if err := ctlog.state.StoreTree(ctlog.tree); err != nil {
+				return fmt.Errorf("Error storing tree: %s", err)
+			}
+			return err
+		} else if err != nil {
+			return fmt.Errorf("Error scanning log (if this error persists, it should be construed as misbehavior by the log): %w", err)
 		}
 
-		rootHash := tree.CalculateRoot()
-		if !bytes.Equal(rootHash, ctlog.verifiedSTH.SHA256RootHash[:]) {
-			return fmt.Errorf("Log has misbehaved: log entries at tree size %d do not correspond to signed tree root", ctlog.verifiedSTH.TreeSize)
+		if segmentEnd == endIndex {
+			rootHash := tree.CalculateRoot()
+			if !bytes.Equal(rootHash, ctlog.verifiedSTH.SHA256RootHash[:]) {
+				description := fmt.Sprintf("log entries at tree size %d do not correspond to signed tree root", ctlog.verifiedSTH.TreeSize)
+				evidence := certspotter.NewEvidence(certspotter.EvidenceBadEntries, description, ctlog.scanner.LogUri, ctlog.verifiedSTH)
+				evidence.Tree = ctlog.tree
+				if entry, auditPath, before, err := ctlog.scanner.FindBadEntry(ctlog.tree, ctlog.verifiedSTH); err != nil {
+					ctlog.logger.Warn("Unable to find the bad entry", "error", err, "error_class", client.Classify(err))
+				} else if entry != nil {
+					evidence.AddEntry(entry)
+					evidence.AuditPath = auditPath
+					evidence.Tree = before
+				}
+				return ctlog.misbehavior(evidence)
+			}
+			journalVerifiedRange(ctlog.logInfo, verifiedStart, ctlog.verifiedSTH)
+			verifiedStart = uint64(endIndex)
 		}
 
 		ctlog.tree = tree
 		if err := ctlog.state.StoreTree(ctlog.tree); err != nil {
 			return fmt.Errorf("Error storing tree: %s", err)
 		}
+		recordScanPosition(ctlog.logInfo, ctlog.tree.GetSize())
+
+		if refreshing && time.Since(lastRefresh) >= refreshInterval {
+			if err := ctlog.refreshDuringScan(); err != nil {
+				return err
+			}
+			lastRefresh = time.Now()
+		}
 	}
+}
 
+// refreshDuringScan fetches the latest STH and verifies it, so that the scan
+// can continue up to it.  If the STH can't be fetched, the scan carries on
+// to the old one.
+func (ctlog *logHandle) refreshDuringScan() error {
+	oldSize := ctlog.verifiedSTH.TreeSize
+	if err := ctlog.refresh(); err != nil {
+		ctlog.logger.Warn("Not refreshing STH during scan", "error", err, "error_class", client.Classify(err))
+		return nil
+	}
+	recordSTHFetch(ctlog.logInfo, ctlog.latestSTH)
+	if err := ctlog.audit(); err != nil {
+		return err
+	}
+	recordVerifiedSTH(ctlog.logInfo, ctlog.verifiedSTH)
+	if ctlog.verifiedSTH.TreeSize > oldSize {
+		ctlog.logger.Debug("Extending scan to latest STH", "old_tree_size", oldSize, "tree_size", ctlog.verifiedSTH.TreeSize)
+	}
 	return nil
 }
 
-func processLog(logInfo *certspotter.LogInfo, processCallback certspotter.ProcessCallback) int {
-	log.SetPrefix(os.Args[0] + ": " + logInfo.Url + ": ")
+// logError logs |err|, with its class (see client.Classify), and records
+// the class in the log's status
+func (ctlog *logHandle) logError(err error) {
+	class := client.Classify(err)
+	ctlog.logger.Error(err.Error(), "error_class", class)
+	recordErrorClass(ctlog.logInfo, class)
+}
 
+// processLog scans |logInfo| for new entries, or for all of its entries if
+// |scanAllTime| is true, and returns the exit code
+func processLog(logInfo *certspotter.LogInfo, processCallback certspotter.ProcessCallback, scanAllTime bool) int {
 	ctlog, err := makeLogHandle(logInfo)
 	if err != nil {
-		log.Printf("%s\n", err)
+		slog.Error(err.Error(), "log", logInfo.FullURI())
 		return 1
 	}
+	logger := ctlog.logger
+
+	if ctlog.isFinished() && !scanningTimeRange() && !scanAllTime {
+		logger.Debug("Read-only log has been scanned up to its final tree head", "tree_size", logInfo.FinalTreeHead.TreeSize)
+		recordScanPosition(logInfo, ctlog.tree.GetSize())
+		return 0
+	}
 
 	if err := ctlog.refresh(); err != nil {
-		log.Printf("%s\n", err)
+		ctlog.logError(err)
+		return 1
+	}
+	recordSTHFetch(logInfo, ctlog.latestSTH)
+	if ctlog.tree != nil {
+		recordScanPosition(logInfo, ctlog.tree.GetSize())
+	}
+
+	exitCode := 0
+	if logInfo.FinalTreeHead == nil {
+		// A read-only log's STH isn't refreshed once it's frozen, so
+		// its age doesn't matter
+		if err := ctlog.checkSTHAge(); err != nil {
+			ctlog.logError(err)
+			exitCode = 1
+		}
+	} else if err := ctlog.checkFinalTreeHead(); err != nil {
+		ctlog.logError(err)
 		return 1
 	}
 
 	if err := ctlog.audit(); err != nil {
-		log.Printf("%s\n", err)
+		ctlog.logError(err)
 		return 1
 	}
+	recordVerifiedSTH(logInfo, ctlog.verifiedSTH)
 
-	if *allTime {
-		ctlog.tree = certspotter.EmptyCollapsedMerkleTree()
-		if *verbose {
-			log.Printf("Scanning all %d entries in the log because -all_time option specified", ctlog.verifiedSTH.TreeSize)
+	if err := ctlog.checkPendingSCTs(); err != nil {
+		ctlog.logError(err)
+		exitCode = 1
+	}
+
+	if scanningTimeRange() {
+		if err := ctlog.scanTimeRange(processCallback); err == certspotter.ErrScanStopped {
+			logger.Info("Stopped scanning time range")
+		} else if err != nil {
+			ctlog.logError(err)
+			return 1
 		}
+		return exitCode
+	}
+
+	if scanAllTime {
+		ctlog.tree = certspotter.EmptyCollapsedMerkleTree()
+		logger.Debug("Scanning all entries in the log because -all_time option specified", "tree_size", ctlog.verifiedSTH.TreeSize)
 	} else if ctlog.tree != nil {
-		if *verbose {
-			log.Printf("Existing log; scanning %d new entries since previous scan", ctlog.verifiedSTH.TreeSize-ctlog.tree.GetSize())
-		}
+		logger.Debug("Existing log; scanning new entries since previous scan", "start", ctlog.tree.GetSize(), "end", ctlog.verifiedSTH.TreeSize)
 	} else if state.IsFirstRun() {
 		ctlog.tree, err = ctlog.scanner.MakeCollapsedMerkleTree(ctlog.verifiedSTH)
 		if err != nil {
-			log.Printf("Error reconstructing Merkle Tree: %s", err)
+			ctlog.logError(fmt.Errorf("Error reconstructing Merkle Tree: %w", err))
 			return 1
 		}
-		if *verbose {
-			log.Printf("First run of Cert Spotter; not scanning %d existing entries because -all_time option not specified", ctlog.verifiedSTH.TreeSize)
-		}
+		logger.Debug("First run of Cert Spotter; not scanning existing entries because -all_time option not specified", "tree_size", ctlog.verifiedSTH.TreeSize)
 	} else {
-		ctlog.tree = certspotter.EmptyCollapsedMerkleTree()
-		if *verbose {
-			log.Printf("New log; scanning all %d entries in the log", ctlog.verifiedSTH.TreeSize)
+		ctlog.tree, err = ctlog.makeNewLogTree()
+		if err != nil {
+			ctlog.logError(err)
+			return 1
 		}
 	}
 	if err := ctlog.state.StoreTree(ctlog.tree); err != nil {
-		log.Printf("Error storing tree: %s\n", err)
+		logger.Error("Error storing tree", "error", err)
 		return 1
 	}
 
-	if err := ctlog.scan(processCallback); err != nil {
-		log.Printf("%s\n", err)
+	err = ctlog.scan(processCallback)
+	recordScanPosition(logInfo, ctlog.tree.GetSize())
+	if err == certspotter.ErrScanStopped {
+		logger.Info("Stopped scanning", "scanned_size", ctlog.tree.GetSize(), "tree_size", ctlog.verifiedSTH.TreeSize)
+		return exitCode
+	} else if err != nil {
+		ctlog.logError(err)
 		return 1
 	}
 
-	if *verbose {
-		log.Printf("Final log size = %d, final root hash = %x", ctlog.verifiedSTH.TreeSize, ctlog.verifiedSTH.SHA256RootHash)
+	logger.Debug("Finished scanning log", "tree_size", ctlog.verifiedSTH.TreeSize, "root_hash", ctlog.verifiedSTH.SHA256RootHash[:])
+
+	return exitCode
+}
+
+// scanLogs scans each of |logs| for new entries once, and returns the exit
+// code.  In -daemon mode, the logs are scanned independently of each other
+// (see scanLogsIndependently).
+func scanLogs(logs []certspotter.LogInfo, processCallback certspotter.ProcessCallback) int {
+	exitCode := 0
+	complete := true
+	if *daemonFlag {
+		exitCode, complete = scanLogsIndependently(logs, processCallback, time.Duration(*intervalFlag)*time.Second)
+	} else {
+		for i := range logs {
+			if isStopping() {
+				break
+			}
+			logExitCode := processLog(&logs[i], processCallback, *allTime)
+			recordScanResult(&logs[i], logExitCode)
+			exitCode |= logExitCode
+		}
+	}
+	if dedup != nil {
+		dedup.Flush(reportEntry)
+	}
+	if *pollinationServer != "" {
+		if err := pollinate(*pollinationServer, logs); err != nil {
+			slog.Error(err.Error())
+			exitCode |= 1
+		}
 	}
 
-	return 0
+	// Logs still being scanned for the first time mustn't see the once
+	// file, lest they be treated as newly-added logs
+	if state.IsFirstRun() && exitCode == 0 && complete && !scanningTimeRange() && !isStopping() {
+		if err := state.WriteOnceFile(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Error writing once file: %s\n", os.Args[0], err)
+			exitCode |= 1
+		}
+	}
+	return exitCode
+}
+
+// parseLogLevel parses the value of -log_level
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("Invalid log level `%s' (must be debug, info, warn, or error)", s)
+	}
+}
+
+// formatLogAttr writes errors and other Stringers as their strings, and
+// byte slices (such as hashes) in hex, in both the text and JSON formats
+func formatLogAttr(groups []string, attr slog.Attr) slog.Attr {
+	switch value := attr.Value.Any().(type) {
+	case time.Time:
+		// Formatted by the handler
+	case error:
+		attr.Value = slog.StringValue(value.Error())
+	case fmt.Stringer:
+		attr.Value = slog.StringValue(value.String())
+	case []byte:
+		attr.Value = slog.StringValue(hex.EncodeToString(value))
+	}
+	return attr
+}
+
+// newLogHandler returns the slog.Handler for -log_format, writing messages
+// at |level| and above to |w|
+func newLogHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: formatLogAttr}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.NewTextHandler(w, options), nil
+	case "json":
+		return slog.NewJSONHandler(w, options), nil
+	default:
+		return nil, fmt.Errorf("Invalid log format `%s' (must be text or json)", format)
+	}
+}
+
+// configureLogging applies -log_level, -verbose, and -log_format to the
+// default slog.Logger, through which messages from the standard log
+// package are sent too
+func configureLogging() error {
+	level, err := parseLogLevel(*logLevel)
+	if err != nil {
+		return err
+	}
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	handler, err := newLogHandler(os.Stderr, *logFormat, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
 }
 
 func Main(statePath string, processCallback certspotter.ProcessCallback) int {
+	if err := configureLogging(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	if *verifyJournalFilename != "" {
+		return verifyJournal()
+	}
+	fsState, err := OpenState(statePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	return MainWithStore(fsState, processCallback)
+}
+
+// MainWithStore is like Main, but keeps state in the given Store instead of
+// a state directory.
+func MainWithStore(store certspotter.Store, processCallback certspotter.ProcessCallback) int {
 	var err error
 
+	if err := configureLogging(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+
+	if err := startTracing(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	defer tracing.Disable()
+	if err := startStatsD(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	defer stopStatsD()
+
 	logs, err := loadLogList()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
 		return 1
 	}
+	monitoredLogs = logs
+	recordMonitoredLogs(logs)
+	if err := checkLogConfigFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	if logConfigs, err = loadLogConfigs(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	warnUnknownLogConfigs(logs)
+	if *dedupFlag {
+		dedup = certspotter.NewDeduplicator()
+	}
+	if *ocspFlag {
+		ocspChecker = certspotter.NewOCSPChecker()
+		ocspChecker.RateLimit = *ocspRateLimit
+	}
+	if *dnsProbe {
+		dnsProber = certspotter.NewDNSProber(*dnsProbeResolver)
+	}
+	if err := loadRootStores(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
 
-	state, err = OpenState(statePath)
-	if err != nil {
+	if err := compression.Check(*compressFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	if err := parseTimeRange(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	if err := checkDaemonFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	if err := checkDiscoveryFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	if err := checkHeartbeatFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	if *onlyPrecerts && *onlyCerts {
+		fmt.Fprintf(os.Stderr, "%s: -only_precerts and -only_certs are mutually exclusive\n", os.Args[0])
+		return 1
+	}
+	if err := openArchive(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Error configuring S3 archive: %s\n", os.Args[0], err)
+		return 1
+	}
+	if err := openJournal(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	defer closeJournal()
+	if err := openSinks(); err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
 		return 1
 	}
+	defer closeSinks()
+
+	state = store
+	if err := openCRLRecheck(store); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	if UsingProfiles() {
+		if profiles, err = openProfiles(nil); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+			return 1
+		}
+		processCallback = profilesCallback
+	} else {
+		if notifiers, retries, err = openNotifiers(store); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+			return 1
+		}
+		if _, isIssuanceStore := state.(certspotter.IssuanceStore); *pairPrecerts && !isIssuanceStore {
+			fmt.Fprintf(os.Stderr, "%s: -pair_precerts is not supported by this store\n", os.Args[0])
+			return 1
+		}
+		if _, isSerialStore := state.(certspotter.SerialStore); *duplicateSerials && !isSerialStore {
+			fmt.Fprintf(os.Stderr, "%s: -duplicate_serials is not supported by this store\n", os.Args[0])
+			return 1
+		}
+		entryPipeline = makeEntryPipeline()
+	}
+	defer closeNotifiers()
+	forceUnlockStore, canForceUnlock := state.(certspotter.ForceUnlockStore)
+	if *forceUnlock {
+		if !canForceUnlock {
+			fmt.Fprintf(os.Stderr, "%s: -force_unlock is not supported by this store\n", os.Args[0])
+			return 1
+		}
+		if err := forceUnlockStore.ForceUnlock(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: Error removing lock: %s\n", os.Args[0], err)
+			return 1
+		}
+	}
 	locked, err := state.Lock()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s: Error locking state directory: %s\n", os.Args[0], err)
+		fmt.Fprintf(os.Stderr, "%s: Error locking state: %s\n", os.Args[0], err)
 		return 1
 	}
 	if !locked {
-		var otherPidInfo string
-		if otherPid := state.LockingPid(); otherPid != 0 {
-			otherPidInfo = fmt.Sprintf(" (as process ID %d)", otherPid)
+		var ownerInfo string
+		if ownerStore, ok := state.(certspotter.LockOwnerStore); ok {
+			if owner, err := ownerStore.LockOwner(); err == nil && owner != "" {
+				ownerInfo = fmt.Sprintf(" (process %s holds the lock)", owner)
+			}
+		}
+		if fsState, isFS := state.(*State); isFS && !lockIsAdvisory {
+			fmt.Fprintf(os.Stderr, "%s: Another instance of %s is already running%s; remove the file %s if this is not the case\n", os.Args[0], os.Args[0], ownerInfo, fsState.LockFilename())
+		} else if canForceUnlock {
+			fmt.Fprintf(os.Stderr, "%s: Another instance of %s is already using this state%s; run with -force_unlock to remove the lock if this is not the case\n", os.Args[0], os.Args[0], ownerInfo)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: Another instance of %s is already using this state%s\n", os.Args[0], os.Args[0], ownerInfo)
 		}
-		fmt.Fprintf(os.Stderr, "%s: Another instance of %s is already running%s; remove the file %s if this is not the case\n", os.Args[0], os.Args[0], otherPidInfo, state.LockFilename())
 		return 1
 	}
+	handleSignals()
+	retryNotifications()
 
-	exitCode := 0
-	for i := range logs {
-		exitCode |= processLog(&logs[i], processCallback)
-	}
-
-	if state.IsFirstRun() && exitCode == 0 {
-		if err := state.WriteOnceFile(); err != nil {
-			fmt.Fprintf(os.Stderr, "%s: Error writing once file: %s\n", os.Args[0], err)
-			exitCode |= 1
+	var exitCode int
+	if *daemonFlag {
+		exitCode = runDaemon(logs, processCallback)
+	} else {
+		exitCode = scanLogs(logs, processCallback)
+		recordScanCycle()
+		if crlChecker != nil {
+			recheckCRLs()
+		}
+		if heartbeatsEnabled() {
+			sendHeartbeat()
 		}
 	}
 
+	// Send pending digests while the state is still locked, so that any
+	// which fail can be queued
+	exitCode |= closeNotifiers()
+	exitCode |= closeSinks()
+
 	if err := state.Unlock(); err != nil {
-		fmt.Fprintf(os.Stderr, "%s: Error unlocking state directory: %s\n", os.Args[0], err)
+		fmt.Fprintf(os.Stderr, "%s: Error unlocking state: %s\n", os.Args[0], err)
+		exitCode |= 1
+	}
+	if err := closeStore(state); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Error closing state: %s\n", os.Args[0], err)
 		exitCode |= 1
 	}
 
@@ -0,0 +1,115 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+var crlRecheck = flag.Int("crl_recheck", 0, "Re-check reported certificates against their CRLs this often, in minutes, in -daemon mode (or after each scan otherwise), and report any which have been revoked (0 to disable)")
+
+var crlChecker *certspotter.CRLChecker
+var revocationStore certspotter.RevocationStore // where reported certificates are kept for -crl_recheck
+var lastCRLRecheck time.Time
+
+// openCRLRecheck sets up -crl_recheck, which needs a store that implements
+// certspotter.RevocationStore
+func openCRLRecheck(store certspotter.Store) error {
+	if *crlRecheck == 0 {
+		return nil
+	}
+	if *crlRecheck < 0 {
+		return fmt.Errorf("-crl_recheck must not be negative")
+	}
+	if UsingProfiles() {
+		return fmt.Errorf("-crl_recheck can't be used with -profiles")
+	}
+	var ok bool
+	if revocationStore, ok = store.(certspotter.RevocationStore); !ok {
+		return fmt.Errorf("-crl_recheck is not supported by this store")
+	}
+	crlChecker = certspotter.NewCRLChecker()
+	return nil
+}
+
+// watchForRevocation saves the reported entry so that its CRL is re-checked
+func watchForRevocation(info *certspotter.EntryInfo) {
+	watched := certspotter.NewWatchedCert(info)
+	if watched == nil {
+		return
+	}
+	if err := revocationStore.WatchCert(watched); err != nil {
+		slog.Error("Error saving certificate for CRL re-checks", "fingerprint", watched.Fingerprint, "error", err)
+	}
+}
+
+// crlRecheckDue returns true if it's been -crl_recheck minutes since the
+// watched certificates were last re-checked
+func crlRecheckDue() bool {
+	return crlChecker != nil && time.Since(lastCRLRecheck) >= time.Duration(*crlRecheck)*time.Minute
+}
+
+// recheckCRLs checks each watched certificate against its CRL, and reports
+// it again, with a MatchRevoked match, if it's been revoked.  Certificates
+// stop being watched once they've been revoked or have expired.
+func recheckCRLs() {
+	lastCRLRecheck = time.Now()
+	watchedCerts, err := revocationStore.GetWatchedCerts()
+	if err != nil {
+		slog.Error("Error loading certificates for CRL re-checks", "error", err)
+		return
+	}
+	slog.Debug("Re-checking CRLs", "certificates", len(watchedCerts))
+	for _, watched := range watchedCerts {
+		if isStopping() {
+			return
+		}
+		if time.Now().After(watched.NotAfter) {
+			unwatchCert(watched)
+			continue
+		}
+		info, err := watched.Entry.EntryInfo()
+		if err != nil {
+			slog.Error("Error reconstructing certificate for CRL re-check", "fingerprint", watched.Fingerprint, "error", err)
+			unwatchCert(watched)
+			continue
+		}
+		status := crlChecker.Check(info)
+		if status == nil {
+			continue
+		} else if status.Status == certspotter.OCSPError {
+			slog.Warn("CRL check failed", "fingerprint", watched.Fingerprint, "crl", status.Responder, "error", status.Error)
+			continue
+		} else if status.Status != certspotter.OCSPRevoked {
+			continue
+		}
+		slog.Info("Reported certificate has been revoked", "fingerprint", watched.Fingerprint, "crl", status.Responder)
+		info.Revocation = status
+		info.Matches = append(info.Matches, certspotter.Match{
+			Category: certspotter.MatchRevoked,
+			ID:       certspotter.MatchRevoked,
+			Pattern:  status.Responder,
+			Value:    status.String(),
+		})
+		reportEntry(info)
+		unwatchCert(watched)
+	}
+}
+
+func unwatchCert(watched *certspotter.WatchedCert) {
+	if err := revocationStore.UnwatchCert(watched.Fingerprint); err != nil {
+		slog.Error("Error removing certificate from CRL re-checks", "fingerprint", watched.Fingerprint, "error", err)
+	}
+}
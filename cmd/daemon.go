@@ -0,0 +1,227 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+var daemonFlag = flag.Bool("daemon", false, "Run continuously, scanning the logs for new entries every -interval seconds")
+var intervalFlag = flag.Int("interval", 300, "Seconds to wait between scans in -daemon mode")
+
+func checkDaemonFlags() error {
+	if !*daemonFlag {
+		if *httpAddr != "" {
+			return fmt.Errorf("-http_addr requires -daemon")
+		}
+		if *pprofAddr != "" {
+			return fmt.Errorf("-pprof_addr requires -daemon")
+		}
+		if *grpcAddr != "" {
+			return fmt.Errorf("-grpc_addr requires -daemon")
+		}
+		if *apiTokenFile != "" {
+			return fmt.Errorf("-api_token_file requires -daemon")
+		}
+		return nil
+	}
+	if scanningTimeRange() {
+		return fmt.Errorf("-daemon can't be used with -start_time or -end_time")
+	}
+	if *intervalFlag <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+	return nil
+}
+
+// logScans tracks the logs being scanned in -daemon mode
+var logScans struct {
+	sync.Mutex
+	running map[string]bool // by log URL
+	wg      sync.WaitGroup
+}
+
+// startLogScan marks |logInfo| as being scanned, and returns false if it
+// already was
+func startLogScan(logInfo *certspotter.LogInfo) bool {
+	logScans.Lock()
+	defer logScans.Unlock()
+	if logScans.running == nil {
+		logScans.running = make(map[string]bool)
+	}
+	if logScans.running[logInfo.Url] {
+		return false
+	}
+	logScans.running[logInfo.Url] = true
+	logScans.wg.Add(1)
+	return true
+}
+
+func finishLogScan(logInfo *certspotter.LogInfo) {
+	logScans.Lock()
+	delete(logScans.running, logInfo.Url)
+	logScans.Unlock()
+	logScans.wg.Done()
+}
+
+// scanLogsIndependently scans each of |logs| for new entries concurrently,
+// so that a slow or broken log doesn't hold back the others.  It waits up to
+// |wait| for the scans to finish, and returns the exit code of those which
+// did.  A log whose scan is still running is left to carry on in the
+// background, and isn't scanned again until it finishes; its result is
+// recorded in its status.  |complete| is false if any log wasn't scanned to
+// completion.  If certspotter is shutting down, this waits for every scan to
+// stop.
+func scanLogsIndependently(logs []certspotter.LogInfo, processCallback certspotter.ProcessCallback, wait time.Duration) (exitCode int, complete bool) {
+	complete = true
+	results := make(chan int, len(logs))
+	started := 0
+	scanAllTime := *allTime
+	for i := range logs {
+		logInfo := &logs[i]
+		if !startLogScan(logInfo) {
+			slog.Info("Log is still being scanned; not starting another scan", "log", logInfo.FullURI())
+			complete = false
+			continue
+		}
+		started++
+		go func() {
+			defer finishLogScan(logInfo)
+			recordScanStart(logInfo)
+			logExitCode := processLog(logInfo, processCallback, scanAllTime)
+			recordScanResult(logInfo, logExitCode)
+			results <- logExitCode
+		}()
+	}
+
+	timeout := time.After(wait)
+	for finished := 0; finished < started; {
+		select {
+		case logExitCode := <-results:
+			exitCode |= logExitCode
+			finished++
+		case <-timeout:
+			slog.Info("Not waiting any longer for logs still being scanned", "logs", started-finished)
+			return exitCode, false
+		case <-stopping:
+			// The scans stop after their current batch
+			for ; finished < started; finished++ {
+				exitCode |= <-results
+			}
+		}
+	}
+	return exitCode, complete
+}
+
+// runDaemon scans |logs| repeatedly, waiting -interval seconds between
+// scans and retrying queued notifications before each one.  With -log_list,
+// the log list is downloaded again every -log_list_refresh minutes, so new
+// logs are picked up.  A log which takes longer than -interval to scan
+// carries on in the background while the others are scanned again.  With
+// -crl_recheck, reported certificates are re-checked against their CRLs
+// every -crl_recheck minutes, after a scan.  Errors are logged and don't
+// stop the daemon.  If run by systemd with Type=notify, readiness, status,
+// and watchdog pings are reported to it.  On SIGHUP, the configuration is
+// reloaded and the logs are scanned again straight away; scans still
+// running in the background carry on, reporting their matches to the
+// reloaded notifiers.  On SIGTERM or SIGINT, it returns the exit code of
+// the interrupted scan, or 0 if it was waiting between scans.
+func runDaemon(logs []certspotter.LogInfo, processCallback certspotter.ProcessCallback) int {
+	interval := time.Duration(*intervalFlag) * time.Second
+	openStreams()
+	defer closeStreams()
+	if err := loadAPIToken(); err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+	listener, err := startHTTPServer()
+	if err != nil {
+		slog.Error("Error starting HTTP server", "error", err)
+		return 1
+	}
+	if listener != nil {
+		defer listener.Close()
+	}
+	pprofListener, err := startPprofServer()
+	if err != nil {
+		slog.Error("Error starting profiling server", "error", err)
+		return 1
+	}
+	if pprofListener != nil {
+		defer pprofListener.Close()
+	}
+	grpcListener, err := startGRPCServer()
+	if err != nil {
+		slog.Error("Error starting gRPC server", "error", err)
+		return 1
+	}
+	if grpcListener != nil {
+		defer grpcListener.Close()
+	}
+
+	// Let scans which are still running stop and save their progress
+	defer logScans.wg.Wait()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	startWatchdog(stop)
+	startHeartbeats(stop)
+	sdNotifyOrLog("READY=1")
+	lastLogListRefresh := time.Now()
+	for {
+		if refresh := time.Duration(*logListRefresh) * time.Minute; *logListURL != "" && refresh > 0 && time.Since(lastLogListRefresh) >= refresh {
+			refreshLogList(&logs)
+			lastLogListRefresh = time.Now()
+		}
+		sdNotifyOrLog("STATUS=Scanning logs")
+		exitCode := scanLogs(logs, streamEntriesCallback(processCallback))
+		recordScanCycle()
+		if isStopping() {
+			sdNotifyOrLog("STOPPING=1")
+			return exitCode
+		}
+		if crlRecheckDue() {
+			sdNotifyOrLog("STATUS=Re-checking CRLs")
+			recheckCRLs()
+		}
+		if exitCode != 0 {
+			slog.Warn("Scan finished with errors", "next_scan", interval)
+			sdNotifyOrLog("STATUS=Last scan finished with errors at " + time.Now().UTC().Format(time.RFC3339))
+		} else {
+			slog.Debug("Scan finished", "next_scan", interval)
+			sdNotifyOrLog("STATUS=Last scan finished at " + time.Now().UTC().Format(time.RFC3339))
+		}
+		// Only the first scan covers all time; later scans pick up where
+		// it left off
+		*allTime = false
+
+		select {
+		case <-time.After(interval):
+		case <-reloading:
+			sdNotifyOrLog("RELOADING=1")
+			if err := reloadConfig(&logs, &processCallback); err != nil {
+				slog.Error("Not reloading configuration", "error", err)
+			} else {
+				lastLogListRefresh = time.Now()
+			}
+			sdNotifyOrLog("READY=1")
+		case <-stopping:
+			sdNotifyOrLog("STOPPING=1")
+			return 0
+		}
+		retryNotifications()
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/loglist"
+)
+
+var newLogsFlag = flag.String("new_logs", "all", "Where to start scanning logs which weren't monitored before: all (from the first entry) or since_start (from when Cert Spotter started)")
+var logListRefresh = flag.Int("log_list_refresh", 60, "In -daemon mode, download the -log_list again this often, in minutes, to start monitoring new logs (0 to disable)")
+
+// When Cert Spotter started, for -new_logs since_start
+var startedAt = time.Now()
+
+func checkDiscoveryFlags() error {
+	if *newLogsFlag != "all" && *newLogsFlag != "since_start" {
+		return fmt.Errorf("-new_logs must be all or since_start")
+	}
+	if *logListRefresh < 0 {
+		return fmt.Errorf("-log_list_refresh must not be negative")
+	}
+	return nil
+}
+
+// makeNewLogTree returns the tree from which to start scanning a log which
+// hasn't been monitored before
+func (ctlog *logHandle) makeNewLogTree() (*certspotter.CollapsedMerkleTree, error) {
+	if *newLogsFlag != "since_start" {
+		ctlog.logger.Debug("New log; scanning all entries in the log", "tree_size", ctlog.verifiedSTH.TreeSize)
+		return certspotter.EmptyCollapsedMerkleTree(), nil
+	}
+	// As in ScanTimeRange, entries logged after startedAt may be timestamped
+	// up to one MMD before it
+	index, err := ctlog.scanner.FindTimestamp(startedAt.Add(-ctlog.logInfo.MaximumMergeDelay()), int64(ctlog.verifiedSTH.TreeSize))
+	if err != nil {
+		return nil, fmt.Errorf("Error finding entries logged since Cert Spotter started: %s", err)
+	}
+	tree, err := ctlog.scanner.MakeCollapsedMerkleTreeAt(uint64(index), ctlog.verifiedSTH)
+	if err != nil {
+		return nil, fmt.Errorf("Error reconstructing Merkle Tree at %d: %s", index, err)
+	}
+	ctlog.logger.Info("New log; scanning entries logged since Cert Spotter started", "start", index, "tree_size", ctlog.verifiedSTH.TreeSize)
+	return tree, nil
+}
+
+// refreshLogList reloads the log list in -daemon mode, so that logs which
+// have been added to it are monitored, and logs which have been removed (or
+// retired) aren't.  If the list can't be loaded, the old one is kept.
+func refreshLogList(logs *[]certspotter.LogInfo) {
+	newLogs, err := loadLogList()
+	if errors.Is(err, loglist.ErrInvalidSignature) {
+		slog.Error("Not refreshing log list, since it isn't properly signed", "error", err)
+		return
+	} else if err != nil {
+		slog.Warn("Not refreshing log list", "error", err)
+		return
+	}
+	oldURLs := make(map[string]bool)
+	for i := range *logs {
+		oldURLs[(*logs)[i].Url] = true
+	}
+	newURLs := make(map[string]bool)
+	for i := range newLogs {
+		newURLs[newLogs[i].Url] = true
+		if !oldURLs[newLogs[i].Url] {
+			slog.Info("Monitoring new log", "log", newLogs[i].FullURI(), "state", newLogs[i].State)
+		}
+	}
+	for i := range *logs {
+		if !newURLs[(*logs)[i].Url] {
+			slog.Info("No longer monitoring log", "log", (*logs)[i].FullURI())
+		}
+	}
+	*logs = newLogs
+	monitoredLogs = newLogs
+	recordMonitoredLogs(newLogs)
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/grpc"
+	"software.sslmate.com/src/certspotter/sink"
+)
+
+var grpcAddr = flag.String("grpc_addr", "", "Serve the gRPC API (see cmd/api.proto) on this address (e.g. localhost:9090) in -daemon mode")
+
+const grpcSocketName = "grpc"
+
+// The service in api.proto
+const grpcService = "certspotter.CertSpotter"
+
+// startGRPCServer serves the gRPC API on -grpc_addr, or on the socket passed
+// by systemd.  It returns nil if neither was given.  Close the listener to
+// stop serving.
+func startGRPCServer() (net.Listener, error) {
+	if err := loadActivatedListeners(); err != nil {
+		return nil, err
+	}
+	if _, activated := activatedListeners[grpcSocketName]; !activated && *grpcAddr == "" {
+		return nil, nil
+	}
+	listener, err := listen(grpcSocketName, *grpcAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAPIListener(listener, "-grpc_addr"); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	server := grpc.NewServer()
+	server.Token = apiToken
+	server.HandleStream(grpcService, "StreamMatches", streamMatches)
+	server.HandleUnary(grpcService, "AddWatchItem", addWatchItem)
+	server.HandleUnary(grpcService, "RemoveWatchItem", removeWatchItem)
+	server.HandleUnary(grpcService, "GetScanStatus", getScanStatus)
+	go func() {
+		if err := server.Serve(listener); err != nil && !isStopping() {
+			slog.Error("gRPC server stopped", "error", err)
+		}
+	}()
+	return listener, nil
+}
+
+// streamMatches implements StreamMatches, sending each match reported from
+// now on as an Entry (see sink/entry.proto)
+func streamMatches(ctx context.Context, request []byte, stream *grpc.Stream) error {
+	fields, err := grpc.ParseMessage(request)
+	if err != nil {
+		return grpc.Errorf(grpc.InvalidArgument, "%s", err)
+	}
+	includeDER, categories := fields.Bool(1), fields.Strings(2)
+
+	sub := matchBroadcaster.Subscribe()
+	defer matchBroadcaster.Unsubscribe(sub)
+	defer func() {
+		if dropped := sub.Dropped(); dropped != 0 {
+			slog.Warn("gRPC client fell behind, so matches were not streamed to it", "dropped", dropped)
+		}
+	}()
+	for {
+		select {
+		case info, ok := <-sub.C:
+			if !ok {
+				return grpc.Errorf(grpc.Unavailable, "certspotter is shutting down")
+			}
+			if !hasMatchCategory(info, categories) {
+				continue
+			}
+			if err := stream.Send(sink.MarshalProtobuf(info, includeDER)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// parseWatchItem returns the pattern and ID of a WatchItem message
+func parseWatchItem(request []byte) (string, string, error) {
+	if WatchlistFilename == "" || UsingProfiles() {
+		return "", "", grpc.Errorf(grpc.FailedPrecondition, "the watchlist can only be changed if it's read from a file, without -profiles")
+	}
+	fields, err := grpc.ParseMessage(request)
+	if err != nil {
+		return "", "", grpc.Errorf(grpc.InvalidArgument, "%s", err)
+	}
+	pattern, id := fields.String(1), fields.String(2)
+	if _, err := certspotter.ParseWatchlistItem(pattern); err != nil {
+		return "", "", grpc.Errorf(grpc.InvalidArgument, "%s", err)
+	}
+	if strings.ContainsAny(id, " \t\r\n") {
+		return "", "", grpc.Errorf(grpc.InvalidArgument, "ID may not contain whitespace")
+	}
+	return pattern, id, nil
+}
+
+// addWatchItem implements AddWatchItem, which adds an item to the watchlist
+// file and reloads the configuration
+func addWatchItem(ctx context.Context, request []byte) ([]byte, error) {
+	pattern, id, err := parseWatchItem(request)
+	if err != nil {
+		return nil, err
+	}
+	added, err := addToWatchlistFile(pattern, id)
+	if err != nil {
+		return nil, grpc.Errorf(grpc.Internal, "Error updating watchlist: %s", err)
+	}
+	if !added {
+		return nil, grpc.Errorf(grpc.AlreadyExists, "%s is already on the watchlist", pattern)
+	}
+	slog.Info("Added item to watchlist", "pattern", pattern, "id", id)
+	requestReload()
+	return nil, nil
+}
+
+// removeWatchItem implements RemoveWatchItem, which removes an item from
+// the watchlist file and reloads the configuration
+func removeWatchItem(ctx context.Context, request []byte) ([]byte, error) {
+	pattern, _, err := parseWatchItem(request)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := removeFromWatchlistFile(pattern)
+	if err != nil {
+		return nil, grpc.Errorf(grpc.Internal, "Error updating watchlist: %s", err)
+	}
+	if !removed {
+		return nil, grpc.Errorf(grpc.NotFound, "%s is not on the watchlist", pattern)
+	}
+	slog.Info("Removed item from watchlist", "pattern", pattern)
+	requestReload()
+	return nil, nil
+}
+
+func protoTime(m *grpc.Message, field int, t *time.Time) {
+	if t != nil {
+		m.OptionalInt64(field, t.UnixNano()/int64(time.Millisecond))
+	}
+}
+
+// getScanStatus implements GetScanStatus, which returns the same status as
+// -http_addr's /healthz
+func getScanStatus(ctx context.Context, request []byte) ([]byte, error) {
+	report := makeHealthReport()
+	var m grpc.Message
+	m.String(1, report.Status)
+	m.Bool(2, report.Ready)
+	protoTime(&m, 3, lastScanCycle())
+	for _, status := range report.Logs {
+		var log grpc.Message
+		log.String(1, status.URL)
+		log.String(2, status.State)
+		protoTime(&log, 3, status.LastSTHFetch)
+		protoTime(&log, 4, status.STHTimestamp)
+		log.Int64(5, int64(status.TreeSize))
+		log.Int64(6, int64(status.VerifiedTreeSize))
+		log.Int64(7, int64(status.ScannedSize))
+		log.Int64(8, int64(status.Lag))
+		protoTime(&log, 9, status.LagSince)
+		log.Bool(10, status.Scanning)
+		protoTime(&log, 11, status.LastScan)
+		log.Int64(12, int64(status.Errors))
+		log.Int64(13, int64(status.ConsecutiveErrors))
+		protoTime(&log, 14, status.LastError)
+		log.String(15, status.LastErrorClass)
+		m.Message(4, &log)
+	}
+	return m.Encoded(), nil
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Command hashwatchlist converts a watchlist, read from stdin, to a hashed
+// watchlist (see certspotter.HashedWatchlist), written to stdout.  Only
+// domains (www.example.com) and domain trees (.example.com) can be hashed.
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+var saltHex = flag.String("salt", "", "Salt to hash with, in hex (default: a new random salt)")
+
+func hashItem(item *certspotter.WatchlistItem, salt []byte) ([]string, error) {
+	if item.Regexp != nil || item.IPNet != nil || item.Email || item.URI || item.AnyPublicSuffix || len(item.Domain) == 0 {
+		return nil, fmt.Errorf("`%s' cannot be hashed: only domains and domain trees are supported", item.Pattern)
+	}
+	domain := strings.Join(item.Domain, ".")
+	if strings.Contains(domain, "*") {
+		return nil, fmt.Errorf("`%s' cannot be hashed: wildcards are not supported", item.Pattern)
+	}
+	if item.AcceptSuffix {
+		return []string{"." + certspotter.HashDomain(salt, domain)}, nil
+	}
+	lines := []string{certspotter.HashDomain(salt, domain)}
+	if dot := strings.IndexByte(domain, '.'); dot != -1 {
+		lines = append(lines, "*"+certspotter.HashDomain(salt, domain[dot+1:]))
+	}
+	return lines, nil
+}
+
+func main() {
+	flag.Parse()
+
+	var salt []byte
+	if *saltHex != "" {
+		var err error
+		if salt, err = hex.DecodeString(*saltHex); err != nil || len(salt) == 0 {
+			fmt.Fprintf(os.Stderr, "%s: Invalid salt `%s'\n", os.Args[0], *saltHex)
+			os.Exit(2)
+		}
+	} else {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+			os.Exit(1)
+		}
+	}
+
+	watchlist, err := certspotter.ReadWatchlist(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: (stdin): %s\n", os.Args[0], err)
+		os.Exit(1)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(out, "salt %x\n", salt)
+	for i := range watchlist.Items {
+		item := &watchlist.Items[i]
+		lines, err := hashItem(item, salt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		for _, line := range lines {
+			if item.ID != item.Pattern {
+				line += " " + item.ID
+			}
+			fmt.Fprintln(out, line)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		os.Exit(1)
+	}
+}
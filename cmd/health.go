@@ -0,0 +1,279 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/ct/client"
+)
+
+// A log isn't ready once this many scans of it in a row have failed
+const maxConsecutiveErrors = 3
+
+type logStatus struct {
+	URL                string     `json:"url"`
+	State              string     `json:"state,omitempty"`
+	LastSTHFetch       *time.Time `json:"last_sth_fetch,omitempty"`
+	STHTimestamp       *time.Time `json:"sth_timestamp,omitempty"`
+	TreeSize           uint64     `json:"tree_size"`
+	VerifiedTreeSize   uint64     `json:"verified_tree_size"` // of the latest STH verified to be consistent
+	ScannedSize        uint64     `json:"scanned_size"`
+	Lag                uint64     `json:"lag"`                 // entries between ScannedSize and TreeSize
+	LagSince           *time.Time `json:"lag_since,omitempty"` // when the oldest of those entries was first seen
+	Scanning           bool       `json:"scanning"`
+	LastScan           *time.Time `json:"last_scan,omitempty"`
+	LastSuccessfulScan *time.Time `json:"last_successful_scan,omitempty"`
+	Errors             int        `json:"errors"`
+	ConsecutiveErrors  int        `json:"consecutive_errors"`
+	LastError          *time.Time `json:"last_error,omitempty"`
+	LastErrorClass     string     `json:"last_error_class,omitempty"` // e.g. timeout, rate_limited, or signature
+
+	lastAdvance time.Time // when ScannedSize last increased
+}
+
+func (status *logStatus) healthy() bool {
+	return status.ConsecutiveErrors < maxConsecutiveErrors
+}
+
+var health struct {
+	sync.Mutex
+	logs          map[string]*logStatus
+	urls          []string // of the logs being monitored
+	firstScanDone bool
+	lastScanCycle time.Time // when scanLogs last returned
+}
+
+// recordMonitoredLogs sets the logs whose status is reported
+func recordMonitoredLogs(logs []certspotter.LogInfo) {
+	health.Lock()
+	defer health.Unlock()
+	health.urls = nil
+	for i := range logs {
+		logInfo := &logs[i]
+		health.urls = append(health.urls, logInfo.Url)
+		updateLogStatusLocked(logInfo, func(status *logStatus) {
+			status.State = logInfo.State
+			if logInfo.FinalTreeHead != nil && status.TreeSize == 0 {
+				status.TreeSize = logInfo.FinalTreeHead.TreeSize
+			}
+		})
+	}
+}
+
+// updateLogStatus calls |update| with the status of |logInfo|, creating it
+// if necessary
+func updateLogStatus(logInfo *certspotter.LogInfo, update func(*logStatus)) {
+	health.Lock()
+	defer health.Unlock()
+	updateLogStatusLocked(logInfo, update)
+}
+
+// updateLogStatusLocked is like updateLogStatus, but health must be locked
+func updateLogStatusLocked(logInfo *certspotter.LogInfo, update func(*logStatus)) {
+	if health.logs == nil {
+		health.logs = make(map[string]*logStatus)
+	}
+	status, exists := health.logs[logInfo.Url]
+	if !exists {
+		status = &logStatus{URL: logInfo.Url}
+		health.logs[logInfo.Url] = status
+	}
+	update(status)
+}
+
+// updateLagSince records when the log fell behind, or clears it if it has
+// caught up
+func (status *logStatus) updateLagSince(now time.Time) {
+	if status.ScannedSize >= status.TreeSize {
+		status.LagSince = nil
+	} else if status.LagSince == nil {
+		status.LagSince = &now
+	}
+}
+
+func recordSTHFetch(logInfo *certspotter.LogInfo, sth *ct.SignedTreeHead) {
+	now := time.Now().UTC()
+	timestamp := time.Unix(int64(sth.Timestamp/1000), int64(sth.Timestamp%1000)*1000000).UTC()
+	updateLogStatus(logInfo, func(status *logStatus) {
+		status.LastSTHFetch = &now
+		status.STHTimestamp = &timestamp
+		status.TreeSize = sth.TreeSize
+		status.updateLagSince(now)
+	})
+}
+
+func recordVerifiedSTH(logInfo *certspotter.LogInfo, sth *ct.SignedTreeHead) {
+	updateLogStatus(logInfo, func(status *logStatus) {
+		status.VerifiedTreeSize = sth.TreeSize
+	})
+}
+
+func recordScanPosition(logInfo *certspotter.LogInfo, size uint64) {
+	now := time.Now().UTC()
+	updateLogStatus(logInfo, func(status *logStatus) {
+		if size > status.ScannedSize {
+			status.lastAdvance = now
+		}
+		status.ScannedSize = size
+		status.updateLagSince(now)
+	})
+}
+
+func recordScanStart(logInfo *certspotter.LogInfo) {
+	updateLogStatus(logInfo, func(status *logStatus) {
+		status.Scanning = true
+	})
+}
+
+func recordScanResult(logInfo *certspotter.LogInfo, exitCode int) {
+	now := time.Now().UTC()
+	updateLogStatus(logInfo, func(status *logStatus) {
+		status.Scanning = false
+		status.LastScan = &now
+		if exitCode == 0 {
+			status.LastSuccessfulScan = &now
+			status.ConsecutiveErrors = 0
+		} else {
+			status.Errors++
+			status.ConsecutiveErrors++
+			status.LastError = &now
+		}
+	})
+}
+
+func recordErrorClass(logInfo *certspotter.LogInfo, class client.ErrorClass) {
+	updateLogStatus(logInfo, func(status *logStatus) {
+		status.LastErrorClass = string(class)
+	})
+}
+
+// recordScanCycle records that the logs have been scanned
+func recordScanCycle() {
+	health.Lock()
+	health.firstScanDone = true
+	health.lastScanCycle = time.Now().UTC()
+	health.Unlock()
+}
+
+// lastScanCycle returns when the logs were last scanned, or nil if they
+// haven't been yet
+func lastScanCycle() *time.Time {
+	health.Lock()
+	defer health.Unlock()
+	if health.lastScanCycle.IsZero() {
+		return nil
+	}
+	last := health.lastScanCycle
+	return &last
+}
+
+// lastProgress returns when the log last made progress: when a scan of it
+// last succeeded or, if it's being scanned, advanced.  Returns |since| if
+// it hasn't made progress since then.
+func (status *logStatus) lastProgress(since time.Time) time.Time {
+	progress := since
+	if status.LastSuccessfulScan != nil && status.LastSuccessfulScan.After(progress) {
+		progress = *status.LastSuccessfulScan
+	}
+	if status.Scanning && status.lastAdvance.After(progress) {
+		progress = status.lastAdvance
+	}
+	return progress
+}
+
+// oldestProgress returns the earliest time at which a monitored log last
+// made progress (see lastProgress), so that a log which keeps failing is
+// noticed even though the others are fine.  Returns the current time if no
+// logs are monitored.
+func oldestProgress(since time.Time) time.Time {
+	health.Lock()
+	defer health.Unlock()
+	oldest := time.Now()
+	for _, url := range health.urls {
+		progress := since
+		if status, exists := health.logs[url]; exists {
+			progress = status.lastProgress(since)
+		}
+		if progress.Before(oldest) {
+			oldest = progress
+		}
+	}
+	return oldest
+}
+
+type healthReport struct {
+	Status string       `json:"status"`
+	Ready  bool         `json:"ready"`
+	Logs   []*logStatus `json:"logs"`
+}
+
+// makeHealthReport reports on the monitored logs.  certspotter is ready once
+// it has finished its first scan, as long as no log has failed too many
+// scans in a row.
+func makeHealthReport() *healthReport {
+	health.Lock()
+	defer health.Unlock()
+	report := &healthReport{Status: "ok", Ready: health.firstScanDone && !isStopping()}
+	for _, url := range health.urls {
+		status, exists := health.logs[url]
+		if !exists {
+			status = &logStatus{URL: url}
+		}
+		statusCopy := *status
+		if statusCopy.TreeSize > statusCopy.ScannedSize {
+			statusCopy.Lag = statusCopy.TreeSize - statusCopy.ScannedSize
+		}
+		report.Ready = report.Ready && statusCopy.healthy()
+		report.Logs = append(report.Logs, &statusCopy)
+	}
+	sort.Sort(logStatusesByURL(report.Logs))
+	if isStopping() {
+		report.Status = "stopping"
+	}
+	return report
+}
+
+type logStatusesByURL []*logStatus
+
+func (s logStatusesByURL) Len() int           { return len(s) }
+func (s logStatusesByURL) Less(i, j int) bool { return s[i].URL < s[j].URL }
+func (s logStatusesByURL) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// serveHealthz reports whether certspotter is alive, which it is unless it's
+// shutting down
+func serveHealthz(w http.ResponseWriter, req *http.Request) {
+	report := makeHealthReport()
+	status := http.StatusOK
+	if isStopping() {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSONResponse(w, status, report)
+}
+
+// serveReadyz reports whether certspotter has caught up with the logs
+func serveReadyz(w http.ResponseWriter, req *http.Request) {
+	report := makeHealthReport()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSONResponse(w, status, report)
+}
+
+func init() {
+	httpMux.HandleFunc("/healthz", serveHealthz)
+	httpMux.HandleFunc("/readyz", serveReadyz)
+}
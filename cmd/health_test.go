@@ -0,0 +1,59 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+func TestOldestProgress(t *testing.T) {
+	defer func() {
+		health.logs, health.urls = nil, nil
+	}()
+	good := &certspotter.LogInfo{Url: "good.example.com/"}
+	failing := &certspotter.LogInfo{Url: "failing.example.com/"}
+	recordMonitoredLogs([]certspotter.LogInfo{*good, *failing})
+	started := time.Now().Add(-time.Hour)
+
+	if oldest := oldestProgress(started); !oldest.Equal(started) {
+		t.Errorf("Before any scans: got %s instead of %s", oldest, started)
+	}
+
+	recordScanResult(good, 0)
+	recordScanResult(failing, 0)
+	afterSuccess := time.Now()
+	if oldest := oldestProgress(started); oldest.Before(afterSuccess.Add(-time.Second)) {
+		t.Errorf("After successful scans: got %s", oldest)
+	}
+
+	// A log which keeps failing holds back progress, even though it
+	// keeps being scanned
+	health.logs[failing.Url].LastSuccessfulScan = &started
+	recordScanResult(failing, 1)
+	recordScanResult(good, 0)
+	if oldest := oldestProgress(started); !oldest.Equal(started) {
+		t.Errorf("With a failing log: got %s instead of %s", oldest, started)
+	}
+
+	// A long scan which is advancing counts as progress, but not once
+	// it has stopped
+	recordScanStart(failing)
+	recordScanPosition(failing, 1000)
+	if oldest := oldestProgress(started); oldest.Before(afterSuccess) {
+		t.Errorf("With an advancing scan: got %s", oldest)
+	}
+	recordScanResult(failing, 1)
+	if oldest := oldestProgress(started); !oldest.Equal(started) {
+		t.Errorf("After the scan failed: got %s instead of %s", oldest, started)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var heartbeatFile = flag.String("heartbeat_file", "", "Write the time and the status of each log to this JSON file every -heartbeat_interval, as long as scans are progressing")
+var heartbeatURL = flag.String("heartbeat_url", "", "POST the time and the status of each log as JSON to this URL every -heartbeat_interval, as long as scans are progressing")
+var heartbeatInterval = flag.Int("heartbeat_interval", 60, "Seconds between heartbeats in -daemon mode")
+
+type heartbeat struct {
+	Time     time.Time  `json:"time"`
+	LastScan *time.Time `json:"last_scan,omitempty"` // when all the logs were last scanned
+	*healthReport
+}
+
+var heartbeatClient = &http.Client{Timeout: 30 * time.Second}
+
+func checkHeartbeatFlags() error {
+	if *heartbeatURL != "" {
+		if u, err := url.Parse(*heartbeatURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("-heartbeat_url must be an http or https URL")
+		}
+	}
+	if *heartbeatInterval <= 0 {
+		return fmt.Errorf("-heartbeat_interval must be positive")
+	}
+	return nil
+}
+
+// heartbeatsEnabled returns true if -heartbeat_file or -heartbeat_url is
+// specified
+func heartbeatsEnabled() bool {
+	return *heartbeatFile != "" || *heartbeatURL != ""
+}
+
+// sendHeartbeat writes the -heartbeat_file and POSTs to the -heartbeat_url
+func sendHeartbeat() {
+	beat := &heartbeat{Time: time.Now().UTC(), LastScan: lastScanCycle(), healthReport: makeHealthReport()}
+	if *heartbeatFile != "" {
+		if err := writeJSONFile(*heartbeatFile, beat, 0666); err != nil {
+			slog.Error("Error writing heartbeat file", "error", err)
+		}
+	}
+	if *heartbeatURL != "" {
+		if err := postHeartbeat(beat); err != nil {
+			slog.Warn("Error sending heartbeat", "error", err)
+		}
+	}
+}
+
+func postHeartbeat(beat *heartbeat) error {
+	body, err := json.Marshal(beat)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", *heartbeatURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "certspotter")
+	resp, err := heartbeatClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s (%s)", *heartbeatURL, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// startHeartbeats sends a heartbeat every -heartbeat_interval until |stop|
+// is closed.  They're only sent while every log is making progress: if a
+// log hasn't been scanned successfully (or, while it's being scanned, its
+// scan hasn't advanced) for 3 -intervals, which is at least one more than
+// it should take (see runDaemon), it's presumed to be wedged or failing,
+// and the heartbeats stop so that whatever is watching for them notices.
+// Logs which haven't made progress yet are given 3 -intervals from now.
+func startHeartbeats(stop <-chan struct{}) {
+	if !heartbeatsEnabled() {
+		return
+	}
+	started := time.Now()
+	deadline := 3 * time.Duration(*intervalFlag) * time.Second
+	go func() {
+		ticker := time.NewTicker(time.Duration(*heartbeatInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if since := oldestProgress(started); time.Since(since) > deadline {
+					slog.Warn("Not sending heartbeat because a log hasn't been scanned successfully recently", "since", since)
+					continue
+				}
+				sendHeartbeat()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -16,6 +16,7 @@ import (
 	"io/ioutil"
 	"os"
 
+	"software.sslmate.com/src/certspotter/compression"
 	"software.sslmate.com/src/certspotter/ct"
 )
 
@@ -59,7 +60,7 @@ func writeJSONFile(filename string, obj interface{}, perm os.FileMode) error {
 }
 
 func readJSONFile(filename string, obj interface{}) error {
-	bytes, err := ioutil.ReadFile(filename)
+	bytes, err := compression.ReadFile(filename)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,114 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"software.sslmate.com/src/certspotter/grpc"
+)
+
+var httpAddr = flag.String("http_addr", "", "Serve HTTP endpoints (/healthz, /readyz, /metrics, /api/certs, /api/stream) on this address (e.g. localhost:8080) in -daemon mode")
+var apiTokenFile = flag.String("api_token_file", "", "File containing a token which clients of /api/certs, /api/stream, and -grpc_addr must send as a bearer token (required to serve them on a non-loopback address)")
+
+// The token read from -api_token_file, or empty if there's none
+var apiToken string
+
+// loadAPIToken reads -api_token_file
+func loadAPIToken() error {
+	if *apiTokenFile == "" {
+		return nil
+	}
+	token, err := ioutil.ReadFile(*apiTokenFile)
+	if err != nil {
+		return fmt.Errorf("Error reading API token: %s", err)
+	}
+	apiToken = string(bytes.TrimSpace(token))
+	if apiToken == "" {
+		return fmt.Errorf("Error reading API token: %s is empty", *apiTokenFile)
+	}
+	return nil
+}
+
+// checkAPIListener refuses to serve the API on |listener|, which was
+// specified with |flagName|, if anyone on the network could connect to it
+// without a token
+func checkAPIListener(listener net.Listener, flagName string) error {
+	if apiToken != "" {
+		return nil
+	}
+	switch addr := listener.Addr().(type) {
+	case *net.UnixAddr:
+		return nil
+	case *net.TCPAddr:
+		if addr.IP.IsLoopback() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a loopback address, so -api_token_file is required to serve %s on it", listener.Addr(), flagName)
+}
+
+// requireAPIToken wraps |handler| so that it's only called for requests
+// with the -api_token_file token
+func requireAPIToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !grpc.HasBearerToken(req, apiToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="certspotter"`)
+			writeJSONResponse(w, http.StatusUnauthorized, map[string]string{"error": "missing or incorrect bearer token"})
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// The name of the socket which systemd socket activation can pass in place
+// of -http_addr
+const httpSocketName = "http"
+
+var httpMux = http.NewServeMux()
+
+// startHTTPServer serves httpMux on -http_addr, or on the socket passed by
+// systemd.  It returns nil if neither was given.  Close the listener to stop
+// serving.
+func startHTTPServer() (net.Listener, error) {
+	if err := loadActivatedListeners(); err != nil {
+		return nil, err
+	}
+	if _, activated := activatedListeners[httpSocketName]; !activated && *httpAddr == "" {
+		return nil, nil
+	}
+	listener, err := listen(httpSocketName, *httpAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAPIListener(listener, "-http_addr"); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	go func() {
+		if err := http.Serve(listener, httpMux); err != nil && !isStopping() {
+			slog.Error("HTTP server stopped", "error", err)
+		}
+	}()
+	return listener, nil
+}
+
+func writeJSONResponse(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
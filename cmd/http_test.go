@@ -0,0 +1,79 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckAPIListener(t *testing.T) {
+	defer func() { apiToken = "" }()
+	tests := []struct {
+		addr  net.Addr
+		token string
+		ok    bool
+	}{
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}, "", true},
+		{&net.TCPAddr{IP: net.IPv6loopback, Port: 8080}, "", true},
+		{&net.UnixAddr{Name: "/run/certspotter.sock", Net: "unix"}, "", true},
+		{&net.TCPAddr{IP: net.IPv4zero, Port: 8080}, "", false},
+		{&net.TCPAddr{IP: net.IPv6unspecified, Port: 8080}, "", false},
+		{&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8080}, "", false},
+		{&net.TCPAddr{IP: net.IPv4zero, Port: 8080}, "s3cret", true},
+	}
+	for _, test := range tests {
+		apiToken = test.token
+		err := checkAPIListener(fakeListener{test.addr}, "-http_addr")
+		if (err == nil) != test.ok {
+			t.Errorf("%s with token %q: got %v", test.addr, test.token, err)
+		}
+	}
+}
+
+type fakeListener struct {
+	addr net.Addr
+}
+
+func (l fakeListener) Accept() (net.Conn, error) { return nil, net.ErrClosed }
+func (l fakeListener) Close() error              { return nil }
+func (l fakeListener) Addr() net.Addr            { return l.addr }
+
+func TestRequireAPIToken(t *testing.T) {
+	defer func() { apiToken = "" }()
+	handler := requireAPIToken(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		token         string
+		authorization string
+		status        int
+	}{
+		{"", "", http.StatusNoContent},
+		{"s3cret", "", http.StatusUnauthorized},
+		{"s3cret", "Bearer wrong", http.StatusUnauthorized},
+		{"s3cret", "s3cret", http.StatusUnauthorized},
+		{"s3cret", "Bearer s3cret", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		apiToken = test.token
+		req := httptest.NewRequest("GET", "/api/certs", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != test.status {
+			t.Errorf("Token %q, Authorization %q: got status %d instead of %d", test.token, test.authorization, w.Code, test.status)
+		}
+	}
+}
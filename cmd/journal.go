@@ -0,0 +1,102 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+var journalFilename = flag.String("journal", "", "Append a hash-chained JSON line to this file for every STH fetched, range of entries verified, and notification sent")
+var verifyJournalFilename = flag.String("verify_journal", "", "Verify the hash chain of this -journal file, print its latest hash, and exit")
+
+// The -journal, or nil
+var journal *certspotter.Journal
+
+func openJournal() error {
+	if *journalFilename == "" {
+		return nil
+	}
+	var err error
+	if journal, err = certspotter.OpenJournal(*journalFilename); err != nil {
+		return fmt.Errorf("Error opening journal: %s", err)
+	}
+	return nil
+}
+
+func closeJournal() {
+	if err := journal.Close(); err != nil {
+		slog.Error("Error closing journal", "error", err)
+	}
+	journal = nil
+}
+
+// verifyJournal verifies the -verify_journal file, and returns the exit code
+func verifyJournal() int {
+	file, err := os.Open(*verifyJournalFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	defer file.Close()
+	lines, lastHash, err := certspotter.VerifyJournal(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", os.Args[0], *verifyJournalFilename, err)
+		return 1
+	}
+	fmt.Printf("%d lines verified; latest hash %s\n", lines, lastHash)
+	return 0
+}
+
+// writeJournal appends |entry| to the -journal, if any, and logs the
+// journal's new latest hash, so that the hash is also recorded outside the
+// journal
+func writeJournal(entry *certspotter.JournalEntry) {
+	if journal == nil {
+		return
+	}
+	if err := journal.Write(entry); err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	slog.Info("Appended to journal", "type", entry.Type, "hash", journal.LastHash())
+}
+
+func journalSTH(logInfo *certspotter.LogInfo, sth *ct.SignedTreeHead) {
+	writeJournal(&certspotter.JournalEntry{Type: certspotter.JournalEntrySTH, LogURI: logInfo.FullURI(), STH: sth})
+}
+
+func journalVerifiedRange(logInfo *certspotter.LogInfo, start uint64, sth *ct.SignedTreeHead) {
+	writeJournal(&certspotter.JournalEntry{
+		Type:   certspotter.JournalEntryVerifiedRange,
+		LogURI: logInfo.FullURI(),
+		Range:  &certspotter.JournalRange{Start: start, End: sth.TreeSize, RootHash: sth.SHA256RootHash[:]},
+	})
+}
+
+func journalNotification(channel string, infos []*certspotter.EntryInfo, err error) {
+	notification := &certspotter.JournalNotification{Channel: channel}
+	for _, info := range infos {
+		notification.Entries = append(notification.Entries, certspotter.JournalNotifiedItem{
+			LogURI:      info.LogUri,
+			Index:       info.Entry.Index,
+			Fingerprint: info.Fingerprint(),
+		})
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+	writeJournal(&certspotter.JournalEntry{Type: certspotter.JournalEntryNotification, Notification: notification})
+}
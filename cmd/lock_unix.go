@@ -0,0 +1,65 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// The lock is released by the kernel if the process dies, so a stale lock
+// file never needs to be removed by hand
+const lockIsAdvisory = true
+
+// acquireLockFile creates the file at |path| if necessary and takes an
+// exclusive advisory lock on it.  Returns nil if another process holds the lock.
+func acquireLockFile(path string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, nil
+		} else if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		// The previous holder removes the file when it unlocks, so we may
+		// have locked a file that no longer exists.  Make sure the file
+		// we locked is the one at |path|, or else try again.
+		lockedInfo, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if currentInfo, err := os.Stat(path); err == nil && os.SameFile(lockedInfo, currentInfo) {
+			return file, nil
+		} else if err != nil && !os.IsNotExist(err) {
+			file.Close()
+			return nil, err
+		}
+		file.Close()
+	}
+}
+
+func releaseLockFile(path string, file *os.File) error {
+	// Remove before closing, while we still hold the lock
+	err := os.Remove(path)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
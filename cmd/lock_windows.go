@@ -0,0 +1,36 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"os"
+)
+
+// The existence of the lock file is the lock, so it has to be removed by
+// hand if the process holding it dies
+const lockIsAdvisory = false
+
+// acquireLockFile creates the file at |path|, which must not already exist.
+// Returns nil if another process holds the lock.
+func acquireLockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return nil, nil
+	}
+	return file, err
+}
+
+func releaseLockFile(path string, file *os.File) error {
+	err := file.Close()
+	if removeErr := os.Remove(path); err == nil {
+		err = removeErr
+	}
+	return err
+}
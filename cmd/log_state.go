@@ -45,7 +45,7 @@ func makeLogStateDir(logStatePath string) error {
 	if err := os.Mkdir(logStatePath, 0777); err != nil && !os.IsExist(err) {
 		return fmt.Errorf("%s: %s", logStatePath, err)
 	}
-	for _, subdir := range []string{"unverified_sths"} {
+	for _, subdir := range []string{"unverified_sths", "pending_scts"} {
 		path := filepath.Join(logStatePath, subdir)
 		if err := os.Mkdir(path, 0777); err != nil && !os.IsExist(err) {
 			return fmt.Errorf("%s: %s", path, err)
@@ -143,3 +143,50 @@ func (logState *LogState) GetTree() (*certspotter.CollapsedMerkleTree, error) {
 func (logState *LogState) StoreTree(tree *certspotter.CollapsedMerkleTree) error {
 	return writeJSONFile(filepath.Join(logState.path, "tree.json"), tree, 0666)
 }
+
+func (logState *LogState) PendingSCTFilename(pending *certspotter.PendingSCT) string {
+	return filepath.Join(logState.path, "pending_scts", base64.RawURLEncoding.EncodeToString(pending.LeafHash)+".json")
+}
+
+func (logState *LogState) GetPendingSCTs() ([]*certspotter.PendingSCT, error) {
+	dir, err := os.Open(filepath.Join(logState.path, "pending_scts"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*certspotter.PendingSCT{}, nil
+		} else {
+			return nil, err
+		}
+	}
+	defer dir.Close()
+	filenames, err := dir.Readdirnames(0)
+	if err != nil {
+		return nil, err
+	}
+
+	pendings := make([]*certspotter.PendingSCT, 0, len(filenames))
+	for _, filename := range filenames {
+		if !strings.HasPrefix(filename, ".") {
+			pending := new(certspotter.PendingSCT)
+			if err := readJSONFile(filepath.Join(dir.Name(), filename), pending); err == nil {
+				pendings = append(pendings, pending)
+			}
+		}
+	}
+	return pendings, nil
+}
+
+func (logState *LogState) StorePendingSCT(pending *certspotter.PendingSCT) error {
+	filename := logState.PendingSCTFilename(pending)
+	if fileExists(filename) {
+		return nil
+	}
+	return writeJSONFile(filename, pending, 0666)
+}
+
+func (logState *LogState) RemovePendingSCT(pending *certspotter.PendingSCT) error {
+	err := os.Remove(logState.PendingSCTFilename(pending))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
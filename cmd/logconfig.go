@@ -0,0 +1,112 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+var rateLimit = flag.Float64("rate_limit", 0, "Maximum number of get-entries requests per second to each log (0 for no limit)")
+var logTimeout = flag.Int("log_timeout", 60, "Number of seconds after which a request to a log times out")
+var logConfigFilename = flag.String("log_config", "", "JSON file of per-log overrides for -batch_size, -num_workers, -rate_limit, and -log_timeout (see README)")
+
+// logConfig overrides the global settings for one log.  Zero values mean
+// that the global setting is used.
+type logConfig struct {
+	BatchSize  int     `json:"batch_size"`
+	NumWorkers int     `json:"num_workers"`
+	RateLimit  float64 `json:"rate_limit"` // requests per second
+	Timeout    int     `json:"timeout"`    // seconds
+}
+
+// logConfigs maps log URLs (without https://) to their overrides
+var logConfigs map[string]logConfig
+
+func checkLogConfigFlags() error {
+	if *rateLimit < 0 {
+		return fmt.Errorf("-rate_limit must not be negative")
+	}
+	if *logTimeout <= 0 {
+		return fmt.Errorf("-log_timeout must be positive")
+	}
+	return nil
+}
+
+// loadLogConfigs reads the -log_config file, which is a JSON object mapping
+// log URLs to logConfig objects
+func loadLogConfigs() (map[string]logConfig, error) {
+	if *logConfigFilename == "" {
+		return nil, nil
+	}
+	var file map[string]logConfig
+	if err := readJSONFile(*logConfigFilename, &file); err != nil {
+		return nil, fmt.Errorf("Error reading log config file: %s: %s", *logConfigFilename, err)
+	}
+	configs := make(map[string]logConfig)
+	for url, config := range file {
+		if config.BatchSize < 0 || config.NumWorkers < 0 || config.RateLimit < 0 || config.Timeout < 0 {
+			return nil, fmt.Errorf("Error reading log config file: %s: %s: settings must not be negative", *logConfigFilename, url)
+		}
+		configs[strings.TrimSuffix(strings.TrimPrefix(url, "https://"), "/")] = config
+	}
+	return configs, nil
+}
+
+// warnUnknownLogConfigs warns about overrides for logs which aren't being
+// monitored, which are probably typos
+func warnUnknownLogConfigs(logs []certspotter.LogInfo) {
+	monitored := make(map[string]bool)
+	for i := range logs {
+		monitored[logs[i].Url] = true
+	}
+	for url := range logConfigs {
+		if !monitored[url] {
+			slog.Warn("Ignoring -log_config settings for log which isn't being monitored", "log", "https://"+url)
+		}
+	}
+}
+
+// scannerOptions returns the options for scanning |logInfo|: the global
+// settings, with the log's overrides applied
+func scannerOptions(logInfo *certspotter.LogInfo) *certspotter.ScannerOptions {
+	opts := &certspotter.ScannerOptions{
+		BatchSize:       *batchSize,
+		NumWorkers:      *numWorkers,
+		Quiet:           !slog.Default().Enabled(context.Background(), slog.LevelDebug),
+		SkipPrecerts:    *onlyCerts,
+		SkipCerts:       *onlyPrecerts,
+		Stop:            stopping,
+		Collector:       collector,
+		RateLimit:       *rateLimit,
+		Timeout:         time.Duration(*logTimeout) * time.Second,
+		TraceSampleRate: *traceSampleRate,
+	}
+	config := logConfigs[logInfo.Url]
+	if config.BatchSize != 0 {
+		opts.BatchSize = config.BatchSize
+	}
+	if config.NumWorkers != 0 {
+		opts.NumWorkers = config.NumWorkers
+	}
+	if config.RateLimit != 0 {
+		opts.RateLimit = config.RateLimit
+	}
+	if config.Timeout != 0 {
+		opts.Timeout = time.Duration(config.Timeout) * time.Second
+	}
+	return opts
+}
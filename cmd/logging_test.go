@@ -0,0 +1,65 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestLogHandlerText(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := newLogHandler(&buf, "text", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(handler).With("log", "ct.example.com/")
+	logger.Debug("Hidden")
+	logger.Info("Fetching entries", "start", 0, "end", 999)
+	logger.Warn("Problem", "error", errors.New("connection refused"), "hash", []byte{0xab, 0xcd}, "next_scan", 5*time.Minute)
+	expected := regexp.MustCompile(`^time=\S+ level=INFO msg="Fetching entries" log=ct.example.com/ start=0 end=999
+time=\S+ level=WARN msg=Problem log=ct.example.com/ error="connection refused" hash=abcd next_scan=5m0s
+$`)
+	if !expected.MatchString(buf.String()) {
+		t.Errorf("Wrong output:\n%s", buf.String())
+	}
+}
+
+func TestLogHandlerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := newLogHandler(&buf, "JSON", slog.LevelDebug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slog.New(handler).With("worker", 2).Debug("Skipping entry", "index", int64(5), "precert", true, "error", errors.New("bad"), "hash", []byte{1}, "retry_wait", time.Second)
+	expected := regexp.MustCompile(`^\{"time":"[^"]+","level":"DEBUG","msg":"Skipping entry","worker":2,"index":5,"precert":true,"error":"bad","hash":"01","retry_wait":"1s"\}
+$`)
+	if !expected.MatchString(buf.String()) {
+		t.Errorf("Wrong output:\n%s", buf.String())
+	}
+}
+
+func TestParseLoggingFlags(t *testing.T) {
+	for input, expected := range map[string]slog.Level{"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warning": slog.LevelWarn, "error": slog.LevelError} {
+		if level, err := parseLogLevel(input); err != nil || level != expected {
+			t.Errorf("parseLogLevel(%q) = %v, %v", input, level, err)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Errorf("Invalid level was accepted")
+	}
+	if _, err := newLogHandler(&bytes.Buffer{}, "xml", slog.LevelInfo); err == nil {
+		t.Errorf("Invalid format was accepted")
+	}
+}
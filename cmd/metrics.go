@@ -0,0 +1,125 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const (
+	metricMatches              = "certspotter_matches_total"
+	metricMatchCategories      = "certspotter_match_categories_total"
+	metricNotificationsSent    = "certspotter_notifications_sent_total"
+	metricNotificationFailures = "certspotter_notification_failures_total"
+	metricQueueDepth           = "certspotter_notification_queue_depth"
+	metricTreeSize             = "certspotter_log_tree_size"
+	metricScannedSize          = "certspotter_log_scanned_size"
+	metricLag                  = "certspotter_log_lag_entries"
+	metricLagSeconds           = "certspotter_log_lag_seconds"
+	metricLastSTHFetch         = "certspotter_log_last_sth_fetch_timestamp_seconds"
+	metricScanErrors           = "certspotter_log_scan_errors_total"
+)
+
+var collector = certspotter.NewCollector()
+
+func init() {
+	collector.Describe(metricMatches, "counter", "Number of matching entries reported from each log")
+	collector.Describe(metricMatchCategories, "counter", "Number of matching entries reported from each log with a match of each category (e.g. weak_crypto)")
+	collector.Describe(metricNotificationsSent, "counter", "Number of notifications sent by each notifier")
+	collector.Describe(metricNotificationFailures, "counter", "Number of failed attempts to send a notification by each notifier")
+	collector.Describe(metricQueueDepth, "gauge", "Number of failed notifications queued for retry by each notifier")
+	collector.Describe(metricTreeSize, "gauge", "Tree size of the latest STH fetched from each log")
+	collector.Describe(metricScannedSize, "gauge", "Number of entries of each log which have been scanned")
+	collector.Describe(metricLag, "gauge", "Number of entries of each log which haven't been scanned yet")
+	collector.Describe(metricLagSeconds, "gauge", "How long ago the oldest entry of each log which hasn't been scanned yet was seen")
+	collector.Describe(metricLastSTHFetch, "gauge", "When an STH was last fetched from each log, as a Unix timestamp")
+	collector.Describe(metricScanErrors, "counter", "Number of failed scans of each log")
+	collector.OnCollect(collectLogMetrics)
+	collector.OnCollect(collectQueueMetrics)
+	httpMux.Handle("/metrics", collector)
+}
+
+func collectLogMetrics(collector *certspotter.Collector) {
+	for _, name := range []string{metricTreeSize, metricScannedSize, metricLag, metricLagSeconds, metricLastSTHFetch, metricScanErrors} {
+		collector.Reset(name)
+	}
+	for _, status := range makeHealthReport().Logs {
+		logURI := "https://" + status.URL
+		collector.Set(metricTreeSize, float64(status.TreeSize), "log", logURI)
+		collector.Set(metricScannedSize, float64(status.ScannedSize), "log", logURI)
+		collector.Set(metricLag, float64(status.Lag), "log", logURI)
+		if status.LagSince != nil {
+			collector.Set(metricLagSeconds, time.Since(*status.LagSince).Seconds(), "log", logURI)
+		} else {
+			collector.Set(metricLagSeconds, 0, "log", logURI)
+		}
+		if status.LastSTHFetch != nil {
+			collector.Set(metricLastSTHFetch, float64(status.LastSTHFetch.Unix()), "log", logURI)
+		}
+		collector.Set(metricScanErrors, float64(status.Errors), "log", logURI)
+	}
+}
+
+func collectQueueMetrics(collector *certspotter.Collector) {
+	collector.Reset(metricQueueDepth)
+	if state == nil {
+		return
+	}
+	notifications, err := state.GetNotifications()
+	if err != nil {
+		return
+	}
+	notifiersLock.RLock()
+	for _, retry := range retries {
+		collector.Set(metricQueueDepth, 0, "channel", retry.Channel())
+	}
+	notifiersLock.RUnlock()
+	for _, notification := range notifications {
+		collector.Add(metricQueueDepth, 1, "channel", notification.Channel)
+	}
+}
+
+// countingNotifier counts the notifications sent by a Notifier, and its
+// failures, and records them in the -journal
+type countingNotifier struct {
+	certspotter.Notifier
+}
+
+func (notifier countingNotifier) count(infos []*certspotter.EntryInfo, err error) error {
+	journalNotification(notifier.Channel(), infos, err)
+	if err != nil {
+		collector.Add(metricNotificationFailures, 1, "channel", notifier.Channel())
+	} else {
+		collector.Add(metricNotificationsSent, 1, "channel", notifier.Channel())
+	}
+	return err
+}
+
+func (notifier countingNotifier) Notify(info *certspotter.EntryInfo) error {
+	return notifier.count([]*certspotter.EntryInfo{info}, notifier.Notifier.Notify(info))
+}
+
+type countingDigestNotifier struct {
+	countingNotifier
+	digestNotifier certspotter.DigestNotifier
+}
+
+func (notifier countingDigestNotifier) NotifyDigest(infos []*certspotter.EntryInfo) error {
+	return notifier.count(infos, notifier.digestNotifier.NotifyDigest(infos))
+}
+
+func countNotifications(notifier certspotter.Notifier) certspotter.Notifier {
+	if digestNotifier, ok := notifier.(certspotter.DigestNotifier); ok {
+		return countingDigestNotifier{countingNotifier{notifier}, digestNotifier}
+	}
+	return countingNotifier{notifier}
+}
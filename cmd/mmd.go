@@ -0,0 +1,116 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// Remember the SCTs embedded in a certificate that we've reported, so that
+// we can later check that the logs which issued them kept their promise
+// to incorporate the pre-certificate within the MMD.
+func recordPendingSCTs(info *certspotter.EntryInfo) {
+	scts, precertEntry, err := certspotter.GetEmbeddedSCTs(info)
+	if err != nil {
+		slog.Debug("Unable to process embedded SCTs", "fingerprint", info.Fingerprint(), "error", err)
+		return
+	}
+	for i := range scts {
+		sct := &scts[i]
+		logInfo := findLogByID(monitoredLogs, sct.LogID)
+		if logInfo == nil {
+			continue
+		}
+		pubkey, err := logInfo.ParsedPublicKey()
+		if err != nil || pubkey == nil {
+			continue
+		}
+		if err := verifySCTSignature(logInfo, sct, precertEntry); err != nil {
+			slog.Warn("Embedded SCT has an invalid signature", "log", logInfo.FullURI(), "fingerprint", info.Fingerprint(), "error", err)
+			continue
+		}
+		pending, err := certspotter.MakePendingSCT(sct, precertEntry, info.Fingerprint())
+		if err != nil {
+			slog.Warn("Unable to compute leaf hash for embedded SCT", "log", logInfo.FullURI(), "fingerprint", info.Fingerprint(), "error", err)
+			continue
+		}
+		logState, err := state.OpenLogState(logInfo)
+		if err != nil {
+			slog.Error("Error opening state directory", "log", logInfo.FullURI(), "error", err)
+			continue
+		}
+		if err := logState.StorePendingSCT(pending); err != nil {
+			slog.Error("Error storing pending SCT", "log", logInfo.FullURI(), "error", err)
+		}
+	}
+}
+
+func (ctlog *logHandle) checkSTHAge() error {
+	if err := certspotter.CheckSTHAge(ctlog.latestSTH, ctlog.logInfo.MaximumMergeDelay(), time.Now()); err != nil {
+		return fmt.Errorf("Log has misbehaved: %s", err)
+	}
+	return nil
+}
+
+// Check that the log has incorporated every entry for which it issued an SCT
+// whose MMD deadline has passed as of the latest verified STH.
+func (ctlog *logHandle) checkPendingSCTs() error {
+	pendings, err := ctlog.state.GetPendingSCTs()
+	if err != nil {
+		return fmt.Errorf("Error loading pending SCTs: %s", err)
+	}
+
+	mmd := ctlog.logInfo.MaximumMergeDelay()
+	var firstErr error
+	for _, pending := range pendings {
+		if !pending.IsDue(ctlog.verifiedSTH, mmd) {
+			continue
+		}
+		var index uint64
+		var auditPath ct.AuditPath
+		var err error
+		if pending.LeafIndex != nil {
+			// Tiled logs can't look up entries by hash, but their SCTs say
+			// where the entry is
+			index = *pending.LeafIndex
+			auditPath, err = ctlog.scanner.VerifyInclusion(pending.LeafHash, int64(index), ctlog.verifiedSTH)
+		} else {
+			index, auditPath, err = ctlog.scanner.CheckIncorporation(pending.LeafHash, ctlog.verifiedSTH)
+		}
+		if err != nil {
+			if firstErr == nil {
+				description := fmt.Sprintf("SCT in certificate %s was not incorporated by %s, within the Maximum Merge Delay (if this error persists, it should be construed as misbehavior by the log): %s", pending.Fingerprint, pending.Deadline(mmd), err)
+				evidence := certspotter.NewEvidence(certspotter.EvidenceUnincorporatedEntry, description, ctlog.scanner.LogUri, ctlog.verifiedSTH)
+				evidence.PendingSCTs = []certspotter.PendingSCT{*pending}
+				entry := certspotter.EvidenceEntry{LeafInput: pending.LeafInput}
+				if pending.LeafIndex != nil || auditPath != nil {
+					// Either the SCT or the log's failed proof says where
+					// the entry should be
+					entryIndex := int64(index)
+					entry.Index = &entryIndex
+				}
+				evidence.Entries = []certspotter.EvidenceEntry{entry}
+				evidence.AuditPath = auditPath
+				firstErr = ctlog.misbehavior(evidence)
+			}
+			continue
+		}
+		ctlog.logger.Debug("SCT was incorporated", "fingerprint", pending.Fingerprint, "index", index)
+		if err := ctlog.state.RemovePendingSCT(pending); err != nil {
+			return fmt.Errorf("Error removing pending SCT: %s", err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,333 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/notify"
+	"software.sslmate.com/src/certspotter/tracing"
+)
+
+var emailSMTP = flag.String("email_smtp", "", "Email matching certificates via the SMTP server at smtp://[USER:PASSWORD@]HOST[:PORT] or smtps://...")
+var emailFrom = flag.String("email_from", "", "From address for -email_smtp (default: certspotter@HOSTNAME)")
+var emailTo = flag.String("email_to", "", "Comma-separated list of addresses to send -email_smtp notifications to")
+var smtpStartTLS = flag.String("smtp_starttls", "", "Whether -email_smtp requires STARTTLS: required or opportunistic (default: required if the URL has a password, otherwise opportunistic)")
+var emailTemplate = flag.String("email_template", "", "Template file for -email_smtp messages (see README)")
+var webhookURL = flag.String("webhook", "", "POST a JSON object about each matching certificate to this URL")
+var webhookRoutes = flag.String("webhook_routes", "", "File of watchlist item IDs and the URLs to POST their matches to instead of -webhook")
+var webhookSecretFile = flag.String("webhook_secret_file", "", "File containing a secret with which to sign webhook requests")
+var webhookTemplate = flag.String("webhook_template", "", "Template file for webhook request bodies, instead of JSON (see README)")
+var slackWebhook = flag.String("slack_webhook", "", "Post a message about each matching certificate to this Slack incoming webhook URL")
+var slackRoutes = flag.String("slack_routes", "", "File of watchlist item IDs and the Slack webhook URLs to post their matches to instead of -slack_webhook")
+var slackTemplate = flag.String("slack_template", "", "Template file for Slack messages (see README)")
+var pagerDutyKeyFile = flag.String("pagerduty_key_file", "", "File containing a PagerDuty Events API v2 integration key with which to trigger alerts")
+var pagerDutyTriggers = flag.String("pagerduty_triggers", "", "Comma-separated list of watchlist item IDs and match categories which trigger PagerDuty alerts (default: all)")
+var pagerDutySeverity = flag.String("pagerduty_severity", notify.PagerDutyCritical, "Severity of PagerDuty alerts (critical, error, warning, or info)")
+var mispURL = flag.String("misp", "", "Record matching certificates as events in the MISP instance at this URL")
+var mispKeyFile = flag.String("misp_key_file", "", "File containing the MISP API key to use with -misp")
+var mispEvent = flag.String("misp_event", "", "ID or UUID of an existing MISP event to add matches to, instead of creating an event for each certificate")
+var mispDistribution = flag.String("misp_distribution", "organisation", "Distribution of the MISP events created by -misp (organisation, community, connected, or all)")
+var mispTags = flag.String("misp_tags", "", "Comma-separated list of tags to add to the MISP events created by -misp")
+var theHiveURL = flag.String("thehive", "", "Raise an alert about each matching certificate in the TheHive instance at this URL")
+var theHiveKeyFile = flag.String("thehive_key_file", "", "File containing the TheHive API key to use with -thehive")
+var theHiveSeverity = flag.String("thehive_severity", "medium", "Severity of TheHive alerts (low, medium, high, or critical)")
+var theHiveSeverities = flag.String("thehive_severities", "", "Comma-separated list of ID=SEVERITY, giving the severity of TheHive alerts for matches of watchlist item IDs or match categories (e.g. lookalike=high)")
+var theHiveTags = flag.String("thehive_tags", "", "Comma-separated list of tags to add to TheHive alerts")
+var syslogServer = flag.String("syslog", "", "Send an RFC 5424 syslog message about each matching certificate to udp://HOST[:PORT], tcp://HOST[:PORT], or tls://HOST[:PORT]")
+var execCommand = flag.String("exec", "", "Command to run for each matching certificate, with the certificate chain as PEM on stdin")
+var execTimeout = flag.Int("exec_timeout", 60, "Number of seconds after which to kill the -exec command")
+var digestMinutes = flag.Int("digest", 0, "Instead of notifying about each matching certificate, send a digest of those found in this many minutes")
+var digestChannels = flag.String("digest_channels", "", "Comma-separated list of notifiers (email, slack, webhook, etc.) to use -digest for (default: all)")
+var notifyAttempts = flag.Int("notify_attempts", notify.DefaultMaxAttempts, "Maximum number of attempts to deliver each notification; failed notifications are retried with backoff on later runs")
+var notifyDedup = flag.Bool("notify_dedup", false, "Don't notify about a certificate more than once on each notifier, even across runs")
+var notifyRateLimit = flag.Int("notify_rate_limit", 0, "Maximum number of notifications per hour on each notifier (0 for no limit)")
+var notifyAlways = flag.String("notify_always", "", "Comma-separated list of watchlist item IDs whose matches are exempt from -notify_dedup and -notify_rate_limit")
+
+var notifiers []certspotter.Notifier
+var retries []*notify.Retry
+
+// notifiersLock guards notifiers, retries, and profiles, which are replaced
+// when the configuration is reloaded, even while scans carry on in the
+// background.  It's held for reading while they're in use, so once it's
+// been held for writing to replace them, the old ones can safely be closed.
+var notifiersLock sync.RWMutex
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// openNotifiers opens the notifiers configured by the flags, returning them
+// and the Retry wrappers among them
+func openNotifiers(store certspotter.Store) ([]certspotter.Notifier, []*notify.Retry, error) {
+	var notifiers []certspotter.Notifier
+	var retries []*notify.Retry
+	if *emailSMTP != "" {
+		from := *emailFrom
+		if from == "" {
+			hostname, _ := os.Hostname()
+			from = "certspotter@" + hostname
+		}
+		emailNotifier, err := notify.NewEmailNotifier(*emailSMTP, from, splitList(*emailTo))
+		if err != nil {
+			return nil, nil, err
+		}
+		switch *smtpStartTLS {
+		case "":
+		case notify.StartTLSRequired, notify.StartTLSOpportunistic:
+			emailNotifier.StartTLS = *smtpStartTLS
+		default:
+			return nil, nil, fmt.Errorf("Invalid -smtp_starttls `%s': must be required or opportunistic", *smtpStartTLS)
+		}
+		if *emailTemplate != "" {
+			if emailNotifier.Template, emailNotifier.Digest, err = notify.LoadTemplate(*emailTemplate, emailNotifier.Template, emailNotifier.Digest); err != nil {
+				return nil, nil, fmt.Errorf("Error loading email template: %s", err)
+			}
+		}
+		notifiers = append(notifiers, emailNotifier)
+	}
+	if *webhookURL != "" || *webhookRoutes != "" {
+		webhookNotifier := notify.NewWebhookNotifier(*webhookURL)
+		if *webhookRoutes != "" {
+			if err := webhookNotifier.LoadRoutes(*webhookRoutes); err != nil {
+				return nil, nil, fmt.Errorf("Error reading webhook routes: %s", err)
+			}
+		}
+		if *webhookSecretFile != "" {
+			secret, err := ioutil.ReadFile(*webhookSecretFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error reading webhook secret: %s", err)
+			}
+			webhookNotifier.Secret = bytes.TrimSpace(secret)
+		}
+		if *webhookTemplate != "" {
+			var err error
+			if webhookNotifier.Template, webhookNotifier.Digest, err = notify.LoadTemplate(*webhookTemplate, &notify.Template{}, &notify.Template{}); err != nil {
+				return nil, nil, fmt.Errorf("Error loading webhook template: %s", err)
+			}
+		}
+		notifiers = append(notifiers, webhookNotifier)
+	}
+	if *slackWebhook != "" || *slackRoutes != "" {
+		slackNotifier := notify.NewSlackNotifier(*slackWebhook)
+		if *slackRoutes != "" {
+			if err := slackNotifier.LoadRoutes(*slackRoutes); err != nil {
+				return nil, nil, fmt.Errorf("Error reading Slack routes: %s", err)
+			}
+		}
+		if *slackTemplate != "" {
+			var err error
+			if slackNotifier.Template, slackNotifier.Digest, err = notify.LoadTemplate(*slackTemplate, slackNotifier.Template, slackNotifier.Digest); err != nil {
+				return nil, nil, fmt.Errorf("Error loading Slack template: %s", err)
+			}
+		}
+		notifiers = append(notifiers, slackNotifier)
+	}
+	if *pagerDutyKeyFile != "" {
+		routingKey, err := ioutil.ReadFile(*pagerDutyKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error reading PagerDuty integration key: %s", err)
+		}
+		pagerDutyNotifier, err := notify.NewPagerDutyNotifier(string(bytes.TrimSpace(routingKey)), *pagerDutySeverity)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, trigger := range splitList(*pagerDutyTriggers) {
+			pagerDutyNotifier.Triggers[trigger] = true
+		}
+		notifiers = append(notifiers, pagerDutyNotifier)
+	}
+	if *mispURL != "" {
+		if *mispKeyFile == "" {
+			return nil, nil, fmt.Errorf("-misp requires -misp_key_file")
+		}
+		authKey, err := ioutil.ReadFile(*mispKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error reading MISP API key: %s", err)
+		}
+		mispNotifier := notify.NewMISPNotifier(*mispURL, string(bytes.TrimSpace(authKey)))
+		mispNotifier.EventID = *mispEvent
+		if mispNotifier.Distribution, err = notify.ParseMISPDistribution(*mispDistribution); err != nil {
+			return nil, nil, err
+		}
+		mispNotifier.Tags = splitList(*mispTags)
+		notifiers = append(notifiers, mispNotifier)
+	}
+	if *theHiveURL != "" {
+		if *theHiveKeyFile == "" {
+			return nil, nil, fmt.Errorf("-thehive requires -thehive_key_file")
+		}
+		apiKey, err := ioutil.ReadFile(*theHiveKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error reading TheHive API key: %s", err)
+		}
+		theHiveNotifier := notify.NewTheHiveNotifier(*theHiveURL, string(bytes.TrimSpace(apiKey)))
+		if theHiveNotifier.Severity, err = notify.ParseTheHiveSeverity(*theHiveSeverity); err != nil {
+			return nil, nil, err
+		}
+		for _, item := range splitList(*theHiveSeverities) {
+			fields := strings.SplitN(item, "=", 2)
+			if len(fields) != 2 {
+				return nil, nil, fmt.Errorf("Invalid -thehive_severities item `%s': expected ID=SEVERITY", item)
+			}
+			if theHiveNotifier.Severities[strings.TrimSpace(fields[0])], err = notify.ParseTheHiveSeverity(strings.TrimSpace(fields[1])); err != nil {
+				return nil, nil, err
+			}
+		}
+		theHiveNotifier.Tags = splitList(*theHiveTags)
+		notifiers = append(notifiers, theHiveNotifier)
+	}
+	if *syslogServer != "" {
+		syslogNotifier, err := notify.NewSyslogNotifier(*syslogServer)
+		if err != nil {
+			return nil, nil, err
+		}
+		notifiers = append(notifiers, syslogNotifier)
+	}
+	if *execCommand != "" {
+		execNotifier := notify.NewExecNotifier(*execCommand)
+		execNotifier.Timeout = time.Duration(*execTimeout) * time.Second
+		notifiers = append(notifiers, execNotifier)
+	}
+	for i, notifier := range notifiers {
+		notifiers[i] = countNotifications(notifier)
+	}
+	if *notifyAttempts > 1 {
+		for i, notifier := range notifiers {
+			retry := notify.NewRetry(notifier, store)
+			retry.MaxAttempts = *notifyAttempts
+			retries = append(retries, retry)
+			notifiers[i] = retry
+		}
+	}
+	if *notifyDedup || *notifyRateLimit > 0 {
+		if _, isNotifiedStore := store.(certspotter.NotifiedStore); *notifyDedup && !isNotifiedStore {
+			return nil, nil, fmt.Errorf("-notify_dedup is not supported by this store")
+		}
+		for i, notifier := range notifiers {
+			limit := notify.NewLimit(notifier)
+			limit.Dedup = *notifyDedup
+			limit.Store, _ = store.(certspotter.NotifiedStore)
+			limit.Rate = *notifyRateLimit
+			for _, id := range splitList(*notifyAlways) {
+				limit.Always[id] = true
+			}
+			notifiers[i] = limit
+		}
+	}
+	if *digestMinutes > 0 {
+		channels := splitList(*digestChannels)
+		for i, notifier := range notifiers {
+			if len(channels) == 0 || contains(channels, notifier.Channel()) {
+				notifiers[i] = notify.NewDigest(notifier, time.Duration(*digestMinutes)*time.Minute)
+			}
+		}
+	}
+	return notifiers, retries, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// retryNotifications retries queued notifications which are due.  The
+// state must be locked.
+func retryNotifications() {
+	notifiersLock.RLock()
+	defer notifiersLock.RUnlock()
+	for _, retry := range retries {
+		if err := retry.RetryPending(); err != nil {
+			slog.Error(err.Error(), "channel", retry.Channel())
+		}
+	}
+	for _, p := range profiles {
+		for _, retry := range p.retries {
+			if err := retry.RetryPending(); err != nil {
+				slog.Error(err.Error(), "profile", p.name, "channel", retry.Channel())
+			}
+		}
+	}
+}
+
+// closeNotifiers sends any pending digests, including the profiles', and
+// returns 1 if any couldn't be sent
+func closeNotifiers() int {
+	notifiersLock.Lock()
+	defer notifiersLock.Unlock()
+	exitCode := closeNotifierList(notifiers)
+	exitCode |= closeProfiles(profiles, nil)
+	notifiers = nil
+	profiles = nil
+	return exitCode
+}
+
+func closeNotifierList(list []certspotter.Notifier) int {
+	exitCode := 0
+	for _, notifier := range list {
+		if closer, ok := notifier.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				slog.Error(err.Error(), "channel", notifier.Channel())
+				exitCode = 1
+			}
+		}
+	}
+	return exitCode
+}
+
+// reopenNotifiers replaces the notifiers with new ones configured from the
+// current contents of their files (routes, templates, keys, etc.), sending
+// the old ones' pending digests once nothing is using them.  If the new ones
+// can't be opened, the old ones are kept.
+func reopenNotifiers(store certspotter.Store) error {
+	newNotifiers, newRetries, err := openNotifiers(store)
+	if err != nil {
+		return err
+	}
+	notifiersLock.Lock()
+	oldNotifiers := notifiers
+	notifiers, retries = newNotifiers, newRetries
+	notifiersLock.Unlock()
+	closeNotifierList(oldNotifiers)
+	return nil
+}
+
+func notifyAll(notifiers []certspotter.Notifier, info *certspotter.EntryInfo) {
+	for _, notifier := range notifiers {
+		span := tracing.Start(info.Span, "Notify", "channel", notifier.Channel(), "log", info.LogUri)
+		span.SetKind(tracing.KindClient)
+		err := notifier.Notify(info)
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			slog.Error(err.Error(), "channel", notifier.Channel())
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+func findLogByID(logs []certspotter.LogInfo, logId ct.SHA256Hash) *certspotter.LogInfo {
+	for i := range logs {
+		if bytes.Equal(logs[i].ID(), logId[:]) {
+			return &logs[i]
+		}
+	}
+	return nil
+}
+
+func verifySTHSignature(logInfo *certspotter.LogInfo, sth *ct.SignedTreeHead) error {
+	pubkey, err := logInfo.ParsedPublicKey()
+	if err != nil {
+		return fmt.Errorf("Bad public key: %s", err)
+	}
+	verifier, err := ct.NewSignatureVerifier(pubkey)
+	if err != nil {
+		return err
+	}
+	return verifier.VerifySTHSignature(*sth)
+}
+
+func verifySCTSignature(logInfo *certspotter.LogInfo, sct *ct.SignedCertificateTimestamp, entry *ct.LogEntry) error {
+	pubkey, err := logInfo.ParsedPublicKey()
+	if err != nil {
+		return fmt.Errorf("Bad public key: %s", err)
+	}
+	verifier, err := ct.NewSignatureVerifier(pubkey)
+	if err != nil {
+		return err
+	}
+	return verifier.VerifySCTSignature(*sct, *entry)
+}
+
+// Exchange our verified STHs with the pollination server, and queue the STHs
+// we receive in return as unverified STHs, so they are audited for consistency
+// with our view of the log during the next run.
+func pollinate(serverUri string, logs []certspotter.LogInfo) error {
+	sths := []ct.SignedTreeHead{}
+	for i := range logs {
+		logState, err := state.OpenLogState(&logs[i])
+		if err != nil {
+			return fmt.Errorf("%s: Error opening state directory: %s", logs[i].Url, err)
+		}
+		sth, err := logState.GetVerifiedSTH()
+		if err != nil {
+			return fmt.Errorf("%s: Error loading verified STH: %s", logs[i].Url, err)
+		}
+		if sth != nil && sth.Version == ct.V1 { // pollination only supports RFC 6962 STHs
+			sths = append(sths, *sth)
+		}
+	}
+
+	slog.Debug("Sending STHs to pollination server", "server", serverUri, "count", len(sths))
+	receivedSTHs, err := certspotter.Pollinate(serverUri, sths)
+	if err != nil {
+		return fmt.Errorf("Error exchanging STHs with pollination server %s: %s", serverUri, err)
+	}
+
+	for i := range receivedSTHs {
+		sth := &receivedSTHs[i]
+		logInfo := findLogByID(logs, sth.LogID)
+		if logInfo == nil || sth.Version != ct.V1 {
+			continue
+		}
+		if err := verifySTHSignature(logInfo, sth); err != nil {
+			slog.Warn("Ignoring STH from pollination server with invalid signature", "log", logInfo.FullURI(), "tree_size", sth.TreeSize, "error", err)
+			continue
+		}
+		logState, err := state.OpenLogState(logInfo)
+		if err != nil {
+			return fmt.Errorf("%s: Error opening state directory: %s", logInfo.Url, err)
+		}
+		if err := logState.StoreUnverifiedSTH(sth); err != nil {
+			return fmt.Errorf("%s: Error storing unverified STH: %s", logInfo.Url, err)
+		}
+	}
+	slog.Debug("Received fresh STHs from pollination server", "server", serverUri, "count", len(receivedSTHs))
+
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+var pprofAddr = flag.String("pprof_addr", "", "Serve net/http/pprof profiles on this address (e.g. localhost:6060) in -daemon mode")
+
+const pprofSocketName = "pprof"
+
+// startPprofServer serves the profiling endpoints under /debug/pprof/ on
+// -pprof_addr, or on the socket passed by systemd.  They're kept off
+// httpMux so they're only exposed when explicitly asked for.
+func startPprofServer() (net.Listener, error) {
+	if err := loadActivatedListeners(); err != nil {
+		return nil, err
+	}
+	if _, activated := activatedListeners[pprofSocketName]; !activated && *pprofAddr == "" {
+		return nil, nil
+	}
+	listener, err := listen(pprofSocketName, *pprofAddr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil && !isStopping() {
+			slog.Error("Profiling server stopped", "error", err)
+		}
+	}()
+	return listener, nil
+}
@@ -0,0 +1,372 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/notify"
+)
+
+var profilesFilename = flag.String("profiles", "", "JSON file describing several watch profiles, each with its own watchlist, notifiers, and state, to check the same log entries against (see README)")
+
+// MakeMatcher, if set, makes a Matcher from the current flags (-watchlist,
+// filters, etc.).  It's called with each profile's flags set, so it's
+// required for -profiles.
+var MakeMatcher func() (certspotter.Matcher, error)
+
+// The flags defined by this package which a profile may set.  This package's
+// other flags control the shared scan, so they apply to every profile.
+var profileFlagNames = []string{
+	"email_smtp", "email_from", "email_to", "email_template", "smtp_starttls",
+	"webhook", "webhook_routes", "webhook_secret_file", "webhook_template",
+	"slack_webhook", "slack_routes", "slack_template",
+	"pagerduty_key_file", "pagerduty_triggers", "pagerduty_severity",
+	"syslog", "exec", "exec_timeout",
+	"digest", "digest_channels",
+	"notify_attempts", "notify_dedup", "notify_rate_limit", "notify_always",
+	"script", "no_save", "pair_precerts",
+}
+
+// sharedFlags contains the flags which a profile may not set
+var sharedFlags = map[string]bool{"state_dir": true, "store": true}
+
+func init() {
+	// Only this package's flags have been defined at this point; the
+	// program's flags (-watchlist, etc.) are defined later
+	flag.VisitAll(func(f *flag.Flag) {
+		sharedFlags[f.Name] = true
+	})
+	for _, name := range profileFlagNames {
+		delete(sharedFlags, name)
+	}
+}
+
+// repeatableFlag is a flag which accumulates its values when it's specified
+// more than once.  Setting it in a profile replaces its values instead.
+type repeatableFlag interface {
+	flag.Value
+	Values() []string
+	Reset()
+}
+
+// profileFlagValue is the value of a flag in the profiles file: a string,
+// number, or boolean, or an array of strings for a repeatable flag
+type profileFlagValue []string
+
+func (value *profileFlagValue) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*value = list
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*value = profileFlagValue{str}
+		return nil
+	}
+	var scalar interface{}
+	if err := json.Unmarshal(data, &scalar); err != nil {
+		return err
+	}
+	switch scalar.(type) {
+	case bool, float64:
+		*value = profileFlagValue{string(bytes.TrimSpace(data))}
+		return nil
+	}
+	return fmt.Errorf("flag value must be a string, number, boolean, or array of strings")
+}
+
+type profileConfig struct {
+	Name     string                      `json:"name"`
+	StateDir string                      `json:"state_dir"` // default: STATE_DIR/profiles/NAME
+	Flags    map[string]profileFlagValue `json:"flags"`
+}
+
+type profilesFile struct {
+	Profiles []profileConfig `json:"profiles"`
+}
+
+// A profile checks the scanned entries against its own Matcher, and reports
+// its matches to its own notifiers, keeping its certificates, notification
+// queue, etc. in its own store.  Scan positions are kept in the main state.
+type profile struct {
+	name      string
+	statePath string
+	store     certspotter.Store // locked while the profile is open
+	matcher   certspotter.Matcher
+	notifiers []certspotter.Notifier
+	retries   []*notify.Retry
+	script    string
+	pipeline  func(*certspotter.EntryInfo)
+}
+
+var profiles []*profile
+
+// UsingProfiles returns true if -profiles was specified, in which case the
+// program's ProcessCallback is ignored, so it needn't load a watchlist
+func UsingProfiles() bool {
+	return *profilesFilename != ""
+}
+
+func loadProfilesFile(filename string) ([]profileConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file profilesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	if len(file.Profiles) == 0 {
+		return nil, fmt.Errorf("%s: no profiles defined", filename)
+	}
+	names := make(map[string]bool)
+	for _, config := range file.Profiles {
+		if config.Name == "" || config.Name == "." || config.Name == ".." || filepath.Base(config.Name) != config.Name {
+			return nil, fmt.Errorf("%s: invalid profile name %q", filename, config.Name)
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("%s: profile %s is defined more than once", filename, config.Name)
+		}
+		names[config.Name] = true
+		for name := range config.Flags {
+			if flag.Lookup(name) == nil {
+				return nil, fmt.Errorf("%s: profile %s: unknown flag -%s", filename, config.Name, name)
+			}
+			if sharedFlags[name] {
+				return nil, fmt.Errorf("%s: profile %s: -%s applies to all profiles, so it can't be set by a profile", filename, config.Name, name)
+			}
+		}
+	}
+	return file.Profiles, nil
+}
+
+// setFlags sets |flags|, and returns a function which restores their
+// previous values
+func setFlags(flags map[string]profileFlagValue) (func(), error) {
+	var restores []func()
+	restore := func() {
+		for i := len(restores) - 1; i >= 0; i-- {
+			restores[i]()
+		}
+	}
+	for name, values := range flags {
+		f := flag.Lookup(name)
+		if repeatable, isRepeatable := f.Value.(repeatableFlag); isRepeatable {
+			saved := repeatable.Values()
+			restores = append(restores, func() {
+				repeatable.Reset()
+				for _, value := range saved {
+					repeatable.Set(value)
+				}
+			})
+			repeatable.Reset()
+			for _, value := range values {
+				if err := repeatable.Set(value); err != nil {
+					restore()
+					return nil, fmt.Errorf("Invalid value %q for -%s: %s", value, name, err)
+				}
+			}
+		} else {
+			if len(values) != 1 {
+				restore()
+				return nil, fmt.Errorf("-%s must have exactly one value", name)
+			}
+			saved := f.Value.String()
+			restores = append(restores, func() { f.Value.Set(saved) })
+			if err := f.Value.Set(values[0]); err != nil {
+				restore()
+				return nil, fmt.Errorf("Invalid value %q for -%s: %s", values[0], name, err)
+			}
+		}
+	}
+	return restore, nil
+}
+
+// profileStatePath returns the path of the state of the profile described
+// by |config|
+func profileStatePath(config profileConfig) (string, error) {
+	if config.StateDir != "" {
+		return config.StateDir, nil
+	}
+	fsState, isFS := state.(*State)
+	if !isFS {
+		return "", fmt.Errorf("state_dir must be specified, since the main state isn't kept in a state directory")
+	}
+	profilesDir := filepath.Join(fsState.path, "profiles")
+	if err := os.Mkdir(profilesDir, 0777); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("Error creating profiles directory: %s", err)
+	}
+	return filepath.Join(profilesDir, config.Name), nil
+}
+
+// openProfileStore opens and locks the store at |statePath|
+func openProfileStore(statePath string) (certspotter.Store, error) {
+	store, err := OpenState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := store.Lock()
+	if err != nil {
+		closeStore(store)
+		return nil, fmt.Errorf("Error locking state %s: %s", statePath, err)
+	}
+	if !locked {
+		closeStore(store)
+		return nil, fmt.Errorf("State %s is already in use by another instance", statePath)
+	}
+	return store, nil
+}
+
+// closeStore closes |store|, if it needs to be closed
+func closeStore(store certspotter.Store) error {
+	if closer, ok := store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// openProfile opens the profile described by |config|, with its flags set.
+// If |stores| (keyed by state path) has its store, that store is used
+// instead of opening it again.
+func openProfile(config profileConfig, stores map[string]certspotter.Store) (*profile, error) {
+	restore, err := setFlags(config.Flags)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+
+	p := &profile{name: config.Name, script: *script}
+	if p.statePath, err = profileStatePath(config); err != nil {
+		return nil, err
+	}
+	if p.store = stores[p.statePath]; p.store == nil {
+		if p.store, err = openProfileStore(p.statePath); err != nil {
+			return nil, err
+		}
+	}
+	if p.matcher, err = MakeMatcher(); err != nil {
+		closeProfiles([]*profile{p}, stores)
+		return nil, err
+	}
+
+	if p.notifiers, p.retries, err = openNotifiers(p.store); err != nil {
+		closeProfiles([]*profile{p}, stores)
+		return nil, err
+	}
+
+	p.pipeline = makeProfilePipeline(p.store, func(info *certspotter.EntryInfo) {
+		reportEntryTo(info, p.notifiers, p.script)
+	})
+	return p, nil
+}
+
+// profileStores returns the stores of |list|, keyed by state path
+func profileStores(list []*profile) map[string]certspotter.Store {
+	stores := make(map[string]certspotter.Store)
+	for _, p := range list {
+		stores[p.statePath] = p.store
+	}
+	return stores
+}
+
+// openProfiles opens the profiles in the -profiles file.  The stores of
+// |existing| profiles are reused by new profiles with the same state path,
+// since they're already locked.
+func openProfiles(existing []*profile) ([]*profile, error) {
+	if MakeMatcher == nil {
+		return nil, fmt.Errorf("-profiles is not supported by this program")
+	}
+	if dedup != nil {
+		return nil, fmt.Errorf("-profiles can't be used with -dedup")
+	}
+	configs, err := loadProfilesFile(*profilesFilename)
+	if err != nil {
+		return nil, err
+	}
+	existingStores := profileStores(existing)
+	var newProfiles []*profile
+	for _, config := range configs {
+		p, err := openProfile(config, existingStores)
+		if err != nil {
+			closeProfiles(newProfiles, existingStores)
+			return nil, fmt.Errorf("Error opening profile %s: %s", config.Name, err)
+		}
+		newProfiles = append(newProfiles, p)
+	}
+	return newProfiles, nil
+}
+
+// closeProfiles sends the profiles' pending digests, then unlocks and closes
+// their stores, except for those in |keep|.  It returns 1 if anything
+// failed.
+func closeProfiles(list []*profile, keep map[string]certspotter.Store) int {
+	exitCode := 0
+	for _, p := range list {
+		exitCode |= closeNotifierList(p.notifiers)
+		if keep[p.statePath] == p.store {
+			continue
+		}
+		if err := p.store.Unlock(); err != nil {
+			slog.Error("Error unlocking profile state", "profile", p.name, "error", err)
+			exitCode = 1
+		}
+		if err := closeStore(p.store); err != nil {
+			slog.Error("Error closing profile state", "profile", p.name, "error", err)
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// reopenProfiles replaces the profiles with ones loaded from the current
+// contents of the -profiles file, closing the old ones once nothing is using
+// them.  If they can't be loaded, the old ones are kept.  Opening a profile
+// sets its flags temporarily, so entries aren't processed in the meantime.
+func reopenProfiles() error {
+	notifiersLock.Lock()
+	newProfiles, err := openProfiles(profiles)
+	if err != nil {
+		notifiersLock.Unlock()
+		return err
+	}
+	oldProfiles := profiles
+	profiles = newProfiles
+	notifiersLock.Unlock()
+	closeProfiles(oldProfiles, profileStores(newProfiles))
+	return nil
+}
+
+// profilesCallback is the ProcessCallback for -profiles.  It parses each
+// entry once, and passes it through the pipeline of each profile whose
+// Matcher matches it.  Each profile gets its own copy of the entry.
+func profilesCallback(scanner *certspotter.Scanner, entry *ct.LogEntry) {
+	parsed := certspotter.NewEntryInfo(scanner.LogUri, entry)
+	notifiersLock.RLock()
+	defer notifiersLock.RUnlock()
+	for _, p := range profiles {
+		if matches := p.matcher.Match(parsed); len(matches) != 0 {
+			info := *parsed
+			info.Matches = matches
+			p.pipeline(&info)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProfileFlagValue(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected profileFlagValue
+		ok       bool
+	}{
+		{`"smtp://localhost"`, profileFlagValue{"smtp://localhost"}, true},
+		{`""`, profileFlagValue{""}, true},
+		{`["a", "b"]`, profileFlagValue{"a", "b"}, true},
+		{`[]`, profileFlagValue{}, true},
+		{`true`, profileFlagValue{"true"}, true},
+		{`false`, profileFlagValue{"false"}, true},
+		{`30`, profileFlagValue{"30"}, true},
+		{`1.5`, profileFlagValue{"1.5"}, true},
+		{`null`, nil, true},
+		{`{"a": "b"}`, nil, false},
+		{`[1, 2]`, nil, false},
+	}
+	for _, test := range tests {
+		var value profileFlagValue
+		err := json.Unmarshal([]byte(test.json), &value)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: unexpected error %v", test.json, err)
+		} else if test.ok && !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("%s: decoded as %q, expected %q", test.json, value, test.expected)
+		}
+	}
+}
+
+func TestLoadProfilesFile(t *testing.T) {
+	tests := []struct {
+		json  string
+		names []string
+		err   string // substring of the expected error
+	}{
+		{`{"profiles": [{"name": "a", "flags": {"email_to": "a@example.com"}}, {"name": "b"}]}`, []string{"a", "b"}, ""},
+		{`{"profiles": [{"name": "a", "state_dir": "/var/lib/a", "flags": {"no_save": true, "exec_timeout": 10}}]}`, []string{"a"}, ""},
+		{`{"profiles": []}`, nil, "no profiles defined"},
+		{`{"profiles": [{"name": "a"}, {"name": "a"}]}`, nil, "defined more than once"},
+		{`{"profiles": [{"name": ""}]}`, nil, "invalid profile name"},
+		{`{"profiles": [{"name": ".."}]}`, nil, "invalid profile name"},
+		{`{"profiles": [{"name": "a/b"}]}`, nil, "invalid profile name"},
+		{`{"profiles": [{"name": "a", "flags": {"no_such_flag": "x"}}]}`, nil, "unknown flag -no_such_flag"},
+		{`{"profiles": [{"name": "a", "flags": {"daemon": true}}]}`, nil, "applies to all profiles"},
+		{`{"profiles": [{"name": "a", "flags": {"email_to": {}}}]}`, nil, "flag value must be"},
+		{`not json`, nil, "invalid character"},
+	}
+	dir := t.TempDir()
+	for i, test := range tests {
+		filename := filepath.Join(dir, "profiles.json")
+		if err := os.WriteFile(filename, []byte(test.json), 0666); err != nil {
+			t.Fatal(err)
+		}
+		configs, err := loadProfilesFile(filename)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Test %d: got error %v, expected %q", i, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: %s", i, err)
+			continue
+		}
+		var names []string
+		for _, config := range configs {
+			names = append(names, config.Name)
+		}
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("Test %d: loaded profiles %v, expected %v", i, names, test.names)
+		}
+	}
+	if _, err := loadProfilesFile(filepath.Join(dir, "nonexistent.json")); err == nil {
+		t.Errorf("Loading a nonexistent file succeeded")
+	}
+}
+
+func TestSetFlags(t *testing.T) {
+	*emailTo = "main@example.com"
+	*noSave = false
+	*execTimeout = 60
+	defer func() { *emailTo = "" }()
+
+	restore, err := setFlags(map[string]profileFlagValue{
+		"email_to":     {"profile@example.com"},
+		"no_save":      {"true"},
+		"exec_timeout": {"10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *emailTo != "profile@example.com" || !*noSave || *execTimeout != 10 {
+		t.Errorf("Flags weren't set: %q, %v, %d", *emailTo, *noSave, *execTimeout)
+	}
+	restore()
+	if *emailTo != "main@example.com" || *noSave || *execTimeout != 60 {
+		t.Errorf("Flags weren't restored: %q, %v, %d", *emailTo, *noSave, *execTimeout)
+	}
+
+	tests := []map[string]profileFlagValue{
+		{"exec_timeout": {"soon"}},
+		{"email_to": {"a@example.com", "b@example.com"}},
+		{"email_to": {}},
+	}
+	for _, flags := range tests {
+		if _, err := setFlags(flags); err == nil {
+			t.Errorf("setFlags(%v) succeeded", flags)
+		}
+		if *emailTo != "main@example.com" || *execTimeout != 60 {
+			t.Errorf("Flags weren't restored after setFlags(%v) failed: %q, %d", flags, *emailTo, *execTimeout)
+		}
+	}
+}
+
+func TestOpenProfileStore(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "profile")
+	store, err := openProfileStore(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openProfileStore(statePath); err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("Opening a locked profile store returned %v", err)
+	}
+	list := []*profile{{name: "test", statePath: statePath, store: store}}
+
+	// Stores which are kept, e.g. by reloaded profiles, stay locked
+	closeProfiles(list, profileStores(list))
+	if _, err := openProfileStore(statePath); err == nil {
+		t.Errorf("Store was unlocked even though it was kept")
+	}
+
+	if exitCode := closeProfiles(list, nil); exitCode != 0 {
+		t.Errorf("closeProfiles returned %d", exitCode)
+	}
+	store, err = openProfileStore(statePath)
+	if err != nil {
+		t.Fatalf("Error reopening profile store after closing it: %s", err)
+	}
+	store.Unlock()
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// Reload, if set, is called when reloading the configuration in -daemon
+// mode, to make a new ProcessCallback (e.g. from a reloaded watchlist)
+var Reload func() (certspotter.ProcessCallback, error)
+
+// reloading receives a value when the configuration should be reloaded
+var reloading = make(chan struct{}, 1)
+
+func requestReload() {
+	select {
+	case reloading <- struct{}{}:
+	default:
+	}
+}
+
+// reloadConfig reloads the log list and -log_config, the notifiers'
+// configuration, and (via Reload) the watchlist, or else the -profiles file.
+// Nothing is changed unless all of them load successfully.  Scan positions
+// are kept in the state, so they aren't affected.
+func reloadConfig(logs *[]certspotter.LogInfo, processCallback *certspotter.ProcessCallback) error {
+	newLogs, err := loadLogList()
+	if err != nil {
+		return err
+	}
+	newLogConfigs, err := loadLogConfigs()
+	if err != nil {
+		return err
+	}
+	newCallback := *processCallback
+	if UsingProfiles() {
+		if err := reopenProfiles(); err != nil {
+			return err
+		}
+		newCallback = profilesCallback
+	} else {
+		if Reload != nil {
+			if newCallback, err = Reload(); err != nil {
+				return err
+			}
+		}
+		if err := reopenNotifiers(state); err != nil {
+			return fmt.Errorf("Error reloading notifiers: %s", err)
+		}
+	}
+	*logs = newLogs
+	monitoredLogs = newLogs
+	recordMonitoredLogs(newLogs)
+	logConfigs = newLogConfigs
+	warnUnknownLogConfigs(newLogs)
+	*processCallback = newCallback
+	slog.Info("Reloaded configuration", "logs", len(newLogs))
+	return nil
+}
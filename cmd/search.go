@@ -0,0 +1,130 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// Number of certificates returned by /api/certs, unless limit says
+// otherwise, and the most that limit may ask for
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+type searchResponse struct {
+	Certs      []*certspotter.CertRecord `json:"certs"`
+	NextOffset *int                      `json:"next_offset,omitempty"` // if there are more results
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func parseSearchTime(params url.Values, name string) (*time.Time, error) {
+	value := params.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 time (e.g. 2024-01-31T00:00:00Z)", name)
+	}
+	return &t, nil
+}
+
+func parseSearchInt(params url.Values, name string, defaultValue int) (int, error) {
+	value := params.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// parseSearchQuery makes a CertQuery from the parameters of a request to
+// /api/certs.  A domain prefixed with a dot includes its sub-domains, as in
+// the watchlist.
+func parseSearchQuery(params url.Values) (*certspotter.CertQuery, error) {
+	query := &certspotter.CertQuery{
+		Fingerprint: params.Get("fingerprint"),
+		Issuer:      params.Get("issuer"),
+	}
+	if domain := params.Get("domain"); domain != "" {
+		query.Domain = strings.TrimPrefix(domain, ".")
+		query.IncludeSubdomains = strings.HasPrefix(domain, ".")
+	}
+	var err error
+	if query.IssuedAfter, err = parseSearchTime(params, "issued_after"); err != nil {
+		return nil, err
+	}
+	if query.IssuedBefore, err = parseSearchTime(params, "issued_before"); err != nil {
+		return nil, err
+	}
+	if query.Offset, err = parseSearchInt(params, "offset", 0); err != nil {
+		return nil, err
+	}
+	if query.Limit, err = parseSearchInt(params, "limit", defaultSearchLimit); err != nil {
+		return nil, err
+	}
+	if query.Limit == 0 || query.Limit > maxSearchLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)
+	}
+	return query, nil
+}
+
+// serveSearch searches the certificates saved in the store
+func serveSearch(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeJSONResponse(w, http.StatusMethodNotAllowed, errorResponse{"Only GET is allowed"})
+		return
+	}
+	store, ok := state.(certspotter.CertSearchStore)
+	if !ok {
+		writeJSONResponse(w, http.StatusNotImplemented, errorResponse{"This store can't be searched; use -store"})
+		return
+	}
+	query, err := parseSearchQuery(req.URL.Query())
+	if err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	// Ask for one more than the limit, to find out if there are more
+	limit := query.Limit
+	query.Limit++
+	records, err := store.SearchCerts(query)
+	if err != nil {
+		writeJSONResponse(w, http.StatusInternalServerError, errorResponse{"Error searching store: " + err.Error()})
+		return
+	}
+	response := searchResponse{Certs: records}
+	if len(records) > limit {
+		response.Certs = records[:limit]
+		nextOffset := query.Offset + limit
+		response.NextOffset = &nextOffset
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+func init() {
+	httpMux.HandleFunc("/api/certs", requireAPIToken(serveSearch))
+}
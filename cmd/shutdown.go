@@ -0,0 +1,56 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// stopping is closed when certspotter has been asked to shut down
+var stopping = make(chan struct{})
+
+func isStopping() bool {
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleSignals arranges for SIGTERM and SIGINT to begin a graceful
+// shutdown: scans stop after the current batch, and the entries fetched so
+// far are processed and checkpointed before exiting.  A second signal exits
+// immediately.  In -daemon mode, SIGHUP reloads the configuration.
+func handleSignals() {
+	if *daemonFlag {
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		go func() {
+			for range hangups {
+				requestReload()
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		slog.Info("Shutting down (send the signal again to exit immediately)", "signal", sig)
+		close(stopping)
+		sig = <-signals
+		slog.Warn("Exiting without saving state", "signal", sig)
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,212 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/kafka"
+	"software.sslmate.com/src/certspotter/sink"
+)
+
+var certDir = flag.String("cert_dir", "", "Directory in which to save matching certificates")
+var certLayout = flag.String("cert_layout", "", "Directory layout for -cert_dir: slash-separated list of domain, date, issued, log, fingerprint")
+var certFormat = flag.String("cert_format", "pem", "File format for -cert_dir: pem, der, or json")
+
+var jsonlFilename = flag.String("jsonl", "", "Append matching certificates to this file as JSON lines (- for stdout)")
+var jsonlDER = flag.Bool("jsonl_der", false, "With -jsonl, include the DER-encoded certificate and chain")
+var csvFilename = flag.String("csv", "", "Append matching certificates to this CSV file (- for stdout)")
+var csvColumns = flag.String("csv_columns", sink.DefaultCSVColumns, "Comma-separated list of columns for -csv")
+var pemBundleFilename = flag.String("pem_bundle", "", "Append matching certificates to this file as PEM (- for stdout)")
+var pemBundleChain = flag.Bool("pem_bundle_chain", false, "With -pem_bundle, include the issuer chain")
+var protobufFilename = flag.String("protobuf", "", "Append matching certificates to this file as length-delimited protobuf messages (- for stdout)")
+var protobufDER = flag.Bool("protobuf_der", false, "With -protobuf, include the DER-encoded certificate and chain")
+var templateFilename = flag.String("template", "", "Report matching certificates by executing the text/template in this file")
+var templateOutput = flag.String("template_output", "-", "File to which -template's output is appended (- for stdout)")
+var derDir = flag.String("der_dir", "", "Directory in which to save each matching certificate as FINGERPRINT.der")
+var parquetDir = flag.String("parquet_dir", "", "Directory in which to write matching certificates as Parquet files")
+var parquetRowGroup = flag.Int("parquet_row_group", sink.DefaultParquetRowGroupSize, "Number of certificates per Parquet row group")
+var kafkaBrokers = flag.String("kafka_brokers", "", "Comma-separated list of host:port of Kafka brokers to publish matching certificates to")
+var kafkaTopic = flag.String("kafka_topic", "certspotter", "Kafka topic for -kafka_brokers")
+var kafkaKey = flag.String("kafka_key", sink.KafkaKeyFingerprint, "Key of Kafka messages: fingerprint or domain")
+var kafkaPartitioner = flag.String("kafka_partitioner", kafka.PartitionByKey, "How to choose the Kafka partition: key or round_robin")
+var kafkaAcks = flag.String("kafka_acks", "all", "Acknowledgement to wait for from Kafka: all, leader, or none")
+var kafkaRetries = flag.Int("kafka_retries", 3, "Number of times to retry publishing to Kafka")
+var natsURL = flag.String("nats", "", "Publish matching certificates to the NATS server at nats://HOST:PORT")
+var natsSubject = flag.String("nats_subject", sink.DefaultNATSSubject, "Subject template for -nats")
+var natsJetStream = flag.Bool("nats_jetstream", false, "With -nats, wait for a JetStream stream to acknowledge each message")
+var elasticsearchURL = flag.String("elasticsearch", "", "Index matching certificates into the Elasticsearch or OpenSearch cluster at this URL")
+var elasticsearchIndex = flag.String("elasticsearch_index", sink.DefaultElasticsearchIndex, "Index for -elasticsearch")
+var elasticsearchBatch = flag.Int("elasticsearch_batch", sink.DefaultElasticsearchBatchSize, "Number of certificates per bulk request for -elasticsearch")
+var splunkURL = flag.String("splunk_hec", "", "Send matching certificates to the Splunk HTTP Event Collector at this URL")
+var splunkTokenFile = flag.String("splunk_token_file", "", "File containing the HEC token to use with -splunk_hec")
+var splunkSourceType = flag.String("splunk_sourcetype", sink.DefaultSplunkSourceType, "Sourcetype of events sent by -splunk_hec")
+var splunkIndex = flag.String("splunk_index", "", "Splunk index for -splunk_hec (default: the token's default index)")
+var splunkBatch = flag.Int("splunk_batch", sink.DefaultSplunkBatchSize, "Number of certificates per request for -splunk_hec")
+
+var sinks []certspotter.Sink
+
+// sinksUseStdout is true if a sink writes to stdout, in which case
+// matching certificates aren't also reported there
+var sinksUseStdout bool
+
+func openSinks() error {
+	if *certDir != "" {
+		diskSink, err := sink.NewDiskSink(*certDir, *certLayout, *certFormat)
+		if err != nil {
+			return err
+		}
+		diskSink.Compression = *compressFlag
+		sinks = append(sinks, diskSink)
+	}
+	if *derDir != "" {
+		derSink, err := sink.NewDiskSink(*derDir, "", sink.FormatDER)
+		if err != nil {
+			return err
+		}
+		derSink.PlainNames = true
+		sinks = append(sinks, derSink)
+	}
+	if *jsonlFilename != "" {
+		jsonlSink, err := sink.NewJSONLinesSink(*jsonlFilename)
+		if err != nil {
+			return err
+		}
+		jsonlSink.IncludeDER = *jsonlDER
+		sinks = append(sinks, jsonlSink)
+		sinksUseStdout = sinksUseStdout || sink.IsStdout(*jsonlFilename)
+	}
+	if *csvFilename != "" {
+		csvSink, err := sink.NewCSVSink(*csvFilename, *csvColumns)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, csvSink)
+		sinksUseStdout = sinksUseStdout || sink.IsStdout(*csvFilename)
+	}
+	if *pemBundleFilename != "" {
+		pemSink, err := sink.NewPEMBundleSink(*pemBundleFilename)
+		if err != nil {
+			return err
+		}
+		pemSink.IncludeChain = *pemBundleChain
+		sinks = append(sinks, pemSink)
+		sinksUseStdout = sinksUseStdout || sink.IsStdout(*pemBundleFilename)
+	}
+	if *protobufFilename != "" {
+		protobufSink, err := sink.NewProtobufSink(*protobufFilename)
+		if err != nil {
+			return err
+		}
+		protobufSink.IncludeDER = *protobufDER
+		sinks = append(sinks, protobufSink)
+		sinksUseStdout = sinksUseStdout || sink.IsStdout(*protobufFilename)
+	}
+	if *templateFilename != "" {
+		templateSink, err := sink.NewTemplateSink(*templateFilename, *templateOutput)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, templateSink)
+		sinksUseStdout = sinksUseStdout || sink.IsStdout(*templateOutput)
+	}
+	if *parquetDir != "" {
+		parquetSink, err := sink.NewParquetSink(*parquetDir)
+		if err != nil {
+			return err
+		}
+		parquetSink.RowGroupSize = *parquetRowGroup
+		sinks = append(sinks, parquetSink)
+	}
+	if *kafkaBrokers != "" {
+		config := kafka.Config{
+			Brokers:     strings.Split(*kafkaBrokers, ","),
+			Topic:       *kafkaTopic,
+			ClientID:    "certspotter",
+			Partitioner: *kafkaPartitioner,
+			Retries:     *kafkaRetries,
+		}
+		switch *kafkaAcks {
+		case "all":
+			config.Acks = kafka.AcksAll
+		case "leader":
+			config.Acks = kafka.AcksLeader
+		case "none":
+			config.Acks = kafka.AcksNone
+		default:
+			return fmt.Errorf("Invalid -kafka_acks `%s': must be all, leader, or none", *kafkaAcks)
+		}
+		kafkaSink, err := sink.NewKafkaSink(config, *kafkaKey)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, kafkaSink)
+	}
+	if *natsURL != "" {
+		natsSink, err := sink.NewNATSSink(*natsURL, *natsSubject)
+		if err != nil {
+			return err
+		}
+		natsSink.JetStream = *natsJetStream
+		sinks = append(sinks, natsSink)
+	}
+	if *elasticsearchURL != "" {
+		elasticsearchSink, err := sink.NewElasticsearchSink(*elasticsearchURL, *elasticsearchIndex)
+		if err != nil {
+			return err
+		}
+		elasticsearchSink.BatchSize = *elasticsearchBatch
+		sinks = append(sinks, elasticsearchSink)
+	}
+	if *splunkURL != "" {
+		if *splunkTokenFile == "" {
+			return fmt.Errorf("-splunk_hec requires -splunk_token_file")
+		}
+		token, err := ioutil.ReadFile(*splunkTokenFile)
+		if err != nil {
+			return fmt.Errorf("Error reading Splunk HEC token: %s", err)
+		}
+		splunkSink, err := sink.NewSplunkSink(*splunkURL, string(bytes.TrimSpace(token)))
+		if err != nil {
+			return err
+		}
+		splunkSink.SourceType = *splunkSourceType
+		splunkSink.Index = *splunkIndex
+		splunkSink.BatchSize = *splunkBatch
+		sinks = append(sinks, splunkSink)
+	}
+	return nil
+}
+
+func writeToSinks(info *certspotter.EntryInfo) {
+	for _, s := range sinks {
+		if err := s.Write(info); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+}
+
+func closeSinks() int {
+	exitCode := 0
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			slog.Error(err.Error())
+			exitCode = 1
+		}
+	}
+	sinks = nil
+	return exitCode
+}
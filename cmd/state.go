@@ -12,21 +12,25 @@ package cmd
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/compression"
 	"software.sslmate.com/src/certspotter/ct"
 )
 
 type State struct {
-	path string
+	path     string
+	lockFile *os.File
 }
 
 func legacySTHFilename(logInfo *certspotter.LogInfo) string {
@@ -57,16 +61,65 @@ func readVersionFile(statePath string) (int, error) {
 	}
 }
 
-func writeVersionFile(statePath string) error {
-	version := 1
+func writeVersionFile(statePath string, version int) error {
 	versionString := fmt.Sprintf("%d\n", version)
 	versionFilePath := filepath.Join(statePath, "version")
-	if err := ioutil.WriteFile(versionFilePath, []byte(versionString), 0666); err != nil {
+	if err := writeFile(versionFilePath, []byte(versionString), 0666); err != nil {
 		return fmt.Errorf("%s: %s\n", versionFilePath, err)
 	}
 	return nil
 }
 
+// stateVersion is the version of the state directory layout written by this
+// version of Cert Spotter.  To change the layout, increment stateVersion and
+// append a function to stateMigrations that upgrades the previous layout.
+const stateVersion = 1
+
+// stateMigrations[i] upgrades a state directory from version i to version i+1
+var stateMigrations = []func(statePath string) error{
+	migrateStateFrom0,
+}
+
+// The original version of certspotter had no version file and kept the
+// latest STH of each log in the sths directory
+func migrateStateFrom0(statePath string) error {
+	if err := os.Rename(filepath.Join(statePath, "sths"), filepath.Join(statePath, "legacy_sths")); err != nil {
+		return fmt.Errorf("Error migrating STHs directory: %s", err)
+	}
+	for _, subdir := range []string{"evidence", "legacy_sths"} {
+		os.Remove(filepath.Join(statePath, subdir))
+	}
+	if err := ioutil.WriteFile(filepath.Join(statePath, "once"), []byte{}, 0666); err != nil {
+		return fmt.Errorf("Error creating once file: %s", err)
+	}
+	return nil
+}
+
+func migrateState(statePath string, version int) error {
+	// Make sure another instance isn't using the state while we change it
+	lockFilename := filepath.Join(statePath, "lock")
+	lockFile, err := acquireLockFile(lockFilename)
+	if err != nil {
+		return fmt.Errorf("Error locking state directory: %s", err)
+	} else if lockFile == nil {
+		return fmt.Errorf("Another instance of Cert Spotter is using the state directory")
+	}
+	defer releaseLockFile(lockFilename, lockFile)
+
+	slog.Info("Migrating state directory", "path", statePath, "from_version", version, "to_version", stateVersion)
+	for ; version < stateVersion; version++ {
+		if err := stateMigrations[version](statePath); err != nil {
+			return err
+		}
+		// Record progress as we go, so an interrupted migration resumes
+		// where it left off
+		if err := writeVersionFile(statePath, version+1); err != nil {
+			return fmt.Errorf("Error writing version file: %s", err)
+		}
+	}
+	return nil
+}
+
 func makeStateDir(statePath string) error {
 	if err := os.Mkdir(statePath, 0777); err != nil && !os.IsExist(err) {
 		return fmt.Errorf("%s: %s", statePath, err)
@@ -86,27 +139,21 @@ func OpenState(statePath string) (*State, error) {
 		return nil, fmt.Errorf("Error reading version file: %s", err)
 	}
 
-	if version < 1 {
+	if version > stateVersion {
+		return nil, fmt.Errorf("%s was created by a newer version of Cert Spotter; please remove this directory or upgrade Cert Spotter", statePath)
+	}
+	if version < stateVersion {
 		if err := makeStateDir(statePath); err != nil {
 			return nil, fmt.Errorf("Error creating state directory: %s", err)
 		}
-		if version == 0 {
-			log.Printf("Migrating state directory (%s) to new layout...", statePath)
-			if err := os.Rename(filepath.Join(statePath, "sths"), filepath.Join(statePath, "legacy_sths")); err != nil {
-				return nil, fmt.Errorf("Error migrating STHs directory: %s", err)
+		if version == -1 {
+			// New state directory, so there's nothing to migrate
+			if err := writeVersionFile(statePath, stateVersion); err != nil {
+				return nil, fmt.Errorf("Error writing version file: %s", err)
 			}
-			for _, subdir := range []string{"evidence", "legacy_sths"} {
-				os.Remove(filepath.Join(statePath, subdir))
-			}
-			if err := ioutil.WriteFile(filepath.Join(statePath, "once"), []byte{}, 0666); err != nil {
-				return nil, fmt.Errorf("Error creating once file: %s", err)
-			}
-		}
-		if err := writeVersionFile(statePath); err != nil {
-			return nil, fmt.Errorf("Error writing version file: %s", err)
+		} else if err := migrateState(statePath, version); err != nil {
+			return nil, err
 		}
-	} else if version > 1 {
-		return nil, fmt.Errorf("%s was created by a newer version of Cert Spotter; please remove this directory or upgrade Cert Spotter", statePath)
 	}
 
 	return &State{path: statePath}, nil
@@ -161,10 +208,217 @@ func (state *State) SaveCert(isPrecert bool, certs [][]byte) (bool, string, erro
 	return false, path, nil
 }
 
-func (state *State) OpenLogState(logInfo *certspotter.LogInfo) (*LogState, error) {
+// SaveSerial records the issuance key and fingerprint in the file
+// serials/ab/SERIALKEY, unless it already exists
+func (state *State) SaveSerial(key []byte, issuanceKey []byte, fingerprint string) (string, error) {
+	keyHex := hex.EncodeToString(key)
+	prefixPath := filepath.Join(state.path, "serials", keyHex[0:2])
+	if err := os.MkdirAll(prefixPath, 0777); err != nil {
+		return "", fmt.Errorf("Failed to create serial directory %s: %s", prefixPath, err)
+	}
+	path := filepath.Join(prefixPath, keyHex)
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		fields := strings.SplitN(string(content), " ", 2)
+		if len(fields) != 2 || fields[0] == hex.EncodeToString(issuanceKey) {
+			return "", nil
+		}
+		return fields[1], nil
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("Failed to read %s: %s", path, err)
+	}
+	// Write to a temporary file first, so that a crash can't leave behind
+	// an empty or partial file, which would be ignored forever after
+	if err := writeFile(path, []byte(hex.EncodeToString(issuanceKey)+" "+fingerprint), 0666); err != nil {
+		return "", fmt.Errorf("Error writing to %s: %s", path, err)
+	}
+	return "", nil
+}
+
+// SaveIssuance records |fingerprint| in the file issuances/ab/ISSUANCEKEY,
+// unless it already exists
+func (state *State) SaveIssuance(key []byte, fingerprint string) (string, error) {
+	keyHex := hex.EncodeToString(key)
+	prefixPath := filepath.Join(state.path, "issuances", keyHex[0:2])
+	if err := os.MkdirAll(prefixPath, 0777); err != nil {
+		return "", fmt.Errorf("Failed to create issuance directory %s: %s", prefixPath, err)
+	}
+	path := filepath.Join(prefixPath, keyHex)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		first, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read %s: %s", path, err)
+		}
+		if string(first) == fingerprint {
+			return "", nil
+		}
+		return string(first), nil
+	} else if err != nil {
+		return "", fmt.Errorf("Failed to open %s for writing: %s", path, err)
+	}
+	if _, err := file.WriteString(fingerprint); err != nil {
+		file.Close()
+		return "", fmt.Errorf("Error writing to %s: %s", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("Error writing to %s: %s", path, err)
+	}
+	return "", nil
+}
+
+func (state *State) notifiedPath(channel string, fingerprint string) string {
+	return filepath.Join(state.path, "notified", channel, fingerprint[0:2], fingerprint)
+}
+
+// HasNotified returns true if the file notified/CHANNEL/ab/FINGERPRINT exists
+func (state *State) HasNotified(channel string, fingerprint string) (bool, error) {
+	if _, err := os.Stat(state.notifiedPath(channel, fingerprint)); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (state *State) SaveNotified(channel string, fingerprint string) error {
+	path := state.notifiedPath(channel, fingerprint)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return fmt.Errorf("Failed to create notified directory %s: %s", filepath.Dir(path), err)
+	}
+	if err := writeFile(path, nil, 0666); err != nil {
+		return fmt.Errorf("Failed to write %s: %s", path, err)
+	}
+	return nil
+}
+
+// Save evidence of log misbehavior in the evidence directory, returning the filename
+func (state *State) StoreEvidence(evidence *certspotter.Evidence) (string, error) {
+	evidenceDir := filepath.Join(state.path, "evidence")
+	if err := os.Mkdir(evidenceDir, 0777); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("Failed to create evidence directory %s: %s", evidenceDir, err)
+	}
+	filename := filepath.Join(evidenceDir, fmt.Sprintf("%s-%s-%s.json", evidence.Time.Format("20060102T150405Z"), base64.RawURLEncoding.EncodeToString(evidence.LogID[:]), evidence.Type))
+	if *compressFlag == compression.None {
+		if err := writeJSONFile(filename, evidence, 0666); err != nil {
+			return "", fmt.Errorf("Failed to write evidence to %s: %s", filename, err)
+		}
+		return filename, nil
+	}
+
+	// Evidence can include many raw log entries, so compress it if requested
+	evidenceJSON, err := json.Marshal(evidence)
+	if err != nil {
+		return "", err
+	}
+	compressed, err := compression.Compress(*compressFlag, append(evidenceJSON, '\n'))
+	if err != nil {
+		return "", fmt.Errorf("Failed to compress evidence: %s", err)
+	}
+	filename += compression.Extension(*compressFlag)
+	if err := writeFile(filename, compressed, 0666); err != nil {
+		return "", fmt.Errorf("Failed to write evidence to %s: %s", filename, err)
+	}
+	return filename, nil
+}
+
+func (state *State) OpenLogState(logInfo *certspotter.LogInfo) (certspotter.LogStore, error) {
 	return OpenLogState(filepath.Join(state.path, "logs", base64.RawURLEncoding.EncodeToString(logInfo.ID())))
 }
 
+func (state *State) notificationFilename(notification *certspotter.PendingNotification) string {
+	return filepath.Join(state.path, "notifications", notification.ID+".json")
+}
+
+func (state *State) QueueNotification(notification *certspotter.PendingNotification) error {
+	notificationsDir := filepath.Join(state.path, "notifications")
+	if err := os.Mkdir(notificationsDir, 0777); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("Failed to create notifications directory %s: %s", notificationsDir, err)
+	}
+	return writeJSONFile(state.notificationFilename(notification), notification, 0666)
+}
+
+func (state *State) GetNotifications() ([]*certspotter.PendingNotification, error) {
+	dir, err := os.Open(filepath.Join(state.path, "notifications"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*certspotter.PendingNotification{}, nil
+		} else {
+			return nil, err
+		}
+	}
+	defer dir.Close()
+	filenames, err := dir.Readdirnames(0)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make([]*certspotter.PendingNotification, 0, len(filenames))
+	for _, filename := range filenames {
+		if !strings.HasPrefix(filename, ".") && strings.HasSuffix(filename, ".json") {
+			notification := new(certspotter.PendingNotification)
+			if err := readJSONFile(filepath.Join(dir.Name(), filename), notification); err == nil {
+				notifications = append(notifications, notification)
+			}
+		}
+	}
+	return notifications, nil
+}
+
+func (state *State) RemoveNotification(notification *certspotter.PendingNotification) error {
+	err := os.Remove(state.notificationFilename(notification))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (state *State) watchedCertFilename(fingerprint string) string {
+	return filepath.Join(state.path, "watched_certs", fingerprint+".json")
+}
+
+// WatchCert saves |watched| in the file watched_certs/FINGERPRINT.json
+func (state *State) WatchCert(watched *certspotter.WatchedCert) error {
+	watchedDir := filepath.Join(state.path, "watched_certs")
+	if err := os.Mkdir(watchedDir, 0777); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("Failed to create watched certificates directory %s: %s", watchedDir, err)
+	}
+	return writeJSONFile(state.watchedCertFilename(watched.Fingerprint), watched, 0666)
+}
+
+func (state *State) GetWatchedCerts() ([]*certspotter.WatchedCert, error) {
+	dir, err := os.Open(filepath.Join(state.path, "watched_certs"))
+	if os.IsNotExist(err) {
+		return []*certspotter.WatchedCert{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	filenames, err := dir.Readdirnames(0)
+	if err != nil {
+		return nil, err
+	}
+
+	watchedCerts := make([]*certspotter.WatchedCert, 0, len(filenames))
+	for _, filename := range filenames {
+		if !strings.HasPrefix(filename, ".") && strings.HasSuffix(filename, ".json") {
+			watched := new(certspotter.WatchedCert)
+			if err := readJSONFile(filepath.Join(dir.Name(), filename), watched); err == nil {
+				watchedCerts = append(watchedCerts, watched)
+			}
+		}
+	}
+	return watchedCerts, nil
+}
+
+func (state *State) UnwatchCert(fingerprint string) error {
+	err := os.Remove(state.watchedCertFilename(fingerprint))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func (state *State) GetLegacySTH(logInfo *certspotter.LogInfo) (*ct.SignedTreeHead, error) {
 	sth, err := readSTHFile(filepath.Join(state.path, "legacy_sths", legacySTHFilename(logInfo)))
 	if err != nil {
@@ -185,36 +439,38 @@ func (state *State) LockFilename() string {
 	return filepath.Join(state.path, "lock")
 }
 func (state *State) Lock() (bool, error) {
-	file, err := os.OpenFile(state.LockFilename(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
-	if err != nil {
-		if os.IsExist(err) {
-			return false, nil
-		} else {
-			return false, err
-		}
+	file, err := acquireLockFile(state.LockFilename())
+	if err != nil || file == nil {
+		return false, err
 	}
-	if _, err := fmt.Fprintf(file, "%d\n", os.Getpid()); err != nil {
-		file.Close()
-		os.Remove(state.LockFilename())
+	if err := file.Truncate(0); err != nil {
+		releaseLockFile(state.LockFilename(), file)
 		return false, err
 	}
-	if err := file.Close(); err != nil {
-		os.Remove(state.LockFilename())
+	if _, err := file.WriteString(certspotter.LockOwnerName() + "\n"); err != nil {
+		releaseLockFile(state.LockFilename(), file)
 		return false, err
 	}
+	state.lockFile = file
 	return true, nil
 }
+
 func (state *State) Unlock() error {
-	return os.Remove(state.LockFilename())
-}
-func (state *State) LockingPid() int {
-	pidBytes, err := ioutil.ReadFile(state.LockFilename())
-	if err != nil {
-		return 0
+	if state.lockFile == nil {
+		return nil
 	}
-	pid, err := strconv.Atoi(string(bytes.TrimSpace(pidBytes)))
-	if err != nil {
-		return 0
+	err := releaseLockFile(state.LockFilename(), state.lockFile)
+	state.lockFile = nil
+	return err
+}
+
+// LockOwner returns the process ID and hostname of the instance holding the lock, if known
+func (state *State) LockOwner() (string, error) {
+	owner, err := ioutil.ReadFile(state.LockFilename())
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
 	}
-	return pid
+	return string(bytes.TrimSpace(owner)), nil
 }
@@ -0,0 +1,45 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveSerial(t *testing.T) {
+	state, err := OpenState(filepath.Join(t.TempDir(), "state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{0xab}, 32)
+	issuance := bytes.Repeat([]byte{1}, 32)
+
+	if other, err := state.SaveSerial(key, issuance, "first"); err != nil || other != "" {
+		t.Fatalf("First SaveSerial returned %q, %v", other, err)
+	}
+	// The same issuance (e.g. the precertificate of the same certificate)
+	if other, err := state.SaveSerial(key, issuance, "second"); err != nil || other != "" {
+		t.Errorf("SaveSerial of the same issuance returned %q, %v", other, err)
+	}
+	if other, err := state.SaveSerial(key, bytes.Repeat([]byte{2}, 32), "third"); err != nil || other != "first" {
+		t.Errorf("SaveSerial of a different issuance returned %q, %v, expected the first fingerprint", other, err)
+	}
+
+	files, err := os.ReadDir(filepath.Join(state.path, "serials", "ab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Ext(files[0].Name()) != "" {
+		t.Errorf("serials/ab contains %v, expected only the serial's file", files)
+	}
+}
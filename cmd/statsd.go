@@ -0,0 +1,84 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+var statsdAddr = flag.String("statsd", "", "Send metrics to the StatsD server at this HOST:PORT over UDP")
+var statsdFormat = flag.String("statsd_format", "statsd", "Format for -statsd: statsd, or dogstatsd to send labels as tags")
+var statsdInterval = flag.Int("statsd_interval", 10, "Seconds between sending metrics to -statsd")
+
+var statsd struct {
+	exporter *certspotter.StatsDExporter
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// startStatsD sends the metrics to -statsd every -statsd_interval seconds,
+// until stopStatsD is called
+func startStatsD() error {
+	if *statsdAddr == "" {
+		return nil
+	}
+	if *statsdFormat != "statsd" && *statsdFormat != "dogstatsd" {
+		return fmt.Errorf("-statsd_format must be statsd or dogstatsd")
+	}
+	if *statsdInterval <= 0 {
+		return fmt.Errorf("-statsd_interval must be positive")
+	}
+	exporter, err := certspotter.NewStatsDExporter(collector, *statsdAddr)
+	if err != nil {
+		return fmt.Errorf("Error connecting to StatsD server: %s", err)
+	}
+	exporter.DogStatsD = *statsdFormat == "dogstatsd"
+	statsd.exporter = exporter
+	statsd.stop = make(chan struct{})
+	statsd.stopped.Add(1)
+	go func() {
+		defer statsd.stopped.Done()
+		ticker := time.NewTicker(time.Duration(*statsdInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				flushStatsD()
+			case <-statsd.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func flushStatsD() {
+	if err := statsd.exporter.Flush(); err != nil {
+		slog.Warn("Error sending metrics to StatsD server", "error", err)
+	}
+}
+
+// stopStatsD sends the metrics one last time
+func stopStatsD() {
+	if statsd.exporter == nil {
+		return
+	}
+	close(statsd.stop)
+	statsd.stopped.Wait()
+	flushStatsD()
+	statsd.exporter.Close()
+	statsd.exporter = nil
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/sink"
+)
+
+// How often /api/stream sends a comment, so that proxies don't time out
+// idle connections
+const streamKeepaliveInterval = 30 * time.Second
+
+// In -daemon mode, matchBroadcaster passes reported matches, and
+// entryBroadcaster every entry scanned, to the clients streaming them;
+// otherwise, they're nil
+var (
+	matchBroadcaster *certspotter.Broadcaster
+	entryBroadcaster *certspotter.Broadcaster
+)
+
+func openStreams() {
+	matchBroadcaster = certspotter.NewBroadcaster()
+	entryBroadcaster = certspotter.NewBroadcaster()
+}
+
+// closeStreams ends the streams, so their clients know to reconnect
+func closeStreams() {
+	matchBroadcaster.Close()
+	entryBroadcaster.Close()
+}
+
+// streamEntriesCallback returns a ProcessCallback which passes each entry
+// to entryBroadcaster, before processing it with |callback|.  Entries are
+// only parsed for the broadcaster while someone is streaming them.
+func streamEntriesCallback(callback certspotter.ProcessCallback) certspotter.ProcessCallback {
+	if entryBroadcaster == nil {
+		return callback
+	}
+	return func(scanner *certspotter.Scanner, entry *ct.LogEntry) {
+		if entryBroadcaster.Subscribers() != 0 {
+			entryBroadcaster.Write(certspotter.NewEntryInfo(scanner.LogUri, entry))
+		}
+		callback(scanner, entry)
+	}
+}
+
+// hasMatchCategory returns true if |info| has a match in one of
+// |categories|, or if |categories| is empty
+func hasMatchCategory(info *certspotter.EntryInfo, categories []string) bool {
+	if len(categories) == 0 {
+		return true
+	}
+	for _, category := range info.MatchCategories() {
+		for _, wanted := range categories {
+			if category == wanted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeEvent writes a server-sent event whose data is the JSON line (as
+// written by -jsonl) for |info|
+func writeEvent(w http.ResponseWriter, event string, info *certspotter.EntryInfo, includeDER bool) error {
+	data, err := json.Marshal(sink.MakeLine(info, includeDER))
+	if err != nil {
+		return fmt.Errorf("Error encoding entry %d from %s as JSON: %s", info.Entry.Index, info.LogUri, err)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// serveStream streams matches as server-sent events, and with entries=all,
+// every entry scanned too
+func serveStream(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	includeDER := params.Get("der") == "true"
+	categories := params["category"]
+	var allEntries bool
+	switch params.Get("entries") {
+	case "", "matches":
+	case "all":
+		allEntries = true
+	default:
+		writeJSONResponse(w, http.StatusBadRequest, errorResponse{"entries must be matches or all"})
+		return
+	}
+
+	matches := matchBroadcaster.Subscribe()
+	defer matchBroadcaster.Unsubscribe(matches)
+	var entries *certspotter.Subscription
+	var entriesC <-chan *certspotter.EntryInfo // stays nil unless entries=all
+	if allEntries {
+		entries = entryBroadcaster.Subscribe()
+		defer entryBroadcaster.Unsubscribe(entries)
+		entriesC = entries.C
+	}
+	defer func() {
+		dropped := matches.Dropped()
+		if entries != nil {
+			dropped += entries.Dropped()
+		}
+		if dropped != 0 {
+			slog.Warn("Stream client fell behind, so entries were not streamed to it", "client", req.RemoteAddr, "dropped", dropped)
+		}
+	}()
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller.Flush()
+
+	keepalive := time.NewTicker(streamKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		var err error
+		select {
+		case info, ok := <-matches.C:
+			if !ok {
+				return
+			}
+			if hasMatchCategory(info, categories) {
+				err = writeEvent(w, "match", info, includeDER)
+			}
+		case info, ok := <-entriesC:
+			if !ok {
+				return
+			}
+			err = writeEvent(w, "entry", info, includeDER)
+		case <-keepalive.C:
+			_, err = fmt.Fprintf(w, ": keepalive\n\n")
+		case <-req.Context().Done():
+			return
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			slog.Debug("Stopped streaming to client", "client", req.RemoteAddr, "error", err)
+			return
+		}
+	}
+}
+
+func init() {
+	httpMux.HandleFunc("/api/stream", requireAPIToken(serveStream))
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The first file descriptor passed by systemd socket activation
+const sdListenFdsStart = 3
+
+// sdNotify sends |state| (e.g. "READY=1") to the service manager, if
+// certspotter was started by one which expects notifications (Type=notify
+// in systemd).  It returns false if there's no service manager to notify.
+func sdNotify(state string) (bool, error) {
+	socketName := os.Getenv("NOTIFY_SOCKET")
+	if socketName == "" {
+		return false, nil
+	}
+	// An initial @ denotes an abstract socket, which the net package
+	// handles itself
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketName, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("Error connecting to service manager: %s", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("Error notifying service manager: %s", err)
+	}
+	return true, nil
+}
+
+func sdNotifyOrLog(state string) {
+	if _, err := sdNotify(state); err != nil {
+		slog.Warn(err.Error())
+	}
+}
+
+// sdWatchdogInterval returns how often the service manager expects
+// watchdog pings, or 0 if the watchdog isn't enabled for this process
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// startWatchdog pings the service manager's watchdog at half the interval it
+// expects, until |stop| is closed
+func startWatchdog(stop <-chan struct{}) {
+	interval := sdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sdNotifyOrLog("WATCHDOG=1")
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+var activatedListeners map[string]net.Listener
+
+// loadActivatedListeners takes ownership of the sockets passed by systemd
+// socket activation, keyed by their FileDescriptorName (which defaults to
+// the name of the socket unit).  The LISTEN_* variables are removed from
+// the environment so they aren't inherited by scripts.
+func loadActivatedListeners() error {
+	if activatedListeners != nil {
+		return nil
+	}
+	activatedListeners = make(map[string]net.Listener)
+
+	pid := os.Getenv("LISTEN_PID")
+	numFds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != strconv.Itoa(os.Getpid()) || err != nil || numFds <= 0 {
+		return nil
+	}
+
+	for i := 0; i < numFds; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(sdListenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("Error using socket %q passed by systemd: %s", name, err)
+		}
+		if _, exists := activatedListeners[name]; exists {
+			listener.Close()
+			return fmt.Errorf("Systemd passed more than one socket named %q", name)
+		}
+		activatedListeners[name] = listener
+	}
+	return nil
+}
+
+// listen returns the socket named |name| passed by systemd socket
+// activation, if there is one, or else listens on the TCP address |addr|
+func listen(name string, addr string) (net.Listener, error) {
+	if err := loadActivatedListeners(); err != nil {
+		return nil, err
+	}
+	if listener, ok := activatedListeners[name]; ok {
+		delete(activatedListeners, name)
+		return listener, nil
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("No address to listen on for %s, and systemd didn't pass a socket named %q", name, name)
+	}
+	return net.Listen("tcp", addr)
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+var startTimeFlag = flag.String("start_time", "", "Instead of scanning new entries, scan entries logged at or after this time (RFC 3339 or YYYY-MM-DD)")
+var endTimeFlag = flag.String("end_time", "", "Instead of scanning new entries, scan entries logged before this time (RFC 3339 or YYYY-MM-DD)")
+
+var startTime, endTime time.Time
+
+func parseTimeFlag(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time `%s' (must be RFC 3339 or YYYY-MM-DD)", value)
+}
+
+func parseTimeRange() error {
+	var err error
+	if *startTimeFlag != "" {
+		if startTime, err = parseTimeFlag(*startTimeFlag); err != nil {
+			return err
+		}
+	}
+	if *endTimeFlag != "" {
+		if endTime, err = parseTimeFlag(*endTimeFlag); err != nil {
+			return err
+		}
+	}
+	if !startTime.IsZero() && !endTime.IsZero() && !startTime.Before(endTime) {
+		return fmt.Errorf("-start_time must be before -end_time")
+	}
+	return nil
+}
+
+// scanningTimeRange returns true if a time window was specified, in which case
+// only the entries in the window are scanned, and the log's position is
+// not updated
+func scanningTimeRange() bool {
+	return !startTime.IsZero() || !endTime.IsZero()
+}
+
+func (ctlog *logHandle) scanTimeRange(processCallback certspotter.ProcessCallback) error {
+	if err := ctlog.scanner.ScanTimeRange(startTime, endTime, ctlog.logInfo.MaximumMergeDelay(), ctlog.verifiedSTH, processCallback); err == certspotter.ErrScanStopped {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("Error scanning time range: %s", err)
+	}
+	return nil
+}
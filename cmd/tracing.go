@@ -0,0 +1,48 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+
+	"software.sslmate.com/src/certspotter/tracing"
+)
+
+var otlpEndpoint = flag.String("otlp_endpoint", "", "Send OpenTelemetry traces to the OTLP/HTTP collector at this URL (e.g. http://localhost:4318)")
+var traceSampleRate = flag.Float64("trace_sample_rate", 0.01, "Fraction of entries whose parsing and matching are traced, with -otlp_endpoint")
+
+// startTracing starts sending spans to the -otlp_endpoint, if specified.
+// The service name is taken from $OTEL_SERVICE_NAME, as with other
+// OpenTelemetry instrumentation.
+func startTracing() error {
+	if *otlpEndpoint == "" {
+		return nil
+	}
+	if parsed, err := url.Parse(*otlpEndpoint); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("-otlp_endpoint must be an http or https URL")
+	}
+	if *traceSampleRate < 0 || *traceSampleRate > 1 {
+		return fmt.Errorf("-trace_sample_rate must be between 0 and 1")
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "certspotter"
+	}
+	exporter := tracing.NewExporter(*otlpEndpoint, serviceName)
+	exporter.OnError = func(err error) {
+		slog.Warn("Error sending traces", "error", err)
+	}
+	tracing.Enable(exporter)
+	return nil
+}
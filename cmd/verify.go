@@ -0,0 +1,106 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// Verify is like Main, but instead of scanning the logs, it checks that
+// each log's latest STH is consistent with the verified STH in the state
+// directory, and returns the exit code
+func Verify(statePath string) int {
+	fsState, err := OpenState(statePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	return VerifyWithStore(fsState)
+}
+
+// VerifyWithStore is like Verify, but uses the given Store instead of a
+// state directory.  Evidence of inconsistent STHs is saved in the store.
+// The store isn't locked, so this may be run while another instance is
+// scanning.  The caller is responsible for closing the store.
+func VerifyWithStore(store certspotter.Store) int {
+	if err := configureLogging(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	if *verifyJournalFilename != "" {
+		return verifyJournal()
+	}
+	logs, err := loadLogList()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	if logConfigs, err = loadLogConfigs(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		return 1
+	}
+	state = store
+
+	exitCode := 0
+	for i := range logs {
+		logInfo := &logs[i]
+		if err := verifyLog(logInfo); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", os.Args[0], logInfo.FullURI(), err)
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// verifyLog checks that the log's latest STH is consistent with its
+// verified STH, and prints the result
+func verifyLog(logInfo *certspotter.LogInfo) error {
+	ctlog, err := makeLogHandle(logInfo)
+	if err != nil {
+		return err
+	}
+	if ctlog.verifiedSTH == nil {
+		fmt.Printf("%s: not yet monitored\n", logInfo.FullURI())
+		return nil
+	}
+	latestSTH, err := ctlog.scanner.GetSTH()
+	if err != nil {
+		return fmt.Errorf("Error retrieving STH from log: %w", err)
+	}
+	verified := ctlog.verifiedSTH
+	switch {
+	case latestSTH.TreeSize == verified.TreeSize:
+		if !bytes.Equal(latestSTH.SHA256RootHash[:], verified.SHA256RootHash[:]) {
+			return ctlog.inconsistentSTHs(latestSTH, nil)
+		}
+	case latestSTH.TreeSize > verified.TreeSize:
+		isValid, proof, err := ctlog.scanner.CheckConsistencyWithProof(verified, latestSTH)
+		if err != nil {
+			return fmt.Errorf("Error fetching consistency proof between %d and %d: %w", verified.TreeSize, latestSTH.TreeSize, err)
+		}
+		if !isValid {
+			return ctlog.inconsistentSTHs(latestSTH, proof)
+		}
+	default:
+		isValid, proof, err := ctlog.scanner.CheckConsistencyWithProof(latestSTH, verified)
+		if err != nil {
+			return fmt.Errorf("Error fetching consistency proof between %d and %d: %w", latestSTH.TreeSize, verified.TreeSize, err)
+		}
+		if !isValid {
+			return ctlog.inconsistentSTHs(latestSTH, proof)
+		}
+	}
+	fmt.Printf("%s: STH %d (%x) is consistent with verified STH %d (%x)\n", logInfo.FullURI(), latestSTH.TreeSize, latestSTH.SHA256RootHash, verified.TreeSize, verified.SHA256RootHash)
+	return nil
+}
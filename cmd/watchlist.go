@@ -0,0 +1,107 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// WatchlistFilename, if set, is the file containing the watchlist, which
+// the gRPC API may add items to and remove items from.  The changes take
+// effect when the configuration is reloaded (see Reload).
+var WatchlistFilename string
+
+// Serializes changes to WatchlistFilename
+var watchlistFileMutex sync.Mutex
+
+// readWatchlistLines returns the lines of WatchlistFilename, and the
+// pattern of each one, or "" if it's blank or a comment
+func readWatchlistLines() (lines []string, patterns []string, err error) {
+	data, err := ioutil.ReadFile(WatchlistFilename)
+	if err != nil {
+		return nil, nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		var pattern string
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			if pattern, _, err = certspotter.ParseWatchlistLine(trimmed); err != nil {
+				return nil, nil, err
+			}
+		}
+		lines = append(lines, line)
+		patterns = append(patterns, pattern)
+	}
+	return lines, patterns, scanner.Err()
+}
+
+func writeWatchlistLines(lines []string) error {
+	var data bytes.Buffer
+	for _, line := range lines {
+		data.WriteString(line)
+		data.WriteByte('\n')
+	}
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(WatchlistFilename); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return writeFile(WatchlistFilename, data.Bytes(), perm)
+}
+
+// addToWatchlistFile appends |pattern|, followed by |id| if it's not empty,
+// to WatchlistFilename, and returns false if it already contains the
+// pattern.  The pattern must be valid.
+func addToWatchlistFile(pattern string, id string) (bool, error) {
+	watchlistFileMutex.Lock()
+	defer watchlistFileMutex.Unlock()
+	lines, patterns, err := readWatchlistLines()
+	if err != nil {
+		return false, err
+	}
+	for _, existing := range patterns {
+		if existing == pattern {
+			return false, nil
+		}
+	}
+	line := pattern
+	if id != "" {
+		line += " " + id
+	}
+	return true, writeWatchlistLines(append(lines, line))
+}
+
+// removeFromWatchlistFile removes the lines with |pattern| from
+// WatchlistFilename, and returns false if there weren't any
+func removeFromWatchlistFile(pattern string) (bool, error) {
+	watchlistFileMutex.Lock()
+	defer watchlistFileMutex.Unlock()
+	lines, patterns, err := readWatchlistLines()
+	if err != nil {
+		return false, err
+	}
+	var kept []string
+	for i := range lines {
+		if patterns[i] != pattern {
+			kept = append(kept, lines[i])
+		}
+	}
+	if len(kept) == len(lines) {
+		return false, nil
+	}
+	return true, writeWatchlistLines(kept)
+}
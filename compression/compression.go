@@ -0,0 +1,151 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package compression compresses certificates, evidence, and other files
+// persisted by Cert Spotter.  Decompression is transparent: the format is
+// detected from the data itself, and uncompressed data is returned as-is.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported algorithms
+const (
+	None = ""
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// The zstd encoder and decoder are expensive to create but safe for
+// concurrent use, so share a single instance of each
+func initZstd() error {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+// Check returns an error if |algorithm| is not supported
+func Check(algorithm string) error {
+	switch algorithm {
+	case None, Gzip, Zstd:
+		return nil
+	default:
+		return fmt.Errorf("Unsupported compression algorithm `%s' (must be gzip or zstd)", algorithm)
+	}
+}
+
+// Extension returns the filename extension, including the dot, for files
+// compressed with |algorithm|
+func Extension(algorithm string) string {
+	switch algorithm {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// ContentType returns the MIME type of data compressed with |algorithm|,
+// or |uncompressedType| if |algorithm| is None
+func ContentType(algorithm string, uncompressedType string) string {
+	switch algorithm {
+	case Gzip:
+		return "application/gzip"
+	case Zstd:
+		return "application/zstd"
+	default:
+		return uncompressedType
+	}
+}
+
+func Compress(algorithm string, data []byte) ([]byte, error) {
+	switch algorithm {
+	case None:
+		return data, nil
+	case Gzip:
+		var buf bytes.Buffer
+		writer, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return nil, Check(algorithm)
+	}
+}
+
+// Decompress decompresses |data| if it is gzip or zstd compressed, and
+// otherwise returns it unchanged
+func Decompress(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	case bytes.HasPrefix(data, zstdMagic):
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return data, nil
+	}
+}
+
+// ReadFile reads the file named by |filename|, decompressing it if necessary
+func ReadFile(filename string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err = Decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return data, nil
+}
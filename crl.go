@@ -0,0 +1,236 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const MatchRevoked = "revoked"
+
+var oidExtensionCRLDistributionPoints = asn1.ObjectIdentifier{2, 5, 29, 31}
+
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	Reason            asn1.BitString        `asn1:"optional,tag:1"`
+	CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+}
+
+type distributionPointName struct {
+	FullName     []asn1.RawValue `asn1:"optional,tag:0"`
+	RelativeName asn1.RawValue   `asn1:"optional,tag:1"`
+}
+
+// ParseCRLDistributionPoints returns the HTTP URLs of the certificate's
+// CRLs, from its CRL Distribution Points extension
+func (tbs *TBSCertificate) ParseCRLDistributionPoints() ([]string, error) {
+	var urls []string
+	for _, ext := range tbs.GetExtension(oidExtensionCRLDistributionPoints) {
+		var points []distributionPoint
+		if rest, err := asn1.Unmarshal(ext.Value, &points); err != nil {
+			return nil, fmt.Errorf("failed to parse CRL Distribution Points: %s", err)
+		} else if len(rest) > 0 {
+			return nil, fmt.Errorf("trailing data after CRL Distribution Points: %v", rest)
+		}
+		for _, point := range points {
+			for _, name := range point.DistributionPoint.FullName {
+				if name.Class == asn1.ClassContextSpecific && name.Tag == sanURI {
+					if u, err := url.Parse(string(name.Bytes)); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+						urls = append(urls, string(name.Bytes))
+					}
+				}
+			}
+		}
+	}
+	return urls, nil
+}
+
+// How long CRLChecker caches CRLs, unless their nextUpdate is sooner, and how
+// long it caches errors
+const (
+	crlCacheTime      = 1 * time.Hour
+	crlErrorCacheTime = 5 * time.Minute
+)
+
+type crlCacheEntry struct {
+	thisUpdate time.Time
+	revoked    map[string]x509.RevocationListEntry // by serial number, in hex
+	err        error
+	expires    time.Time
+}
+
+// CRLChecker checks certificates against the CRLs in their CRL Distribution
+// Points extension.  CRLs are cached by URL, so certificates from the same
+// CA only cause one download.
+type CRLChecker struct {
+	Client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*crlCacheEntry
+}
+
+func NewCRLChecker() *CRLChecker {
+	return &CRLChecker{Client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Check returns the revocation status of the entry's certificate according
+// to its first CRL, or nil if it doesn't have one.  The CRL must be signed
+// by the certificate's issuer (see OCSPChecker.Check).
+func (checker *CRLChecker) Check(info *EntryInfo) *RevocationStatus {
+	if info.CertInfo == nil || info.CertInfo.SerialNumberParseError != nil {
+		return nil
+	}
+	urls, err := info.CertInfo.TBS.ParseCRLDistributionPoints()
+	if err != nil {
+		return &RevocationStatus{Status: OCSPError, Error: err.Error()}
+	} else if len(urls) == 0 {
+		return nil
+	}
+	crlURL := urls[0]
+	issuer, err := ocspIssuer(info.FullChain)
+	if err != nil {
+		return &RevocationStatus{Status: OCSPError, Responder: crlURL, Error: err.Error()}
+	}
+	crl := checker.get(crlURL, issuer)
+	if crl.err != nil {
+		return &RevocationStatus{Status: OCSPError, Responder: crlURL, Error: crl.err.Error()}
+	}
+	thisUpdate := crl.thisUpdate
+	status := &RevocationStatus{Status: OCSPGood, Responder: crlURL, ThisUpdate: &thisUpdate}
+	if entry, revoked := crl.revoked[info.CertInfo.SerialNumber.Text(16)]; revoked {
+		revokedAt := entry.RevocationTime
+		status.Status = OCSPRevoked
+		status.RevokedAt = &revokedAt
+		if entry.ReasonCode != 0 {
+			status.Reason = crlReasons[entry.ReasonCode]
+		}
+	}
+	return status
+}
+
+// get returns the CRL at |crlURL|, downloading it if it isn't cached
+func (checker *CRLChecker) get(crlURL string, issuer *x509.Certificate) *crlCacheEntry {
+	key := crlURL + " " + string(issuer.RawSubjectPublicKeyInfo)
+	checker.mu.Lock()
+	entry, ok := checker.cache[key]
+	checker.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry
+	}
+
+	entry = &crlCacheEntry{expires: time.Now().Add(crlCacheTime)}
+	crl, err := checker.fetch(crlURL, issuer)
+	if err != nil {
+		entry.err = err
+		entry.expires = time.Now().Add(crlErrorCacheTime)
+	} else {
+		entry.thisUpdate = crl.ThisUpdate
+		entry.revoked = make(map[string]x509.RevocationListEntry, len(crl.RevokedCertificateEntries))
+		for _, revoked := range crl.RevokedCertificateEntries {
+			entry.revoked[revoked.SerialNumber.Text(16)] = revoked
+		}
+		if !crl.NextUpdate.IsZero() && crl.NextUpdate.Before(entry.expires) {
+			entry.expires = crl.NextUpdate
+		}
+	}
+
+	checker.mu.Lock()
+	if checker.cache == nil {
+		checker.cache = make(map[string]*crlCacheEntry)
+	}
+	checker.cache[key] = entry
+	checker.mu.Unlock()
+	return entry
+}
+
+func (checker *CRLChecker) fetch(crlURL string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	request, err := http.NewRequest("GET", crlURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", "certspotter")
+	response, err := checker.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %s", crlURL, response.Status)
+	}
+	crl, err := x509.ParseRevocationList(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Malformed CRL: %s", crlURL, err)
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("%s: CRL has an invalid signature: %s", crlURL, err)
+	}
+	return crl, nil
+}
+
+// A WatchedCert is a reported certificate whose CRL is re-checked
+// periodically, so that a follow-up can be reported if it's revoked.  The
+// entry is kept so the follow-up can include everything in the original report.
+type WatchedCert struct {
+	Fingerprint string               `json:"fingerprint"`
+	NotAfter    time.Time            `json:"not_after"`
+	Entry       *PendingNotification `json:"entry"`
+}
+
+// NewWatchedCert returns a WatchedCert for |info|, or nil if its
+// certificate doesn't have a CRL or has expired
+func NewWatchedCert(info *EntryInfo) *WatchedCert {
+	if info.CertInfo == nil || info.CertInfo.ValidityParseError != nil {
+		return nil
+	}
+	if urls, err := info.CertInfo.TBS.ParseCRLDistributionPoints(); err != nil || len(urls) == 0 {
+		return nil
+	}
+	notAfter := info.CertInfo.Validity.NotAfter
+	if time.Now().After(notAfter) {
+		return nil
+	}
+	return &WatchedCert{
+		Fingerprint: info.Fingerprint(),
+		NotAfter:    notAfter,
+		Entry:       NewPendingNotification("crl", info),
+	}
+}
+
+// Stores which can keep track of reported certificates, so that their CRLs
+// can be re-checked, implement RevocationStore
+type RevocationStore interface {
+	Store
+
+	WatchCert(*WatchedCert) error
+	GetWatchedCerts() ([]*WatchedCert, error)
+	UnwatchCert(fingerprint string) error
+}
+
+// IsRevocationFollowUp returns true if the entry is being reported again
+// because its certificate was revoked after it was first reported
+func (info *EntryInfo) IsRevocationFollowUp() bool {
+	for _, match := range info.Matches {
+		if match.Category == MatchRevoked {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// makeCRLTestEntry returns an entry for a certificate with serial number
+// |serial| issued by |ca|, with |crlURL| as its CRL distribution point
+func makeCRLTestEntry(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, crlURL string, serial int64) *EntryInfo {
+	return makeTestChainEntry(t, ca, caKey, func(template *x509.Certificate) {
+		template.SerialNumber = big.NewInt(serial)
+		template.CRLDistributionPoints = []string{crlURL}
+	})
+}
+
+func TestParseCRLDistributionPoints(t *testing.T) {
+	cert := makeTestCertInfo(t, nil, nil, func(template *x509.Certificate) {
+		template.CRLDistributionPoints = []string{"http://crl.example.com/1.crl", "ldap://ldap.example.com/cn=CA", "https://crl.example.net/2.crl"}
+	})
+	urls, err := cert.TBS.ParseCRLDistributionPoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 2 || urls[0] != "http://crl.example.com/1.crl" || urls[1] != "https://crl.example.net/2.crl" {
+		t.Errorf("Wrong URLs: %v", urls)
+	}
+}
+
+func TestCRLChecker(t *testing.T) {
+	ca, caKey := makeTestCA(t, nil, nil, nil)
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(24 * time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(666), RevocationTime: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), ReasonCode: 1},
+		},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write(crl)
+	}))
+	defer server.Close()
+	checker := NewCRLChecker()
+
+	status := checker.Check(makeCRLTestEntry(t, ca, caKey, server.URL, 42))
+	if status == nil || status.Status != OCSPGood || status.Responder != server.URL {
+		t.Fatalf("Wrong status: %+v", status)
+	}
+	status = checker.Check(makeCRLTestEntry(t, ca, caKey, server.URL, 666))
+	if status.Status != OCSPRevoked || status.Reason != "keyCompromise" || !status.RevokedAt.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Wrong status: %+v", status)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("CRL wasn't cached: downloaded %d times", n)
+	}
+
+	// Issued by a different CA, which didn't sign the CRL
+	otherCA, otherKey := makeTestCA(t, nil, nil, nil)
+	status = checker.Check(makeCRLTestEntry(t, otherCA, otherKey, server.URL, 42))
+	if status.Status != OCSPError || !strings.Contains(status.Error, "invalid signature") {
+		t.Errorf("Wrong status: %+v", status)
+	}
+
+	if status := checker.Check(&EntryInfo{CertInfo: makeTestCertInfo(t, nil, nil, nil)}); status != nil {
+		t.Errorf("Certificate without a CRL has status %+v", status)
+	}
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ErrorClass classifies the errors returned by log clients, so that callers
+// can react differently to, e.g., rate limiting and misbehavior by the log
+type ErrorClass string
+
+const (
+	ErrorNetwork     ErrorClass = "network"      // connecting to the log or reading its response failed
+	ErrorTimeout     ErrorClass = "timeout"      // the request timed out
+	ErrorRateLimited ErrorClass = "rate_limited" // HTTP 429
+	ErrorServer      ErrorClass = "server"       // HTTP 5xx
+	ErrorHTTP        ErrorClass = "http"         // any other unsuccessful HTTP status
+	ErrorDecode      ErrorClass = "decode"       // the response wasn't valid JSON
+	ErrorSignature   ErrorClass = "signature"    // an STH or checkpoint signature is invalid
+	ErrorParse       ErrorClass = "parse"        // a malformed entry, STH, tile, or proof
+	ErrorOther       ErrorClass = "other"
+)
+
+// Error is an error with an ErrorClass
+type Error struct {
+	Class      ErrorClass
+	StatusCode int // of the HTTP response, for ErrorRateLimited, ErrorServer, and ErrorHTTP
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errorf formats an error of class |class|, like fmt.Errorf
+func Errorf(class ErrorClass, format string, args ...interface{}) error {
+	return &Error{Class: class, Err: fmt.Errorf(format, args...)}
+}
+
+// StatusError formats an error for an unsuccessful HTTP response with status
+// |statusCode|, classified by the status
+func StatusError(statusCode int, format string, args ...interface{}) error {
+	class := ErrorHTTP
+	if statusCode == http.StatusTooManyRequests {
+		class = ErrorRateLimited
+	} else if statusCode/100 == 5 {
+		class = ErrorServer
+	}
+	return &Error{Class: class, StatusCode: statusCode, Err: fmt.Errorf(format, args...)}
+}
+
+// Classify returns the class of |err|: that of the outermost *Error it
+// wraps, if any, or else ErrorTimeout or ErrorNetwork if it's a net.Error
+// (as returned by http.Client), or else ErrorOther
+func Classify(err error) ErrorClass {
+	var classified *Error
+	var netErr net.Error
+	if errors.As(err, &classified) {
+		return classified.Class
+	} else if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrorTimeout
+		}
+		return ErrorNetwork
+	}
+	return ErrorOther
+}
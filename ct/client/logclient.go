@@ -14,6 +14,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"time"
 
 	"github.com/mreiferson/go-httpclient"
@@ -27,12 +28,14 @@ const (
 	GetSTHConsistencyPath = "/ct/v1/get-sth-consistency"
 	GetProofByHashPath    = "/ct/v1/get-proof-by-hash"
 	AddChainPath          = "/ct/v1/add-chain"
+	STHPollinationPath    = "/.well-known/ct-gossip/v1/sth-pollination"
 )
 
 // LogClient represents a client for a given CT Log instance
 type LogClient struct {
-	uri        string       // the base URI of the log. e.g. http://ct.googleapis/pilot
-	httpClient *http.Client // used to interact with the log via HTTP
+	bytesDownloaded uint64       // accessed atomically, so first for alignment
+	uri             string       // the base URI of the log. e.g. http://ct.googleapis/pilot
+	httpClient      *http.Client // used to interact with the log via HTTP
 }
 
 //////////////////////////////////////////////////////////////////////////////////
@@ -82,16 +85,29 @@ type addChainResponse struct {
 	Signature  []byte `json:"signature"`
 }
 
+// DefaultRequestTimeout is the maximum time a request to the log may take
+const DefaultRequestTimeout = 60 * time.Second
+
 // New constructs a new LogClient instance.
 // |uri| is the base URI of the CT log instance to interact with, e.g.
 // http://ct.googleapis.com/pilot
 func New(uri string) *LogClient {
+	return NewWithTimeout(uri, DefaultRequestTimeout)
+}
+
+// NewWithTimeout is like New, but requests time out after |requestTimeout|
+// instead of DefaultRequestTimeout
+func NewWithTimeout(uri string, requestTimeout time.Duration) *LogClient {
 	var c LogClient
 	c.uri = uri
+	responseHeaderTimeout := 30 * time.Second
+	if responseHeaderTimeout > requestTimeout {
+		responseHeaderTimeout = requestTimeout
+	}
 	transport := &httpclient.Transport{
 		ConnectTimeout:        10 * time.Second,
-		RequestTimeout:        60 * time.Second,
-		ResponseHeaderTimeout: 30 * time.Second,
+		RequestTimeout:        requestTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
 		MaxIdleConnsPerHost:   10,
 		DisableKeepAlives:     false,
 	}
@@ -99,6 +115,12 @@ func New(uri string) *LogClient {
 	return &c
 }
 
+// BytesDownloaded returns the number of bytes of response bodies received
+// from the log
+func (c *LogClient) BytesDownloaded() uint64 {
+	return atomic.LoadUint64(&c.bytesDownloaded)
+}
+
 // Makes a HTTP call to |uri|, and attempts to parse the response as a JSON
 // representation of the structure in |res|.
 // Returns a non-nil |error| if there was a problem.
@@ -131,18 +153,19 @@ func (c *LogClient) doAndParse(req *http.Request, respBody interface{}) error {
 	if resp != nil {
 		respBodyBytes, err = ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
+		atomic.AddUint64(&c.bytesDownloaded, uint64(len(respBodyBytes)))
 		if err != nil {
-			return fmt.Errorf("%s %s: Reading response failed: %s", req.Method, req.URL, err)
+			return fmt.Errorf("%s %s: Reading response failed: %w", req.Method, req.URL, err)
 		}
 	}
 	if err != nil {
 		return err
 	}
 	if resp.StatusCode/100 != 2 {
-		return fmt.Errorf("%s %s: %s (%s)", req.Method, req.URL, resp.Status, string(respBodyBytes))
+		return StatusError(resp.StatusCode, "%s %s: %s (%s)", req.Method, req.URL, resp.Status, string(respBodyBytes))
 	}
 	if err = json.Unmarshal(respBodyBytes, &respBody); err != nil {
-		return fmt.Errorf("%s %s: Parsing response JSON failed: %s", req.Method, req.URL, err)
+		return Errorf(ErrorDecode, "%s %s: Parsing response JSON failed: %s", req.Method, req.URL, err)
 	}
 	return nil
 }
@@ -160,13 +183,13 @@ func (c *LogClient) GetSTH() (sth *ct.SignedTreeHead, err error) {
 	}
 
 	if len(resp.SHA256RootHash) != sha256.Size {
-		return nil, fmt.Errorf("STH returned by server has invalid sha256_root_hash (expected length %d got %d)", sha256.Size, len(resp.SHA256RootHash))
+		return nil, Errorf(ErrorParse, "STH returned by server has invalid sha256_root_hash (expected length %d got %d)", sha256.Size, len(resp.SHA256RootHash))
 	}
 	copy(sth.SHA256RootHash[:], resp.SHA256RootHash)
 
 	ds, err := ct.UnmarshalDigitallySigned(bytes.NewReader(resp.TreeHeadSignature))
 	if err != nil {
-		return nil, err
+		return nil, Errorf(ErrorParse, "STH returned by server has invalid tree_head_signature: %s", err)
 	}
 	// TODO(alcutter): Verify signature
 	sth.TreeHeadSignature = *ds
@@ -191,8 +214,16 @@ func (c *LogClient) GetEntries(start, end int64) ([]ct.LogEntry, error) {
 	entries := make([]ct.LogEntry, len(resp.Entries))
 	for index, entry := range resp.Entries {
 		leaf, err := ct.ReadMerkleTreeLeaf(bytes.NewBuffer(entry.LeafInput))
+		if _, isUnknownType := err.(*ct.UnknownEntryTypeError); isUnknownType {
+			// Return the entry without parsing it further, so the caller
+			// can still hash the leaf and decide what to do with it
+			entries[index].LeafBytes = entry.LeafInput
+			entries[index].Leaf = *leaf
+			entries[index].Index = start + int64(index)
+			continue
+		}
 		if err != nil {
-			return nil, fmt.Errorf("Reading Merkle Tree Leaf at index %d failed: %s", start+int64(index), err)
+			return nil, Errorf(ErrorParse, "Reading Merkle Tree Leaf at index %d failed: %s", start+int64(index), err)
 		}
 		entries[index].LeafBytes = entry.LeafInput
 		entries[index].Leaf = *leaf
@@ -204,12 +235,9 @@ func (c *LogClient) GetEntries(start, end int64) ([]ct.LogEntry, error) {
 
 		case ct.PrecertLogEntryType:
 			chain, err = ct.UnmarshalPrecertChainArray(entry.ExtraData)
-
-		default:
-			return nil, fmt.Errorf("Unknown entry type at index %d: %v", start+int64(index), leaf.TimestampedEntry.EntryType)
 		}
 		if err != nil {
-			return nil, fmt.Errorf("Parsing entry of type %d at index %d failed: %s", leaf.TimestampedEntry.EntryType, start+int64(index), err)
+			return nil, Errorf(ErrorParse, "Parsing entry of type %d at index %d failed: %s", leaf.TimestampedEntry.EntryType, start+int64(index), err)
 		}
 		entries[index].Chain = chain
 		entries[index].Index = start + int64(index)
@@ -269,7 +297,7 @@ func (c *LogClient) AddChain(chain [][]byte) (*ct.SignedCertificateTimestamp, er
 	}
 
 	if len(resp.ID) != sha256.Size {
-		return nil, fmt.Errorf("SCT returned by server has invalid id (expected length %d got %d)", sha256.Size, len(resp.ID))
+		return nil, Errorf(ErrorParse, "SCT returned by server has invalid id (expected length %d got %d)", sha256.Size, len(resp.ID))
 	}
 	copy(sct.LogID[:], resp.ID)
 
@@ -280,3 +308,14 @@ func (c *LogClient) AddChain(chain [][]byte) (*ct.SignedCertificateTimestamp, er
 	sct.Signature = *ds
 	return sct, nil
 }
+
+// PostSTHPollination sends the STHs in |p| to an STH pollination server and
+// returns the STHs that the server sends back.  The LogClient must have been
+// constructed with the base URI of the pollination server, not a log.
+func (c *LogClient) PostSTHPollination(p *ct.STHPollination) (*ct.STHPollination, error) {
+	var resp ct.STHPollination
+	if err := c.postAndParse(c.uri+STHPollinationPath, p, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+func TestPostSTHPollination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// The path defined by the STH gossip draft
+		if req.Method != "POST" || req.URL.Path != "/.well-known/ct-gossip/v1/sth-pollination" {
+			t.Errorf("Unexpected request %s %s", req.Method, req.URL.Path)
+			http.NotFound(w, req)
+			return
+		}
+		var request ct.STHPollination
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			t.Errorf("Error decoding request: %s", err)
+		}
+		if len(request.STHs) != 1 || request.STHs[0].TreeSize != 100 {
+			t.Errorf("Unexpected request %+v", request)
+		}
+		json.NewEncoder(w).Encode(ct.STHPollination{STHs: []ct.SignedTreeHead{{TreeSize: 200}}})
+	}))
+	defer server.Close()
+
+	response, err := New(server.URL).PostSTHPollination(&ct.STHPollination{STHs: []ct.SignedTreeHead{{TreeSize: 100}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.STHs) != 1 || response.STHs[0].TreeSize != 200 {
+		t.Errorf("Unexpected response %+v", response)
+	}
+}
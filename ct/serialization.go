@@ -117,6 +117,16 @@ func readASN1CertList(r io.Reader, totalLenBytes int, elementLenBytes int) ([]AS
 	return ret, nil
 }
 
+// UnknownEntryTypeError is returned when parsing a TimestampedEntry whose
+// type is neither X509LogEntryType nor PrecertLogEntryType
+type UnknownEntryTypeError struct {
+	EntryType LogEntryType
+}
+
+func (e *UnknownEntryTypeError) Error() string {
+	return fmt.Sprintf("unknown EntryType: %d", e.EntryType)
+}
+
 // ReadTimestampedEntryInto parses the byte-stream representation of a
 // TimestampedEntry from |r| and populates the struct |t| with the data.  See
 // RFC section 3.4 for details on the format.
@@ -142,7 +152,7 @@ func ReadTimestampedEntryInto(r io.Reader, t *TimestampedEntry) error {
 			return err
 		}
 	default:
-		return fmt.Errorf("unknown EntryType: %d", t.EntryType)
+		return &UnknownEntryTypeError{EntryType: t.EntryType}
 	}
 	t.Extensions, err = readVarBytes(r, ExtensionsLengthBytes)
 	return nil
@@ -153,7 +163,8 @@ func ReadTimestampedEntryInto(r io.Reader, t *TimestampedEntry) error {
 // parsed data.
 // See RFC section 3.4 for details on the format.
 // Returns a pointer to a new MerkleTreeLeaf or non-nil error if there was a
-// problem
+// problem.  If the entry type is unknown, the error is an *UnknownEntryTypeError
+// and the returned MerkleTreeLeaf has every field but the entry itself.
 func ReadMerkleTreeLeaf(r io.Reader) (*MerkleTreeLeaf, error) {
 	var m MerkleTreeLeaf
 	if err := binary.Read(r, binary.BigEndian, &m.Version); err != nil {
@@ -169,6 +180,9 @@ func ReadMerkleTreeLeaf(r io.Reader) (*MerkleTreeLeaf, error) {
 		return nil, fmt.Errorf("unknown LeafType %d", m.LeafType)
 	}
 	if err := ReadTimestampedEntryInto(r, &m.TimestampedEntry); err != nil {
+		if _, isUnknownType := err.(*UnknownEntryTypeError); isUnknownType {
+			return &m, err
+		}
 		return nil, err
 	}
 	return &m, nil
@@ -450,13 +464,111 @@ func serializeV1STHSignatureInput(sth SignedTreeHead) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// serializeV2STHSignatureInput serializes the TreeHeadDataV2 structure from
+// RFC 9162 section 4.10
+func serializeV2STHSignatureInput(sth SignedTreeHead) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, sth.Timestamp); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, sth.TreeSize); err != nil {
+		return nil, err
+	}
+	if err := writeVarBytes(&buf, sth.SHA256RootHash[:], 1); err != nil {
+		return nil, err
+	}
+	if err := writeVarBytes(&buf, sth.Extensions, ExtensionsLengthBytes); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // SerializeSTHSignatureInput serializes the passed in sth into the correct
 // format for signing.
 func SerializeSTHSignatureInput(sth SignedTreeHead) ([]byte, error) {
 	switch sth.Version {
 	case V1:
 		return serializeV1STHSignatureInput(sth)
+	case V2:
+		return serializeV2STHSignatureInput(sth)
 	default:
 		return nil, fmt.Errorf("unsupported STH version %d", sth.Version)
 	}
 }
+
+// SerializeMerkleTreeLeaf serializes the passed in leaf into the format
+// specified by RFC6962 section 3.4.  The Merkle leaf hash of an entry is
+// calculated over this serialization.
+func SerializeMerkleTreeLeaf(leaf MerkleTreeLeaf) ([]byte, error) {
+	if leaf.Version != V1 {
+		return nil, fmt.Errorf("unsupported leaf version %s", leaf.Version)
+	}
+	if leaf.LeafType != TimestampedEntryLeafType {
+		return nil, fmt.Errorf("unsupported leaf type %s", leaf.LeafType)
+	}
+	entry := leaf.TimestampedEntry
+	if err := checkExtensionsFormat(entry.Extensions); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, leaf.Version); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, leaf.LeafType); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, entry.Timestamp); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, entry.EntryType); err != nil {
+		return nil, err
+	}
+	switch entry.EntryType {
+	case X509LogEntryType:
+		if err := checkCertificateFormat(entry.X509Entry); err != nil {
+			return nil, err
+		}
+		if err := writeVarBytes(&buf, entry.X509Entry, CertificateLengthBytes); err != nil {
+			return nil, err
+		}
+	case PrecertLogEntryType:
+		if err := checkCertificateFormat(entry.PrecertEntry.TBSCertificate); err != nil {
+			return nil, err
+		}
+		if _, err := buf.Write(entry.PrecertEntry.IssuerKeyHash[:]); err != nil {
+			return nil, err
+		}
+		if err := writeVarBytes(&buf, entry.PrecertEntry.TBSCertificate, PreCertificateLengthBytes); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown EntryType: %d", entry.EntryType)
+	}
+	if err := writeVarBytes(&buf, entry.Extensions, ExtensionsLengthBytes); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DeserializeSCTList parses a SignedCertificateTimestampList, as found in the
+// SCT extension of a certificate (see RFC section 3.3).
+func DeserializeSCTList(b []byte) ([]SignedCertificateTimestamp, error) {
+	listBytes, err := readVarBytes(bytes.NewReader(b), 2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SCT list: %v", err)
+	}
+	reader := bytes.NewReader(listBytes)
+	var scts []SignedCertificateTimestamp
+	for reader.Len() > 0 {
+		sctBytes, err := readVarBytes(reader, 2)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SCT from list: %v", err)
+		}
+		sct, err := DeserializeSCT(bytes.NewReader(sctBytes))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SCT from list: %v", err)
+		}
+		scts = append(scts, *sct)
+	}
+	return scts, nil
+}
@@ -3,6 +3,7 @@ package ct
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
@@ -34,6 +35,7 @@ func NewSignatureVerifier(pk crypto.PublicKey) (*SignatureVerifier, error) {
 	switch pkType := pk.(type) {
 	case *rsa.PublicKey:
 	case *ecdsa.PublicKey:
+	case ed25519.PublicKey:
 	default:
 		return nil, fmt.Errorf("Unsupported public key type %v", pkType)
 	}
@@ -106,5 +108,25 @@ func (s SignatureVerifier) VerifySTHSignature(sth SignedTreeHead) error {
 	if err != nil {
 		return err
 	}
+	if sth.Version == V2 {
+		return s.verifySignatureV2(sthData, sth.TreeHeadSignature.Signature)
+	}
 	return s.verifySignature(sthData, sth.TreeHeadSignature)
 }
+
+// verifySignatureV2 verifies an RFC 9162 signature, which doesn't say which
+// algorithm it uses, since a log has only one: ecdsa_secp256r1_sha256 or
+// ed25519 (see RFC 9162 section 2.1.4).
+func (s SignatureVerifier) verifySignatureV2(data []byte, signature []byte) error {
+	switch key := s.pubKey.(type) {
+	case *ecdsa.PublicKey:
+		return s.verifySignature(data, DigitallySigned{HashAlgorithm: SHA256, SignatureAlgorithm: ECDSA, Signature: signature})
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, signature) {
+			return errors.New("failed to verify ed25519 signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("cannot verify RFC 9162 signature with %T key", s.pubKey)
+	}
+}
@@ -27,7 +27,7 @@ func (e LogEntryType) String() string {
 	case PrecertLogEntryType:
 		return "PrecertLogEntryType"
 	}
-	panic(fmt.Sprintf("No string defined for LogEntryType constant value %d", e))
+	return fmt.Sprintf("LogEntryType(%d)", uint16(e))
 }
 
 // LogEntryType constants, see section 3.1 of RFC6962.
@@ -62,14 +62,19 @@ func (v Version) String() string {
 	switch v {
 	case V1:
 		return "V1"
+	case V2:
+		return "V2"
 	default:
 		return fmt.Sprintf("UnknownVersion(%d)", v)
 	}
 }
 
-// CT Version constants, see section 3.2 of the RFC.
+// CT Version constants, see section 3.2 of the RFC.  V2 isn't part of the
+// enum; it marks STHs from RFC 9162 logs, whose signatures are made over a
+// TreeHeadDataV2 structure.
 const (
 	V1 Version = 0
+	V2 Version = 1
 )
 
 // SignatureType differentiates STH signatures from SCT signatures, see RFC
@@ -236,6 +241,7 @@ type LogEntry struct {
 	Leaf      MerkleTreeLeaf
 	Chain     []ASN1Cert
 	LeafBytes []byte
+	LeafHash  MerkleTreeNode // Merkle leaf hash of LeafBytes (see section 2.1)
 }
 
 // SHA256Hash represents the output from the SHA256 hash function.
@@ -276,12 +282,19 @@ func (s *SHA256Hash) UnmarshalJSON(b []byte) error {
 // SignedTreeHead represents the structure returned by the get-sth CT method
 // after base64 decoding. See sections 3.5 and 4.3 in the RFC)
 type SignedTreeHead struct {
-	Version           Version         `json:"sth_version"`         // The version of the protocol to which the STH conforms
-	TreeSize          uint64          `json:"tree_size"`           // The number of entries in the new tree
-	Timestamp         uint64          `json:"timestamp"`           // The time at which the STH was created
-	SHA256RootHash    SHA256Hash      `json:"sha256_root_hash"`    // The root hash of the log's Merkle tree
-	TreeHeadSignature DigitallySigned `json:"tree_head_signature"` // The Log's signature for this STH (see RFC section 3.5)
-	LogID             SHA256Hash      `json:"log_id"`              // The SHA256 hash of the log's public key
+	Version           Version         `json:"sth_version"`              // The version of the protocol to which the STH conforms
+	TreeSize          uint64          `json:"tree_size"`                // The number of entries in the new tree
+	Timestamp         uint64          `json:"timestamp"`                // The time at which the STH was created
+	SHA256RootHash    SHA256Hash      `json:"sha256_root_hash"`         // The root hash of the log's Merkle tree
+	TreeHeadSignature DigitallySigned `json:"tree_head_signature"`      // The Log's signature for this STH (see RFC section 3.5)
+	LogID             SHA256Hash      `json:"log_id"`                   // The SHA256 hash of the log's public key
+	Extensions        CTExtensions    `json:"sth_extensions,omitempty"` // V2 only
+}
+
+// STHPollination represents the message exchanged with an STH pollination
+// server, as described in draft-ietf-trans-gossip section 8.2.
+type STHPollination struct {
+	STHs []SignedTreeHead `json:"sths"`
 }
 
 // SignedCertificateTimestamp represents the structure returned by the
@@ -0,0 +1,186 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package ctv2
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/ct/client"
+)
+
+// URI paths for RFC 9162 log endpoints
+const (
+	GetSTHPath            = "/ct/v2/get-sth"
+	GetEntriesPath        = "/ct/v2/get-entries"
+	GetSTHConsistencyPath = "/ct/v2/get-sth-consistency"
+	GetProofByHashPath    = "/ct/v2/get-proof-by-hash"
+)
+
+// DefaultRequestTimeout is the maximum time a request to the log may take
+const DefaultRequestTimeout = 60 * time.Second
+
+// Client represents a client for an RFC 9162 log
+type Client struct {
+	bytesDownloaded uint64 // accessed atomically, so first for alignment
+	uri             string // the base URI of the log, e.g. https://example.com/2025
+	httpClient      *http.Client
+}
+
+type getSTHResponse struct {
+	STH []byte `json:"sth"`
+}
+
+type submittedEntry struct {
+	Submission []byte   `json:"submission"`
+	Chain      [][]byte `json:"chain"`
+}
+
+type getEntriesResponse struct {
+	Entries []struct {
+		LogEntry       []byte         `json:"log_entry"`
+		SubmittedEntry submittedEntry `json:"submitted_entry"`
+	} `json:"entries"`
+}
+
+type getConsistencyProofResponse struct {
+	Consistency []byte `json:"consistency"`
+}
+
+type getInclusionProofResponse struct {
+	Inclusion []byte `json:"inclusion"`
+}
+
+// New constructs a Client for the log at |uri|.  Requests time out after
+// |requestTimeout|, or DefaultRequestTimeout if it's 0.
+func New(uri string, requestTimeout time.Duration) *Client {
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeout
+	}
+	return &Client{uri: uri, httpClient: &http.Client{Timeout: requestTimeout}}
+}
+
+// BytesDownloaded returns the number of bytes of response bodies received
+// from the log
+func (c *Client) BytesDownloaded() uint64 {
+	return atomic.LoadUint64(&c.bytesDownloaded)
+}
+
+func (c *Client) fetchAndParse(uri string, respBody interface{}) error {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return fmt.Errorf("GET %s: Sending request failed: %s", uri, err)
+	}
+	req.Header.Set("User-Agent", "certspotter")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBodyBytes, err := ioutil.ReadAll(resp.Body)
+	atomic.AddUint64(&c.bytesDownloaded, uint64(len(respBodyBytes)))
+	if err != nil {
+		return fmt.Errorf("GET %s: Reading response failed: %w", uri, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return client.StatusError(resp.StatusCode, "GET %s: %s (%s)", uri, resp.Status, string(respBodyBytes))
+	}
+	if err := json.Unmarshal(respBodyBytes, respBody); err != nil {
+		return client.Errorf(client.ErrorDecode, "GET %s: Parsing response JSON failed: %s", uri, err)
+	}
+	return nil
+}
+
+// GetSTH retrieves the current STH from the log, as a ct.SignedTreeHead
+// with Version ct.V2
+func (c *Client) GetSTH() (*ct.SignedTreeHead, error) {
+	var resp getSTHResponse
+	if err := c.fetchAndParse(c.uri+GetSTHPath, &resp); err != nil {
+		return nil, err
+	}
+	sth, err := ParseSignedTreeHead(resp.STH)
+	if err != nil {
+		return nil, client.Errorf(client.ErrorParse, "Parsing STH failed: %s", err)
+	}
+	return sth.STH(), nil
+}
+
+// GetEntries retrieves the entries in [|start|, |end|] from the log.  See
+// LogEntry for how they're converted.
+func (c *Client) GetEntries(start, end int64) ([]ct.LogEntry, error) {
+	if end < 0 {
+		return nil, errors.New("GetEntries: end should be >= 0")
+	}
+	if end < start {
+		return nil, errors.New("GetEntries: start should be <= end")
+	}
+	var resp getEntriesResponse
+	if err := c.fetchAndParse(fmt.Sprintf("%s%s?start=%d&end=%d", c.uri, GetEntriesPath, start, end), &resp); err != nil {
+		return nil, err
+	}
+	entries := make([]ct.LogEntry, len(resp.Entries))
+	for index, entry := range resp.Entries {
+		logEntry, err := LogEntry(entry.LogEntry, entry.SubmittedEntry.Submission, entry.SubmittedEntry.Chain)
+		if err != nil {
+			return nil, client.Errorf(client.ErrorParse, "Parsing entry at index %d failed: %s", start+int64(index), err)
+		}
+		logEntry.Index = start + int64(index)
+		entries[index] = *logEntry
+	}
+	return entries, nil
+}
+
+// GetConsistencyProof retrieves a consistency proof between the trees of
+// size |first| and |second| from the log
+func (c *Client) GetConsistencyProof(first, second int64) (ct.ConsistencyProof, error) {
+	if second < 0 {
+		return nil, errors.New("GetConsistencyProof: second should be >= 0")
+	}
+	if second < first {
+		return nil, errors.New("GetConsistencyProof: first should be <= second")
+	}
+	var resp getConsistencyProofResponse
+	if err := c.fetchAndParse(fmt.Sprintf("%s%s?first=%d&second=%d", c.uri, GetSTHConsistencyPath, first, second), &resp); err != nil {
+		return nil, err
+	}
+	proof, err := ParseConsistencyProof(resp.Consistency)
+	if err != nil {
+		return nil, client.Errorf(client.ErrorParse, "Parsing consistency proof failed: %s", err)
+	}
+	if proof.TreeSize1 != uint64(first) || proof.TreeSize2 != uint64(second) {
+		return nil, fmt.Errorf("Log returned consistency proof between %d and %d instead of %d and %d", proof.TreeSize1, proof.TreeSize2, first, second)
+	}
+	return proof.Path, nil
+}
+
+// GetAuditProof retrieves the inclusion proof of the entry with leaf hash
+// |hash| in the tree of size |treeSize|, and the index of the entry
+func (c *Client) GetAuditProof(hash ct.MerkleTreeNode, treeSize uint64) (ct.AuditPath, uint64, error) {
+	var resp getInclusionProofResponse
+	if err := c.fetchAndParse(fmt.Sprintf("%s%s?hash=%s&tree_size=%d", c.uri, GetProofByHashPath, url.QueryEscape(base64.StdEncoding.EncodeToString(hash)), treeSize), &resp); err != nil {
+		return nil, 0, err
+	}
+	proof, err := ParseInclusionProof(resp.Inclusion)
+	if err != nil {
+		return nil, 0, client.Errorf(client.ErrorParse, "Parsing inclusion proof failed: %s", err)
+	}
+	if proof.TreeSize != treeSize {
+		return nil, 0, fmt.Errorf("Log returned inclusion proof for tree size %d instead of %d", proof.TreeSize, treeSize)
+	}
+	return proof.Path, proof.LeafIndex, nil
+}
@@ -0,0 +1,248 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package ctv2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+	ctclient "software.sslmate.com/src/certspotter/ct/client"
+)
+
+var testLogID = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x01} // an OID
+
+func varBytes(numLenBytes int, data []byte) []byte {
+	var buf []byte
+	for i := numLenBytes - 1; i >= 0; i-- {
+		buf = append(buf, byte(len(data)>>(8*uint(i))))
+	}
+	return append(buf, data...)
+}
+
+func transItem(itemType VersionedTransType, data []byte) []byte {
+	return append([]byte{byte(itemType >> 8), byte(itemType)}, data...)
+}
+
+func makeSTH(t *testing.T, sign func([]byte) []byte, treeSize uint64, rootHash []byte) []byte {
+	var treeHead bytes.Buffer
+	binary.Write(&treeHead, binary.BigEndian, uint64(1500000000000))
+	binary.Write(&treeHead, binary.BigEndian, treeSize)
+	treeHead.Write(varBytes(1, rootHash))
+	treeHead.Write(varBytes(2, []byte{0, 1, 0, 0})) // an sth_extension
+	var data []byte
+	data = append(data, varBytes(1, testLogID)...)
+	data = append(data, treeHead.Bytes()...)
+	data = append(data, varBytes(2, sign(treeHead.Bytes()))...)
+	return transItem(SignedTreeHeadV2, data)
+}
+
+func TestSTHSignature(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Public, ed25519Private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signECDSA := func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, ecdsaKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+		return signature
+	}
+	signEd25519 := func(data []byte) []byte {
+		return ed25519.Sign(ed25519Private, data)
+	}
+
+	rootHash := bytes.Repeat([]byte{0x42}, 32)
+	for _, test := range []struct {
+		name string
+		sign func([]byte) []byte
+		key  interface{}
+	}{
+		{"ECDSA", signECDSA, &ecdsaKey.PublicKey},
+		{"Ed25519", signEd25519, ed25519Public},
+	} {
+		parsed, err := ParseSignedTreeHead(makeSTH(t, test.sign, 1234, rootHash))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		sth := parsed.STH()
+		if sth.Version != ct.V2 || sth.TreeSize != 1234 || sth.Timestamp != 1500000000000 || !bytes.Equal(sth.SHA256RootHash[:], rootHash) || !bytes.Equal(parsed.LogID, testLogID) {
+			t.Errorf("%s: Wrong STH: %+v", test.name, sth)
+		}
+		verifier, err := ct.NewSignatureVerifier(test.key)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if err := verifier.VerifySTHSignature(*sth); err != nil {
+			t.Errorf("%s: Signature is invalid: %s", test.name, err)
+		}
+		sth.TreeSize++
+		if err := verifier.VerifySTHSignature(*sth); err == nil {
+			t.Errorf("%s: Signature is valid for the wrong tree size", test.name)
+		}
+		sth.TreeSize--
+		sth.Extensions = nil
+		if err := verifier.VerifySTHSignature(*sth); err == nil {
+			t.Errorf("%s: Signature is valid without the STH extensions", test.name)
+		}
+	}
+}
+
+func makeCertificate(t *testing.T, serial int64) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "www.example.com"},
+		NotBefore:    time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func makeEntry(itemType VersionedTransType, tbs []byte) []byte {
+	var data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, uint64(1500000000000))
+	data.Write(varBytes(1, bytes.Repeat([]byte{0x01}, 32)))
+	data.Write(varBytes(3, tbs))
+	data.Write(varBytes(2, nil))
+	return transItem(itemType, data.Bytes())
+}
+
+func tbsOf(t *testing.T, cert []byte) []byte {
+	parsed, err := x509.ParseCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.RawTBSCertificate
+}
+
+func TestLogEntry(t *testing.T) {
+	cert := makeCertificate(t, 1)
+	issuer := []byte("issuer")
+	leafInput := makeEntry(X509EntryV2, tbsOf(t, cert))
+
+	entry, err := LogEntry(leafInput, cert, [][]byte{issuer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Leaf.TimestampedEntry.EntryType != ct.X509LogEntryType || !bytes.Equal(entry.Leaf.TimestampedEntry.X509Entry, cert) || entry.Leaf.TimestampedEntry.Timestamp != 1500000000000 {
+		t.Errorf("Wrong entry: %+v", entry.Leaf)
+	}
+	if len(entry.Chain) != 1 || !bytes.Equal(entry.Chain[0], issuer) || !bytes.Equal(entry.LeafBytes, leafInput) {
+		t.Errorf("Wrong chain or leaf bytes")
+	}
+
+	if _, err := LogEntry(leafInput, makeCertificate(t, 2), nil); err == nil {
+		t.Errorf("Submitted certificate which doesn't match the entry was accepted")
+	}
+
+	precert := []byte("CMS precertificate")
+	entry, err = LogEntry(makeEntry(PrecertEntryV2, []byte("tbs")), precert, [][]byte{issuer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Leaf.TimestampedEntry.EntryType != ct.PrecertLogEntryType || string(entry.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate) != "tbs" || entry.Leaf.TimestampedEntry.PrecertEntry.IssuerKeyHash[0] != 0x01 {
+		t.Errorf("Wrong entry: %+v", entry.Leaf)
+	}
+	if len(entry.Chain) != 2 || !bytes.Equal(entry.Chain[0], precert) || !bytes.Equal(entry.Chain[1], issuer) {
+		t.Errorf("Wrong chain")
+	}
+
+	if _, err := LogEntry(makeSTH(t, func([]byte) []byte { return []byte{1} }, 1, make([]byte, 32)), nil, nil); err == nil {
+		t.Errorf("STH was accepted as an entry")
+	}
+}
+
+func TestClient(t *testing.T) {
+	cert := makeCertificate(t, 1)
+	leafInput := makeEntry(X509EntryV2, tbsOf(t, cert))
+	var proofData []byte
+	proofData = append(proofData, varBytes(1, testLogID)...)
+	proofData = append(proofData, 0, 0, 0, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0, 0, 0, 20)
+	proofData = append(proofData, varBytes(2, append(varBytes(1, bytes.Repeat([]byte{0xaa}, 32)), varBytes(1, bytes.Repeat([]byte{0xbb}, 32))...))...)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case GetEntriesPath:
+			if req.URL.Query().Get("start") != "5" || req.URL.Query().Get("end") != "5" {
+				http.Error(w, "wrong range", 400)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"entries": []interface{}{map[string]interface{}{
+					"log_entry":       leafInput,
+					"submitted_entry": map[string]interface{}{"submission": cert, "chain": [][]byte{}},
+				}},
+			})
+		case GetSTHPath:
+			http.Error(w, "overloaded", 503)
+		case GetSTHConsistencyPath:
+			json.NewEncoder(w).Encode(map[string]interface{}{"consistency": transItem(ConsistencyProofV2, proofData)})
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+	client := New(server.URL, 0)
+
+	entries, err := client.GetEntries(5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Index != 5 || !bytes.Equal(entries[0].Leaf.TimestampedEntry.X509Entry, cert) {
+		t.Errorf("Wrong entries: %+v", entries)
+	}
+
+	proof, err := client.GetConsistencyProof(10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof) != 2 || proof[0][0] != 0xaa || proof[1][0] != 0xbb {
+		t.Errorf("Wrong proof: %x", proof)
+	}
+	if _, err := client.GetConsistencyProof(10, 21); err == nil {
+		t.Errorf("Consistency proof for the wrong tree size was accepted")
+	}
+
+	if _, err := client.GetSTH(); ctclient.Classify(err) != ctclient.ErrorServer {
+		t.Errorf("Wrong class of error for HTTP 503: %v", err)
+	}
+	if _, _, err := client.GetAuditProof(nil, 1); ctclient.Classify(err) != ctclient.ErrorHTTP {
+		t.Errorf("Wrong class of error for HTTP 404: %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package ctv2 is a client for logs implementing Certificate Transparency
+// version 2.0 (RFC 9162), whose API returns TransItem structures instead of
+// RFC 6962's leaves and signatures.  Entries, STHs, and proofs are converted
+// to the types in package ct, so that they can be scanned and audited like
+// those of RFC 6962 logs.
+package ctv2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// VersionedTransType is the type of a TransItem (RFC 9162 section 4.4)
+type VersionedTransType uint16
+
+const (
+	X509EntryV2        VersionedTransType = 1
+	PrecertEntryV2     VersionedTransType = 2
+	X509SCTV2          VersionedTransType = 3
+	PrecertSCTV2       VersionedTransType = 4
+	SignedTreeHeadV2   VersionedTransType = 5
+	ConsistencyProofV2 VersionedTransType = 6
+	InclusionProofV2   VersionedTransType = 7
+)
+
+func (t VersionedTransType) String() string {
+	switch t {
+	case X509EntryV2:
+		return "x509_entry_v2"
+	case PrecertEntryV2:
+		return "precert_entry_v2"
+	case X509SCTV2:
+		return "x509_sct_v2"
+	case PrecertSCTV2:
+		return "precert_sct_v2"
+	case SignedTreeHeadV2:
+		return "signed_tree_head_v2"
+	case ConsistencyProofV2:
+		return "consistency_proof_v2"
+	case InclusionProofV2:
+		return "inclusion_proof_v2"
+	default:
+		return fmt.Sprintf("VersionedTransType(%d)", uint16(t))
+	}
+}
+
+// TimestampedCertificateEntry is the data of an x509_entry_v2 or
+// precert_entry_v2 TransItem.  Both contain only the TBSCertificate.
+type TimestampedCertificateEntry struct {
+	Timestamp      uint64
+	IssuerKeyHash  []byte
+	TBSCertificate []byte
+	SCTExtensions  []byte
+}
+
+// TreeHead is the TreeHeadDataV2 structure
+type TreeHead struct {
+	Timestamp     uint64
+	TreeSize      uint64
+	RootHash      []byte
+	STHExtensions []byte
+}
+
+// SignedTreeHead is the data of a signed_tree_head_v2 TransItem
+type SignedTreeHead struct {
+	LogID     []byte // DER-encoded OID, without the tag and length
+	TreeHead  TreeHead
+	Signature []byte
+}
+
+// ConsistencyProof is the data of a consistency_proof_v2 TransItem
+type ConsistencyProof struct {
+	LogID     []byte
+	TreeSize1 uint64
+	TreeSize2 uint64
+	Path      []ct.MerkleTreeNode
+}
+
+// InclusionProof is the data of an inclusion_proof_v2 TransItem
+type InclusionProof struct {
+	LogID     []byte
+	TreeSize  uint64
+	LeafIndex uint64
+	Path      []ct.MerkleTreeNode
+}
+
+// ReadTransItemType reads the type of the TransItem in |data|, and returns
+// the rest of it
+func ReadTransItemType(data []byte) (VersionedTransType, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, errors.New("TransItem is too short")
+	}
+	return VersionedTransType(binary.BigEndian.Uint16(data)), data[2:], nil
+}
+
+// readTransItem reads a TransItem of type |want|, and returns its data
+func readTransItem(data []byte, want VersionedTransType) (*bytes.Reader, error) {
+	itemType, itemData, err := ReadTransItemType(data)
+	if err != nil {
+		return nil, err
+	}
+	if itemType != want {
+		return nil, fmt.Errorf("expected %s TransItem, got %s", want, itemType)
+	}
+	return bytes.NewReader(itemData), nil
+}
+
+func readUint64(r io.Reader) (uint64, error) {
+	var value uint64
+	err := binary.Read(r, binary.BigEndian, &value)
+	return value, err
+}
+
+func readVarBytes(r io.Reader, numLenBytes int) ([]byte, error) {
+	var length uint64
+	for i := 0; i < numLenBytes; i++ {
+		var b [1]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		length = length<<8 | uint64(b[0])
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("short read: expected %d bytes", length)
+	}
+	return data, nil
+}
+
+func checkEnd(r *bytes.Reader) error {
+	if r.Len() != 0 {
+		return fmt.Errorf("%d bytes of trailing data", r.Len())
+	}
+	return nil
+}
+
+func readNodeHash(r io.Reader) (ct.MerkleTreeNode, error) {
+	hash, err := readVarBytes(r, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) != sha256.Size {
+		return nil, fmt.Errorf("hash has wrong length %d", len(hash))
+	}
+	return hash, nil
+}
+
+func readPath(r io.Reader) ([]ct.MerkleTreeNode, error) {
+	pathBytes, err := readVarBytes(r, 2)
+	if err != nil {
+		return nil, err
+	}
+	pathReader := bytes.NewReader(pathBytes)
+	path := []ct.MerkleTreeNode{}
+	for pathReader.Len() > 0 {
+		hash, err := readNodeHash(pathReader)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, hash)
+	}
+	return path, nil
+}
+
+// ParseEntry parses an x509_entry_v2 or precert_entry_v2 TransItem
+func ParseEntry(data []byte) (VersionedTransType, *TimestampedCertificateEntry, error) {
+	itemType, itemData, err := ReadTransItemType(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if itemType != X509EntryV2 && itemType != PrecertEntryV2 {
+		return 0, nil, fmt.Errorf("expected entry TransItem, got %s", itemType)
+	}
+	r := bytes.NewReader(itemData)
+	entry := new(TimestampedCertificateEntry)
+	if entry.Timestamp, err = readUint64(r); err != nil {
+		return 0, nil, err
+	}
+	if entry.IssuerKeyHash, err = readVarBytes(r, 1); err != nil {
+		return 0, nil, err
+	}
+	if entry.TBSCertificate, err = readVarBytes(r, 3); err != nil {
+		return 0, nil, err
+	}
+	if entry.SCTExtensions, err = readVarBytes(r, 2); err != nil {
+		return 0, nil, err
+	}
+	return itemType, entry, checkEnd(r)
+}
+
+// ParseSignedTreeHead parses a signed_tree_head_v2 TransItem
+func ParseSignedTreeHead(data []byte) (*SignedTreeHead, error) {
+	r, err := readTransItem(data, SignedTreeHeadV2)
+	if err != nil {
+		return nil, err
+	}
+	sth := new(SignedTreeHead)
+	if sth.LogID, err = readVarBytes(r, 1); err != nil {
+		return nil, err
+	}
+	if sth.TreeHead.Timestamp, err = readUint64(r); err != nil {
+		return nil, err
+	}
+	if sth.TreeHead.TreeSize, err = readUint64(r); err != nil {
+		return nil, err
+	}
+	if sth.TreeHead.RootHash, err = readNodeHash(r); err != nil {
+		return nil, err
+	}
+	if sth.TreeHead.STHExtensions, err = readVarBytes(r, 2); err != nil {
+		return nil, err
+	}
+	if sth.Signature, err = readVarBytes(r, 2); err != nil {
+		return nil, err
+	}
+	return sth, checkEnd(r)
+}
+
+// ParseConsistencyProof parses a consistency_proof_v2 TransItem
+func ParseConsistencyProof(data []byte) (*ConsistencyProof, error) {
+	r, err := readTransItem(data, ConsistencyProofV2)
+	if err != nil {
+		return nil, err
+	}
+	proof := new(ConsistencyProof)
+	if proof.LogID, err = readVarBytes(r, 1); err != nil {
+		return nil, err
+	}
+	if proof.TreeSize1, err = readUint64(r); err != nil {
+		return nil, err
+	}
+	if proof.TreeSize2, err = readUint64(r); err != nil {
+		return nil, err
+	}
+	if proof.Path, err = readPath(r); err != nil {
+		return nil, err
+	}
+	return proof, checkEnd(r)
+}
+
+// ParseInclusionProof parses an inclusion_proof_v2 TransItem
+func ParseInclusionProof(data []byte) (*InclusionProof, error) {
+	r, err := readTransItem(data, InclusionProofV2)
+	if err != nil {
+		return nil, err
+	}
+	proof := new(InclusionProof)
+	if proof.LogID, err = readVarBytes(r, 1); err != nil {
+		return nil, err
+	}
+	if proof.TreeSize, err = readUint64(r); err != nil {
+		return nil, err
+	}
+	if proof.LeafIndex, err = readUint64(r); err != nil {
+		return nil, err
+	}
+	if proof.Path, err = readPath(r); err != nil {
+		return nil, err
+	}
+	return proof, checkEnd(r)
+}
+
+// STH converts the STH to a ct.SignedTreeHead with Version ct.V2, whose
+// signature can be verified with ct.SignatureVerifier.  Only the Signature
+// field of its TreeHeadSignature is set.
+func (sth *SignedTreeHead) STH() *ct.SignedTreeHead {
+	converted := &ct.SignedTreeHead{
+		Version:           ct.V2,
+		TreeSize:          sth.TreeHead.TreeSize,
+		Timestamp:         sth.TreeHead.Timestamp,
+		TreeHeadSignature: ct.DigitallySigned{Signature: sth.Signature},
+		Extensions:        sth.TreeHead.STHExtensions,
+	}
+	copy(converted.SHA256RootHash[:], sth.TreeHead.RootHash)
+	return converted
+}
+
+// LogEntry converts an entry to a ct.LogEntry.  |leafInput| is the entry's
+// TransItem, whose Merkle leaf hash is the tree's leaf.  RFC 9162 entries
+// contain only the TBSCertificate, so the certificate is taken from
+// |submission|, the certificate or CMS precertificate which was submitted
+// to the log, and |chain| is the rest of the submitted chain.  For
+// precertificates, the first element of the entry's Chain is the CMS
+// precertificate.
+func LogEntry(leafInput []byte, submission []byte, chain [][]byte) (*ct.LogEntry, error) {
+	itemType, entry, err := ParseEntry(leafInput)
+	if err != nil {
+		return nil, err
+	}
+	logEntry := &ct.LogEntry{LeafBytes: leafInput}
+	logEntry.Leaf.Version = ct.V1
+	logEntry.Leaf.LeafType = ct.TimestampedEntryLeafType
+	timestampedEntry := &logEntry.Leaf.TimestampedEntry
+	timestampedEntry.Timestamp = entry.Timestamp
+	timestampedEntry.Extensions = entry.SCTExtensions
+	switch itemType {
+	case X509EntryV2:
+		if err := checkTBSCertificate(submission, entry.TBSCertificate); err != nil {
+			return nil, err
+		}
+		timestampedEntry.EntryType = ct.X509LogEntryType
+		timestampedEntry.X509Entry = submission
+	case PrecertEntryV2:
+		if len(entry.IssuerKeyHash) != sha256.Size {
+			return nil, fmt.Errorf("issuer_key_hash has wrong length %d", len(entry.IssuerKeyHash))
+		}
+		timestampedEntry.EntryType = ct.PrecertLogEntryType
+		copy(timestampedEntry.PrecertEntry.IssuerKeyHash[:], entry.IssuerKeyHash)
+		timestampedEntry.PrecertEntry.TBSCertificate = entry.TBSCertificate
+		logEntry.Chain = append(logEntry.Chain, submission)
+	}
+	for _, cert := range chain {
+		logEntry.Chain = append(logEntry.Chain, cert)
+	}
+	return logEntry, nil
+}
+
+// checkTBSCertificate checks that |cert| is the certificate whose
+// TBSCertificate was logged
+func checkTBSCertificate(cert []byte, tbs []byte) error {
+	var parsed struct {
+		TBSCertificate     asn1.RawValue
+		SignatureAlgorithm asn1.RawValue
+		Signature          asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert, &parsed); err != nil {
+		return fmt.Errorf("submitted certificate is malformed: %s", err)
+	}
+	if !bytes.Equal(parsed.TBSCertificate.FullBytes, tbs) {
+		return errors.New("submitted certificate doesn't match the logged TBSCertificate")
+	}
+	return nil
+}
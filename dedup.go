@@ -0,0 +1,89 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"strconv"
+	"sync"
+)
+
+// Deduplicator collects matching entries so that each unique certificate
+// (by SHA-256 fingerprint) can be reported once, along with every log it
+// was seen in.  It is safe for concurrent use.
+type Deduplicator struct {
+	mu         sync.Mutex
+	entries    map[string]*EntryInfo
+	suppressed map[string]bool
+	order      []string
+}
+
+func NewDeduplicator() *Deduplicator {
+	return &Deduplicator{
+		entries:    make(map[string]*EntryInfo),
+		suppressed: make(map[string]bool),
+	}
+}
+
+func dedupKey(info *EntryInfo) string {
+	if fingerprint := info.Fingerprint(); fingerprint != "" {
+		return fingerprint
+	}
+	// Can't identify the certificate, so treat every entry as unique
+	return info.LogUri + " " + strconv.FormatInt(info.Entry.Index, 10)
+}
+
+// Add records |info|, returning true if its certificate has not been added
+// before.  Otherwise, the entry's log is added to the SeenInLogs of the
+// entry that was added first.
+func (d *Deduplicator) Add(info *EntryInfo) bool {
+	key := dedupKey(info)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if first, exists := d.entries[key]; exists {
+		for _, logUri := range first.SeenInLogs {
+			if logUri == info.LogUri {
+				return false
+			}
+		}
+		first.SeenInLogs = append(first.SeenInLogs, info.LogUri)
+		return false
+	}
+	info.SeenInLogs = []string{info.LogUri}
+	d.entries[key] = info
+	d.order = append(d.order, key)
+	return true
+}
+
+// Suppress prevents |info|'s certificate from being passed to Flush (e.g.
+// because it was already reported in a previous run).  Further entries for
+// the certificate are still absorbed by Add.
+func (d *Deduplicator) Suppress(info *EntryInfo) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.suppressed[dedupKey(info)] = true
+}
+
+// Flush calls |callback| for each unique, unsuppressed certificate, in the
+// order they were first added, and then forgets every certificate.
+func (d *Deduplicator) Flush(callback func(*EntryInfo)) {
+	d.mu.Lock()
+	entries, suppressed, order := d.entries, d.suppressed, d.order
+	d.entries = make(map[string]*EntryInfo)
+	d.suppressed = make(map[string]bool)
+	d.order = nil
+	d.mu.Unlock()
+
+	for _, key := range order {
+		if !suppressed[key] {
+			callback(entries[key])
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+// A minimal DNS stub resolver, since the standard library can't look up
+// CAA records
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTypeCAA   = 257
+	dnsClassIN   = 1
+	dnsRcodeOK   = 0
+	dnsRcodeNX   = 3
+	dnsMaxUDP    = 4096
+	dnsFlagQR    = 1 << 15
+	dnsFlagTC    = 1 << 9
+	dnsFlagRD    = 1 << 8
+	dnsRcodeMask = 0xF
+)
+
+var errDNSTruncated = errors.New("DNS response is truncated")
+
+type dnsRR struct {
+	rrtype uint16
+	data   []byte
+}
+
+type dnsResponse struct {
+	rcode   int
+	answers []dnsRR
+}
+
+// DefaultDNSServer returns the first nameserver in /etc/resolv.conf, as
+// host:port, or localhost if there isn't one
+func DefaultDNSServer() string {
+	if file, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+func makeDNSQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	query := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(query[0:], id)
+	binary.BigEndian.PutUint16(query[2:], dnsFlagRD)
+	binary.BigEndian.PutUint16(query[4:], 1) // QDCOUNT
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("Invalid DNS name %q", name)
+		}
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	query = append(query, byte(qtype>>8), byte(qtype), 0, dnsClassIN)
+	return query, nil
+}
+
+// skipDNSName returns the offset in |msg| just past the name at |offset|
+func skipDNSName(msg []byte, offset int) (int, error) {
+	for {
+		if offset >= len(msg) {
+			return 0, errors.New("DNS name is truncated")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xC0 == 0xC0: // compression pointer, which ends the name
+			return offset + 2, nil
+		case length&0xC0 != 0:
+			return 0, errors.New("DNS name has an invalid label type")
+		default:
+			offset += 1 + length
+		}
+	}
+}
+
+func parseDNSResponse(msg []byte, id uint16) (*dnsResponse, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("DNS response is too short")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, errors.New("DNS response has the wrong ID")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagQR == 0 {
+		return nil, errors.New("DNS response is not a response")
+	}
+	if flags&dnsFlagTC != 0 {
+		return nil, errDNSTruncated
+	}
+	response := &dnsResponse{rcode: int(flags & dnsRcodeMask)}
+	if response.rcode == dnsRcodeNX {
+		return response, nil
+	} else if response.rcode != dnsRcodeOK {
+		return nil, fmt.Errorf("DNS server returned rcode %d", response.rcode)
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+	offset := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if offset, err = skipDNSName(msg, offset); err != nil {
+			return nil, err
+		}
+		offset += 4 // QTYPE and QCLASS
+	}
+	for i := 0; i < ancount; i++ {
+		if offset, err = skipDNSName(msg, offset); err != nil {
+			return nil, err
+		}
+		if offset+10 > len(msg) {
+			return nil, errors.New("DNS resource record is truncated")
+		}
+		rrtype := binary.BigEndian.Uint16(msg[offset:])
+		rdlength := int(binary.BigEndian.Uint16(msg[offset+8:]))
+		offset += 10
+		if offset+rdlength > len(msg) {
+			return nil, errors.New("DNS resource record data is truncated")
+		}
+		response.answers = append(response.answers, dnsRR{rrtype: rrtype, data: msg[offset : offset+rdlength]})
+		offset += rdlength
+	}
+	return response, nil
+}
+
+// dnsExchange sends a recursive query for |name| and |qtype| to |server|
+// over |network| (udp or tcp)
+func dnsExchange(server string, network string, name string, qtype uint16, timeout time.Duration) (*dnsResponse, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+	query, err := makeDNSQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout(network, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var msg []byte
+	if network == "tcp" {
+		if _, err := conn.Write(append([]byte{byte(len(query) >> 8), byte(len(query))}, query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		msg = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		msg = make([]byte, dnsMaxUDP)
+		n, err := conn.Read(msg)
+		if err != nil {
+			return nil, err
+		}
+		msg = msg[:n]
+	}
+	return parseDNSResponse(msg, id)
+}
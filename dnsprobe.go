@@ -0,0 +1,146 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSStatus is whether one of a certificate's DNS names currently exists,
+// and where it points
+type DNSStatus struct {
+	Name      string   `json:"name"`
+	Exists    bool     `json:"exists"` // has an address
+	CNAME     string   `json:"cname,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	Error     string   `json:"error,omitempty"` // if the lookup failed, in which case Exists is meaningless
+}
+
+func (status DNSStatus) String() string {
+	switch {
+	case status.Error != "":
+		return status.Name + ": error: " + status.Error
+	case !status.Exists:
+		return status.Name + ": does not exist"
+	case status.CNAME != "":
+		return status.Name + ": " + strings.Join(status.Addresses, ", ") + " (via " + status.CNAME + ")"
+	default:
+		return status.Name + ": " + strings.Join(status.Addresses, ", ")
+	}
+}
+
+// How long DNSProber caches lookups
+const dnsProbeCacheTime = 10 * time.Minute
+
+type dnsProbeCacheEntry struct {
+	status  DNSStatus
+	expires time.Time
+}
+
+// DNSProber resolves the DNS names of certificates, to help triage whether
+// they correspond to live infrastructure.  Lookups use the recursive
+// resolver at Server (host:port), or the system's resolver if it's empty,
+// and are cached for ten minutes.  Wildcard names are probed by their base
+// domain.  At most MaxNames names are probed per certificate, if set.
+type DNSProber struct {
+	Server   string
+	Timeout  time.Duration
+	MaxNames int
+
+	resolver *net.Resolver
+	mu       sync.Mutex
+	cache    map[string]dnsProbeCacheEntry
+}
+
+func NewDNSProber(server string) *DNSProber {
+	prober := &DNSProber{Server: server, Timeout: 5 * time.Second, MaxNames: 10}
+	prober.resolver = &net.Resolver{PreferGo: true}
+	if server != "" {
+		prober.resolver.Dial = func(ctx context.Context, network string, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, prober.Server)
+		}
+	}
+	return prober
+}
+
+// Probe returns the status of each of the entry's DNS names
+func (prober *DNSProber) Probe(info *EntryInfo) []DNSStatus {
+	if info.Identifiers == nil {
+		return nil
+	}
+	var statuses []DNSStatus
+	for _, dnsName := range info.Identifiers.DNSNames {
+		if prober.MaxNames > 0 && len(statuses) == prober.MaxNames {
+			break
+		}
+		statuses = append(statuses, prober.Lookup(dnsName))
+	}
+	return statuses
+}
+
+// Lookup returns the status of |dnsName|
+func (prober *DNSProber) Lookup(dnsName string) DNSStatus {
+	name := strings.TrimSuffix(strings.ToLower(strings.TrimPrefix(dnsName, "*.")), ".")
+	if status, ok := prober.cached(name); ok {
+		status.Name = dnsName
+		return status
+	}
+	status := prober.query(name)
+	prober.store(name, status)
+	status.Name = dnsName
+	return status
+}
+
+func (prober *DNSProber) cached(name string) (DNSStatus, bool) {
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	entry, ok := prober.cache[name]
+	if !ok || time.Now().After(entry.expires) {
+		return DNSStatus{}, false
+	}
+	return entry.status, true
+}
+
+func (prober *DNSProber) store(name string, status DNSStatus) {
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	if prober.cache == nil {
+		prober.cache = make(map[string]dnsProbeCacheEntry)
+	}
+	prober.cache[name] = dnsProbeCacheEntry{status: status, expires: time.Now().Add(dnsProbeCacheTime)}
+}
+
+func (prober *DNSProber) query(name string) DNSStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), prober.Timeout)
+	defer cancel()
+
+	// The trailing dot keeps the name from being qualified by the search list
+	addrs, err := prober.resolver.LookupIPAddr(ctx, name+".")
+	if dnsErr, isDNSErr := err.(*net.DNSError); isDNSErr && dnsErr.IsNotFound {
+		return DNSStatus{Exists: false}
+	} else if err != nil {
+		return DNSStatus{Error: err.Error()}
+	}
+	status := DNSStatus{Exists: true}
+	for _, addr := range addrs {
+		status.Addresses = append(status.Addresses, addr.String())
+	}
+	if cname, err := prober.resolver.LookupCNAME(ctx, name+"."); err == nil {
+		if cname = strings.TrimSuffix(cname, "."); cname != name {
+			status.CNAME = cname
+		}
+	}
+	return status
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// serveFakeAddressDNS answers A queries with |zone|'s address for each name,
+// AAAA queries with no records, and queries for other names with NXDOMAIN
+func serveFakeAddressDNS(t *testing.T, zone map[string]net.IP) (string, *int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	queries := new(int32)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(queries, 1)
+			query := buf[:n]
+			var labels []string
+			offset := 12
+			for ; query[offset] != 0; offset += 1 + int(query[offset]) {
+				labels = append(labels, string(query[offset+1:offset+1+int(query[offset])]))
+			}
+			qtype := binary.BigEndian.Uint16(query[offset+1:])
+			response := append([]byte(nil), query[:offset+5]...) // without any EDNS record
+			ip, ok := zone[strings.ToLower(strings.Join(labels, "."))]
+			flags := uint16(dnsFlagQR | dnsFlagRD | 0x80)
+			if !ok {
+				flags |= dnsRcodeNX
+			}
+			binary.BigEndian.PutUint16(response[2:], flags)
+			binary.BigEndian.PutUint16(response[6:], 0)
+			binary.BigEndian.PutUint16(response[8:], 0)
+			binary.BigEndian.PutUint16(response[10:], 0)
+			if ok && qtype == 1 {
+				binary.BigEndian.PutUint16(response[6:], 1)
+				response = append(response, 0xC0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+				response = append(response, ip.To4()...)
+			}
+			conn.WriteTo(response, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), queries
+}
+
+func TestDNSProber(t *testing.T) {
+	server, queries := serveFakeAddressDNS(t, map[string]net.IP{
+		"www.example.com": net.IPv4(192, 0, 2, 1),
+		"example.com":     net.IPv4(192, 0, 2, 2),
+	})
+	prober := NewDNSProber(server)
+
+	status := prober.Lookup("www.example.com")
+	if !status.Exists || len(status.Addresses) != 1 || status.Addresses[0] != "192.0.2.1" || status.CNAME != "" || status.Error != "" {
+		t.Errorf("Wrong status: %+v", status)
+	}
+	if status := prober.Lookup("*.example.com"); status.Name != "*.example.com" || !status.Exists || status.Addresses[0] != "192.0.2.2" {
+		t.Errorf("Wrong status of wildcard: %+v", status)
+	}
+	if status := prober.Lookup("nonexistent.example.com"); status.Exists || status.Error != "" || status.String() != "nonexistent.example.com: does not exist" {
+		t.Errorf("Wrong status: %+v", status)
+	}
+
+	before := atomic.LoadInt32(queries)
+	if status := prober.Lookup("WWW.example.com"); status.Name != "WWW.example.com" || !status.Exists {
+		t.Errorf("Wrong status: %+v", status)
+	}
+	if after := atomic.LoadInt32(queries); after != before {
+		t.Errorf("Lookup wasn't cached: made %d more queries", after-before)
+	}
+
+	prober.MaxNames = 2
+	info := &EntryInfo{Identifiers: &Identifiers{DNSNames: []string{"www.example.com", "example.com", "nonexistent.example.com"}}}
+	if statuses := prober.Probe(info); len(statuses) != 2 || statuses[1].Name != "example.com" {
+		t.Errorf("Wrong statuses: %+v", statuses)
+	}
+}
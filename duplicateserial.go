@@ -0,0 +1,63 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+const MatchDuplicateSerial = "duplicate_serial"
+
+// SerialKey returns the SHA-256 hash of the certificate's issuer and serial
+// number, as they're encoded in the certificate.  A CA must never use the
+// same serial number for two different certificates, so two entries with
+// the same SerialKey must have the same IssuanceKey.  Returns nil if the
+// entry can't be parsed.
+func (info *EntryInfo) SerialKey() []byte {
+	if info.CertInfo == nil {
+		return nil
+	}
+	issuer := info.CertInfo.TBS.Issuer.FullBytes
+	serial := info.CertInfo.TBS.SerialNumber.FullBytes
+	if len(issuer) == 0 || len(serial) == 0 {
+		return nil
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(issuer)))
+	hash := sha256.New()
+	hash.Write(length[:])
+	hash.Write(issuer)
+	hash.Write(serial)
+	return hash.Sum(nil)
+}
+
+// DuplicateSerialMatch returns the match added to an entry whose serial
+// number was used by its issuer for the certificate with fingerprint |other|
+func DuplicateSerialMatch(info *EntryInfo, other string) Match {
+	match := Match{Category: MatchDuplicateSerial, ID: MatchDuplicateSerial, Pattern: "also used by " + other}
+	if info.CertInfo != nil && info.CertInfo.SerialNumber != nil {
+		match.Value = info.CertInfo.SerialNumber.Text(16)
+	}
+	return match
+}
+
+// Stores which can detect duplicate serial numbers implement SerialStore
+type SerialStore interface {
+	Store
+
+	// Record that the issuance identified by |issuanceKey| (see
+	// EntryInfo.IssuanceKey), whose certificate has the given
+	// fingerprint, has the issuer and serial number identified by |key|
+	// (see EntryInfo.SerialKey).  If a different issuance was recorded
+	// with the same key before, the fingerprint of its certificate is
+	// returned; otherwise the empty string is returned.
+	SaveSerial(key []byte, issuanceKey []byte, fingerprint string) (string, error)
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+)
+
+func TestSerialKey(t *testing.T) {
+	first := &EntryInfo{CertInfo: makeTestCertInfo(t, nil, nil, nil)}
+	second := &EntryInfo{CertInfo: makeTestCertInfo(t, nil, nil, nil)}
+	if !bytes.Equal(first.SerialKey(), second.SerialKey()) {
+		t.Error("Certificates with the same issuer and serial number have different serial keys")
+	}
+	if bytes.Equal(first.IssuanceKey(), second.IssuanceKey()) {
+		t.Error("Different certificates have the same issuance key")
+	}
+
+	otherSerial := &EntryInfo{CertInfo: makeTestCertInfo(t, nil, nil, func(template *x509.Certificate) {
+		template.SerialNumber = big.NewInt(5678)
+	})}
+	otherIssuer := &EntryInfo{CertInfo: makeTestCertInfo(t, nil, nil, func(template *x509.Certificate) {
+		template.Subject = pkix.Name{CommonName: "Other CA"}
+	})}
+	for _, other := range []*EntryInfo{otherSerial, otherIssuer} {
+		if bytes.Equal(first.SerialKey(), other.SerialKey()) {
+			t.Errorf("Certificate with serial %x has the same serial key", other.CertInfo.SerialNumber)
+		}
+	}
+
+	if key := (&EntryInfo{}).SerialKey(); key != nil {
+		t.Errorf("Unparsed entry has serial key %x", key)
+	}
+
+	match := DuplicateSerialMatch(second, "abcd")
+	if match.Category != MatchDuplicateSerial || match.Value != "4d2" || match.Pattern != "also used by abcd" {
+		t.Errorf("Wrong match: %+v", match)
+	}
+}
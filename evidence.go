@@ -0,0 +1,75 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// Types of misbehavior recorded in an Evidence bundle
+const (
+	EvidenceInconsistentSTHs    = "inconsistent_sths"    // two STHs are not consistent with each other
+	EvidenceBadEntries          = "bad_entries"          // entries do not hash to the root in the STH
+	EvidenceUnincorporatedEntry = "unincorporated_entry" // entry promised by an SCT is not in the tree
+	EvidenceFinalTreeHead       = "final_tree_head"      // read-only log's STH differs from its final tree head
+)
+
+// EvidenceEntry is a log entry which the log misbehaved with
+type EvidenceEntry struct {
+	Index     *int64        `json:"index,omitempty"` // nil if the log never said where the entry is
+	LeafInput []byte        `json:"leaf_input"`      // MerkleTreeLeaf structure
+	Chain     []ct.ASN1Cert `json:"chain,omitempty"`
+}
+
+// Evidence contains everything needed to show a third party (such as the log
+// operator or a root program) that a log has misbehaved.  Since STHs and SCTs
+// are signed by the log, the bundle can be checked by anyone who has the
+// log's public key.
+type Evidence struct {
+	Type        string               `json:"type"`
+	Description string               `json:"description"`
+	Time        time.Time            `json:"time"`
+	LogURI      string               `json:"log_uri"`
+	LogID       ct.SHA256Hash        `json:"log_id"`
+	STHs        []ct.SignedTreeHead  `json:"sths"`
+	Consistency ct.ConsistencyProof  `json:"consistency_proof,omitempty"`
+	AuditPath   ct.AuditPath         `json:"audit_path,omitempty"` // log's inclusion proof for Entries[0], if any
+	Tree        *CollapsedMerkleTree `json:"tree,omitempty"`       // state of the tree before the entries were added
+	Entries     []EvidenceEntry      `json:"entries,omitempty"`
+	PendingSCTs []PendingSCT         `json:"pending_scts,omitempty"`
+}
+
+func NewEvidence(evidenceType string, description string, logUri string, sths ...*ct.SignedTreeHead) *Evidence {
+	evidence := &Evidence{
+		Type:        evidenceType,
+		Description: description,
+		Time:        time.Now().UTC(),
+		LogURI:      logUri,
+		STHs:        make([]ct.SignedTreeHead, 0, len(sths)),
+	}
+	for _, sth := range sths {
+		evidence.STHs = append(evidence.STHs, *sth)
+	}
+	if len(sths) > 0 {
+		evidence.LogID = sths[0].LogID
+	}
+	return evidence
+}
+
+func (evidence *Evidence) AddEntry(entry *ct.LogEntry) {
+	index := entry.Index
+	evidence.Entries = append(evidence.Entries, EvidenceEntry{
+		Index:     &index,
+		LeafInput: entry.LeafBytes,
+		Chain:     entry.Chain,
+	})
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+func TestEvidenceRoundTrip(t *testing.T) {
+	leaves := makeTestLeaves(10)
+	sth := makeTestSTH(leaves)
+	tree := EmptyCollapsedMerkleTree()
+	for _, leaf := range leaves[:7] {
+		tree.Add(leaf)
+	}
+
+	evidence := NewEvidence(EvidenceBadEntries, "log entries do not correspond to signed tree root", "https://ct.example.com", sth)
+	evidence.AddEntry(&ct.LogEntry{Index: 7, LeafBytes: []byte("bogus"), Chain: []ct.ASN1Cert{[]byte("issuer")}})
+	evidence.AuditPath = referencePath(7, leaves)
+	evidence.Tree = tree
+	evidence.PendingSCTs = []PendingSCT{{Timestamp: 1500000000000, LeafHash: hashLeaf([]byte("bogus")), LeafInput: []byte("bogus"), Fingerprint: "abcd"}}
+	evidence.Entries = append(evidence.Entries, EvidenceEntry{LeafInput: []byte("unindexed")})
+
+	encoded, err := json.Marshal(evidence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Evidence
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.Entries) != 2 || decoded.Entries[0].Index == nil || *decoded.Entries[0].Index != 7 || string(decoded.Entries[0].LeafInput) != "bogus" || len(decoded.Entries[0].Chain) != 1 {
+		t.Errorf("Wrong entries: %s", encoded)
+	} else if decoded.Entries[1].Index != nil || string(decoded.Entries[1].LeafInput) != "unindexed" {
+		t.Errorf("Wrong unindexed entry: %s", encoded)
+	}
+	if !reflect.DeepEqual(decoded.AuditPath, evidence.AuditPath) || !VerifyInclusionProof(decoded.AuditPath, 7, leaves[7], sth) {
+		t.Errorf("Wrong audit path: %s", encoded)
+	}
+	if decoded.Tree == nil || decoded.Tree.GetSize() != 7 || !reflect.DeepEqual(decoded.Tree.CalculateRoot(), tree.CalculateRoot()) {
+		t.Errorf("Wrong tree: %s", encoded)
+	}
+	if len(decoded.PendingSCTs) != 1 || string(decoded.PendingSCTs[0].LeafInput) != "bogus" || !reflect.DeepEqual(hashLeaf(decoded.PendingSCTs[0].LeafInput), decoded.PendingSCTs[0].LeafHash) {
+		t.Errorf("Wrong pending SCTs: %s", encoded)
+	}
+	if len(decoded.STHs) != 1 || decoded.STHs[0].TreeSize != 10 || decoded.STHs[0].SHA256RootHash != sth.SHA256RootHash {
+		t.Errorf("Wrong STHs: %s", encoded)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// eval evaluates |n|, returning an *unknownError if it depends on a part of
+// the certificate that couldn't be parsed.  The logical operators follow
+// three-valued logic, so for example false && UNKNOWN is false.
+func eval(n node, info *certspotter.EntryInfo) (interface{}, error) {
+	switch n := n.(type) {
+	case *literalNode:
+		return n.value, nil
+	case *fieldNode:
+		value, err := n.def.get(info)
+		if err != nil {
+			return nil, &unknownError{field: n.name, err: err}
+		}
+		return value, nil
+	case *unaryNode:
+		x, err := eval(n.x, info)
+		if err != nil {
+			return nil, err
+		}
+		b, err := asBool(x)
+		if err != nil {
+			return nil, err
+		}
+		return !b, nil
+	case *binaryNode:
+		return evalBinary(n, info)
+	case *callNode:
+		return evalCall(n, info)
+	}
+	panic("invalid node")
+}
+
+func evalBinary(n *binaryNode, info *certspotter.EntryInfo) (interface{}, error) {
+	x, xErr := eval(n.x, info)
+	if n.op == "&&" || n.op == "||" {
+		// The result if either operand has this value
+		decisive := n.op == "||"
+		var xBool, yBool bool
+		if xErr == nil {
+			xBool, xErr = asBool(x)
+		}
+		if xErr == nil && xBool == decisive {
+			return decisive, nil
+		}
+		y, yErr := eval(n.y, info)
+		if yErr == nil {
+			yBool, yErr = asBool(y)
+		}
+		if yErr == nil && yBool == decisive {
+			return decisive, nil
+		}
+		if xErr != nil {
+			return nil, xErr
+		}
+		if yErr != nil {
+			return nil, yErr
+		}
+		return yBool, nil
+	}
+	if xErr != nil {
+		return nil, xErr
+	}
+	y, err := eval(n.y, info)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return x == y, nil
+	case "!=":
+		return x != y, nil
+	case "in":
+		str, err := asString(x)
+		if err != nil {
+			return nil, err
+		}
+		list, err := asList(y)
+		if err != nil {
+			return nil, err
+		}
+		for _, elem := range list {
+			if elem == str {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	a, err := asInt(x)
+	if err != nil {
+		return nil, err
+	}
+	b, err := asInt(y)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	case ">=":
+		return a >= b, nil
+	}
+	panic("invalid operator " + n.op)
+}
+
+func evalCall(n *callNode, info *certspotter.EntryInfo) (interface{}, error) {
+	recv, err := eval(n.recv, info)
+	if err != nil {
+		return nil, err
+	}
+	if n.method == "size" {
+		if list, ok := recv.([]string); ok {
+			return int64(len(list)), nil
+		}
+		str, err := asString(recv)
+		if err != nil {
+			return nil, err
+		}
+		return int64(utf8.RuneCountInString(str)), nil
+	}
+	var arg string
+	if n.arg != nil {
+		value, err := eval(n.arg, info)
+		if err != nil {
+			return nil, err
+		}
+		if arg, err = asString(value); err != nil {
+			return nil, err
+		}
+	}
+	test := func(s string) bool {
+		switch n.method {
+		case "startsWith":
+			return strings.HasPrefix(s, arg)
+		case "endsWith":
+			return strings.HasSuffix(s, arg)
+		case "contains":
+			return strings.Contains(s, arg)
+		case "matches":
+			return n.re.MatchString(s)
+		}
+		panic("invalid method " + n.method)
+	}
+	// Methods called on a list are true if they're true for any element
+	if list, ok := recv.([]string); ok {
+		for _, elem := range list {
+			if test(elem) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	str, err := asString(recv)
+	if err != nil {
+		return nil, err
+	}
+	return test(str), nil
+}
+
+// The parser checks types, so these only fail if a field returns a value
+// of the wrong type (such as nil), which is an error rather than a panic
+
+func typeError(value interface{}, expected string) error {
+	return fmt.Errorf("expected %s, but got %T", expected, value)
+}
+
+func asBool(value interface{}) (bool, error) {
+	if b, ok := value.(bool); ok {
+		return b, nil
+	}
+	return false, typeError(value, "a bool")
+}
+
+func asString(value interface{}) (string, error) {
+	if str, ok := value.(string); ok {
+		return str, nil
+	}
+	return "", typeError(value, "a string")
+}
+
+func asInt(value interface{}) (int64, error) {
+	if n, ok := value.(int64); ok {
+		return n, nil
+	}
+	return 0, typeError(value, "an int")
+}
+
+func asList(value interface{}) ([]string, error) {
+	if list, ok := value.([]string); ok {
+		return list, nil
+	}
+	return nil, typeError(value, "a list")
+}
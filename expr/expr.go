@@ -0,0 +1,96 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package expr implements a small expression language for selecting
+// entries, such as:
+//
+//	san.endsWith(".bank.example") && issuer.org != "Example CA" && key.rsaBits < 2048
+//
+// Expressions are made of fields, string literals ("..." with Go escapes, or
+// '...' without escapes), integers, true, false, and the operators
+// ||, &&, !, ==, !=, <, <=, >, >=, and in (e.g. "www.example.com" in san),
+// with the usual precedence.  Strings and lists have the methods
+// startsWith(s), endsWith(s), contains(s), matches(regexp), and size().
+// A method called on a list is true if it's true for any element.
+//
+// The fields are:
+//
+//	san		list of DNS names
+//	ip		list of IP addresses
+//	email		list of email addresses
+//	uri		list of URIs
+//	issuer		issuer distinguished name
+//	issuer.cn	issuer common name
+//	issuer.org	issuer organization
+//	subject		subject distinguished name
+//	subject.cn	subject common name
+//	subject.org	subject organization
+//	serial		serial number, in lowercase hex
+//	pubkeyHash	SHA-256 hash of the Subject Public Key Info, in hex
+//	key.algorithm	RSA, ECDSA, DSA, DH, Ed25519, Ed448, or unknown
+//	key.bits	size of the key
+//	key.rsaBits	size of the key if it's RSA, and 0 otherwise
+//	key.curve	ECDSA curve (e.g. P-256)
+//	validityDays	length of the validity period, in whole days
+//	isCA		whether the certificate is a CA certificate
+//	isPrecert	whether the entry is a precertificate
+//	fingerprint	SHA-256 fingerprint, in hex
+//	log		URI of the log containing the entry
+//
+// Type errors and unknown fields are detected when the expression is compiled.
+package expr
+
+import (
+	"fmt"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const MatchExpression = "expression"
+
+// Expr is a compiled expression, which implements certspotter.Matcher
+type Expr struct {
+	ID     string // identifies the expression in matches
+	source string
+	root   node
+}
+
+func Compile(source string) (*Expr, error) {
+	root, err := parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid expression `%s': %s", source, err)
+	}
+	return &Expr{source: source, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression.  An error is returned if the result depends
+// on a part of the certificate that couldn't be parsed.
+func (e *Expr) Eval(info *certspotter.EntryInfo) (bool, error) {
+	result, err := eval(e.root, info)
+	if err != nil {
+		return false, err
+	}
+	return asBool(result)
+}
+
+func (e *Expr) Match(info *certspotter.EntryInfo) []certspotter.Match {
+	result, err := e.Eval(info)
+	if err != nil {
+		// Fail safe: the entry might match
+		return []certspotter.Match{{Category: certspotter.MatchUnparsable, ID: e.ID, Pattern: e.source, Value: err.Error()}}
+	}
+	if !result {
+		return nil
+	}
+	return []certspotter.Match{{Category: MatchExpression, ID: e.ID, Pattern: e.source}}
+}
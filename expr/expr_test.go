@@ -0,0 +1,102 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package expr
+
+import (
+	"testing"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+func TestEval(t *testing.T) {
+	info := &certspotter.EntryInfo{
+		LogUri:      "https://ct.example.com/log/",
+		IsPrecert:   true,
+		Identifiers: &certspotter.Identifiers{DNSNames: []string{"www.bank.example", "example.com"}},
+	}
+	tests := []struct {
+		source   string
+		expected bool
+		unknown  bool
+	}{
+		{`san.endsWith(".bank.example")`, true, false},
+		{`san.endsWith(".bank.test")`, false, false},
+		{`"example.com" in san && isPrecert`, true, false},
+		{`!("example.org" in san) && san.size() == 2`, true, false},
+		{`san.matches('^www\.[a-z]+\.example$') || false`, true, false},
+		{`log.startsWith("https://ct.example.com/")`, true, false},
+		{`isPrecert && key.rsaBits < 2048`, false, true},
+		{`isPrecert == false && key.rsaBits < 2048`, false, false},
+		{`isPrecert || key.rsaBits < 2048`, true, false},
+	}
+	for _, test := range tests {
+		e, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+			continue
+		}
+		result, err := e.Eval(info)
+		if test.unknown {
+			if err == nil {
+				t.Errorf("%s: should be unknown, but is %v", test.source, result)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+		} else if result != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.source, result, test.expected)
+		}
+	}
+}
+
+// Identifiers which were never parsed make list fields unknown, rather
+// than panicking
+func TestEvalUnparsedIdentifiers(t *testing.T) {
+	for _, source := range []string{
+		`"x" in san`,
+		`san.endsWith("x")`,
+		`san.size() == 0`,
+		`ip.contains("10.")`,
+		`email.size() > 0 || uri.size() > 0`,
+		`!("x" in san)`,
+	} {
+		e, err := Compile(source)
+		if err != nil {
+			t.Errorf("%s: %s", source, err)
+			continue
+		}
+		if result, err := e.Eval(&certspotter.EntryInfo{}); err == nil {
+			t.Errorf("%s: should be unknown, but is %v", source, result)
+		}
+		if result, err := e.Eval(&certspotter.EntryInfo{Identifiers: &certspotter.Identifiers{}}); err != nil {
+			t.Errorf("%s: with empty identifiers: %s", source, err)
+		} else if expected := source == `san.size() == 0` || source == `!("x" in san)`; result != expected {
+			t.Errorf("%s: with empty identifiers: got %v, expected %v", source, result, expected)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, source := range []string{
+		`san == "example.com"`,
+		`issuer.org < 5`,
+		`nosuchfield == 1`,
+		`san.endsWith(1)`,
+		`san.frobnicate("x")`,
+		`san.matches("(")`,
+		`key.bits`,
+		`"unterminated`,
+		`(isCA`,
+		`isPrecert && 1 + 1`,
+	} {
+		if _, err := Compile(source); err == nil {
+			t.Errorf("%s: should not compile", source)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// unknownError is returned when a field can't be evaluated because part of
+// the certificate couldn't be parsed
+type unknownError struct {
+	field string
+	err   error
+}
+
+func (e *unknownError) Error() string {
+	return fmt.Sprintf("%s is unknown: %s", e.field, e.err)
+}
+
+var (
+	errNotParsed            = errors.New("certificate could not be parsed")
+	errIdentifiersNotParsed = errors.New("identifiers could not be parsed")
+)
+
+type fieldDef struct {
+	t   valueType
+	get func(*certspotter.EntryInfo) (interface{}, error)
+}
+
+func certField(get func(*certspotter.CertInfo) (interface{}, error)) func(*certspotter.EntryInfo) (interface{}, error) {
+	return func(info *certspotter.EntryInfo) (interface{}, error) {
+		if info.CertInfo == nil {
+			return nil, errNotParsed
+		}
+		return get(info.CertInfo)
+	}
+}
+
+// identifiersField returns a list field of the entry's identifiers, which is
+// unknown if they weren't parsed.  A nil list is returned as an empty one.
+func identifiersField(get func(*certspotter.Identifiers) []string) func(*certspotter.EntryInfo) (interface{}, error) {
+	return func(info *certspotter.EntryInfo) (interface{}, error) {
+		if info.Identifiers == nil {
+			if info.IdentifiersParseError != nil {
+				return nil, info.IdentifiersParseError
+			}
+			return nil, errIdentifiersNotParsed
+		}
+		if list := get(info.Identifiers); list != nil {
+			return list, nil
+		}
+		return []string{}, nil
+	}
+}
+
+func firstOrEmpty(values []string, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+	return values[0], nil
+}
+
+func dnField(get func(*certspotter.CertInfo) (certspotter.RDNSequence, error), attribute func(certspotter.RDNSequence) ([]string, error)) func(*certspotter.EntryInfo) (interface{}, error) {
+	return certField(func(cert *certspotter.CertInfo) (interface{}, error) {
+		rdns, err := get(cert)
+		if err != nil {
+			return nil, err
+		}
+		if attribute == nil {
+			return rdns.String(), nil
+		}
+		return firstOrEmpty(attribute(rdns))
+	})
+}
+
+func issuerDN(cert *certspotter.CertInfo) (certspotter.RDNSequence, error) {
+	return cert.Issuer, cert.IssuerParseError
+}
+
+func subjectDN(cert *certspotter.CertInfo) (certspotter.RDNSequence, error) {
+	return cert.Subject, cert.SubjectParseError
+}
+
+func keyField(get func(*certspotter.PublicKeyInfo) interface{}) func(*certspotter.EntryInfo) (interface{}, error) {
+	return certField(func(cert *certspotter.CertInfo) (interface{}, error) {
+		if cert.PublicKeyParseError != nil {
+			return nil, cert.PublicKeyParseError
+		}
+		return get(cert.PublicKey), nil
+	})
+}
+
+var fields = map[string]*fieldDef{
+	"san": {typeList, identifiersField(func(identifiers *certspotter.Identifiers) []string {
+		return identifiers.DNSNames
+	})},
+	"ip": {typeList, identifiersField(func(identifiers *certspotter.Identifiers) []string {
+		ipAddrs := make([]string, len(identifiers.IPAddrs))
+		for i, ipAddr := range identifiers.IPAddrs {
+			ipAddrs[i] = ipAddr.String()
+		}
+		return ipAddrs
+	})},
+	"email": {typeList, identifiersField(func(identifiers *certspotter.Identifiers) []string {
+		return identifiers.EmailAddrs
+	})},
+	"uri": {typeList, identifiersField(func(identifiers *certspotter.Identifiers) []string {
+		return identifiers.URIs
+	})},
+	"issuer":      {typeString, dnField(issuerDN, nil)},
+	"issuer.cn":   {typeString, dnField(issuerDN, certspotter.RDNSequence.ParseCNs)},
+	"issuer.org":  {typeString, dnField(issuerDN, certspotter.RDNSequence.ParseOrganizations)},
+	"subject":     {typeString, dnField(subjectDN, nil)},
+	"subject.cn":  {typeString, dnField(subjectDN, certspotter.RDNSequence.ParseCNs)},
+	"subject.org": {typeString, dnField(subjectDN, certspotter.RDNSequence.ParseOrganizations)},
+	"serial": {typeString, certField(func(cert *certspotter.CertInfo) (interface{}, error) {
+		if cert.SerialNumberParseError != nil {
+			return nil, cert.SerialNumberParseError
+		}
+		return fmt.Sprintf("%x", cert.SerialNumber), nil
+	})},
+	"pubkeyHash": {typeString, certField(func(cert *certspotter.CertInfo) (interface{}, error) {
+		return cert.PubkeyHash(), nil
+	})},
+	"key.algorithm": {typeString, keyField(func(key *certspotter.PublicKeyInfo) interface{} { return key.Algorithm })},
+	"key.bits":      {typeInt, keyField(func(key *certspotter.PublicKeyInfo) interface{} { return int64(key.Bits) })},
+	"key.curve":     {typeString, keyField(func(key *certspotter.PublicKeyInfo) interface{} { return key.Curve })},
+	"key.rsaBits": {typeInt, keyField(func(key *certspotter.PublicKeyInfo) interface{} {
+		if key.Algorithm != certspotter.KeyAlgorithmRSA {
+			return int64(0)
+		}
+		return int64(key.Bits)
+	})},
+	"validityDays": {typeInt, certField(func(cert *certspotter.CertInfo) (interface{}, error) {
+		if cert.ValidityParseError != nil {
+			return nil, cert.ValidityParseError
+		}
+		period := cert.Validity.NotAfter.Sub(cert.Validity.NotBefore) + time.Second
+		return int64(period / (24 * time.Hour)), nil
+	})},
+	"isCA": {typeBool, certField(func(cert *certspotter.CertInfo) (interface{}, error) {
+		if cert.IsCAParseError != nil {
+			return nil, cert.IsCAParseError
+		}
+		return cert.IsCA != nil && *cert.IsCA, nil
+	})},
+	"isPrecert": {typeBool, func(info *certspotter.EntryInfo) (interface{}, error) {
+		return info.IsPrecert, nil
+	}},
+	"fingerprint": {typeString, func(info *certspotter.EntryInfo) (interface{}, error) {
+		return info.Fingerprint(), nil
+	}},
+	"log": {typeString, func(info *certspotter.EntryInfo) (interface{}, error) {
+		return info.LogUri, nil
+	}},
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokPunct // operators and punctuation
+)
+
+type token struct {
+	kind  tokenKind
+	text  string // for tokString, the unquoted value
+	pos   int
+	value int64 // for tokInt
+}
+
+func (tok token) String() string {
+	switch tok.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(tok.text)
+	default:
+		return "`" + tok.text + "'"
+	}
+}
+
+// Longest operators first, so that e.g. <= isn't lexed as <
+var punctuation = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", ".", ","}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	pos := 0
+	for {
+		for pos < len(src) && strings.IndexByte(" \t\r\n", src[pos]) != -1 {
+			pos++
+		}
+		if pos == len(src) {
+			return append(tokens, token{kind: tokEOF, pos: pos}), nil
+		}
+		start := pos
+		ch := src[pos]
+		switch {
+		case isIdentStart(ch):
+			for pos < len(src) && (isIdentStart(src[pos]) || isDigit(src[pos])) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:pos], pos: start})
+		case isDigit(ch):
+			for pos < len(src) && isDigit(src[pos]) {
+				pos++
+			}
+			value, err := strconv.ParseInt(src[start:pos], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("at %d: invalid integer %s", start, src[start:pos])
+			}
+			tokens = append(tokens, token{kind: tokInt, text: src[start:pos], pos: start, value: value})
+		case ch == '"':
+			pos++
+			for pos < len(src) && src[pos] != '"' {
+				if src[pos] == '\\' {
+					pos++
+				}
+				pos++
+			}
+			if pos >= len(src) {
+				return nil, fmt.Errorf("at %d: unterminated string", start)
+			}
+			pos++
+			value, err := strconv.Unquote(src[start:pos])
+			if err != nil {
+				return nil, fmt.Errorf("at %d: invalid string %s", start, src[start:pos])
+			}
+			tokens = append(tokens, token{kind: tokString, text: value, pos: start})
+		case ch == '\'':
+			// Single-quoted strings have no escapes, which is convenient for regular expressions
+			end := strings.IndexByte(src[pos+1:], '\'')
+			if end == -1 {
+				return nil, fmt.Errorf("at %d: unterminated string", start)
+			}
+			pos += end + 2
+			tokens = append(tokens, token{kind: tokString, text: src[start+1 : pos-1], pos: start})
+		default:
+			found := false
+			for _, punct := range punctuation {
+				if strings.HasPrefix(src[pos:], punct) {
+					tokens = append(tokens, token{kind: tokPunct, text: punct, pos: start})
+					pos += len(punct)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("at %d: unexpected character %q", start, ch)
+			}
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package expr
+
+import (
+	"fmt"
+	"regexp"
+)
+
+type valueType int
+
+const (
+	typeBool valueType = iota
+	typeInt
+	typeString
+	typeList // of strings
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeBool:
+		return "bool"
+	case typeInt:
+		return "int"
+	case typeString:
+		return "string"
+	case typeList:
+		return "list"
+	}
+	return "unknown"
+}
+
+// Nodes of the syntax tree.  Each node's type is determined when the
+// expression is compiled, so evaluation never encounters a type error.
+type node interface {
+	typ() valueType
+}
+
+type literalNode struct {
+	value interface{} // bool, int64, or string
+	t     valueType
+}
+
+type fieldNode struct {
+	name string
+	def  *fieldDef
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+type binaryNode struct {
+	op   string
+	x, y node
+	t    valueType
+}
+
+type callNode struct {
+	recv   node
+	method string
+	arg    node           // nil for size()
+	re     *regexp.Regexp // for matches()
+}
+
+func (n *literalNode) typ() valueType { return n.t }
+func (n *fieldNode) typ() valueType   { return n.def.t }
+func (n *unaryNode) typ() valueType   { return typeBool }
+func (n *binaryNode) typ() valueType  { return n.t }
+func (n *callNode) typ() valueType {
+	if n.method == "size" {
+		return typeInt
+	}
+	return typeBool
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isPunct(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokPunct && tok.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if tok := p.next(); tok.kind != tokPunct || tok.text != text {
+		return fmt.Errorf("at %d: expected `%s', found %s", tok.pos, text, tok)
+	}
+	return nil
+}
+
+func parse(src string) (node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("at %d: unexpected %s", tok.pos, tok)
+	}
+	if n.typ() != typeBool {
+		return nil, fmt.Errorf("expression is a %s, not a bool", n.typ())
+	}
+	return n, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseLogical("&&", p.parseComparison)
+}
+
+func (p *parser) parseLogical(op string, parseOperand func() (node, error)) (node, error) {
+	x, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct(op) {
+		pos := p.next().pos
+		y, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if x.typ() != typeBool || y.typ() != typeBool {
+			return nil, fmt.Errorf("at %d: operands of %s must be bools, not %s and %s", pos, op, x.typ(), y.typ())
+		}
+		x = &binaryNode{op: op, x: x, y: y, t: typeBool}
+	}
+	return x, nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	var op string
+	if tok.kind == tokPunct && (tok.text == "==" || tok.text == "!=" || tok.text == "<" || tok.text == "<=" || tok.text == ">" || tok.text == ">=") {
+		op = tok.text
+	} else if tok.kind == tokIdent && tok.text == "in" {
+		op = "in"
+	} else {
+		return x, nil
+	}
+	p.next()
+	y, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "==", "!=":
+		if x.typ() != y.typ() || x.typ() == typeList {
+			return nil, fmt.Errorf("at %d: cannot compare %s and %s with %s (use `in' to search lists)", tok.pos, x.typ(), y.typ(), op)
+		}
+	case "in":
+		if x.typ() != typeString || y.typ() != typeList {
+			return nil, fmt.Errorf("at %d: `in' requires a string and a list, not %s and %s", tok.pos, x.typ(), y.typ())
+		}
+	default:
+		if x.typ() != typeInt || y.typ() != typeInt {
+			return nil, fmt.Errorf("at %d: operands of %s must be ints, not %s and %s", tok.pos, op, x.typ(), y.typ())
+		}
+	}
+	return &binaryNode{op: op, x: x, y: y, t: typeBool}, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isPunct("!") {
+		pos := p.next().pos
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if x.typ() != typeBool {
+			return nil, fmt.Errorf("at %d: operand of ! must be a bool, not %s", pos, x.typ())
+		}
+		return &unaryNode{op: "!", x: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	tok := p.next()
+	var x node
+	var path string // of the field being parsed, if x is nil
+	switch tok.kind {
+	case tokInt:
+		x = &literalNode{value: tok.value, t: typeInt}
+	case tokString:
+		x = &literalNode{value: tok.text, t: typeString}
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			x = &literalNode{value: true, t: typeBool}
+		case "false":
+			x = &literalNode{value: false, t: typeBool}
+		default:
+			path = tok.text
+		}
+	case tokPunct:
+		if tok.text == "(" {
+			var err error
+			if x, err = p.parseOr(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+		fallthrough
+	default:
+		return nil, fmt.Errorf("at %d: unexpected %s", tok.pos, tok)
+	}
+
+	for p.isPunct(".") {
+		p.next()
+		name := p.next()
+		if name.kind != tokIdent {
+			return nil, fmt.Errorf("at %d: expected a name after `.', found %s", name.pos, name)
+		}
+		if !p.isPunct("(") {
+			if x != nil {
+				return nil, fmt.Errorf("at %d: unexpected `.%s'", name.pos, name.text)
+			}
+			path += "." + name.text
+			continue
+		}
+		if x == nil {
+			var err error
+			if x, err = lookupField(path, tok.pos); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if x, err = p.parseCall(x, name); err != nil {
+			return nil, err
+		}
+	}
+	if x == nil {
+		return lookupField(path, tok.pos)
+	}
+	return x, nil
+}
+
+func lookupField(path string, pos int) (node, error) {
+	def, ok := fields[path]
+	if !ok {
+		return nil, fmt.Errorf("at %d: unknown field `%s'", pos, path)
+	}
+	return &fieldNode{name: path, def: def}, nil
+}
+
+func (p *parser) parseCall(recv node, name token) (node, error) {
+	p.next() // (
+	call := &callNode{recv: recv, method: name.text}
+	switch name.text {
+	case "size":
+		if recv.typ() != typeList && recv.typ() != typeString {
+			return nil, fmt.Errorf("at %d: size() requires a string or list, not %s", name.pos, recv.typ())
+		}
+		return call, p.expect(")")
+	case "startsWith", "endsWith", "contains", "matches":
+	default:
+		return nil, fmt.Errorf("at %d: unknown method `%s'", name.pos, name.text)
+	}
+	if recv.typ() != typeString && recv.typ() != typeList {
+		return nil, fmt.Errorf("at %d: %s() requires a string or list, not %s", name.pos, name.text, recv.typ())
+	}
+	arg, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if arg.typ() != typeString {
+		return nil, fmt.Errorf("at %d: argument of %s() must be a string, not %s", name.pos, name.text, arg.typ())
+	}
+	call.arg = arg
+	if name.text == "matches" {
+		literal, ok := arg.(*literalNode)
+		if !ok {
+			return nil, fmt.Errorf("at %d: argument of matches() must be a string literal", name.pos)
+		}
+		if call.re, err = regexp.Compile(literal.value.(string)); err != nil {
+			return nil, fmt.Errorf("at %d: invalid regular expression: %s", name.pos, err)
+		}
+	}
+	return call, p.expect(")")
+}
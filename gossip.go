@@ -0,0 +1,51 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/ct/client"
+)
+
+// STHs older than this are not sent to, or accepted from, pollination servers
+const STHFreshness = 14 * 24 * time.Hour
+
+func sthTime(sth *ct.SignedTreeHead) time.Time {
+	return time.Unix(int64(sth.Timestamp/1000), int64(sth.Timestamp%1000)*1000000)
+}
+
+func IsFreshSTH(sth *ct.SignedTreeHead, now time.Time) bool {
+	return now.Sub(sthTime(sth)) < STHFreshness
+}
+
+func freshSTHs(sths []ct.SignedTreeHead, now time.Time) []ct.SignedTreeHead {
+	fresh := make([]ct.SignedTreeHead, 0, len(sths))
+	for i := range sths {
+		if IsFreshSTH(&sths[i], now) {
+			fresh = append(fresh, sths[i])
+		}
+	}
+	return fresh
+}
+
+// Pollinate sends the fresh STHs among |sths| to the STH pollination server
+// at |serverUri| and returns the fresh STHs sent back by the server.  The
+// returned STHs have NOT been verified; the caller must check that each one
+// belongs to a known log and is correctly signed before trusting it.
+func Pollinate(serverUri string, sths []ct.SignedTreeHead) ([]ct.SignedTreeHead, error) {
+	now := time.Now()
+	resp, err := client.New(serverUri).PostSTHPollination(&ct.STHPollination{STHs: freshSTHs(sths, now)})
+	if err != nil {
+		return nil, err
+	}
+	return freshSTHs(resp.STHs, now), nil
+}
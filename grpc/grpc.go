@@ -0,0 +1,263 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package grpc is a minimal gRPC server, supporting only what's needed to
+// serve certspotter's API: unary and server-streaming RPCs over HTTP/2
+// without TLS ("h2c", as used by gRPC clients with insecure credentials),
+// without compression.  Handlers receive and return encoded messages; see
+// proto.go for encoding and decoding them.
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status codes (see https://grpc.github.io/grpc/core/md_doc_statuscodes.html)
+type Code int
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	Unauthenticated    Code = 16
+)
+
+// The largest request message accepted, as in gRPC's implementations
+const MaxRequestSize = 4 * 1024 * 1024
+
+// Status is an error which is returned to the client with its Code, instead
+// of Unknown
+type Status struct {
+	Code    Code
+	Message string
+}
+
+func (status *Status) Error() string {
+	return fmt.Sprintf("rpc error: code = %d desc = %s", status.Code, status.Message)
+}
+
+// Errorf returns a Status with the given code and formatted message
+func Errorf(code Code, format string, args ...interface{}) error {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// A Stream sends the response messages of an RPC
+type Stream struct {
+	w          http.ResponseWriter
+	controller *http.ResponseController
+}
+
+// Send sends |message| to the client straight away
+func (stream *Stream) Send(message []byte) error {
+	var header [5]byte // uncompressed, followed by the length
+	binary.BigEndian.PutUint32(header[1:], uint32(len(message)))
+	if _, err := stream.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := stream.w.Write(message); err != nil {
+		return err
+	}
+	return stream.controller.Flush()
+}
+
+// A StreamHandler handles a server-streaming RPC, sending its responses to
+// |stream|.  |ctx| is canceled if the client goes away or its deadline
+// passes.
+type StreamHandler func(ctx context.Context, request []byte, stream *Stream) error
+
+// A UnaryHandler handles an RPC with a single response
+type UnaryHandler func(ctx context.Context, request []byte) ([]byte, error)
+
+// Server dispatches RPCs to the handlers registered for their methods.
+// Handlers must be registered before the server is started.
+type Server struct {
+	Token string // if set, clients must send it as a bearer token
+
+	handlers map[string]StreamHandler // by path, e.g. /package.Service/Method
+}
+
+func NewServer() *Server {
+	return &Server{handlers: make(map[string]StreamHandler)}
+}
+
+// HandleStream registers |handler| for the server-streaming RPC |method| of
+// |service| (including its package, e.g. certspotter.CertSpotter)
+func (server *Server) HandleStream(service string, method string, handler StreamHandler) {
+	server.handlers["/"+service+"/"+method] = handler
+}
+
+// HandleUnary registers |handler| for the unary RPC |method| of |service|
+func (server *Server) HandleUnary(service string, method string, handler UnaryHandler) {
+	server.HandleStream(service, method, func(ctx context.Context, request []byte, stream *Stream) error {
+		response, err := handler(ctx, request)
+		if err != nil {
+			return err
+		}
+		return stream.Send(response)
+	})
+}
+
+// Serve accepts connections on |listener| until it's closed, and serves
+// RPCs on them
+func (server *Server) Serve(listener net.Listener) error {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	httpServer := &http.Server{Handler: server, Protocols: &protocols}
+	return httpServer.Serve(listener)
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "gRPC requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "Content-Type must be application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	if req.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	ctx := req.Context()
+	if timeout, ok := parseTimeout(req.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	err := server.handle(ctx, req, &Stream{w: w, controller: http.NewResponseController(w)})
+	status := toStatus(ctx, err)
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(status.Code)))
+	if status.Message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeMessage(status.Message))
+	}
+}
+
+func (server *Server) handle(ctx context.Context, req *http.Request, stream *Stream) error {
+	if !HasBearerToken(req, server.Token) {
+		return Errorf(Unauthenticated, "missing or incorrect bearer token")
+	}
+	handler, exists := server.handlers[req.URL.Path]
+	if !exists {
+		return Errorf(Unimplemented, "unknown method %s", req.URL.Path)
+	}
+	request, err := readMessage(req.Body)
+	if err != nil {
+		return err
+	}
+	return handler(ctx, request, stream)
+}
+
+// HasBearerToken returns true if |token| is empty, or |req| has an
+// Authorization header containing it as a bearer token
+func HasBearerToken(req *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+// readMessage reads the single request message of a unary or
+// server-streaming RPC
+func readMessage(body io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(body, header[:]); err != nil {
+		return nil, Errorf(Internal, "error reading request: %s", err)
+	}
+	if header[0] != 0 {
+		return nil, Errorf(Unimplemented, "compressed messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > MaxRequestSize {
+		return nil, Errorf(ResourceExhausted, "request is larger than %d bytes", MaxRequestSize)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, Errorf(Internal, "error reading request: %s", err)
+	}
+	return message, nil
+}
+
+// toStatus returns the status to send to the client for a handler's error
+func toStatus(ctx context.Context, err error) *Status {
+	var status *Status
+	switch {
+	case err == nil:
+		return &Status{Code: OK}
+	case errors.As(err, &status):
+		return status
+	case ctx.Err() == context.DeadlineExceeded:
+		return &Status{Code: DeadlineExceeded, Message: err.Error()}
+	case ctx.Err() != nil:
+		return &Status{Code: Canceled, Message: err.Error()}
+	default:
+		return &Status{Code: Unknown, Message: err.Error()}
+	}
+}
+
+// parseTimeout parses the value of the grpc-timeout header, e.g. 100m
+func parseTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// encodeMessage percent-encodes a status message for the grpc-message
+// trailer, as the protocol requires
+func encodeMessage(message string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		} else {
+			encoded.WriteByte(c)
+		}
+	}
+	return encoded.String()
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func callRPC(t *testing.T, addr string, path string, request []byte) ([][]byte, http.Header) {
+	return callRPCWithToken(t, addr, path, request, "")
+}
+
+func callRPCWithToken(t *testing.T, addr string, path string, request []byte, token string) ([][]byte, http.Header) {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+
+	var body bytes.Buffer
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[1:], uint32(len(request)))
+	body.Write(header[:])
+	body.Write(request)
+	req, _ := http.NewRequest("POST", "http://"+addr+path, &body)
+	req.Header.Set("Content-Type", "application/grpc")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Response was HTTP/%d", resp.ProtoMajor)
+	}
+	var messages [][]byte
+	for {
+		if _, err := io.ReadFull(resp.Body, header[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		message := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(resp.Body, message); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, resp.Trailer
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer()
+	server.HandleStream("test.Test", "Count", func(ctx context.Context, request []byte, stream *Stream) error {
+		fields, err := ParseMessage(request)
+		if err != nil {
+			return Errorf(InvalidArgument, "%s", err)
+		}
+		for i := int64(1); i <= fields.Int64(1); i++ {
+			var m Message
+			m.Int64(1, i)
+			m.String(2, fields.String(2))
+			if err := stream.Send(m.Encoded()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	server.HandleUnary("test.Test", "Fail", func(ctx context.Context, request []byte) ([]byte, error) {
+		return nil, &Status{Code: NotFound, Message: "no such thing: 100%"}
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.Serve(listener)
+	addr := listener.Addr().String()
+
+	var request Message
+	request.Int64(1, 3)
+	request.String(2, "hello")
+	messages, trailer := callRPC(t, addr, "/test.Test/Count", request.Encoded())
+	if trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("Wrong status: %v", trailer)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("Got %d messages instead of 3", len(messages))
+	}
+	for i, message := range messages {
+		fields, err := ParseMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields.Int64(1) != int64(i+1) || fields.String(2) != "hello" {
+			t.Errorf("Wrong message %d: %x", i, message)
+		}
+	}
+
+	messages, trailer = callRPC(t, addr, "/test.Test/Fail", nil)
+	if len(messages) != 0 || trailer.Get("Grpc-Status") != "5" || trailer.Get("Grpc-Message") != "no such thing: 100%25" {
+		t.Errorf("Wrong response: %d messages, trailer %v", len(messages), trailer)
+	}
+
+	if _, trailer := callRPC(t, addr, "/test.Test/Nope", nil); trailer.Get("Grpc-Status") != "12" {
+		t.Errorf("Wrong status for unknown method: %v", trailer)
+	}
+}
+
+func TestServerToken(t *testing.T) {
+	server := NewServer()
+	server.Token = "s3cret"
+	server.HandleUnary("test.Test", "Echo", func(ctx context.Context, request []byte) ([]byte, error) {
+		return request, nil
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.Serve(listener)
+	addr := listener.Addr().String()
+
+	for _, token := range []string{"", "wrong", "s3cret "} {
+		if messages, trailer := callRPCWithToken(t, addr, "/test.Test/Echo", []byte("hi"), token); len(messages) != 0 || trailer.Get("Grpc-Status") != "16" {
+			t.Errorf("Token %q: wrong response: %d messages, trailer %v", token, len(messages), trailer)
+		}
+	}
+	if messages, trailer := callRPCWithToken(t, addr, "/test.Test/Echo", []byte("hi"), "s3cret"); len(messages) != 1 || string(messages[0]) != "hi" || trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("Correct token: wrong response: %q, trailer %v", messages, trailer)
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	var sub Message
+	sub.String(1, "inner")
+	var m Message
+	m.Bool(1, true)
+	m.RepeatedBytes(2, []byte("a"))
+	m.RepeatedBytes(2, []byte(""))
+	m.Message(3, &sub)
+	m.OptionalInt64(4, -1)
+	fields, err := ParseMessage(append(m.Encoded(), 0x2d, 1, 2, 3, 4)) // field 5, fixed32
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fields.Bool(1) || fields.Int64(4) != -1 {
+		t.Errorf("Wrong varints: %v", fields.varints)
+	}
+	if strings := fields.Strings(2); len(strings) != 2 || strings[0] != "a" || strings[1] != "" {
+		t.Errorf("Wrong repeated string: %q", strings)
+	}
+	if inner, err := ParseMessage([]byte(fields.String(3))); err != nil || inner.String(1) != "inner" {
+		t.Errorf("Wrong submessage: %v", err)
+	}
+	if _, err := ParseMessage([]byte{0x12, 5, 'a'}); err == nil {
+		t.Errorf("Truncated message was parsed")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package grpc
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Protocol buffer wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Message encodes a protocol buffer message.  Fields with default values
+// (0, false, "") are omitted, as in proto3, except by the Optional* methods.
+type Message struct {
+	buf []byte
+}
+
+func (m *Message) tag(field int, wireType int) {
+	m.buf = binary.AppendUvarint(m.buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (m *Message) OptionalInt64(field int, value int64) {
+	m.tag(field, wireVarint)
+	m.buf = binary.AppendUvarint(m.buf, uint64(value))
+}
+
+func (m *Message) Int64(field int, value int64) {
+	if value != 0 {
+		m.OptionalInt64(field, value)
+	}
+}
+
+func (m *Message) Bool(field int, value bool) {
+	if value {
+		m.OptionalInt64(field, 1)
+	}
+}
+
+// RepeatedBytes adds |value| to a repeated bytes, string, or message field;
+// unlike Bytes, the value is added even if it's empty
+func (m *Message) RepeatedBytes(field int, value []byte) {
+	m.tag(field, wireBytes)
+	m.buf = binary.AppendUvarint(m.buf, uint64(len(value)))
+	m.buf = append(m.buf, value...)
+}
+
+func (m *Message) Bytes(field int, value []byte) {
+	if len(value) != 0 {
+		m.RepeatedBytes(field, value)
+	}
+}
+
+func (m *Message) String(field int, value string) {
+	m.Bytes(field, []byte(value))
+}
+
+// Message adds the encoding of |sub| to a message field
+func (m *Message) Message(field int, sub *Message) {
+	m.RepeatedBytes(field, sub.buf)
+}
+
+// Encoded returns the encoded message
+func (m *Message) Encoded() []byte {
+	return m.buf
+}
+
+// Fields are the fields of a decoded protocol buffer message.  Varint and
+// length-delimited fields are kept; fixed-size fields are skipped.
+type Fields struct {
+	varints map[int][]uint64
+	bytes   map[int][][]byte
+}
+
+// ParseMessage decodes the fields of |data|
+func ParseMessage(data []byte) (*Fields, error) {
+	fields := &Fields{varints: make(map[int][]uint64), bytes: make(map[int][][]byte)}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed field tag")
+		}
+		data = data[n:]
+		field, wireType := int(key>>3), int(key&7)
+		switch wireType {
+		case wireVarint:
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("malformed varint in field %d", field)
+			}
+			fields.varints[field] = append(fields.varints[field], value)
+			data = data[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return nil, fmt.Errorf("malformed length-delimited field %d", field)
+			}
+			data = data[n:]
+			fields.bytes[field] = append(fields.bytes[field], data[:length])
+			data = data[length:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wireType == wireFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return nil, fmt.Errorf("truncated field %d", field)
+			}
+			data = data[size:]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d in field %d", wireType, field)
+		}
+	}
+	return fields, nil
+}
+
+// Int64 returns the last value of a varint field, or 0 if it's absent
+func (fields *Fields) Int64(field int) int64 {
+	values := fields.varints[field]
+	if len(values) == 0 {
+		return 0
+	}
+	return int64(values[len(values)-1])
+}
+
+func (fields *Fields) Bool(field int) bool {
+	return fields.Int64(field) != 0
+}
+
+// String returns the last value of a string field, or "" if it's absent
+func (fields *Fields) String(field int) string {
+	values := fields.bytes[field]
+	if len(values) == 0 {
+		return ""
+	}
+	return string(values[len(values)-1])
+}
+
+// Strings returns the values of a repeated string field
+func (fields *Fields) Strings(field int) []string {
+	var values []string
+	for _, value := range fields.bytes[field] {
+		values = append(values, string(value))
+	}
+	return values
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Kinds of HashedWatchlist item, identified by the prefix of the hash
+const (
+	hashedExact     = ""  // HASH matches the domain only
+	hashedSubtree   = "." // .HASH matches the domain and all of its subdomains
+	hashedWildcards = "*" // *HASH matches wildcard and redacted names under the domain
+)
+
+type hashedItem struct {
+	ID      string
+	Pattern string
+}
+
+// A HashedWatchlist is a watchlist of domains which are known only by their
+// salted hashes (see HashDomain), so that a monitoring service can watch
+// domains on behalf of others without learning what they are.  A DNS name
+// is matched by hashing each of its parent domains and looking them up.
+//
+// The file format is a line "salt HEX", followed by one item per line, each
+// optionally followed by whitespace and an ID:
+//
+//	HASH		matches the domain only
+//	.HASH		matches the domain and all of its subdomains
+//	*HASH		matches wildcard and redacted names one level under the
+//			domain (e.g. *.example.com), which may be for a name
+//			watched by an exact item
+//
+// Use the hashwatchlist command to convert a watchlist to this format.
+type HashedWatchlist struct {
+	Salt  []byte
+	items map[string]*hashedItem // kind + hash => item
+}
+
+// HashDomain returns the hex-encoded HMAC-SHA256 of |domain|, which must be
+// normalized (see NormalizeDNSName), keyed by |salt|
+func HashDomain(salt []byte, domain string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(domain))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func NewHashedWatchlist(salt []byte) *HashedWatchlist {
+	return &HashedWatchlist{Salt: salt, items: make(map[string]*hashedItem)}
+}
+
+// Add adds a hashed item (see HashedWatchlist) to the watchlist
+func (watchlist *HashedWatchlist) Add(pattern string, id string) error {
+	kind, hash := hashedExact, strings.ToLower(pattern)
+	if strings.HasPrefix(hash, hashedSubtree) || strings.HasPrefix(hash, hashedWildcards) {
+		kind, hash = hash[:1], hash[1:]
+	}
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("Invalid hashed watchlist item `%s': must be 64 hex digits, optionally prefixed with . or *", pattern)
+	}
+	if id == "" {
+		id = pattern
+	}
+	watchlist.items[kind+hash] = &hashedItem{ID: id, Pattern: pattern}
+	return nil
+}
+
+// ReadHashedWatchlist reads a hashed watchlist.  Empty lines and lines
+// starting with # are ignored.
+func ReadHashedWatchlist(reader io.Reader) (*HashedWatchlist, error) {
+	var watchlist *HashedWatchlist
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if watchlist == nil {
+			if len(fields) != 2 || fields[0] != "salt" {
+				return nil, fmt.Errorf("Hashed watchlist must start with `salt HEX'")
+			}
+			salt, err := hex.DecodeString(fields[1])
+			if err != nil || len(salt) == 0 {
+				return nil, fmt.Errorf("Invalid salt `%s'", fields[1])
+			}
+			watchlist = NewHashedWatchlist(salt)
+			continue
+		}
+		var id string
+		switch len(fields) {
+		case 1:
+		case 2:
+			id = fields[1]
+		default:
+			return nil, fmt.Errorf("Invalid hashed watchlist item `%s': too many fields", line)
+		}
+		if err := watchlist.Add(fields[0], id); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if watchlist == nil {
+		return nil, fmt.Errorf("Hashed watchlist has no salt")
+	}
+	return watchlist, nil
+}
+
+// LoadHashedWatchlist reads a hashed watchlist from the file named by |filename|
+func LoadHashedWatchlist(filename string) (*HashedWatchlist, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	watchlist, err := ReadHashedWatchlist(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return watchlist, nil
+}
+
+func (watchlist *HashedWatchlist) lookup(kind string, domain string) *hashedItem {
+	return watchlist.items[kind+HashDomain(watchlist.Salt, domain)]
+}
+
+// MatchDNSName returns the ID and pattern of the first item that matches
+// |dnsName|, checking the most specific domain first, or "" if none do
+func (watchlist *HashedWatchlist) MatchDNSName(dnsName string) (id string, pattern string) {
+	labels := strings.Split(normalizeDomainPart(dnsName), ".")
+	if item := watchlist.lookup(hashedExact, strings.Join(labels, ".")); item != nil {
+		return item.ID, item.Pattern
+	}
+	if first := labels[0]; len(labels) > 1 && (first == "*" || first == "?" || first == UnparsableDNSLabelPlaceholder) {
+		// A wildcard, redacted, or unparsable label might stand for a
+		// watched name
+		if item := watchlist.lookup(hashedWildcards, strings.Join(labels[1:], ".")); item != nil {
+			return item.ID, item.Pattern
+		}
+	}
+	for i := range labels {
+		if item := watchlist.lookup(hashedSubtree, strings.Join(labels[i:], ".")); item != nil {
+			return item.ID, item.Pattern
+		}
+	}
+	return "", ""
+}
+
+func (watchlist *HashedWatchlist) Match(info *EntryInfo) []Match {
+	if info.Identifiers == nil {
+		return []Match{{Category: MatchUnparsable}}
+	}
+	var matches []Match
+	for _, dnsName := range info.Identifiers.DNSNames {
+		if id, pattern := watchlist.MatchDNSName(dnsName); id != "" {
+			matches = append(matches, Match{Category: MatchDNSName, ID: id, Pattern: pattern, Value: dnsName, Anomalies: info.Identifiers.DNSNameAnomalies[dnsName]})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashedWatchlist(t *testing.T) {
+	salt := []byte("salt")
+	list := "salt 73616c74\n" +
+		"." + HashDomain(salt, "example.com") + " tree\n" +
+		HashDomain(salt, "www.example.org") + " exact\n" +
+		"*" + HashDomain(salt, "example.org") + " exact\n"
+	watchlist, err := ReadHashedWatchlist(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"example.com":         "tree",
+		"a.b.EXAMPLE.com.":    "tree",
+		"*.example.com":       "tree",
+		"notexample.com":      "",
+		"www.example.org":     "exact",
+		"*.example.org":       "exact",
+		"?.example.org":       "exact",
+		"a.www.example.org":   "",
+		"example.org":         "",
+		"www.example.org.com": "",
+	}
+	for dnsName, expected := range tests {
+		if id, _ := watchlist.MatchDNSName(dnsName); id != expected {
+			t.Errorf("MatchDNSName(%q) = %q, expected %q", dnsName, id, expected)
+		}
+	}
+}
@@ -12,8 +12,10 @@ package certspotter
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -25,6 +27,7 @@ import (
 	"time"
 
 	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/tracing"
 )
 
 func ReadSTHFile(path string) (*ct.SignedTreeHead, error) {
@@ -106,6 +109,13 @@ type EntryInfo struct {
 	Identifiers           *Identifiers
 	IdentifiersParseError error
 	Filename              string
+	SeenInLogs            []string          // set by Deduplicator
+	Matches               []Match           // set by MatchingCallback
+	Violations            []string          // Baseline Requirements violations (see BRViolations)
+	Revocation            *RevocationStatus // set by OCSPChecker
+	ChainValidation       *ChainValidation  // set by ChainValidator
+	DNSStatuses           []DNSStatus       // set by DNSProber
+	Span                  *tracing.Span     // if the entry's processing is being traced
 }
 
 type CertInfo struct {
@@ -123,6 +133,11 @@ type CertInfo struct {
 	ValidityParseError     error
 	IsCA                   *bool
 	IsCAParseError         error
+	PublicKey              *PublicKeyInfo
+	PublicKeyParseError    error
+
+	// Structural problems tolerated by MakeCertInfoFromLogEntryLenient
+	StructureErrors []error
 }
 
 func MakeCertInfoFromTBS(tbs *TBSCertificate) *CertInfo {
@@ -134,6 +149,7 @@ func MakeCertInfoFromTBS(tbs *TBSCertificate) *CertInfo {
 	info.SerialNumber, info.SerialNumberParseError = tbs.ParseSerialNumber()
 	info.Validity, info.ValidityParseError = tbs.ParseValidity()
 	info.IsCA, info.IsCAParseError = tbs.ParseBasicConstraints()
+	info.PublicKey, info.PublicKeyParseError = tbs.ParsePublicKey()
 
 	return info
 }
@@ -223,6 +239,14 @@ func (info *CertInfo) Environ() []string {
 		env = append(env, "ISSUER_DN="+info.Issuer.String())
 	}
 
+	if len(info.StructureErrors) != 0 {
+		messages := make([]string, len(info.StructureErrors))
+		for i, err := range info.StructureErrors {
+			messages[i] = err.Error()
+		}
+		env = append(env, "STRUCTURE_ERRORS="+strings.Join(messages, "; "))
+	}
+
 	// TODO: include SANs in environment
 
 	return env
@@ -236,7 +260,8 @@ func (info *EntryInfo) HasParseErrors() bool {
 		info.CertInfo.SANsParseError != nil ||
 		info.CertInfo.SerialNumberParseError != nil ||
 		info.CertInfo.ValidityParseError != nil ||
-		info.CertInfo.IsCAParseError != nil
+		info.CertInfo.IsCAParseError != nil ||
+		len(info.CertInfo.StructureErrors) != 0
 }
 
 func (info *EntryInfo) Fingerprint() string {
@@ -255,6 +280,14 @@ func (info *EntryInfo) FingerprintBytes() []byte {
 	}
 }
 
+func (info *EntryInfo) LeafHash() []byte {
+	if info.Entry.LeafHash != nil {
+		return info.Entry.LeafHash
+	} else {
+		return hashLeaf(info.Entry.LeafBytes)
+	}
+}
+
 func (info *EntryInfo) typeString() string {
 	if info.IsPrecert {
 		return "precert"
@@ -266,6 +299,8 @@ func (info *EntryInfo) typeString() string {
 func (info *EntryInfo) typeFriendlyString() string {
 	if info.IsPrecert {
 		return "Pre-certificate"
+	} else if info.CertInfo != nil && info.CertInfo.HasPoison() {
+		return "Pre-certificate logged as certificate"
 	} else {
 		return "Certificate"
 	}
@@ -286,11 +321,55 @@ func (info *EntryInfo) Environ() []string {
 		"CERT_PARSEABLE=" + yesnoString(info.ParseError == nil),
 		"LOG_URI=" + info.LogUri,
 		"ENTRY_INDEX=" + strconv.FormatInt(info.Entry.Index, 10),
+		"LEAF_HASH=" + base64.StdEncoding.EncodeToString(info.LeafHash()),
 	}
 
 	if info.Filename != "" {
 		env = append(env, "CERT_FILENAME="+info.Filename)
 	}
+	if len(info.SeenInLogs) != 0 {
+		env = append(env, "SEEN_IN_LOGS="+strings.Join(info.SeenInLogs, " "))
+	}
+	if matchIDs := info.MatchIDs(); len(matchIDs) != 0 {
+		env = append(env, "MATCH_IDS="+strings.Join(matchIDs, ","))
+	}
+	if len(info.Violations) != 0 {
+		env = append(env, "BR_VIOLATIONS="+strings.Join(info.Violations, "; "))
+	}
+	if status := info.Revocation; status != nil {
+		env = append(env, "OCSP_STATUS="+status.Status)
+		if status.RevokedAt != nil {
+			env = append(env, "REVOCATION_TIME="+status.RevokedAt.String())
+			env = append(env, "REVOCATION_UNIXTIME="+strconv.FormatInt(status.RevokedAt.Unix(), 10))
+		}
+		if status.Reason != "" {
+			env = append(env, "REVOCATION_REASON="+status.Reason)
+		}
+		if status.Error != "" {
+			env = append(env, "OCSP_ERROR="+status.Error)
+		}
+	}
+	if validation := info.ChainValidation; validation != nil {
+		env = append(env, "CHAIN_TRUSTED="+yesnoString(validation.Trusted()))
+		env = append(env, "TRUSTED_BY="+strings.Join(validation.TrustedBy, ","))
+		if validation.Root != "" {
+			env = append(env, "CHAIN_ROOT="+validation.Root)
+		}
+		if validation.Error != "" {
+			env = append(env, "CHAIN_ERROR="+validation.Error)
+		}
+	}
+	if len(info.DNSStatuses) != 0 {
+		var existing, addresses []string
+		for _, status := range info.DNSStatuses {
+			if status.Exists {
+				existing = append(existing, status.Name)
+				addresses = append(addresses, status.Addresses...)
+			}
+		}
+		env = append(env, "EXISTING_DNS_NAMES="+strings.Join(existing, ","))
+		env = append(env, "RESOLVED_ADDRESSES="+strings.Join(addresses, ","))
+	}
 	if info.ParseError != nil {
 		env = append(env, "PARSE_ERROR="+info.ParseError.Error())
 	} else if info.CertInfo != nil {
@@ -302,6 +381,8 @@ func (info *EntryInfo) Environ() []string {
 	} else if info.Identifiers != nil {
 		env = append(env, "DNS_NAMES="+info.Identifiers.dnsNamesString(","))
 		env = append(env, "IP_ADDRESSES="+info.Identifiers.ipAddrsString(","))
+		env = append(env, "EMAIL_ADDRESSES="+strings.Join(info.Identifiers.EmailAddrs, ","))
+		env = append(env, "URIS="+strings.Join(info.Identifiers.URIs, " "))
 	}
 
 	return env
@@ -322,11 +403,28 @@ func (info *EntryInfo) Write(out io.Writer) {
 		writeField(out, "Identifiers", nil, info.IdentifiersParseError)
 	} else if info.Identifiers != nil {
 		for _, dnsName := range info.Identifiers.DNSNames {
-			writeField(out, "DNS Name", dnsName, nil)
+			if anomalies := info.Identifiers.DNSNameAnomalies[dnsName]; len(anomalies) != 0 {
+				writeField(out, "DNS Name", dnsName+" ("+strings.Join(anomalies, ", ")+")", nil)
+			} else {
+				writeField(out, "DNS Name", dnsName, nil)
+			}
 		}
 		for _, ipaddr := range info.Identifiers.IPAddrs {
 			writeField(out, "IP Address", ipaddr, nil)
 		}
+		for _, address := range info.Identifiers.EmailAddrs {
+			writeField(out, "Email Address", address, nil)
+		}
+		for _, uri := range info.Identifiers.URIs {
+			writeField(out, "URI", uri, nil)
+		}
+	}
+	for _, match := range info.Matches {
+		if match.ID != "" && match.Score != 0 {
+			writeField(out, "Matched", fmt.Sprintf("%s (%s, score %.2f)", match.ID, match.Value, match.Score), nil)
+		} else if match.ID != "" {
+			writeField(out, "Matched", fmt.Sprintf("%s (%s)", match.ID, match.Value), nil)
+		}
 	}
 	if info.ParseError != nil {
 		writeField(out, "Parse Error", "*** "+info.ParseError.Error()+" ***", nil)
@@ -335,8 +433,31 @@ func (info *EntryInfo) Write(out io.Writer) {
 		writeField(out, "Issuer", info.CertInfo.Issuer, info.CertInfo.IssuerParseError)
 		writeField(out, "Not Before", info.CertInfo.NotBefore(), info.CertInfo.ValidityParseError)
 		writeField(out, "Not After", info.CertInfo.NotAfter(), info.CertInfo.ValidityParseError)
+		for _, err := range info.CertInfo.StructureErrors {
+			writeField(out, "Malformed", err, nil)
+		}
+	}
+	for _, violation := range info.Violations {
+		writeField(out, "BR Violation", violation, nil)
+	}
+	if status := info.Revocation; status != nil && status.Status == OCSPError {
+		writeField(out, "OCSP Status", nil, errors.New(status.Error))
+	} else if status != nil {
+		writeField(out, "OCSP Status", status, nil)
+	}
+	if info.ChainValidation != nil {
+		writeField(out, "Trusted By", info.ChainValidation, nil)
+	}
+	for _, status := range info.DNSStatuses {
+		writeField(out, "DNS Lookup", status, nil)
 	}
 	writeField(out, "Log Entry", fmt.Sprintf("%d @ %s (%s)", info.Entry.Index, info.LogUri, info.typeFriendlyString()), nil)
+	for _, logUri := range info.SeenInLogs {
+		if logUri != info.LogUri {
+			writeField(out, "Also Seen In", logUri, nil)
+		}
+	}
+	writeField(out, "Leaf Hash", base64.StdEncoding.EncodeToString(info.LeafHash()), nil)
 	writeField(out, "crt.sh", "https://crt.sh/?sha256="+fingerprint, nil)
 	if info.Filename != "" {
 		writeField(out, "Filename", info.Filename, nil)
@@ -10,9 +10,89 @@
 package certspotter
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"testing"
+	"time"
 )
 
+// makeTestCert returns a certificate and its key.  The certificate is made
+// from a template for www.example.com, valid from 2024-01-01 to 2024-04-01,
+// after |modify| (if not nil) has changed it, and is signed by |issuer| with
+// |issuerKey|, or self-signed if |issuer| is nil.
+func makeTestCert(t *testing.T, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey, modify func(*x509.Certificate)) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1234),
+		Subject:               pkix.Name{CommonName: "www.example.com"},
+		NotBefore:             time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:              []string{"www.example.com"},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if modify != nil {
+		modify(template)
+	}
+	if issuer == nil {
+		issuer, issuerKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// makeTestCA is like makeTestCert, but the template is for a CA named
+// "Test CA", valid from 2024-01-01 to 2034-01-01
+func makeTestCA(t *testing.T, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey, modify func(*x509.Certificate)) (*x509.Certificate, *ecdsa.PrivateKey) {
+	return makeTestCert(t, issuer, issuerKey, func(template *x509.Certificate) {
+		template.SerialNumber = big.NewInt(1)
+		template.Subject = pkix.Name{CommonName: "Test CA"}
+		template.NotAfter = time.Date(2034, 1, 1, 0, 0, 0, 0, time.UTC)
+		template.DNSNames = nil
+		template.ExtKeyUsage = nil
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		if modify != nil {
+			modify(template)
+		}
+	})
+}
+
+// makeTestCertInfo returns the CertInfo of a certificate from makeTestCert
+func makeTestCertInfo(t *testing.T, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey, modify func(*x509.Certificate)) *CertInfo {
+	cert, _ := makeTestCert(t, issuer, issuerKey, modify)
+	info, err := MakeCertInfoFromRawCert(cert.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// makeTestChainEntry returns an entry for a certificate from makeTestCert
+// issued by |issuer|, whose chain is the certificate and |issuer|
+func makeTestChainEntry(t *testing.T, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey, modify func(*x509.Certificate)) *EntryInfo {
+	cert, _ := makeTestCert(t, issuer, issuerKey, modify)
+	info, err := MakeCertInfoFromRawCert(cert.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &EntryInfo{CertInfo: info, FullChain: [][]byte{cert.Raw, issuer.Raw}}
+}
+
 func doWildcardTest(t *testing.T, dnsName string, wildcard string, expected bool) {
 	if MatchesWildcard(dnsName, wildcard) != expected {
 		t.Errorf("MatchesWildcard(%q, %q) != %v", dnsName, wildcard, expected)
@@ -19,6 +19,19 @@ import (
 
 const UnparsableDNSLabelPlaceholder = "<unparsable>"
 
+// Anomalies of DNS names, which are reported alongside them so that
+// consumers can tell hostile or malformed names from ordinary ones
+const (
+	DNSNameRedacted         = "redacted"           // has a redacted (?) label
+	DNSNameWildcard         = "wildcard"           // has a * label
+	DNSNameUnparsableLabel  = "unparsable_label"   // has a label that was replaced with UnparsableDNSLabelPlaceholder
+	DNSNameEmptyLabel       = "empty_label"        // has an empty label (e.g. www..example.com)
+	DNSNameNonASCII         = "non_ascii"          // encoded with non-ASCII characters, which aren't allowed
+	DNSNameURLPrefix        = "url_prefix"         // had an http:// or https:// prefix, which was removed
+	DNSNameTruncatedAtSlash = "truncated_at_slash" // the part of a value before a slash
+	DNSNameTruncatedAtNull  = "truncated_at_null"  // the part of a value before a null byte
+)
+
 /*
 const (
 	IdentifierSourceSubjectCN = iota
@@ -34,15 +47,22 @@ type UnknownIdentifier struct {
 */
 
 type Identifiers struct {
-	DNSNames []string // stored as ASCII, with IDNs in Punycode
-	IPAddrs  []net.IP
+	DNSNames   []string // stored as ASCII, with IDNs in Punycode
+	IPAddrs    []net.IP
+	EmailAddrs []string // rfc822Name SANs, with the domain normalized like DNSNames
+	URIs       []string
+	// DNSNameAnomalies[name] lists the anomalies (DNSName* constants) of
+	// the DNS names which have any
+	DNSNameAnomalies map[string][]string
 	//Unknowns		[]UnknownIdentifier
 }
 
 func NewIdentifiers() *Identifiers {
 	return &Identifiers{
-		DNSNames: []string{},
-		IPAddrs:  []net.IP{},
+		DNSNames:   []string{},
+		IPAddrs:    []net.IP{},
+		EmailAddrs: []string{},
+		URIs:       []string{},
 		//Unknowns:	[]UnknownIdentifier{},
 	}
 }
@@ -142,10 +162,60 @@ func sanitizeUnicodeDNSName(value string) string {
 	return strings.Join(labels, ".")
 }
 
-func (ids *Identifiers) appendDNSName(dnsName string) {
-	if dnsName != "" && !ids.hasDNSName(dnsName) {
+// IDNA (UTS #46) treats these as equivalent to the full stop
+var dnsLabelSeparators = strings.NewReplacer("\u3002", ".", "\uff0e", ".", "\uff61", ".")
+
+// NormalizeDNSName converts a DNS name to the form used for matching:
+// alternative label separators are replaced with dots, trailing dots are
+// removed, and each label is case-folded and converted to ASCII (Punycode).
+// Labels containing wildcards are case-folded only.
+func NormalizeDNSName(value string) (string, error) {
+	value = strings.ToLower(trimTrailingDots(dnsLabelSeparators.Replace(strings.TrimSpace(value))))
+	labels := strings.Split(value, ".")
+	for i, label := range labels {
+		if strings.Contains(label, "*") {
+			continue
+		}
+		asciiLabel, err := idna.ToASCII(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = strings.ToLower(asciiLabel)
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// labelAnomalies returns the anomalies of |dnsName| evident from its labels
+func labelAnomalies(dnsName string) []string {
+	var anomalies []string
+	for _, label := range strings.Split(dnsName, ".") {
+		switch {
+		case label == "?":
+			anomalies = appendUniqueString(anomalies, DNSNameRedacted)
+		case label == "*":
+			anomalies = appendUniqueString(anomalies, DNSNameWildcard)
+		case label == UnparsableDNSLabelPlaceholder:
+			anomalies = appendUniqueString(anomalies, DNSNameUnparsableLabel)
+		case label == "":
+			anomalies = appendUniqueString(anomalies, DNSNameEmptyLabel)
+		}
+	}
+	return anomalies
+}
+
+func (ids *Identifiers) appendDNSName(dnsName string, anomalies []string) {
+	if dnsName == "" {
+		return
+	}
+	if !ids.hasDNSName(dnsName) {
 		ids.DNSNames = append(ids.DNSNames, dnsName)
 	}
+	for _, anomaly := range append(labelAnomalies(dnsName), anomalies...) {
+		if ids.DNSNameAnomalies == nil {
+			ids.DNSNameAnomalies = make(map[string][]string)
+		}
+		ids.DNSNameAnomalies[dnsName] = appendUniqueString(ids.DNSNameAnomalies[dnsName], anomaly)
+	}
 }
 func (ids *Identifiers) appendIPAddress(ipaddr net.IP) {
 	if !ids.hasIPAddress(ipaddr) {
@@ -153,6 +223,18 @@ func (ids *Identifiers) appendIPAddress(ipaddr net.IP) {
 	}
 }
 
+func appendUniqueString(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
 func (ids *Identifiers) hasDNSName(target string) bool {
 	for _, value := range ids.DNSNames {
 		if value == target {
@@ -170,7 +252,7 @@ func (ids *Identifiers) hasIPAddress(target net.IP) bool {
 	return false
 }
 
-func (ids *Identifiers) addDnsSANfinal(value []byte) {
+func (ids *Identifiers) addDnsSANfinal(value []byte, anomalies []string) {
 	if ipaddr := parseIPAddrString(string(value)); ipaddr != nil {
 		// Stupid CAs put IP addresses in DNS SANs because stupid Microsoft
 		// used to not support IP address SANs.  Since there's no way for an IP
@@ -178,29 +260,35 @@ func (ids *Identifiers) addDnsSANfinal(value []byte) {
 		// and not try to process it as a DNS name.
 		ids.appendIPAddress(ipaddr)
 	} else if isASCIIString(value) {
-		ids.appendDNSName(sanitizeDNSName(string(value)))
+		ids.appendDNSName(sanitizeDNSName(string(value)), anomalies)
 	} else {
 		// DNS SANs are supposed to be IA5Strings (i.e. ASCII) but CAs can't follow
 		// simple rules.  Unfortunately, we have no idea what the encoding really is
 		// in this case, so interpret it as both UTF-8 (if it's valid UTF-8)
 		// and Latin-1.
+		anomalies = append(anomalies, DNSNameNonASCII)
 		if isUTF8String(value) {
-			ids.appendDNSName(sanitizeUnicodeDNSName(string(value)))
+			ids.appendDNSName(sanitizeUnicodeDNSName(string(value)), anomalies)
 		}
-		ids.appendDNSName(sanitizeUnicodeDNSName(latin1ToUTF8(value)))
+		ids.appendDNSName(sanitizeUnicodeDNSName(latin1ToUTF8(value)), anomalies)
 	}
 }
 
-func (ids *Identifiers) addDnsSANnonull(value []byte) {
+// withAnomaly returns a copy of |anomalies| with |anomaly| added
+func withAnomaly(anomalies []string, anomaly string) []string {
+	return append(append([]string{}, anomalies...), anomaly)
+}
+
+func (ids *Identifiers) addDnsSANnonull(value []byte, anomalies []string) {
 	if slashIndex := bytes.IndexByte(value, '/'); slashIndex != -1 {
 		// If the value contains a slash, then this might be a URL,
 		// so process the part of the value up to the first slash,
 		// which should be the domain.  Even though no client should
 		// ever successfully validate such a DNS name, the domain owner
 		// might still want to know about it.
-		ids.addDnsSANfinal(value[0:slashIndex])
+		ids.addDnsSANfinal(value[0:slashIndex], withAnomaly(anomalies, DNSNameTruncatedAtSlash))
 	}
-	ids.addDnsSANfinal(value)
+	ids.addDnsSANfinal(value, anomalies)
 }
 
 func (ids *Identifiers) AddDnsSAN(value []byte) {
@@ -208,37 +296,44 @@ func (ids *Identifiers) AddDnsSAN(value []byte) {
 	// so http://example.com becomes just example.com.  Even though clients
 	// should never successfully validate a DNS name like http://example.com,
 	// the owner of example.com might still want to know about it.
-	value = trimHttpPrefixBytes(value)
+	var anomalies []string
+	if trimmed := trimHttpPrefixBytes(value); len(trimmed) != len(value) {
+		anomalies = append(anomalies, DNSNameURLPrefix)
+		value = trimmed
+	}
 
 	if nullIndex := bytes.IndexByte(value, 0); nullIndex != -1 {
 		// If the value contains a null byte, process the part of
 		// the value up to the first null byte in addition to the
 		// complete value, in case this certificate is an attempt to
 		// fake out validators that only compare up to the first null.
-		ids.addDnsSANnonull(value[0:nullIndex])
+		ids.addDnsSANnonull(value[0:nullIndex], withAnomaly(anomalies, DNSNameTruncatedAtNull))
 	}
-	ids.addDnsSANnonull(value)
+	ids.addDnsSANnonull(value, anomalies)
 }
 
-func (ids *Identifiers) addCNfinal(value string) {
+func (ids *Identifiers) addCNfinal(value string, anomalies []string) {
 	if ipaddr := parseIPAddrString(value); ipaddr != nil {
 		ids.appendIPAddress(ipaddr)
 	} else if !strings.ContainsRune(value, ' ') {
 		// If the CN contains a space it's clearly not a DNS name, so ignore it.
-		ids.appendDNSName(sanitizeUnicodeDNSName(value))
+		if !isASCIIString([]byte(value)) {
+			anomalies = withAnomaly(anomalies, DNSNameNonASCII)
+		}
+		ids.appendDNSName(sanitizeUnicodeDNSName(value), anomalies)
 	}
 }
 
-func (ids *Identifiers) addCNnonull(value string) {
+func (ids *Identifiers) addCNnonull(value string, anomalies []string) {
 	if slashIndex := strings.IndexRune(value, '/'); slashIndex != -1 {
 		// If the value contains a slash, then this might be a URL,
 		// so process the part of the value up to the first slash,
 		// which should be the domain.  Even though no client should
 		// ever successfully validate such a DNS name, the domain owner
 		// might still want to know about it.
-		ids.addCNfinal(value[0:slashIndex])
+		ids.addCNfinal(value[0:slashIndex], withAnomaly(anomalies, DNSNameTruncatedAtSlash))
 	}
-	ids.addCNfinal(value)
+	ids.addCNfinal(value, anomalies)
 }
 
 func (ids *Identifiers) AddCN(value string) {
@@ -246,22 +341,70 @@ func (ids *Identifiers) AddCN(value string) {
 	// so http://example.com becomes just example.com.  Even though clients
 	// should never successfully validate a DNS name like http://example.com,
 	// the owner of example.com might still want to know about it.
-	value = trimHttpPrefixString(value)
+	var anomalies []string
+	if trimmed := trimHttpPrefixString(value); len(trimmed) != len(value) {
+		anomalies = append(anomalies, DNSNameURLPrefix)
+		value = trimmed
+	}
 
 	if nullIndex := strings.IndexRune(value, 0); nullIndex != -1 {
 		// If the value contains a null byte, process the part of
 		// the value up to the first null byte in addition to the
 		// complete value, in case this certificate is an attempt to
 		// fake out validators that only compare up to the first null.
-		ids.addCNnonull(value[0:nullIndex])
+		ids.addCNnonull(value[0:nullIndex], withAnomaly(anomalies, DNSNameTruncatedAtNull))
 	}
-	ids.addCNnonull(value)
+	ids.addCNnonull(value, anomalies)
 }
 
 func (ids *Identifiers) AddIPAddress(value net.IP) {
 	ids.appendIPAddress(value)
 }
 
+// Like DNS SANs, email and URI SANs are supposed to be IA5Strings but often
+// aren't, so interpret non-ASCII values as both UTF-8 and Latin-1
+func decodeIA5Values(value []byte) []string {
+	if isASCIIString(value) {
+		return []string{string(value)}
+	}
+	var values []string
+	if isUTF8String(value) {
+		values = append(values, string(value))
+	}
+	return append(values, latin1ToUTF8(value))
+}
+
+func (ids *Identifiers) addEmailSANfinal(value string) {
+	value = strings.TrimSpace(value)
+	if at := strings.LastIndex(value, "@"); at != -1 {
+		value = value[:at+1] + sanitizeUnicodeDNSName(value[at+1:])
+	}
+	ids.EmailAddrs = appendUniqueString(ids.EmailAddrs, value)
+}
+
+func (ids *Identifiers) AddEmailSAN(value []byte) {
+	// As with DNS SANs, also process the part before a null byte
+	if nullIndex := bytes.IndexByte(value, 0); nullIndex != -1 {
+		for _, str := range decodeIA5Values(value[0:nullIndex]) {
+			ids.addEmailSANfinal(str)
+		}
+	}
+	for _, str := range decodeIA5Values(value) {
+		ids.addEmailSANfinal(str)
+	}
+}
+
+func (ids *Identifiers) AddURISAN(value []byte) {
+	if nullIndex := bytes.IndexByte(value, 0); nullIndex != -1 {
+		for _, str := range decodeIA5Values(value[0:nullIndex]) {
+			ids.URIs = appendUniqueString(ids.URIs, strings.TrimSpace(str))
+		}
+	}
+	for _, str := range decodeIA5Values(value) {
+		ids.URIs = appendUniqueString(ids.URIs, strings.TrimSpace(str))
+	}
+}
+
 func (ids *Identifiers) dnsNamesString(sep string) string {
 	return strings.Join(ids.DNSNames, sep)
 }
@@ -298,6 +441,10 @@ func (cert *CertInfo) ParseIdentifiers() (*Identifiers, error) {
 		switch san.Type {
 		case sanDNSName:
 			ids.AddDnsSAN(san.Value)
+		case sanRfc822Name:
+			ids.AddEmailSAN(san.Value)
+		case sanURI:
+			ids.AddURISAN(san.Value)
 		case sanIPAddress:
 			if len(san.Value) == 4 || len(san.Value) == 16 {
 				ids.AddIPAddress(net.IP(san.Value))
@@ -0,0 +1,36 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDNSNameAnomalies(t *testing.T) {
+	ids := NewIdentifiers()
+	ids.AddDnsSAN([]byte("?.example.com"))
+	ids.AddDnsSAN([]byte("*.example.net"))
+	ids.AddDnsSAN([]byte("https://www.example.org/path"))
+	ids.AddDnsSAN([]byte("example.org\x00.example.com"))
+	ids.AddDnsSAN([]byte("plain.example.com"))
+
+	expected := map[string][]string{
+		"?.example.com":                    {DNSNameRedacted},
+		"*.example.net":                    {DNSNameWildcard},
+		"www.example.org":                  {DNSNameURLPrefix, DNSNameTruncatedAtSlash},
+		"www.example.org/path":             {DNSNameURLPrefix},
+		"example.org":                      {DNSNameTruncatedAtNull},
+		"example.<unparsable>.example.com": {DNSNameUnparsableLabel},
+	}
+	if !reflect.DeepEqual(ids.DNSNameAnomalies, expected) {
+		t.Errorf("Wrong anomalies: %v", ids.DNSNameAnomalies)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package certtest creates certificates and log entries for tests of the
+// packages which consume them (notify, sink, and the stores).
+package certtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// Cert returns the DER of a self-signed certificate with serial number 42,
+// common name "Test CA", and validity from 2024-01-01 to 2024-04-01, after
+// |modify| (if not nil) has changed its template
+func Cert(t testing.TB, modify func(*x509.Certificate)) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "Test CA"},
+		NotBefore:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if modify != nil {
+		modify(template)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// EntryInfo returns the EntryInfo of a Cert for |dnsNames| and
+// |ipAddresses|, as if it were entry 7 of https://ct.example.com/, logged
+// at 2024-01-01 00:05
+func EntryInfo(t testing.TB, dnsNames []string, ipAddresses []net.IP) *certspotter.EntryInfo {
+	der := Cert(t, func(template *x509.Certificate) {
+		template.DNSNames = dnsNames
+		template.IPAddresses = ipAddresses
+	})
+	entry := &ct.LogEntry{Index: 7}
+	entry.Leaf.LeafType = ct.TimestampedEntryLeafType
+	entry.Leaf.TimestampedEntry.Timestamp = uint64(time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond))
+	entry.Leaf.TimestampedEntry.EntryType = ct.X509LogEntryType
+	entry.Leaf.TimestampedEntry.X509Entry = der
+	var err error
+	if entry.LeafBytes, err = ct.SerializeMerkleTreeLeaf(entry.Leaf); err != nil {
+		t.Fatal(err)
+	}
+	info := certspotter.NewEntryInfo("https://ct.example.com/", entry)
+	if info.CertInfo == nil {
+		t.Fatalf("Error parsing test certificate: %s", info.ParseError)
+	}
+	return info
+}
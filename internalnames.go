@@ -0,0 +1,133 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"net"
+	"strings"
+)
+
+const MatchInternalName = "internal_name"
+
+// Matched as the Pattern of single-label names
+const singleLabelPattern = "single-label name"
+
+// Address ranges which aren't publicly routable, so CAs can't validate
+// control of them (see the IANA special-purpose address registries)
+var reservedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",       // "this network"
+	"10.0.0.0/8",      // RFC 1918
+	"100.64.0.0/10",   // carrier-grade NAT
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link local
+	"172.16.0.0/12",   // RFC 1918
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"192.168.0.0/16",  // RFC 1918
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved, and broadcast
+	"::/128",          // unspecified
+	"::1/128",         // loopback
+	"64:ff9b:1::/48",  // local-use IPv4/IPv6 translation
+	"100::/64",        // discard-only
+	"2001:db8::/32",   // documentation
+	"fc00::/7",        // unique local
+	"fe80::/10",       // link local
+	"ff00::/8",        // multicast
+)
+
+// Domains which aren't delegated in the public DNS, or are commonly used
+// for internal networks
+var internalDomains = []string{
+	"local",
+	"localhost",
+	"internal",
+	"intranet",
+	"corp",
+	"home",
+	"home.arpa",
+	"lan",
+	"localdomain",
+	"private",
+	"test",
+	"example",
+	"invalid",
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// ReservedNetwork returns the reserved range which contains |ip|, or nil if
+// it's a public address
+func ReservedNetwork(ip net.IP) *net.IPNet {
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return network
+		}
+	}
+	return nil
+}
+
+// InternalDomain returns the internal domain (e.g. "internal") which
+// |dnsName| is in, "" if it's a single-label name, or ok=false if it's
+// neither
+func InternalDomain(dnsName string) (domain string, ok bool) {
+	dnsName = strings.TrimSuffix(strings.ToLower(dnsName), ".")
+	dnsName = strings.TrimPrefix(dnsName, "*.")
+	if !strings.Contains(dnsName, ".") {
+		return "", true
+	}
+	for _, domain := range internalDomains {
+		if dnsName == domain || strings.HasSuffix(dnsName, "."+domain) {
+			return domain, true
+		}
+	}
+	return "", false
+}
+
+// InternalNameMatcher matches certificates for reserved IP addresses,
+// internal domains, or single-label names, none of which can be validated
+// by a publicly-trusted CA.  Finding them in CT means a CA misissued, or an
+// internal CA's certificates are leaking into public logs.
+type InternalNameMatcher struct{}
+
+func (matcher *InternalNameMatcher) Match(info *EntryInfo) []Match {
+	if info.Identifiers == nil {
+		return nil
+	}
+	var matches []Match
+	for _, dnsName := range info.Identifiers.DNSNames {
+		if domain, ok := InternalDomain(dnsName); ok {
+			pattern := singleLabelPattern
+			if domain != "" {
+				pattern = "." + domain
+			}
+			matches = append(matches, Match{Category: MatchInternalName, ID: MatchInternalName, Pattern: pattern, Value: dnsName, Anomalies: info.Identifiers.DNSNameAnomalies[dnsName]})
+		}
+	}
+	for _, ip := range info.Identifiers.IPAddrs {
+		if network := ReservedNetwork(ip); network != nil {
+			matches = append(matches, Match{Category: MatchInternalName, ID: MatchInternalName, Pattern: network.String(), Value: ip.String()})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"net"
+	"testing"
+)
+
+func TestInternalDomain(t *testing.T) {
+	for name, expected := range map[string]string{
+		"printer.local":      "local",
+		"DB.Corp.Internal.":  "internal",
+		"*.nas.home.arpa":    "home.arpa",
+		"intranet":           "",
+		"*.mailserver":       "",
+		"www.example.com":    "-",
+		"local.example.com":  "-",
+		"internal-tools.net": "-",
+	} {
+		domain, ok := InternalDomain(name)
+		if !ok {
+			domain = "-"
+		}
+		if domain != expected {
+			t.Errorf("InternalDomain(%q) = %q, expected %q", name, domain, expected)
+		}
+	}
+}
+
+func TestReservedNetwork(t *testing.T) {
+	for ip, expected := range map[string]string{
+		"10.1.2.3":      "10.0.0.0/8",
+		"172.31.255.1":  "172.16.0.0/12",
+		"192.168.0.1":   "192.168.0.0/16",
+		"127.0.0.1":     "127.0.0.0/8",
+		"100.100.1.1":   "100.64.0.0/10",
+		"fd12:3456::1":  "fc00::/7",
+		"::1":           "::1/128",
+		"172.32.0.1":    "",
+		"8.8.8.8":       "",
+		"2606:4700::11": "",
+	} {
+		network := ReservedNetwork(net.ParseIP(ip))
+		if (network == nil && expected != "") || (network != nil && network.String() != expected) {
+			t.Errorf("ReservedNetwork(%s) = %v, expected %q", ip, network, expected)
+		}
+	}
+}
+
+func TestInternalNameMatcher(t *testing.T) {
+	ids := NewIdentifiers()
+	ids.DNSNames = []string{"www.example.com", "wiki.corp", "fileserver"}
+	ids.IPAddrs = []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.1")}
+	matches := new(InternalNameMatcher).Match(&EntryInfo{Identifiers: ids})
+	if len(matches) != 3 ||
+		matches[0].Value != "wiki.corp" || matches[0].Pattern != ".corp" ||
+		matches[1].Value != "fileserver" || matches[1].Pattern != "single-label name" ||
+		matches[2].Value != "10.0.0.1" || matches[2].Pattern != "10.0.0.0/8" {
+		t.Errorf("Wrong matches: %+v", matches)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+const MatchIssuer = "issuer"
+
+// IssuerKeyHash returns the SHA-256 hash of the issuer's Subject Public Key
+// Info, or nil if it can't be determined.  For precertificates, this is the
+// hash in the log entry, which identifies the CA even if the precertificate
+// was signed by a precertificate signing certificate.
+func (info *EntryInfo) IssuerKeyHash() []byte {
+	if info.Entry.Leaf.TimestampedEntry.EntryType == ct.PrecertLogEntryType {
+		hash := info.Entry.Leaf.TimestampedEntry.PrecertEntry.IssuerKeyHash
+		return hash[:]
+	}
+	if len(info.Entry.Chain) == 0 {
+		return nil
+	}
+	issuer, err := MakeCertInfoFromRawCert(info.Entry.Chain[0])
+	if err != nil {
+		return nil
+	}
+	return issuer.PubkeyHashBytes()
+}
+
+// A CA is a named set of issuers, identified by distinguished name and/or
+// the SHA-256 hash of their public key
+type CA struct {
+	Name       string   `json:"name"`
+	IssuerDNs  []string `json:"issuer_dns"`
+	KeyHashes  []string `json:"key_hashes"`            // hex
+	CAADomains []string `json:"caa_domains,omitempty"` // issuer domain names which the CA recognizes in CAA records
+}
+
+// CAListFile is the format of a JSON file listing CAs which can be referred
+// to by name in issuer filters
+type CAListFile struct {
+	CAs []CA `json:"cas"`
+}
+
+// IssuerFilter matches entries issued by any of a set of issuers, or if
+// Exclude is true, entries not issued by any of them.
+type IssuerFilter struct {
+	Exclude   bool
+	IssuerDNs []string
+	KeyHashes [][]byte
+}
+
+func normalizeDN(dn string) string {
+	return strings.ToLower(strings.Join(strings.Fields(dn), " "))
+}
+
+// AddCA adds the issuers of |ca| to the filter
+func (filter *IssuerFilter) AddCA(ca *CA) error {
+	for _, dn := range ca.IssuerDNs {
+		filter.IssuerDNs = append(filter.IssuerDNs, normalizeDN(dn))
+	}
+	for _, keyHash := range ca.KeyHashes {
+		hash, err := hex.DecodeString(keyHash)
+		if err != nil || len(hash) != 32 {
+			return fmt.Errorf("CA %s has invalid key hash `%s'", ca.Name, keyHash)
+		}
+		filter.KeyHashes = append(filter.KeyHashes, hash)
+	}
+	return nil
+}
+
+// Add adds an issuer to the filter, specified as one of:
+//
+//	dn:DN		issuer distinguished name (e.g. dn:CN=R3, O=Let's Encrypt, C=US)
+//	key:HEX		SHA-256 hash of the issuer's Subject Public Key Info
+//	ca:NAME		all issuers of the named CA in |cas|
+func (filter *IssuerFilter) Add(spec string, cas []CA) error {
+	fields := strings.SplitN(spec, ":", 2)
+	if len(fields) != 2 {
+		return fmt.Errorf("Invalid issuer `%s': must be dn:DN, key:HASH, or ca:NAME", spec)
+	}
+	switch fields[0] {
+	case "dn":
+		filter.IssuerDNs = append(filter.IssuerDNs, normalizeDN(fields[1]))
+	case "key":
+		hash, err := hex.DecodeString(fields[1])
+		if err != nil || len(hash) != 32 {
+			return fmt.Errorf("Invalid issuer `%s': key hash must be 64 hex digits", spec)
+		}
+		filter.KeyHashes = append(filter.KeyHashes, hash)
+	case "ca":
+		for i := range cas {
+			if strings.EqualFold(cas[i].Name, fields[1]) {
+				return filter.AddCA(&cas[i])
+			}
+		}
+		return fmt.Errorf("Invalid issuer `%s': unknown CA `%s'", spec, fields[1])
+	default:
+		return fmt.Errorf("Invalid issuer `%s': must be dn:DN, key:HASH, or ca:NAME", spec)
+	}
+	return nil
+}
+
+// matchesIssuer returns a description of the issuer and whether it's one of
+// the filter's issuers.  ok is false if the issuer couldn't be determined.
+func (filter *IssuerFilter) matchesIssuer(info *EntryInfo) (issuer string, matches bool, ok bool) {
+	if info.CertInfo != nil && info.CertInfo.IssuerParseError == nil {
+		issuer = info.CertInfo.Issuer.String()
+		ok = true
+		normalized := normalizeDN(issuer)
+		for _, dn := range filter.IssuerDNs {
+			if dn == normalized {
+				return issuer, true, true
+			}
+		}
+	}
+	if keyHash := info.IssuerKeyHash(); keyHash != nil {
+		if issuer == "" {
+			issuer = hex.EncodeToString(keyHash)
+		}
+		ok = true
+		for _, hash := range filter.KeyHashes {
+			if bytes.Equal(hash, keyHash) {
+				return issuer, true, true
+			}
+		}
+	}
+	return issuer, false, ok
+}
+
+func (filter *IssuerFilter) Match(info *EntryInfo) []Match {
+	issuer, matches, ok := filter.matchesIssuer(info)
+	if !ok {
+		// Fail safe: we don't know who issued it, so it might match
+		return []Match{{Category: MatchUnparsable}}
+	}
+	if matches == filter.Exclude {
+		return nil
+	}
+	return []Match{{Category: MatchIssuer, Value: issuer}}
+}
@@ -0,0 +1,157 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// makeIssuerTestChain returns a leaf certificate and the DER of the CA
+// certificate which issued it
+func makeIssuerTestChain(t *testing.T) (*CertInfo, []byte) {
+	ca, caKey := makeTestCA(t, nil, nil, func(template *x509.Certificate) {
+		template.Subject = pkix.Name{CommonName: "Test Issuer", Organization: []string{"Example CA"}}
+	})
+	return makeTestCertInfo(t, ca, caKey, nil), ca.Raw
+}
+
+func TestIssuerFilterAdd(t *testing.T) {
+	keyHash := strings.Repeat("ab", 32)
+	cas := []CA{
+		{Name: "Example", IssuerDNs: []string{"CN=Example  R1, O=Example"}, KeyHashes: []string{keyHash}},
+		{Name: "Broken", KeyHashes: []string{"abcd"}},
+	}
+	tests := []struct {
+		spec      string
+		issuerDNs []string
+		keyHashes []string
+		ok        bool
+	}{
+		{"dn:CN=R3, O=Let's Encrypt, C=US", []string{"cn=r3, o=let's encrypt, c=us"}, nil, true},
+		{"dn:CN=Foo:Bar", []string{"cn=foo:bar"}, nil, true},
+		{"key:" + keyHash, nil, []string{keyHash}, true},
+		{"key:" + strings.ToUpper(keyHash), nil, []string{keyHash}, true},
+		{"key:abcd", nil, nil, false},
+		{"key:" + strings.Repeat("zz", 32), nil, nil, false},
+		{"ca:example", []string{"cn=example r1, o=example"}, []string{keyHash}, true},
+		{"ca:Unknown", nil, nil, false},
+		{"ca:Broken", nil, nil, false},
+		{"serial:1234", nil, nil, false},
+		{"CN=R3", nil, nil, false},
+	}
+	for _, test := range tests {
+		var filter IssuerFilter
+		err := filter.Add(test.spec, cas)
+		if (err == nil) != test.ok {
+			t.Errorf("Add(%q) returned error %v, expected ok=%v", test.spec, err, test.ok)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if strings.Join(filter.IssuerDNs, "|") != strings.Join(test.issuerDNs, "|") {
+			t.Errorf("Add(%q) added DNs %q, expected %q", test.spec, filter.IssuerDNs, test.issuerDNs)
+		}
+		var keyHashes []string
+		for _, hash := range filter.KeyHashes {
+			keyHashes = append(keyHashes, hex.EncodeToString(hash))
+		}
+		if strings.Join(keyHashes, "|") != strings.Join(test.keyHashes, "|") {
+			t.Errorf("Add(%q) added key hashes %q, expected %q", test.spec, keyHashes, test.keyHashes)
+		}
+	}
+}
+
+func TestIssuerKeyHash(t *testing.T) {
+	leaf, caDER := makeIssuerTestChain(t)
+	caInfo, err := MakeCertInfoFromRawCert(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKeyHash := caInfo.PubkeyHashBytes()
+	precertKeyHash := sha256.Sum256([]byte("precert issuer"))
+
+	tests := []struct {
+		name     string
+		entry    ct.TimestampedEntry
+		chain    []ct.ASN1Cert
+		expected []byte
+	}{
+		{"certificate", ct.TimestampedEntry{EntryType: ct.X509LogEntryType}, []ct.ASN1Cert{caDER}, caKeyHash},
+		{"no chain", ct.TimestampedEntry{EntryType: ct.X509LogEntryType}, nil, nil},
+		{"unparsable issuer", ct.TimestampedEntry{EntryType: ct.X509LogEntryType}, []ct.ASN1Cert{[]byte("garbage")}, nil},
+		{"precertificate", ct.TimestampedEntry{EntryType: ct.PrecertLogEntryType, PrecertEntry: ct.PreCert{IssuerKeyHash: precertKeyHash}}, []ct.ASN1Cert{caDER}, precertKeyHash[:]},
+	}
+	for _, test := range tests {
+		info := &EntryInfo{Entry: &ct.LogEntry{Chain: test.chain}, CertInfo: leaf}
+		info.Entry.Leaf.TimestampedEntry = test.entry
+		if actual := info.IssuerKeyHash(); !bytes.Equal(actual, test.expected) {
+			t.Errorf("%s: IssuerKeyHash() = %x, expected %x", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestIssuerFilterMatch(t *testing.T) {
+	leaf, caDER := makeIssuerTestChain(t)
+	caInfo, err := MakeCertInfoFromRawCert(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerDN := leaf.Issuer.String()
+	caKeyHash := hex.EncodeToString(caInfo.PubkeyHashBytes())
+	otherKeyHash := strings.Repeat("00", 32)
+
+	tests := []struct {
+		name     string
+		specs    []string
+		exclude  bool
+		certInfo *CertInfo
+		chain    []ct.ASN1Cert
+		category string // empty for no match
+		value    string
+	}{
+		{"DN", []string{"dn:" + issuerDN}, false, leaf, nil, MatchIssuer, issuerDN},
+		{"DN with different case and spacing", []string{"dn:" + strings.ToUpper(strings.Replace(issuerDN, " ", "   ", -1))}, false, leaf, nil, MatchIssuer, issuerDN},
+		{"other DN", []string{"dn:CN=Other"}, false, leaf, []ct.ASN1Cert{caDER}, "", ""},
+		{"key hash", []string{"key:" + caKeyHash}, false, leaf, []ct.ASN1Cert{caDER}, MatchIssuer, issuerDN},
+		{"other key hash", []string{"key:" + otherKeyHash}, false, leaf, []ct.ASN1Cert{caDER}, "", ""},
+		{"key hash without certificate", []string{"key:" + caKeyHash}, false, nil, []ct.ASN1Cert{caDER}, MatchIssuer, caKeyHash},
+		{"excluded", []string{"dn:" + issuerDN}, true, leaf, nil, "", ""},
+		{"not excluded", []string{"dn:CN=Other", "key:" + otherKeyHash}, true, leaf, []ct.ASN1Cert{caDER}, MatchIssuer, issuerDN},
+		{"unknown issuer", []string{"dn:CN=Other"}, false, nil, nil, MatchUnparsable, ""},
+		{"unknown issuer excluded", []string{"dn:CN=Other"}, true, nil, nil, MatchUnparsable, ""},
+	}
+	for _, test := range tests {
+		filter := IssuerFilter{Exclude: test.exclude}
+		for _, spec := range test.specs {
+			if err := filter.Add(spec, nil); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+		}
+		info := &EntryInfo{Entry: &ct.LogEntry{Chain: test.chain}, CertInfo: test.certInfo}
+		info.Entry.Leaf.TimestampedEntry.EntryType = ct.X509LogEntryType
+		matches := filter.Match(info)
+		if test.category == "" {
+			if len(matches) != 0 {
+				t.Errorf("%s: unexpected matches %+v", test.name, matches)
+			}
+		} else if len(matches) != 1 || matches[0].Category != test.category || matches[0].Value != test.value {
+			t.Errorf("%s: matches are %+v, expected %s %q", test.name, matches, test.category, test.value)
+		}
+	}
+}
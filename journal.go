@@ -0,0 +1,198 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// Types of JournalEntry
+const (
+	JournalEntrySTH           = "sth"            // an STH was fetched from a log
+	JournalEntryVerifiedRange = "verified_range" // entries of a log were scanned and verified against an STH
+	JournalEntryNotification  = "notification"   // a notification was sent, or failed to be
+)
+
+// JournalEntry is a line of a Journal
+type JournalEntry struct {
+	PrevHash     string               `json:"prev_hash,omitempty"` // hex SHA-256 of the previous line, without its newline
+	Time         time.Time            `json:"time"`
+	Type         string               `json:"type"`
+	LogURI       string               `json:"log_uri,omitempty"`
+	STH          *ct.SignedTreeHead   `json:"sth,omitempty"`
+	Range        *JournalRange        `json:"range,omitempty"`
+	Notification *JournalNotification `json:"notification,omitempty"`
+}
+
+// JournalRange is a range of entries [Start, End) which hash, along with the
+// entries before them, to RootHash, the root hash of the STH of size End
+type JournalRange struct {
+	Start    uint64 `json:"start"`
+	End      uint64 `json:"end"`
+	RootHash []byte `json:"root_hash"`
+}
+
+type JournalNotification struct {
+	Channel string                `json:"channel"`
+	Entries []JournalNotifiedItem `json:"entries"` // several, for a digest
+	Error   string                `json:"error,omitempty"`
+}
+
+type JournalNotifiedItem struct {
+	LogURI      string `json:"log_uri"`
+	Index       int64  `json:"index"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Journal is an append-only file of JSON lines recording what a monitor saw
+// and did.  Each line includes the hash of the one before, so altering or
+// removing a line breaks the chain (see VerifyJournal) unless all of the
+// lines after it are rewritten too.  The chain alone therefore only detects
+// accidental damage; to detect tampering, the latest hash (see LastHash) must
+// be recorded somewhere the journal's writer can't change.  All methods may
+// be called concurrently, and on a nil *Journal, in which case they do
+// nothing.
+type Journal struct {
+	mu       sync.Mutex
+	file     *os.File
+	lastHash string
+}
+
+// Longest line which OpenJournal will look back for
+const maxJournalLine = 1 << 20
+
+// OpenJournal opens the journal in |filename| for appending, creating it if
+// it doesn't exist
+func OpenJournal(filename string) (*Journal, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	lastLine, err := readLastLine(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	journal := &Journal{file: file}
+	if lastLine != nil {
+		journal.lastHash = hashJournalLine(lastLine)
+	}
+	return journal, nil
+}
+
+// readLastLine returns the last line of |file|, without its newline, or nil
+// if it's empty
+func readLastLine(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+	length := size
+	if length > maxJournalLine {
+		length = maxJournalLine
+	}
+	buf := make([]byte, length)
+	if _, err := file.ReadAt(buf, size-length); err != nil {
+		return nil, err
+	}
+	if buf[len(buf)-1] != '\n' {
+		return nil, fmt.Errorf("Journal doesn't end with a complete line")
+	}
+	buf = buf[:len(buf)-1]
+	start := bytes.LastIndexByte(buf, '\n')
+	if start == -1 && length < size {
+		return nil, fmt.Errorf("Last line of journal is longer than %d bytes", maxJournalLine)
+	}
+	return buf[start+1:], nil
+}
+
+func hashJournalLine(line []byte) string {
+	hash := sha256.Sum256(line)
+	return hex.EncodeToString(hash[:])
+}
+
+// Write appends |entry| to the journal, setting its PrevHash, and its Time if
+// it's zero
+func (journal *Journal) Write(entry *JournalEntry) error {
+	if journal == nil {
+		return nil
+	}
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	entry.PrevHash = journal.lastHash
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := journal.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Error writing to journal: %s", err)
+	}
+	journal.lastHash = hashJournalLine(line)
+	return nil
+}
+
+// LastHash returns the hex SHA-256 of the last line of the journal, or the
+// empty string if it's empty
+func (journal *Journal) LastHash() string {
+	if journal == nil {
+		return ""
+	}
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	return journal.lastHash
+}
+
+// Close closes the journal's file
+func (journal *Journal) Close() error {
+	if journal == nil {
+		return nil
+	}
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	return journal.file.Close()
+}
+
+// VerifyJournal checks that each line of the journal read from |r| is a
+// JournalEntry whose PrevHash is the hash of the line before, and returns
+// the number of lines and the hash of the last one
+func VerifyJournal(r io.Reader) (lines int, lastHash string, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxJournalLine)
+	for scanner.Scan() {
+		lines++
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return lines, lastHash, fmt.Errorf("Line %d is malformed: %s", lines, err)
+		}
+		if entry.PrevHash != lastHash {
+			return lines, lastHash, fmt.Errorf("Line %d doesn't follow the line before it (its prev_hash is %q instead of %q)", lines, entry.PrevHash, lastHash)
+		}
+		lastHash = hashJournalLine(scanner.Bytes())
+	}
+	return lines, lastHash, scanner.Err()
+}
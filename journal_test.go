@@ -0,0 +1,78 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "journal.jsonl")
+
+	journal, err := OpenJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Write(&JournalEntry{Type: JournalEntrySTH, LogURI: "https://ct.example.com", STH: &ct.SignedTreeHead{TreeSize: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Write(&JournalEntry{Type: JournalEntryVerifiedRange, LogURI: "https://ct.example.com", Range: &JournalRange{Start: 0, End: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	// The chain continues when the journal is reopened
+	journal, err = OpenJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Write(&JournalEntry{Type: JournalEntryNotification, Notification: &JournalNotification{Channel: "email"}}); err != nil {
+		t.Fatal(err)
+	}
+	lastHash := journal.LastHash()
+	journal.Close()
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, hash, err := VerifyJournal(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines != 3 || hash != lastHash {
+		t.Errorf("Verified %d lines ending with hash %s; expected 3 ending with %s", lines, hash, lastHash)
+	}
+
+	tampered := bytes.Replace(data, []byte(`"end":10`), []byte(`"end":11`), 1)
+	if _, _, err := VerifyJournal(bytes.NewReader(tampered)); err == nil {
+		t.Errorf("Tampered journal was verified")
+	}
+	removed := data[bytes.IndexByte(data, '\n')+1:]
+	if _, _, err := VerifyJournal(bytes.NewReader(removed)); err == nil {
+		t.Errorf("Journal with its first line removed was verified")
+	}
+
+	var nilJournal *Journal
+	if err := nilJournal.Write(&JournalEntry{Type: JournalEntrySTH}); err != nil || nilJournal.LastHash() != "" {
+		t.Errorf("Nil journal didn't do nothing")
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package kafka is a minimal Kafka producer, supporting only what's needed
+// to publish certificates: uncompressed record batches to a single topic,
+// without TLS or SASL.  It requires Kafka 0.11 or higher.
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Values for Config.Acks
+const (
+	AcksNone   = 0  // don't wait for the broker; messages may be lost
+	AcksLeader = 1  // wait for the partition's leader to write the message
+	AcksAll    = -1 // wait for all in-sync replicas to write the message
+)
+
+// Values for Config.Partitioner
+const (
+	PartitionByKey      = "key"         // same as the Java client, so messages with the same key go to the same partition
+	PartitionRoundRobin = "round_robin" // spread messages evenly, ignoring the key
+)
+
+type Config struct {
+	Brokers     []string // host:port of one or more brokers, used to find the rest
+	Topic       string
+	ClientID    string
+	Acks        int
+	Partitioner string
+	Timeout     time.Duration // for each request
+	Retries     int           // how many times to retry a failed message
+}
+
+type conn struct {
+	net.Conn
+	correlationID int32
+}
+
+// A Producer publishes messages to a Kafka topic.  It is safe for concurrent
+// use; messages are sent one at a time, in order.
+type Producer struct {
+	config Config
+
+	mu         sync.Mutex
+	metadata   *metadata
+	conns      map[string]*conn
+	roundRobin int
+}
+
+// NewProducer creates a Producer, and fetches the metadata for the topic to
+// make sure that it exists
+func NewProducer(config Config) (*Producer, error) {
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.Partitioner == "" {
+		config.Partitioner = PartitionByKey
+	}
+	if config.Partitioner != PartitionByKey && config.Partitioner != PartitionRoundRobin {
+		return nil, fmt.Errorf("Invalid Kafka partitioner `%s'", config.Partitioner)
+	}
+	if config.Acks != AcksNone && config.Acks != AcksLeader && config.Acks != AcksAll {
+		return nil, fmt.Errorf("Invalid Kafka acks %d", config.Acks)
+	}
+	producer := &Producer{config: config, conns: make(map[string]*conn)}
+	if err := producer.refreshMetadata(); err != nil {
+		producer.Close()
+		return nil, err
+	}
+	return producer, nil
+}
+
+func (producer *Producer) connect(addr string) (*conn, error) {
+	if c := producer.conns[addr]; c != nil {
+		return c, nil
+	}
+	netConn, err := net.DialTimeout("tcp", addr, producer.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: netConn}
+	producer.conns[addr] = c
+	return c, nil
+}
+
+func (producer *Producer) closeConns() {
+	for addr, c := range producer.conns {
+		c.Close()
+		delete(producer.conns, addr)
+	}
+}
+
+// request sends a request to the broker at |addr| and returns the body of
+// the response, or nil if |wantResponse| is false
+func (producer *Producer) request(addr string, apiKey int16, apiVersion int16, body []byte, wantResponse bool) ([]byte, error) {
+	c, err := producer.connect(addr)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.roundTrip(apiKey, apiVersion, producer.config.ClientID, producer.config.Timeout, body, wantResponse)
+	if err != nil {
+		// The connection may be in an unknown state
+		c.Close()
+		delete(producer.conns, addr)
+		return nil, fmt.Errorf("%s: %s", addr, err)
+	}
+	return response, nil
+}
+
+func (c *conn) roundTrip(apiKey int16, apiVersion int16, clientID string, timeout time.Duration, body []byte, wantResponse bool) ([]byte, error) {
+	c.correlationID++
+	var header encoder
+	header.int16(apiKey)
+	header.int16(apiVersion)
+	header.int32(c.correlationID)
+	header.string(clientID)
+
+	c.SetDeadline(time.Now().Add(timeout))
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(header.Len()+len(body)))
+	if _, err := c.Write(append(append(size[:], header.Bytes()...), body...)); err != nil {
+		return nil, err
+	}
+	if !wantResponse {
+		return nil, nil
+	}
+	if _, err := io.ReadFull(c, size[:]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c, response); err != nil {
+		return nil, err
+	}
+	if len(response) < 4 || int32(binary.BigEndian.Uint32(response)) != c.correlationID {
+		return nil, fmt.Errorf("Response is for the wrong request")
+	}
+	return response[4:], nil
+}
+
+func (producer *Producer) refreshMetadata() error {
+	addrs := append([]string{}, producer.config.Brokers...)
+	if producer.metadata != nil {
+		for _, broker := range producer.metadata.brokers {
+			addrs = append(addrs, broker.addr)
+		}
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		response, err := producer.request(addr, apiMetadata, apiMetadataVersion, encodeMetadataRequest(producer.config.Topic), true)
+		if err == nil {
+			var md *metadata
+			if md, err = decodeMetadataResponse(response, producer.config.Topic); err == nil {
+				if md.topicErr != 0 {
+					return fmt.Errorf("Kafka topic %s: %s", producer.config.Topic, ErrorCode(md.topicErr))
+				}
+				if len(md.partitions) == 0 {
+					return fmt.Errorf("Kafka topic %s has no partitions", producer.config.Topic)
+				}
+				producer.metadata = md
+				return nil
+			}
+		}
+		lastErr = fmt.Errorf("Error getting metadata from Kafka broker: %s", err)
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("No Kafka brokers specified")
+	}
+	return lastErr
+}
+
+func (producer *Producer) partitionFor(key []byte) partitionMetadata {
+	partitions := producer.metadata.partitions
+	if key != nil && producer.config.Partitioner == PartitionByKey {
+		return partitions[int(murmur2(key)&0x7fffffff)%len(partitions)]
+	}
+	producer.roundRobin = (producer.roundRobin + 1) % len(partitions)
+	return partitions[producer.roundRobin]
+}
+
+func (producer *Producer) produce(message Message) error {
+	if producer.metadata == nil {
+		if err := producer.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+	partition := producer.partitionFor(message.Key)
+	if partition.err != 0 {
+		return ErrorCode(partition.err)
+	}
+	leader, ok := producer.metadata.brokers[partition.leader]
+	if !ok {
+		return ErrorCode(5) // LEADER_NOT_AVAILABLE
+	}
+	body := encodeProduceRequest(producer.config.Topic, partition.id, int16(producer.config.Acks), producer.config.Timeout, []Message{message})
+	response, err := producer.request(leader.addr, apiProduce, apiProduceVersion, body, producer.config.Acks != AcksNone)
+	if err != nil || response == nil {
+		return err
+	}
+	errorCode, err := decodeProduceResponse(response)
+	if err != nil {
+		return err
+	}
+	if errorCode != 0 {
+		return ErrorCode(errorCode)
+	}
+	return nil
+}
+
+func isRetriable(err error) bool {
+	if code, ok := err.(ErrorCode); ok {
+		return retriableErrors[int16(code)]
+	}
+	return true // network errors
+}
+
+// Produce publishes |message|, waiting for it to be acknowledged as
+// specified by Config.Acks.  If a broker can't be reached or isn't the
+// leader, the metadata is refreshed and the message retried, up to
+// Config.Retries times.
+func (producer *Producer) Produce(message Message) error {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+	for attempt := 0; ; attempt++ {
+		err := producer.produce(message)
+		if err == nil {
+			return nil
+		}
+		if attempt >= producer.config.Retries || !isRetriable(err) {
+			return err
+		}
+		// The partition may have moved
+		producer.metadata = nil
+		time.Sleep(time.Duration(100<<uint(attempt)) * time.Millisecond)
+	}
+}
+
+func (producer *Producer) Close() error {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	producer.closeConns()
+	return nil
+}
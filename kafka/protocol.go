@@ -0,0 +1,313 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"time"
+)
+
+// API keys and the versions of them that are used
+const (
+	apiProduce         = 0
+	apiProduceVersion  = 3 // the first version to use record batches
+	apiMetadata        = 3
+	apiMetadataVersion = 1
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var errTruncated = errors.New("Truncated response from Kafka broker")
+
+// Error codes which mean that the metadata is out of date, so the request
+// should be retried after refreshing it
+var retriableErrors = map[int16]bool{
+	3:  true, // UNKNOWN_TOPIC_OR_PARTITION
+	5:  true, // LEADER_NOT_AVAILABLE
+	6:  true, // NOT_LEADER_FOR_PARTITION
+	7:  true, // REQUEST_TIMED_OUT
+	19: true, // NOT_ENOUGH_REPLICAS
+	20: true, // NOT_ENOUGH_REPLICAS_AFTER_APPEND
+}
+
+type ErrorCode int16
+
+func (code ErrorCode) Error() string {
+	return fmt.Sprintf("Kafka error code %d", int16(code))
+}
+
+// encoder encodes Kafka protocol primitives
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) int8(v int8)   { e.WriteByte(byte(v)) }
+func (e *encoder) int16(v int16) { binary.Write(&e.Buffer, binary.BigEndian, v) }
+func (e *encoder) int32(v int32) { binary.Write(&e.Buffer, binary.BigEndian, v) }
+func (e *encoder) int64(v int64) { binary.Write(&e.Buffer, binary.BigEndian, v) }
+
+func (e *encoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+func (e *encoder) nullString() { e.int16(-1) }
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+
+// varintBytes encodes a record key or value, where nil is encoded as null
+func (e *encoder) varintBytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.Write(b)
+}
+
+// decoder decodes Kafka protocol primitives.  After an error, all further
+// reads return zero, and err is set.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.data) < n {
+		d.err = errTruncated
+		return make([]byte, n)
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) int8() int8   { return int8(d.next(1)[0]) }
+func (d *decoder) int16() int16 { return int16(binary.BigEndian.Uint16(d.next(2))) }
+func (d *decoder) int32() int32 { return int32(binary.BigEndian.Uint32(d.next(4))) }
+func (d *decoder) int64() int64 { return int64(binary.BigEndian.Uint64(d.next(8))) }
+
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// arrayLength returns the length of an array; a bogus length sets err
+func (d *decoder) arrayLength() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(d.data) {
+		d.err = errTruncated
+		return 0
+	}
+	return int(n)
+}
+
+// A Message is a record to be produced to a topic
+type Message struct {
+	Key       []byte
+	Value     []byte
+	Timestamp time.Time
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// encodeRecordBatch encodes |messages| as a version 2 record batch
+func encodeRecordBatch(messages []Message) []byte {
+	firstTimestamp, maxTimestamp := millis(messages[0].Timestamp), millis(messages[0].Timestamp)
+	for _, message := range messages {
+		if t := millis(message.Timestamp); t > maxTimestamp {
+			maxTimestamp = t
+		}
+	}
+
+	// The part of the batch covered by the CRC
+	var body encoder
+	body.int16(0) // attributes: no compression, create time
+	body.int32(int32(len(messages) - 1))
+	body.int64(firstTimestamp)
+	body.int64(maxTimestamp)
+	body.int64(-1) // producer ID
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(messages)))
+	for i, message := range messages {
+		var record encoder
+		record.int8(0) // attributes
+		record.varint(millis(message.Timestamp) - firstTimestamp)
+		record.varint(int64(i))
+		record.varintBytes(message.Key)
+		record.varintBytes(message.Value)
+		record.varint(0) // headers
+		body.varint(int64(record.Len()))
+		body.Write(record.Bytes())
+	}
+
+	var batch encoder
+	batch.int64(0) // base offset, assigned by the broker
+	batch.int32(int32(4 + 1 + 4 + body.Len()))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(body.Bytes(), castagnoli)))
+	batch.Write(body.Bytes())
+	return batch.Bytes()
+}
+
+type broker struct {
+	id   int32
+	addr string
+}
+
+type partitionMetadata struct {
+	err    int16
+	id     int32
+	leader int32
+}
+
+type metadata struct {
+	brokers    map[int32]broker
+	topicErr   int16
+	partitions []partitionMetadata
+}
+
+func encodeMetadataRequest(topic string) []byte {
+	var e encoder
+	e.int32(1)
+	e.string(topic)
+	return e.Bytes()
+}
+
+func decodeMetadataResponse(data []byte, topic string) (*metadata, error) {
+	d := &decoder{data: data}
+	md := &metadata{brokers: make(map[int32]broker)}
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		md.brokers[id] = broker{id: id, addr: net.JoinHostPort(host, strconv.Itoa(int(port)))}
+	}
+	d.int32() // controller ID
+	found := false
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		topicErr := d.int16()
+		name := d.string()
+		d.int8() // is internal
+		var partitions []partitionMetadata
+		for j, m := 0, d.arrayLength(); j < m; j++ {
+			partition := partitionMetadata{err: d.int16(), id: d.int32(), leader: d.int32()}
+			for k, l := 0, d.arrayLength(); k < l; k++ {
+				d.int32() // replicas
+			}
+			for k, l := 0, d.arrayLength(); k < l; k++ {
+				d.int32() // in-sync replicas
+			}
+			partitions = append(partitions, partition)
+		}
+		if name == topic {
+			found = true
+			md.topicErr = topicErr
+			md.partitions = partitions
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if !found {
+		return nil, fmt.Errorf("Kafka broker did not return metadata for topic %s", topic)
+	}
+	return md, nil
+}
+
+func encodeProduceRequest(topic string, partition int32, acks int16, timeout time.Duration, messages []Message) []byte {
+	var e encoder
+	e.nullString() // transactional ID
+	e.int16(acks)
+	e.int32(int32(timeout / time.Millisecond))
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.bytes(encodeRecordBatch(messages))
+	return e.Bytes()
+}
+
+// decodeProduceResponse returns the error code for the (only) partition
+func decodeProduceResponse(data []byte) (int16, error) {
+	d := &decoder{data: data}
+	var errorCode int16
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		d.string() // topic
+		for j, m := 0, d.arrayLength(); j < m; j++ {
+			d.int32() // partition
+			errorCode = d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+		}
+	}
+	return errorCode, d.err
+}
+
+// murmur2 is the hash function used by the Java client's default
+// partitioner, so that messages with the same key go to the same partition
+// regardless of which client produced them
+func murmur2(data []byte) int32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	h := uint32(seed) ^ uint32(len(data))
+	for len(data) >= 4 {
+		k := binary.LittleEndian.Uint32(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+		data = data[4:]
+	}
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package kafka
+
+import (
+	"testing"
+)
+
+func TestMurmur2(t *testing.T) {
+	// Test vectors from the Java client
+	tests := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for input, expected := range tests {
+		if actual := murmur2([]byte(input)); actual != expected {
+			t.Errorf("murmur2(%q) = %d, expected %d", input, actual, expected)
+		}
+	}
+}
+
+func TestDecodeMetadataResponse(t *testing.T) {
+	var e encoder
+	e.int32(2) // brokers
+	e.int32(1)
+	e.string("kafka1.example.com")
+	e.int32(9092)
+	e.nullString()
+	e.int32(2)
+	e.string("2001:db8::1")
+	e.int32(9093)
+	e.nullString()
+	e.int32(1) // controller ID
+	e.int32(1) // topics
+	e.int16(0)
+	e.string("certs")
+	e.int8(0)
+	e.int32(1) // partitions
+	e.int16(0)
+	e.int32(0)
+	e.int32(2)
+	e.int32(1) // replicas
+	e.int32(2)
+	e.int32(1) // in-sync replicas
+	e.int32(2)
+
+	md, err := decodeMetadataResponse(e.Bytes(), "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedAddrs := map[int32]string{1: "kafka1.example.com:9092", 2: "[2001:db8::1]:9093"}
+	for id, addr := range expectedAddrs {
+		if md.brokers[id].addr != addr {
+			t.Errorf("broker %d has address %q, expected %q", id, md.brokers[id].addr, addr)
+		}
+	}
+	if len(md.partitions) != 1 || md.partitions[0].leader != 2 {
+		t.Errorf("wrong partitions: %+v", md.partitions)
+	}
+
+	if _, err := decodeMetadataResponse(e.Bytes(), "other"); err == nil {
+		t.Errorf("no error for missing topic")
+	}
+}
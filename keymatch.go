@@ -0,0 +1,171 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	MatchSerial         = "serial"
+	MatchPubkey         = "pubkey"
+	MatchCompromisedKey = "compromised_key"
+)
+
+// ReadIdentifierList reads a list of values, one per line, each optionally
+// followed by whitespace and an ID, calling |add| for each one.  Empty lines
+// and lines starting with # are ignored.
+func ReadIdentifierList(reader io.Reader, add func(value string, id string) error) error {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var id string
+		if len(fields) > 1 {
+			id = strings.Join(fields[1:], " ")
+		}
+		if err := add(fields[0], id); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// LoadIdentifierList is like ReadIdentifierList, but reads from the file named by |filename|
+func LoadIdentifierList(filename string, add func(value string, id string) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := ReadIdentifierList(file, add); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	return nil
+}
+
+// SerialMatcher matches certificates with particular serial numbers
+type SerialMatcher struct {
+	serials map[string]string // normalized serial => ID
+}
+
+// Convert a serial number in hex, with optional 0x prefix, colons, and
+// leading zeros, to the form used by formatSerialNumber
+func normalizeSerial(serial string) (string, error) {
+	negative := strings.HasPrefix(serial, "-")
+	serial = strings.TrimPrefix(serial, "-")
+	serial = strings.TrimPrefix(strings.ToLower(serial), "0x")
+	serial = strings.Replace(serial, ":", "", -1)
+	if _, err := hex.DecodeString(strings.Repeat("0", len(serial)%2) + serial); err != nil || serial == "" {
+		return "", fmt.Errorf("Invalid serial number `%s': must be hex", serial)
+	}
+	serial = strings.TrimLeft(serial, "0")
+	if serial == "" {
+		return "0", nil
+	}
+	if negative {
+		serial = "-" + serial
+	}
+	return serial, nil
+}
+
+func (matcher *SerialMatcher) Add(serial string, id string) error {
+	normalized, err := normalizeSerial(serial)
+	if err != nil {
+		return err
+	}
+	if matcher.serials == nil {
+		matcher.serials = make(map[string]string)
+	}
+	if id == "" {
+		id = serial
+	}
+	matcher.serials[normalized] = id
+	return nil
+}
+
+func (matcher *SerialMatcher) Match(info *EntryInfo) []Match {
+	if info.CertInfo == nil || info.CertInfo.SerialNumberParseError != nil {
+		return nil
+	}
+	serial := formatSerialNumber(info.CertInfo.SerialNumber)
+	if id, ok := matcher.serials[serial]; ok {
+		return []Match{{Category: MatchSerial, ID: id, Value: serial}}
+	}
+	return nil
+}
+
+// PubkeyMatcher matches certificates whose Subject Public Key Info has
+// a particular SHA-256 hash
+type PubkeyMatcher struct {
+	hashes map[string]string // hex hash => ID
+}
+
+func (matcher *PubkeyMatcher) Add(hash string, id string) error {
+	hash = strings.ToLower(strings.Replace(hash, ":", "", -1))
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+		return fmt.Errorf("Invalid public key hash `%s': must be a hex SHA-256 hash", hash)
+	}
+	if matcher.hashes == nil {
+		matcher.hashes = make(map[string]string)
+	}
+	if id == "" {
+		id = hash
+	}
+	matcher.hashes[hash] = id
+	return nil
+}
+
+func (matcher *PubkeyMatcher) Contains(hash string) bool {
+	_, ok := matcher.hashes[hash]
+	return ok
+}
+
+func (matcher *PubkeyMatcher) Match(info *EntryInfo) []Match {
+	if info.CertInfo == nil {
+		return nil
+	}
+	hash := info.CertInfo.PubkeyHash()
+	if id, ok := matcher.hashes[hash]; ok {
+		return []Match{{Category: MatchPubkey, ID: id, Value: hash}}
+	}
+	return nil
+}
+
+// KeyBlocklist matches certificates whose public key is known to be
+// compromised, such as the Debian weak keys or those published by
+// pwnedkeys.com, identified by the SHA-256 hash of their Subject Public
+// Key Info
+type KeyBlocklist struct {
+	keys PubkeyMatcher
+}
+
+func (blocklist *KeyBlocklist) Add(hash string, id string) error {
+	if id == "" {
+		id = MatchCompromisedKey
+	}
+	return blocklist.keys.Add(hash, id)
+}
+
+func (blocklist *KeyBlocklist) Match(info *EntryInfo) []Match {
+	matches := blocklist.keys.Match(info)
+	for i := range matches {
+		matches[i].Category = MatchCompromisedKey
+	}
+	return matches
+}
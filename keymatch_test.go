@@ -0,0 +1,55 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeSerial(t *testing.T) {
+	for input, expected := range map[string]string{
+		"0a1b":          "a1b",
+		"0x0A:1B":       "a1b",
+		"00:00":         "0",
+		"-0ff":          "-ff",
+		"03b1b1579d6f0": "3b1b1579d6f0",
+	} {
+		if actual, err := normalizeSerial(input); err != nil {
+			t.Errorf("normalizeSerial(%q): %s", input, err)
+		} else if actual != expected {
+			t.Errorf("normalizeSerial(%q) = %q, expected %q", input, actual, expected)
+		}
+	}
+	for _, input := range []string{"", "0x", "xyz"} {
+		if _, err := normalizeSerial(input); err == nil {
+			t.Errorf("normalizeSerial(%q) should have failed", input)
+		}
+	}
+}
+
+func TestKeyBlocklist(t *testing.T) {
+	info := &EntryInfo{CertInfo: makeTestCertInfo(t, nil, nil, nil)}
+	hash := info.CertInfo.PubkeyHash()
+
+	blocklist := new(KeyBlocklist)
+	list := "# pwnedkeys\n" + strings.ToUpper(hash) + "\n" + strings.Repeat("ab", 32) + " debian-weak\n"
+	if err := ReadIdentifierList(strings.NewReader(list), blocklist.Add); err != nil {
+		t.Fatal(err)
+	}
+	matches := blocklist.Match(info)
+	if len(matches) != 1 || matches[0].Category != MatchCompromisedKey || matches[0].ID != MatchCompromisedKey || matches[0].Value != hash {
+		t.Errorf("Wrong matches: %+v", matches)
+	}
+
+	if err := blocklist.Add("abcd", ""); err == nil {
+		t.Errorf("Adding a short hash should have failed")
+	}
+}
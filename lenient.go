@@ -0,0 +1,239 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// Many logged certificates violate DER or have other structural problems
+// which make ParseCertificate and ParseTBSCertificate fail.  The lenient
+// parsers below decode such certificates on a best-effort basis: each field
+// of the TBS is decoded separately, so a problem with one field doesn't
+// prevent the others from being used.  Fields which can't be found are left
+// empty, and the per-field Parse* methods return errors for them.
+
+func isSequence(value *asn1.RawValue) bool {
+	return value.Class == asn1.ClassUniversal && value.Tag == asn1.TagSequence && value.IsCompound
+}
+
+// parseSequenceLenient decodes the outer SEQUENCE of |data| and returns its
+// elements.  Trailing data, and elements after an undecodable one, are
+// reported as errors.  complete is false if there were elements which
+// couldn't be decoded.
+func parseSequenceLenient(data []byte, what string) (seq *asn1.RawValue, elements []asn1.RawValue, errs []error, complete bool) {
+	seq = new(asn1.RawValue)
+	if rest, err := asn1.Unmarshal(data, seq); err != nil {
+		return nil, nil, []error{fmt.Errorf("failed to parse %s: %s", what, err)}, false
+	} else if len(rest) > 0 {
+		errs = append(errs, fmt.Errorf("%d bytes of trailing data after %s", len(rest), what))
+	}
+	if !isSequence(seq) {
+		return nil, nil, append(errs, fmt.Errorf("%s is not a SEQUENCE", what)), false
+	}
+	for data := seq.Bytes; len(data) > 0; {
+		var element asn1.RawValue
+		rest, err := asn1.Unmarshal(data, &element)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse element %d of %s: %s", len(elements), what, err))
+			return seq, elements, errs, false
+		}
+		elements = append(elements, element)
+		data = rest
+	}
+	return seq, elements, errs, true
+}
+
+// ParseCertificateLenient is like ParseCertificate, but tolerates
+// structural problems, which are returned as a list of errors.  The
+// returned Certificate is nil only if the TBSCertificate couldn't be found.
+func ParseCertificateLenient(certBytes []byte) (*Certificate, []error) {
+	cert, strictErr := ParseCertificate(certBytes)
+	if strictErr == nil {
+		return cert, nil
+	}
+	seq, elements, errs, _ := parseSequenceLenient(certBytes, "certificate")
+	if seq == nil || len(elements) == 0 || !isSequence(&elements[0]) {
+		return nil, append(errs, errors.New("certificate does not contain a TBSCertificate"))
+	}
+	cert = &Certificate{Raw: seq.FullBytes, TBSCertificate: elements[0]}
+	if len(elements) > 1 {
+		cert.SignatureAlgorithm = elements[1]
+	}
+	if len(elements) > 2 {
+		cert.SignatureValue = elements[2]
+	}
+	if len(elements) != 3 {
+		errs = append(errs, fmt.Errorf("certificate has %d elements instead of 3", len(elements)))
+	}
+	if len(errs) == 0 {
+		errs = append(errs, strictErr)
+	}
+	return cert, errs
+}
+
+// parseExtensionLenient decodes an Extension, accepting non-DER BOOLEANs
+// and an explicitly-encoded default criticality
+func parseExtensionLenient(value *asn1.RawValue) (Extension, error) {
+	var ext Extension
+	if !isSequence(value) {
+		return ext, errors.New("extension is not a SEQUENCE")
+	}
+	var elements []asn1.RawValue
+	for data := value.Bytes; len(data) > 0; {
+		var element asn1.RawValue
+		rest, err := asn1.Unmarshal(data, &element)
+		if err != nil {
+			return ext, fmt.Errorf("failed to parse extension: %s", err)
+		}
+		elements = append(elements, element)
+		data = rest
+	}
+	if len(elements) == 0 {
+		return ext, errors.New("extension is empty")
+	}
+	if _, err := asn1.Unmarshal(elements[0].FullBytes, &ext.Id); err != nil {
+		return ext, fmt.Errorf("failed to parse extension OID: %s", err)
+	}
+	elements = elements[1:]
+	if len(elements) > 0 && elements[0].Class == asn1.ClassUniversal && elements[0].Tag == asn1.TagBoolean {
+		ext.Critical = len(elements[0].Bytes) > 0 && elements[0].Bytes[0] != 0
+		elements = elements[1:]
+	}
+	if len(elements) != 1 || elements[0].Class != asn1.ClassUniversal || elements[0].Tag != asn1.TagOctetString {
+		return ext, fmt.Errorf("extension %s has no OCTET STRING value", ext.Id)
+	}
+	ext.Value = elements[0].Bytes
+	return ext, nil
+}
+
+// parseTBSCertificateLenient returns the decoded TBS (nil if not even the
+// outer SEQUENCE could be decoded), the problems encountered, and whether
+// all extensions were decoded.  If they weren't, the SANs and basic
+// constraints can't be trusted to be complete.
+func parseTBSCertificateLenient(tbsBytes []byte) (*TBSCertificate, []error, bool) {
+	tbs, strictErr := ParseTBSCertificate(tbsBytes)
+	if strictErr == nil {
+		return tbs, nil, true
+	}
+	// If an element couldn't be decoded, the elements after it, such as
+	// the extensions, are missing
+	seq, elements, errs, extensionsComplete := parseSequenceLenient(tbsBytes, "TBS")
+	if seq == nil {
+		return nil, errs, false
+	}
+
+	tbs = &TBSCertificate{Raw: seq.FullBytes, Version: 1}
+	fields := []*asn1.RawValue{&tbs.SerialNumber, &tbs.SignatureAlgorithm, &tbs.Issuer, &tbs.Validity, &tbs.Subject, &tbs.PublicKey}
+	fieldNames := []string{"serial number", "signature algorithm", "issuer", "validity", "subject", "public key"}
+	nextField := 0
+
+	for i := range elements {
+		element := &elements[i]
+		if element.Class != asn1.ClassContextSpecific {
+			if nextField < len(fields) {
+				*fields[nextField] = *element
+				nextField++
+			} else {
+				errs = append(errs, fmt.Errorf("unexpected element %d in TBS", i))
+			}
+			continue
+		}
+		switch element.Tag {
+		case 0:
+			if _, err := asn1.Unmarshal(element.Bytes, &tbs.Version); err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse version: %s", err))
+			}
+		case 1, 2:
+			// Unique identifiers are obsolete and ignored
+		case 3:
+			_, extensions, extErrs, complete := parseSequenceLenient(element.Bytes, "extensions")
+			if !complete {
+				extensionsComplete = false
+			}
+			errs = append(errs, extErrs...)
+			for j := range extensions {
+				ext, err := parseExtensionLenient(&extensions[j])
+				if err != nil {
+					extensionsComplete = false
+					errs = append(errs, err)
+					continue
+				}
+				tbs.Extensions = append(tbs.Extensions, ext)
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unexpected element [%d] in TBS", element.Tag))
+		}
+	}
+	for _, name := range fieldNames[nextField:] {
+		errs = append(errs, fmt.Errorf("TBS has no %s", name))
+	}
+	if len(errs) == 0 {
+		// The strict parser rejected it for a reason we didn't notice
+		errs = append(errs, strictErr)
+	}
+	return tbs, errs, extensionsComplete
+}
+
+// ParseTBSCertificateLenient is like ParseTBSCertificate, but tolerates
+// structural problems, which are returned as a list of errors.  The
+// returned TBSCertificate is nil only if it couldn't be decoded at all.
+func ParseTBSCertificateLenient(tbsBytes []byte) (*TBSCertificate, []error) {
+	tbs, errs, _ := parseTBSCertificateLenient(tbsBytes)
+	return tbs, errs
+}
+
+// MakeCertInfoFromLogEntryLenient is like MakeCertInfoFromLogEntry, but
+// decodes malformed certificates on a best-effort basis.  The problems are
+// recorded in the CertInfo's StructureErrors, and an error is returned only
+// if nothing could be decoded.
+func MakeCertInfoFromLogEntryLenient(entry *ct.LogEntry) (*CertInfo, error) {
+	if info, err := MakeCertInfoFromLogEntry(entry); err == nil {
+		return info, nil
+	} else if entry.Leaf.TimestampedEntry.EntryType != ct.X509LogEntryType && entry.Leaf.TimestampedEntry.EntryType != ct.PrecertLogEntryType {
+		return nil, err
+	}
+
+	var errs []error
+	var tbsBytes []byte
+	if entry.Leaf.TimestampedEntry.EntryType == ct.X509LogEntryType {
+		var cert *Certificate
+		cert, errs = ParseCertificateLenient(entry.Leaf.TimestampedEntry.X509Entry)
+		if cert == nil {
+			return nil, errs[len(errs)-1]
+		}
+		tbsBytes = cert.GetRawTBSCertificate()
+	} else {
+		tbsBytes = entry.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate
+	}
+
+	tbs, tbsErrs, extensionsComplete := parseTBSCertificateLenient(tbsBytes)
+	errs = append(errs, tbsErrs...)
+	if tbs == nil {
+		return nil, errs[len(errs)-1]
+	}
+
+	info := MakeCertInfoFromTBS(tbs)
+	info.StructureErrors = errs
+	if !extensionsComplete {
+		incomplete := errors.New("some extensions could not be parsed")
+		if info.SANsParseError == nil {
+			info.SANs, info.SANsParseError = nil, incomplete
+		}
+		if info.IsCAParseError == nil {
+			info.IsCA, info.IsCAParseError = nil, incomplete
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+func makeTestTBS(t *testing.T) []byte {
+	cert, _ := makeTestCert(t, nil, nil, func(template *x509.Certificate) {
+		template.NotBefore = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+		template.NotAfter = time.Date(2017, 4, 1, 0, 0, 0, 0, time.UTC)
+		template.ExtKeyUsage = nil
+		template.BasicConstraintsValid = false
+	})
+	parsed, err := ParseCertificate(cert.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.GetRawTBSCertificate()
+}
+
+// makeTBS encodes |elements| as a TBS SEQUENCE
+func makeTBS(t *testing.T, elements []asn1.RawValue) []byte {
+	var content []byte
+	for _, element := range elements {
+		content = append(content, element.FullBytes...)
+	}
+	tbs, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tbs
+}
+
+func lenientCertInfo(t *testing.T, tbs []byte) *CertInfo {
+	entry := &ct.LogEntry{}
+	entry.Leaf.TimestampedEntry.EntryType = ct.PrecertLogEntryType
+	entry.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate = tbs
+	info, err := MakeCertInfoFromLogEntryLenient(entry)
+	if err != nil {
+		t.Fatalf("MakeCertInfoFromLogEntryLenient: %s", err)
+	}
+	return info
+}
+
+func TestLenientTrailingData(t *testing.T) {
+	tbs := append(makeTestTBS(t), 0, 0)
+	if _, err := ParseTBSCertificate(tbs); err == nil {
+		t.Fatal("strict parser accepted trailing data")
+	}
+	info := lenientCertInfo(t, tbs)
+	if len(info.StructureErrors) != 1 {
+		t.Errorf("expected 1 structure error, got %v", info.StructureErrors)
+	}
+	if info.SerialNumberParseError != nil || info.SerialNumber.Int64() != 1234 {
+		t.Errorf("wrong serial number: %v (%v)", info.SerialNumber, info.SerialNumberParseError)
+	}
+	if info.ValidityParseError != nil || !info.NotAfter().Equal(time.Date(2017, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong validity: %v (%v)", info.Validity, info.ValidityParseError)
+	}
+	if identifiers, err := info.ParseIdentifiers(); err != nil || len(identifiers.DNSNames) != 1 {
+		t.Errorf("wrong identifiers: %v (%v)", identifiers, err)
+	}
+}
+
+func TestLenientBadExtension(t *testing.T) {
+	_, elements, _, _ := parseSequenceLenient(makeTestTBS(t), "TBS")
+	last := &elements[len(elements)-1]
+	if last.Class != asn1.ClassContextSpecific || last.Tag != 3 {
+		t.Fatalf("test TBS does not end with extensions")
+	}
+	// Replace the extensions with a SEQUENCE containing a non-SEQUENCE
+	extensions, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: []byte{0x04, 0x00}})
+	*last = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: extensions}
+	last.FullBytes, _ = asn1.Marshal(*last)
+
+	info := lenientCertInfo(t, makeTBS(t, elements))
+	if len(info.StructureErrors) == 0 {
+		t.Error("no structure errors reported")
+	}
+	if info.SubjectParseError != nil {
+		t.Errorf("subject not parsed: %s", info.SubjectParseError)
+	}
+	if info.SANsParseError == nil {
+		t.Error("SANs considered complete despite unparsable extension")
+	}
+}
+
+func TestLenientMissingFields(t *testing.T) {
+	_, elements, _, _ := parseSequenceLenient(makeTestTBS(t), "TBS")
+	// Keep only the version, serial number, signature algorithm, and issuer
+	info := lenientCertInfo(t, makeTBS(t, elements[:4]))
+	if info.IssuerParseError != nil {
+		t.Errorf("issuer not parsed: %s", info.IssuerParseError)
+	}
+	if info.ValidityParseError == nil || info.SubjectParseError == nil {
+		t.Error("missing fields parsed successfully")
+	}
+}
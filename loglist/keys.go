@@ -0,0 +1,49 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package loglist
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+)
+
+// Where Google publishes the key which signs its log list
+const ChromeKeyURL = "https://www.gstatic.com/ct/log_list/v3/log_list_pubkey.pem"
+
+// pinnedKeys maps the URLs of the well-known log lists to the keys which
+// sign them, as base64 DER SubjectPublicKeyInfo.  A list listed here must
+// be signed by its key unless another is given explicitly.
+var pinnedKeys = map[string]string{}
+
+// IsWellKnown returns true if |url| is the URL of Google's or Apple's log
+// list, whose signatures must always be verified
+func IsWellKnown(url string) bool {
+	return url == ChromeURL || url == AppleURL
+}
+
+// PinnedKey returns the key built into Cert Spotter which signs the log list
+// at |url|, or nil if there isn't one
+func PinnedKey(url string) (crypto.PublicKey, error) {
+	encoded, ok := pinnedKeys[url]
+	if !ok {
+		return nil, nil
+	}
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Pinned key for %s is malformed: %s", url, err)
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Pinned key for %s is malformed: %s", url, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,292 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package loglist downloads and parses log lists in the v3 format published
+// by Google (for Chrome) and Apple, so that the logs to monitor don't have to
+// be maintained by hand.
+package loglist
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const (
+	ChromeURL = "https://www.gstatic.com/ct/log_list/v3/log_list.json"
+	AppleURL  = "https://valid.apple.com/ct/log_list/current_log_list.json"
+)
+
+type List struct {
+	Version          string     `json:"version"`
+	LogListTimestamp time.Time  `json:"log_list_timestamp"`
+	Operators        []Operator `json:"operators"`
+}
+
+type Operator struct {
+	Name      string     `json:"name"`
+	Email     []string   `json:"email"`
+	Logs      []Log      `json:"logs"`
+	TiledLogs []TiledLog `json:"tiled_logs"`
+}
+
+// Log is an RFC 6962 log
+type Log struct {
+	Description      string                        `json:"description"`
+	LogID            []byte                        `json:"log_id"`
+	Key              []byte                        `json:"key"`
+	URL              string                        `json:"url"`
+	MMD              int                           `json:"mmd"` // seconds
+	State            *State                        `json:"state"`
+	TemporalInterval *certspotter.TemporalInterval `json:"temporal_interval"`
+}
+
+// TiledLog is a log implementing the static-ct-api, which serves its tree
+// as tiles from MonitoringURL
+type TiledLog struct {
+	Description      string                        `json:"description"`
+	LogID            []byte                        `json:"log_id"`
+	Key              []byte                        `json:"key"`
+	SubmissionURL    string                        `json:"submission_url"`
+	MonitoringURL    string                        `json:"monitoring_url"`
+	MMD              int                           `json:"mmd"` // seconds
+	State            *State                        `json:"state"`
+	TemporalInterval *certspotter.TemporalInterval `json:"temporal_interval"`
+}
+
+// State is a log's state in the list.  Only one of the fields is set.
+type State struct {
+	Pending   *StateInfo         `json:"pending"`
+	Qualified *StateInfo         `json:"qualified"`
+	Usable    *StateInfo         `json:"usable"`
+	ReadOnly  *ReadOnlyStateInfo `json:"readonly"`
+	Retired   *StateInfo         `json:"retired"`
+	Rejected  *StateInfo         `json:"rejected"`
+}
+
+type StateInfo struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type ReadOnlyStateInfo struct {
+	StateInfo
+	FinalTreeHead certspotter.FinalTreeHead `json:"final_tree_head"`
+}
+
+// Name returns the name of the state (e.g. certspotter.LogUsable), or the
+// empty string if no state is set
+func (state *State) Name() string {
+	switch {
+	case state == nil:
+		return ""
+	case state.Pending != nil:
+		return certspotter.LogPending
+	case state.Qualified != nil:
+		return certspotter.LogQualified
+	case state.Usable != nil:
+		return certspotter.LogUsable
+	case state.ReadOnly != nil:
+		return certspotter.LogReadOnly
+	case state.Retired != nil:
+		return certspotter.LogRetired
+	case state.Rejected != nil:
+		return certspotter.LogRejected
+	default:
+		return ""
+	}
+}
+
+// Parse parses a log list, checking that each log's ID matches its key
+func Parse(data []byte) (*List, error) {
+	list := new(List)
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, err
+	}
+	for _, operator := range list.Operators {
+		for _, log := range operator.Logs {
+			if err := checkLogID(log.LogID, log.Key); err != nil {
+				return nil, fmt.Errorf("Log %s: %s", log.URL, err)
+			}
+		}
+		for _, log := range operator.TiledLogs {
+			if err := checkLogID(log.LogID, log.Key); err != nil {
+				return nil, fmt.Errorf("Log %s: %s", log.MonitoringURL, err)
+			}
+		}
+	}
+	return list, nil
+}
+
+func checkLogID(logID []byte, key []byte) error {
+	if len(key) == 0 {
+		return fmt.Errorf("no key")
+	}
+	sum := sha256.Sum256(key)
+	if !bytes.Equal(logID, sum[:]) {
+		return fmt.Errorf("log_id doesn't match key")
+	}
+	return nil
+}
+
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// Fetch downloads the log list at |url|, or reads it from a file if |url|
+// isn't an http:// or https:// URL
+func Fetch(url string) (*List, error) {
+	data, err := fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	list, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", url, err)
+	}
+	return list, nil
+}
+
+// ErrInvalidSignature is returned by FetchSigned and VerifySignature if the
+// log list isn't signed by the key
+var ErrInvalidSignature = errors.New("log list signature is invalid")
+
+// FetchSigned is like Fetch, but first checks that the list is signed by
+// |key|, with the signature at |signatureURL|.  Google signs its list with
+// RSA (see SignatureURL).
+func FetchSigned(url string, signatureURL string, key crypto.PublicKey) (*List, error) {
+	data, err := fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := fetch(signatureURL)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching signature: %s", err)
+	}
+	if err := VerifySignature(data, signature, key); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	list, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", url, err)
+	}
+	return list, nil
+}
+
+// SignatureURL returns the URL of the signature of the log list at |url|,
+// which is published alongside it with a .sig extension instead of .json
+func SignatureURL(url string) string {
+	return strings.TrimSuffix(url, ".json") + ".sig"
+}
+
+// VerifySignature checks that |signature| is a signature of |data| by |key|:
+// a PKCS #1 v1.5 signature if |key| is RSA, or an ASN.1 one if it's ECDSA,
+// of the data's SHA-256 hash
+func VerifySignature(data []byte, signature []byte, key crypto.PublicKey) error {
+	digest := sha256.Sum256(data)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		var ecdsaSignature struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(signature, &ecdsaSignature); err != nil || len(rest) != 0 {
+			return fmt.Errorf("%w: malformed ASN.1", ErrInvalidSignature)
+		}
+		if !ecdsa.Verify(key, digest[:], ecdsaSignature.R, ecdsaSignature.S) {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("unsupported log list key type %T", key)
+	}
+	return nil
+}
+
+func fetch(url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return ioutil.ReadFile(url)
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "certspotter")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Error reading response: %s", url, err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return data, nil
+}
+
+// LogInfo converts the log to a LogInfo
+func (log *Log) LogInfo() certspotter.LogInfo {
+	info := certspotter.LogInfo{
+		Description:      log.Description,
+		Key:              log.Key,
+		Url:              strings.TrimSuffix(strings.TrimPrefix(log.URL, "https://"), "/"),
+		MMD:              log.MMD,
+		State:            log.State.Name(),
+		TemporalInterval: log.TemporalInterval,
+	}
+	if log.State != nil && log.State.ReadOnly != nil {
+		finalTreeHead := log.State.ReadOnly.FinalTreeHead
+		info.FinalTreeHead = &finalTreeHead
+	}
+	return info
+}
+
+// LogInfo converts the log to a LogInfo, whose Url is the log's monitoring
+// prefix
+func (log *TiledLog) LogInfo() certspotter.LogInfo {
+	info := (&Log{
+		Description:      log.Description,
+		Key:              log.Key,
+		URL:              log.MonitoringURL,
+		MMD:              log.MMD,
+		State:            log.State,
+		TemporalInterval: log.TemporalInterval,
+	}).LogInfo()
+	info.Tiled = true
+	return info
+}
+
+// LogInfos returns the list's logs, both RFC 6962 and tiled
+func (list *List) LogInfos() []certspotter.LogInfo {
+	var infos []certspotter.LogInfo
+	for _, operator := range list.Operators {
+		for i := range operator.Logs {
+			infos = append(infos, operator.Logs[i].LogInfo())
+		}
+		for i := range operator.TiledLogs {
+			infos = append(infos, operator.TiledLogs[i].LogInfo())
+		}
+	}
+	return infos
+}
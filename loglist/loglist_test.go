@@ -0,0 +1,218 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package loglist
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const testList = `{
+  "version": "1.0",
+  "log_list_timestamp": "2017-06-01T00:00:00Z",
+  "operators": [
+    {
+      "name": "Google",
+      "email": ["google-ct-logs@googlegroups.com"],
+      "logs": [
+        {
+          "description": "Google 'Pilot' log",
+          "log_id": "pLkJkLQYWBSHuxOizGdwCjw1mAT5G9+443fNDsgN3BA=",
+          "key": "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEfahLEimAoz2t01p3uMziiLOl/fHTDM0YDOhBRuiBARsV4UvxG2LdNgoIGLrtCzWE0J5APC2em4JlvR8EEEFMoA==",
+          "url": "https://ct.googleapis.com/pilot/",
+          "mmd": 86400,
+          "state": {"usable": {"timestamp": "2014-09-01T00:00:00Z"}},
+          "temporal_interval": {
+            "start_inclusive": "2017-01-01T00:00:00Z",
+            "end_exclusive": "2018-01-01T00:00:00Z"
+          }
+        },
+        {
+          "description": "Google 'Aviator' log",
+          "log_id": "aPaY+B9kgr46jO65KB1M/HFRXWeT1ETRCmesu09P+8Q=",
+          "key": "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE1/TMabLkDpCjiupacAlP7xNi0I1JYP8bQFAHDG1xhtolSY1l4QgNRzRrvSe8liE+NPWHdjGxfx3JhTsN9x8/6Q==",
+          "url": "https://ct.googleapis.com/aviator/",
+          "mmd": 86400,
+          "state": {
+            "readonly": {
+              "timestamp": "2016-11-30T13:24:18Z",
+              "final_tree_head": {
+                "sha256_root_hash": "LcGcZRsm+LGYmrlyC5LXhV1T6OD8iH5dNlb0sEJl9bA=",
+                "tree_size": 46466472
+              }
+            }
+          }
+        }
+      ],
+      "tiled_logs": [
+        {
+          "description": "Example tiled log",
+          "log_id": "pLkJkLQYWBSHuxOizGdwCjw1mAT5G9+443fNDsgN3BA=",
+          "key": "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEfahLEimAoz2t01p3uMziiLOl/fHTDM0YDOhBRuiBARsV4UvxG2LdNgoIGLrtCzWE0J5APC2em4JlvR8EEEFMoA==",
+          "submission_url": "https://example.com/2025h1/",
+          "monitoring_url": "https://example.net/2025h1/",
+          "mmd": 60,
+          "state": {"usable": {"timestamp": "2017-01-01T00:00:00Z"}}
+        }
+      ]
+    }
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	list, err := Parse([]byte(testList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos := list.LogInfos()
+	if len(infos) != 3 {
+		t.Fatalf("Expected 3 logs, got %d", len(infos))
+	}
+	if infos[0].Url != "ct.googleapis.com/pilot" || infos[0].MMD != 86400 || infos[0].Description != "Google 'Pilot' log" || infos[0].State != certspotter.LogUsable || infos[0].FinalTreeHead != nil {
+		t.Errorf("Wrong LogInfo: %+v", infos[0])
+	}
+	if infos[1].State != certspotter.LogReadOnly || infos[1].FinalTreeHead == nil || infos[1].FinalTreeHead.TreeSize != 46466472 || len(infos[1].FinalTreeHead.SHA256RootHash) != 32 {
+		t.Errorf("Wrong LogInfo: %+v", infos[1])
+	}
+	if infos[2].Url != "example.net/2025h1" || !infos[2].Tiled || infos[2].MMD != 60 || infos[2].State != certspotter.LogUsable {
+		t.Errorf("Wrong LogInfo: %+v", infos[2])
+	}
+	log := list.Operators[0].Logs[0]
+	if log.State == nil || log.State.Usable == nil || !log.State.Usable.Timestamp.Equal(time.Date(2014, 9, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Wrong state: %+v", log.State)
+	}
+	if !log.TemporalInterval.Contains(time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Interval should contain 2017-06-01")
+	}
+	if log.TemporalInterval.Contains(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Interval shouldn't contain its end")
+	}
+}
+
+func TestParseWrongLogID(t *testing.T) {
+	data := strings.Replace(testList, "pLkJkLQYWBSHuxOizGdwCjw1mAT5G9+443fNDsgN3BA=", "AAAAkLQYWBSHuxOizGdwCjw1mAT5G9+443fNDsgN3BA=", 1)
+	if _, err := Parse([]byte(data)); err == nil {
+		t.Errorf("Log with the wrong ID was accepted")
+	}
+}
+
+func TestFetchSigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loglist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	listPath := filepath.Join(dir, "log_list.json")
+	if err := ioutil.WriteFile(listPath, []byte(testList), 0666); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(testList))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(SignatureURL(listPath), signature, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if SignatureURL(listPath) != filepath.Join(dir, "log_list.sig") {
+		t.Errorf("Wrong signature URL: %s", SignatureURL(listPath))
+	}
+	if _, err := FetchSigned(listPath, SignatureURL(listPath), &rsaKey.PublicKey); err != nil {
+		t.Errorf("Signed list was refused: %s", err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FetchSigned(listPath, SignatureURL(listPath), &otherKey.PublicKey); err == nil {
+		t.Errorf("List signed by the wrong key was accepted")
+	}
+	if err := ioutil.WriteFile(listPath, []byte(strings.Replace(testList, "Pilot", "Copilot", 1)), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FetchSigned(listPath, SignatureURL(listPath), &rsaKey.PublicKey); err == nil {
+		t.Errorf("Modified list was accepted")
+	}
+}
+
+func TestVerifyECDSASignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(testList))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignature([]byte(testList), signature, &key.PublicKey); err != nil {
+		t.Errorf("Valid signature was refused: %s", err)
+	}
+	if err := VerifySignature([]byte(testList+" "), signature, &key.PublicKey); err == nil {
+		t.Errorf("Signature of different data was accepted")
+	}
+}
+
+func TestPinnedKeys(t *testing.T) {
+	for url := range pinnedKeys {
+		if !IsWellKnown(url) {
+			t.Errorf("Key pinned for %s, which isn't a well-known list", url)
+		}
+		if key, err := PinnedKey(url); err != nil || key == nil {
+			t.Errorf("Pinned key for %s: %v", url, err)
+		}
+	}
+	if key, err := PinnedKey("https://ct.example.com/log_list.json"); key != nil || err != nil {
+		t.Errorf("Key found for an unknown list")
+	}
+}
+
+func TestFetchSignedInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loglist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	listPath := filepath.Join(dir, "log_list.json")
+	if err := ioutil.WriteFile(listPath, []byte(testList), 0666); err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, signature := range [][]byte{[]byte("garbage"), {0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01}} {
+		if err := ioutil.WriteFile(SignatureURL(listPath), signature, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := FetchSigned(listPath, SignatureURL(listPath), &key.PublicKey); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Signature %x: got %v instead of ErrInvalidSignature", signature, err)
+		}
+	}
+}
@@ -10,20 +10,144 @@
 package certspotter
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type LogInfoFile struct {
 	Logs []LogInfo `json:"logs"`
 }
 type LogInfo struct {
-	Description string `json:"description"`
-	Key         []byte `json:"key"`
-	Url         string `json:"url"`
-	MMD         int    `json:"maximum_merge_delay"`
+	Description   string         `json:"description"`
+	Key           []byte         `json:"key"`
+	Url           string         `json:"url"`
+	MMD           int            `json:"maximum_merge_delay"`
+	State         string         `json:"state,omitempty"`           // from a log list, or empty if unknown
+	FinalTreeHead *FinalTreeHead `json:"final_tree_head,omitempty"` // if State is LogReadOnly
+	Tiled         bool           `json:"tiled,omitempty"`           // if the log implements the static-ct-api, in which case Url is its monitoring prefix
+	CTVersion     int            `json:"ct_version,omitempty"`      // 2 if the log implements RFC 9162, or 0 or 1 for RFC 6962
+
+	// The range of expiry dates of the certificates accepted by the log, if
+	// it's temporally sharded
+	TemporalInterval *TemporalInterval `json:"temporal_interval,omitempty"`
+}
+
+// TemporalInterval is the range of expiry dates of the certificates accepted
+// by a temporally sharded log
+type TemporalInterval struct {
+	StartInclusive time.Time `json:"start_inclusive"`
+	EndExclusive   time.Time `json:"end_exclusive"`
+}
+
+// Contains returns true if the interval contains |t|
+func (interval *TemporalInterval) Contains(t time.Time) bool {
+	return !t.Before(interval.StartInclusive) && t.Before(interval.EndExclusive)
+}
+
+// The states of a log in a log list
+const (
+	LogPending   = "pending"
+	LogQualified = "qualified"
+	LogUsable    = "usable"
+	LogReadOnly  = "readonly"
+	LogRetired   = "retired"
+	LogRejected  = "rejected"
+)
+
+// FinalTreeHead is the tree head of a read-only log, which won't grow any more
+type FinalTreeHead struct {
+	TreeSize       uint64 `json:"tree_size"`
+	SHA256RootHash []byte `json:"sha256_root_hash"`
+}
+
+// IsMonitored returns false if the log's state means there's no point
+// monitoring it: it hasn't been accepted yet (pending), never will be
+// (rejected), or is no longer trusted (retired).  Read-only logs are
+// monitored until they've been scanned up to their final tree head.
+func (info *LogInfo) IsMonitored() bool {
+	switch info.State {
+	case LogPending, LogRejected, LogRetired:
+		return false
+	default:
+		return true
+	}
+}
+
+// Matches shard names like 2025, 2025h1, and 2025h2
+var shardNameRegexp = regexp.MustCompile(`^(20[0-9][0-9])(h[12])?$`)
+
+// shardName returns the submatches of shardNameRegexp for the first segment
+// of |logURL|'s path which names a shard, or nil if none does.  Years
+// elsewhere in the URL, such as in the hostname, aren't considered, since
+// they often name something other than a shard.
+func shardName(logURL string) []string {
+	segments := strings.Split(strings.ToLower(logURL), "/")
+	for _, segment := range segments[1:] {
+		if match := shardNameRegexp.FindStringSubmatch(segment); match != nil {
+			return match
+		}
+	}
+	return nil
+}
+
+// Shard returns the log's TemporalInterval.  If it isn't known, but a
+// segment of the log's URL path names a shard, like ct.example.com/2025h2/
+// (for certificates expiring in the second half of 2025), the interval is
+// inferred from the name.  Returns nil if the log doesn't seem to be
+// sharded.
+func (info *LogInfo) Shard() *TemporalInterval {
+	if info.TemporalInterval != nil {
+		return info.TemporalInterval
+	}
+	match := shardName(info.Url)
+	if match == nil {
+		return nil
+	}
+	year, _ := strconv.Atoi(match[1])
+	interval := &TemporalInterval{
+		StartInclusive: time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndExclusive:   time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	switch match[2] {
+	case "h1":
+		interval.EndExclusive = time.Date(year, time.July, 1, 0, 0, 0, 0, time.UTC)
+	case "h2":
+		interval.StartInclusive = time.Date(year, time.July, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return interval
+}
+
+// IsExpiredShard returns true if the log is a temporal shard for
+// certificates which have all expired as of |now|
+func (info *LogInfo) IsExpiredShard(now time.Time) bool {
+	shard := info.Shard()
+	return shard != nil && !now.Before(shard.EndExclusive)
+}
+
+// MergeLogs returns |logs| with the logs in |custom| added to them.  A custom
+// log with the same ID as one of |logs| takes its place.
+func MergeLogs(logs []LogInfo, custom []LogInfo) []LogInfo {
+	merged := append([]LogInfo{}, logs...)
+	for _, customLog := range custom {
+		replaced := false
+		for i := range merged {
+			if bytes.Equal(merged[i].ID(), customLog.ID()) {
+				merged[i] = customLog
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, customLog)
+		}
+	}
+	return merged
 }
 
 func (info *LogInfo) FullURI() string {
@@ -38,6 +162,10 @@ func (info *LogInfo) ParsedPublicKey() (crypto.PublicKey, error) {
 	}
 }
 
+func (info *LogInfo) MaximumMergeDelay() time.Duration {
+	return time.Duration(info.MMD) * time.Second
+}
+
 func (info *LogInfo) ID() []byte {
 	sum := sha256.Sum256(info.Key)
 	return sum[:]
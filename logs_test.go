@@ -0,0 +1,82 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestShard(t *testing.T) {
+	tests := []struct {
+		url   string
+		start time.Time
+		end   time.Time
+	}{
+		{"oak.ct.letsencrypt.org/2025h1", date(2025, time.January, 1), date(2025, time.July, 1)},
+		{"ct.example.com/logs/2025H2/", date(2025, time.July, 1), date(2026, time.January, 1)},
+		{"ct.example.com/2024/", date(2024, time.January, 1), date(2025, time.January, 1)},
+		{"ct.googleapis.com/logs/us1/argon2025h2", time.Time{}, time.Time{}},
+		{"yeti2025.ct.digicert.com/log", time.Time{}, time.Time{}},
+		{"2025.ct.example.com/log", time.Time{}, time.Time{}},
+		{"ct.example.com/log-2025-backup", time.Time{}, time.Time{}},
+		{"ct.example.com/v2025", time.Time{}, time.Time{}},
+		{"ct.googleapis.com/pilot", time.Time{}, time.Time{}},
+		{"ct.example.com/20251", time.Time{}, time.Time{}},
+	}
+	for _, test := range tests {
+		info := LogInfo{Url: test.url}
+		shard := info.Shard()
+		if test.end.IsZero() {
+			if shard != nil {
+				t.Errorf("%s: expected no shard, got %v", test.url, shard)
+			}
+			continue
+		}
+		if shard == nil || !shard.StartInclusive.Equal(test.start) || !shard.EndExclusive.Equal(test.end) {
+			t.Errorf("%s: wrong shard %v", test.url, shard)
+		}
+	}
+
+	info := LogInfo{Url: "ct.example.com/2025h2", TemporalInterval: &TemporalInterval{StartInclusive: date(2025, time.June, 1), EndExclusive: date(2025, time.December, 1)}}
+	if info.Shard() != info.TemporalInterval {
+		t.Errorf("TemporalInterval should take precedence over the URL")
+	}
+	if info.IsExpiredShard(date(2025, time.November, 30)) || !info.IsExpiredShard(date(2025, time.December, 1)) {
+		t.Errorf("Wrong IsExpiredShard")
+	}
+}
+
+func TestMergeLogs(t *testing.T) {
+	logs := []LogInfo{
+		{Key: []byte("key 1"), Url: "ct.example.com/1"},
+		{Key: []byte("key 2"), Url: "ct.example.com/2"},
+	}
+	custom := []LogInfo{
+		{Key: []byte("key 3"), Url: "ct.internal.example/log", Description: "Private log"},
+		{Key: []byte("key 1"), Url: "ct-mirror.example.com/1"},
+	}
+	merged := MergeLogs(logs, custom)
+	var urls []string
+	for _, log := range merged {
+		urls = append(urls, log.Url)
+	}
+	if got := strings.Join(urls, " "); got != "ct-mirror.example.com/1 ct.example.com/2 ct.internal.example/log" {
+		t.Errorf("Wrong merged logs: %s", got)
+	}
+	if logs[0].Url != "ct.example.com/1" {
+		t.Errorf("MergeLogs modified its argument")
+	}
+}
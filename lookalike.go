@@ -0,0 +1,231 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const MatchLookalike = "lookalike"
+
+const DefaultLookalikeThreshold = 0.7
+
+// Scores assigned by LookalikeDetector, from most to least suspicious
+const (
+	lookalikeScoreHomoglyph = 1.0  // same as the brand after mapping confusable characters
+	lookalikeScoreBrand     = 0.9  // the brand itself, under someone else's domain
+	lookalikeScoreTypo      = 0.85 // one edit away from the brand
+	lookalikeScoreKeyword   = 0.8  // the brand as a hyphen-separated word (e.g. paypal-login)
+	lookalikeScoreTypo2     = 0.7  // two edits away from a long brand
+	lookalikeScoreEmbedded  = 0.7  // the brand within a longer label (e.g. securepaypal)
+)
+
+// Characters which are commonly substituted for one another.  Every
+// character is mapped to the representative of its class, so two strings
+// are confusable if their skeletons are equal.
+var confusableRunes = map[rune]string{
+	'0': "o", '1': "l", 'i': "l", '|': "l", '3': "e", '4': "a", '5': "s", '7': "t", '8': "b", '9': "g",
+	// Cyrillic
+	'а': "a", 'в': "b", 'е': "e", 'ё': "e", 'к': "k", 'м': "m", 'н': "h", 'о': "o", 'р': "p",
+	'с': "c", 'т': "t", 'у': "y", 'х': "x", 'і': "l", 'ї': "l", 'ј': "j", 'ѕ': "s", 'ԁ': "d",
+	'ԛ': "q", 'ԝ': "w", 'һ': "h", 'ɡ': "g",
+	// Greek
+	'α': "a", 'β': "b", 'ε': "e", 'ι': "l", 'κ': "k", 'ν': "v", 'ο': "o", 'ρ': "p", 'τ': "t",
+	'υ': "u", 'χ': "x", 'ω': "w",
+	// Latin with diacritics
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ą': "a",
+	'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'ì': "l", 'í': "l", 'î': "l", 'ï': "l", 'ı': "l", 'ł': "l", 'ľ': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'ŕ': "r", 'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ť': "t", 'ţ': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// Sequences of ASCII characters which look like a single character
+var confusableSequences = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+
+// skeleton maps |label| (in Unicode) to a canonical form in which
+// confusable characters are identical
+func skeleton(label string) string {
+	var mapped []string
+	for _, r := range strings.ToLower(label) {
+		if replacement, ok := confusableRunes[r]; ok {
+			mapped = append(mapped, replacement)
+		} else {
+			mapped = append(mapped, string(r))
+		}
+	}
+	return confusableSequences.Replace(strings.Join(mapped, ""))
+}
+
+// editDistance returns the number of insertions, deletions, substitutions,
+// and transpositions of adjacent characters needed to turn a into b
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// d[i][j] is the distance between s[:i] and t[:j]
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, minInt(d[i][j-1]+1, d[i-1][j-1]+cost))
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type lookalikeBrand struct {
+	item     *WatchlistItem
+	label    string
+	skeleton string
+}
+
+// A LookalikeDetector matches DNS names which resemble, but aren't matched
+// by, domains on a watchlist, such as paypa1.com, paypal-login.com, or
+// раураl.com (in Cyrillic) for a watchlist containing paypal.com.  The
+// "brand" of each watchlist domain is its label preceding the public suffix;
+// brands under 4 characters long are ignored since too many names would
+// resemble them.
+type LookalikeDetector struct {
+	Watchlist *Watchlist
+	Threshold float64 // minimum score to report, from 0 to 1
+	brands    []lookalikeBrand
+}
+
+// NewLookalikeDetector creates a LookalikeDetector for the domains on
+// |watchlist|
+func NewLookalikeDetector(watchlist *Watchlist) *LookalikeDetector {
+	detector := &LookalikeDetector{Watchlist: watchlist, Threshold: DefaultLookalikeThreshold}
+	seen := make(map[string]bool)
+	for i := range watchlist.Items {
+		item := &watchlist.Items[i]
+		if item.Regexp != nil || item.IPNet != nil || item.Email || item.URI || len(item.Domain) == 0 {
+			continue
+		}
+		var labels []string
+		if item.AnyPublicSuffix {
+			labels = item.Domain
+		} else if labels = withoutPublicSuffix(strings.Join(item.Domain, ".")); labels == nil {
+			continue
+		}
+		brand := labels[len(labels)-1]
+		if unicodeBrand, err := idna.ToUnicode(brand); err == nil {
+			brand = unicodeBrand
+		}
+		if len([]rune(brand)) < 4 || strings.Contains(brand, "*") || seen[brand] {
+			continue
+		}
+		seen[brand] = true
+		detector.brands = append(detector.brands, lookalikeBrand{item: item, label: brand, skeleton: skeleton(brand)})
+	}
+	return detector
+}
+
+// scoreLabel returns how much |label| (in Unicode) resembles |brand|
+func scoreLabel(label string, brand *lookalikeBrand) float64 {
+	if label == brand.label {
+		return lookalikeScoreBrand
+	}
+	labelSkeleton := skeleton(label)
+	if labelSkeleton == brand.skeleton {
+		return lookalikeScoreHomoglyph
+	}
+	score := 0.0
+	brandLength := len([]rune(brand.skeleton))
+	switch distance := editDistance(labelSkeleton, brand.skeleton); {
+	case distance == 1 && brandLength >= 5:
+		score = lookalikeScoreTypo
+	case distance == 2 && brandLength >= 8:
+		score = lookalikeScoreTypo2
+	}
+	if score < lookalikeScoreKeyword {
+		for _, word := range strings.FieldsFunc(label, func(r rune) bool { return r == '-' || r == '_' }) {
+			if skeleton(word) == brand.skeleton {
+				return lookalikeScoreKeyword
+			}
+		}
+	}
+	if score < lookalikeScoreEmbedded && strings.Contains(labelSkeleton, brand.skeleton) {
+		score = lookalikeScoreEmbedded
+	}
+	return score
+}
+
+// Score returns the highest score of |dnsName| (in ASCII) against the brands
+// on the watchlist, and the item whose brand it resembles.  Names matched by
+// the watchlist itself are legitimate and score 0.
+func (detector *LookalikeDetector) Score(dnsName string) (float64, *WatchlistItem) {
+	dnsName = normalizeDomainPart(dnsName)
+	if detector.Watchlist.MatchDNSName(dnsName) != nil {
+		return 0, nil
+	}
+	labels := withoutPublicSuffix(dnsName)
+	if labels == nil {
+		labels = strings.Split(dnsName, ".")
+	}
+	bestScore := 0.0
+	var bestItem *WatchlistItem
+	for _, label := range labels {
+		if unicodeLabel, err := idna.ToUnicode(label); err == nil {
+			label = unicodeLabel
+		}
+		for i := range detector.brands {
+			if score := scoreLabel(label, &detector.brands[i]); score > bestScore {
+				bestScore = score
+				bestItem = detector.brands[i].item
+			}
+		}
+	}
+	return bestScore, bestItem
+}
+
+func (detector *LookalikeDetector) Match(info *EntryInfo) []Match {
+	if info.Identifiers == nil {
+		return []Match{{Category: MatchUnparsable}}
+	}
+	var matches []Match
+	for _, dnsName := range info.Identifiers.DNSNames {
+		if score, item := detector.Score(dnsName); score > 0 && score >= detector.Threshold {
+			matches = append(matches, Match{
+				Category:  MatchLookalike,
+				ID:        "lookalike:" + item.ID,
+				Pattern:   item.Pattern,
+				Value:     dnsName,
+				Score:     score,
+				Anomalies: info.Identifiers.DNSNameAnomalies[dnsName],
+			})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"paypal", "paypal", 0},
+		{"paypal", "paypall", 1},
+		{"paypal", "papyal", 1},
+		{"paypal", "pyapla", 2},
+		{"", "abc", 3},
+	}
+	for _, test := range tests {
+		if distance := editDistance(test.a, test.b); distance != test.distance {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d", test.a, test.b, distance, test.distance)
+		}
+	}
+}
+
+func TestLookalikeDetector(t *testing.T) {
+	watchlist, err := ReadWatchlist(strings.NewReader(".paypal.com\n.example.org\nabc.net\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	detector := NewLookalikeDetector(watchlist)
+	tests := []struct {
+		dnsName string
+		score   float64
+	}{
+		{"www.paypal.com", 0},                   // legitimate
+		{"paypa1.com", lookalikeScoreHomoglyph}, // digit for letter
+		{"раураl.com", lookalikeScoreHomoglyph}, // Cyrillic раураl
+		{"paypal.com.evil.net", lookalikeScoreBrand},
+		{"paypall.net", lookalikeScoreTypo},
+		{"paypa1-login.net", lookalikeScoreKeyword},
+		{"securepaypal.net", lookalikeScoreEmbedded},
+		{"abcd.net", 0}, // brands under 4 characters are ignored
+		{"unrelated.com", 0},
+	}
+	for _, test := range tests {
+		if score, _ := detector.Score(test.dnsName); score != test.score {
+			t.Errorf("Score(%q) = %.2f, expected %.2f", test.dnsName, score, test.score)
+		}
+	}
+}
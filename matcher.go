@@ -0,0 +1,124 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// Categories of Match
+const (
+	MatchUnparsable   = "unparsable" // the entry couldn't be parsed, so it might match
+	MatchDNSName      = "dns_name"
+	MatchIPAddress    = "ip_address"
+	MatchEmailAddress = "email_address"
+	MatchURI          = "uri"
+)
+
+// A Match describes why an entry matched
+type Match struct {
+	Category string  `json:"category"`
+	ID       string  `json:"id,omitempty"`      // identifies the watchlist item or other matcher
+	Pattern  string  `json:"pattern,omitempty"` // the watchlist item or other criterion that matched
+	Value    string  `json:"value,omitempty"`   // the part of the certificate that matched, such as a DNS name
+	Score    float64 `json:"score,omitempty"`   // for fuzzy matches, how close the match is, from 0 to 1
+
+	// Anomalies of the matched DNS name (see DNSNameRedacted etc.), which
+	// may be why it matched
+	Anomalies []string `json:"anomalies,omitempty"`
+}
+
+// A Matcher decides which entries are interesting
+type Matcher interface {
+	// Match returns the reasons that |info| matches, or nil if it doesn't
+	Match(info *EntryInfo) []Match
+}
+
+type MatcherFunc func(info *EntryInfo) []Match
+
+func (f MatcherFunc) Match(info *EntryInfo) []Match {
+	return f(info)
+}
+
+// Any returns a Matcher that matches entries matched by at least one of |matchers|
+func Any(matchers ...Matcher) Matcher {
+	return MatcherFunc(func(info *EntryInfo) []Match {
+		var matches []Match
+		for _, matcher := range matchers {
+			matches = append(matches, matcher.Match(info)...)
+		}
+		return matches
+	})
+}
+
+// All returns a Matcher that matches entries matched by every one of |matchers|
+func All(matchers ...Matcher) Matcher {
+	return MatcherFunc(func(info *EntryInfo) []Match {
+		var matches []Match
+		for _, matcher := range matchers {
+			m := matcher.Match(info)
+			if len(m) == 0 {
+				return nil
+			}
+			matches = append(matches, m...)
+		}
+		return matches
+	})
+}
+
+// MatchIDs returns the distinct IDs of the entry's matches, in order
+func (info *EntryInfo) MatchIDs() []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, match := range info.Matches {
+		if match.ID != "" && !seen[match.ID] {
+			seen[match.ID] = true
+			ids = append(ids, match.ID)
+		}
+	}
+	return ids
+}
+
+// MatchCategories returns the distinct categories of the entry's matches, in
+// order
+func (info *EntryInfo) MatchCategories() []string {
+	var categories []string
+	seen := make(map[string]bool)
+	for _, match := range info.Matches {
+		if match.Category != "" && !seen[match.Category] {
+			seen[match.Category] = true
+			categories = append(categories, match.Category)
+		}
+	}
+	return categories
+}
+
+// NewEntryInfo parses |entry| (from the log at |logUri|).  Malformed
+// certificates are parsed leniently; see MakeCertInfoFromLogEntryLenient.
+func NewEntryInfo(logUri string, entry *ct.LogEntry) *EntryInfo {
+	info := &EntryInfo{
+		LogUri:    logUri,
+		Entry:     entry,
+		IsPrecert: IsPrecert(entry),
+		FullChain: GetFullChain(entry),
+	}
+	info.CertInfo, info.ParseError = MakeCertInfoFromLogEntryLenient(entry)
+	if info.CertInfo != nil {
+		info.Identifiers, info.IdentifiersParseError = info.CertInfo.ParseIdentifiers()
+	}
+	return info
+}
+
+// MatchingCallback returns a ProcessCallback which parses each entry and
+// passes it to |callback| if it's matched by |matcher|.  The matches are
+// stored in the entry's Matches field.
+func MatchingCallback(matcher Matcher, callback func(*EntryInfo)) ProcessCallback {
+	return PipelineCallback(MatchStage(matcher), ProcessStage(callback))
+}
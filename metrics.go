@@ -0,0 +1,372 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics maintained by the Scanner, if ScannerOptions.Collector is set
+const (
+	MetricEntriesFetched   = "certspotter_entries_fetched_total"
+	MetricEntriesProcessed = "certspotter_entries_processed_total"
+	MetricFetchErrors      = "certspotter_fetch_errors_total" // also labeled with the client.ErrorClass
+	MetricParseErrors      = "certspotter_parse_errors_total"
+
+	// Histograms of get-entries requests, successful or not, and of the
+	// number of entries returned by the successful ones
+	MetricGetEntriesSeconds = "certspotter_get_entries_duration_seconds"
+	MetricGetEntriesSize    = "certspotter_get_entries_size_entries"
+
+	// Labeled with the worker as well as the log (see WorkerStats)
+	MetricWorkerEntries     = "certspotter_worker_entries_total"
+	MetricWorkerBusySeconds = "certspotter_worker_busy_seconds_total"
+	MetricWorkerIdleSeconds = "certspotter_worker_idle_seconds_total"
+)
+
+// Buckets (upper bounds) of MetricGetEntriesSeconds and MetricGetEntriesSize
+var (
+	GetEntriesSecondsBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	GetEntriesSizeBuckets    = []float64{1, 16, 32, 64, 128, 256, 512, 1000, 2000, 5000}
+)
+
+type metric struct {
+	kind       string // counter, gauge, or histogram
+	help       string
+	values     map[string]float64 // by formatted label set
+	buckets    []float64
+	histograms map[string]*histogram // by formatted label set, for histograms
+	labels     map[string][]string   // unformatted label sets, by formatted label set
+}
+
+type histogram struct {
+	counts []uint64 // of observations in each bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Collector collects metrics and exposes them in the Prometheus text
+// format, either over HTTP (it's an http.Handler) or via WriteTo, so that
+// library users can serve them from their own HTTP server.  Gather returns
+// them for other exporters, such as StatsDExporter.  Functions
+// registered with OnCollect are called before each collection to update
+// gauges which are computed rather than counted.  All methods may be called
+// on a nil *Collector, in which case they do nothing.
+type Collector struct {
+	mu         sync.Mutex
+	metrics    map[string]*metric
+	onCollect  []func(*Collector)
+	collecting sync.Mutex
+}
+
+func NewCollector() *Collector {
+	collector := &Collector{metrics: make(map[string]*metric)}
+	collector.Describe(MetricEntriesFetched, "counter", "Number of entries fetched from each log")
+	collector.Describe(MetricEntriesProcessed, "counter", "Number of entries from each log passed to the ProcessCallback")
+	collector.Describe(MetricFetchErrors, "counter", "Number of failed requests for entries to each log, by class of error (e.g. timeout, rate_limited, server, parse)")
+	collector.Describe(MetricParseErrors, "counter", "Number of entries from each log which couldn't be parsed")
+	collector.DescribeHistogram(MetricGetEntriesSeconds, "How long get-entries requests to each log took", GetEntriesSecondsBuckets)
+	collector.DescribeHistogram(MetricGetEntriesSize, "Number of entries returned by each get-entries request to each log", GetEntriesSizeBuckets)
+	collector.Describe(MetricWorkerEntries, "counter", "Number of entries handled by each scan worker (the fetcher or a processor)")
+	collector.Describe(MetricWorkerBusySeconds, "counter", "Time each scan worker spent working")
+	collector.Describe(MetricWorkerIdleSeconds, "counter", "Time each scan worker spent waiting for another (the fetcher for the processors, or a processor for the fetcher)")
+	return collector
+}
+
+// Describe sets the kind (counter or gauge) and help text of a metric
+func (collector *Collector) Describe(name string, kind string, help string) {
+	if collector == nil {
+		return
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	m := collector.get(name)
+	m.kind = kind
+	m.help = help
+}
+
+// DescribeHistogram makes metric |name| a histogram with |help| text, whose
+// buckets have the upper bounds |buckets|, in increasing order.  Its values
+// are recorded by Observe.
+func (collector *Collector) DescribeHistogram(name string, help string, buckets []float64) {
+	if collector == nil {
+		return
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	m := collector.get(name)
+	m.kind = "histogram"
+	m.help = help
+	m.buckets = buckets
+}
+
+// get returns the metric named |name|, creating it if necessary.
+// collector.mu must be held.
+func (collector *Collector) get(name string) *metric {
+	m, exists := collector.metrics[name]
+	if !exists {
+		m = &metric{kind: "untyped"}
+		m.reset()
+		collector.metrics[name] = m
+	}
+	return m
+}
+
+func (m *metric) reset() {
+	m.values = make(map[string]float64)
+	m.histograms = make(map[string]*histogram)
+	m.labels = make(map[string][]string)
+}
+
+// addLabels records |labels|, formatted as |key|, for Gather
+func (m *metric) addLabels(key string, labels []string) {
+	if _, exists := m.labels[key]; !exists {
+		m.labels[key] = append([]string(nil), labels...)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats |labels|, a list of alternating names and values
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `%s="%s"`, labels[i], labelValueEscaper.Replace(labels[i+1]))
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+// Add adds |delta| to the value of metric |name| with |labels| (alternating
+// names and values)
+func (collector *Collector) Add(name string, delta float64, labels ...string) {
+	if collector == nil {
+		return
+	}
+	key := formatLabels(labels)
+	collector.mu.Lock()
+	m := collector.get(name)
+	m.values[key] += delta
+	m.addLabels(key, labels)
+	collector.mu.Unlock()
+}
+
+// Set sets the value of metric |name| with |labels| (alternating names and
+// values)
+func (collector *Collector) Set(name string, value float64, labels ...string) {
+	if collector == nil {
+		return
+	}
+	key := formatLabels(labels)
+	collector.mu.Lock()
+	m := collector.get(name)
+	m.values[key] = value
+	m.addLabels(key, labels)
+	collector.mu.Unlock()
+}
+
+// Observe records |value| in histogram |name| with |labels| (alternating
+// names and values)
+func (collector *Collector) Observe(name string, value float64, labels ...string) {
+	if collector == nil {
+		return
+	}
+	key := formatLabels(labels)
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	m := collector.get(name)
+	h, exists := m.histograms[key]
+	if !exists {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.histograms[key] = h
+		m.addLabels(key, labels)
+	}
+	for i, bound := range m.buckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// Reset removes all values of metric |name|, e.g. before setting gauges
+// for a set of labels which may have changed
+func (collector *Collector) Reset(name string) {
+	if collector == nil {
+		return
+	}
+	collector.mu.Lock()
+	collector.get(name).reset()
+	collector.mu.Unlock()
+}
+
+// OnCollect registers |f| to be called before each collection
+func (collector *Collector) OnCollect(f func(*Collector)) {
+	if collector == nil {
+		return
+	}
+	collector.mu.Lock()
+	collector.onCollect = append(collector.onCollect, f)
+	collector.mu.Unlock()
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// withLabel adds the label |name|=|value| to |key|, a formatted label set
+func withLabel(key string, name string, value string) string {
+	label := formatLabels([]string{name, value})
+	if key == "" {
+		return label
+	}
+	return key[:len(key)-1] + "," + label[1:]
+}
+
+// writeHistogram writes the _bucket, _sum, and _count series of histogram
+// |name| to |buf|
+func writeHistogram(buf *bytes.Buffer, name string, m *metric) {
+	keys := make([]string, 0, len(m.histograms))
+	for key := range m.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		h := m.histograms[key]
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", name, withLabel(key, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", name, withLabel(key, "le", "+Inf"), h.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", name, key, formatValue(h.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", name, key, h.count)
+	}
+}
+
+// WriteTo writes the metrics to |w| in the Prometheus text format
+func (collector *Collector) WriteTo(w io.Writer) (int64, error) {
+	if collector == nil {
+		return 0, nil
+	}
+	collector.collecting.Lock()
+	defer collector.collecting.Unlock()
+	collector.runOnCollect()
+
+	var buf bytes.Buffer
+	collector.mu.Lock()
+	names := make([]string, 0, len(collector.metrics))
+	for name := range collector.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := collector.metrics[name]
+		if m.help != "" {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", name, strings.Replace(m.help, "\n", " ", -1))
+		}
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, m.kind)
+		if m.kind == "histogram" {
+			writeHistogram(&buf, name, m)
+			continue
+		}
+		keys := make([]string, 0, len(m.values))
+		for key := range m.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "%s%s %s\n", name, key, formatValue(m.values[key]))
+		}
+	}
+	collector.mu.Unlock()
+	return buf.WriteTo(w)
+}
+
+// runOnCollect calls the functions registered with OnCollect.
+// collector.collecting must be held.
+func (collector *Collector) runOnCollect() {
+	collector.mu.Lock()
+	onCollect := collector.onCollect
+	collector.mu.Unlock()
+	for _, f := range onCollect {
+		f(collector)
+	}
+}
+
+// Sample is the value of a metric with one set of labels, as returned by
+// Gather
+type Sample struct {
+	Name   string
+	Kind   string   // counter, gauge, histogram, or untyped
+	Labels []string // alternating names and values
+	Value  float64  // for a histogram, the sum of the observations
+	Count  uint64   // for a histogram, the number of observations
+}
+
+// Gather returns the values of all the metrics, sorted by name and labels,
+// for exporting them other than in the Prometheus format
+func (collector *Collector) Gather() []Sample {
+	if collector == nil {
+		return nil
+	}
+	collector.collecting.Lock()
+	defer collector.collecting.Unlock()
+	collector.runOnCollect()
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	names := make([]string, 0, len(collector.metrics))
+	for name := range collector.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var samples []Sample
+	for _, name := range names {
+		m := collector.metrics[name]
+		keys := make([]string, 0, len(m.labels))
+		for key := range m.labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			sample := Sample{Name: name, Kind: m.kind, Labels: m.labels[key]}
+			if h, isHistogram := m.histograms[key]; isHistogram {
+				sample.Value = h.sum
+				sample.Count = h.count
+			} else {
+				sample.Value = m.values[key]
+			}
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+func (collector *Collector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	collector.WriteTo(w)
+}
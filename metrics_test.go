@@ -0,0 +1,92 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCollector(t *testing.T) {
+	collector := &Collector{metrics: make(map[string]*metric)}
+	collector.Describe("test_total", "counter", "A test counter")
+	collector.Add("test_total", 1, "log", "b")
+	collector.Add("test_total", 2, "log", "a\"\\\n")
+	collector.Add("test_total", 0.5, "log", "b")
+	collector.OnCollect(func(c *Collector) {
+		c.Reset("test_gauge")
+		c.Set("test_gauge", 42)
+	})
+	collector.Set("test_gauge", 1, "stale", "yes")
+
+	var buf bytes.Buffer
+	if _, err := collector.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# TYPE test_gauge untyped
+test_gauge 42
+# HELP test_total A test counter
+# TYPE test_total counter
+test_total{log="a\"\\\n"} 2
+test_total{log="b"} 1.5
+`
+	if buf.String() != expected {
+		t.Errorf("Wrong output:\n%s\nExpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestNilCollector(t *testing.T) {
+	var collector *Collector
+	collector.Add("test_total", 1)
+	collector.Set("test_gauge", 1)
+	collector.Observe("test_seconds", 1)
+	if n, err := collector.WriteTo(new(bytes.Buffer)); n != 0 || err != nil {
+		t.Errorf("Nil collector wrote %d bytes (error %v)", n, err)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	collector := &Collector{metrics: make(map[string]*metric)}
+	collector.DescribeHistogram("test_seconds", "A test histogram", []float64{0.5, 1, 5})
+	collector.Observe("test_seconds", 0.25, "log", "a")
+	collector.Observe("test_seconds", 1, "log", "a")
+	collector.Observe("test_seconds", 10, "log", "a")
+	collector.Observe("test_seconds", 2)
+
+	var buf bytes.Buffer
+	if _, err := collector.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP test_seconds A test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.5"} 0
+test_seconds_bucket{le="1"} 0
+test_seconds_bucket{le="5"} 1
+test_seconds_bucket{le="+Inf"} 1
+test_seconds_sum 2
+test_seconds_count 1
+test_seconds_bucket{log="a",le="0.5"} 1
+test_seconds_bucket{log="a",le="1"} 2
+test_seconds_bucket{log="a",le="5"} 2
+test_seconds_bucket{log="a",le="+Inf"} 3
+test_seconds_sum{log="a"} 11.25
+test_seconds_count{log="a"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("Wrong output:\n%s\nExpected:\n%s", buf.String(), expected)
+	}
+
+	collector.Reset("test_seconds")
+	buf.Reset()
+	collector.WriteTo(&buf)
+	if buf.String() != "# HELP test_seconds A test histogram\n# TYPE test_seconds histogram\n" {
+		t.Errorf("Histogram wasn't reset:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/tiled"
+)
+
+// CheckSTHAge returns an error if |sth| is older than the log's Maximum Merge
+// Delay, which means the log has failed to produce a new STH in time.
+func CheckSTHAge(sth *ct.SignedTreeHead, mmd time.Duration, now time.Time) error {
+	age := now.Sub(sthTime(sth))
+	if mmd > 0 && age > mmd {
+		return fmt.Errorf("latest STH (%d) is %s old, which exceeds the log's Maximum Merge Delay of %s", sth.TreeSize, age, mmd)
+	}
+	return nil
+}
+
+// A PendingSCT records a log's promise, in the form of an SCT, to incorporate
+// an entry with the given leaf hash by the SCT timestamp plus the log's MMD.
+type PendingSCT struct {
+	LogID       ct.SHA256Hash     `json:"log_id"`
+	Timestamp   uint64            `json:"timestamp"`
+	LeafHash    ct.MerkleTreeNode `json:"leaf_hash"`
+	LeafInput   []byte            `json:"leaf_input,omitempty"` // MerkleTreeLeaf hashed to get LeafHash
+	Fingerprint string            `json:"fingerprint"`          // of the certificate containing the SCT
+	LeafIndex   *uint64           `json:"leaf_index,omitempty"` // from the SCT's leaf_index extension, if issued by a tiled log
+}
+
+func (pending *PendingSCT) Deadline(mmd time.Duration) time.Time {
+	return time.Unix(int64(pending.Timestamp/1000), int64(pending.Timestamp%1000)*1000000).Add(mmd)
+}
+
+// IsDue returns true if the log should have incorporated the entry as of |sth|
+func (pending *PendingSCT) IsDue(sth *ct.SignedTreeHead, mmd time.Duration) bool {
+	return !sthTime(sth).Before(pending.Deadline(mmd))
+}
+
+// ParseSCTList returns the SCTs embedded in the certificate, or nil if it has none
+func (tbs *TBSCertificate) ParseSCTList() ([]ct.SignedCertificateTimestamp, error) {
+	sctExts := tbs.GetExtension(oidExtensionSCT)
+	if len(sctExts) == 0 {
+		return nil, nil
+	}
+	var sctListBytes []byte
+	if rest, err := asn1.Unmarshal(sctExts[0].Value, &sctListBytes); err != nil {
+		return nil, errors.New("failed to parse SCT extension: " + err.Error())
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing data after SCT extension: %v", rest)
+	}
+	return ct.DeserializeSCTList(sctListBytes)
+}
+
+// GetEmbeddedSCTs returns the SCTs embedded in the certificate described by
+// |info|, along with the pre-certificate log entry that they sign.  Returns
+// nil if |info| is a pre-certificate or contains no SCTs.  The signatures on the
+// SCTs are not verified.
+func GetEmbeddedSCTs(info *EntryInfo) ([]ct.SignedCertificateTimestamp, *ct.LogEntry, error) {
+	if info.IsPrecert || info.CertInfo == nil {
+		return nil, nil, nil
+	}
+	scts, err := info.CertInfo.TBS.ParseSCTList()
+	if err != nil || scts == nil {
+		return nil, nil, err
+	}
+
+	if len(info.Entry.Chain) == 0 {
+		return nil, nil, errors.New("log entry has no issuer certificate")
+	}
+	issuer, err := ParseCertificate(info.Entry.Chain[0])
+	if err != nil {
+		return nil, nil, errors.New("failed to parse issuer certificate: " + err.Error())
+	}
+	issuerTBS, err := issuer.ParseTBSCertificate()
+	if err != nil {
+		return nil, nil, errors.New("failed to parse issuer certificate: " + err.Error())
+	}
+	precertTBS, err := ReconstructPrecertTBS(info.CertInfo.TBS)
+	if err != nil {
+		return nil, nil, errors.New("failed to reconstruct pre-certificate TBS: " + err.Error())
+	}
+
+	precertEntry := &ct.LogEntry{
+		Leaf: ct.MerkleTreeLeaf{
+			Version:  ct.V1,
+			LeafType: ct.TimestampedEntryLeafType,
+			TimestampedEntry: ct.TimestampedEntry{
+				EntryType: ct.PrecertLogEntryType,
+				PrecertEntry: ct.PreCert{
+					IssuerKeyHash:  sha256.Sum256(issuerTBS.GetRawPublicKey()),
+					TBSCertificate: precertTBS.Raw,
+				},
+			},
+		},
+	}
+	return scts, precertEntry, nil
+}
+
+// MakePendingSCT computes the leaf hash of the entry which |sct| promises to
+// incorporate.  |entry| is the log entry signed by the SCT, as returned by
+// GetEmbeddedSCTs.
+func MakePendingSCT(sct *ct.SignedCertificateTimestamp, entry *ct.LogEntry, fingerprint string) (*PendingSCT, error) {
+	leaf := entry.Leaf
+	leaf.TimestampedEntry.Timestamp = sct.Timestamp
+	leaf.TimestampedEntry.Extensions = sct.Extensions
+	leafBytes, err := ct.SerializeMerkleTreeLeaf(leaf)
+	if err != nil {
+		return nil, err
+	}
+	pending := &PendingSCT{
+		LogID:       sct.LogID,
+		Timestamp:   sct.Timestamp,
+		LeafHash:    hashLeaf(leafBytes),
+		LeafInput:   leafBytes,
+		Fingerprint: fingerprint,
+	}
+	if index, ok := tiled.LeafIndex(sct.Extensions); ok {
+		pending.LeafIndex = &index
+	}
+	return pending, nil
+}
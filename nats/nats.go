@@ -0,0 +1,358 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package nats is a minimal NATS client, supporting only publishing, with
+// optional JetStream acknowledgements.  TLS is not supported.
+package nats
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultPort = "4222"
+
+// The largest message accepted from a server which doesn't say what its
+// max_payload is, as in NATS's default configuration
+const DefaultMaxPayload = 1024 * 1024
+
+var errClosed = errors.New("Connection to NATS server closed")
+
+type serverInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	MaxPayload  int  `json:"max_payload"`
+}
+
+type connectOptions struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+// A PubAck is JetStream's acknowledgement of a published message
+type PubAck struct {
+	Stream    string `json:"stream"`
+	Sequence  uint64 `json:"seq"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error,omitempty"`
+}
+
+// Conn is a connection to a NATS server.  It is safe for concurrent use.
+// If the connection is lost, it is re-established by the next Publish.
+type Conn struct {
+	URL     string
+	Name    string
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	netConn net.Conn
+	writer  *bufio.Writer
+	info    serverInfo
+	inbox   string
+	nextID  int
+	acks    map[string]chan []byte // reply subject => channel for the reply
+	readErr error
+}
+
+// Dial connects to the NATS server at |serverURL|, of the form
+// nats://[USER:PASSWORD@|TOKEN@]HOST[:PORT]
+func Dial(serverURL string, name string, timeout time.Duration) (*Conn, error) {
+	conn := &Conn{URL: serverURL, Name: name, Timeout: timeout}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if err := conn.connect(); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (conn *Conn) connect() error {
+	u, err := url.Parse(conn.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "nats" || u.Host == "" {
+		return fmt.Errorf("%s: not of the form nats://HOST:PORT", conn.URL)
+	}
+	addr := u.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultPort)
+	}
+	netConn, err := net.DialTimeout("tcp", addr, conn.Timeout)
+	if err != nil {
+		return err
+	}
+	netConn.SetDeadline(time.Now().Add(conn.Timeout))
+	reader := bufio.NewReader(netConn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		netConn.Close()
+		return err
+	}
+	var info serverInfo
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(line[5:]), &info) != nil {
+		netConn.Close()
+		return fmt.Errorf("%s: not a NATS server", addr)
+	}
+	if info.TLSRequired {
+		netConn.Close()
+		return fmt.Errorf("%s: NATS server requires TLS, which is not supported", addr)
+	}
+
+	options := connectOptions{Name: conn.Name, Lang: "go", Version: "certspotter", Protocol: 1}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			options.User, options.Pass = u.User.Username(), password
+		} else {
+			options.Token = u.User.Username()
+		}
+	}
+	optionsJSON, _ := json.Marshal(options)
+	conn.inbox = "_INBOX." + randomID()
+	writer := bufio.NewWriter(netConn)
+	fmt.Fprintf(writer, "CONNECT %s\r\nSUB %s.* 1\r\nPING\r\n", optionsJSON, conn.inbox)
+	if err := writer.Flush(); err != nil {
+		netConn.Close()
+		return err
+	}
+	// The server replies to PING with PONG once it has processed CONNECT,
+	// or with -ERR if authentication failed
+	if line, err = reader.ReadString('\n'); err != nil {
+		netConn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "PONG") {
+		netConn.Close()
+		return fmt.Errorf("%s: %s", addr, strings.TrimSpace(line))
+	}
+	netConn.SetDeadline(time.Time{})
+
+	conn.netConn = netConn
+	conn.writer = writer
+	conn.info = info
+	conn.acks = make(map[string]chan []byte)
+	conn.readErr = nil
+	maxPayload := info.MaxPayload
+	if maxPayload <= 0 {
+		maxPayload = DefaultMaxPayload
+	}
+	go conn.readLoop(netConn, reader, maxPayload)
+	return nil
+}
+
+// readLoop handles messages from the server until the connection fails
+func (conn *Conn) readLoop(netConn net.Conn, reader *bufio.Reader, maxPayload int) {
+	err := conn.read(netConn, reader, maxPayload)
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	netConn.Close()
+	if conn.netConn == netConn {
+		conn.netConn = nil
+		conn.readErr = err
+		for _, ack := range conn.acks {
+			close(ack)
+		}
+		conn.acks = nil
+	}
+}
+
+// read handles messages from the server, whose payloads may be at most
+// |maxPayload| bytes
+func (conn *Conn) read(netConn net.Conn, reader *bufio.Reader, maxPayload int) error {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			conn.mu.Lock()
+			if conn.netConn == netConn {
+				conn.writer.WriteString("PONG\r\n")
+				conn.writer.Flush()
+			}
+			conn.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS server error: %s", strings.TrimSpace(line[4:]))
+		case strings.HasPrefix(line, "MSG "), strings.HasPrefix(line, "HMSG "):
+			subject, headerSize, size, err := parseMsgLine(line, maxPayload)
+			if err != nil {
+				return err
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return err
+			}
+			conn.mu.Lock()
+			if ack := conn.acks[subject]; ack != nil {
+				ack <- payload[headerSize:size]
+				delete(conn.acks, subject)
+			}
+			conn.mu.Unlock()
+		}
+	}
+}
+
+// parseMsgLine parses the line which introduces a message from the server,
+// either MSG SUBJECT SID [REPLY] SIZE or HMSG SUBJECT SID [REPLY]
+// HEADER_SIZE SIZE, returning the subject, the size of the headers (0 for
+// MSG), and the total size of the payload, which must not exceed
+// |maxPayload|
+func parseMsgLine(line string, maxPayload int) (string, int, int, error) {
+	fields := strings.Fields(line)
+	sizeFields := 1
+	if fields[0] == "HMSG" {
+		sizeFields = 2
+	}
+	if len(fields) != 3+sizeFields && len(fields) != 4+sizeFields {
+		return "", 0, 0, fmt.Errorf("Malformed message from NATS server: %s", line)
+	}
+	var sizes [2]int
+	for i, field := range fields[len(fields)-sizeFields:] {
+		size, err := strconv.Atoi(field)
+		if err != nil || size < 0 {
+			return "", 0, 0, fmt.Errorf("Malformed message from NATS server: %s", line)
+		}
+		sizes[i] = size
+	}
+	headerSize, size := 0, sizes[0]
+	if sizeFields == 2 {
+		headerSize, size = sizes[0], sizes[1]
+		if headerSize > size {
+			return "", 0, 0, fmt.Errorf("Malformed message from NATS server: %s", line)
+		}
+	}
+	if size > maxPayload {
+		return "", 0, 0, fmt.Errorf("Message from NATS server is %d bytes, which exceeds the maximum of %d", size, maxPayload)
+	}
+	return fields[1], headerSize, size, nil
+}
+
+// publish sends a message, with a reply subject if |reply| is non-empty.
+// conn.mu must be held.
+func (conn *Conn) publish(subject string, reply string, data []byte) error {
+	if conn.netConn == nil {
+		if err := conn.connect(); err != nil {
+			return err
+		}
+	}
+	if conn.info.MaxPayload > 0 && len(data) > conn.info.MaxPayload {
+		return fmt.Errorf("Message is %d bytes, which exceeds the NATS server's maximum of %d", len(data), conn.info.MaxPayload)
+	}
+	if reply != "" {
+		reply = " " + reply
+	}
+	conn.netConn.SetWriteDeadline(time.Now().Add(conn.Timeout))
+	fmt.Fprintf(conn.writer, "PUB %s%s %d\r\n", subject, reply, len(data))
+	conn.writer.Write(data)
+	conn.writer.WriteString("\r\n")
+	if err := conn.writer.Flush(); err != nil {
+		conn.netConn.Close()
+		return err
+	}
+	return nil
+}
+
+// Publish publishes |data| to |subject|.  Like all NATS publishes, it
+// doesn't wait for the message to be received.
+func (conn *Conn) Publish(subject string, data []byte) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.publish(subject, "", data)
+}
+
+// PublishJetStream publishes |data| to |subject|, which must be captured by
+// a JetStream stream, and waits for the stream to acknowledge that it has
+// persisted the message
+func (conn *Conn) PublishJetStream(subject string, data []byte) (*PubAck, error) {
+	conn.mu.Lock()
+	if err := func() error {
+		if conn.netConn == nil {
+			return conn.connect()
+		}
+		return nil
+	}(); err != nil {
+		conn.mu.Unlock()
+		return nil, err
+	}
+	conn.nextID++
+	reply := conn.inbox + "." + strconv.Itoa(conn.nextID)
+	ackChan := make(chan []byte, 1)
+	conn.acks[reply] = ackChan
+	err := conn.publish(subject, reply, data)
+	conn.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case payload, ok := <-ackChan:
+		if !ok {
+			conn.mu.Lock()
+			err := conn.readErr
+			conn.mu.Unlock()
+			if err == nil {
+				err = errClosed
+			}
+			return nil, err
+		}
+		ack := new(PubAck)
+		if err := json.Unmarshal(payload, ack); err != nil {
+			return nil, fmt.Errorf("Malformed JetStream acknowledgement: %s", err)
+		}
+		if ack.Error != nil {
+			return nil, fmt.Errorf("JetStream error %d: %s", ack.Error.Code, ack.Error.Description)
+		}
+		return ack, nil
+	case <-time.After(conn.Timeout):
+		conn.mu.Lock()
+		delete(conn.acks, reply)
+		conn.mu.Unlock()
+		return nil, fmt.Errorf("Timed out waiting for JetStream acknowledgement (is there a stream for subject %s?)", subject)
+	}
+}
+
+func (conn *Conn) Close() error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.netConn == nil {
+		return nil
+	}
+	conn.writer.Flush()
+	err := conn.netConn.Close()
+	conn.netConn = nil
+	return err
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package nats
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseMsgLine(t *testing.T) {
+	tests := []struct {
+		line       string
+		subject    string
+		headerSize int
+		size       int
+		ok         bool
+	}{
+		{"MSG _INBOX.a.1 1 5", "_INBOX.a.1", 0, 5, true},
+		{"MSG _INBOX.a.1 1 reply 0", "_INBOX.a.1", 0, 0, true},
+		{"HMSG _INBOX.a.1 1 12 20", "_INBOX.a.1", 12, 20, true},
+		{"HMSG _INBOX.a.1 1 reply 12 20", "_INBOX.a.1", 12, 20, true},
+		{"MSG _INBOX.a.1 1 1024", "_INBOX.a.1", 0, 1024, true},
+		{"MSG _INBOX.a.1 1 1025", "", 0, 0, false}, // exceeds max_payload
+		{"MSG _INBOX.a.1 1 -5", "", 0, 0, false},
+		{"MSG _INBOX.a.1 1 five", "", 0, 0, false},
+		{"MSG _INBOX.a.1 5", "", 0, 0, false},
+		{"MSG", "", 0, 0, false},
+		{"MSG a b c d e", "", 0, 0, false},
+		{"HMSG _INBOX.a.1 1 20", "", 0, 0, false},
+		{"HMSG _INBOX.a.1 1 21 20", "", 0, 0, false}, // headers larger than the message
+		{"HMSG _INBOX.a.1 1 -1 20", "", 0, 0, false},
+		{"HMSG _INBOX.a.1 1 0 2000", "", 0, 0, false},
+	}
+	for _, test := range tests {
+		subject, headerSize, size, err := parseMsgLine(test.line, 1024)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v", test.line, err)
+		} else if test.ok && (subject != test.subject || headerSize != test.headerSize || size != test.size) {
+			t.Errorf("%q: got %q, %d, %d", test.line, subject, headerSize, size)
+		}
+	}
+}
+
+func TestReadMalformedMsg(t *testing.T) {
+	ack, headerAck := make(chan []byte, 1), make(chan []byte, 1)
+	conn := &Conn{acks: map[string]chan []byte{"_INBOX.a.1": ack, "_INBOX.a.2": headerAck}}
+	input := "MSG _INBOX.a.1 1 5\r\nhello\r\n" +
+		"HMSG _INBOX.a.2 1 12 17\r\nNATS/1.0\r\n\r\nhello\r\n" +
+		"MSG _INBOX.a.3 1 -3\r\n"
+	err := conn.read(nil, bufio.NewReader(strings.NewReader(input)), 1024)
+	if err == nil || !strings.Contains(err.Error(), "Malformed message") {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if payload := <-ack; string(payload) != "hello" {
+		t.Errorf("Wrong payload: %q", payload)
+	}
+	if payload := <-headerAck; string(payload) != "hello" {
+		t.Errorf("Wrong payload after headers: %q", payload)
+	}
+
+	input = "MSG _INBOX.a.1 1 2000000000\r\n"
+	if err := conn.read(nil, bufio.NewReader(strings.NewReader(input)), 1024); err == nil || err == io.EOF {
+		t.Errorf("Oversized message was read: %v", err)
+	}
+}
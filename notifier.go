@@ -0,0 +1,48 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"fmt"
+
+	"software.sslmate.com/src/certspotter/ct/client"
+)
+
+// A Notifier tells a person or system about matching entries, e.g. by email
+// or in a chat channel.  Implementations are in the notify package.
+type Notifier interface {
+	// Channel identifies the notifier (e.g. "email"), so that
+	// notifications can be tracked per channel
+	Channel() string
+	Notify(*EntryInfo) error
+}
+
+// A DigestNotifier can also send a single notification summarizing several
+// entries
+type DigestNotifier interface {
+	Notifier
+	NotifyDigest([]*EntryInfo) error
+}
+
+// Stores which can record which certificates have been notified about on
+// each channel implement NotifiedStore, so that repeat notifications can be
+// suppressed across runs
+type NotifiedStore interface {
+	Store
+
+	HasNotified(channel string, fingerprint string) (bool, error)
+	SaveNotified(channel string, fingerprint string) error
+}
+
+// LogEntryURL returns the URL from which the entry can be retrieved from
+// its log
+func (info *EntryInfo) LogEntryURL() string {
+	return fmt.Sprintf("%s%s?start=%d&end=%d", info.LogUri, client.GetEntriesPath, info.Entry.Index, info.Entry.Index)
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const DefaultDigestMaxEntries = 1000
+
+// Digest wraps a Notifier, collecting the entries it's notified about for
+// Window after the first one, and then sending a single digest notification
+// about all of them.  If the wrapped Notifier isn't a DigestNotifier, the
+// entries are sent to it one at a time at the end of the window instead.  To
+// bound memory use, the digest is sent early if it reaches MaxEntries.
+type Digest struct {
+	Notifier   certspotter.Notifier
+	Window     time.Duration
+	MaxEntries int
+
+	mu      sync.Mutex
+	pending []*certspotter.EntryInfo
+	timer   *time.Timer
+}
+
+func NewDigest(notifier certspotter.Notifier, window time.Duration) *Digest {
+	return &Digest{Notifier: notifier, Window: window, MaxEntries: DefaultDigestMaxEntries}
+}
+
+func (digest *Digest) Channel() string {
+	return digest.Notifier.Channel()
+}
+
+func (digest *Digest) Notify(info *certspotter.EntryInfo) error {
+	digest.mu.Lock()
+	digest.pending = append(digest.pending, info)
+	if len(digest.pending) < digest.MaxEntries {
+		if digest.timer == nil {
+			digest.timer = time.AfterFunc(digest.Window, digest.flushAfterWindow)
+		}
+		digest.mu.Unlock()
+		return nil
+	}
+	infos := digest.take()
+	digest.mu.Unlock()
+	return digest.send(infos)
+}
+
+// take removes and returns the pending entries.  digest.mu must be held.
+func (digest *Digest) take() []*certspotter.EntryInfo {
+	infos := digest.pending
+	digest.pending = nil
+	if digest.timer != nil {
+		digest.timer.Stop()
+		digest.timer = nil
+	}
+	return infos
+}
+
+func (digest *Digest) send(infos []*certspotter.EntryInfo) error {
+	if len(infos) == 0 {
+		return nil
+	}
+	if len(infos) > 1 {
+		if digestNotifier, ok := digest.Notifier.(certspotter.DigestNotifier); ok {
+			return digestNotifier.NotifyDigest(infos)
+		}
+	}
+	var firstErr error
+	for _, info := range infos {
+		if err := digest.Notifier.Notify(info); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (digest *Digest) flushAfterWindow() {
+	if err := digest.Flush(); err != nil {
+		slog.Error(err.Error(), "channel", digest.Channel())
+	}
+}
+
+// Flush sends the pending entries now
+func (digest *Digest) Flush() error {
+	digest.mu.Lock()
+	infos := digest.take()
+	digest.mu.Unlock()
+	return digest.send(infos)
+}
+
+// Close sends the pending entries
+func (digest *Digest) Close() error {
+	return digest.Flush()
+}
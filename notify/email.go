@@ -0,0 +1,237 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// Values for EmailNotifier.StartTLS
+const (
+	StartTLSOpportunistic = "opportunistic" // use STARTTLS if the server supports it
+	StartTLSRequired      = "required"      // fail if the server doesn't support STARTTLS
+)
+
+// EmailNotifier sends an email about each entry via SMTP
+type EmailNotifier struct {
+	Host        string
+	Port        string
+	Username    string
+	Password    string
+	ImplicitTLS bool   // connect with TLS (smtps), rather than using STARTTLS
+	StartTLS    string // ignored if ImplicitTLS is set
+	From        string
+	To          []string
+	Template    *Template
+	Digest      *Template // for NotifyDigest
+}
+
+// NewEmailNotifier creates an EmailNotifier which sends mail through the
+// server at |serverURL|, of the form smtp://[USER:PASSWORD@]HOST[:PORT]
+// or smtps://... (TLS).  STARTTLS is required if the URL has a password;
+// otherwise it's used if the server supports it.
+func NewEmailNotifier(serverURL string, from string, to []string) (*EmailNotifier, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	notifier := &EmailNotifier{From: from, To: to, Template: DefaultTemplate, Digest: DefaultDigestTemplate}
+	switch u.Scheme {
+	case "smtp":
+		notifier.Host, notifier.Port = u.Host, "25"
+	case "smtps":
+		notifier.Host, notifier.Port = u.Host, "465"
+		notifier.ImplicitTLS = true
+	default:
+		return nil, fmt.Errorf("%s: not an smtp:// or smtps:// URL", serverURL)
+	}
+	if host, port, err := net.SplitHostPort(u.Host); err == nil {
+		notifier.Host, notifier.Port = host, port
+	}
+	if notifier.Host == "" {
+		return nil, fmt.Errorf("%s: no host specified", serverURL)
+	}
+	if u.User != nil {
+		notifier.Username = u.User.Username()
+		notifier.Password, _ = u.User.Password()
+	}
+	if notifier.Password != "" {
+		notifier.StartTLS = StartTLSRequired
+	} else {
+		notifier.StartTLS = StartTLSOpportunistic
+	}
+	if from == "" {
+		return nil, fmt.Errorf("No From address specified")
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("No To addresses specified")
+	}
+	return notifier, nil
+}
+
+func (notifier *EmailNotifier) Channel() string {
+	return "email"
+}
+
+func messageID(from string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at != -1 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// formatMessage formats an RFC 5322 message
+func (notifier *EmailNotifier) formatMessage(subject string, body string) ([]byte, error) {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", notifier.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(notifier.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: %s\r\n", messageID(notifier.From))
+	fmt.Fprintf(&msg, "Auto-Submitted: auto-generated\r\n")
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "Content-Transfer-Encoding: quoted-printable\r\n")
+	fmt.Fprintf(&msg, "\r\n")
+	writer := quotedprintable.NewWriter(&msg)
+	if _, err := writer.Write([]byte(strings.Replace(body, "\n", "\r\n", -1))); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// addressOf extracts the address from |mailbox|, which may be of the form
+// "Name <address>"
+func addressOf(mailbox string) string {
+	if start, end := strings.LastIndex(mailbox, "<"), strings.LastIndex(mailbox, ">"); start != -1 && end > start {
+		return mailbox[start+1 : end]
+	}
+	return strings.TrimSpace(mailbox)
+}
+
+func (notifier *EmailNotifier) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(notifier.Host, notifier.Port)
+	tlsConfig := &tls.Config{ServerName: notifier.Host}
+	var conn net.Conn
+	var err error
+	if notifier.ImplicitTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 60 * time.Second}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 60*time.Second)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	client, err := smtp.NewClient(conn, notifier.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		if err := client.Hello(hostname); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if !notifier.ImplicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, err
+			}
+		} else if notifier.StartTLS == StartTLSRequired {
+			client.Close()
+			return nil, fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+	}
+	return client, nil
+}
+
+// Send sends an email with the given subject and body
+func (notifier *EmailNotifier) Send(subject string, body string) error {
+	msg, err := notifier.formatMessage(subject, body)
+	if err != nil {
+		return err
+	}
+	client, err := notifier.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if notifier.Username != "" {
+		// PlainAuth refuses to send the password unless the connection
+		// is encrypted (or to localhost)
+		if err := client.Auth(smtp.PlainAuth("", notifier.Username, notifier.Password, notifier.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(addressOf(notifier.From)); err != nil {
+		return err
+	}
+	for _, to := range notifier.To {
+		if err := client.Rcpt(addressOf(to)); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(msg); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (notifier *EmailNotifier) Notify(info *certspotter.EntryInfo) error {
+	subject, body, err := notifier.Template.Render(info)
+	if err != nil {
+		return err
+	}
+	if err := notifier.Send(subject, body); err != nil {
+		return fmt.Errorf("Error sending email about %s: %s", info.Fingerprint(), err)
+	}
+	return nil
+}
+
+func (notifier *EmailNotifier) NotifyDigest(infos []*certspotter.EntryInfo) error {
+	subject, body, err := notifier.Digest.RenderDigest(infos)
+	if err != nil {
+		return err
+	}
+	if err := notifier.Send(subject, body); err != nil {
+		return fmt.Errorf("Error sending email digest of %d certificates: %s", len(infos), err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// ExecNotifier runs a command for each entry.  The command's environment has
+// the same variables as -script (see EntryInfo.Environ), plus SUBJECT,
+// LOG_ENTRY_URL, and CRTSH_URL, and its standard input is the certificate
+// chain as PEM, leaf first.  If the command doesn't exit within Timeout, it
+// is killed, along with any processes it started.
+type ExecNotifier struct {
+	Command  string
+	Args     []string
+	Timeout  time.Duration
+	Template *Template // only the subject is used, as $SUBJECT
+}
+
+func NewExecNotifier(command string, args ...string) *ExecNotifier {
+	return &ExecNotifier{Command: command, Args: args, Timeout: time.Minute, Template: DefaultTemplate}
+}
+
+func (notifier *ExecNotifier) Channel() string {
+	return "exec"
+}
+
+func chainPEM(chain [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, cert := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	}
+	return buf.Bytes()
+}
+
+func (notifier *ExecNotifier) Notify(info *certspotter.EntryInfo) error {
+	data := MakeData(info)
+	subject, err := execute(notifier.Template.Subject, data)
+	if err != nil {
+		return fmt.Errorf("Error executing subject template: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifier.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, notifier.Command, notifier.Args...)
+	// Kill the command's whole process group, so that its children don't
+	// outlive it, or hold stderr open and keep Wait from returning
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(), info.Environ()...)
+	cmd.Env = append(cmd.Env,
+		"SUBJECT="+strings.TrimSpace(subject),
+		"LOG_ENTRY_URL="+data.LogEntryURL,
+		"CRTSH_URL="+data.CrtShURL,
+	)
+	cmd.Stdin = bytes.NewReader(chainPEM(info.FullChain))
+	stderrBuffer := bytes.Buffer{}
+	cmd.Stderr = &stderrBuffer
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to execute command: %s: %s", notifier.Command, err)
+	}
+	err = cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Command timed out after %s: %s", notifier.Timeout, notifier.Command)
+	}
+	if err != nil {
+		if _, isExitError := err.(*exec.ExitError); isExitError {
+			return fmt.Errorf("Command failed for %s: %s: %s", info.Fingerprint(), notifier.Command, strings.TrimSpace(stderrBuffer.String()))
+		}
+		return fmt.Errorf("Failed to execute command: %s: %s", notifier.Command, err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/internal/certtest"
+)
+
+// processRunning returns true if process |pid| exists and isn't a zombie
+func processRunning(pid int) bool {
+	stat, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesized command name
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestExecTimeoutKillsChildren(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires /proc")
+	}
+	dir, err := ioutil.TempDir("", "exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "pid")
+
+	notifier := NewExecNotifier("sh", "-c", `sleep 100 & echo $! > "$0"; sleep 100`, pidFile)
+	notifier.Timeout = 200 * time.Millisecond
+	start := time.Now()
+	err = notifier.Notify(certtest.EntryInfo(t, []string{"www.example.com"}, nil))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Notify took %s", elapsed)
+	}
+
+	pidBytes, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Background process %d is still running", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExecFailure(t *testing.T) {
+	notifier := NewExecNotifier("sh", "-c", `echo oops >&2; exit 1`)
+	if err := notifier.Notify(certtest.EntryInfo(t, []string{"www.example.com"}, nil)); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("Wrong error: %v", err)
+	}
+	notifier = NewExecNotifier("sh", "-c", `cat > /dev/null`)
+	if err := notifier.Notify(certtest.EntryInfo(t, []string{"www.example.com"}, nil)); err != nil {
+		t.Errorf("Command failed: %s", err)
+	}
+}
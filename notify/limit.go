@@ -0,0 +1,183 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// Limit wraps a Notifier, suppressing repeat notifications about the same
+// certificate (by fingerprint) and limiting how many notifications are sent
+// per Interval.  Entries which match one of the Always watchlist items are
+// exempt from both, and follow-ups about revoked certificates aren't
+// suppressed as repeats.
+//
+// Fingerprints are recorded in Store, if set, so repeats are suppressed
+// across runs; otherwise they are only remembered in memory.  A certificate
+// is only recorded once a notification about it has been sent successfully.
+type Limit struct {
+	Notifier certspotter.Notifier
+	Store    certspotter.NotifiedStore
+	Dedup    bool
+	Rate     int // maximum notifications per Interval, or 0 for no limit
+	Interval time.Duration
+	Always   map[string]bool // watchlist item IDs
+
+	mu       sync.Mutex
+	notified map[string]bool
+	sent     []time.Time // when notifications were sent in the last Interval
+}
+
+func NewLimit(notifier certspotter.Notifier) *Limit {
+	return &Limit{
+		Notifier: notifier,
+		Interval: time.Hour,
+		Always:   make(map[string]bool),
+		notified: make(map[string]bool),
+	}
+}
+
+func (limit *Limit) Channel() string {
+	return limit.Notifier.Channel()
+}
+
+func (limit *Limit) always(info *certspotter.EntryInfo) bool {
+	for _, id := range info.MatchIDs() {
+		if limit.Always[id] {
+			return true
+		}
+	}
+	return false
+}
+
+func (limit *Limit) hasNotified(fingerprint string) (bool, error) {
+	limit.mu.Lock()
+	notified := limit.notified[fingerprint]
+	limit.mu.Unlock()
+	if notified || limit.Store == nil {
+		return notified, nil
+	}
+	return limit.Store.HasNotified(limit.Channel(), fingerprint)
+}
+
+func (limit *Limit) saveNotified(fingerprint string) error {
+	limit.mu.Lock()
+	limit.notified[fingerprint] = true
+	limit.mu.Unlock()
+	if limit.Store == nil {
+		return nil
+	}
+	return limit.Store.SaveNotified(limit.Channel(), fingerprint)
+}
+
+// allow returns true if sending another notification now wouldn't exceed
+// the rate limit, and if so counts it
+func (limit *Limit) allow() bool {
+	if limit.Rate <= 0 {
+		return true
+	}
+	limit.mu.Lock()
+	defer limit.mu.Unlock()
+	now := time.Now()
+	for len(limit.sent) > 0 && now.Sub(limit.sent[0]) >= limit.Interval {
+		limit.sent = limit.sent[1:]
+	}
+	if len(limit.sent) >= limit.Rate {
+		return false
+	}
+	limit.sent = append(limit.sent, now)
+	return true
+}
+
+// filter returns the entries of |infos| which should be notified about
+func (limit *Limit) filter(infos []*certspotter.EntryInfo) ([]*certspotter.EntryInfo, error) {
+	if !limit.Dedup {
+		return infos, nil
+	}
+	var filtered []*certspotter.EntryInfo
+	for _, info := range infos {
+		fingerprint := info.Fingerprint()
+		if fingerprint == "" || limit.always(info) || info.IsRevocationFollowUp() {
+			filtered = append(filtered, info)
+			continue
+		}
+		notified, err := limit.hasNotified(fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("Error checking whether %s has been notified about on %s: %s", fingerprint, limit.Channel(), err)
+		}
+		if !notified {
+			filtered = append(filtered, info)
+		}
+	}
+	return filtered, nil
+}
+
+func (limit *Limit) record(infos []*certspotter.EntryInfo) error {
+	if !limit.Dedup {
+		return nil
+	}
+	for _, info := range infos {
+		if fingerprint := info.Fingerprint(); fingerprint != "" {
+			if err := limit.saveNotified(fingerprint); err != nil {
+				return fmt.Errorf("Error recording that %s has been notified about on %s: %s", fingerprint, limit.Channel(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (limit *Limit) Notify(info *certspotter.EntryInfo) error {
+	infos, err := limit.filter([]*certspotter.EntryInfo{info})
+	if err != nil || len(infos) == 0 {
+		return err
+	}
+	if !limit.always(info) && !limit.allow() {
+		return fmt.Errorf("Not notifying about %s on %s: rate limit of %d per %s exceeded", info.Fingerprint(), limit.Channel(), limit.Rate, limit.Interval)
+	}
+	if err := limit.Notifier.Notify(info); err != nil {
+		return err
+	}
+	return limit.record(infos)
+}
+
+// NotifyDigest sends a digest of the entries which haven't been notified
+// about, which counts as one notification towards the rate limit
+func (limit *Limit) NotifyDigest(infos []*certspotter.EntryInfo) error {
+	infos, err := limit.filter(infos)
+	if err != nil || len(infos) == 0 {
+		return err
+	}
+	always := false
+	for _, info := range infos {
+		always = always || limit.always(info)
+	}
+	if !always && !limit.allow() {
+		return fmt.Errorf("Not sending digest of %d certificates on %s: rate limit of %d per %s exceeded", len(infos), limit.Channel(), limit.Rate, limit.Interval)
+	}
+	if digestNotifier, ok := limit.Notifier.(certspotter.DigestNotifier); ok && len(infos) > 1 {
+		if err := digestNotifier.NotifyDigest(infos); err != nil {
+			return err
+		}
+		return limit.record(infos)
+	}
+	for _, info := range infos {
+		if err := limit.Notifier.Notify(info); err != nil {
+			return err
+		}
+		if err := limit.record([]*certspotter.EntryInfo{info}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package notify contains implementations of certspotter.Notifier.
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/sink"
+)
+
+// Data is the data passed to notification templates, and the payload of
+// webhooks: the fields of sink.Line, plus some URLs
+type Data struct {
+	*sink.Line
+	MatchIDs    []string `json:"match_ids"`
+	LogEntryURL string   `json:"log_entry_url"` // from which the entry can be retrieved from the log
+	CrtShURL    string   `json:"crtsh_url"`
+}
+
+func MakeData(info *certspotter.EntryInfo) *Data {
+	line := sink.MakeLine(info, false)
+	return &Data{
+		Line:        line,
+		MatchIDs:    info.MatchIDs(),
+		LogEntryURL: info.LogEntryURL(),
+		CrtShURL:    "https://crt.sh/?sha256=" + line.Fingerprint,
+	}
+}
+
+// DigestData is the data passed to digest templates, and the payload of
+// digest webhooks
+type DigestData struct {
+	Entries  []*Data  `json:"entries"`
+	DNSNames []string `json:"dns_names"` // of all the entries, without duplicates
+	MatchIDs []string `json:"match_ids"` // likewise
+}
+
+func MakeDigestData(infos []*certspotter.EntryInfo) *DigestData {
+	digest := &DigestData{Entries: make([]*Data, 0, len(infos))}
+	seenDNSNames := make(map[string]bool)
+	seenMatchIDs := make(map[string]bool)
+	for _, info := range infos {
+		data := MakeData(info)
+		digest.Entries = append(digest.Entries, data)
+		for _, dnsName := range data.DNSNames {
+			if !seenDNSNames[dnsName] {
+				seenDNSNames[dnsName] = true
+				digest.DNSNames = append(digest.DNSNames, dnsName)
+			}
+		}
+		for _, id := range data.MatchIDs {
+			if !seenMatchIDs[id] {
+				seenMatchIDs[id] = true
+				digest.MatchIDs = append(digest.MatchIDs, id)
+			}
+		}
+	}
+	return digest
+}
+
+// summarize lists the first few of |values|, and how many more there are
+func summarize(values []string) string {
+	const max = 3
+	switch {
+	case len(values) == 0:
+		return "(no names)"
+	case len(values) <= max:
+		return strings.Join(values, ", ")
+	default:
+		return fmt.Sprintf("%s, and %d more", strings.Join(values[:max], ", "), len(values)-max)
+	}
+}
+
+// truncate shortens |s| to at most |max| bytes, to fit limits on the
+// length of fields in messages
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	n := max - 3
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
+
+var templateFuncs = template.FuncMap{"summarize": summarize}
+
+func init() {
+	for name, function := range sink.TemplateFuncs {
+		templateFuncs[name] = function
+	}
+}
+
+// A Template renders the subject and body of a notification from Data
+type Template struct {
+	Subject *template.Template
+	Body    *template.Template
+}
+
+// ParseTemplate parses the text of a subject template and a body template
+func ParseTemplate(subject string, body string) (*Template, error) {
+	subjectTemplate, err := template.New("subject").Funcs(templateFuncs).Parse(subject)
+	if err != nil {
+		return nil, err
+	}
+	bodyTemplate, err := template.New("body").Funcs(templateFuncs).Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{Subject: subjectTemplate, Body: bodyTemplate}, nil
+}
+
+// LoadTemplate reads a template file which defines any of the templates
+// "subject" and "body" (for notifications about a single entry) and
+// "digest_subject" and "digest_body" (for digests) with {{define}}, and
+// returns a Template for single entries and one for digests.  Templates
+// which the file doesn't define are taken from |defaults| and
+// |digestDefaults|.
+func LoadTemplate(filename string, defaults *Template, digestDefaults *Template) (*Template, *Template, error) {
+	text, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := template.New(filename).Funcs(templateFuncs).Parse(string(text))
+	if err != nil {
+		return nil, nil, err
+	}
+	lookup := func(name string, fallback *template.Template) *template.Template {
+		if tmpl := root.Lookup(name); tmpl != nil {
+			return tmpl
+		}
+		return fallback
+	}
+	if lookup("subject", nil) == nil && lookup("body", nil) == nil && lookup("digest_subject", nil) == nil && lookup("digest_body", nil) == nil {
+		return nil, nil, fmt.Errorf("%s: doesn't define a subject, body, digest_subject, or digest_body template", filename)
+	}
+	single := &Template{Subject: lookup("subject", defaults.Subject), Body: lookup("body", defaults.Body)}
+	digest := &Template{Subject: lookup("digest_subject", digestDefaults.Subject), Body: lookup("digest_body", digestDefaults.Body)}
+	return single, digest, nil
+}
+
+func execute(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Render returns the subject and body of the notification for |info|
+func (t *Template) Render(info *certspotter.EntryInfo) (string, string, error) {
+	return t.render(MakeData(info))
+}
+
+// RenderDigest returns the subject and body of a digest notification about
+// |infos|.  The template must be a digest template, executed with
+// DigestData.
+func (t *Template) RenderDigest(infos []*certspotter.EntryInfo) (string, string, error) {
+	return t.render(MakeDigestData(infos))
+}
+
+func (t *Template) render(data interface{}) (string, string, error) {
+	subject, err := execute(t.Subject, data)
+	if err != nil {
+		return "", "", fmt.Errorf("Error executing subject template: %s", err)
+	}
+	body, err := execute(t.Body, data)
+	if err != nil {
+		return "", "", fmt.Errorf("Error executing body template: %s", err)
+	}
+	return strings.TrimSpace(subject), body, nil
+}
+
+const defaultSubject = `{{if .IsPrecert}}Precertificate{{else}}Certificate{{end}} {{if .Revoked}}revoked{{else}}issued{{end}} for {{summarize .DNSNames}}`
+
+const defaultBody = `{{if .Revoked}}{{if .IsPrecert}}A precertificate{{else}}A certificate{{end}} which was reported before has been revoked{{with .Revocation}} ({{.}}){{end}}.{{else}}{{if .IsPrecert}}A precertificate{{else}}A certificate{{end}} matching {{join .MatchIDs ", "}} has been logged in Certificate Transparency.{{end}}
+
+{{range .DNSNames}}     DNS Name = {{.}}
+{{end}}{{range .IPAddresses}}   IP Address = {{.}}
+{{end}}{{with .Issuer}}       Issuer = {{.}}
+{{end}}{{with .NotBefore}}   Not Before = {{rfc3339 .}}
+{{end}}{{with .NotAfter}}    Not After = {{rfc3339 .}}
+{{end}}  Fingerprint = {{.Fingerprint}}
+    Log Entry = {{.Index}} @ {{.LogURI}}
+                {{.LogEntryURL}}
+       crt.sh = {{.CrtShURL}}
+`
+
+const defaultDigestSubject = `{{len .Entries}} certificates issued for {{summarize .DNSNames}}`
+
+const defaultDigestBody = `{{len .Entries}} certificates matching {{join .MatchIDs ", "}} have been logged in Certificate Transparency.
+{{range .Entries}}
+{{if .IsPrecert}}Precertificate{{else}}Certificate{{end}} for {{summarize .DNSNames}}
+{{with .Issuer}}       Issuer = {{.}}
+{{end}}{{with .NotBefore}}   Not Before = {{rfc3339 .}}
+{{end}}{{with .NotAfter}}    Not After = {{rfc3339 .}}
+{{end}}  Fingerprint = {{.Fingerprint}}
+    Log Entry = {{.Index}} @ {{.LogURI}}
+       crt.sh = {{.CrtShURL}}
+{{end}}`
+
+var DefaultTemplate *Template
+var DefaultDigestTemplate *Template
+
+func init() {
+	var err error
+	if DefaultTemplate, err = ParseTemplate(defaultSubject, defaultBody); err != nil {
+		panic(err)
+	}
+	if DefaultDigestTemplate, err = ParseTemplate(defaultDigestSubject, defaultDigestBody); err != nil {
+		panic(err)
+	}
+}
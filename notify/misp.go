@@ -0,0 +1,236 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// Values for MISPNotifier.Distribution
+const (
+	MISPYourOrganisation     = 0
+	MISPThisCommunity        = 1
+	MISPConnectedCommunities = 2
+	MISPAllCommunities       = 3
+)
+
+// ParseMISPDistribution parses the name of a MISP distribution level
+// (organisation, community, connected, or all)
+func ParseMISPDistribution(name string) (int, error) {
+	switch name {
+	case "organisation":
+		return MISPYourOrganisation, nil
+	case "community":
+		return MISPThisCommunity, nil
+	case "connected":
+		return MISPConnectedCommunities, nil
+	case "all":
+		return MISPAllCommunities, nil
+	default:
+		return 0, fmt.Errorf("Invalid MISP distribution `%s': must be organisation, community, connected, or all", name)
+	}
+}
+
+// MISPNotifier records each entry in a MISP instance as attributes: the
+// certificate's fingerprint (x509-fingerprint-sha256), its DNS names
+// (domain), and its issuer (text).
+//
+// Unless EventID is set, each issuance gets its own event, whose UUID is
+// derived from the issuance, so a precertificate and its certificate (or
+// the same certificate seen in several logs) update the same event rather
+// than creating another.  If EventID is set, attributes are instead added
+// to that existing event.  Attributes already in the event are skipped.
+type MISPNotifier struct {
+	URL          string // base URL of the MISP instance
+	EventID      string // ID or UUID of the event to add attributes to, or empty
+	Distribution int
+	Tags         []string // added to events which are created
+
+	Template *Template // only the subject is used, as the event's info
+	webhook  *WebhookNotifier
+}
+
+func NewMISPNotifier(url string, authKey string) *MISPNotifier {
+	webhook := NewWebhookNotifier("")
+	webhook.Headers = http.Header{
+		"Authorization": {authKey},
+		"Accept":        {"application/json"},
+	}
+	return &MISPNotifier{
+		URL:          strings.TrimSuffix(url, "/"),
+		Distribution: MISPYourOrganisation,
+		Template:     DefaultTemplate,
+		webhook:      webhook,
+	}
+}
+
+func (notifier *MISPNotifier) Channel() string {
+	return "misp"
+}
+
+type mispAttribute struct {
+	Type     string `json:"type"`
+	Category string `json:"category"`
+	Value    string `json:"value"`
+	Comment  string `json:"comment,omitempty"`
+	ToIDS    bool   `json:"to_ids"`
+}
+
+type mispTag struct {
+	Name string `json:"name"`
+}
+
+type mispEvent struct {
+	ID            string          `json:"id,omitempty"`
+	UUID          string          `json:"uuid,omitempty"`
+	Info          string          `json:"info,omitempty"`
+	Date          string          `json:"date,omitempty"`
+	Distribution  string          `json:"distribution,omitempty"`
+	ThreatLevelID string          `json:"threat_level_id,omitempty"`
+	Analysis      string          `json:"analysis,omitempty"`
+	Attribute     []mispAttribute `json:"Attribute,omitempty"`
+	Tag           []mispTag       `json:"Tag,omitempty"`
+}
+
+type mispEventResponse struct {
+	Event mispEvent `json:"Event"`
+}
+
+// mispAttributes returns the attributes to record about |data|
+func mispAttributes(data *Data) []mispAttribute {
+	certType := "Certificate"
+	if data.IsPrecert {
+		certType = "Precertificate"
+	}
+	attributes := []mispAttribute{
+		{Type: "x509-fingerprint-sha256", Category: "Network activity", Value: data.Fingerprint, Comment: certType + " logged at " + data.LogEntryURL},
+	}
+	seen := make(map[string]bool)
+	for _, dnsName := range data.DNSNames {
+		// MISP doesn't accept wildcards as domains
+		domain := strings.ToLower(strings.TrimPrefix(dnsName, "*."))
+		if !seen[domain] {
+			seen[domain] = true
+			attributes = append(attributes, mispAttribute{Type: "domain", Category: "Network activity", Value: domain, Comment: "DNS name of certificate " + data.Fingerprint})
+		}
+	}
+	if data.Issuer != "" {
+		attributes = append(attributes, mispAttribute{Type: "text", Category: "Other", Value: data.Issuer, Comment: "Issuer of certificate " + data.Fingerprint})
+	}
+	return attributes
+}
+
+// mispEventUUID returns the UUID of the event for the issuance identified
+// by |key|, as a name-based UUID (version 5 layout) so it's the same each
+// time the issuance is seen
+func mispEventUUID(key []byte) string {
+	hash := sha256.Sum256(append([]byte("certspotter MISP event\x00"), key...))
+	uuid := hash[:16]
+	uuid[6] = (uuid[6] & 0x0f) | 0x50
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// getEvent returns the event with the given ID or UUID, or nil if there
+// isn't one
+func (notifier *MISPNotifier) getEvent(id string) (*mispEvent, error) {
+	var response mispEventResponse
+	err := notifier.webhook.Request("GET", notifier.URL+"/events/view/"+id, nil, &response)
+	if httpErr, ok := err.(*HTTPError); ok && httpErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &response.Event, nil
+}
+
+func (notifier *MISPNotifier) createEvent(event *mispEvent) error {
+	body, err := json.Marshal(mispEventResponse{Event: *event})
+	if err != nil {
+		return err
+	}
+	return notifier.webhook.Request("POST", notifier.URL+"/events/add", body, nil)
+}
+
+// addAttributes adds those of |attributes| which |event| doesn't already
+// have
+func (notifier *MISPNotifier) addAttributes(event *mispEvent, attributes []mispAttribute) error {
+	existing := make(map[string]bool)
+	for _, attribute := range event.Attribute {
+		existing[attribute.Type+"|"+attribute.Value] = true
+	}
+	for _, attribute := range attributes {
+		if existing[attribute.Type+"|"+attribute.Value] {
+			continue
+		}
+		body, err := json.Marshal(attribute)
+		if err != nil {
+			return err
+		}
+		if err := notifier.webhook.Request("POST", notifier.URL+"/attributes/add/"+event.ID, body, nil); err != nil {
+			return fmt.Errorf("Error adding %s attribute %s: %s", attribute.Type, attribute.Value, err)
+		}
+	}
+	return nil
+}
+
+func (notifier *MISPNotifier) Notify(info *certspotter.EntryInfo) error {
+	data := MakeData(info)
+	attributes := mispAttributes(data)
+
+	eventID := notifier.EventID
+	if eventID == "" {
+		key := info.IssuanceKey()
+		if key == nil {
+			key = info.FingerprintBytes()
+		}
+		eventID = mispEventUUID(key)
+	}
+	event, err := notifier.getEvent(eventID)
+	if err != nil {
+		return fmt.Errorf("Error getting MISP event %s: %s", eventID, err)
+	}
+	if event != nil {
+		if err := notifier.addAttributes(event, attributes); err != nil {
+			return fmt.Errorf("Error updating MISP event %s for %s: %s", eventID, info.Fingerprint(), err)
+		}
+		return nil
+	}
+	if notifier.EventID != "" {
+		return fmt.Errorf("MISP event %s does not exist", eventID)
+	}
+
+	subject, err := execute(notifier.Template.Subject, data)
+	if err != nil {
+		return fmt.Errorf("Error executing subject template: %s", err)
+	}
+	event = &mispEvent{
+		UUID:          eventID,
+		Info:          truncate(strings.TrimSpace(subject), 1024),
+		Date:          data.Timestamp.UTC().Format("2006-01-02"),
+		Distribution:  fmt.Sprint(notifier.Distribution),
+		ThreatLevelID: "3", // low
+		Analysis:      "0", // initial
+		Attribute:     attributes,
+	}
+	for _, tag := range notifier.Tags {
+		event.Tag = append(event.Tag, mispTag{Name: tag})
+	}
+	if err := notifier.createEvent(event); err != nil {
+		return fmt.Errorf("Error creating MISP event for %s: %s", info.Fingerprint(), err)
+	}
+	return nil
+}
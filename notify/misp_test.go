@@ -0,0 +1,177 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/internal/certtest"
+)
+
+func TestMISPEventUUID(t *testing.T) {
+	uuid := mispEventUUID([]byte("issuance"))
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(uuid) {
+		t.Errorf("%q is not a version 5 UUID", uuid)
+	}
+	if again := mispEventUUID([]byte("issuance")); again != uuid {
+		t.Errorf("UUID of the same key changed from %s to %s", uuid, again)
+	}
+	if other := mispEventUUID([]byte("other issuance")); other == uuid {
+		t.Errorf("Different keys have the same UUID %s", uuid)
+	}
+}
+
+func TestMISPAttributes(t *testing.T) {
+	info := certtest.EntryInfo(t, []string{"*.Example.com", "example.com", "www.example.com"}, nil)
+	data := MakeData(info)
+	expected := []mispAttribute{
+		{Type: "x509-fingerprint-sha256", Category: "Network activity", Value: data.Fingerprint, Comment: "Certificate logged at " + data.LogEntryURL},
+		{Type: "domain", Category: "Network activity", Value: "example.com", Comment: "DNS name of certificate " + data.Fingerprint},
+		{Type: "domain", Category: "Network activity", Value: "www.example.com", Comment: "DNS name of certificate " + data.Fingerprint},
+		{Type: "text", Category: "Other", Value: data.Issuer, Comment: "Issuer of certificate " + data.Fingerprint},
+	}
+	if attributes := mispAttributes(data); !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("mispAttributes = %+v, expected %+v", attributes, expected)
+	}
+}
+
+func TestParseMISPDistribution(t *testing.T) {
+	tests := map[string]int{
+		"organisation": MISPYourOrganisation,
+		"community":    MISPThisCommunity,
+		"connected":    MISPConnectedCommunities,
+		"all":          MISPAllCommunities,
+	}
+	for name, expected := range tests {
+		if distribution, err := ParseMISPDistribution(name); err != nil || distribution != expected {
+			t.Errorf("ParseMISPDistribution(%q) = %d, %v, expected %d", name, distribution, err, expected)
+		}
+	}
+	if _, err := ParseMISPDistribution("everyone"); err == nil {
+		t.Errorf("ParseMISPDistribution accepted an invalid distribution")
+	}
+}
+
+// fakeMISP implements the parts of the MISP API used by MISPNotifier
+type fakeMISP struct {
+	t        *testing.T
+	mu       sync.Mutex
+	events   map[string]*mispEvent // by UUID
+	requests []string
+}
+
+func (misp *fakeMISP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	misp.mu.Lock()
+	defer misp.mu.Unlock()
+	misp.requests = append(misp.requests, req.Method+" "+req.URL.Path)
+	if auth := req.Header.Get("Authorization"); auth != "authkey" {
+		misp.t.Errorf("Authorization header is %q", auth)
+	}
+	if accept := req.Header.Get("Accept"); accept != "application/json" {
+		misp.t.Errorf("Accept header is %q", accept)
+	}
+	body, _ := io.ReadAll(req.Body)
+	switch {
+	case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/events/view/"):
+		event, ok := misp.events[strings.TrimPrefix(req.URL.Path, "/events/view/")]
+		if !ok {
+			http.Error(w, `{"name":"Invalid event","message":"Invalid event"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(mispEventResponse{Event: *event})
+	case req.Method == "POST" && req.URL.Path == "/events/add":
+		var request mispEventResponse
+		if err := json.Unmarshal(body, &request); err != nil {
+			misp.t.Errorf("Error decoding event: %s", err)
+		}
+		event := request.Event
+		event.ID = "1"
+		misp.events[event.UUID] = &event
+		json.NewEncoder(w).Encode(mispEventResponse{Event: event})
+	case req.Method == "POST" && strings.HasPrefix(req.URL.Path, "/attributes/add/"):
+		var attribute mispAttribute
+		if err := json.Unmarshal(body, &attribute); err != nil {
+			misp.t.Errorf("Error decoding attribute: %s", err)
+		}
+		id := strings.TrimPrefix(req.URL.Path, "/attributes/add/")
+		for _, event := range misp.events {
+			if event.ID == id {
+				event.Attribute = append(event.Attribute, attribute)
+				w.Write([]byte(`{}`))
+				return
+			}
+		}
+		http.NotFound(w, req)
+	default:
+		misp.t.Errorf("Unexpected request %s %s", req.Method, req.URL.Path)
+		http.NotFound(w, req)
+	}
+}
+
+func TestMISPNotify(t *testing.T) {
+	misp := &fakeMISP{t: t, events: make(map[string]*mispEvent)}
+	server := httptest.NewServer(misp)
+	defer server.Close()
+
+	notifier := NewMISPNotifier(server.URL+"/", "authkey")
+	notifier.Distribution = MISPThisCommunity
+	notifier.Tags = []string{"tlp:green"}
+
+	info := certtest.EntryInfo(t, []string{"www.example.com"}, nil)
+	uuid := mispEventUUID(info.IssuanceKey())
+	if err := notifier.Notify(info); err != nil {
+		t.Fatal(err)
+	}
+	event := misp.events[uuid]
+	if event == nil {
+		t.Fatalf("No event was created with UUID %s; requests were %v", uuid, misp.requests)
+	}
+	if event.Distribution != "1" || event.Date != "2024-01-01" || len(event.Attribute) != 3 {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if !reflect.DeepEqual(event.Tag, []mispTag{{Name: "tlp:green"}}) {
+		t.Errorf("Event has tags %v", event.Tag)
+	}
+
+	// Adding another certificate to the event only adds the attributes
+	// which the event doesn't have yet: its fingerprint and the new DNS
+	// name, but not the shared DNS name or issuer
+	misp.requests = nil
+	notifier.EventID = uuid
+	other := certtest.EntryInfo(t, []string{"www.example.com", "mail.example.com"}, nil)
+	if err := notifier.Notify(other); err != nil {
+		t.Fatal(err)
+	}
+	expectedRequests := []string{"GET /events/view/" + uuid, "POST /attributes/add/1", "POST /attributes/add/1"}
+	if !reflect.DeepEqual(misp.requests, expectedRequests) {
+		t.Errorf("Requests were %v, expected %v", misp.requests, expectedRequests)
+	}
+	var values []string
+	for _, attribute := range event.Attribute[3:] {
+		values = append(values, attribute.Value)
+	}
+	if expected := []string{other.Fingerprint(), "mail.example.com"}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("Added attributes %v, expected %v", values, expected)
+	}
+
+	// A configured event which doesn't exist is an error
+	notifier.EventID = "nonexistent"
+	if err := notifier.Notify(info); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Notify with a nonexistent event returned %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// Values for PagerDutyNotifier.Severity
+const (
+	PagerDutyCritical = "critical"
+	PagerDutyError    = "error"
+	PagerDutyWarning  = "warning"
+	PagerDutyInfo     = "info"
+)
+
+// PagerDutyNotifier triggers a PagerDuty alert via the Events API v2 for
+// each entry with a high-severity match.  The dedup key is derived from the
+// issuance (see EntryInfo.IssuanceKey), so a certificate and its
+// precertificate, or the same certificate seen in several logs, open only
+// one incident.
+type PagerDutyNotifier struct {
+	RoutingKey string // integration key of the PagerDuty service
+	Severity   string
+
+	// Watchlist item IDs and match categories (such as "issuer") which are
+	// high-severity.  If empty, every match is.
+	Triggers map[string]bool
+
+	Template *Template // only the subject is used, as the alert's summary
+	webhook  *WebhookNotifier
+}
+
+func NewPagerDutyNotifier(routingKey string, severity string) (*PagerDutyNotifier, error) {
+	switch severity {
+	case PagerDutyCritical, PagerDutyError, PagerDutyWarning, PagerDutyInfo:
+	default:
+		return nil, fmt.Errorf("Invalid PagerDuty severity `%s': must be critical, error, warning, or info", severity)
+	}
+	return &PagerDutyNotifier{
+		RoutingKey: routingKey,
+		Severity:   severity,
+		Triggers:   make(map[string]bool),
+		Template:   DefaultTemplate,
+		webhook:    NewWebhookNotifier(PagerDutyEventsURL),
+	}, nil
+}
+
+func (notifier *PagerDutyNotifier) Channel() string {
+	return "pagerduty"
+}
+
+// triggers returns the matches of |info| which are high-severity
+func (notifier *PagerDutyNotifier) triggers(info *certspotter.EntryInfo) []certspotter.Match {
+	if len(notifier.Triggers) == 0 {
+		return info.Matches
+	}
+	var matches []certspotter.Match
+	for _, match := range info.Matches {
+		if notifier.Triggers[match.ID] || notifier.Triggers[match.Category] {
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string      `json:"summary"`
+	Source        string      `json:"source"`
+	Severity      string      `json:"severity"`
+	Timestamp     string      `json:"timestamp,omitempty"`
+	Component     string      `json:"component,omitempty"`
+	Class         string      `json:"class,omitempty"`
+	CustomDetails interface{} `json:"custom_details,omitempty"`
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerDutyPayload `json:"payload"`
+	Links       []pagerDutyLink  `json:"links,omitempty"`
+	Client      string           `json:"client,omitempty"`
+}
+
+// pagerDutyDedupKey returns the dedup key of the alert about |info|: the hex
+// IssuanceKey, or the fingerprint if the entry can't be parsed
+func pagerDutyDedupKey(info *certspotter.EntryInfo) string {
+	if key := info.IssuanceKey(); key != nil {
+		return hex.EncodeToString(key)
+	}
+	return info.Fingerprint()
+}
+
+func (notifier *PagerDutyNotifier) Notify(info *certspotter.EntryInfo) error {
+	matches := notifier.triggers(info)
+	if len(matches) == 0 {
+		return nil
+	}
+	data := MakeData(info)
+	subject, err := execute(notifier.Template.Subject, data)
+	if err != nil {
+		return fmt.Errorf("Error executing subject template: %s", err)
+	}
+	source := data.Fingerprint
+	if len(data.DNSNames) != 0 {
+		source = data.DNSNames[0]
+	}
+	event := pagerDutyEvent{
+		RoutingKey:  notifier.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    pagerDutyDedupKey(info),
+		Payload: pagerDutyPayload{
+			Summary:       truncate(strings.TrimSpace(subject), 1024),
+			Source:        source,
+			Severity:      notifier.Severity,
+			Timestamp:     data.Timestamp.UTC().Format(time.RFC3339),
+			Component:     data.Issuer,
+			Class:         matches[0].Category,
+			CustomDetails: data,
+		},
+		Links: []pagerDutyLink{
+			{Href: data.CrtShURL, Text: "View on crt.sh"},
+			{Href: data.LogEntryURL, Text: "Log Entry"},
+		},
+		Client: "certspotter",
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := notifier.webhook.Post(notifier.webhook.URL, body); err != nil {
+		return fmt.Errorf("Error triggering PagerDuty alert for %s: %s", info.Fingerprint(), err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/internal/certtest"
+)
+
+func TestPagerDutyDedupKey(t *testing.T) {
+	var events []pagerDutyEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		var event pagerDutyEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("Error decoding event: %s", err)
+		}
+		events = append(events, event)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	notifier, err := NewPagerDutyNotifier("routingkey", PagerDutyCritical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.webhook.URL = server.URL
+
+	cert := certtest.EntryInfo(t, []string{"www.example.com"}, nil)
+	tbs, err := certspotter.ReconstructPrecertTBS(cert.CertInfo.TBS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &ct.LogEntry{Index: 8}
+	entry.Leaf.LeafType = ct.TimestampedEntryLeafType
+	entry.Leaf.TimestampedEntry.Timestamp = cert.Entry.Leaf.TimestampedEntry.Timestamp
+	entry.Leaf.TimestampedEntry.EntryType = ct.PrecertLogEntryType
+	entry.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate = tbs.Raw
+	precert := certspotter.NewEntryInfo("https://ct.example.com/", entry)
+
+	for _, info := range []*certspotter.EntryInfo{precert, cert} {
+		info.Matches = []certspotter.Match{{Category: "domain", ID: "example"}}
+		if err := notifier.Notify(info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(events) != 2 {
+		t.Fatalf("%d events were sent, expected 2", len(events))
+	}
+	if events[0].DedupKey != events[1].DedupKey {
+		t.Errorf("Precertificate has dedup key %s, but certificate has %s", events[0].DedupKey, events[1].DedupKey)
+	}
+	if events[1].DedupKey == cert.Fingerprint() {
+		t.Errorf("Dedup key is the certificate's fingerprint")
+	}
+	if events[1].RoutingKey != "routingkey" || events[1].EventAction != "trigger" || events[1].Payload.Class != "domain" {
+		t.Errorf("Unexpected event %+v", events[1])
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const DefaultMaxAttempts = 10
+
+// Retry wraps a Notifier, queueing failed notifications in Store so that
+// they can be retried by RetryPending, even after a restart.  When a
+// notification has been queued, Notify logs the failure and returns nil, so
+// that wrappers such as Limit consider it delivered.
+//
+// Retries are spaced with exponential backoff, starting at MinBackoff and
+// capped at a day.  A notification is dropped after MaxAttempts attempts.
+type Retry struct {
+	Notifier    certspotter.Notifier
+	Store       certspotter.Store
+	MaxAttempts int
+	MinBackoff  time.Duration
+}
+
+func NewRetry(notifier certspotter.Notifier, store certspotter.Store) *Retry {
+	return &Retry{Notifier: notifier, Store: store, MaxAttempts: DefaultMaxAttempts, MinBackoff: time.Minute}
+}
+
+func (retry *Retry) Channel() string {
+	return retry.Notifier.Channel()
+}
+
+func (retry *Retry) backoff(attempts int) time.Duration {
+	const maxBackoff = 24 * time.Hour
+	backoff := retry.MinBackoff
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// queue queues a notification about |info| after its first attempt failed
+// with |notifyErr|
+func (retry *Retry) queue(info *certspotter.EntryInfo, notifyErr error) error {
+	if retry.MaxAttempts <= 1 {
+		return notifyErr
+	}
+	notification := certspotter.NewPendingNotification(retry.Channel(), info)
+	notification.Attempts = 1
+	notification.NextAttempt = time.Now().Add(retry.backoff(1)).UTC()
+	if err := retry.Store.QueueNotification(notification); err != nil {
+		return fmt.Errorf("%s (unable to queue it for retry: %s)", notifyErr, err)
+	}
+	slog.Warn(notifyErr.Error(), "channel", retry.Channel(), "attempt", 1, "next_attempt", notification.NextAttempt.Format(time.RFC3339))
+	return nil
+}
+
+func (retry *Retry) Notify(info *certspotter.EntryInfo) error {
+	if err := retry.Notifier.Notify(info); err != nil {
+		return retry.queue(info, err)
+	}
+	return nil
+}
+
+// NotifyDigest sends a digest if the wrapped Notifier supports it.  If it
+// fails, each entry is queued to be retried separately.
+func (retry *Retry) NotifyDigest(infos []*certspotter.EntryInfo) error {
+	digestNotifier, ok := retry.Notifier.(certspotter.DigestNotifier)
+	if !ok {
+		var firstErr error
+		for _, info := range infos {
+			if err := retry.Notify(info); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+	notifyErr := digestNotifier.NotifyDigest(infos)
+	if notifyErr == nil {
+		return nil
+	}
+	for _, info := range infos {
+		if err := retry.queue(info, fmt.Errorf("Error notifying about %s: %s", info.Fingerprint(), notifyErr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetryPending retries the queued notifications for this channel which are
+// due, removing them from the queue once they succeed or have been attempted
+// MaxAttempts times
+func (retry *Retry) RetryPending() error {
+	notifications, err := retry.Store.GetNotifications()
+	if err != nil {
+		return fmt.Errorf("Error reading notification queue: %s", err)
+	}
+	now := time.Now()
+	for _, notification := range notifications {
+		if notification.Channel != retry.Channel() || notification.NextAttempt.After(now) {
+			continue
+		}
+		info, err := notification.EntryInfo()
+		if err != nil {
+			slog.Error("Dropping malformed queued notification", "channel", retry.Channel(), "id", notification.ID, "error", err)
+			if err := retry.Store.RemoveNotification(notification); err != nil {
+				return err
+			}
+			continue
+		}
+		notification.Attempts++
+		if notifyErr := retry.Notifier.Notify(info); notifyErr == nil {
+			if err := retry.Store.RemoveNotification(notification); err != nil {
+				return err
+			}
+		} else if notification.Attempts >= retry.MaxAttempts {
+			slog.Error(notifyErr.Error()+" (giving up)", "channel", retry.Channel(), "attempt", notification.Attempts, "queued", notification.Queued.Format(time.RFC3339))
+			if err := retry.Store.RemoveNotification(notification); err != nil {
+				return err
+			}
+		} else {
+			notification.NextAttempt = now.Add(retry.backoff(notification.Attempts)).UTC()
+			slog.Warn(notifyErr.Error(), "channel", retry.Channel(), "attempt", notification.Attempts, "next_attempt", notification.NextAttempt.Format(time.RFC3339))
+			if err := retry.Store.QueueNotification(notification); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// SlackNotifier posts a message about each entry to a Slack incoming
+// webhook, formatted with Block Kit.  Like WebhookNotifier, matches of
+// particular watchlist items can be routed to their own webhooks (and
+// hence channels).
+//
+// The subject of Template is the message's header.  If Template has a body,
+// it's shown as Slack mrkdwn instead of the certificate's fields.  Digest is
+// used likewise for NotifyDigest.
+type SlackNotifier struct {
+	Template *Template
+	Digest   *Template
+	webhook  *WebhookNotifier
+}
+
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{
+		Template: &Template{Subject: DefaultTemplate.Subject},
+		Digest:   &Template{Subject: DefaultDigestTemplate.Subject},
+		webhook:  NewWebhookNotifier(webhookURL),
+	}
+}
+
+// LoadRoutes reads webhook URLs for particular watchlist items (see
+// WebhookNotifier.LoadRoutes)
+func (notifier *SlackNotifier) LoadRoutes(filename string) error {
+	return notifier.webhook.LoadRoutes(filename)
+}
+
+func (notifier *SlackNotifier) Channel() string {
+	return "slack"
+}
+
+type slackText struct {
+	Type string `json:"type"` // plain_text or mrkdwn
+	Text string `json:"text"`
+}
+
+type slackElement struct {
+	Type string     `json:"type"`
+	Text *slackText `json:"text"`
+	URL  string     `json:"url"`
+}
+
+type slackBlock struct {
+	Type     string        `json:"type"`
+	Text     *slackText    `json:"text,omitempty"`
+	Fields   []*slackText  `json:"fields,omitempty"`
+	Elements []interface{} `json:"elements,omitempty"` // slackElements, or *slackTexts in context blocks
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"` // shown in notifications
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// slackEscape escapes the characters that are special in Slack's mrkdwn
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slackField(name string, value string) *slackText {
+	return &slackText{Type: "mrkdwn", Text: truncate("*"+name+"*\n"+slackEscape.Replace(value), 2000)}
+}
+
+func makeSlackMessage(subject string, data *Data) *slackMessage {
+	var fields []*slackText
+	if len(data.DNSNames) != 0 {
+		fields = append(fields, slackField("Domain", data.DNSNames[0]))
+	}
+	if data.Issuer != "" {
+		fields = append(fields, slackField("Issuer", data.Issuer))
+	}
+	if data.NotBefore != nil {
+		fields = append(fields, slackField("Not Before", data.NotBefore.UTC().Format(time.RFC3339)))
+	}
+	if data.NotAfter != nil {
+		fields = append(fields, slackField("Not After", data.NotAfter.UTC().Format(time.RFC3339)))
+	}
+	if len(data.MatchIDs) != 0 {
+		fields = append(fields, slackField("Matched", strings.Join(data.MatchIDs, ", ")))
+	}
+	typeName := "Certificate"
+	if data.IsPrecert {
+		typeName = "Precertificate"
+	}
+	fields = append(fields, slackField("Type", typeName))
+
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: truncate(subject, 150)}},
+		{Type: "section", Fields: fields},
+	}
+	if len(data.DNSNames) > 1 || len(data.IPAddresses) != 0 {
+		var sans []string
+		for _, dnsName := range data.DNSNames {
+			sans = append(sans, "`"+slackEscape.Replace(dnsName)+"`")
+		}
+		for _, addr := range data.IPAddresses {
+			sans = append(sans, "`"+addr.String()+"`")
+		}
+		blocks = append(blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: truncate("*SANs*\n"+strings.Join(sans, ", "), 3000)}})
+	}
+	blocks = append(blocks, slackBlock{Type: "actions", Elements: []interface{}{
+		slackElement{Type: "button", Text: &slackText{Type: "plain_text", Text: "View on crt.sh"}, URL: data.CrtShURL},
+		slackElement{Type: "button", Text: &slackText{Type: "plain_text", Text: "Log Entry"}, URL: data.LogEntryURL},
+	}})
+	return &slackMessage{Text: subject, Blocks: blocks}
+}
+
+// makeSlackTextMessage makes a message from a custom body template's output,
+// which is Slack mrkdwn
+func makeSlackTextMessage(subject string, text string) *slackMessage {
+	return &slackMessage{Text: subject, Blocks: []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: truncate(subject, 150)}},
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: truncate(text, 3000)}},
+	}}
+}
+
+// Slack allows at most 50 blocks per message
+const slackMaxDigestEntries = 40
+
+func makeSlackDigestMessage(subject string, digest *DigestData) *slackMessage {
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: truncate(subject, 150)}},
+	}
+	for i, data := range digest.Entries {
+		if i == slackMaxDigestEntries {
+			more := fmt.Sprintf("and %d more", len(digest.Entries)-i)
+			blocks = append(blocks, slackBlock{Type: "context", Elements: []interface{}{&slackText{Type: "mrkdwn", Text: more}}})
+			break
+		}
+		text := "*<" + data.CrtShURL + "|" + slackEscape.Replace(summarize(data.DNSNames)) + ">*"
+		if data.Issuer != "" {
+			text += "\nIssuer: " + slackEscape.Replace(data.Issuer)
+		}
+		if data.NotAfter != nil {
+			text += "\nNot After: " + data.NotAfter.UTC().Format(time.RFC3339)
+		}
+		text += "\n<" + data.LogEntryURL + "|Log Entry>"
+		blocks = append(blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: truncate(text, 3000)}})
+	}
+	return &slackMessage{Text: subject, Blocks: blocks}
+}
+
+func (notifier *SlackNotifier) Notify(info *certspotter.EntryInfo) error {
+	data := MakeData(info)
+	subject, err := execute(notifier.Template.Subject, data)
+	if err != nil {
+		return fmt.Errorf("Error executing subject template: %s", err)
+	}
+	subject = strings.TrimSpace(subject)
+	message := makeSlackMessage(subject, data)
+	if notifier.Template.Body != nil {
+		text, err := execute(notifier.Template.Body, data)
+		if err != nil {
+			return fmt.Errorf("Error executing body template: %s", err)
+		}
+		message = makeSlackTextMessage(subject, text)
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	for _, url := range notifier.webhook.destinations(info) {
+		if err := notifier.webhook.Post(url, body); err != nil {
+			return fmt.Errorf("Error posting to Slack about %s: %s", info.Fingerprint(), err)
+		}
+	}
+	return nil
+}
+
+func (notifier *SlackNotifier) NotifyDigest(infos []*certspotter.EntryInfo) error {
+	urls, groups := notifier.webhook.digestDestinations(infos)
+	for _, url := range urls {
+		digest := MakeDigestData(groups[url])
+		subject, err := execute(notifier.Digest.Subject, digest)
+		if err != nil {
+			return fmt.Errorf("Error executing subject template: %s", err)
+		}
+		subject = strings.TrimSpace(subject)
+		message := makeSlackDigestMessage(subject, digest)
+		if notifier.Digest.Body != nil {
+			text, err := execute(notifier.Digest.Body, digest)
+			if err != nil {
+				return fmt.Errorf("Error executing body template: %s", err)
+			}
+			message = makeSlackTextMessage(subject, text)
+		}
+		body, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		if err := notifier.webhook.Post(url, body); err != nil {
+			return fmt.Errorf("Error posting digest of %d certificates to Slack: %s", len(groups[url]), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// The SD-ID of the structured data element in syslog messages.  32473 is the
+// enterprise number reserved for documentation (RFC 5612), as certspotter
+// doesn't have one of its own.
+const SyslogSDID = "certspotter@32473"
+
+// Syslog facilities and severities (RFC 5424 section 6.2.1)
+const (
+	SyslogFacilityUser = 1
+	SyslogNotice       = 5
+)
+
+// SyslogNotifier sends an RFC 5424 syslog message about each entry over UDP,
+// TCP, or TLS.  The message contains the notification's subject, and a
+// structured data element with the certificate's domains, issuer,
+// fingerprint, matches, and log entry.  Over TCP and TLS, messages are framed
+// with octet counting (RFC 6587 and RFC 5425).
+type SyslogNotifier struct {
+	Network  string // udp, tcp, or tls
+	Addr     string // host:port
+	Facility int
+	Severity int
+	Hostname string
+	Timeout  time.Duration
+	Template *Template // only the subject is used, as the message
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogNotifier creates a SyslogNotifier for the server at |serverURL|,
+// of the form udp://HOST[:PORT], tcp://HOST[:PORT], or tls://HOST[:PORT].
+// The default port is 514, or 6514 for TLS.
+func NewSyslogNotifier(serverURL string) (*SyslogNotifier, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	var defaultPort string
+	switch u.Scheme {
+	case "udp", "tcp":
+		defaultPort = "514"
+	case "tls":
+		defaultPort = "6514"
+	default:
+		return nil, fmt.Errorf("%s: syslog server must be udp://, tcp://, or tls://", serverURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s: no host specified", serverURL)
+	}
+	addr := u.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultPort)
+	}
+	hostname, _ := os.Hostname()
+	return &SyslogNotifier{
+		Network:  u.Scheme,
+		Addr:     addr,
+		Facility: SyslogFacilityUser,
+		Severity: SyslogNotice,
+		Hostname: hostname,
+		Timeout:  30 * time.Second,
+		Template: DefaultTemplate,
+	}, nil
+}
+
+func (notifier *SyslogNotifier) Channel() string {
+	return "syslog"
+}
+
+var sdParamEscape = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+func writeSDParam(buf *bytes.Buffer, name string, value string) {
+	fmt.Fprintf(buf, ` %s="%s"`, name, sdParamEscape.Replace(value))
+}
+
+// syslogHeaderField returns |value| as a header field: printable ASCII
+// without spaces, at most |max| bytes, or "-" if empty
+func syslogHeaderField(value string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, value)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+func (notifier *SyslogNotifier) formatMessage(subject string, data *Data) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s certspotter %d match [%s",
+		notifier.Facility*8+notifier.Severity,
+		time.Now().UTC().Format("2006-01-02T15:04:05.000000Z"),
+		syslogHeaderField(notifier.Hostname, 255),
+		os.Getpid(),
+		SyslogSDID)
+	for _, dnsName := range data.DNSNames {
+		writeSDParam(&buf, "domain", dnsName)
+	}
+	for _, addr := range data.IPAddresses {
+		writeSDParam(&buf, "ip", addr.String())
+	}
+	if data.Issuer != "" {
+		writeSDParam(&buf, "issuer", data.Issuer)
+	}
+	writeSDParam(&buf, "fingerprint", data.Fingerprint)
+	for _, id := range data.MatchIDs {
+		writeSDParam(&buf, "match", id)
+	}
+	writeSDParam(&buf, "precert", strconv.FormatBool(data.IsPrecert))
+	writeSDParam(&buf, "log", data.LogURI)
+	writeSDParam(&buf, "index", strconv.FormatInt(data.Index, 10))
+	buf.WriteString("] \xEF\xBB\xBF") // BOM, indicating that the message is UTF-8
+	buf.WriteString(subject)
+	return buf.Bytes()
+}
+
+func (notifier *SyslogNotifier) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: notifier.Timeout}
+	if notifier.Network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", notifier.Addr, nil)
+	}
+	return dialer.Dial(notifier.Network, notifier.Addr)
+}
+
+// send sends |message|, connecting if necessary.  notifier.mu must be held.
+func (notifier *SyslogNotifier) send(message []byte) error {
+	if notifier.conn == nil {
+		conn, err := notifier.dial()
+		if err != nil {
+			return err
+		}
+		notifier.conn = conn
+	}
+	if notifier.Network != "udp" {
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+	notifier.conn.SetWriteDeadline(time.Now().Add(notifier.Timeout))
+	if _, err := notifier.conn.Write(message); err != nil {
+		notifier.conn.Close()
+		notifier.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (notifier *SyslogNotifier) Notify(info *certspotter.EntryInfo) error {
+	data := MakeData(info)
+	subject, err := execute(notifier.Template.Subject, data)
+	if err != nil {
+		return fmt.Errorf("Error executing subject template: %s", err)
+	}
+	message := notifier.formatMessage(strings.TrimSpace(subject), data)
+
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	err = notifier.send(message)
+	if err != nil && notifier.Network != "udp" {
+		// The server may have closed an idle connection; try again with a
+		// new one
+		err = notifier.send(message)
+	}
+	if err != nil {
+		return fmt.Errorf("Error sending syslog message about %s to %s: %s", info.Fingerprint(), notifier.Addr, err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// Values for TheHiveNotifier.Severity
+const (
+	TheHiveLow      = 1
+	TheHiveMedium   = 2
+	TheHiveHigh     = 3
+	TheHiveCritical = 4
+)
+
+// ParseTheHiveSeverity parses the name of a TheHive severity (low, medium,
+// high, or critical)
+func ParseTheHiveSeverity(name string) (int, error) {
+	switch name {
+	case "low":
+		return TheHiveLow, nil
+	case "medium":
+		return TheHiveMedium, nil
+	case "high":
+		return TheHiveHigh, nil
+	case "critical":
+		return TheHiveCritical, nil
+	default:
+		return 0, fmt.Errorf("Invalid TheHive severity `%s': must be low, medium, high, or critical", name)
+	}
+}
+
+// TheHiveNotifier raises an alert in TheHive (version 5, via API v1) for
+// each entry, with the certificate's DNS names, IP addresses, fingerprint,
+// and issuer attached as observables, so they can be analyzed with Cortex.
+// The alert's sourceRef is the certificate's fingerprint, so the same
+// certificate seen in several logs raises only one alert.  If the
+// certificate is reported again because it was revoked, the sourceRef has
+// "-revoked" appended, so that a second alert is raised.
+//
+// The severity of the alert is the highest in Severities of its matches'
+// watchlist item IDs and categories (for example, "lookalike" could be high
+// and "issuer" medium), or Severity if none of them are listed.
+type TheHiveNotifier struct {
+	URL        string // base URL of TheHive
+	Severity   int
+	Severities map[string]int // watchlist item ID or match category => severity
+	Tags       []string
+
+	Template *Template // the subject is the alert's title and the body its description
+	webhook  *WebhookNotifier
+}
+
+func NewTheHiveNotifier(url string, apiKey string) *TheHiveNotifier {
+	webhook := NewWebhookNotifier("")
+	webhook.Headers = http.Header{"Authorization": {"Bearer " + apiKey}}
+	return &TheHiveNotifier{
+		URL:        strings.TrimSuffix(url, "/"),
+		Severity:   TheHiveMedium,
+		Severities: make(map[string]int),
+		Template:   DefaultTemplate,
+		webhook:    webhook,
+	}
+}
+
+func (notifier *TheHiveNotifier) Channel() string {
+	return "thehive"
+}
+
+// severity returns the severity of an alert about |info|
+func (notifier *TheHiveNotifier) severity(info *certspotter.EntryInfo) int {
+	severity := 0
+	for _, match := range info.Matches {
+		for _, key := range []string{match.ID, match.Category} {
+			if s, ok := notifier.Severities[key]; ok && s > severity {
+				severity = s
+			}
+		}
+	}
+	if severity == 0 {
+		return notifier.Severity
+	}
+	return severity
+}
+
+type theHiveObservable struct {
+	DataType string `json:"dataType"`
+	Data     string `json:"data"`
+	Message  string `json:"message,omitempty"`
+}
+
+type theHiveAlert struct {
+	Type         string              `json:"type"`
+	Source       string              `json:"source"`
+	SourceRef    string              `json:"sourceRef"`
+	Title        string              `json:"title"`
+	Description  string              `json:"description"`
+	Severity     int                 `json:"severity"`
+	Date         int64               `json:"date"` // milliseconds since the epoch
+	Tags         []string            `json:"tags,omitempty"`
+	ExternalLink string              `json:"externalLink,omitempty"`
+	Observables  []theHiveObservable `json:"observables,omitempty"`
+}
+
+// theHiveObservables returns the observables to attach to an alert about
+// |data|
+func theHiveObservables(data *Data) []theHiveObservable {
+	observables := []theHiveObservable{
+		{DataType: "hash", Data: data.Fingerprint, Message: "SHA-256 fingerprint of the certificate"},
+	}
+	seen := make(map[string]bool)
+	for _, dnsName := range data.DNSNames {
+		domain := strings.ToLower(strings.TrimPrefix(dnsName, "*."))
+		if !seen[domain] {
+			seen[domain] = true
+			observables = append(observables, theHiveObservable{DataType: "domain", Data: domain, Message: "DNS name of the certificate"})
+		}
+	}
+	for _, ip := range data.IPAddresses {
+		observables = append(observables, theHiveObservable{DataType: "ip", Data: ip.String(), Message: "IP address of the certificate"})
+	}
+	if data.Issuer != "" {
+		observables = append(observables, theHiveObservable{DataType: "other", Data: data.Issuer, Message: "Issuer of the certificate"})
+	}
+	return observables
+}
+
+func (notifier *TheHiveNotifier) Notify(info *certspotter.EntryInfo) error {
+	data := MakeData(info)
+	subject, err := execute(notifier.Template.Subject, data)
+	if err != nil {
+		return fmt.Errorf("Error executing subject template: %s", err)
+	}
+	body, err := execute(notifier.Template.Body, data)
+	if err != nil {
+		return fmt.Errorf("Error executing body template: %s", err)
+	}
+	sourceRef := data.Fingerprint
+	if info.IsRevocationFollowUp() {
+		sourceRef += "-revoked"
+	}
+	tags := append([]string{}, notifier.Tags...)
+	for _, category := range info.MatchCategories() {
+		tags = append(tags, "certspotter:"+category)
+	}
+	alert := theHiveAlert{
+		Type:         "certificate",
+		Source:       "certspotter",
+		SourceRef:    sourceRef,
+		Title:        truncate(strings.TrimSpace(subject), 512),
+		Description:  "```\n" + strings.TrimSpace(body) + "\n```",
+		Severity:     notifier.severity(info),
+		Date:         data.Timestamp.UnixNano() / 1e6,
+		Tags:         tags,
+		ExternalLink: data.CrtShURL,
+		Observables:  theHiveObservables(data),
+	}
+	requestBody, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	err = notifier.webhook.Request("POST", notifier.URL+"/api/v1/alert", requestBody, nil)
+	if httpErr, ok := err.(*HTTPError); ok && httpErr.StatusCode == http.StatusBadRequest && bytes.Contains(httpErr.Body, []byte("already exists")) {
+		// The certificate was already alerted about, e.g. from another log
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Error raising TheHive alert for %s: %s", info.Fingerprint(), err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/internal/certtest"
+)
+
+func TestTheHiveSeverity(t *testing.T) {
+	notifier := NewTheHiveNotifier("https://thehive.example.com/", "key")
+	notifier.Severities["lookalike"] = TheHiveHigh
+	notifier.Severities["issuer"] = TheHiveLow
+	notifier.Severities["bank"] = TheHiveCritical
+
+	tests := []struct {
+		matches  []certspotter.Match
+		expected int
+	}{
+		{nil, TheHiveMedium},
+		{[]certspotter.Match{{Category: "domain", ID: "example"}}, TheHiveMedium},
+		{[]certspotter.Match{{Category: "issuer"}}, TheHiveLow},
+		{[]certspotter.Match{{Category: "issuer"}, {Category: "lookalike"}}, TheHiveHigh},
+		{[]certspotter.Match{{Category: "lookalike", ID: "bank"}}, TheHiveCritical},
+		{[]certspotter.Match{{Category: "domain", ID: "bank"}, {Category: "issuer"}}, TheHiveCritical},
+	}
+	for _, test := range tests {
+		info := &certspotter.EntryInfo{Matches: test.matches}
+		if severity := notifier.severity(info); severity != test.expected {
+			t.Errorf("severity(%v) = %d, expected %d", test.matches, severity, test.expected)
+		}
+	}
+}
+
+func TestTheHiveObservables(t *testing.T) {
+	info := certtest.EntryInfo(t, []string{"Example.com", "*.example.com", "www.example.com"}, []net.IP{net.ParseIP("192.0.2.1")})
+	data := MakeData(info)
+	expected := []theHiveObservable{
+		{DataType: "hash", Data: data.Fingerprint, Message: "SHA-256 fingerprint of the certificate"},
+		{DataType: "domain", Data: "example.com", Message: "DNS name of the certificate"},
+		{DataType: "domain", Data: "www.example.com", Message: "DNS name of the certificate"},
+		{DataType: "ip", Data: "192.0.2.1", Message: "IP address of the certificate"},
+		{DataType: "other", Data: data.Issuer, Message: "Issuer of the certificate"},
+	}
+	if observables := theHiveObservables(data); !reflect.DeepEqual(observables, expected) {
+		t.Errorf("theHiveObservables = %+v, expected %+v", observables, expected)
+	}
+}
+
+func TestTheHiveNotify(t *testing.T) {
+	var alerts []theHiveAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || req.URL.Path != "/api/v1/alert" {
+			t.Errorf("Unexpected request %s %s", req.Method, req.URL.Path)
+		}
+		if auth := req.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Authorization header is %q", auth)
+		}
+		body, _ := io.ReadAll(req.Body)
+		var alert theHiveAlert
+		if err := json.Unmarshal(body, &alert); err != nil {
+			t.Errorf("Error decoding alert: %s", err)
+		}
+		for _, existing := range alerts {
+			if existing.SourceRef == alert.SourceRef {
+				http.Error(w, `{"type":"CreateError","message":"Alert already exists"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		alerts = append(alerts, alert)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	notifier := NewTheHiveNotifier(server.URL+"/", "secret")
+	notifier.Tags = []string{"ct"}
+	info := certtest.EntryInfo(t, []string{"www.example.com"}, nil)
+	info.Matches = []certspotter.Match{{Category: "domain", ID: "example"}}
+	fingerprint := info.Fingerprint()
+
+	if err := notifier.Notify(info); err != nil {
+		t.Fatal(err)
+	}
+	// Seeing it again, e.g. in another log, doesn't raise another alert
+	if err := notifier.Notify(info); err != nil {
+		t.Fatalf("Error notifying about the same certificate again: %s", err)
+	}
+	// But its revocation does
+	info.Matches = append(info.Matches, certspotter.Match{Category: certspotter.MatchRevoked})
+	if err := notifier.Notify(info); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(alerts) != 2 {
+		t.Fatalf("%d alerts were raised, expected 2", len(alerts))
+	}
+	if alerts[0].SourceRef != fingerprint {
+		t.Errorf("sourceRef is %q, expected %q", alerts[0].SourceRef, fingerprint)
+	}
+	if alerts[1].SourceRef != fingerprint+"-revoked" {
+		t.Errorf("sourceRef of revocation is %q, expected %q", alerts[1].SourceRef, fingerprint+"-revoked")
+	}
+	if expected := []string{"ct", "certspotter:domain"}; !reflect.DeepEqual(alerts[0].Tags, expected) {
+		t.Errorf("Tags are %v, expected %v", alerts[0].Tags, expected)
+	}
+	if expected := []string{"ct", "certspotter:domain", "certspotter:revoked"}; !reflect.DeepEqual(alerts[1].Tags, expected) {
+		t.Errorf("Tags of revocation are %v, expected %v", alerts[1].Tags, expected)
+	}
+	if alerts[0].Severity != TheHiveMedium || alerts[0].ExternalLink != "https://crt.sh/?sha256="+fingerprint {
+		t.Errorf("Unexpected alert %+v", alerts[0])
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const WebhookSignatureHeader = "X-Certspotter-Signature"
+
+// WebhookNotifier POSTs a JSON object (see Data) about each entry to a URL.
+// If Secret is set, the request has a header
+//
+//	X-Certspotter-Signature: sha256=HMAC
+//
+// where HMAC is the hex HMAC-SHA256 of the body, keyed by Secret.  Requests
+// which fail with a network error, 429, or 5xx status are retried with
+// exponential backoff, up to Retries times.
+//
+// If Template (or Digest, for NotifyDigest) is set, the request body is the
+// output of its body template instead.
+type WebhookNotifier struct {
+	URL      string            // default destination
+	Routes   map[string]string // watchlist item ID => destination
+	Secret   []byte
+	Headers  http.Header // added to each request
+	Retries  int
+	Template *Template
+	Digest   *Template
+
+	httpClient *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:        url,
+		Routes:     make(map[string]string),
+		Retries:    3,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// LoadRoutes reads destinations for particular watchlist items from the
+// file named by |filename|, which contains lines of the form "ID URL".
+// Empty lines and lines starting with # are ignored.
+func (notifier *WebhookNotifier) LoadRoutes(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected ID and URL", filename, lineNumber)
+		}
+		notifier.Routes[fields[0]] = fields[1]
+	}
+	return scanner.Err()
+}
+
+func (notifier *WebhookNotifier) Channel() string {
+	return "webhook"
+}
+
+// destinations returns the URLs to send a notification about |info| to: the
+// routes for its matches, or the default URL if there are none
+func (notifier *WebhookNotifier) destinations(info *certspotter.EntryInfo) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, id := range info.MatchIDs() {
+		if url, ok := notifier.Routes[id]; ok && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 && notifier.URL != "" {
+		urls = append(urls, notifier.URL)
+	}
+	return urls
+}
+
+// Sign returns the value of the signature header for |body|
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// HTTPError is returned when a request fails with a non-2xx status
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       []byte // the beginning of the response body
+}
+
+func (err *HTTPError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Status, bytes.TrimSpace(err.Body))
+}
+
+// send makes a request to |url| once, decoding the JSON response into
+// |response| if it's non-nil.  It returns whether a failure should be
+// retried.
+func (notifier *WebhookNotifier) send(method string, url string, body []byte, response interface{}) (bool, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, bodyReader)
+	if err != nil {
+		return false, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "certspotter")
+	for name, values := range notifier.Headers {
+		req.Header[name] = values
+	}
+	if notifier.Secret != nil {
+		req.Header.Set(WebhookSignatureHeader, Sign(notifier.Secret, body))
+	}
+	resp, err := notifier.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: respBody}
+	}
+	if response != nil {
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			return false, fmt.Errorf("Error decoding response: %s", err)
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return false, nil
+}
+
+// Request makes a request to |url| with the given method and JSON |body|
+// (which may be nil), retrying if necessary.  If |response| is non-nil, the
+// JSON response is decoded into it.
+func (notifier *WebhookNotifier) Request(method string, url string, body []byte, response interface{}) error {
+	for attempt := 0; ; attempt++ {
+		retry, err := notifier.send(method, url, body, response)
+		if err == nil || !retry || attempt >= notifier.Retries {
+			return err
+		}
+		time.Sleep(time.Duration(1<<uint(attempt)) * time.Second)
+	}
+}
+
+// Post POSTs |body| to |url|, retrying if necessary
+func (notifier *WebhookNotifier) Post(url string, body []byte) error {
+	return notifier.Request("POST", url, body, nil)
+}
+
+// digestDestinations groups |infos| by the URLs to send them to (see
+// destinations)
+func (notifier *WebhookNotifier) digestDestinations(infos []*certspotter.EntryInfo) ([]string, map[string][]*certspotter.EntryInfo) {
+	var urls []string
+	groups := make(map[string][]*certspotter.EntryInfo)
+	for _, info := range infos {
+		for _, url := range notifier.destinations(info) {
+			if _, ok := groups[url]; !ok {
+				urls = append(urls, url)
+			}
+			groups[url] = append(groups[url], info)
+		}
+	}
+	return urls, groups
+}
+
+// makeBody returns the body of a request about |data|, using |tmpl| if set
+func makeBody(tmpl *Template, data interface{}) ([]byte, error) {
+	if tmpl != nil && tmpl.Body != nil {
+		body, err := execute(tmpl.Body, data)
+		if err != nil {
+			return nil, fmt.Errorf("Error executing body template: %s", err)
+		}
+		return []byte(body), nil
+	}
+	return json.Marshal(data)
+}
+
+func (notifier *WebhookNotifier) Notify(info *certspotter.EntryInfo) error {
+	body, err := makeBody(notifier.Template, MakeData(info))
+	if err != nil {
+		return err
+	}
+	for _, url := range notifier.destinations(info) {
+		if err := notifier.Post(url, body); err != nil {
+			return fmt.Errorf("Error posting webhook about %s to %s: %s", info.Fingerprint(), url, err)
+		}
+	}
+	return nil
+}
+
+// NotifyDigest POSTs a JSON object (see DigestData) about |infos| to each
+// destination, containing the entries routed to it
+func (notifier *WebhookNotifier) NotifyDigest(infos []*certspotter.EntryInfo) error {
+	urls, groups := notifier.digestDestinations(infos)
+	for _, url := range urls {
+		body, err := makeBody(notifier.Digest, MakeDigestData(groups[url]))
+		if err != nil {
+			return err
+		}
+		if err := notifier.Post(url, body); err != nil {
+			return fmt.Errorf("Error posting webhook digest of %d certificates to %s: %s", len(groups[url]), url, err)
+		}
+	}
+	return nil
+}
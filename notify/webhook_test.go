@@ -0,0 +1,61 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			t.Errorf("Method is %s, expected GET", req.Method)
+		}
+		if req.ContentLength > 0 || req.Header.Get("Content-Type") != "" {
+			t.Errorf("GET request has a body")
+		}
+		if value := req.Header.Get("X-Api-Key"); value != "key" {
+			t.Errorf("X-Api-Key header is %q", value)
+		}
+		if value := req.Header.Get("User-Agent"); value != "certspotter" {
+			t.Errorf("User-Agent header is %q", value)
+		}
+		if req.URL.Path == "/missing" {
+			http.Error(w, "not here", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"value"}`))
+	}))
+	defer server.Close()
+
+	notifier := NewWebhookNotifier("")
+	notifier.Headers = http.Header{"X-Api-Key": {"key"}}
+	var response struct {
+		Name string `json:"name"`
+	}
+	if err := notifier.Request("GET", server.URL+"/thing", nil, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Name != "value" {
+		t.Errorf("Decoded response is %+v", response)
+	}
+
+	err := notifier.Request("GET", server.URL+"/missing", nil, nil)
+	httpErr, ok := err.(*HTTPError)
+	if !ok {
+		t.Fatalf("Request returned %v, expected an HTTPError", err)
+	}
+	if httpErr.StatusCode != http.StatusNotFound || string(httpErr.Body) != "not here\n" {
+		t.Errorf("Unexpected error %+v", httpErr)
+	}
+}
@@ -0,0 +1,504 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Values of RevocationStatus.Status
+const (
+	OCSPGood    = "good"
+	OCSPRevoked = "revoked"
+	OCSPUnknown = "unknown" // the responder doesn't know the certificate
+	OCSPError   = "error"   // the status couldn't be determined
+)
+
+var (
+	oidExtensionAuthorityInfoAccess = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 1}
+	oidAccessMethodOCSP             = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1}
+	oidOCSPBasicResponse            = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidHashSHA1                     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidPrecertSigningCert           = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 4}
+)
+
+var crlReasons = map[int]string{
+	0:  "unspecified",
+	1:  "keyCompromise",
+	2:  "cACompromise",
+	3:  "affiliationChanged",
+	4:  "superseded",
+	5:  "cessationOfOperation",
+	6:  "certificateHold",
+	8:  "removeFromCRL",
+	9:  "privilegeWithdrawn",
+	10: "aACompromise",
+}
+
+// Signature algorithms which OCSP responses may be signed with
+var ocspSignatureAlgorithms = []struct {
+	oid       asn1.ObjectIdentifier
+	algorithm x509.SignatureAlgorithm
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+	{asn1.ObjectIdentifier{1, 3, 101, 112}, x509.PureEd25519},
+}
+
+// RevocationStatus is the revocation status of a certificate, according to
+// its OCSP responder or CRL
+type RevocationStatus struct {
+	Status     string     `json:"status"` // OCSPGood, OCSPRevoked, etc.
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Reason     string     `json:"reason,omitempty"`      // CRL reason of a revocation, such as keyCompromise
+	Responder  string     `json:"responder"`             // URL of the OCSP responder or CRL
+	ThisUpdate *time.Time `json:"this_update,omitempty"` // when the responder last knew the status to be correct
+	Error      string     `json:"error,omitempty"`       // for OCSPError
+}
+
+func (status *RevocationStatus) String() string {
+	switch status.Status {
+	case OCSPRevoked:
+		s := "revoked at " + status.RevokedAt.String()
+		if status.Reason != "" {
+			s += " (" + status.Reason + ")"
+		}
+		return s
+	case OCSPError:
+		return "error: " + status.Error
+	default:
+		return status.Status
+	}
+}
+
+type authorityInfoAccess struct {
+	Method   asn1.ObjectIdentifier
+	Location asn1.RawValue
+}
+
+// ParseOCSPServers returns the URLs of the certificate's OCSP responders,
+// from its Authority Information Access extension
+func (tbs *TBSCertificate) ParseOCSPServers() ([]string, error) {
+	var servers []string
+	for _, ext := range tbs.GetExtension(oidExtensionAuthorityInfoAccess) {
+		var descriptions []authorityInfoAccess
+		if rest, err := asn1.Unmarshal(ext.Value, &descriptions); err != nil {
+			return nil, fmt.Errorf("failed to parse Authority Information Access: %s", err)
+		} else if len(rest) > 0 {
+			return nil, fmt.Errorf("trailing data after Authority Information Access: %v", rest)
+		}
+		for _, description := range descriptions {
+			if description.Method.Equal(oidAccessMethodOCSP) && description.Location.Class == asn1.ClassContextSpecific && description.Location.Tag == sanURI {
+				servers = append(servers, string(description.Location.Bytes))
+			}
+		}
+	}
+	return servers, nil
+}
+
+type ocspCertID struct {
+	HashAlgorithm  algorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		RequestList []struct {
+			ReqCert ocspCertID
+		}
+	}
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type basicOCSPResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm algorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version     int `asn1:"optional,explicit,default:0,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+	Extensions  []Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag       `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag       `asn1:"tag:2,optional"`
+	ThisUpdate time.Time       `asn1:"generalized"`
+	NextUpdate time.Time       `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []Extension     `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time     `asn1:"generalized"`
+	Reason         asn1.RawValue `asn1:"explicit,tag:0,optional"` // [0] ENUMERATED; a RawValue so its absence can be told from unspecified (0)
+}
+
+// reason returns the name of the revocation reason, or the empty string if
+// there isn't one
+func (info *ocspRevokedInfo) reason() (string, error) {
+	if len(info.Reason.FullBytes) == 0 {
+		return "", nil
+	}
+	var reason asn1.Enumerated
+	if rest, err := asn1.Unmarshal(info.Reason.Bytes, &reason); err != nil {
+		return "", fmt.Errorf("Malformed revocation reason: %s", err)
+	} else if len(rest) != 0 {
+		return "", errors.New("Malformed revocation reason: trailing data")
+	}
+	if name, ok := crlReasons[int(reason)]; ok {
+		return name, nil
+	}
+	return fmt.Sprintf("reason %d", int(reason)), nil
+}
+
+// How long OCSPChecker caches statuses, unless the response's nextUpdate is
+// sooner, and how long it caches errors
+const (
+	ocspCacheTime      = 1 * time.Hour
+	ocspErrorCacheTime = 5 * time.Minute
+)
+
+// Responses whose thisUpdate is more than ocspClockSkew in the future, or
+// whose nextUpdate is more than ocspClockSkew in the past, are rejected.
+// Responses without a nextUpdate are rejected once they're older than
+// ocspMaxAge (the longest validity the Baseline Requirements allow).
+const (
+	ocspClockSkew = 5 * time.Minute
+	ocspMaxAge    = 10 * 24 * time.Hour
+)
+
+var errOCSPRateLimited = errors.New("OCSP request rate limit exceeded")
+
+type ocspCacheEntry struct {
+	status  *RevocationStatus
+	expires time.Time
+}
+
+// OCSPChecker queries the OCSP responders of certificates.  Statuses are
+// cached, and requests to each responder are limited to RateLimit per
+// second, if set.  Since Check is called from the entry pipeline, it waits
+// at most MaxWait for the rate limit; if a responder is so busy that it
+// would have to wait longer, the status is an error instead.
+type OCSPChecker struct {
+	Client    *http.Client
+	RateLimit float64
+	MaxWait   time.Duration
+
+	mu          sync.Mutex
+	cache       map[string]ocspCacheEntry
+	nextRequest map[string]time.Time // responder host => when it may next be queried
+}
+
+func NewOCSPChecker() *OCSPChecker {
+	return &OCSPChecker{Client: &http.Client{Timeout: 10 * time.Second}, RateLimit: 1, MaxWait: 10 * time.Second}
+}
+
+// Check returns the revocation status of the entry's certificate, or nil if
+// it doesn't have an OCSP responder.  It's issued by the first certificate
+// in its chain, apart from any precertificate signing certificate.
+func (checker *OCSPChecker) Check(info *EntryInfo) *RevocationStatus {
+	if info.CertInfo == nil || info.CertInfo.SerialNumberParseError != nil {
+		return nil
+	}
+	servers, err := info.CertInfo.TBS.ParseOCSPServers()
+	if err != nil {
+		return &RevocationStatus{Status: OCSPError, Error: err.Error()}
+	} else if len(servers) == 0 {
+		return nil
+	}
+	responder := servers[0]
+	issuer, err := ocspIssuer(info.FullChain)
+	if err != nil {
+		return &RevocationStatus{Status: OCSPError, Responder: responder, Error: err.Error()}
+	}
+	certID, err := makeOCSPCertID(info.CertInfo, issuer)
+	if err != nil {
+		return &RevocationStatus{Status: OCSPError, Responder: responder, Error: err.Error()}
+	}
+
+	cacheKey := responder + " " + hex.EncodeToString(certID.IssuerKeyHash) + " " + certID.SerialNumber.Text(16)
+	if status := checker.cached(cacheKey); status != nil {
+		return status
+	}
+	status, nextUpdate, err := checker.query(responder, certID, issuer)
+	expires := time.Now().Add(ocspCacheTime)
+	if err == errOCSPRateLimited {
+		// Not the responder's fault, so don't cache it
+		return &RevocationStatus{Status: OCSPError, Responder: responder, Error: err.Error()}
+	} else if err != nil {
+		status = &RevocationStatus{Status: OCSPError, Responder: responder, Error: err.Error()}
+		expires = time.Now().Add(ocspErrorCacheTime)
+	} else if !nextUpdate.IsZero() && nextUpdate.Before(expires) {
+		expires = nextUpdate
+	}
+	checker.store(cacheKey, ocspCacheEntry{status: status, expires: expires})
+	return status
+}
+
+func (checker *OCSPChecker) cached(key string) *RevocationStatus {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	if entry, ok := checker.cache[key]; ok && time.Now().Before(entry.expires) {
+		return entry.status
+	}
+	return nil
+}
+
+func (checker *OCSPChecker) store(key string, entry ocspCacheEntry) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	if checker.cache == nil {
+		checker.cache = make(map[string]ocspCacheEntry)
+	}
+	checker.cache[key] = entry
+}
+
+// waitForRateLimit waits until |host| may be queried again without
+// exceeding RateLimit.  It returns false, without waiting, if that would
+// take longer than MaxWait (if set).
+func (checker *OCSPChecker) waitForRateLimit(host string) bool {
+	if checker.RateLimit <= 0 {
+		return true
+	}
+	checker.mu.Lock()
+	if checker.nextRequest == nil {
+		checker.nextRequest = make(map[string]time.Time)
+	}
+	now := time.Now()
+	next := checker.nextRequest[host]
+	if next.Before(now) {
+		next = now
+	}
+	if checker.MaxWait > 0 && next.Sub(now) > checker.MaxWait {
+		checker.mu.Unlock()
+		return false
+	}
+	checker.nextRequest[host] = next.Add(time.Duration(float64(time.Second) / checker.RateLimit))
+	checker.mu.Unlock()
+	time.Sleep(next.Sub(now))
+	return true
+}
+
+func ocspIssuer(chain [][]byte) (*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("Issuer is not in the chain")
+	}
+	for _, der := range chain[1:] {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing issuer: %s", err)
+		}
+		isPrecertSigner := false
+		for _, usage := range cert.UnknownExtKeyUsage {
+			if usage.Equal(oidPrecertSigningCert) {
+				isPrecertSigner = true
+			}
+		}
+		if !isPrecertSigner {
+			return cert, nil
+		}
+	}
+	return nil, errors.New("Issuer is not in the chain")
+}
+
+func makeOCSPCertID(cert *CertInfo, issuer *x509.Certificate) (*ocspCertID, error) {
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, fmt.Errorf("Error parsing issuer's public key: %s", err)
+	}
+	nameHash := sha1.Sum(cert.TBS.GetRawIssuer())
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return &ocspCertID{
+		HashAlgorithm:  algorithmIdentifier{Algorithm: oidHashSHA1, Parameters: asn1.NullRawValue},
+		IssuerNameHash: nameHash[:],
+		IssuerKeyHash:  keyHash[:],
+		SerialNumber:   cert.SerialNumber,
+	}, nil
+}
+
+func (checker *OCSPChecker) query(responder string, certID *ocspCertID, issuer *x509.Certificate) (*RevocationStatus, time.Time, error) {
+	responderURL, err := url.Parse(responder)
+	if err != nil || (responderURL.Scheme != "http" && responderURL.Scheme != "https") {
+		return nil, time.Time{}, fmt.Errorf("Invalid OCSP responder URL `%s'", responder)
+	}
+	var request ocspRequest
+	request.TBSRequest.RequestList = make([]struct{ ReqCert ocspCertID }, 1)
+	request.TBSRequest.RequestList[0].ReqCert = *certID
+	requestBytes, err := asn1.Marshal(request)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if !checker.waitForRateLimit(responderURL.Host) {
+		return nil, time.Time{}, errOCSPRateLimited
+	}
+	httpRequest, err := http.NewRequest("POST", responder, bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/ocsp-request")
+	httpRequest.Header.Set("Accept", "application/ocsp-response")
+	httpRequest.Header.Set("User-Agent", "certspotter")
+	httpResponse, err := checker.Client.Do(httpRequest)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer httpResponse.Body.Close()
+	responseBytes, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if httpResponse.StatusCode != 200 {
+		return nil, time.Time{}, fmt.Errorf("%s: %s", responder, httpResponse.Status)
+	}
+
+	single, err := parseOCSPResponse(responseBytes, certID, issuer)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %s", responder, err)
+	}
+	if err := checkOCSPFreshness(single, time.Now()); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %s", responder, err)
+	}
+	thisUpdate := single.ThisUpdate
+	status := &RevocationStatus{Responder: responder, ThisUpdate: &thisUpdate}
+	if !single.Revoked.RevocationTime.IsZero() {
+		revokedAt := single.Revoked.RevocationTime
+		status.Status = OCSPRevoked
+		status.RevokedAt = &revokedAt
+		if status.Reason, err = single.Revoked.reason(); err != nil {
+			return nil, time.Time{}, fmt.Errorf("%s: %s", responder, err)
+		}
+	} else if single.Good {
+		status.Status = OCSPGood
+	} else {
+		status.Status = OCSPUnknown
+	}
+	return status, single.NextUpdate, nil
+}
+
+// checkOCSPFreshness returns an error if |single| isn't current at |now|
+func checkOCSPFreshness(single *ocspSingleResponse, now time.Time) error {
+	if single.ThisUpdate.After(now.Add(ocspClockSkew)) {
+		return fmt.Errorf("OCSP response's thisUpdate (%s) is in the future", single.ThisUpdate)
+	}
+	if !single.NextUpdate.IsZero() {
+		if single.NextUpdate.Before(now.Add(-ocspClockSkew)) {
+			return fmt.Errorf("OCSP response is stale: its nextUpdate (%s) has passed", single.NextUpdate)
+		}
+	} else if single.ThisUpdate.Before(now.Add(-ocspMaxAge)) {
+		return fmt.Errorf("OCSP response is stale: its thisUpdate (%s) is more than %s ago and it has no nextUpdate", single.ThisUpdate, ocspMaxAge)
+	}
+	return nil
+}
+
+// parseOCSPResponse verifies the OCSP response in |der| and returns its
+// status for |certID|
+func parseOCSPResponse(der []byte, certID *ocspCertID, issuer *x509.Certificate) (*ocspSingleResponse, error) {
+	var response ocspResponse
+	if _, err := asn1.Unmarshal(der, &response); err != nil {
+		return nil, fmt.Errorf("Malformed OCSP response: %s", err)
+	}
+	if response.Status != 0 {
+		return nil, fmt.Errorf("OCSP responder returned status %d", response.Status)
+	}
+	if !response.Response.ResponseType.Equal(oidOCSPBasicResponse) {
+		return nil, fmt.Errorf("OCSP response has unsupported type %s", response.Response.ResponseType)
+	}
+	var basic basicOCSPResponse
+	if _, err := asn1.Unmarshal(response.Response.Response, &basic); err != nil {
+		return nil, fmt.Errorf("Malformed OCSP response: %s", err)
+	}
+	var data ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, fmt.Errorf("Malformed OCSP response data: %s", err)
+	}
+	if err := verifyOCSPSignature(&basic, issuer); err != nil {
+		return nil, err
+	}
+	for i := range data.Responses {
+		single := &data.Responses[i]
+		if single.CertID.SerialNumber.Cmp(certID.SerialNumber) == 0 && bytes.Equal(single.CertID.IssuerKeyHash, certID.IssuerKeyHash) && bytes.Equal(single.CertID.IssuerNameHash, certID.IssuerNameHash) {
+			return single, nil
+		}
+	}
+	return nil, errors.New("OCSP response doesn't include the certificate")
+}
+
+// verifyOCSPSignature checks that |response| is signed by |issuer| or by a
+// responder certificate which |issuer| has delegated to
+func verifyOCSPSignature(response *basicOCSPResponse, issuer *x509.Certificate) error {
+	var algorithm x509.SignatureAlgorithm
+	for _, candidate := range ocspSignatureAlgorithms {
+		if candidate.oid.Equal(response.SignatureAlgorithm.Algorithm) {
+			algorithm = candidate.algorithm
+		}
+	}
+	if algorithm == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("OCSP response is signed with unsupported algorithm %s", response.SignatureAlgorithm.Algorithm)
+	}
+	signer := issuer
+	if len(response.Certificates) != 0 {
+		responderCert, err := x509.ParseCertificate(response.Certificates[0].FullBytes)
+		if err != nil {
+			return fmt.Errorf("Error parsing OCSP responder certificate: %s", err)
+		}
+		if !bytes.Equal(responderCert.Raw, issuer.Raw) {
+			if err := responderCert.CheckSignatureFrom(issuer); err != nil {
+				return fmt.Errorf("OCSP responder certificate isn't issued by the certificate's issuer: %s", err)
+			}
+			delegated := false
+			for _, usage := range responderCert.ExtKeyUsage {
+				if usage == x509.ExtKeyUsageOCSPSigning {
+					delegated = true
+				}
+			}
+			if !delegated {
+				return errors.New("OCSP responder certificate isn't authorized to sign OCSP responses")
+			}
+			signer = responderCert
+		}
+	}
+	if err := signer.CheckSignature(algorithm, response.TBSResponseData.FullBytes, response.Signature.RightAlign()); err != nil {
+		return fmt.Errorf("OCSP response has an invalid signature: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testSingleResponse struct {
+	CertID     ocspCertID
+	Status     asn1.RawValue
+	ThisUpdate time.Time `asn1:"generalized"`
+	NextUpdate time.Time `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type testResponseData struct {
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []testSingleResponse
+}
+
+// fakeOCSPResponder answers every request with a response signed by
+// |signer|, saying the certificate is revoked if its serial is in |revoked|,
+// which maps it to the reason code, or -1 for no reason.  Responses have the
+// given nextUpdate, if set.
+type fakeOCSPResponder struct {
+	signer     *ecdsa.PrivateKey
+	revoked    map[int64]int
+	nextUpdate time.Time
+	requests   int32
+}
+
+func (responder *fakeOCSPResponder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&responder.requests, 1)
+	body, _ := ioutil.ReadAll(r.Body)
+	var request ocspRequest
+	if _, err := asn1.Unmarshal(body, &request); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	certID := request.TBSRequest.RequestList[0].ReqCert
+	status := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0}
+	if reason, revoked := responder.revoked[certID.SerialNumber.Int64()]; revoked {
+		info := ocspRevokedInfo{RevocationTime: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}
+		if reason != -1 {
+			enumerated, _ := asn1.Marshal(asn1.Enumerated(reason))
+			info.Reason = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: enumerated}
+		}
+		revokedInfo, _ := asn1.Marshal(info)
+		var sequence asn1.RawValue
+		asn1.Unmarshal(revokedInfo, &sequence)
+		status = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: sequence.Bytes}
+	}
+	keyHash, _ := asn1.Marshal(certID.IssuerKeyHash)
+	tbs, _ := asn1.Marshal(testResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+		ProducedAt:  time.Now().UTC().Truncate(time.Second),
+		Responses:   []testSingleResponse{{CertID: certID, Status: status, ThisUpdate: time.Now().UTC().Truncate(time.Second), NextUpdate: responder.nextUpdate}},
+	})
+	digest := sha256.Sum256(tbs)
+	signature, _ := ecdsa.SignASN1(rand.Reader, responder.signer, digest[:])
+	basic, _ := asn1.Marshal(basicOCSPResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: algorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	})
+	var response ocspResponse
+	response.Response.ResponseType = oidOCSPBasicResponse
+	response.Response.Response = basic
+	der, _ := asn1.Marshal(response)
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(der)
+}
+
+// makeOCSPTestEntry returns an entry for a certificate with serial number
+// |serial| issued by a new CA, with |responderURL| as its OCSP responder,
+// and the CA's key
+func makeOCSPTestEntry(t *testing.T, responderURL string, serial int64) (*EntryInfo, *ecdsa.PrivateKey) {
+	ca, caKey := makeTestCA(t, nil, nil, nil)
+	return makeTestChainEntry(t, ca, caKey, func(template *x509.Certificate) {
+		template.SerialNumber = big.NewInt(serial)
+		template.OCSPServer = []string{responderURL}
+	}), caKey
+}
+
+func TestOCSPChecker(t *testing.T) {
+	responder := &fakeOCSPResponder{revoked: map[int64]int{666: 1, 667: 0, 668: -1}}
+	server := httptest.NewServer(responder)
+	defer server.Close()
+
+	info, caKey := makeOCSPTestEntry(t, server.URL, 42)
+	responder.signer = caKey
+	checker := NewOCSPChecker()
+	checker.RateLimit = 0
+
+	status := checker.Check(info)
+	if status == nil || status.Status != OCSPGood || status.Responder != server.URL {
+		t.Fatalf("Wrong status: %+v", status)
+	}
+	if status := checker.Check(info); status.Status != OCSPGood || atomic.LoadInt32(&responder.requests) != 1 {
+		t.Errorf("Status wasn't cached: %+v after %d requests", status, atomic.LoadInt32(&responder.requests))
+	}
+
+	info, caKey = makeOCSPTestEntry(t, server.URL, 666)
+	responder.signer = caKey
+	status = checker.Check(info)
+	if status.Status != OCSPRevoked || status.Reason != "keyCompromise" || !status.RevokedAt.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Wrong status: %+v", status)
+	}
+
+	for serial, reason := range map[int64]string{667: "unspecified", 668: ""} {
+		info, caKey = makeOCSPTestEntry(t, server.URL, serial)
+		responder.signer = caKey
+		status = checker.Check(info)
+		if status.Status != OCSPRevoked || status.Reason != reason {
+			t.Errorf("Wrong status for serial %d: %+v", serial, status)
+		}
+	}
+
+	// Signed by the wrong key
+	info, _ = makeOCSPTestEntry(t, server.URL, 43)
+	status = checker.Check(info)
+	if status.Status != OCSPError || !strings.Contains(status.Error, "invalid signature") {
+		t.Errorf("Wrong status: %+v", status)
+	}
+
+	// Stale
+	info, caKey = makeOCSPTestEntry(t, server.URL, 44)
+	responder.signer = caKey
+	responder.nextUpdate = time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	status = checker.Check(info)
+	if status.Status != OCSPError || !strings.Contains(status.Error, "stale") {
+		t.Errorf("Wrong status: %+v", status)
+	}
+
+	info.CertInfo = makeTestCertInfo(t, nil, nil, nil)
+	if status := checker.Check(info); status != nil {
+		t.Errorf("Certificate without a responder has status %+v", status)
+	}
+}
+
+func TestOCSPRateLimit(t *testing.T) {
+	checker := &OCSPChecker{RateLimit: 20}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		checker.waitForRateLimit("ocsp.example.com")
+	}
+	checker.waitForRateLimit("ocsp.example.net")
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("3 requests at 20 per second took %s", elapsed)
+	}
+
+	// Requests which would have to wait longer than MaxWait, because
+	// other goroutines are already waiting, aren't made
+	checker = &OCSPChecker{RateLimit: 1, MaxWait: time.Second}
+	checker.nextRequest = map[string]time.Time{"ocsp.example.com": time.Now().Add(5 * time.Second)}
+	start = time.Now()
+	if checker.waitForRateLimit("ocsp.example.com") {
+		t.Errorf("waitForRateLimit returned true even though it would have to wait 5 seconds")
+	}
+	if !checker.waitForRateLimit("ocsp.example.net") {
+		t.Errorf("waitForRateLimit returned false for another responder")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("waitForRateLimit waited %s", elapsed)
+	}
+	if next := checker.nextRequest["ocsp.example.com"]; next.Sub(start) > 5*time.Second {
+		t.Errorf("A request which wasn't made used up the rate limit")
+	}
+}
+
+func TestOCSPFreshness(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		thisUpdate time.Time
+		nextUpdate time.Time
+		ok         bool
+	}{
+		{now.Add(-time.Hour), now.Add(7 * 24 * time.Hour), true},
+		{now.Add(-time.Hour), time.Time{}, true},
+		{now.Add(time.Minute), now.Add(7 * 24 * time.Hour), true}, // within clock skew
+		{now.Add(time.Hour), now.Add(7 * 24 * time.Hour), false},
+		{now.Add(-8 * 24 * time.Hour), now.Add(-time.Minute), true}, // within clock skew
+		{now.Add(-8 * 24 * time.Hour), now.Add(-time.Hour), false},
+		{now.Add(-11 * 24 * time.Hour), time.Time{}, false},
+		{now.Add(-11 * 24 * time.Hour), now.Add(time.Hour), true},
+	}
+	for _, test := range tests {
+		single := &ocspSingleResponse{ThisUpdate: test.thisUpdate, NextUpdate: test.nextUpdate}
+		if err := checkOCSPFreshness(single, now); (err == nil) != test.ok {
+			t.Errorf("thisUpdate %s, nextUpdate %s: got %v", test.thisUpdate, test.nextUpdate, err)
+		}
+	}
+}
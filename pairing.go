@@ -0,0 +1,47 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+// HasPoison returns true if the certificate contains the CT poison
+// extension, meaning it's a precertificate.  Precertificates are sometimes
+// (incorrectly) logged as regular X.509 entries.
+func (info *CertInfo) HasPoison() bool {
+	return len(info.TBS.GetExtension(oidExtensionCTPoison)) != 0
+}
+
+// IssuanceKey returns the SHA-256 hash of the TBSCertificate, as it appears
+// in precertificate log entries: without the poison or SCT extensions.  A
+// precertificate and its corresponding certificate have the same
+// IssuanceKey, so it identifies a single issuance.  Returns nil if the
+// entry can't be parsed.
+func (info *EntryInfo) IssuanceKey() []byte {
+	if info.IsPrecert {
+		return sha256sum(info.Entry.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate)
+	}
+	if info.CertInfo == nil {
+		return nil
+	}
+	tbs, err := ReconstructPrecertTBS(info.CertInfo.TBS)
+	if err != nil {
+		return nil
+	}
+	return sha256sum(tbs.Raw)
+}
+
+// Stores which can pair precertificates with certificates implement IssuanceStore
+type IssuanceStore interface {
+	Store
+
+	// Record that the certificate with the given fingerprint belongs to
+	// the issuance identified by |key| (see EntryInfo.IssuanceKey).  If
+	// another certificate of the same issuance was recorded before, its
+	// fingerprint is returned; otherwise the empty string is returned.
+	SaveIssuance(key []byte, fingerprint string) (string, error)
+}
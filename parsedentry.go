@@ -0,0 +1,174 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"net"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// ParsedExtension is a certificate extension, with its OID in dotted form
+type ParsedExtension struct {
+	OID      string `json:"oid"`
+	Critical bool   `json:"critical"`
+	Value    []byte `json:"value"`
+}
+
+// A ParseError records that a part of the certificate couldn't be parsed
+type ParseError struct {
+	Field string `json:"field"` // e.g. "subject", "validity"; "certificate" if nothing could be parsed
+	Error string `json:"error"`
+}
+
+// ParsedEntry is a fully decoded log entry, for consumers which want the
+// contents of the certificate without parsing it themselves.  Parts of the
+// certificate which couldn't be parsed are left empty and listed in
+// ParseErrors.
+type ParsedEntry struct {
+	LogURI      string    `json:"log_uri"`
+	Index       int64     `json:"index"`
+	Timestamp   time.Time `json:"timestamp"` // leaf timestamp
+	IsPrecert   bool      `json:"is_precert"`
+	Fingerprint string    `json:"fingerprint"` // SHA-256, hex
+	LeafHash    []byte    `json:"leaf_hash"`
+
+	// DER-encoded certificate (or precertificate) and issuer chain
+	Raw   []byte   `json:"raw"`
+	Chain [][]byte `json:"chain,omitempty"`
+	// DER-encoded TBSCertificate, as it appears in precertificate log entries
+	RawTBS []byte `json:"raw_tbs,omitempty"`
+
+	Subject          string              `json:"subject,omitempty"`
+	SubjectCNs       []string            `json:"subject_cns,omitempty"`
+	Issuer           string              `json:"issuer,omitempty"`
+	Serial           string              `json:"serial,omitempty"` // hex
+	NotBefore        *time.Time          `json:"not_before,omitempty"`
+	NotAfter         *time.Time          `json:"not_after,omitempty"`
+	IsCA             *bool               `json:"is_ca,omitempty"`
+	DNSNames         []string            `json:"dns_names"`
+	DNSNameAnomalies map[string][]string `json:"dns_name_anomalies,omitempty"` // see DNSNameRedacted etc.
+	IPAddresses      []net.IP            `json:"ip_addresses"`
+	EmailAddresses   []string            `json:"email_addresses"`
+	URIs             []string            `json:"uris"`
+	PublicKey        *PublicKeyInfo      `json:"public_key,omitempty"`
+	PubkeyHash       string              `json:"pubkey_hash,omitempty"` // SHA-256 of the SPKI, hex
+
+	Extensions []ParsedExtension               `json:"extensions,omitempty"`
+	SCTs       []ct.SignedCertificateTimestamp `json:"scts,omitempty"` // embedded in the certificate
+
+	ParseErrors []ParseError `json:"parse_errors,omitempty"`
+}
+
+func (entry *ParsedEntry) addError(field string, err error) {
+	entry.ParseErrors = append(entry.ParseErrors, ParseError{Field: field, Error: err.Error()})
+}
+
+// Parse decodes |info| into a ParsedEntry
+func (info *EntryInfo) Parse() *ParsedEntry {
+	entry := &ParsedEntry{
+		LogURI:         info.LogUri,
+		Index:          info.Entry.Index,
+		Timestamp:      entryTimestamp(info.Entry),
+		IsPrecert:      info.IsPrecert,
+		Fingerprint:    info.Fingerprint(),
+		LeafHash:       info.LeafHash(),
+		DNSNames:       []string{},
+		IPAddresses:    []net.IP{},
+		EmailAddresses: []string{},
+		URIs:           []string{},
+	}
+	if len(info.FullChain) != 0 {
+		entry.Raw = info.FullChain[0]
+		entry.Chain = info.FullChain[1:]
+	}
+	if info.IsPrecert {
+		entry.RawTBS = info.Entry.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate
+	}
+
+	if info.IdentifiersParseError != nil {
+		entry.addError("identifiers", info.IdentifiersParseError)
+	} else if info.Identifiers != nil {
+		entry.DNSNames = info.Identifiers.DNSNames
+		entry.DNSNameAnomalies = info.Identifiers.DNSNameAnomalies
+		entry.IPAddresses = info.Identifiers.IPAddrs
+		entry.EmailAddresses = info.Identifiers.EmailAddrs
+		entry.URIs = info.Identifiers.URIs
+	}
+
+	cert := info.CertInfo
+	if cert == nil {
+		entry.addError("certificate", info.ParseError)
+		return entry
+	}
+	if !info.IsPrecert {
+		entry.RawTBS = cert.TBS.Raw
+	}
+	for _, err := range cert.StructureErrors {
+		entry.addError("structure", err)
+	}
+	if cert.SubjectParseError != nil {
+		entry.addError("subject", cert.SubjectParseError)
+	} else {
+		entry.Subject = cert.Subject.String()
+		var err error
+		if entry.SubjectCNs, err = cert.Subject.ParseCNs(); err != nil {
+			entry.addError("subject_cns", err)
+		}
+	}
+	if cert.IssuerParseError != nil {
+		entry.addError("issuer", cert.IssuerParseError)
+	} else {
+		entry.Issuer = cert.Issuer.String()
+	}
+	if cert.SerialNumberParseError != nil {
+		entry.addError("serial", cert.SerialNumberParseError)
+	} else {
+		entry.Serial = formatSerialNumber(cert.SerialNumber)
+	}
+	if cert.ValidityParseError != nil {
+		entry.addError("validity", cert.ValidityParseError)
+	} else {
+		entry.NotBefore = cert.NotBefore()
+		entry.NotAfter = cert.NotAfter()
+	}
+	if cert.IsCAParseError != nil {
+		entry.addError("basic_constraints", cert.IsCAParseError)
+	} else {
+		entry.IsCA = cert.IsCA
+	}
+	if cert.SANsParseError != nil {
+		entry.addError("sans", cert.SANsParseError)
+	}
+	if cert.PublicKeyParseError != nil {
+		entry.addError("public_key", cert.PublicKeyParseError)
+	} else {
+		entry.PublicKey = cert.PublicKey
+	}
+	entry.PubkeyHash = cert.PubkeyHash()
+
+	for _, ext := range cert.TBS.Extensions {
+		entry.Extensions = append(entry.Extensions, ParsedExtension{OID: ext.Id.String(), Critical: ext.Critical, Value: ext.Value})
+	}
+	var err error
+	if entry.SCTs, err = cert.TBS.ParseSCTList(); err != nil {
+		entry.addError("scts", err)
+	}
+	return entry
+}
+
+// ParsedCallback returns a ProcessCallback which passes every entry matched
+// by |matcher|, parsed, to |callback|
+func ParsedCallback(matcher Matcher, callback func(*ParsedEntry, []Match)) ProcessCallback {
+	return MatchingCallback(matcher, func(info *EntryInfo) {
+		callback(info.Parse(), info.Matches)
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"log/slog"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/tracing"
+)
+
+// A Stage is one step of a pipeline which processes entries.  It passes the
+// entry on to the rest of the pipeline by calling |next|, possibly after
+// modifying it; not calling |next| drops the entry.  A stage may also do
+// something after |next| returns.
+type Stage func(info *EntryInfo, next func(*EntryInfo))
+
+// Pipeline composes |stages| into a single function, which passes each entry
+// through the stages in order
+func Pipeline(stages ...Stage) func(*EntryInfo) {
+	next := func(*EntryInfo) {}
+	for i := len(stages) - 1; i >= 0; i-- {
+		stage, rest := stages[i], next
+		next = func(info *EntryInfo) { stage(info, rest) }
+	}
+	return next
+}
+
+// PipelineCallback returns a ProcessCallback which parses each entry and
+// passes it through |stages|.  Entries given Matches by a MatchStage, and
+// entries which can't be parsed, are counted in the Scanner's Stats.
+func PipelineCallback(stages ...Stage) ProcessCallback {
+	pipeline := Pipeline(stages...)
+	return func(scanner *Scanner, entry *ct.LogEntry) {
+		var span *tracing.Span
+		if tracing.Sample(scanner.opts.TraceSampleRate) {
+			span = scanner.TraceSpan().Child("ProcessEntry", "index", entry.Index)
+		}
+		parseSpan := span.Child("ParseEntry")
+		info := NewEntryInfo(scanner.LogUri, entry)
+		parseSpan.SetError(info.ParseError)
+		if info.ParseError != nil {
+			scanner.countParseError()
+		}
+		parseSpan.End()
+		info.Span = span
+		pipeline(info)
+		if len(info.Matches) != 0 {
+			scanner.CountMatch()
+		}
+		span.SetFields("matched", len(info.Matches) != 0)
+		span.End()
+	}
+}
+
+// MatchStage passes on entries matched by |matcher|, with their Matches set
+func MatchStage(matcher Matcher) Stage {
+	return func(info *EntryInfo, next func(*EntryInfo)) {
+		span := info.Span.Child("Match")
+		info.Matches = matcher.Match(info)
+		span.SetFields("matches", len(info.Matches))
+		span.End()
+		if len(info.Matches) != 0 {
+			next(info)
+		}
+	}
+}
+
+// FilterStage passes on entries for which |filter| returns true
+func FilterStage(filter func(*EntryInfo) bool) Stage {
+	return func(info *EntryInfo, next func(*EntryInfo)) {
+		if filter(info) {
+			next(info)
+		}
+	}
+}
+
+// ProcessStage calls |process| on each entry, e.g. to enrich it, and then
+// passes it on
+func ProcessStage(process func(*EntryInfo)) Stage {
+	return func(info *EntryInfo, next func(*EntryInfo)) {
+		process(info)
+		next(info)
+	}
+}
+
+// DedupStage passes on only the first entry for each certificate, adding
+// the logs of later entries to its SeenInLogs (see Deduplicator.Add)
+func DedupStage(d *Deduplicator) Stage {
+	return func(info *EntryInfo, next func(*EntryInfo)) {
+		if d.Add(info) {
+			next(info)
+		}
+	}
+}
+
+// SinkStage writes each entry to |sink| and then passes it on.  Errors are
+// logged and don't stop the entry.
+func SinkStage(sink Sink) Stage {
+	return func(info *EntryInfo, next func(*EntryInfo)) {
+		if err := sink.Write(info); err != nil {
+			slog.Error(err.Error())
+		}
+		next(info)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	var trace []string
+	tracer := func(name string) Stage {
+		return func(info *EntryInfo, next func(*EntryInfo)) {
+			trace = append(trace, name)
+			next(info)
+			trace = append(trace, "/"+name)
+		}
+	}
+	pipeline := Pipeline(
+		tracer("a"),
+		FilterStage(func(info *EntryInfo) bool { return info.LogUri != "drop" }),
+		tracer("b"),
+		ProcessStage(func(info *EntryInfo) { trace = append(trace, "process "+info.LogUri) }),
+	)
+
+	pipeline(&EntryInfo{LogUri: "keep"})
+	if got := strings.Join(trace, " "); got != "a b process keep /b /a" {
+		t.Errorf("Wrong trace for kept entry: %s", got)
+	}
+	trace = nil
+	pipeline(&EntryInfo{LogUri: "drop"})
+	if got := strings.Join(trace, " "); got != "a /a" {
+		t.Errorf("Wrong trace for dropped entry: %s", got)
+	}
+
+	// An empty pipeline does nothing
+	Pipeline()(&EntryInfo{})
+}
@@ -134,6 +134,7 @@ func ReconstructPrecertTBS(tbs *TBSCertificate) (*TBSCertificate, error) {
 	for _, ext := range tbs.Extensions {
 		switch {
 		case ext.Id.Equal(oidExtensionSCT):
+		case ext.Id.Equal(oidExtensionCTPoison):
 		default:
 			precertTBS.Extensions = append(precertTBS.Extensions, ext)
 		}
@@ -0,0 +1,167 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	oidPublicKeyRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidPublicKeyRSAPSS  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidPublicKeyDSA     = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 1}
+	oidPublicKeyDH      = asn1.ObjectIdentifier{1, 2, 840, 10046, 2, 1}
+	oidPublicKeyPKCS3DH = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 3, 1}
+	oidPublicKeyECDSA   = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidPublicKeyEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+	oidPublicKeyEd448   = asn1.ObjectIdentifier{1, 3, 101, 113}
+)
+
+// Public key algorithms, as in PublicKeyInfo.Algorithm
+const (
+	KeyAlgorithmRSA     = "RSA"
+	KeyAlgorithmDSA     = "DSA"
+	KeyAlgorithmDH      = "DH"
+	KeyAlgorithmECDSA   = "ECDSA"
+	KeyAlgorithmEd25519 = "Ed25519"
+	KeyAlgorithmEd448   = "Ed448"
+	KeyAlgorithmUnknown = "unknown"
+)
+
+// Curve, when the curve parameters are given explicitly rather than by name
+const CurveExplicit = "explicit"
+
+type namedCurve struct {
+	oid  asn1.ObjectIdentifier
+	name string
+	bits int
+}
+
+var namedCurves = []namedCurve{
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 1}, "P-192", 192},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 33}, "P-224", 224},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}, "P-256", 256},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 34}, "P-384", 384},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 35}, "P-521", 521},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 10}, "secp256k1", 256},
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm struct {
+		Algorithm  asn1.ObjectIdentifier
+		Parameters asn1.RawValue `asn1:"optional"`
+	}
+	PublicKey asn1.BitString
+}
+
+type rsaPublicKey struct {
+	N *big.Int
+	E *big.Int
+}
+
+// PublicKeyInfo describes a certificate's Subject Public Key Info
+type PublicKeyInfo struct {
+	Algorithm string `json:"algorithm"`
+	Bits      int    `json:"bits"` // modulus size for RSA, prime size for DSA and DH, curve size for ECDSA
+
+	RSAModulus  *big.Int `json:"rsa_modulus,omitempty"`
+	RSAExponent *big.Int `json:"rsa_exponent,omitempty"`
+	Prime       *big.Int `json:"prime,omitempty"`    // DSA and DH
+	Curve       string   `json:"curve,omitempty"`    // ECDSA: name of the curve, CurveExplicit, or the curve's OID if unrecognized
+	ECPoint     []byte   `json:"ec_point,omitempty"` // ECDSA
+}
+
+// Parse the first INTEGER in the SEQUENCE |params|, which is the prime in
+// both DSA and DH parameters
+func parsePrime(params asn1.RawValue) (*big.Int, error) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(params.FullBytes, &seq); err != nil {
+		return nil, err
+	}
+	if seq.Tag != asn1.TagSequence {
+		return nil, errors.New("parameters are not a SEQUENCE")
+	}
+	prime := new(big.Int)
+	if _, err := asn1.Unmarshal(seq.Bytes, &prime); err != nil {
+		return nil, err
+	}
+	return prime, nil
+}
+
+func ParsePublicKeyInfo(spkiBytes []byte) (*PublicKeyInfo, error) {
+	var spki subjectPublicKeyInfo
+	if rest, err := asn1.Unmarshal(spkiBytes, &spki); err != nil {
+		return nil, errors.New("failed to parse public key: " + err.Error())
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after public key")
+	}
+	algorithm := spki.Algorithm.Algorithm
+	params := spki.Algorithm.Parameters
+	key := spki.PublicKey.RightAlign()
+
+	info := new(PublicKeyInfo)
+	switch {
+	case algorithm.Equal(oidPublicKeyRSA) || algorithm.Equal(oidPublicKeyRSAPSS):
+		info.Algorithm = KeyAlgorithmRSA
+		var rsaKey rsaPublicKey
+		if _, err := asn1.Unmarshal(key, &rsaKey); err != nil {
+			return nil, errors.New("failed to parse RSA public key: " + err.Error())
+		}
+		info.RSAModulus = rsaKey.N
+		info.RSAExponent = rsaKey.E
+		info.Bits = rsaKey.N.BitLen()
+	case algorithm.Equal(oidPublicKeyDSA) || algorithm.Equal(oidPublicKeyDH) || algorithm.Equal(oidPublicKeyPKCS3DH):
+		if algorithm.Equal(oidPublicKeyDSA) {
+			info.Algorithm = KeyAlgorithmDSA
+		} else {
+			info.Algorithm = KeyAlgorithmDH
+		}
+		prime, err := parsePrime(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s parameters: %s", info.Algorithm, err)
+		}
+		info.Prime = prime
+		info.Bits = prime.BitLen()
+	case algorithm.Equal(oidPublicKeyECDSA):
+		info.Algorithm = KeyAlgorithmECDSA
+		info.ECPoint = key
+		if params.Tag != asn1.TagOID {
+			info.Curve = CurveExplicit
+			break
+		}
+		var curveOID asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(params.FullBytes, &curveOID); err != nil {
+			return nil, errors.New("failed to parse ECDSA curve: " + err.Error())
+		}
+		info.Curve = curveOID.String()
+		for _, curve := range namedCurves {
+			if curve.oid.Equal(curveOID) {
+				info.Curve = curve.name
+				info.Bits = curve.bits
+			}
+		}
+	case algorithm.Equal(oidPublicKeyEd25519):
+		info.Algorithm = KeyAlgorithmEd25519
+		info.Bits = 256
+	case algorithm.Equal(oidPublicKeyEd448):
+		info.Algorithm = KeyAlgorithmEd448
+		info.Bits = 448
+	default:
+		info.Algorithm = KeyAlgorithmUnknown
+	}
+	return info, nil
+}
+
+func (tbs *TBSCertificate) ParsePublicKey() (*PublicKeyInfo, error) {
+	return ParsePublicKeyInfo(tbs.GetRawPublicKey())
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package s3archive uploads certificates to an S3-compatible bucket for
+// long-term retention.
+//
+// Objects are content-addressed by the certificate's SHA-256 fingerprint:
+//
+//	PREFIXcerts/ab/abcdef....der	the certificate, DER-encoded
+//	PREFIXcerts/ab/abcdef....json	metadata (see Metadata)
+//
+// where "ab" is the first byte of the fingerprint, to spread keys out.
+// If Config.Compression is set, the keys have an additional .gz or .zst suffix.
+// Uploading the same certificate twice is harmless.
+package s3archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/compression"
+)
+
+type Config struct {
+	// If Endpoint is empty, Amazon S3 in Region is used.  Otherwise,
+	// Endpoint is the base URL of an S3-compatible service
+	// (e.g. https://minio.example.com), and path-style requests are made.
+	Endpoint string
+	Region   string
+	Bucket   string
+	Prefix   string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// If set, objects are compressed with this algorithm (see the
+	// compression package), and their keys end in .gz or .zst
+	Compression string
+}
+
+// ParseURL parses an archive location of the form s3://BUCKET/PREFIX into
+// |config|.  The prefix, if non-empty, is made to end with '/'.
+func (config *Config) ParseURL(archiveURL string) error {
+	u, err := url.Parse(archiveURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return fmt.Errorf("%s: not of the form s3://BUCKET/PREFIX", archiveURL)
+	}
+	config.Bucket = u.Host
+	config.Prefix = strings.TrimPrefix(u.Path, "/")
+	if config.Prefix != "" && !strings.HasSuffix(config.Prefix, "/") {
+		config.Prefix += "/"
+	}
+	return nil
+}
+
+// ConfigFromEnvironment fills in the region and credentials from the
+// standard AWS environment variables
+func (config *Config) ConfigFromEnvironment() {
+	config.Region = os.Getenv("AWS_REGION")
+	if config.Region == "" {
+		config.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+}
+
+// Metadata is the JSON document stored alongside each certificate
+type Metadata struct {
+	*certspotter.CertRecord
+	LogURI     string `json:"log_uri"`
+	EntryIndex int64  `json:"entry_index"`
+}
+
+type Archive struct {
+	config     Config
+	httpClient *http.Client
+}
+
+func New(config Config) (*Archive, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("No bucket specified")
+	}
+	if err := compression.Check(config.Compression); err != nil {
+		return nil, err
+	}
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("No credentials specified (set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	}
+	return &Archive{
+		config:     config,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+func (archive *Archive) objectURL(key string) *url.URL {
+	var u url.URL
+	if archive.config.Endpoint != "" {
+		endpoint, _ := url.Parse(archive.config.Endpoint)
+		u.Scheme = endpoint.Scheme
+		u.Host = endpoint.Host
+		u.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + archive.config.Bucket + "/" + key
+	} else {
+		u.Scheme = "https"
+		u.Host = archive.config.Bucket + ".s3." + archive.config.Region + ".amazonaws.com"
+		u.Path = "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, true)
+	return &u
+}
+
+func (archive *Archive) putObject(key string, contentType string, body []byte) error {
+	body, err := compression.Compress(archive.config.Compression, body)
+	if err != nil {
+		return err
+	}
+	key += compression.Extension(archive.config.Compression)
+	contentType = compression.ContentType(archive.config.Compression, contentType)
+
+	req, err := http.NewRequest("PUT", "", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.URL = archive.objectURL(key)
+	req.Host = req.URL.Host
+	req.Header.Set("Content-Type", contentType)
+	archive.sign(req, sha256Hex(body), time.Now())
+
+	resp, err := archive.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("PUT %s: %s: %s", req.URL, resp.Status, bytes.TrimSpace(respBody))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// Upload archives the certificate in |info|
+func (archive *Archive) Upload(info *certspotter.EntryInfo) error {
+	record := certspotter.MakeCertRecord(info.IsPrecert, info.FullChain)
+	metadata := Metadata{
+		CertRecord: record,
+		LogURI:     info.LogUri,
+		EntryIndex: info.Entry.Index,
+	}
+	metadataJSON, err := json.MarshalIndent(metadata, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	key := archive.config.Prefix + "certs/" + record.Fingerprint[0:2] + "/" + record.Fingerprint
+	if err := archive.putObject(key+".der", "application/pkix-cert", info.FullChain[0]); err != nil {
+		return fmt.Errorf("Error archiving certificate %s: %s", record.Fingerprint, err)
+	}
+	// Upload the metadata last, so its presence means the archive is complete
+	if err := archive.putObject(key+".json", "application/json", metadataJSON); err != nil {
+		return fmt.Errorf("Error archiving metadata for certificate %s: %s", record.Fingerprint, err)
+	}
+	return nil
+}
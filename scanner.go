@@ -13,18 +13,18 @@
 package certspotter
 
 import (
-	//	"container/list"
 	"bytes"
+	//	"container/list"
 	"crypto"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"software.sslmate.com/src/certspotter/ct"
 	"software.sslmate.com/src/certspotter/ct/client"
+	"software.sslmate.com/src/certspotter/tracing"
 )
 
 type ProcessCallback func(*Scanner, *ct.LogEntry)
@@ -34,6 +34,15 @@ const (
 	FETCH_RETRY_WAIT = 1
 )
 
+// How long to wait before the first retry of a failed fetch; it doubles
+// with each retry (a variable so that tests can shorten it)
+var fetchRetryWait = FETCH_RETRY_WAIT * time.Second
+
+// ErrScanStopped is returned by Scan if it stopped early because
+// ScannerOptions.Stop was closed.  Every entry added to the tree by then has
+// been processed, so the tree can be saved and the scan resumed from it.
+var ErrScanStopped = errors.New("Scan stopped")
+
 // ScannerOptions holds configuration options for the Scanner
 type ScannerOptions struct {
 	// Number of entries to request in one batch from the Log
@@ -44,6 +53,38 @@ type ScannerOptions struct {
 
 	// Don't print any status messages to stdout
 	Quiet bool
+
+	// Don't pass precertificates or final certificates to the
+	// ProcessCallback.  Entries are still added to the Merkle tree.
+	// Entries of unknown types are never passed.
+	SkipPrecerts bool
+	SkipCerts    bool
+
+	// If closed, scans stop early and return ErrScanStopped
+	Stop <-chan struct{}
+
+	// If not nil, the Scanner's metrics are collected here
+	Collector *Collector
+
+	// Maximum number of get-entries requests per second, or 0 for no limit
+	RateLimit float64
+
+	// Maximum time a request to the Log may take, or 0 for the default
+	Timeout time.Duration
+
+	// Fraction of entries whose processing is traced, if tracing is
+	// enabled.  Scans and get-entries requests are always traced.
+	TraceSampleRate float64
+
+	// Hooks which, if not nil, are called as a scan progresses, e.g. to
+	// show progress or keep an audit trail.  They're called by the
+	// goroutine which called Scan (or GetSTH), so they needn't be
+	// synchronized with each other, but mustn't take long.
+	OnScanStart     func(s *Scanner, start, end int64)          // before scanning entries [start, end)
+	OnBatchFetched  func(s *Scanner, start int64, entries int)  // after a get-entries request returns entries from start
+	OnRangeComplete func(s *Scanner, start, end int64)          // once entries [start, end] have been fetched and added to the tree; they may still be being processed
+	OnScanComplete  func(s *Scanner, err error)                 // once the scan has finished, with the error Scan returns; s.Stats() has its final statistics
+	OnLogError      func(s *Scanner, err error, willRetry bool) // when a request to the log fails (see client.Classify)
 }
 
 // Creates a new ScannerOptions struct with sensible defaults
@@ -55,6 +96,77 @@ func DefaultScannerOptions() *ScannerOptions {
 	}
 }
 
+// LogClient talks to a CT log.  *client.LogClient implements it for RFC
+// 6962 logs, and *tiled.Client for static-ct-api logs.
+type LogClient interface {
+	GetSTH() (*ct.SignedTreeHead, error)
+	GetEntries(start, end int64) ([]ct.LogEntry, error)
+	GetConsistencyProof(first, second int64) (ct.ConsistencyProof, error)
+	GetAuditProof(hash ct.MerkleTreeNode, treeSize uint64) (ct.AuditPath, uint64, error)
+}
+
+// indexedProofClient is implemented by LogClients which can fetch the
+// inclusion proof of an entry by its index, such as *tiled.Client, which
+// can't look up entries by hash
+type indexedProofClient interface {
+	GetInclusionProof(index uint64, treeSize uint64) (ct.AuditPath, error)
+}
+
+// byteCountingClient is implemented by LogClients which count the bytes
+// they download, such as *client.LogClient
+type byteCountingClient interface {
+	BytesDownloaded() uint64
+}
+
+// ScanStats are statistics about the Scanner's latest scan
+type ScanStats struct {
+	EntriesFetched   uint64
+	EntriesProcessed uint64                       // passed to the ProcessCallback
+	EntriesMatched   uint64                       // counted by CountMatch
+	Errors           uint64                       // failed get-entries requests, including those retried
+	ErrorsByClass    map[client.ErrorClass]uint64 // Errors, by class
+	ParseErrors      uint64                       // entries passed to a PipelineCallback which couldn't be parsed
+	BytesDownloaded  uint64                       // by the LogClient, if it counts them
+	Elapsed          time.Duration
+	Workers          []WorkerStats // the fetcher, followed by the processors
+}
+
+// WorkerStats are statistics about one of a scan's workers: the fetcher,
+// which fetches entries from the log, or one of the NumWorkers processors,
+// which pass them to the ProcessCallback.  Idle is the time the worker spent
+// waiting for the others: the fetcher for the processors to take more
+// entries, or a processor for the fetcher to fetch more.  If the fetcher is
+// often idle, the scan is limited by processing, and more NumWorkers may
+// help; if the processors are, it's limited by fetching.  A processor's
+// statistics are updated every processorStatsInterval entries.
+type WorkerStats struct {
+	Name    string // fetcher, or processor N
+	Entries uint64
+	Busy    time.Duration
+	Idle    time.Duration
+}
+
+// Utilization returns the fraction of its time the worker spent busy
+func (worker WorkerStats) Utilization() float64 {
+	if worker.Busy+worker.Idle == 0 {
+		return 0
+	}
+	return float64(worker.Busy) / float64(worker.Busy+worker.Idle)
+}
+
+// How many entries a processor handles between updates of its statistics
+const processorStatsInterval = 100
+
+// scanStats are a Scanner's statistics, which are updated by several
+// goroutines
+type scanStats struct {
+	sync.Mutex
+	ScanStats
+	startTime    time.Time
+	endTime      time.Time // zero while scanning
+	bytesAtStart uint64
+}
+
 // Scanner is a tool to scan all the entries in a CT Log.
 type Scanner struct {
 	// Base URI of CT log
@@ -65,13 +177,22 @@ type Scanner struct {
 	LogId     []byte
 
 	// Client used to talk to the CT log instance
-	logClient *client.LogClient
+	logClient LogClient
 
 	// Configuration options for this Scanner instance
 	opts ScannerOptions
 
+	// Adds the log's URI to messages
+	logger *slog.Logger
+
 	// Stats
-	certsProcessed int64
+	stats *scanStats
+
+	// The span of the scan in progress, if it's being traced
+	scanSpan *tracing.Span
+
+	// When the next get-entries request may be made, if RateLimit is set
+	nextFetch time.Time
 }
 
 // fetchRange represents a range of certs to fetch from a CT log
@@ -84,40 +205,142 @@ type fetchRange struct {
 // Accepts ct.LogEntries over the |entries| channel, and invokes processCert on them.
 // Returns true over the |done| channel when the |entries| channel is closed.
 func (s *Scanner) processerJob(id int, entries <-chan ct.LogEntry, processCert ProcessCallback, wg *sync.WaitGroup) {
-	for entry := range entries {
-		atomic.AddInt64(&s.certsProcessed, 1)
-		processCert(s, &entry)
+	logger := s.getLogger().With("worker", id)
+	var worker WorkerStats // since its statistics were last updated
+	defer func() {
+		s.recordWorker(id+1, worker)
+		wg.Done()
+	}()
+	for {
+		var entry ct.LogEntry
+		var ok bool
+		select {
+		case entry, ok = <-entries:
+		default:
+			// Only time the wait if there is one
+			waitStart := time.Now()
+			entry, ok = <-entries
+			worker.Idle += time.Since(waitStart)
+		}
+		if !ok {
+			return
+		}
+		busyStart := time.Now()
+		worker.Entries++
+		if s.wantEntry(&entry, logger) {
+			s.updateStats(func(stats *ScanStats) { stats.EntriesProcessed++ })
+			s.opts.Collector.Add(MetricEntriesProcessed, 1, "log", s.LogUri)
+			processCert(s, &entry)
+		}
+		worker.Busy += time.Since(busyStart)
+		if worker.Entries == processorStatsInterval {
+			s.recordWorker(id+1, worker)
+			worker = WorkerStats{}
+		}
+	}
+}
+
+// recordWorker adds |worker|'s entries and times to the statistics of
+// worker |index| (0 for the fetcher)
+func (s *Scanner) recordWorker(index int, worker WorkerStats) {
+	var name string
+	s.updateStats(func(stats *ScanStats) {
+		total := &stats.Workers[index]
+		total.Entries += worker.Entries
+		total.Busy += worker.Busy
+		total.Idle += worker.Idle
+		name = total.Name
+	})
+	s.opts.Collector.Add(MetricWorkerEntries, float64(worker.Entries), "log", s.LogUri, "worker", name)
+	s.opts.Collector.Add(MetricWorkerBusySeconds, worker.Busy.Seconds(), "log", s.LogUri, "worker", name)
+	s.opts.Collector.Add(MetricWorkerIdleSeconds, worker.Idle.Seconds(), "log", s.LogUri, "worker", name)
+}
+
+func (s *Scanner) wantEntry(entry *ct.LogEntry, logger *slog.Logger) bool {
+	switch entry.Leaf.TimestampedEntry.EntryType {
+	case ct.X509LogEntryType:
+		return !s.opts.SkipCerts
+	case ct.PrecertLogEntryType:
+		return !s.opts.SkipPrecerts
+	default:
+		if !s.opts.Quiet {
+			logger.Debug("Skipping entry of unknown type", "index", entry.Index, "type", entry.Leaf.TimestampedEntry.EntryType)
+		}
+		return false
 	}
-	wg.Done()
 }
 
 func (s *Scanner) fetch(r fetchRange, entries chan<- ct.LogEntry, tree *CollapsedMerkleTree) error {
+	startTime := time.Now()
+	var worker WorkerStats
+	defer func() {
+		worker.Busy = time.Since(startTime) - worker.Idle
+		s.recordWorker(0, worker)
+	}()
 	success := false
 	retries := FETCH_RETRIES
-	retryWait := FETCH_RETRY_WAIT
+	retryWait := fetchRetryWait
 	for !success {
-		s.Log(fmt.Sprintf("Fetching entries %d to %d", r.start, r.end))
+		if err := s.waitForRateLimit(); err != nil {
+			return err
+		}
+		s.debug("Fetching entries", "start", r.start, "end", r.end)
+		span := s.scanSpan.Child("GetEntries", "start", r.start, "end", r.end)
+		span.SetKind(tracing.KindClient)
+		requestStart := time.Now()
 		logEntries, err := s.logClient.GetEntries(r.start, r.end)
+		s.opts.Collector.Observe(MetricGetEntriesSeconds, time.Since(requestStart).Seconds(), "log", s.LogUri)
+		span.SetFields("entries", len(logEntries))
+		span.SetError(err)
+		span.End()
 		if err != nil {
+			if s.opts.OnLogError != nil {
+				s.opts.OnLogError(s, err, retries > 0)
+			}
+			class := client.Classify(err)
+			s.updateStats(func(stats *ScanStats) {
+				stats.Errors++
+				stats.ErrorsByClass[class]++
+			})
+			s.opts.Collector.Add(MetricFetchErrors, 1, "log", s.LogUri, "class", string(class))
 			if retries == 0 {
-				s.Warn(fmt.Sprintf("Problem fetching entries %d to %d from log: %s", r.start, r.end, err.Error()))
+				s.getLogger().Warn("Problem fetching entries from log", "start", r.start, "end", r.end, "error", err, "error_class", class)
 				return err
 			} else {
-				s.Log(fmt.Sprintf("Problem fetching entries %d to %d from log (will retry): %s", r.start, r.end, err.Error()))
-				time.Sleep(time.Duration(retryWait) * time.Second)
+				s.debug("Problem fetching entries from log (will retry)", "start", r.start, "end", r.end, "error", err, "error_class", class, "retry_wait", retryWait)
+				select {
+				case <-time.After(retryWait):
+				case <-s.opts.Stop:
+					return ErrScanStopped
+				}
 				retries--
 				retryWait *= 2
 				continue
 			}
 		}
 		retries = FETCH_RETRIES
-		retryWait = FETCH_RETRY_WAIT
+		retryWait = fetchRetryWait
+		s.updateStats(func(stats *ScanStats) { stats.EntriesFetched += uint64(len(logEntries)) })
+		s.opts.Collector.Add(MetricEntriesFetched, float64(len(logEntries)), "log", s.LogUri)
+		s.opts.Collector.Observe(MetricGetEntriesSize, float64(len(logEntries)), "log", s.LogUri)
+		if s.opts.OnBatchFetched != nil {
+			s.opts.OnBatchFetched(s, r.start, len(logEntries))
+		}
 		for _, logEntry := range logEntries {
+			logEntry.LeafHash = hashLeaf(logEntry.LeafBytes)
 			if tree != nil {
-				tree.Add(hashLeaf(logEntry.LeafBytes))
+				tree.Add(logEntry.LeafHash)
 			}
 			logEntry.Index = r.start
-			entries <- logEntry
+			select {
+			case entries <- logEntry:
+			default:
+				// The processors are behind
+				waitStart := time.Now()
+				entries <- logEntry
+				worker.Idle += time.Since(waitStart)
+			}
+			worker.Entries++
 			r.start++
 		}
 		if r.start > r.end {
@@ -185,19 +408,127 @@ func humanTime(seconds int) string {
 	return s
 }
 
-func (s Scanner) Log(msg string) {
+// waitForRateLimit waits until another get-entries request may be made
+// without exceeding the RateLimit option
+func (s *Scanner) waitForRateLimit() error {
+	if s.opts.RateLimit <= 0 {
+		return nil
+	}
+	now := time.Now()
+	if wait := s.nextFetch.Sub(now); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-s.opts.Stop:
+			return ErrScanStopped
+		}
+		now = s.nextFetch
+	}
+	s.nextFetch = now.Add(time.Duration(float64(time.Second) / s.opts.RateLimit))
+	return nil
+}
+
+// updateStats calls |update| with the statistics locked
+func (s *Scanner) updateStats(update func(*ScanStats)) {
+	s.stats.Lock()
+	update(&s.stats.ScanStats)
+	s.stats.Unlock()
+}
+
+func (s *Scanner) bytesDownloaded() uint64 {
+	if countingClient, ok := s.logClient.(byteCountingClient); ok {
+		return countingClient.BytesDownloaded()
+	}
+	return 0
+}
+
+// TraceSpan returns the span of the scan in progress, or nil if it isn't
+// being traced.  A ProcessCallback can use it as the parent of its own
+// spans.
+func (s *Scanner) TraceSpan() *tracing.Span {
+	return s.scanSpan
+}
+
+// CountMatch counts an entry which the ProcessCallback found to match in the
+// scan's statistics.  It may be called concurrently.
+func (s *Scanner) CountMatch() {
+	s.updateStats(func(stats *ScanStats) { stats.EntriesMatched++ })
+}
+
+func (s *Scanner) countParseError() {
+	s.updateStats(func(stats *ScanStats) { stats.ParseErrors++ })
+	s.opts.Collector.Add(MetricParseErrors, 1, "log", s.LogUri)
+}
+
+// Stats returns a snapshot of the statistics of the current scan, or of the
+// last one if no scan is running.  It may be called while scanning.
+func (s *Scanner) Stats() ScanStats {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	stats := s.stats.ScanStats
+	stats.Workers = append([]WorkerStats(nil), stats.Workers...)
+	stats.ErrorsByClass = make(map[client.ErrorClass]uint64)
+	for class, count := range s.stats.ErrorsByClass {
+		stats.ErrorsByClass[class] = count
+	}
+	if s.stats.startTime.IsZero() {
+		return stats
+	}
+	if s.stats.endTime.IsZero() {
+		stats.Elapsed = time.Since(s.stats.startTime)
+		stats.BytesDownloaded = s.bytesDownloaded() - s.stats.bytesAtStart
+	} else {
+		stats.Elapsed = s.stats.endTime.Sub(s.stats.startTime)
+	}
+	return stats
+}
+
+func (s *Scanner) stopped() bool {
+	select {
+	case <-s.opts.Stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Scanner) getLogger() *slog.Logger {
+	if s.logger == nil {
+		return slog.With("log", s.LogUri)
+	}
+	return s.logger
+}
+
+// debug logs a progress message, unless the Quiet option is set
+func (s *Scanner) debug(msg string, fields ...interface{}) {
 	if !s.opts.Quiet {
-		log.Print(msg)
+		s.getLogger().Debug(msg, fields...)
 	}
 }
 
+func (s Scanner) Log(msg string) {
+	s.debug(msg)
+}
+
 func (s Scanner) Warn(msg string) {
-	log.Print(msg)
+	s.getLogger().Warn(msg)
 }
 
-func (s *Scanner) GetSTH() (*ct.SignedTreeHead, error) {
+func (s *Scanner) GetSTH() (sth *ct.SignedTreeHead, err error) {
+	span := tracing.Start(nil, "GetSTH", "log", s.LogUri)
+	span.SetKind(tracing.KindClient)
+	defer func() {
+		if sth != nil {
+			span.SetFields("tree_size", sth.TreeSize)
+		}
+		span.SetError(err)
+		span.End()
+	}()
+
 	latestSth, err := s.logClient.GetSTH()
 	if err != nil {
+		if s.opts.OnLogError != nil {
+			s.opts.OnLogError(s, err, false)
+		}
 		return nil, err
 	}
 	if s.publicKey != nil {
@@ -206,7 +537,7 @@ func (s *Scanner) GetSTH() (*ct.SignedTreeHead, error) {
 			return nil, err
 		}
 		if err := verifier.VerifySTHSignature(*latestSth); err != nil {
-			return nil, errors.New("STH signature is invalid: " + err.Error())
+			return nil, client.Errorf(client.ErrorSignature, "STH signature is invalid: %s", err)
 		}
 	}
 	copy(latestSth.LogID[:], s.LogId)
@@ -214,11 +545,18 @@ func (s *Scanner) GetSTH() (*ct.SignedTreeHead, error) {
 }
 
 func (s *Scanner) CheckConsistency(first *ct.SignedTreeHead, second *ct.SignedTreeHead) (bool, error) {
+	isValid, _, err := s.CheckConsistencyWithProof(first, second)
+	return isValid, err
+}
+
+// CheckConsistencyWithProof is like CheckConsistency, but also returns the
+// consistency proof that was checked, so it can be kept as evidence.
+func (s *Scanner) CheckConsistencyWithProof(first *ct.SignedTreeHead, second *ct.SignedTreeHead) (bool, ct.ConsistencyProof, error) {
 	var proof ct.ConsistencyProof
 
 	if first.TreeSize > second.TreeSize {
 		// No way this can be valid
-		return false, nil
+		return false, nil, nil
 	} else if first.TreeSize == second.TreeSize {
 		// The proof *should* be empty, so don't bother contacting the server.
 		// This is necessary because the digicert server returns a 400 error if first==second.
@@ -227,11 +565,59 @@ func (s *Scanner) CheckConsistency(first *ct.SignedTreeHead, second *ct.SignedTr
 		var err error
 		proof, err = s.logClient.GetConsistencyProof(int64(first.TreeSize), int64(second.TreeSize))
 		if err != nil {
-			return false, err
+			return false, nil, err
 		}
 	}
 
-	return VerifyConsistencyProof(proof, first, second), nil
+	return VerifyConsistencyProof(proof, first, second), proof, nil
+}
+
+// CheckIncorporation fetches an inclusion proof from the log for the entry with
+// the given leaf hash, and checks it against the root hash in |sth|.  Returns
+// the index of the entry in the log, and the inclusion proof, which is also
+// returned (with the index) if it doesn't verify.
+func (s *Scanner) CheckIncorporation(leafHash ct.MerkleTreeNode, sth *ct.SignedTreeHead) (uint64, ct.AuditPath, error) {
+	auditPath, leafIndex, err := s.logClient.GetAuditProof(leafHash, sth.TreeSize)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !VerifyInclusionProof(auditPath, leafIndex, leafHash, sth) {
+		return leafIndex, auditPath, fmt.Errorf("Inclusion proof for entry %d (leaf hash %x) does not verify against STH of size %d", leafIndex, leafHash, sth.TreeSize)
+	}
+	return leafIndex, auditPath, nil
+}
+
+// VerifyInclusion checks that the entry with the given leaf hash is included at
+// |index| in the tree described by |sth|.  Returns the inclusion proof, which
+// is also returned if it doesn't verify.
+func (s *Scanner) VerifyInclusion(leafHash ct.MerkleTreeNode, index int64, sth *ct.SignedTreeHead) (ct.AuditPath, error) {
+	if uint64(index) >= sth.TreeSize {
+		return nil, fmt.Errorf("Entry %d is not covered by STH of size %d", index, sth.TreeSize)
+	}
+	auditPath, err := s.getInclusionProof(leafHash, uint64(index), sth.TreeSize)
+	if err != nil {
+		return nil, err
+	}
+	if !VerifyInclusionProof(auditPath, uint64(index), leafHash, sth) {
+		return auditPath, fmt.Errorf("Inclusion proof for entry %d (leaf hash %x) does not verify against STH of size %d", index, leafHash, sth.TreeSize)
+	}
+	return auditPath, nil
+}
+
+// getInclusionProof fetches the inclusion proof of the entry at |index|,
+// whose leaf hash is |leafHash|, in the tree of size |treeSize|
+func (s *Scanner) getInclusionProof(leafHash ct.MerkleTreeNode, index uint64, treeSize uint64) (ct.AuditPath, error) {
+	if indexedClient, ok := s.logClient.(indexedProofClient); ok {
+		return indexedClient.GetInclusionProof(index, treeSize)
+	}
+	auditPath, leafIndex, err := s.logClient.GetAuditProof(leafHash, treeSize)
+	if err != nil {
+		return nil, err
+	}
+	if leafIndex != index {
+		return nil, fmt.Errorf("Log returned inclusion proof for index %d instead of %d", leafIndex, index)
+	}
+	return auditPath, nil
 }
 
 func (s *Scanner) MakeCollapsedMerkleTree(sth *ct.SignedTreeHead) (*CollapsedMerkleTree, error) {
@@ -250,7 +636,7 @@ func (s *Scanner) MakeCollapsedMerkleTree(sth *ct.SignedTreeHead) (*CollapsedMer
 
 	var tree *CollapsedMerkleTree
 	if sth.TreeSize > 1 {
-		auditPath, _, err := s.logClient.GetAuditProof(leafHash, sth.TreeSize)
+		auditPath, err := s.getInclusionProof(leafHash, sth.TreeSize-1, sth.TreeSize)
 		if err != nil {
 			return nil, err
 		}
@@ -271,21 +657,164 @@ func (s *Scanner) MakeCollapsedMerkleTree(sth *ct.SignedTreeHead) (*CollapsedMer
 	return tree, nil
 }
 
-func (s *Scanner) Scan(startIndex int64, endIndex int64, processCert ProcessCallback, tree *CollapsedMerkleTree) error {
-	s.Log("Starting scan...")
+// MakeCollapsedMerkleTreeAt returns the collapsed Merkle tree of the first
+// |size| entries of the tree described by |sth|, so that scanning can start
+// at |size|.  The tree is made from the inclusion proof of entry |size|-1.
+func (s *Scanner) MakeCollapsedMerkleTreeAt(size uint64, sth *ct.SignedTreeHead) (*CollapsedMerkleTree, error) {
+	if size == 0 {
+		return EmptyCollapsedMerkleTree(), nil
+	}
+	if size == sth.TreeSize {
+		return s.MakeCollapsedMerkleTree(sth)
+	}
+	if size > sth.TreeSize {
+		return nil, fmt.Errorf("Tree size %d is larger than STH of size %d", size, sth.TreeSize)
+	}
+
+	index := size - 1
+	entries, err := s.logClient.GetEntries(int64(index), int64(index))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("Log did not return entry %d", index)
+	}
+	leafHash := hashLeaf(entries[0].LeafBytes)
+
+	auditPath, err := s.getInclusionProof(leafHash, index, sth.TreeSize)
+	if err != nil {
+		return nil, err
+	}
+	return collapsedTreeFromInclusionProof(auditPath, index, leafHash, sth)
+}
+
+// The number of entries FindBadEntry fetches between inclusion proofs
+const badEntryBatchSize = 1000
+
+// FindBadEntry is used when the entries after |tree| don't hash to the root
+// in |sth|.  It fetches them again, checking them against the log's
+// inclusion proofs, and returns the first entry which the log's tree
+// disagrees with, the audit path the log gave for it (nil if the log had
+// none), and the tree before the entry.  Returns a nil entry if the entries
+// now agree with |sth|, as happens if the log served different entries
+// before.
+func (s *Scanner) FindBadEntry(tree *CollapsedMerkleTree, sth *ct.SignedTreeHead) (*ct.LogEntry, ct.AuditPath, *CollapsedMerkleTree, error) {
+	tree = CloneCollapsedMerkleTree(tree)
+	for start := int64(tree.GetSize()); start < int64(sth.TreeSize); {
+		end := start + badEntryBatchSize - 1
+		if end >= int64(sth.TreeSize) {
+			end = int64(sth.TreeSize) - 1
+		}
+		entries, err := s.logClient.GetEntries(start, end)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(entries) == 0 {
+			return nil, nil, nil, fmt.Errorf("Log did not return entry %d", start)
+		}
+
+		// trees[i] is the tree before entries[i] is added
+		trees := make([]*CollapsedMerkleTree, len(entries)+1)
+		for i := range entries {
+			entries[i].Index = start + int64(i)
+			trees[i] = CloneCollapsedMerkleTree(tree)
+			tree.Add(hashLeaf(entries[i].LeafBytes))
+		}
+		trees[len(entries)] = tree
+
+		if _, agrees, err := s.checkEntry(&entries[len(entries)-1], tree, sth); err != nil {
+			return nil, nil, nil, err
+		} else if !agrees {
+			// Binary search for the first entry the log disagrees with,
+			// relying on every later entry disagreeing too
+			low, high := 0, len(entries)-1
+			var auditPath ct.AuditPath
+			for low < high {
+				mid := (low + high) / 2
+				_, agrees, err := s.checkEntry(&entries[mid], trees[mid+1], sth)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				if agrees {
+					low = mid + 1
+				} else {
+					high = mid
+				}
+			}
+			auditPath, _, err = s.checkEntry(&entries[low], trees[low+1], sth)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			return &entries[low], auditPath, trees[low], nil
+		}
+		start += int64(len(entries))
+	}
+	return nil, nil, nil, nil
+}
+
+// checkEntry fetches the inclusion proof of |entry| in |sth|, and returns
+// it along with whether it shows that |tree|, the tree up to and including
+// |entry|, agrees with the log's tree
+func (s *Scanner) checkEntry(entry *ct.LogEntry, tree *CollapsedMerkleTree, sth *ct.SignedTreeHead) (ct.AuditPath, bool, error) {
+	leafHash := hashLeaf(entry.LeafBytes)
+	auditPath, err := s.getInclusionProof(leafHash, uint64(entry.Index), sth.TreeSize)
+	if client.Classify(err) == client.ErrorHTTP {
+		// The log has no proof for the entry it served
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	logTree, err := collapsedTreeFromInclusionProof(auditPath, uint64(entry.Index), leafHash, sth)
+	if err != nil {
+		return auditPath, false, nil
+	}
+	return auditPath, bytes.Equal(logTree.CalculateRoot(), tree.CalculateRoot()), nil
+}
+
+func (s *Scanner) Scan(startIndex int64, endIndex int64, processCert ProcessCallback, tree *CollapsedMerkleTree) (err error) {
+	s.debug("Starting scan", "start", startIndex, "end", endIndex)
+	if s.opts.OnScanStart != nil {
+		s.opts.OnScanStart(s, startIndex, endIndex)
+	}
+	if s.opts.OnScanComplete != nil {
+		// Deferred before finishStats, so that it's called after
+		defer func() { s.opts.OnScanComplete(s, err) }()
+	}
 
-	s.certsProcessed = 0
 	startTime := time.Now()
+	s.stats.Lock()
+	s.stats.ScanStats = ScanStats{
+		ErrorsByClass: make(map[client.ErrorClass]uint64),
+		Workers:       []WorkerStats{{Name: "fetcher"}},
+	}
+	for w := 0; w < s.opts.NumWorkers; w++ {
+		s.stats.Workers = append(s.stats.Workers, WorkerStats{Name: fmt.Sprintf("processor %d", w)})
+	}
+	s.stats.startTime = startTime
+	s.stats.endTime = time.Time{}
+	s.stats.bytesAtStart = s.bytesDownloaded()
+	s.stats.Unlock()
+	defer s.finishStats()
+	s.scanSpan = tracing.Start(nil, "Scan", "log", s.LogUri, "start", startIndex, "end", endIndex)
+	defer func() {
+		if err == ErrScanStopped {
+			s.scanSpan.SetFields("stopped", true)
+		} else {
+			s.scanSpan.SetError(err)
+		}
+		s.scanSpan.End()
+	}()
 	/* TODO: only launch ticker goroutine if in verbose mode; kill the goroutine when the scanner finishes
 	ticker := time.NewTicker(time.Second)
 	go func() {
 		for range ticker.C {
-			throughput := float64(s.certsProcessed) / time.Since(startTime).Seconds()
-			remainingCerts := int64(endIndex) - int64(startIndex) - s.certsProcessed
+			processed := int64(s.Stats().EntriesProcessed)
+			throughput := float64(processed) / time.Since(startTime).Seconds()
+			remainingCerts := int64(endIndex) - int64(startIndex) - processed
 			remainingSeconds := int(float64(remainingCerts) / throughput)
 			remainingString := humanTime(remainingSeconds)
-			s.Log(fmt.Sprintf("Processed: %d certs (to index %d). Throughput: %3.2f ETA: %s", s.certsProcessed,
-				startIndex+int64(s.certsProcessed), throughput, remainingString))
+			s.Log(fmt.Sprintf("Processed: %d certs (to index %d). Throughput: %3.2f ETA: %s", processed,
+				startIndex+processed, throughput, remainingString))
 		}
 	}()
 	*/
@@ -297,29 +826,84 @@ func (s *Scanner) Scan(startIndex int64, endIndex int64, processCert ProcessCall
 		processorWG.Add(1)
 		go s.processerJob(w, jobs, processCert, &processorWG)
 	}
+	// However the scan ends, let the processors finish the entries already
+	// fetched, so that none are processed after Scan returns
+	processorsDone := false
+	waitForProcessors := func() {
+		if !processorsDone {
+			processorsDone = true
+			close(jobs)
+			processorWG.Wait()
+		}
+	}
+	defer waitForProcessors()
 
 	for start := startIndex; start < int64(endIndex); {
 		end := min(start+int64(s.opts.BatchSize), int64(endIndex)) - 1
-		if err := s.fetch(fetchRange{start, end}, jobs, tree); err != nil {
+		err := ErrScanStopped
+		if !s.stopped() {
+			err = s.fetch(fetchRange{start, end}, jobs, tree)
+		}
+		if err == ErrScanStopped {
+			waitForProcessors()
+			s.debug("Stopped scan", "certs", s.Stats().EntriesProcessed, "elapsed", humanTime(int(time.Since(startTime).Seconds())))
+			return err
+		} else if err != nil {
 			return err
 		}
+		if s.opts.OnRangeComplete != nil {
+			s.opts.OnRangeComplete(s, start, end)
+		}
 		start = end + 1
 	}
-	close(jobs)
-	processorWG.Wait()
-	s.Log(fmt.Sprintf("Completed %d certs in %s", s.certsProcessed, humanTime(int(time.Since(startTime).Seconds()))))
+	waitForProcessors()
+	stats := s.Stats()
+	s.debug("Completed scan", "certs", stats.EntriesProcessed, "elapsed", humanTime(int(time.Since(startTime).Seconds())), "fetcher_utilization", stats.Workers[0].Utilization(), "processor_utilization", processorUtilization(stats.Workers[1:]))
 
 	return nil
 }
 
-// Creates a new Scanner instance using |client| to talk to the log, and taking
+// processorUtilization returns the mean utilization of |processors|
+func processorUtilization(processors []WorkerStats) float64 {
+	var busy, total time.Duration
+	for _, processor := range processors {
+		busy += processor.Busy
+		total += processor.Busy + processor.Idle
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(busy) / float64(total)
+}
+
+// finishStats records the end of the scan in the statistics
+func (s *Scanner) finishStats() {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	s.stats.endTime = time.Now()
+	s.stats.BytesDownloaded = s.bytesDownloaded() - s.stats.bytesAtStart
+}
+
+// Creates a new Scanner instance for the RFC 6962 log at |logUri|, taking
 // configuration options from |opts|.
 func NewScanner(logUri string, logId []byte, publicKey crypto.PublicKey, opts *ScannerOptions) *Scanner {
+	if opts.Timeout > 0 {
+		return NewScannerWithClient(logUri, logId, publicKey, client.NewWithTimeout(logUri, opts.Timeout), opts)
+	} else {
+		return NewScannerWithClient(logUri, logId, publicKey, client.New(logUri), opts)
+	}
+}
+
+// NewScannerWithClient is like NewScanner, but uses |logClient| to talk to
+// the log, e.g. a *tiled.Client for a static-ct-api log
+func NewScannerWithClient(logUri string, logId []byte, publicKey crypto.PublicKey, logClient LogClient, opts *ScannerOptions) *Scanner {
 	var scanner Scanner
 	scanner.LogUri = logUri
 	scanner.LogId = logId
 	scanner.publicKey = publicKey
-	scanner.logClient = client.New(logUri)
+	scanner.logClient = logClient
 	scanner.opts = *opts
+	scanner.logger = slog.With("log", logUri)
+	scanner.stats = new(scanStats)
 	return &scanner
 }
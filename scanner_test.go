@@ -0,0 +1,313 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/ct/client"
+)
+
+// fakeLogClient serves entries with no certificates, failing the first
+// get-entries request as if rate limited
+type fakeLogClient struct {
+	mu       sync.Mutex
+	requests int
+	bytes    uint64
+}
+
+func (c *fakeLogClient) GetSTH() (*ct.SignedTreeHead, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeLogClient) GetEntries(start, end int64) ([]ct.LogEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	if c.requests == 1 {
+		return nil, client.StatusError(429, "429 Too Many Requests")
+	}
+	var entries []ct.LogEntry
+	for i := start; i <= end; i++ {
+		var entry ct.LogEntry
+		entry.LeafBytes = []byte{byte(i)}
+		entry.Leaf.TimestampedEntry.EntryType = ct.X509LogEntryType
+		entries = append(entries, entry)
+		c.bytes += 100
+	}
+	return entries, nil
+}
+
+func (c *fakeLogClient) GetConsistencyProof(first, second int64) (ct.ConsistencyProof, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeLogClient) GetAuditProof(hash ct.MerkleTreeNode, treeSize uint64) (ct.AuditPath, uint64, error) {
+	return nil, 0, errors.New("not implemented")
+}
+
+func (c *fakeLogClient) BytesDownloaded() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+func TestScannerStats(t *testing.T) {
+	opts := DefaultScannerOptions()
+	opts.BatchSize = 10
+	opts.NumWorkers = 4
+	opts.Quiet = true
+	logClient := &fakeLogClient{bytes: 5000} // downloaded before the scan
+	scanner := NewScannerWithClient("https://ct.example.com", nil, nil, logClient, opts)
+
+	if stats := scanner.Stats(); stats.EntriesFetched != 0 || stats.Elapsed != 0 || len(stats.Workers) != 0 {
+		t.Errorf("Stats before scanning: %+v", stats)
+	}
+
+	err := scanner.Scan(0, 95, func(scanner *Scanner, entry *ct.LogEntry) {
+		if entry.Index%3 == 0 {
+			scanner.CountMatch()
+		}
+		scanner.Stats() // mustn't race with the other workers
+	}, EmptyCollapsedMerkleTree())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := scanner.Stats()
+	if stats.EntriesFetched != 95 || stats.EntriesProcessed != 95 || stats.EntriesMatched != 32 || stats.Errors != 1 || stats.BytesDownloaded != 9500 {
+		t.Errorf("Wrong stats: %+v", stats)
+	}
+	if len(stats.ErrorsByClass) != 1 || stats.ErrorsByClass[client.ErrorRateLimited] != 1 {
+		t.Errorf("Wrong errors by class: %v", stats.ErrorsByClass)
+	}
+	if stats.Elapsed <= 0 || scanner.Stats().Elapsed != stats.Elapsed {
+		t.Errorf("Elapsed should be fixed once the scan is done: %s", stats.Elapsed)
+	}
+
+	if len(stats.Workers) != 5 || stats.Workers[0].Name != "fetcher" || stats.Workers[0].Entries != 95 || stats.Workers[4].Name != "processor 3" {
+		t.Fatalf("Wrong worker stats: %+v", stats.Workers)
+	}
+	var processed uint64
+	for _, worker := range stats.Workers[1:] {
+		processed += worker.Entries
+		if utilization := worker.Utilization(); utilization < 0 || utilization > 1 {
+			t.Errorf("%s has utilization %f", worker.Name, utilization)
+		}
+	}
+	if processed != 95 {
+		t.Errorf("Processors handled %d entries", processed)
+	}
+	// The fetcher was busy waiting to retry the failed request
+	if stats.Workers[0].Busy < time.Second {
+		t.Errorf("Fetcher was busy for only %s", stats.Workers[0].Busy)
+	}
+}
+
+func TestScannerHooks(t *testing.T) {
+	var events []string
+	opts := DefaultScannerOptions()
+	opts.BatchSize = 50
+	opts.Quiet = true
+	opts.OnScanStart = func(s *Scanner, start, end int64) {
+		events = append(events, fmt.Sprintf("start %d-%d", start, end))
+	}
+	opts.OnBatchFetched = func(s *Scanner, start int64, entries int) {
+		events = append(events, fmt.Sprintf("fetched %d+%d", start, entries))
+	}
+	opts.OnRangeComplete = func(s *Scanner, start, end int64) {
+		events = append(events, fmt.Sprintf("range %d-%d", start, end))
+	}
+	opts.OnScanComplete = func(s *Scanner, err error) {
+		events = append(events, fmt.Sprintf("complete %v %d", err, s.Stats().EntriesProcessed))
+	}
+	opts.OnLogError = func(s *Scanner, err error, willRetry bool) {
+		events = append(events, fmt.Sprintf("error %s %v", client.Classify(err), willRetry))
+	}
+	scanner := NewScannerWithClient("https://ct.example.com", nil, nil, &fakeLogClient{}, opts)
+	if err := scanner.Scan(0, 80, func(*Scanner, *ct.LogEntry) {}, EmptyCollapsedMerkleTree()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"start 0-80",
+		"error rate_limited true",
+		"fetched 0+50",
+		"range 0-49",
+		"fetched 50+30",
+		"range 50-79",
+		"complete <nil> 80",
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Wrong events:\n%s", strings.Join(events, "\n"))
+	}
+}
+
+// failingLogClient serves the first |good| entries, and fails every request
+// for any after them
+type failingLogClient struct {
+	fakeLogClient
+	good int64
+}
+
+func (c *failingLogClient) GetEntries(start, end int64) ([]ct.LogEntry, error) {
+	if end >= c.good {
+		return nil, client.StatusError(500, "500 Internal Server Error")
+	}
+	var entries []ct.LogEntry
+	for i := start; i <= end; i++ {
+		var entry ct.LogEntry
+		entry.LeafBytes = []byte{byte(i)}
+		entry.Leaf.TimestampedEntry.EntryType = ct.X509LogEntryType
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func TestScannerFetchError(t *testing.T) {
+	defer func(wait time.Duration) { fetchRetryWait = wait }(fetchRetryWait)
+	fetchRetryWait = time.Microsecond
+
+	opts := DefaultScannerOptions()
+	opts.BatchSize = 10
+	opts.NumWorkers = 4
+	opts.Quiet = true
+	scanner := NewScannerWithClient("https://ct.example.com", nil, nil, &failingLogClient{good: 20}, opts)
+
+	var mu sync.Mutex
+	processed := 0
+	returned := false
+	err := scanner.Scan(0, 50, func(*Scanner, *ct.LogEntry) {
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if returned {
+			t.Errorf("Entry processed after Scan returned")
+		}
+		processed++
+	}, EmptyCollapsedMerkleTree())
+	mu.Lock()
+	returned = true
+	processedBeforeReturn := processed
+	mu.Unlock()
+	if err == nil || err == ErrScanStopped {
+		t.Fatalf("Scan returned %v instead of the fetch error", err)
+	}
+	if processedBeforeReturn != 20 {
+		t.Errorf("%d entries processed before Scan returned, instead of 20", processedBeforeReturn)
+	}
+
+	// Give any leaked processors a chance to run
+	time.Sleep(50 * time.Millisecond)
+}
+
+// proofLogClient serves the entries of a log whose tree is made of
+// makeTestLeaves, except that the entry at |bad| is replaced
+type proofLogClient struct {
+	leaves []ct.MerkleTreeNode
+	bad    int64
+}
+
+func (c *proofLogClient) leafBytes(index int64) []byte {
+	if index == c.bad {
+		return []byte("bogus")
+	}
+	return []byte{byte(index), byte(index >> 8)}
+}
+
+func (c *proofLogClient) GetSTH() (*ct.SignedTreeHead, error) {
+	return makeTestSTH(c.leaves), nil
+}
+
+func (c *proofLogClient) GetEntries(start, end int64) ([]ct.LogEntry, error) {
+	if end >= start+100 {
+		end = start + 99 // like a real log, return at most 100 entries
+	}
+	var entries []ct.LogEntry
+	for i := start; i <= end; i++ {
+		entries = append(entries, ct.LogEntry{LeafBytes: c.leafBytes(i)})
+	}
+	return entries, nil
+}
+
+func (c *proofLogClient) GetConsistencyProof(first, second int64) (ct.ConsistencyProof, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *proofLogClient) GetAuditProof(hash ct.MerkleTreeNode, treeSize uint64) (ct.AuditPath, uint64, error) {
+	for i, leaf := range c.leaves[:treeSize] {
+		if bytes.Equal(leaf, hash) {
+			return referencePath(uint64(i), c.leaves[:treeSize]), uint64(i), nil
+		}
+	}
+	return nil, 0, client.StatusError(404, "404 Not Found")
+}
+
+// indexedProofLogClient can also fetch inclusion proofs by index, like a
+// tiled log
+type indexedProofLogClient struct {
+	proofLogClient
+}
+
+func (c *indexedProofLogClient) GetInclusionProof(index uint64, treeSize uint64) (ct.AuditPath, error) {
+	return referencePath(index, c.leaves[:treeSize]), nil
+}
+
+func TestFindBadEntry(t *testing.T) {
+	leaves := makeTestLeaves(2500)
+	sth := makeTestSTH(leaves)
+	start := EmptyCollapsedMerkleTree()
+	for _, leaf := range leaves[:100] {
+		start.Add(leaf)
+	}
+
+	for _, bad := range []int64{100, 1234, 1999, 2000, 2499} {
+		indexed := &indexedProofLogClient{proofLogClient{leaves: leaves, bad: bad}}
+		for _, logClient := range []LogClient{&indexed.proofLogClient, indexed} {
+			scanner := NewScannerWithClient("https://ct.example.com", nil, nil, logClient, DefaultScannerOptions())
+			entry, auditPath, tree, err := scanner.FindBadEntry(start, sth)
+			if err != nil {
+				t.Errorf("bad entry %d (%T): %s", bad, logClient, err)
+				continue
+			}
+			if entry == nil || entry.Index != bad || string(entry.LeafBytes) != "bogus" {
+				t.Errorf("bad entry %d (%T): found %+v", bad, logClient, entry)
+				continue
+			}
+			if tree.GetSize() != uint64(bad) || !bytes.Equal(tree.CalculateRoot(), referenceRoot(leaves[:bad])) {
+				t.Errorf("bad entry %d (%T): wrong tree of size %d", bad, logClient, tree.GetSize())
+			}
+			if logClient == indexed {
+				if !reflect.DeepEqual(auditPath, referencePath(uint64(bad), leaves)) {
+					t.Errorf("bad entry %d: wrong audit path", bad)
+				}
+			} else if auditPath != nil {
+				// The log can't find the bogus entry by its hash
+				t.Errorf("bad entry %d (%T): unexpected audit path", bad, logClient)
+			}
+		}
+	}
+	if start.GetSize() != 100 {
+		t.Errorf("FindBadEntry modified the tree")
+	}
+
+	scanner := NewScannerWithClient("https://ct.example.com", nil, nil, &proofLogClient{leaves: leaves, bad: -1}, DefaultScannerOptions())
+	if entry, _, _, err := scanner.FindBadEntry(start, sth); entry != nil || err != nil {
+		t.Errorf("found bad entry %+v (%v) in a good log", entry, err)
+	}
+}
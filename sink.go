@@ -0,0 +1,17 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+// A Sink receives matching entries, e.g. to save them or pass them on to
+// another system.  Implementations are in the sink package.
+type Sink interface {
+	Write(*EntryInfo) error
+	Close() error
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// The columns which may appear in a CSVSink
+const (
+	ColumnDomain      = "domain"      // DNS names, space-separated
+	ColumnIPAddress   = "ip_address"  // IP addresses, space-separated
+	ColumnIssuer      = "issuer"      // issuer distinguished name
+	ColumnSubject     = "subject"     // subject distinguished name
+	ColumnNotBefore   = "not_before"  // RFC 3339
+	ColumnNotAfter    = "not_after"   // RFC 3339
+	ColumnSerial      = "serial"      // hex
+	ColumnFingerprint = "fingerprint" // SHA-256, hex
+	ColumnType        = "type"        // cert or precert
+	ColumnLog         = "log"         // URI of the log
+	ColumnIndex       = "index"       // index of the entry in the log
+	ColumnTimestamp   = "timestamp"   // when the entry was logged, RFC 3339
+	ColumnMatches     = "matches"     // IDs of the matches, space-separated
+)
+
+const DefaultCSVColumns = "domain,issuer,not_before,not_after,serial,log,index"
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func csvValue(column string, info *certspotter.EntryInfo, entry *certspotter.ParsedEntry) string {
+	switch column {
+	case ColumnDomain:
+		return strings.Join(entry.DNSNames, " ")
+	case ColumnIPAddress:
+		addrs := make([]string, len(entry.IPAddresses))
+		for i, addr := range entry.IPAddresses {
+			addrs[i] = addr.String()
+		}
+		return strings.Join(addrs, " ")
+	case ColumnIssuer:
+		return entry.Issuer
+	case ColumnSubject:
+		return entry.Subject
+	case ColumnNotBefore:
+		return formatTime(entry.NotBefore)
+	case ColumnNotAfter:
+		return formatTime(entry.NotAfter)
+	case ColumnSerial:
+		return entry.Serial
+	case ColumnFingerprint:
+		return entry.Fingerprint
+	case ColumnType:
+		if entry.IsPrecert {
+			return "precert"
+		}
+		return "cert"
+	case ColumnLog:
+		return entry.LogURI
+	case ColumnIndex:
+		return strconv.FormatInt(entry.Index, 10)
+	case ColumnTimestamp:
+		return formatTime(&entry.Timestamp)
+	case ColumnMatches:
+		return strings.Join(info.MatchIDs(), " ")
+	}
+	panic("invalid column " + column)
+}
+
+// CSVSink writes each entry to a CSV file as a row with the given columns.
+// A header row is written first if the file is empty.
+type CSVSink struct {
+	Columns []string
+	out     *output
+}
+
+// NewCSVSink creates a CSVSink which appends to the file at |path|, or writes
+// to stdout if |path| is "-".  |columns| is a comma-separated list of
+// Column* constants.
+func NewCSVSink(path string, columns string) (*CSVSink, error) {
+	sink := &CSVSink{Columns: strings.Split(columns, ",")}
+	for _, column := range sink.Columns {
+		switch column {
+		case ColumnDomain, ColumnIPAddress, ColumnIssuer, ColumnSubject, ColumnNotBefore, ColumnNotAfter, ColumnSerial,
+			ColumnFingerprint, ColumnType, ColumnLog, ColumnIndex, ColumnTimestamp, ColumnMatches:
+		default:
+			return nil, fmt.Errorf("Invalid CSV column `%s'", column)
+		}
+	}
+	out, err := openOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	sink.out = out
+	return sink, nil
+}
+
+func (sink *CSVSink) Write(info *certspotter.EntryInfo) error {
+	entry := info.Parse()
+	row := make([]string, len(sink.Columns))
+	for i, column := range sink.Columns {
+		row[i] = csvValue(column, info, entry)
+	}
+	err := sink.out.write(func(writer *bufio.Writer) error {
+		csvWriter := csv.NewWriter(writer)
+		if sink.out.empty {
+			if err := csvWriter.Write(sink.Columns); err != nil {
+				return err
+			}
+		}
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	})
+	if err != nil {
+		return fmt.Errorf("Error writing CSV row: %s", err)
+	}
+	return nil
+}
+
+func (sink *CSVSink) Close() error {
+	return sink.out.Close()
+}
@@ -0,0 +1,190 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/compression"
+)
+
+// The components which may appear in a DiskSink layout
+const (
+	LayoutDomain      = "domain"      // first DNS name in the certificate
+	LayoutDate        = "date"        // date the certificate was found (YYYY-MM-DD)
+	LayoutIssued      = "issued"      // notBefore date of the certificate (YYYY-MM-DD)
+	LayoutLog         = "log"         // log the certificate was found in
+	LayoutFingerprint = "fingerprint" // first two hex digits of the fingerprint
+)
+
+// The file formats supported by DiskSink
+const (
+	FormatPEM  = "pem"  // the certificate chain, PEM-encoded
+	FormatDER  = "der"  // the certificate only, DER-encoded
+	FormatJSON = "json" // a Record
+)
+
+// DiskSink saves each certificate to a file named after its fingerprint,
+// in a directory hierarchy given by Layout.  For example, the layout
+// "date/domain" saves certificates to DIR/2017-03-01/www.example.com/FINGERPRINT.cert.pem
+type DiskSink struct {
+	Dir    string
+	Layout []string
+	Format string
+
+	// If set, files are compressed with this algorithm (see the
+	// compression package), and named accordingly (e.g. .cert.pem.gz)
+	Compression string
+
+	// If set, files are named FINGERPRINT.FORMAT, without indicating
+	// whether they're certificates or precertificates
+	PlainNames bool
+}
+
+// NewDiskSink creates a DiskSink.  |layout| is a slash-separated list of
+// Layout* constants, and may be empty to save all certificates in |dir|.
+func NewDiskSink(dir string, layout string, format string) (*DiskSink, error) {
+	sink := &DiskSink{Dir: dir, Format: format}
+	if layout != "" {
+		sink.Layout = strings.Split(layout, "/")
+	}
+	for _, component := range sink.Layout {
+		switch component {
+		case LayoutDomain, LayoutDate, LayoutIssued, LayoutLog, LayoutFingerprint:
+		default:
+			return nil, fmt.Errorf("Invalid layout component `%s'", component)
+		}
+	}
+	switch format {
+	case FormatPEM, FormatDER, FormatJSON:
+	default:
+		return nil, fmt.Errorf("Invalid format `%s'", format)
+	}
+	return sink, nil
+}
+
+// Make a string safe for use as a single path component
+func sanitizeFilename(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == '*' || r == ':' || r < 0x20:
+			return '_'
+		default:
+			return r
+		}
+	}, s)
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+func (sink *DiskSink) componentValue(component string, info *certspotter.EntryInfo, record *Record) string {
+	switch component {
+	case LayoutDomain:
+		if len(record.DNSNames) == 0 {
+			return "_none"
+		}
+		return sanitizeFilename(strings.ToLower(record.DNSNames[0]))
+	case LayoutDate:
+		return time.Now().UTC().Format("2006-01-02")
+	case LayoutIssued:
+		if record.NotBefore == nil {
+			return "_unknown"
+		}
+		return record.NotBefore.UTC().Format("2006-01-02")
+	case LayoutLog:
+		if u, err := url.Parse(info.LogUri); err == nil && u.Host != "" {
+			return sanitizeFilename(u.Host + strings.TrimSuffix(u.Path, "/"))
+		}
+		return sanitizeFilename(info.LogUri)
+	case LayoutFingerprint:
+		return record.Fingerprint[0:2]
+	}
+	panic("invalid layout component " + component)
+}
+
+func (sink *DiskSink) encode(record *Record) ([]byte, error) {
+	switch sink.Format {
+	case FormatPEM:
+		var buf bytes.Buffer
+		for _, cert := range record.Chain {
+			if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert}); err != nil {
+				return nil, err
+			}
+		}
+		return buf.Bytes(), nil
+	case FormatDER:
+		return record.Chain[0], nil
+	case FormatJSON:
+		data, err := json.MarshalIndent(record, "", "\t")
+		return append(data, '\n'), err
+	}
+	panic("invalid format " + sink.Format)
+}
+
+// path returns the path to which the certificate in |info| is saved
+func (sink *DiskSink) path(info *certspotter.EntryInfo, record *Record) string {
+	components := []string{sink.Dir}
+	for _, component := range sink.Layout {
+		components = append(components, sink.componentValue(component, info, record))
+	}
+	var suffix string
+	if sink.PlainNames {
+		suffix = "." + sink.Format
+	} else if record.IsPrecert {
+		suffix = ".precert." + sink.Format
+	} else {
+		suffix = ".cert." + sink.Format
+	}
+	suffix += compression.Extension(sink.Compression)
+	return filepath.Join(append(components, record.Fingerprint+suffix)...)
+}
+
+func (sink *DiskSink) Write(info *certspotter.EntryInfo) error {
+	if len(info.FullChain) == 0 {
+		return fmt.Errorf("Cannot save an empty certificate chain")
+	}
+	record := MakeRecord(info)
+	path := sink.path(info, record)
+	data, err := sink.encode(record)
+	if err != nil {
+		return fmt.Errorf("Error encoding %s: %s", path, err)
+	}
+	if data, err = compression.Compress(sink.Compression, data); err != nil {
+		return fmt.Errorf("Error compressing %s: %s", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return fmt.Errorf("Error creating directory for %s: %s", path, err)
+	}
+	tempname := path + ".new"
+	if err := ioutil.WriteFile(tempname, data, 0666); err != nil {
+		return fmt.Errorf("Error writing %s: %s", tempname, err)
+	}
+	if err := os.Rename(tempname, path); err != nil {
+		os.Remove(tempname)
+		return fmt.Errorf("Error writing %s: %s", path, err)
+	}
+	return nil
+}
+
+func (sink *DiskSink) Close() error {
+	return nil
+}
@@ -0,0 +1,317 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const (
+	DefaultElasticsearchIndex     = "certspotter"
+	DefaultElasticsearchBatchSize = 500
+)
+
+// The mappings installed in the index template.  Other fields are mapped
+// dynamically.
+var elasticsearchMappings = map[string]interface{}{
+	"properties": map[string]interface{}{
+		"log_uri":         map[string]string{"type": "keyword"},
+		"index":           map[string]string{"type": "long"},
+		"timestamp":       map[string]string{"type": "date"},
+		"is_precert":      map[string]string{"type": "boolean"},
+		"fingerprint":     map[string]string{"type": "keyword"},
+		"leaf_hash":       map[string]interface{}{"type": "keyword", "index": false},
+		"subject":         map[string]interface{}{"type": "text", "fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 1024}}},
+		"subject_cns":     map[string]string{"type": "keyword"},
+		"issuer":          map[string]interface{}{"type": "text", "fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 1024}}},
+		"serial":          map[string]string{"type": "keyword"},
+		"not_before":      map[string]string{"type": "date"},
+		"not_after":       map[string]string{"type": "date"},
+		"is_ca":           map[string]string{"type": "boolean"},
+		"dns_names":       map[string]string{"type": "keyword"},
+		"ip_addresses":    map[string]string{"type": "ip"},
+		"email_addresses": map[string]string{"type": "keyword"},
+		"uris":            map[string]string{"type": "keyword"},
+		"pubkey_hash":     map[string]string{"type": "keyword"},
+		"seen_in_logs":    map[string]string{"type": "keyword"},
+		// Extension values and DNS name anomalies vary too much to map
+		"extensions":         map[string]interface{}{"type": "object", "enabled": false},
+		"dns_name_anomalies": map[string]interface{}{"type": "object", "enabled": false},
+		"matches": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"category":  map[string]string{"type": "keyword"},
+				"id":        map[string]string{"type": "keyword"},
+				"pattern":   map[string]string{"type": "keyword"},
+				"value":     map[string]string{"type": "keyword"},
+				"score":     map[string]string{"type": "float"},
+				"anomalies": map[string]string{"type": "keyword"},
+			},
+		},
+	},
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// ElasticsearchSink indexes entries into Elasticsearch or OpenSearch, as
+// documents like the objects written by JSONLinesSink (without DER), with
+// the certificate's fingerprint as the document ID.
+//
+// Entries are sent with the bulk API in batches of BatchSize, or after
+// FlushInterval if fewer arrive.  Write blocks while a full batch is being
+// sent, so a slow cluster slows down the monitor rather than causing memory
+// to grow.  Requests which fail with a network error, 429, or 5xx status, and
+// individual documents which are rejected with 429, are retried with
+// exponential backoff up to Retries times.
+type ElasticsearchSink struct {
+	Index         string
+	BatchSize     int
+	FlushInterval time.Duration
+	Retries       int
+
+	baseURL    *url.URL // without credentials
+	username   string
+	password   string
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	pending [][]byte // action and document lines, each ending in a newline
+	started bool     // whether flushPeriodically is running
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewElasticsearchSink creates an ElasticsearchSink for the cluster at
+// |clusterURL| (which may include a username and password), and installs an
+// index template for |index| and indices beginning with |index|-
+func NewElasticsearchSink(clusterURL string, index string) (*ElasticsearchSink, error) {
+	baseURL, err := url.Parse(clusterURL)
+	if err != nil {
+		return nil, err
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("%s: not an HTTP or HTTPS URL", clusterURL)
+	}
+	sink := &ElasticsearchSink{
+		Index:         index,
+		BatchSize:     DefaultElasticsearchBatchSize,
+		FlushInterval: 5 * time.Second,
+		Retries:       5,
+		httpClient:    &http.Client{Timeout: 60 * time.Second},
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	if baseURL.User != nil {
+		sink.username = baseURL.User.Username()
+		sink.password, _ = baseURL.User.Password()
+		baseURL.User = nil
+	}
+	baseURL.Path = strings.TrimSuffix(baseURL.Path, "/")
+	sink.baseURL = baseURL
+
+	if err := sink.putIndexTemplate(); err != nil {
+		return nil, fmt.Errorf("Error installing Elasticsearch index template: %s", err)
+	}
+	return sink, nil
+}
+
+func (sink *ElasticsearchSink) do(method string, path string, contentType string, body []byte) (*http.Response, []byte, error) {
+	u := *sink.baseURL
+	u.Path += path
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if sink.username != "" {
+		req.SetBasicAuth(sink.username, sink.password)
+	}
+	resp, err := sink.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, respBody, nil
+}
+
+func (sink *ElasticsearchSink) putIndexTemplate() error {
+	template, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{sink.Index, sink.Index + "-*"},
+		"template":       map[string]interface{}{"mappings": elasticsearchMappings},
+	})
+	if err != nil {
+		return err
+	}
+	resp, body, err := sink.do("PUT", "/_index_template/"+url.QueryEscape(sink.Index), "application/json", template)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func isRetriableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// sendBulk sends |lines| to the bulk API, retrying if necessary
+func (sink *ElasticsearchSink) sendBulk(lines [][]byte) error {
+	var failErr error
+	for attempt := 0; ; attempt++ {
+		var err error
+		lines, err, failErr = sink.tryBulk(lines, failErr)
+		if len(lines) == 0 || attempt >= sink.Retries {
+			if failErr != nil {
+				return failErr
+			}
+			return err
+		}
+		time.Sleep(time.Duration(500<<uint(attempt)) * time.Millisecond)
+	}
+}
+
+// tryBulk sends |lines| to the bulk API once, and returns the lines which
+// should be retried along with the reason, and the first error for
+// documents which can't be retried, starting with |failErr|
+func (sink *ElasticsearchSink) tryBulk(lines [][]byte, failErr error) ([][]byte, error, error) {
+	resp, body, err := sink.do("POST", "/_bulk", "application/x-ndjson", bytes.Join(lines, nil))
+	if err != nil {
+		return lines, err, failErr
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("POST /_bulk: %s: %s", resp.Status, bytes.TrimSpace(body))
+		if isRetriableStatus(resp.StatusCode) {
+			return lines, err, failErr
+		}
+		return nil, nil, err
+	}
+	var bulk bulkResponse
+	if err := json.Unmarshal(body, &bulk); err != nil {
+		return nil, nil, fmt.Errorf("POST /_bulk: Malformed response: %s", err)
+	}
+	if !bulk.Errors {
+		return nil, nil, failErr
+	}
+	var retry [][]byte
+	var failed int
+	var firstError json.RawMessage
+	for i, item := range bulk.Items {
+		for _, result := range item {
+			if result.Status < 300 || 2*i+1 >= len(lines) {
+				continue
+			}
+			if result.Status == http.StatusTooManyRequests {
+				retry = append(retry, lines[2*i], lines[2*i+1])
+			} else if failed++; firstError == nil {
+				firstError = result.Error
+			}
+		}
+	}
+	if failed > 0 && failErr == nil {
+		failErr = fmt.Errorf("%d documents were rejected (first error: %s)", failed, firstError)
+	}
+	if len(retry) > 0 {
+		err = fmt.Errorf("%d documents were rejected because the cluster is overloaded", len(retry)/2)
+	}
+	return retry, err, failErr
+}
+
+// flush sends the pending entries.  sink.mu must be held.
+func (sink *ElasticsearchSink) flush() error {
+	if len(sink.pending) == 0 {
+		return nil
+	}
+	lines := sink.pending
+	sink.pending = nil
+	if err := sink.sendBulk(lines); err != nil {
+		return fmt.Errorf("Error indexing into Elasticsearch: %s", err)
+	}
+	return nil
+}
+
+func (sink *ElasticsearchSink) flushPeriodically() {
+	defer close(sink.stopped)
+	ticker := time.NewTicker(sink.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sink.mu.Lock()
+			err := sink.flush()
+			sink.mu.Unlock()
+			if err != nil {
+				slog.Error(err.Error())
+			}
+		case <-sink.stop:
+			return
+		}
+	}
+}
+
+func (sink *ElasticsearchSink) Write(info *certspotter.EntryInfo) error {
+	line := MakeLine(info, false)
+	document, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("Error encoding entry %d from %s as JSON: %s", info.Entry.Index, info.LogUri, err)
+	}
+	action, err := json.Marshal(map[string]interface{}{
+		"index": map[string]string{"_index": sink.Index, "_id": line.Fingerprint},
+	})
+	if err != nil {
+		return err
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if !sink.started {
+		sink.started = true
+		go sink.flushPeriodically()
+	}
+	sink.pending = append(sink.pending, append(action, '\n'), append(document, '\n'))
+	if len(sink.pending) >= 2*sink.BatchSize {
+		return sink.flush()
+	}
+	return nil
+}
+
+func (sink *ElasticsearchSink) Close() error {
+	sink.mu.Lock()
+	started := sink.started
+	sink.mu.Unlock()
+	if started {
+		close(sink.stop)
+		<-sink.stopped
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.flush()
+}
@@ -0,0 +1,89 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// Line is the JSON object written by JSONLinesSink for each entry
+type Line struct {
+	*certspotter.ParsedEntry
+	Matches         []certspotter.Match           `json:"matches,omitempty"`
+	Violations      []string                      `json:"br_violations,omitempty"`
+	Revocation      *certspotter.RevocationStatus `json:"revocation,omitempty"`
+	ChainValidation *certspotter.ChainValidation  `json:"chain_validation,omitempty"`
+	DNSStatuses     []certspotter.DNSStatus       `json:"dns_statuses,omitempty"`
+	SeenInLogs      []string                      `json:"seen_in_logs,omitempty"`
+	Revoked         bool                          `json:"revoked_follow_up,omitempty"` // reported again because it was revoked (see EntryInfo.IsRevocationFollowUp)
+}
+
+func MakeLine(info *certspotter.EntryInfo, includeDER bool) *Line {
+	line := &Line{
+		ParsedEntry:     info.Parse(),
+		Matches:         info.Matches,
+		Violations:      info.Violations,
+		Revocation:      info.Revocation,
+		ChainValidation: info.ChainValidation,
+		DNSStatuses:     info.DNSStatuses,
+		SeenInLogs:      info.SeenInLogs,
+		Revoked:         info.IsRevocationFollowUp(),
+	}
+	if !includeDER {
+		line.Raw = nil
+		line.Chain = nil
+		line.RawTBS = nil
+	}
+	return line
+}
+
+// JSONLinesSink writes each entry to a file as a single line of JSON (see
+// Line), so the file can be processed with tools like jq
+type JSONLinesSink struct {
+	// If set, the DER-encoded certificate, TBS, and chain are included
+	IncludeDER bool
+
+	out *output
+}
+
+// NewJSONLinesSink creates a JSONLinesSink which appends to the file at
+// |path|, or writes to stdout if |path| is "-"
+func NewJSONLinesSink(path string) (*JSONLinesSink, error) {
+	out, err := openOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesSink{out: out}, nil
+}
+
+func (sink *JSONLinesSink) Write(info *certspotter.EntryInfo) error {
+	data, err := json.Marshal(MakeLine(info, sink.IncludeDER))
+	if err != nil {
+		return fmt.Errorf("Error encoding entry %d from %s as JSON: %s", info.Entry.Index, info.LogUri, err)
+	}
+	err = sink.out.write(func(writer *bufio.Writer) error {
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+		return writer.WriteByte('\n')
+	})
+	if err != nil {
+		return fmt.Errorf("Error writing JSON line: %s", err)
+	}
+	return nil
+}
+
+func (sink *JSONLinesSink) Close() error {
+	return sink.out.Close()
+}
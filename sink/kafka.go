@@ -0,0 +1,64 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/kafka"
+)
+
+// Values for KafkaSink.Key
+const (
+	KafkaKeyFingerprint = "fingerprint" // the certificate's SHA-256 fingerprint, hex
+	KafkaKeyDomain      = "domain"      // the certificate's first DNS name, or its fingerprint if it has none
+)
+
+// KafkaSink publishes each entry to a Kafka topic as a JSON object (see
+// Line)
+type KafkaSink struct {
+	Key        string
+	IncludeDER bool
+
+	producer *kafka.Producer
+}
+
+func NewKafkaSink(config kafka.Config, key string) (*KafkaSink, error) {
+	if key != KafkaKeyFingerprint && key != KafkaKeyDomain {
+		return nil, fmt.Errorf("Invalid Kafka key `%s': must be %s or %s", key, KafkaKeyFingerprint, KafkaKeyDomain)
+	}
+	producer, err := kafka.NewProducer(config)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaSink{Key: key, producer: producer}, nil
+}
+
+func (sink *KafkaSink) Write(info *certspotter.EntryInfo) error {
+	line := MakeLine(info, sink.IncludeDER)
+	value, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("Error encoding entry %d from %s as JSON: %s", info.Entry.Index, info.LogUri, err)
+	}
+	key := line.Fingerprint
+	if sink.Key == KafkaKeyDomain && len(line.DNSNames) != 0 {
+		key = line.DNSNames[0]
+	}
+	if err := sink.producer.Produce(kafka.Message{Key: []byte(key), Value: value}); err != nil {
+		return fmt.Errorf("Error publishing entry %d from %s to Kafka: %s", info.Entry.Index, info.LogUri, err)
+	}
+	return nil
+}
+
+func (sink *KafkaSink) Close() error {
+	return sink.producer.Close()
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/nats"
+)
+
+const DefaultNATSSubject = "certspotter.{reversed_domain}"
+
+// natsToken makes |s| safe to use in a subject, where wildcards and
+// whitespace aren't allowed
+var natsToken = strings.NewReplacer("*", "_", ">", "_", " ", "_", "\t", "_", "\r", "_", "\n", "_")
+
+func reverseDomain(domain string) string {
+	labels := strings.Split(domain, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".")
+}
+
+// NATSSink publishes each entry to NATS as a JSON object (see Line).  The
+// subject is made from a template in which these placeholders are replaced:
+//
+//	{domain}		a DNS name, e.g. www.example.com
+//	{reversed_domain}	a DNS name reversed, e.g. com.example.www, so
+//				that subscribers can use wildcards such as
+//				certspotter.com.example.>
+//	{fingerprint}		the SHA-256 fingerprint, hex
+//	{type}			cert or precert
+//
+// If the template contains a domain placeholder, the entry is published
+// once for each of its DNS names, so that subscribers to any of them receive
+// it.  Wildcards in DNS names are replaced by _.
+type NATSSink struct {
+	Subject    string
+	JetStream  bool // if set, wait for a JetStream stream to acknowledge each message
+	IncludeDER bool
+
+	conn *nats.Conn
+}
+
+func NewNATSSink(serverURL string, subject string) (*NATSSink, error) {
+	conn, err := nats.Dial(serverURL, "certspotter", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &NATSSink{Subject: subject, conn: conn}, nil
+}
+
+func (sink *NATSSink) subjects(entry *certspotter.ParsedEntry) []string {
+	entryType := "cert"
+	if entry.IsPrecert {
+		entryType = "precert"
+	}
+	subject := strings.NewReplacer("{fingerprint}", entry.Fingerprint, "{type}", entryType).Replace(sink.Subject)
+	if !strings.Contains(subject, "{domain}") && !strings.Contains(subject, "{reversed_domain}") {
+		return []string{subject}
+	}
+	dnsNames := entry.DNSNames
+	if len(dnsNames) == 0 {
+		dnsNames = []string{"_"}
+	}
+	var subjects []string
+	seen := make(map[string]bool)
+	for _, dnsName := range dnsNames {
+		dnsName = natsToken.Replace(dnsName)
+		s := strings.NewReplacer("{domain}", dnsName, "{reversed_domain}", reverseDomain(dnsName)).Replace(subject)
+		if !seen[s] {
+			seen[s] = true
+			subjects = append(subjects, s)
+		}
+	}
+	return subjects
+}
+
+func (sink *NATSSink) Write(info *certspotter.EntryInfo) error {
+	line := MakeLine(info, sink.IncludeDER)
+	data, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("Error encoding entry %d from %s as JSON: %s", info.Entry.Index, info.LogUri, err)
+	}
+	for _, subject := range sink.subjects(line.ParsedEntry) {
+		if sink.JetStream {
+			_, err = sink.conn.PublishJetStream(subject, data)
+		} else {
+			err = sink.conn.Publish(subject, data)
+		}
+		if err != nil {
+			return fmt.Errorf("Error publishing entry %d from %s to NATS subject %s: %s", info.Entry.Index, info.LogUri, subject, err)
+		}
+	}
+	return nil
+}
+
+func (sink *NATSSink) Close() error {
+	return sink.conn.Close()
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// output is a file (or stdout) to which a sink appends entries.  Writes are
+// buffered, and flushed after every entry so that the file is always
+// complete.  It is safe for concurrent use.
+type output struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	empty  bool // nothing has been written to the file yet
+}
+
+// openOutput opens |path| for appending, creating it if necessary, or uses
+// stdout if |path| is "-"
+func openOutput(path string) (*output, error) {
+	if path == "-" {
+		return &output{file: os.Stdout, writer: bufio.NewWriter(os.Stdout), empty: true}, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &output{file: file, writer: bufio.NewWriter(file), empty: info.Size() == 0}, nil
+}
+
+// IsStdout returns true if |path|, as passed to a sink constructor, refers
+// to stdout
+func IsStdout(path string) bool {
+	return path == "-"
+}
+
+// write calls |encode| to write an entry, and then flushes it
+func (out *output) write(encode func(*bufio.Writer) error) error {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if err := encode(out.writer); err != nil {
+		return err
+	}
+	out.empty = false
+	return out.writer.Flush()
+}
+
+func (out *output) Close() error {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	err := out.writer.Flush()
+	if out.file != os.Stdout {
+		if closeErr := out.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,401 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const DefaultParquetRowGroupSize = 10000
+
+// Parquet physical types, repetition types, converted types, and encodings
+// (see parquet.thrift in the Parquet format specification)
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+	parquetRepeated = 2
+
+	parquetNoConversion    = -1
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+var parquetMagic = []byte("PAR1")
+
+// A parquetColumn accumulates the values of one column for a row group
+type parquetColumn struct {
+	name       string
+	physical   int32
+	converted  int32
+	repetition int32
+
+	values    bytes.Buffer // PLAIN-encoded
+	defLevels []byte
+	repLevels []byte
+}
+
+func (column *parquetColumn) addValue(value interface{}) {
+	switch value := value.(type) {
+	case int64:
+		binary.Write(&column.values, binary.LittleEndian, value)
+	case string:
+		binary.Write(&column.values, binary.LittleEndian, uint32(len(value)))
+		column.values.WriteString(value)
+	}
+}
+
+// add appends a value to a required column, or to an optional column, in
+// which case nil means null
+func (column *parquetColumn) add(value interface{}) {
+	if column.repetition == parquetOptional {
+		if value == nil {
+			column.defLevels = append(column.defLevels, 0)
+			return
+		}
+		column.defLevels = append(column.defLevels, 1)
+	}
+	column.addValue(value)
+}
+
+// addList appends a list of values to a repeated column
+func (column *parquetColumn) addList(values []string) {
+	if len(values) == 0 {
+		column.defLevels = append(column.defLevels, 0)
+		column.repLevels = append(column.repLevels, 0)
+		return
+	}
+	for i, value := range values {
+		column.defLevels = append(column.defLevels, 1)
+		if i == 0 {
+			column.repLevels = append(column.repLevels, 0)
+		} else {
+			column.repLevels = append(column.repLevels, 1)
+		}
+		column.addValue(value)
+	}
+}
+
+func (column *parquetColumn) reset() {
+	column.values.Reset()
+	column.defLevels = column.defLevels[:0]
+	column.repLevels = column.repLevels[:0]
+}
+
+// encodeLevels encodes levels (all 0 or 1) with the RLE/bit-packing hybrid
+// encoding, using only RLE runs, prefixed by the length
+func encodeLevels(buf *bytes.Buffer, levels []byte) {
+	var runs bytes.Buffer
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		writeUvarint(&runs, uint64(j-i)<<1)
+		runs.WriteByte(levels[i])
+		i = j
+	}
+	binary.Write(buf, binary.LittleEndian, uint32(runs.Len()))
+	buf.Write(runs.Bytes())
+}
+
+// The columns of a ParquetSink's files, in order.  Columns may be added to
+// the end in the future, but won't be removed or changed.
+func newParquetColumns() []*parquetColumn {
+	return []*parquetColumn{
+		{name: "fingerprint", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetRequired},
+		{name: "type", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetRequired},
+		{name: "log", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetRequired},
+		{name: "index", physical: parquetInt64, converted: parquetNoConversion, repetition: parquetRequired},
+		{name: "timestamp", physical: parquetInt64, converted: parquetTimestampMillis, repetition: parquetRequired},
+		{name: "dns_names", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetRepeated},
+		{name: "ip_addresses", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetRepeated},
+		{name: "email_addresses", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetRepeated},
+		{name: "uris", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetRepeated},
+		{name: "subject", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetOptional},
+		{name: "issuer", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetOptional},
+		{name: "serial", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetOptional},
+		{name: "not_before", physical: parquetInt64, converted: parquetTimestampMillis, repetition: parquetOptional},
+		{name: "not_after", physical: parquetInt64, converted: parquetTimestampMillis, repetition: parquetOptional},
+		{name: "pubkey_hash", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetOptional},
+		{name: "matches", physical: parquetByteArray, converted: parquetUTF8, repetition: parquetRepeated},
+	}
+}
+
+func parquetString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func parquetTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+type parquetColumnChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+	hasLevels bool
+}
+
+type parquetRowGroup struct {
+	columns []parquetColumnChunk
+	size    int64
+	numRows int64
+}
+
+// ParquetSink writes entries to Parquet files in a directory, so that they
+// can be queried by Spark, DuckDB, Athena, etc. as a single dataset.  Each
+// ParquetSink creates a new file, named after the time it was created, when
+// the first entry is written.
+//
+// Entries are buffered in memory and written as a row group once there are
+// RowGroupSize of them, or when the sink is closed.  The file's footer is
+// rewritten after every row group, so the file is always readable up to the
+// last row group written, even if the process doesn't exit cleanly.
+type ParquetSink struct {
+	Dir          string
+	RowGroupSize int
+
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	dataEnd   int64 // where the next row group goes, overwriting the footer
+	columns   []*parquetColumn
+	numRows   int64 // in the buffered row group
+	rowGroups []parquetRowGroup
+}
+
+func NewParquetSink(dir string) (*ParquetSink, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("certspotter-%s-%d.parquet", time.Now().UTC().Format("20060102T150405Z"), os.Getpid())
+	return &ParquetSink{
+		Dir:          dir,
+		RowGroupSize: DefaultParquetRowGroupSize,
+		path:         filepath.Join(dir, name),
+		columns:      newParquetColumns(),
+	}, nil
+}
+
+func (sink *ParquetSink) Write(info *certspotter.EntryInfo) error {
+	entry := info.Parse()
+	entryType := "cert"
+	if entry.IsPrecert {
+		entryType = "precert"
+	}
+	ipAddresses := make([]string, len(entry.IPAddresses))
+	for i, addr := range entry.IPAddresses {
+		ipAddresses[i] = addr.String()
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	c := sink.columns
+	c[0].add(entry.Fingerprint)
+	c[1].add(entryType)
+	c[2].add(entry.LogURI)
+	c[3].add(entry.Index)
+	c[4].add(parquetTime(&entry.Timestamp))
+	c[5].addList(entry.DNSNames)
+	c[6].addList(ipAddresses)
+	c[7].addList(entry.EmailAddresses)
+	c[8].addList(entry.URIs)
+	c[9].add(parquetString(entry.Subject))
+	c[10].add(parquetString(entry.Issuer))
+	c[11].add(parquetString(entry.Serial))
+	c[12].add(parquetTime(entry.NotBefore))
+	c[13].add(parquetTime(entry.NotAfter))
+	c[14].add(parquetString(entry.PubkeyHash))
+	c[15].addList(info.MatchIDs())
+	sink.numRows++
+
+	if sink.numRows >= int64(sink.RowGroupSize) {
+		if err := sink.flush(); err != nil {
+			return fmt.Errorf("Error writing Parquet file %s: %s", sink.path, err)
+		}
+	}
+	return nil
+}
+
+// flush writes the buffered entries as a row group, followed by the footer
+func (sink *ParquetSink) flush() error {
+	if sink.numRows == 0 {
+		return nil
+	}
+	if sink.file == nil {
+		file, err := os.OpenFile(sink.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(parquetMagic); err != nil {
+			file.Close()
+			return err
+		}
+		sink.file = file
+		sink.dataEnd = int64(len(parquetMagic))
+	}
+
+	var buf bytes.Buffer
+	rowGroup := parquetRowGroup{numRows: sink.numRows}
+	for _, column := range sink.columns {
+		var page bytes.Buffer
+		numValues := len(column.defLevels)
+		if column.repetition == parquetRepeated {
+			encodeLevels(&page, column.repLevels)
+		}
+		if column.repetition != parquetRequired {
+			encodeLevels(&page, column.defLevels)
+		} else {
+			numValues = int(sink.numRows)
+		}
+		page.Write(column.values.Bytes())
+
+		chunk := parquetColumnChunk{
+			offset:    sink.dataEnd + int64(buf.Len()),
+			numValues: int64(numValues),
+			hasLevels: column.repetition != parquetRequired,
+		}
+		writePageHeader(&buf, page.Len(), numValues)
+		buf.Write(page.Bytes())
+		chunk.size = sink.dataEnd + int64(buf.Len()) - chunk.offset
+		rowGroup.size += chunk.size
+		rowGroup.columns = append(rowGroup.columns, chunk)
+		column.reset()
+	}
+	sink.numRows = 0
+
+	if _, err := sink.file.WriteAt(buf.Bytes(), sink.dataEnd); err != nil {
+		return err
+	}
+	sink.dataEnd += int64(buf.Len())
+	sink.rowGroups = append(sink.rowGroups, rowGroup)
+
+	footer := sink.encodeFooter()
+	binary.Write(footer, binary.LittleEndian, uint32(footer.Len()))
+	footer.Write(parquetMagic)
+	_, err := sink.file.WriteAt(footer.Bytes(), sink.dataEnd)
+	return err
+}
+
+func writePageHeader(buf *bytes.Buffer, pageSize int, numValues int) {
+	w := newThriftWriter(buf)
+	w.i32(1, 0) // type: DATA_PAGE
+	w.i32(2, int32(pageSize))
+	w.i32(3, int32(pageSize)) // uncompressed
+	w.beginStruct(5)          // data_page_header
+	w.i32(1, int32(numValues))
+	w.i32(2, parquetPlain)
+	w.i32(3, parquetRLE)
+	w.i32(4, parquetRLE)
+	w.endStruct()
+	w.endStruct()
+}
+
+// encodeFooter encodes the FileMetaData
+func (sink *ParquetSink) encodeFooter() *bytes.Buffer {
+	var buf bytes.Buffer
+	w := newThriftWriter(&buf)
+	w.i32(1, 1) // version
+
+	w.beginList(2, thriftStruct, len(sink.columns)+1)
+	w.beginElement()
+	w.binary(4, "certspotter")
+	w.i32(5, int32(len(sink.columns)))
+	w.endStruct()
+	for _, column := range sink.columns {
+		w.beginElement()
+		w.i32(1, column.physical)
+		w.i32(3, column.repetition)
+		w.binary(4, column.name)
+		if column.converted != parquetNoConversion {
+			w.i32(6, column.converted)
+		}
+		w.endStruct()
+	}
+
+	var numRows int64
+	for _, rowGroup := range sink.rowGroups {
+		numRows += rowGroup.numRows
+	}
+	w.i64(3, numRows)
+
+	w.beginList(4, thriftStruct, len(sink.rowGroups))
+	for _, rowGroup := range sink.rowGroups {
+		w.beginElement()
+		w.beginList(1, thriftStruct, len(rowGroup.columns))
+		for i, chunk := range rowGroup.columns {
+			column := sink.columns[i]
+			w.beginElement()
+			w.i64(2, chunk.offset)
+			w.beginStruct(3) // meta_data
+			w.i32(1, column.physical)
+			if chunk.hasLevels {
+				w.beginList(2, thriftI32, 2)
+				w.element32(parquetPlain)
+				w.element32(parquetRLE)
+			} else {
+				w.beginList(2, thriftI32, 1)
+				w.element32(parquetPlain)
+			}
+			w.beginList(3, thriftBinary, 1)
+			w.elementBinary(column.name)
+			w.i32(4, 0) // codec: UNCOMPRESSED
+			w.i64(5, chunk.numValues)
+			w.i64(6, chunk.size)
+			w.i64(7, chunk.size)
+			w.i64(9, chunk.offset)
+			w.endStruct()
+			w.endStruct()
+		}
+		w.i64(2, rowGroup.size)
+		w.i64(3, rowGroup.numRows)
+		w.endStruct()
+	}
+
+	w.binary(6, "certspotter")
+	w.endStruct()
+	return &buf
+}
+
+func (sink *ParquetSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	err := sink.flush()
+	if sink.file != nil {
+		if closeErr := sink.file.Close(); err == nil {
+			err = closeErr
+		}
+		sink.file = nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing Parquet file %s: %s", sink.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+
+	"software.sslmate.com/src/certspotter/internal/certtest"
+)
+
+func TestEncodeLevels(t *testing.T) {
+	tests := []struct {
+		levels   []byte
+		expected []byte
+	}{
+		{[]byte{}, []byte{0, 0, 0, 0}},
+		{[]byte{1}, []byte{2, 0, 0, 0, 0x02, 1}},
+		{[]byte{1, 1, 0, 1}, []byte{6, 0, 0, 0, 0x04, 1, 0x02, 0, 0x02, 1}},
+		{bytes.Repeat([]byte{0}, 100), []byte{3, 0, 0, 0, 0xc8, 0x01, 0}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		encodeLevels(&buf, test.levels)
+		if !bytes.Equal(buf.Bytes(), test.expected) {
+			t.Errorf("encodeLevels(%v) = %x, expected %x", test.levels, buf.Bytes(), test.expected)
+		}
+	}
+}
+
+// readParquetFooter checks the magic numbers of the Parquet file |data| and
+// decodes its FileMetaData
+func readParquetFooter(t *testing.T, data []byte) map[int16]interface{} {
+	if len(data) < 12 || !bytes.HasPrefix(data, parquetMagic) || !bytes.HasSuffix(data, parquetMagic) {
+		t.Fatalf("File doesn't begin and end with %q", parquetMagic)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLen > len(data)-12 {
+		t.Fatalf("Footer length %d is too long", footerLen)
+	}
+	footer, rest, err := decodeThriftStruct(data[len(data)-8-footerLen : len(data)-8])
+	if err != nil {
+		t.Fatalf("Error decoding footer: %s", err)
+	}
+	if len(rest) != 0 {
+		t.Fatalf("%d bytes after footer", len(rest))
+	}
+	return footer
+}
+
+func TestParquetSink(t *testing.T) {
+	sink, err := NewParquetSink(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.RowGroupSize = 2
+	infos := []struct {
+		dnsNames    []string
+		ipAddresses []net.IP
+	}{
+		{[]string{"a.example.com", "b.example.com"}, nil},
+		{nil, []net.IP{net.ParseIP("192.0.2.1")}},
+		{[]string{"c.example.com"}, nil},
+	}
+	for _, i := range infos {
+		if err := sink.Write(certtest.EntryInfo(t, i.dnsNames, i.ipAddresses)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first row group has been written, so the file must be readable
+	data, err := os.ReadFile(sink.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numRows := readParquetFooter(t, data)[3]; numRows != int64(2) {
+		t.Errorf("Before close, num_rows is %v, expected 2", numRows)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(sink.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer := readParquetFooter(t, data)
+	if footer[1] != int64(1) {
+		t.Errorf("version is %v, expected 1", footer[1])
+	}
+	if footer[3] != int64(3) {
+		t.Errorf("num_rows is %v, expected 3", footer[3])
+	}
+
+	columns := newParquetColumns()
+	schema := footer[2].([]interface{})
+	if len(schema) != len(columns)+1 {
+		t.Fatalf("Schema has %d elements, expected %d", len(schema), len(columns)+1)
+	}
+	if root := schema[0].(map[int16]interface{}); root[5] != int64(len(columns)) {
+		t.Errorf("Root has %v children, expected %d", root[5], len(columns))
+	}
+	for i, column := range columns {
+		element := schema[i+1].(map[int16]interface{})
+		if element[4] != column.name || element[1] != int64(column.physical) || element[3] != int64(column.repetition) {
+			t.Errorf("Schema element %d is %v, expected column %s", i+1, element, column.name)
+		}
+		if _, hasConverted := element[6]; hasConverted != (column.converted != parquetNoConversion) {
+			t.Errorf("Schema element %s has wrong converted type %v", column.name, element[6])
+		}
+	}
+
+	rowGroups := footer[4].([]interface{})
+	if len(rowGroups) != 2 {
+		t.Fatalf("File has %d row groups, expected 2", len(rowGroups))
+	}
+	expectedEnd := int64(len(parquetMagic))
+	for i, expectedRows := range []int64{2, 1} {
+		rowGroup := rowGroups[i].(map[int16]interface{})
+		if rowGroup[3] != expectedRows {
+			t.Errorf("Row group %d has %v rows, expected %d", i, rowGroup[3], expectedRows)
+		}
+		chunks := rowGroup[1].([]interface{})
+		if len(chunks) != len(columns) {
+			t.Fatalf("Row group %d has %d columns, expected %d", i, len(chunks), len(columns))
+		}
+		var totalSize int64
+		for j, c := range chunks {
+			chunk := c.(map[int16]interface{})
+			meta := chunk[3].(map[int16]interface{})
+			offset, size := meta[9].(int64), meta[6].(int64)
+			if chunk[2] != offset {
+				t.Errorf("Column %s of row group %d: file_offset %v differs from data_page_offset %d", columns[j].name, i, chunk[2], offset)
+			}
+			if offset != expectedEnd {
+				t.Errorf("Column %s of row group %d begins at %d, expected %d", columns[j].name, i, offset, expectedEnd)
+			}
+			if path := meta[3].([]interface{}); len(path) != 1 || path[0] != columns[j].name {
+				t.Errorf("Column %d of row group %d has path %v", j, i, path)
+			}
+			expectedEnd = offset + size
+			totalSize += size
+
+			header, page, err := decodeThriftStruct(data[offset : offset+size])
+			if err != nil {
+				t.Fatalf("Error decoding page header of column %s: %s", columns[j].name, err)
+			}
+			if header[2] != int64(len(page)) || header[3] != int64(len(page)) {
+				t.Errorf("Page of column %s is %d bytes, but header says %v/%v", columns[j].name, len(page), header[2], header[3])
+			}
+			if numValues := header[5].(map[int16]interface{})[1]; numValues != meta[5] {
+				t.Errorf("Page of column %s has %v values, but chunk has %v", columns[j].name, numValues, meta[5])
+			}
+			if columns[j].name == "index" {
+				if len(page) != 8*int(expectedRows) {
+					t.Fatalf("index column is %d bytes", len(page))
+				}
+				for k := 0; k < int(expectedRows); k++ {
+					if index := binary.LittleEndian.Uint64(page[8*k:]); index != 7 {
+						t.Errorf("index of row %d is %d, expected 7", k, index)
+					}
+				}
+			}
+		}
+		if rowGroup[2] != totalSize {
+			t.Errorf("Row group %d has total_byte_size %v, expected %d", i, rowGroup[2], totalSize)
+		}
+	}
+	if footerStart := int64(len(data)) - 8 - int64(binary.LittleEndian.Uint32(data[len(data)-8:])); footerStart != expectedEnd {
+		t.Errorf("Footer begins at %d, expected %d", footerStart, expectedEnd)
+	}
+
+	// dns_names of the first row group: two names, then none
+	chunk := rowGroups[0].(map[int16]interface{})[1].([]interface{})[5].(map[int16]interface{})
+	meta := chunk[3].(map[int16]interface{})
+	offset, size := meta[9].(int64), meta[6].(int64)
+	_, page, _ := decodeThriftStruct(data[offset : offset+size])
+	expectedPage := []byte{
+		6, 0, 0, 0, 0x02, 0, 0x02, 1, 0x02, 0, // repetition levels: 0, 1, 0
+		4, 0, 0, 0, 0x04, 1, 0x02, 0, // definition levels: 1, 1, 0
+		13, 0, 0, 0, 'a', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm',
+		13, 0, 0, 0, 'b', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm',
+	}
+	if !bytes.Equal(page, expectedPage) {
+		t.Errorf("dns_names page is %x, expected %x", page, expectedPage)
+	}
+	if meta[5] != int64(3) {
+		t.Errorf("dns_names has %v values, expected 3", meta[5])
+	}
+}
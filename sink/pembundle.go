@@ -0,0 +1,93 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// PEMBundleSink appends each certificate to a single file as a PEM block,
+// preceded by comment lines identifying where it was found.  Tools such as
+// openssl ignore text outside of PEM blocks, so the file can be used with
+// them directly.
+type PEMBundleSink struct {
+	// If set, the issuer chain is included after each certificate
+	IncludeChain bool
+
+	out *output
+}
+
+// NewPEMBundleSink creates a PEMBundleSink which appends to the file at
+// |path|, or writes to stdout if |path| is "-"
+func NewPEMBundleSink(path string) (*PEMBundleSink, error) {
+	out, err := openOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	return &PEMBundleSink{out: out}, nil
+}
+
+func (sink *PEMBundleSink) Write(info *certspotter.EntryInfo) error {
+	if len(info.FullChain) == 0 {
+		return fmt.Errorf("Cannot save an empty certificate chain")
+	}
+	certs := info.FullChain[:1]
+	if sink.IncludeChain {
+		certs = info.FullChain
+	}
+	var header []string
+	header = append(header, "# Fingerprint: "+info.Fingerprint())
+	if info.IsPrecert {
+		header = append(header, "# Type: precert")
+	} else {
+		header = append(header, "# Type: cert")
+	}
+	header = append(header, fmt.Sprintf("# Log Entry: %d @ %s", info.Entry.Index, info.LogUri))
+	if info.Identifiers != nil && len(info.Identifiers.DNSNames) != 0 {
+		header = append(header, "# DNS Names: "+strings.Join(info.Identifiers.DNSNames, ", "))
+	}
+	err := sink.out.write(func(writer *bufio.Writer) error {
+		for _, line := range header {
+			if _, err := fmt.Fprintln(writer, sanitizeComment(line)); err != nil {
+				return err
+			}
+		}
+		for _, cert := range certs {
+			if err := pem.Encode(writer, &pem.Block{Type: "CERTIFICATE", Bytes: cert}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error writing PEM bundle: %s", err)
+	}
+	return nil
+}
+
+// sanitizeComment prevents values from the certificate from breaking out of
+// a comment line by containing a newline or other control characters
+func sanitizeComment(line string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return '?'
+		}
+		return r
+	}, line)
+}
+
+func (sink *PEMBundleSink) Close() error {
+	return sink.out.Close()
+}
@@ -0,0 +1,196 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// Protocol buffer wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+// protoMessage encodes a protocol buffer message.  Fields with default
+// values (0, false, "") are omitted, as in proto3, except by the optional*
+// methods.
+type protoMessage struct {
+	bytes.Buffer
+}
+
+func (m *protoMessage) tag(field int, wireType int) {
+	writeUvarint(&m.Buffer, uint64(field)<<3|uint64(wireType))
+}
+
+func (m *protoMessage) optionalInt64(field int, value int64) {
+	m.tag(field, protoVarint)
+	writeUvarint(&m.Buffer, uint64(value))
+}
+
+func (m *protoMessage) int64(field int, value int64) {
+	if value != 0 {
+		m.optionalInt64(field, value)
+	}
+}
+
+func (m *protoMessage) optionalBool(field int, value bool) {
+	m.tag(field, protoVarint)
+	if value {
+		m.WriteByte(1)
+	} else {
+		m.WriteByte(0)
+	}
+}
+
+func (m *protoMessage) bool(field int, value bool) {
+	if value {
+		m.optionalBool(field, value)
+	}
+}
+
+func (m *protoMessage) double(field int, value float64) {
+	if value != 0 {
+		m.tag(field, protoFixed64)
+		binary.Write(&m.Buffer, binary.LittleEndian, math.Float64bits(value))
+	}
+}
+
+// repeatedBytes adds |value| to a repeated bytes, string, or message field;
+// unlike bytes, the value is added even if it's empty
+func (m *protoMessage) repeatedBytes(field int, value []byte) {
+	m.tag(field, protoBytes)
+	writeUvarint(&m.Buffer, uint64(len(value)))
+	m.Write(value)
+}
+
+func (m *protoMessage) bytes(field int, value []byte) {
+	if len(value) != 0 {
+		m.repeatedBytes(field, value)
+	}
+}
+
+func (m *protoMessage) string(field int, value string) {
+	m.bytes(field, []byte(value))
+}
+
+func (m *protoMessage) repeatedString(field int, values []string) {
+	for _, value := range values {
+		m.repeatedBytes(field, []byte(value))
+	}
+}
+
+func protoMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// MarshalProtobuf encodes the Entry message (see entry.proto) for |info|
+func MarshalProtobuf(info *certspotter.EntryInfo, includeDER bool) []byte {
+	entry := info.Parse()
+	var m protoMessage
+	m.string(1, entry.LogURI)
+	m.int64(2, entry.Index)
+	m.int64(3, protoMillis(entry.Timestamp))
+	m.bool(4, entry.IsPrecert)
+	m.string(5, entry.Fingerprint)
+	m.bytes(6, entry.LeafHash)
+	if includeDER {
+		m.bytes(7, entry.Raw)
+		for _, cert := range entry.Chain {
+			m.repeatedBytes(8, cert)
+		}
+		m.bytes(9, entry.RawTBS)
+	}
+	m.string(10, entry.Subject)
+	m.repeatedString(11, entry.SubjectCNs)
+	m.string(12, entry.Issuer)
+	m.string(13, entry.Serial)
+	if entry.NotBefore != nil {
+		m.optionalInt64(14, protoMillis(*entry.NotBefore))
+	}
+	if entry.NotAfter != nil {
+		m.optionalInt64(15, protoMillis(*entry.NotAfter))
+	}
+	if entry.IsCA != nil {
+		m.optionalBool(16, *entry.IsCA)
+	}
+	m.repeatedString(17, entry.DNSNames)
+	for _, addr := range entry.IPAddresses {
+		m.repeatedBytes(18, []byte(addr.String()))
+	}
+	m.repeatedString(19, entry.EmailAddresses)
+	m.repeatedString(20, entry.URIs)
+	m.string(21, entry.PubkeyHash)
+	for _, parseError := range entry.ParseErrors {
+		var sub protoMessage
+		sub.string(1, parseError.Field)
+		sub.string(2, parseError.Error)
+		m.repeatedBytes(22, sub.Bytes())
+	}
+	for _, match := range info.Matches {
+		var sub protoMessage
+		sub.string(1, match.Category)
+		sub.string(2, match.ID)
+		sub.string(3, match.Pattern)
+		sub.string(4, match.Value)
+		sub.double(5, match.Score)
+		sub.repeatedString(6, match.Anomalies)
+		m.repeatedBytes(23, sub.Bytes())
+	}
+	m.repeatedString(24, info.SeenInLogs)
+	return m.Bytes()
+}
+
+// ProtobufSink writes each entry to a file as an Entry protocol buffer
+// message (see entry.proto), preceded by its length as a varint
+type ProtobufSink struct {
+	// If set, the DER-encoded certificate, TBS, and chain are included
+	IncludeDER bool
+
+	out *output
+}
+
+// NewProtobufSink creates a ProtobufSink which appends to the file at
+// |path|, or writes to stdout if |path| is "-"
+func NewProtobufSink(path string) (*ProtobufSink, error) {
+	out, err := openOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ProtobufSink{out: out}, nil
+}
+
+func (sink *ProtobufSink) Write(info *certspotter.EntryInfo) error {
+	data := MarshalProtobuf(info, sink.IncludeDER)
+	err := sink.out.write(func(writer *bufio.Writer) error {
+		var length [binary.MaxVarintLen64]byte
+		if _, err := writer.Write(length[:binary.PutUvarint(length[:], uint64(len(data)))]); err != nil {
+			return err
+		}
+		_, err := writer.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error writing protobuf message: %s", err)
+	}
+	return nil
+}
+
+func (sink *ProtobufSink) Close() error {
+	return sink.out.Close()
+}
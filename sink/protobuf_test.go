@@ -0,0 +1,222 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/internal/certtest"
+)
+
+func TestProtoMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    func(m *protoMessage)
+		expected []byte
+	}{
+		{"int64", func(m *protoMessage) { m.int64(2, 150) }, []byte{0x10, 0x96, 0x01}},
+		{"zero int64", func(m *protoMessage) { m.int64(2, 0) }, []byte{}},
+		{"optional zero int64", func(m *protoMessage) { m.optionalInt64(14, 0) }, []byte{0x70, 0x00}},
+		{"negative int64", func(m *protoMessage) { m.int64(1, -1) }, []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"bool", func(m *protoMessage) { m.bool(4, true) }, []byte{0x20, 0x01}},
+		{"false bool", func(m *protoMessage) { m.bool(4, false) }, []byte{}},
+		{"optional false bool", func(m *protoMessage) { m.optionalBool(16, false) }, []byte{0x80, 0x01, 0x00}},
+		{"double", func(m *protoMessage) { m.double(5, 0.5) }, []byte{0x29, 0, 0, 0, 0, 0, 0, 0xe0, 0x3f}},
+		{"string", func(m *protoMessage) { m.string(1, "testing") }, []byte{0x0a, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}},
+		{"empty string", func(m *protoMessage) { m.string(1, "") }, []byte{}},
+		{"repeated string", func(m *protoMessage) { m.repeatedString(17, []string{"a", ""}) }, []byte{0x8a, 0x01, 0x01, 'a', 0x8a, 0x01, 0x00}},
+	}
+	for _, test := range tests {
+		var m protoMessage
+		test.write(&m)
+		if !bytes.Equal(m.Bytes(), test.expected) {
+			t.Errorf("%s: encoded as %x, expected %x", test.name, m.Bytes(), test.expected)
+		}
+	}
+}
+
+type protoField struct {
+	number   int
+	wireType int
+	value    interface{} // uint64 or []byte
+}
+
+// decodeProto splits a protocol buffer message into its fields
+func decodeProto(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid tag")
+		}
+		data = data[n:]
+		field := protoField{number: int(tag >> 3), wireType: int(tag & 7)}
+		switch field.wireType {
+		case protoVarint:
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint in field %d", field.number)
+			}
+			field.value = value
+			data = data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("truncated field %d", field.number)
+			}
+			field.value = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case protoBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return nil, fmt.Errorf("invalid length of field %d", field.number)
+			}
+			field.value = data[n : n+int(length)]
+			data = data[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unexpected wire type %d in field %d", field.wireType, field.number)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func TestMarshalProtobuf(t *testing.T) {
+	info := certtest.EntryInfo(t, []string{"a.example.com", "b.example.com"}, nil)
+	info.Matches = []certspotter.Match{{Category: "domain", ID: "item1", Score: 0.5}}
+	info.SeenInLogs = []string{"https://ct.example.com/"}
+	entry := info.Parse()
+
+	fields, err := decodeProto(MarshalProtobuf(info, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numbers []int
+	byNumber := make(map[int][]protoField)
+	for _, field := range fields {
+		numbers = append(numbers, field.number)
+		byNumber[field.number] = append(byNumber[field.number], field)
+	}
+	// is_precert (4) is false, DER fields (7-9) weren't requested, and the
+	// certificate has no basic constraints, so is_ca (16) is absent
+	expectedNumbers := []int{1, 2, 3, 5, 6, 10, 11, 12, 13, 14, 15, 17, 17, 21, 23, 24}
+	if fmt.Sprint(numbers) != fmt.Sprint(expectedNumbers) {
+		t.Fatalf("Message has fields %v, expected %v", numbers, expectedNumbers)
+	}
+
+	expectedTypes := map[int]int{1: protoBytes, 2: protoVarint, 3: protoVarint, 5: protoBytes, 6: protoBytes, 10: protoBytes, 11: protoBytes, 12: protoBytes, 13: protoBytes, 14: protoVarint, 15: protoVarint, 17: protoBytes, 21: protoBytes, 23: protoBytes, 24: protoBytes}
+	for _, field := range fields {
+		if field.wireType != expectedTypes[field.number] {
+			t.Errorf("Field %d has wire type %d, expected %d", field.number, field.wireType, expectedTypes[field.number])
+		}
+	}
+
+	expectedValues := map[int]interface{}{
+		1:  "https://ct.example.com/",
+		2:  uint64(7),
+		3:  uint64(1704067500000), // 2024-01-01 00:05:00
+		5:  entry.Fingerprint,
+		11: "Test CA",
+		12: entry.Issuer,
+		13: "2a",
+		14: uint64(1704067200000), // 2024-01-01
+		15: uint64(1711929600000), // 2024-04-01
+		21: entry.PubkeyHash,
+		24: "https://ct.example.com/",
+	}
+	for number, expected := range expectedValues {
+		value := byNumber[number][0].value
+		if b, isBytes := value.([]byte); isBytes {
+			value = string(b)
+		}
+		if value != expected {
+			t.Errorf("Field %d is %v, expected %v", number, value, expected)
+		}
+	}
+	if value := byNumber[6][0].value.([]byte); !bytes.Equal(value, entry.LeafHash) {
+		t.Errorf("leaf_hash is %x, expected %x", value, entry.LeafHash)
+	}
+	if a, b := string(byNumber[17][0].value.([]byte)), string(byNumber[17][1].value.([]byte)); a != "a.example.com" || b != "b.example.com" {
+		t.Errorf("dns_names are %q, %q", a, b)
+	}
+
+	match, err := decodeProto(byNumber[23][0].value.([]byte))
+	if err != nil {
+		t.Fatalf("Error decoding Match: %s", err)
+	}
+	expectedMatch := []protoField{
+		{1, protoBytes, []byte("domain")},
+		{2, protoBytes, []byte("item1")},
+		{5, protoFixed64, uint64(0x3fe0000000000000)},
+	}
+	if fmt.Sprint(match) != fmt.Sprint(expectedMatch) {
+		t.Errorf("Match is %v, expected %v", match, expectedMatch)
+	}
+
+	fields, err = decodeProto(MarshalProtobuf(info, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []byte
+	for _, field := range fields {
+		if field.number == 7 {
+			raw = field.value.([]byte)
+		}
+	}
+	if !bytes.Equal(raw, info.Entry.Leaf.TimestampedEntry.X509Entry) {
+		t.Errorf("raw is %x, expected the certificate", raw)
+	}
+}
+
+func TestProtobufSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entries.pb")
+	sink, err := NewProtobufSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.IncludeDER = true // so each message is 128 bytes or more
+	info := certtest.EntryInfo(t, []string{"a.example.com"}, nil)
+	for i := 0; i < 2; i++ {
+		if err := sink.Write(info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := MarshalProtobuf(info, true)
+	if len(expected) < 128 {
+		t.Fatalf("Message is only %d bytes", len(expected))
+	}
+	for i := 0; i < 2; i++ {
+		length, n := binary.Uvarint(data)
+		if n != 2 || length != uint64(len(expected)) {
+			t.Fatalf("Message %d has length prefix %x, expected varint %d", i, data[:2], len(expected))
+		}
+		data = data[n:]
+		if !bytes.Equal(data[:length], expected) {
+			t.Errorf("Message %d differs from MarshalProtobuf", i)
+		}
+		data = data[length:]
+	}
+	if len(data) != 0 {
+		t.Errorf("%d bytes left over", len(data))
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package sink contains implementations of certspotter.Sink.
+package sink
+
+import (
+	"software.sslmate.com/src/certspotter"
+)
+
+// Record is the JSON representation of a matching entry
+type Record struct {
+	*certspotter.CertRecord
+	LogURI     string   `json:"log_uri"`
+	EntryIndex int64    `json:"entry_index"`
+	LeafHash   []byte   `json:"leaf_hash"`
+	SeenInLogs []string `json:"seen_in_logs,omitempty"`
+	ParseError string   `json:"parse_error,omitempty"`
+}
+
+func MakeRecord(info *certspotter.EntryInfo) *Record {
+	record := &Record{
+		CertRecord: &certspotter.CertRecord{IsPrecert: info.IsPrecert, DNSNames: []string{}},
+		LogURI:     info.LogUri,
+		EntryIndex: info.Entry.Index,
+		LeafHash:   info.LeafHash(),
+		SeenInLogs: info.SeenInLogs,
+	}
+	if len(info.FullChain) != 0 {
+		record.CertRecord = certspotter.MakeCertRecord(info.IsPrecert, info.FullChain)
+	}
+	if info.ParseError != nil {
+		record.ParseError = info.ParseError.Error()
+	}
+	return record
+}
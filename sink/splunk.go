@@ -0,0 +1,229 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+const (
+	DefaultSplunkSourceType = "certspotter:json"
+	DefaultSplunkBatchSize  = 100
+	DefaultSplunkMaxPending = 100000
+)
+
+// The path of HEC's JSON event endpoint, used if the URL has no path
+const splunkEventPath = "/services/collector/event"
+
+type splunkEvent struct {
+	Time       float64 `json:"time"` // seconds since the epoch
+	Source     string  `json:"source"`
+	SourceType string  `json:"sourcetype"`
+	Index      string  `json:"index,omitempty"`
+	Event      *Line   `json:"event"`
+}
+
+type splunkResponse struct {
+	Text string `json:"text"`
+	Code int    `json:"code"`
+}
+
+// SplunkSink sends entries to a Splunk HTTP Event Collector, as events like
+// the objects written by JSONLinesSink (without DER), with the given
+// sourcetype and the leaf timestamp as the event time.  If Index is empty,
+// the token's default index is used.
+//
+// Events are sent in batches of BatchSize, or after FlushInterval if fewer
+// arrive.  Write blocks while a full batch is being sent, like
+// ElasticsearchSink.  Requests which fail with a network error, 429, or 5xx
+// status (such as when HEC's queue is full) are retried with exponential
+// backoff up to Retries times.  If a batch still can't be sent, its events
+// are kept and sent with the next batch, and Write stops blocking until a
+// batch is sent successfully.  At most MaxPending events are kept; beyond
+// that, the oldest are dropped.
+type SplunkSink struct {
+	SourceType    string
+	Index         string
+	BatchSize     int
+	FlushInterval time.Duration
+	Retries       int
+	MaxPending    int
+
+	endpoint   string
+	token      string
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	pending [][]byte // events, each ending in a newline
+	failing bool     // whether the last flush failed
+	started bool     // whether flushPeriodically is running
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewSplunkSink creates a SplunkSink for the HTTP Event Collector at
+// |hecURL| (e.g. https://splunk.example.com:8088), authenticating with
+// |token|
+func NewSplunkSink(hecURL string, token string) (*SplunkSink, error) {
+	endpoint, err := url.Parse(hecURL)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("%s: not an HTTP or HTTPS URL", hecURL)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = splunkEventPath
+	}
+	return &SplunkSink{
+		SourceType:    DefaultSplunkSourceType,
+		BatchSize:     DefaultSplunkBatchSize,
+		FlushInterval: 5 * time.Second,
+		Retries:       5,
+		MaxPending:    DefaultSplunkMaxPending,
+		endpoint:      endpoint.String(),
+		token:         token,
+		httpClient:    &http.Client{Timeout: 60 * time.Second},
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}, nil
+}
+
+// trySend sends |body| to HEC once, returning whether a failure should be
+// retried
+func (sink *SplunkSink) trySend(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", sink.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Splunk "+sink.token)
+	resp, err := sink.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		var response splunkResponse
+		if json.Unmarshal(respBody, &response) == nil && response.Text != "" {
+			err = fmt.Errorf("%s: %s (code %d)", resp.Status, response.Text, response.Code)
+		} else {
+			err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(respBody))
+		}
+		return isRetriableStatus(resp.StatusCode), err
+	}
+	return false, nil
+}
+
+// send sends |events| to HEC, retrying if necessary
+func (sink *SplunkSink) send(events [][]byte) error {
+	body := bytes.Join(events, nil)
+	for attempt := 0; ; attempt++ {
+		retry, err := sink.trySend(body)
+		if err == nil || !retry || attempt >= sink.Retries {
+			return err
+		}
+		time.Sleep(time.Duration(500<<uint(attempt)) * time.Millisecond)
+	}
+}
+
+// flush sends the pending events in batches of BatchSize.  Events which
+// can't be sent are left pending.  sink.mu must be held.
+func (sink *SplunkSink) flush() error {
+	for len(sink.pending) > 0 {
+		events := sink.pending
+		if len(events) > sink.BatchSize {
+			events = events[:sink.BatchSize]
+		}
+		if err := sink.send(events); err != nil {
+			sink.failing = true
+			return fmt.Errorf("Error sending %d events to Splunk: %s", len(sink.pending), err)
+		}
+		sink.pending = sink.pending[len(events):]
+	}
+	sink.pending = nil
+	sink.failing = false
+	return nil
+}
+
+func (sink *SplunkSink) flushPeriodically() {
+	defer close(sink.stopped)
+	ticker := time.NewTicker(sink.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sink.mu.Lock()
+			err := sink.flush()
+			sink.mu.Unlock()
+			if err != nil {
+				slog.Error(err.Error())
+			}
+		case <-sink.stop:
+			return
+		}
+	}
+}
+
+func (sink *SplunkSink) Write(info *certspotter.EntryInfo) error {
+	line := MakeLine(info, false)
+	event, err := json.Marshal(splunkEvent{
+		Time:       float64(line.Timestamp.UnixNano()/int64(time.Millisecond)) / 1000,
+		Source:     "certspotter",
+		SourceType: sink.SourceType,
+		Index:      sink.Index,
+		Event:      line,
+	})
+	if err != nil {
+		return fmt.Errorf("Error encoding entry %d from %s as JSON: %s", info.Entry.Index, info.LogUri, err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if !sink.started {
+		sink.started = true
+		go sink.flushPeriodically()
+	}
+	sink.pending = append(sink.pending, append(event, '\n'))
+	if excess := len(sink.pending) - sink.MaxPending; sink.MaxPending > 0 && excess > 0 {
+		slog.Error("Dropping events which couldn't be sent to Splunk", "count", excess)
+		sink.pending = sink.pending[excess:]
+	}
+	if len(sink.pending) >= sink.BatchSize && !sink.failing {
+		// While HEC is failing, leave retrying to flushPeriodically
+		return sink.flush()
+	}
+	return nil
+}
+
+func (sink *SplunkSink) Close() error {
+	sink.mu.Lock()
+	started := sink.started
+	sink.mu.Unlock()
+	if started {
+		close(sink.stop)
+		<-sink.stopped
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.flush()
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/certspotter/internal/certtest"
+)
+
+// fakeHEC records the events it receives, failing requests with 503 while
+// unavailable is positive (decrementing it each time)
+type fakeHEC struct {
+	t           *testing.T
+	mu          sync.Mutex
+	unavailable int
+	requests    int
+	batches     [][]splunkEvent
+}
+
+func (hec *fakeHEC) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	hec.mu.Lock()
+	defer hec.mu.Unlock()
+	hec.requests++
+	if req.Method != "POST" || req.URL.Path != splunkEventPath {
+		hec.t.Errorf("Unexpected request %s %s", req.Method, req.URL.Path)
+	}
+	if auth := req.Header.Get("Authorization"); auth != "Splunk hectoken" {
+		hec.t.Errorf("Authorization header is %q", auth)
+	}
+	if hec.unavailable > 0 {
+		hec.unavailable--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"text":"Server is busy","code":9}`))
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	var batch []splunkEvent
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var event splunkEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			hec.t.Errorf("Error decoding event: %s", err)
+		}
+		batch = append(batch, event)
+	}
+	hec.batches = append(hec.batches, batch)
+	w.Write([]byte(`{"text":"Success","code":0}`))
+}
+
+func newTestSplunkSink(t *testing.T) (*SplunkSink, *fakeHEC) {
+	hec := &fakeHEC{t: t}
+	server := httptest.NewServer(hec)
+	t.Cleanup(server.Close)
+	sink, err := NewSplunkSink(server.URL, "hectoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.FlushInterval = time.Hour
+	return sink, hec
+}
+
+func TestSplunkSink(t *testing.T) {
+	sink, hec := newTestSplunkSink(t)
+	sink.SourceType = "ct:json"
+	sink.Index = "certs"
+	sink.BatchSize = 2
+	sink.Retries = 1
+	hec.unavailable = 1 // the first batch is retried
+
+	info := certtest.EntryInfo(t, []string{"www.example.com"}, nil)
+	for i := 0; i < 3; i++ {
+		if err := sink.Write(info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hec.mu.Lock()
+	if len(hec.batches) != 1 || len(hec.batches[0]) != 2 || hec.requests != 2 {
+		t.Errorf("After 3 writes, got %d batches in %d requests, expected one batch of 2 in 2 requests", len(hec.batches), hec.requests)
+	}
+	hec.mu.Unlock()
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(hec.batches) != 2 || len(hec.batches[1]) != 1 {
+		t.Fatalf("After closing, got %d batches, expected the remaining event in a second batch", len(hec.batches))
+	}
+
+	event := hec.batches[0][0]
+	if event.Source != "certspotter" || event.SourceType != "ct:json" || event.Index != "certs" {
+		t.Errorf("Event has source %q, sourcetype %q, and index %q", event.Source, event.SourceType, event.Index)
+	}
+	if expected := float64(time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC).Unix()); event.Time != expected {
+		t.Errorf("Event time is %f, expected %f", event.Time, expected)
+	}
+	if event.Event == nil || event.Event.Fingerprint != info.Fingerprint() || event.Event.Raw != nil {
+		t.Errorf("Unexpected event %+v", event.Event)
+	}
+}
+
+func TestSplunkSinkFailure(t *testing.T) {
+	sink, hec := newTestSplunkSink(t)
+	sink.BatchSize = 2
+	sink.Retries = 0
+	hec.unavailable = 1
+
+	info := certtest.EntryInfo(t, []string{"www.example.com"}, nil)
+	if err := sink.Write(info); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(info); err == nil {
+		t.Fatal("Write succeeded even though HEC was unavailable")
+	}
+	// While failing, Write doesn't try to send full batches
+	for i := 0; i < 3; i++ {
+		if err := sink.Write(info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hec.requests != 1 {
+		t.Errorf("%d requests were made while failing, expected 1", hec.requests)
+	}
+	// The failed batch is kept and sent along with the later events
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var total int
+	for _, batch := range hec.batches {
+		if len(batch) > 2 {
+			t.Errorf("Batch of %d events is larger than BatchSize", len(batch))
+		}
+		total += len(batch)
+	}
+	if total != 5 {
+		t.Errorf("%d events were sent, expected 5", total)
+	}
+}
+
+func TestSplunkSinkMaxPending(t *testing.T) {
+	sink, hec := newTestSplunkSink(t)
+	sink.BatchSize = 2
+	sink.Retries = 0
+	sink.MaxPending = 3
+	hec.unavailable = 1
+
+	info := certtest.EntryInfo(t, []string{"www.example.com"}, nil)
+	for i := 0; i < 6; i++ {
+		sink.Write(info)
+	}
+	if len(sink.pending) != 3 {
+		t.Errorf("%d events are pending, expected MaxPending", len(sink.pending))
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
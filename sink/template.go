@@ -0,0 +1,84 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+// TemplateFuncs are the functions available to templates, in addition to
+// text/template's built-in functions
+var TemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	"rfc3339": func(t interface{}) string {
+		switch t := t.(type) {
+		case time.Time:
+			return formatTime(&t)
+		case *time.Time:
+			return formatTime(t)
+		}
+		return ""
+	},
+}
+
+// TemplateSink writes each entry to a file by executing a text/template
+// with the entry's Line (see MakeLine) as its data.  Nothing is added
+// after the template's output, so the template should normally end in
+// a newline.
+type TemplateSink struct {
+	Template *template.Template
+	out      *output
+}
+
+// NewTemplateSink creates a TemplateSink for the template in the file at
+// |templatePath|, which appends to the file at |path|, or writes to stdout
+// if |path| is "-"
+func NewTemplateSink(templatePath string, path string) (*TemplateSink, error) {
+	text, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(filepath.Base(templatePath)).Funcs(TemplateFuncs).Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+	out, err := openOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateSink{Template: tmpl, out: out}, nil
+}
+
+func (sink *TemplateSink) Write(info *certspotter.EntryInfo) error {
+	err := sink.out.write(func(writer *bufio.Writer) error {
+		return sink.Template.Execute(writer, MakeLine(info, true))
+	})
+	if err != nil {
+		return fmt.Errorf("Error executing template for entry %d from %s: %s", info.Entry.Index, info.LogUri, err)
+	}
+	return nil
+}
+
+func (sink *TemplateSink) Close() error {
+	return sink.out.Close()
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+func writeUvarint(buf *bytes.Buffer, value uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], value)])
+}
+
+func writeVarint(buf *bytes.Buffer, value int64) {
+	writeUvarint(buf, uint64((value<<1)^(value>>63))) // zigzag
+}
+
+// thriftWriter encodes a struct with the Thrift compact protocol, which is
+// used for Parquet metadata.  It starts inside the struct; fields must be
+// written in increasing order of ID.
+type thriftWriter struct {
+	buf       *bytes.Buffer
+	lastField []int16 // of each struct being written
+}
+
+func newThriftWriter(buf *bytes.Buffer) *thriftWriter {
+	return &thriftWriter{buf: buf, lastField: []int16{0}}
+}
+
+func (w *thriftWriter) field(id int16, fieldType byte) {
+	last := &w.lastField[len(w.lastField)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.buf.WriteByte(fieldType)
+		writeVarint(w.buf, int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) i32(id int16, value int32) {
+	w.field(id, thriftI32)
+	writeVarint(w.buf, int64(value))
+}
+
+func (w *thriftWriter) i64(id int16, value int64) {
+	w.field(id, thriftI64)
+	writeVarint(w.buf, value)
+}
+
+func (w *thriftWriter) binary(id int16, value string) {
+	w.field(id, thriftBinary)
+	w.elementBinary(value)
+}
+
+func (w *thriftWriter) beginStruct(id int16) {
+	w.field(id, thriftStruct)
+	w.beginElement()
+}
+
+// beginElement begins a struct which is an element of a list
+func (w *thriftWriter) beginElement() {
+	w.lastField = append(w.lastField, 0)
+}
+
+func (w *thriftWriter) endStruct() {
+	w.buf.WriteByte(0)
+	w.lastField = w.lastField[:len(w.lastField)-1]
+}
+
+// beginList begins a list field, whose |size| elements must then be written
+func (w *thriftWriter) beginList(id int16, elementType byte, size int) {
+	w.field(id, thriftList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elementType)
+	} else {
+		w.buf.WriteByte(0xf0 | elementType)
+		writeUvarint(w.buf, uint64(size))
+	}
+}
+
+func (w *thriftWriter) element32(value int32) {
+	writeVarint(w.buf, int64(value))
+}
+
+func (w *thriftWriter) elementBinary(value string) {
+	writeUvarint(w.buf, uint64(len(value)))
+	w.buf.WriteString(value)
+}
@@ -0,0 +1,212 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestThriftWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    func(w *thriftWriter)
+		expected []byte
+	}{
+		{"i32", func(w *thriftWriter) { w.i32(1, 3) }, []byte{0x15, 0x06, 0x00}},
+		{"negative i32", func(w *thriftWriter) { w.i32(1, -1) }, []byte{0x15, 0x01, 0x00}},
+		{"i64", func(w *thriftWriter) { w.i64(2, 300) }, []byte{0x26, 0xd8, 0x04, 0x00}},
+		{"binary", func(w *thriftWriter) { w.binary(4, "abc") }, []byte{0x48, 0x03, 'a', 'b', 'c', 0x00}},
+		{"field deltas", func(w *thriftWriter) { w.i32(1, 0); w.i32(3, 0) }, []byte{0x15, 0x00, 0x25, 0x00, 0x00}},
+		{"long field delta", func(w *thriftWriter) { w.i32(1, 0); w.i32(17, 0) }, []byte{0x15, 0x00, 0x05, 0x22, 0x00, 0x00}},
+		{"nested struct", func(w *thriftWriter) {
+			w.i32(1, 0)
+			w.beginStruct(5)
+			w.i32(1, 1)
+			w.endStruct()
+			w.i32(6, 2) // field delta is relative to 5, not to the nested field
+		}, []byte{0x15, 0x00, 0x4c, 0x15, 0x02, 0x00, 0x15, 0x04, 0x00}},
+		{"short list", func(w *thriftWriter) {
+			w.beginList(2, thriftI32, 2)
+			w.element32(0)
+			w.element32(3)
+		}, []byte{0x29, 0x25, 0x00, 0x06, 0x00}},
+		{"long list", func(w *thriftWriter) {
+			w.beginList(3, thriftBinary, 15)
+			for i := 0; i < 15; i++ {
+				w.elementBinary("")
+			}
+		}, append([]byte{0x39, 0xf8, 0x0f}, make([]byte, 16)...)},
+		{"list of structs", func(w *thriftWriter) {
+			w.beginList(1, thriftStruct, 2)
+			w.beginElement()
+			w.i32(2, 1)
+			w.endStruct()
+			w.beginElement()
+			w.i32(2, 2)
+			w.endStruct()
+		}, []byte{0x19, 0x2c, 0x25, 0x02, 0x00, 0x25, 0x04, 0x00, 0x00}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		w := newThriftWriter(&buf)
+		test.write(w)
+		w.endStruct()
+		if !bytes.Equal(buf.Bytes(), test.expected) {
+			t.Errorf("%s: encoded as %x, expected %x", test.name, buf.Bytes(), test.expected)
+		}
+	}
+}
+
+// thriftReader decodes the subset of the Thrift compact protocol written by
+// thriftWriter.  Structs are decoded as maps from field ID to value, lists
+// as slices, integers as int64, and binary as string.
+type thriftReader struct {
+	data []byte
+	err  error
+}
+
+func (r *thriftReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) == 0 {
+		r.err = fmt.Errorf("unexpected end of data")
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("invalid varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *thriftReader) varint() int64 {
+	value := r.uvarint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (r *thriftReader) value(valueType byte) interface{} {
+	switch valueType {
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		length := r.uvarint()
+		if r.err == nil && length > uint64(len(r.data)) {
+			r.err = fmt.Errorf("binary of %d bytes is too long", length)
+		}
+		if r.err != nil {
+			return ""
+		}
+		value := string(r.data[:length])
+		r.data = r.data[length:]
+		return value
+	case thriftList:
+		header := r.byte()
+		size := uint64(header >> 4)
+		if size == 15 {
+			size = r.uvarint()
+		}
+		var list []interface{}
+		for i := uint64(0); i < size && r.err == nil; i++ {
+			list = append(list, r.value(header&0x0f))
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("unsupported type %d", valueType)
+		}
+		return nil
+	}
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var id int16
+	for r.err == nil {
+		header := r.byte()
+		if header == 0 {
+			break
+		}
+		if delta := header >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			id = int16(r.varint())
+		}
+		fields[id] = r.value(header & 0x0f)
+	}
+	return fields
+}
+
+func decodeThriftStruct(data []byte) (map[int16]interface{}, []byte, error) {
+	r := &thriftReader{data: data}
+	fields := r.structure()
+	return fields, r.data, r.err
+}
+
+func TestThriftRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := newThriftWriter(&buf)
+	w.i32(1, -12345)
+	w.beginList(2, thriftStruct, 1)
+	w.beginElement()
+	w.binary(4, "name")
+	w.endStruct()
+	w.i64(3, 1<<40)
+	w.beginStruct(20)
+	w.beginList(2, thriftBinary, 20)
+	for i := 0; i < 20; i++ {
+		w.elementBinary(strings.Repeat("x", i))
+	}
+	w.endStruct()
+	w.endStruct()
+
+	fields, rest, err := decodeThriftStruct(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Error decoding %x: %s", buf.Bytes(), err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("%d bytes left over", len(rest))
+	}
+	if fields[1] != int64(-12345) {
+		t.Errorf("field 1 is %v", fields[1])
+	}
+	if list := fields[2].([]interface{}); len(list) != 1 || list[0].(map[int16]interface{})[4] != "name" {
+		t.Errorf("field 2 is %v", fields[2])
+	}
+	if fields[3] != int64(1<<40) {
+		t.Errorf("field 3 is %v", fields[3])
+	}
+	list := fields[20].(map[int16]interface{})[2].([]interface{})
+	if len(list) != 20 {
+		t.Fatalf("list has %d elements, expected 20", len(list))
+	}
+	for i, element := range list {
+		if element != strings.Repeat("x", i) {
+			t.Errorf("element %d is %q", i, element)
+		}
+	}
+}
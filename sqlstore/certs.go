@@ -0,0 +1,250 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/pem"
+	"math"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+func encodeChain(certs [][]byte) string {
+	var chain []byte
+	for _, cert := range certs {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+	}
+	return string(chain)
+}
+
+func decodeChain(chain string) [][]byte {
+	var certs [][]byte
+	rest := []byte(chain)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		certs = append(certs, block.Bytes)
+	}
+	return certs
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func nullTime(value *time.Time) sql.NullInt64 {
+	if value == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: value.Unix(), Valid: true}
+}
+
+func timeFromNull(value sql.NullInt64) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	t := time.Unix(value.Int64, 0).UTC()
+	return &t
+}
+
+const certColumns = `c.fingerprint, c.is_precert, c.chain, c.serial, c.issuer, c.subject, c.not_before, c.not_after, c.first_seen`
+
+func (store *Store) queryCerts(query string, args ...interface{}) ([]*certspotter.CertRecord, error) {
+	rows, err := store.db.Query(store.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []*certspotter.CertRecord{}
+	for rows.Next() {
+		var (
+			record                  certspotter.CertRecord
+			chain                   string
+			serial, issuer, subject sql.NullString
+			notBefore, notAfter     sql.NullInt64
+			firstSeen               int64
+		)
+		if err := rows.Scan(&record.Fingerprint, &record.IsPrecert, &chain, &serial, &issuer, &subject, &notBefore, &notAfter, &firstSeen); err != nil {
+			return nil, err
+		}
+		record.Chain = decodeChain(chain)
+		record.Serial = serial.String
+		record.Issuer = issuer.String
+		record.Subject = subject.String
+		record.NotBefore = timeFromNull(notBefore)
+		record.NotAfter = timeFromNull(notAfter)
+		record.FirstSeen = time.Unix(firstSeen, 0).UTC()
+		records = append(records, &record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.DNSNames, err = store.getDNSNames(record.Fingerprint); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (store *Store) getDNSNames(fingerprint string) ([]string, error) {
+	rows, err := store.db.Query(store.rebind(`SELECT reversed_dns_name FROM cert_dns_names WHERE fingerprint = ? ORDER BY reversed_dns_name`), fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	dnsNames := []string{}
+	for rows.Next() {
+		var reversedDNSName string
+		if err := rows.Scan(&reversedDNSName); err != nil {
+			return nil, err
+		}
+		dnsNames = append(dnsNames, certspotter.ReverseDNSName(reversedDNSName))
+	}
+	return dnsNames, rows.Err()
+}
+
+// GetCert returns the certificate with the given (hex-encoded SHA-256)
+// fingerprint, or nil if it is not in the store
+func (store *Store) GetCert(fingerprint string) (*certspotter.CertRecord, error) {
+	records, err := store.queryCerts(`SELECT `+certColumns+` FROM certs c WHERE c.fingerprint = ?`, strings.ToLower(fingerprint))
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+// domainCondition returns an SQL condition on c.fingerprint, and its
+// arguments, which selects certificates for the given DNS name, and if
+// |includeSubdomains| is true, for any DNS name under it
+func (store *Store) domainCondition(domain string, includeSubdomains bool) (string, []interface{}) {
+	reversed := certspotter.ReverseDNSName(strings.ToLower(domain))
+	if includeSubdomains {
+		// '/' is the character after '.', so this range contains exactly
+		// the names ending in .domain - provided strings are compared
+		// bytewise, which isn't the default in PostgreSQL
+		collate := ""
+		if store.dialect == postgresDialect {
+			collate = ` COLLATE "C"`
+		}
+		return `c.fingerprint IN (SELECT fingerprint FROM cert_dns_names WHERE reversed_dns_name = ? OR (reversed_dns_name` + collate + ` >= ? AND reversed_dns_name` + collate + ` < ?))`,
+			[]interface{}{reversed, reversed + ".", reversed + "/"}
+	}
+	return `c.fingerprint IN (SELECT fingerprint FROM cert_dns_names WHERE reversed_dns_name = ?)`, []interface{}{reversed}
+}
+
+// FindCertsByDomain returns certificates for the given DNS name, and if
+// |includeSubdomains| is true, for any DNS name under it
+func (store *Store) FindCertsByDomain(domain string, includeSubdomains bool) ([]*certspotter.CertRecord, error) {
+	condition, args := store.domainCondition(domain, includeSubdomains)
+	return store.queryCerts(`SELECT `+certColumns+` FROM certs c WHERE `+condition+` ORDER BY c.not_before`, args...)
+}
+
+// escapeLike escapes the wildcards in a LIKE pattern, for use with
+// ESCAPE '\'
+func escapeLike(str string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(str)
+}
+
+// SearchCerts returns the certificates selected by |query|
+func (store *Store) SearchCerts(query *certspotter.CertQuery) ([]*certspotter.CertRecord, error) {
+	var conditions []string
+	var args []interface{}
+	if query.Fingerprint != "" {
+		conditions = append(conditions, `c.fingerprint = ?`)
+		args = append(args, strings.ToLower(query.Fingerprint))
+	}
+	if query.Domain != "" {
+		condition, domainArgs := store.domainCondition(query.Domain, query.IncludeSubdomains)
+		conditions = append(conditions, condition)
+		args = append(args, domainArgs...)
+	}
+	if query.Issuer != "" {
+		conditions = append(conditions, `LOWER(c.issuer) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(strings.ToLower(query.Issuer))+"%")
+	}
+	if query.IssuedAfter != nil {
+		conditions = append(conditions, `c.not_before >= ?`)
+		args = append(args, query.IssuedAfter.Unix())
+	}
+	if query.IssuedBefore != nil {
+		conditions = append(conditions, `c.not_before < ?`)
+		args = append(args, query.IssuedBefore.Unix())
+	}
+
+	sql := `SELECT ` + certColumns + ` FROM certs c`
+	if len(conditions) != 0 {
+		sql += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	// NULLs sort first in SQLite but last in PostgreSQL
+	sql += ` ORDER BY COALESCE(c.not_before, 0), c.fingerprint`
+	if query.Limit > 0 || query.Offset > 0 {
+		limit := query.Limit
+		if limit <= 0 {
+			limit = math.MaxInt32
+		}
+		sql += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, query.Offset)
+	}
+	return store.queryCerts(sql, args...)
+}
+
+// FindCertsIssuedBetween returns certificates whose notBefore time is in [start, end)
+func (store *Store) FindCertsIssuedBetween(start time.Time, end time.Time) ([]*certspotter.CertRecord, error) {
+	return store.queryCerts(`SELECT `+certColumns+` FROM certs c WHERE c.not_before >= ? AND c.not_before < ? ORDER BY c.not_before`, start.Unix(), end.Unix())
+}
+
+func (store *Store) SaveIssuance(key []byte, fingerprint string) (string, error) {
+	if _, err := store.db.Exec(store.rebind(`INSERT INTO issuances (issuance_key, fingerprint) VALUES (?, ?) ON CONFLICT (issuance_key) DO NOTHING`), hex.EncodeToString(key), fingerprint); err != nil {
+		return "", err
+	}
+	var first string
+	if err := store.db.QueryRow(store.rebind(`SELECT fingerprint FROM issuances WHERE issuance_key = ?`), hex.EncodeToString(key)).Scan(&first); err != nil {
+		return "", err
+	}
+	if first == fingerprint {
+		return "", nil
+	}
+	return first, nil
+}
+
+func (store *Store) SaveSerial(key []byte, issuanceKey []byte, fingerprint string) (string, error) {
+	if _, err := store.db.Exec(store.rebind(`INSERT INTO serials (serial_key, issuance_key, fingerprint) VALUES (?, ?, ?) ON CONFLICT (serial_key) DO NOTHING`), hex.EncodeToString(key), hex.EncodeToString(issuanceKey), fingerprint); err != nil {
+		return "", err
+	}
+	var firstIssuanceKey, first string
+	if err := store.db.QueryRow(store.rebind(`SELECT issuance_key, fingerprint FROM serials WHERE serial_key = ?`), hex.EncodeToString(key)).Scan(&firstIssuanceKey, &first); err != nil {
+		return "", err
+	}
+	if firstIssuanceKey == hex.EncodeToString(issuanceKey) {
+		return "", nil
+	}
+	return first, nil
+}
+
+func (store *Store) HasNotified(channel string, fingerprint string) (bool, error) {
+	var count int
+	if err := store.db.QueryRow(store.rebind(`SELECT COUNT(*) FROM notified WHERE channel = ? AND fingerprint = ?`), channel, fingerprint).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (store *Store) SaveNotified(channel string, fingerprint string) error {
+	_, err := store.db.Exec(store.rebind(`INSERT INTO notified (channel, fingerprint, time) VALUES (?, ?, ?) ON CONFLICT (channel, fingerprint) DO NOTHING`), channel, fingerprint, time.Now().Unix())
+	return err
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sqlstore
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+type LogStore struct {
+	store *Store
+	logId []byte
+}
+
+func (logStore *LogStore) logIdString() string {
+	return base64.StdEncoding.EncodeToString(logStore.logId)
+}
+
+// generate a key that uniquely identifies the STH (within the context of a particular log)
+func sthKey(sth *ct.SignedTreeHead) string {
+	hasher := sha256.New()
+	binary.Write(hasher, binary.LittleEndian, sth.Timestamp)
+	binary.Write(hasher, binary.LittleEndian, sth.SHA256RootHash)
+	return strconv.FormatUint(sth.TreeSize, 10) + "-" + base64.RawURLEncoding.EncodeToString(hasher.Sum(nil))
+}
+
+func (logStore *LogStore) getJSONColumn(column string, obj interface{}) (bool, error) {
+	var value sql.NullString
+	if err := logStore.store.db.QueryRow(logStore.store.rebind(`SELECT `+column+` FROM logs WHERE log_id = ?`), logStore.logIdString()).Scan(&value); err != nil {
+		return false, err
+	}
+	if !value.Valid {
+		return false, nil
+	}
+	return true, json.Unmarshal([]byte(value.String), obj)
+}
+
+func (logStore *LogStore) setJSONColumn(column string, obj interface{}) error {
+	value, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = logStore.store.db.Exec(logStore.store.rebind(`UPDATE logs SET `+column+` = ? WHERE log_id = ?`), string(value), logStore.logIdString())
+	return err
+}
+
+func (logStore *LogStore) GetVerifiedSTH() (*ct.SignedTreeHead, error) {
+	sth := new(ct.SignedTreeHead)
+	if present, err := logStore.getJSONColumn("verified_sth", sth); err != nil || !present {
+		return nil, err
+	}
+	return sth, nil
+}
+
+func (logStore *LogStore) StoreVerifiedSTH(sth *ct.SignedTreeHead) error {
+	return logStore.setJSONColumn("verified_sth", sth)
+}
+
+func (logStore *LogStore) GetTree() (*certspotter.CollapsedMerkleTree, error) {
+	tree := new(certspotter.CollapsedMerkleTree)
+	if present, err := logStore.getJSONColumn("tree", tree); err != nil || !present {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func (logStore *LogStore) StoreTree(tree *certspotter.CollapsedMerkleTree) error {
+	return logStore.setJSONColumn("tree", tree)
+}
+
+func (logStore *LogStore) GetUnverifiedSTHs() ([]*ct.SignedTreeHead, error) {
+	rows, err := logStore.store.db.Query(logStore.store.rebind(`SELECT sth FROM unverified_sths WHERE log_id = ? ORDER BY tree_size`), logStore.logIdString())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sths := []*ct.SignedTreeHead{}
+	for rows.Next() {
+		var sthJSON string
+		if err := rows.Scan(&sthJSON); err != nil {
+			return nil, err
+		}
+		sth := new(ct.SignedTreeHead)
+		if err := json.Unmarshal([]byte(sthJSON), sth); err != nil {
+			return nil, err
+		}
+		sths = append(sths, sth)
+	}
+	return sths, rows.Err()
+}
+
+func (logStore *LogStore) StoreUnverifiedSTH(sth *ct.SignedTreeHead) error {
+	sthJSON, err := json.Marshal(sth)
+	if err != nil {
+		return err
+	}
+	_, err = logStore.store.db.Exec(logStore.store.rebind(`INSERT INTO unverified_sths (log_id, sth_key, tree_size, sth) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`),
+		logStore.logIdString(), sthKey(sth), int64(sth.TreeSize), string(sthJSON))
+	return err
+}
+
+func (logStore *LogStore) RemoveUnverifiedSTH(sth *ct.SignedTreeHead) error {
+	_, err := logStore.store.db.Exec(logStore.store.rebind(`DELETE FROM unverified_sths WHERE log_id = ? AND sth_key = ?`), logStore.logIdString(), sthKey(sth))
+	return err
+}
+
+func (logStore *LogStore) GetPendingSCTs() ([]*certspotter.PendingSCT, error) {
+	rows, err := logStore.store.db.Query(logStore.store.rebind(`SELECT pending_sct FROM pending_scts WHERE log_id = ?`), logStore.logIdString())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pendings := []*certspotter.PendingSCT{}
+	for rows.Next() {
+		var pendingJSON string
+		if err := rows.Scan(&pendingJSON); err != nil {
+			return nil, err
+		}
+		pending := new(certspotter.PendingSCT)
+		if err := json.Unmarshal([]byte(pendingJSON), pending); err != nil {
+			return nil, err
+		}
+		pendings = append(pendings, pending)
+	}
+	return pendings, rows.Err()
+}
+
+func (logStore *LogStore) StorePendingSCT(pending *certspotter.PendingSCT) error {
+	pendingJSON, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	_, err = logStore.store.db.Exec(logStore.store.rebind(`INSERT INTO pending_scts (log_id, leaf_hash, pending_sct) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`),
+		logStore.logIdString(), base64.StdEncoding.EncodeToString(pending.LeafHash), string(pendingJSON))
+	return err
+}
+
+func (logStore *LogStore) RemovePendingSCT(pending *certspotter.PendingSCT) error {
+	_, err := logStore.store.db.Exec(logStore.store.rebind(`DELETE FROM pending_scts WHERE log_id = ? AND leaf_hash = ?`), logStore.logIdString(), base64.StdEncoding.EncodeToString(pending.LeafHash))
+	return err
+}
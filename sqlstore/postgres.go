@@ -0,0 +1,96 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Advisory lock keys.  These are arbitrary, but must not change, since
+// every instance sharing a database has to agree on them.
+const (
+	stateLockKey     int64 = 0x63657274 // "cert"
+	migrationLockKey int64 = 0x6d696772 // "migr"
+)
+
+// PostgresOptions configures the connection pool used by OpenPostgres
+type PostgresOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+var DefaultPostgresOptions = PostgresOptions{
+	MaxOpenConns:    10,
+	MaxIdleConns:    2,
+	ConnMaxLifetime: 30 * time.Minute,
+}
+
+// OpenPostgres connects to the PostgreSQL database described by |dsn|
+// (a URL or key=value connection string), creating or upgrading the schema if
+// necessary.  A driver must be registered under the name "postgres" (e.g. by
+// importing github.com/lib/pq).
+//
+// Any number of instances may share the same database.  Scanning is
+// serialized by an advisory lock, which PostgreSQL releases automatically if
+// an instance crashes or loses its connection.
+func OpenPostgres(dsn string, options PostgresOptions) (*Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxLifetime(options.ConnMaxLifetime)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	store, err := newStore(db, postgresDialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Advisory locks belong to a session, so take one connection out of the
+// pool and hold on to it until Unlock
+func (store *Store) lockPostgres() (bool, error) {
+	conn, err := store.db.Conn(context.Background())
+	if err != nil {
+		return false, err
+	}
+	var locked bool
+	if err := conn.QueryRowContext(context.Background(), `SELECT pg_try_advisory_lock($1)`, stateLockKey).Scan(&locked); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !locked {
+		conn.Close()
+		return false, nil
+	}
+	store.lockConn = conn
+	return true, nil
+}
+
+func (store *Store) unlockPostgres() error {
+	if store.lockConn == nil {
+		return nil
+	}
+	_, err := store.lockConn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, stateLockKey)
+	if closeErr := store.lockConn.Close(); err == nil {
+		err = closeErr
+	}
+	store.lockConn = nil
+	return err
+}
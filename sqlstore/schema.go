@@ -0,0 +1,183 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sqlstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The meta table holds the schema version, so it must exist before migrations are run
+const metaTable = `CREATE TABLE IF NOT EXISTS meta (
+	key		TEXT NOT NULL PRIMARY KEY,
+	value		TEXT NOT NULL
+)`
+
+// migrations[i] upgrades the schema from version i to version i+1.  Never
+// modify a migration that has been released; append a new one instead.  The
+// statements must work in both SQLite and PostgreSQL.
+//
+// Times are stored as Unix timestamps, and STHs, trees, and other structures
+// are stored as JSON, in the same format as the filesystem store.
+var migrations = [][]string{
+	// Version 1: initial schema.  Databases created before migrations
+	// were introduced already have these tables, hence IF NOT EXISTS.
+	{
+		`CREATE TABLE IF NOT EXISTS logs (
+			log_id		TEXT NOT NULL PRIMARY KEY,
+			uri		TEXT NOT NULL,
+			verified_sth	TEXT,
+			tree		TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS unverified_sths (
+			log_id		TEXT NOT NULL REFERENCES logs (log_id),
+			sth_key		TEXT NOT NULL,
+			tree_size	BIGINT NOT NULL,
+			sth		TEXT NOT NULL,
+			PRIMARY KEY (log_id, sth_key)
+		)`,
+		`CREATE TABLE IF NOT EXISTS pending_scts (
+			log_id		TEXT NOT NULL REFERENCES logs (log_id),
+			leaf_hash	TEXT NOT NULL,
+			pending_sct	TEXT NOT NULL,
+			PRIMARY KEY (log_id, leaf_hash)
+		)`,
+		`CREATE TABLE IF NOT EXISTS certs (
+			fingerprint	TEXT NOT NULL PRIMARY KEY,
+			is_precert	BOOLEAN NOT NULL,
+			chain		TEXT NOT NULL,
+			serial		TEXT,
+			issuer		TEXT,
+			subject		TEXT,
+			not_before	BIGINT,
+			not_after	BIGINT,
+			first_seen	BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS certs_not_before ON certs (not_before)`,
+		`CREATE INDEX IF NOT EXISTS certs_first_seen ON certs (first_seen)`,
+		// DNS names are stored with their labels reversed (e.g. com.example.www)
+		// so that all names under a domain can be found with an index range scan.
+		`CREATE TABLE IF NOT EXISTS cert_dns_names (
+			fingerprint		TEXT NOT NULL REFERENCES certs (fingerprint),
+			reversed_dns_name	TEXT NOT NULL,
+			PRIMARY KEY (fingerprint, reversed_dns_name)
+		)`,
+		`CREATE INDEX IF NOT EXISTS cert_dns_names_reversed_dns_name ON cert_dns_names (reversed_dns_name)`,
+		`CREATE TABLE IF NOT EXISTS evidence (
+			id		TEXT NOT NULL PRIMARY KEY,
+			log_id		TEXT NOT NULL,
+			type		TEXT NOT NULL,
+			time		BIGINT NOT NULL,
+			evidence	TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS notifications (
+			id		TEXT NOT NULL PRIMARY KEY,
+			next_attempt	BIGINT NOT NULL,
+			notification	TEXT NOT NULL
+		)`,
+	},
+	// Version 2: pairing of precertificates with certificates
+	{
+		`CREATE TABLE issuances (
+			issuance_key	TEXT NOT NULL PRIMARY KEY,
+			fingerprint	TEXT NOT NULL
+		)`,
+	},
+	// Version 3: certificates notified about on each channel
+	{
+		`CREATE TABLE notified (
+			channel		TEXT NOT NULL,
+			fingerprint	TEXT NOT NULL,
+			time		BIGINT NOT NULL,
+			PRIMARY KEY (channel, fingerprint)
+		)`,
+	},
+	// Version 4: certificates whose CRLs are re-checked
+	{
+		`CREATE TABLE watched_certs (
+			fingerprint	TEXT NOT NULL PRIMARY KEY,
+			not_after	BIGINT NOT NULL,
+			watched_cert	TEXT NOT NULL
+		)`,
+	},
+	// Version 5: issuer and serial numbers, for detecting duplicates
+	{
+		`CREATE TABLE serials (
+			serial_key	TEXT NOT NULL PRIMARY KEY,
+			issuance_key	TEXT NOT NULL,
+			fingerprint	TEXT NOT NULL
+		)`,
+	},
+}
+
+func (store *Store) schemaVersion() (int, error) {
+	value, err := store.getMeta("schema_version")
+	if err != nil || value == "" {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+func (store *Store) migrate() error {
+	if _, err := store.db.Exec(metaTable); err != nil {
+		return err
+	}
+	version, err := store.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("Database has schema version %d, but this version of Cert Spotter only supports up to version %d", version, len(migrations))
+	}
+	for version < len(migrations) {
+		if err := store.runMigration(version); err != nil {
+			return fmt.Errorf("Migration to version %d: %s", version+1, err)
+		}
+		version++
+	}
+	return nil
+}
+
+func (store *Store) runMigration(fromVersion int) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if store.dialect == postgresDialect {
+		// Serialize migrations by instances sharing the database; the
+		// lock is released when the transaction ends
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockKey); err != nil {
+			return err
+		}
+	}
+	var value string
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(value), '0') FROM meta WHERE key = 'schema_version'`).Scan(&value); err != nil {
+		return err
+	}
+	if current, err := strconv.Atoi(value); err != nil {
+		return err
+	} else if current > fromVersion {
+		// another instance beat us to it
+		return nil
+	}
+
+	for _, stmt := range migrations[fromVersion] {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %s", strings.TrimSpace(strings.SplitN(stmt, "(", 2)[0]), err)
+		}
+	}
+	if _, err := tx.Exec(store.rebind(`INSERT INTO meta (key, value) VALUES ('schema_version', ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`), strconv.Itoa(fromVersion+1)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
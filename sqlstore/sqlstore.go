@@ -0,0 +1,336 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package sqlstore implements certspotter.Store on top of an SQL database
+// (SQLite or PostgreSQL).
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"software.sslmate.com/src/certspotter"
+)
+
+type dialect int
+
+const (
+	sqliteDialect dialect = iota
+	postgresDialect
+)
+
+type Store struct {
+	db        *sql.DB
+	dialect   dialect
+	firstRun  bool
+	lockOwner string
+	lockConn  *sql.Conn // Postgres only: the connection holding the advisory lock
+}
+
+// OpenSQLite opens the SQLite database at |path|, creating it if necessary.
+// An SQLite driver must be registered under the name "sqlite3" (e.g. by
+// importing github.com/mattn/go-sqlite3).
+func OpenSQLite(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer at a time, so use a single connection
+	// to avoid contending with ourselves.  Write-ahead logging lets other
+	// processes read the database while we are writing to it.
+	db.SetMaxOpenConns(1)
+	for _, pragma := range []string{"PRAGMA journal_mode=WAL", "PRAGMA busy_timeout=10000", "PRAGMA foreign_keys=ON"} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %s", pragma, err)
+		}
+	}
+	store, err := newStore(db, sqliteDialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// New creates a Store using the given SQLite database handle, creating or
+// upgrading the schema if necessary.
+func New(db *sql.DB) (*Store, error) {
+	return newStore(db, sqliteDialect)
+}
+
+func newStore(db *sql.DB, dialect dialect) (*Store, error) {
+	store := &Store{db: db, dialect: dialect}
+	if err := store.migrate(); err != nil {
+		return nil, fmt.Errorf("Error upgrading database schema: %s", err)
+	}
+	once, err := store.getMeta("once")
+	if err != nil {
+		return nil, err
+	}
+	store.firstRun = once == ""
+	return store, nil
+}
+
+func (store *Store) Close() error {
+	if store.lockConn != nil {
+		store.lockConn.Close()
+	}
+	return store.db.Close()
+}
+
+// DB returns the underlying database handle, for running queries
+func (store *Store) DB() *sql.DB {
+	return store.db
+}
+
+// rebind converts the ?-style placeholders in |query| to the style used by the database
+func (store *Store) rebind(query string) string {
+	if store.dialect != postgresDialect {
+		return query
+	}
+	var rebound []byte
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] == '?' {
+			n++
+			rebound = strconv.AppendInt(append(rebound, '$'), int64(n), 10)
+		} else {
+			rebound = append(rebound, query[i])
+		}
+	}
+	return string(rebound)
+}
+
+func (store *Store) getMeta(key string) (string, error) {
+	var value string
+	err := store.db.QueryRow(store.rebind(`SELECT value FROM meta WHERE key = ?`), key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+func (store *Store) setMeta(key string, value string) error {
+	_, err := store.db.Exec(store.rebind(`INSERT INTO meta (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`), key, value)
+	return err
+}
+
+func (store *Store) Lock() (bool, error) {
+	if store.dialect == postgresDialect {
+		if locked, err := store.lockPostgres(); err != nil || !locked {
+			return locked, err
+		}
+	}
+	owner := certspotter.LockOwnerName()
+	if store.dialect == postgresDialect {
+		// The advisory lock is what counts; the meta row just records who holds it,
+		// and may have been left behind by an instance that crashed.
+		if err := store.setMeta("lock", owner); err != nil {
+			store.unlockPostgres()
+			return false, err
+		}
+		store.lockOwner = owner
+		return true, nil
+	}
+	result, err := store.db.Exec(store.rebind(`INSERT INTO meta (key, value) VALUES ('lock', ?) ON CONFLICT (key) DO NOTHING`), owner)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return false, err
+	} else if n == 0 {
+		return store.takeStaleLock(owner)
+	}
+	store.lockOwner = owner
+	return true, nil
+}
+
+// takeStaleLock takes over the lock for |owner| if the process holding it
+// has died without releasing it
+func (store *Store) takeStaleLock(owner string) (bool, error) {
+	previous, err := store.getMeta("lock")
+	if err != nil || !certspotter.LockOwnerIsDead(previous) {
+		return false, err
+	}
+	result, err := store.db.Exec(store.rebind(`UPDATE meta SET value = ? WHERE key = 'lock' AND value = ?`), owner, previous)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return false, err
+	} else if n == 0 {
+		// Another instance took it first
+		return false, nil
+	}
+	slog.Warn("Taking over lock left behind by a process which is no longer running", "owner", previous)
+	store.lockOwner = owner
+	return true, nil
+}
+
+func (store *Store) Unlock() error {
+	_, err := store.db.Exec(store.rebind(`DELETE FROM meta WHERE key = 'lock' AND value = ?`), store.lockOwner)
+	if store.dialect == postgresDialect {
+		if unlockErr := store.unlockPostgres(); err == nil {
+			err = unlockErr
+		}
+	}
+	return err
+}
+
+// ForceUnlock removes the lock, such as one left behind by an instance on
+// another host which crashed
+func (store *Store) ForceUnlock() error {
+	_, err := store.db.Exec(`DELETE FROM meta WHERE key = 'lock'`)
+	return err
+}
+
+// LockOwner returns the process ID and hostname of the instance holding the lock, if any
+func (store *Store) LockOwner() (string, error) {
+	return store.getMeta("lock")
+}
+
+func (store *Store) IsFirstRun() bool {
+	return store.firstRun
+}
+
+func (store *Store) WriteOnceFile() error {
+	return store.setMeta("once", time.Now().UTC().Format(time.RFC3339))
+}
+
+func (store *Store) SaveCert(isPrecert bool, certs [][]byte) (bool, string, error) {
+	if len(certs) == 0 {
+		return false, "", fmt.Errorf("Cannot save an empty certificate chain")
+	}
+	record := certspotter.MakeCertRecord(isPrecert, certs)
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return false, "", err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(store.rebind(`INSERT INTO certs (fingerprint, is_precert, chain, serial, issuer, subject, not_before, not_after, first_seen) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (fingerprint) DO NOTHING`),
+		record.Fingerprint, record.IsPrecert, encodeChain(record.Chain), nullString(record.Serial), nullString(record.Issuer), nullString(record.Subject), nullTime(record.NotBefore), nullTime(record.NotAfter), record.FirstSeen.Unix())
+	if err != nil {
+		return false, "", fmt.Errorf("Error saving certificate %s: %s", record.Fingerprint, err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return false, "", err
+	} else if n == 0 {
+		return true, record.Fingerprint, nil
+	}
+	for _, dnsName := range record.DNSNames {
+		if _, err := tx.Exec(store.rebind(`INSERT INTO cert_dns_names (fingerprint, reversed_dns_name) VALUES (?, ?) ON CONFLICT DO NOTHING`), record.Fingerprint, certspotter.ReverseDNSName(dnsName)); err != nil {
+			return false, "", fmt.Errorf("Error saving DNS names of certificate %s: %s", record.Fingerprint, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, "", err
+	}
+	return false, record.Fingerprint, nil
+}
+
+func (store *Store) StoreEvidence(evidence *certspotter.Evidence) (string, error) {
+	evidenceJSON, err := json.Marshal(evidence)
+	if err != nil {
+		return "", err
+	}
+	id := fmt.Sprintf("%s-%x-%s", evidence.Time.Format("20060102T150405Z"), evidence.LogID[:], evidence.Type)
+	if _, err := store.db.Exec(store.rebind(`INSERT INTO evidence (id, log_id, type, time, evidence) VALUES (?, ?, ?, ?, ?)`), id, evidence.LogID.Base64String(), evidence.Type, evidence.Time.Unix(), string(evidenceJSON)); err != nil {
+		return "", err
+	}
+	return "evidence " + id, nil
+}
+
+func (store *Store) QueueNotification(notification *certspotter.PendingNotification) error {
+	notificationJSON, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(store.rebind(`INSERT INTO notifications (id, next_attempt, notification) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET next_attempt = excluded.next_attempt, notification = excluded.notification`),
+		notification.ID, notification.NextAttempt.Unix(), string(notificationJSON))
+	return err
+}
+
+func (store *Store) GetNotifications() ([]*certspotter.PendingNotification, error) {
+	rows, err := store.db.Query(`SELECT notification FROM notifications ORDER BY next_attempt`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notifications := []*certspotter.PendingNotification{}
+	for rows.Next() {
+		var notificationJSON []byte
+		if err := rows.Scan(&notificationJSON); err != nil {
+			return nil, err
+		}
+		notification := new(certspotter.PendingNotification)
+		if err := json.Unmarshal(notificationJSON, notification); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+func (store *Store) RemoveNotification(notification *certspotter.PendingNotification) error {
+	_, err := store.db.Exec(store.rebind(`DELETE FROM notifications WHERE id = ?`), notification.ID)
+	return err
+}
+
+func (store *Store) WatchCert(watched *certspotter.WatchedCert) error {
+	watchedJSON, err := json.Marshal(watched)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(store.rebind(`INSERT INTO watched_certs (fingerprint, not_after, watched_cert) VALUES (?, ?, ?) ON CONFLICT (fingerprint) DO UPDATE SET not_after = excluded.not_after, watched_cert = excluded.watched_cert`),
+		watched.Fingerprint, watched.NotAfter.Unix(), string(watchedJSON))
+	return err
+}
+
+func (store *Store) GetWatchedCerts() ([]*certspotter.WatchedCert, error) {
+	rows, err := store.db.Query(`SELECT watched_cert FROM watched_certs ORDER BY not_after`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	watchedCerts := []*certspotter.WatchedCert{}
+	for rows.Next() {
+		var watchedJSON []byte
+		if err := rows.Scan(&watchedJSON); err != nil {
+			return nil, err
+		}
+		watched := new(certspotter.WatchedCert)
+		if err := json.Unmarshal(watchedJSON, watched); err != nil {
+			return nil, err
+		}
+		watchedCerts = append(watchedCerts, watched)
+	}
+	return watchedCerts, rows.Err()
+}
+
+func (store *Store) UnwatchCert(fingerprint string) error {
+	_, err := store.db.Exec(store.rebind(`DELETE FROM watched_certs WHERE fingerprint = ?`), fingerprint)
+	return err
+}
+
+func (store *Store) OpenLogState(logInfo *certspotter.LogInfo) (certspotter.LogStore, error) {
+	logStore := &LogStore{store: store, logId: logInfo.ID()}
+	if _, err := store.db.Exec(store.rebind(`INSERT INTO logs (log_id, uri) VALUES (?, ?) ON CONFLICT (log_id) DO NOTHING`), logStore.logIdString(), logInfo.FullURI()); err != nil {
+		return nil, err
+	}
+	return logStore, nil
+}
@@ -0,0 +1,367 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package sqlstore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"database/sql"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/internal/certtest"
+)
+
+// The tests also run against PostgreSQL if this environment variable is set
+// to a DSN.  Every table in that database is dropped!
+const postgresDSNVariable = "CERTSPOTTER_TEST_POSTGRES_DSN"
+
+// forEachDatabase runs |test| against a new SQLite database, and against
+// PostgreSQL if configured.  |open| opens the database, creating or
+// upgrading its schema, and can be called more than once.  |raw| is a handle
+// to the same database on which no migrations have been run, for setting it
+// up beforehand.
+func forEachDatabase(t *testing.T, test func(t *testing.T, raw *Store, open func() *Store)) {
+	t.Run("sqlite", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "certspotter.db")
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		test(t, &Store{db: db, dialect: sqliteDialect}, func() *Store {
+			store, err := OpenSQLite(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		})
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv(postgresDSNVariable)
+		if dsn == "" {
+			t.Skip(postgresDSNVariable + " is not set")
+		}
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := db.Exec(`DROP TABLE IF EXISTS meta, logs, unverified_sths, pending_scts, certs, cert_dns_names, evidence, notifications, issuances, notified, watched_certs, serials CASCADE`); err != nil {
+			t.Fatal(err)
+		}
+		test(t, &Store{db: db, dialect: postgresDialect}, func() *Store {
+			store, err := OpenPostgres(dsn, DefaultPostgresOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		})
+	})
+}
+
+func makeCert(t *testing.T, serial int64, notBefore time.Time, dnsNames ...string) []byte {
+	return certtest.Cert(t, func(template *x509.Certificate) {
+		template.SerialNumber = big.NewInt(serial)
+		template.NotBefore = notBefore
+		template.NotAfter = notBefore.AddDate(0, 3, 0)
+		template.DNSNames = dnsNames
+	})
+}
+
+func fingerprints(records []*certspotter.CertRecord) string {
+	var fps []string
+	for _, record := range records {
+		fps = append(fps, record.Fingerprint)
+	}
+	return strings.Join(fps, ",")
+}
+
+func TestMigrateV1(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, raw *Store, open func() *Store) {
+		record := certspotter.MakeCertRecord(false, [][]byte{makeCert(t, 1, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "www.example.com")})
+
+		// The schema of databases created before migrations were introduced,
+		// which have no meta table
+		for _, stmt := range migrations[0] {
+			if _, err := raw.db.Exec(stmt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := raw.db.Exec(raw.rebind(`INSERT INTO certs (fingerprint, is_precert, chain, not_before, first_seen) VALUES (?, ?, ?, ?, ?)`), record.Fingerprint, false, encodeChain(record.Chain), record.NotBefore.Unix(), record.FirstSeen.Unix()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := raw.db.Exec(raw.rebind(`INSERT INTO cert_dns_names (fingerprint, reversed_dns_name) VALUES (?, ?)`), record.Fingerprint, "com.example.www"); err != nil {
+			t.Fatal(err)
+		}
+
+		store := open()
+		if version, err := store.schemaVersion(); err != nil || version != len(migrations) {
+			t.Errorf("Database has schema version %d after migrating, expected %d (error: %v)", version, len(migrations), err)
+		}
+		if !store.IsFirstRun() {
+			t.Error("Migrated database is not in its first run")
+		}
+		if records, err := store.FindCertsByDomain("example.com", true); err != nil || fingerprints(records) != record.Fingerprint {
+			t.Errorf("Certificate saved before migrating was not found: %q, %v", fingerprints(records), err)
+		}
+		// Tables added by later migrations
+		if first, err := store.SaveIssuance([]byte("issuance"), record.Fingerprint); err != nil || first != "" {
+			t.Errorf("SaveIssuance after migrating returned %q, %v", first, err)
+		}
+		if err := store.SaveNotified("email", record.Fingerprint); err != nil {
+			t.Errorf("SaveNotified after migrating failed: %s", err)
+		}
+		if err := store.WatchCert(&certspotter.WatchedCert{Fingerprint: record.Fingerprint}); err != nil {
+			t.Errorf("WatchCert after migrating failed: %s", err)
+		}
+		if other, err := store.SaveSerial([]byte("serial"), []byte("issuance"), record.Fingerprint); err != nil || other != "" {
+			t.Errorf("SaveSerial after migrating returned %q, %v", other, err)
+		}
+		store.Close()
+
+		// Reopening an up-to-date database changes nothing
+		store = open()
+		if version, err := store.schemaVersion(); err != nil || version != len(migrations) {
+			t.Errorf("Database has schema version %d after reopening, expected %d (error: %v)", version, len(migrations), err)
+		}
+		if first, err := store.SaveIssuance([]byte("issuance"), "other"); err != nil || first != record.Fingerprint {
+			t.Errorf("SaveIssuance after reopening returned %q, %v", first, err)
+		}
+	})
+}
+
+func TestNewerSchemaVersion(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, raw *Store, open func() *Store) {
+		open().Close()
+		if err := raw.setMeta("schema_version", strconv.Itoa(len(migrations)+1)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newStore(raw.db, raw.dialect); err == nil {
+			t.Fatal("Opening a database from a newer version succeeded")
+		} else if !strings.Contains(err.Error(), "only supports up to version") {
+			t.Errorf("Opening a database from a newer version failed with %q", err)
+		}
+	})
+}
+
+func TestSearchCerts(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, raw *Store, open func() *Store) {
+		store := open()
+		// Certificates 3 and 4 have the same notBefore, so they're
+		// ordered by fingerprint
+		days := []int{5, 1, 3, 3, 2, 4}
+		var all []*certspotter.CertRecord
+		for i, day := range days {
+			notBefore := time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC)
+			cert := makeCert(t, int64(i+1), notBefore, strconv.Itoa(i)+".example.com")
+			if _, _, err := store.SaveCert(false, [][]byte{cert}); err != nil {
+				t.Fatal(err)
+			}
+			all = append(all, certspotter.MakeCertRecord(false, [][]byte{cert}))
+		}
+		// Also a certificate without a notBefore, which sorts first
+		unparsable := []byte("not a certificate")
+		if _, _, err := store.SaveCert(false, [][]byte{unparsable}); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, certspotter.MakeCertRecord(false, [][]byte{unparsable}))
+
+		records, err := store.SearchCerts(&certspotter.CertQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != len(all) {
+			t.Fatalf("SearchCerts returned %d certificates, expected %d", len(records), len(all))
+		}
+		if records[0].NotBefore != nil {
+			t.Errorf("First certificate has notBefore %s, expected the one without a notBefore", records[0].NotBefore)
+		}
+		for i := 2; i < len(records); i++ {
+			previous, current := records[i-1], records[i]
+			if current.NotBefore.Before(*previous.NotBefore) || (current.NotBefore.Equal(*previous.NotBefore) && current.Fingerprint < previous.Fingerprint) {
+				t.Errorf("Certificate %d (%s, %s) sorts before certificate %d (%s, %s)", i, current.NotBefore, current.Fingerprint, i-1, previous.NotBefore, previous.Fingerprint)
+			}
+		}
+		ordered := fingerprints(records)
+
+		// Pages must partition the full result, in the same order
+		for _, pageSize := range []int{1, 2, 3, 5} {
+			var paged []string
+			for offset := 0; offset < len(all); offset += pageSize {
+				page, err := store.SearchCerts(&certspotter.CertQuery{Offset: offset, Limit: pageSize})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := min(pageSize, len(all)-offset); len(page) != want {
+					t.Fatalf("SearchCerts with offset %d and limit %d returned %d certificates, expected %d", offset, pageSize, len(page), want)
+				}
+				paged = append(paged, fingerprints(page))
+			}
+			if got := strings.Join(paged, ","); got != ordered {
+				t.Errorf("Pages of %d returned %q, expected %q", pageSize, got, ordered)
+			}
+		}
+		// An offset without a limit returns the rest
+		if page, err := store.SearchCerts(&certspotter.CertQuery{Offset: 4}); err != nil || fingerprints(page) != fingerprints(records[4:]) {
+			t.Errorf("SearchCerts with only an offset returned %q, %v", fingerprints(page), err)
+		}
+		if page, err := store.SearchCerts(&certspotter.CertQuery{Offset: len(all)}); err != nil || len(page) != 0 {
+			t.Errorf("SearchCerts past the end returned %d certificates, %v", len(page), err)
+		}
+
+		// Paging combined with conditions
+		after := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+		if page, err := store.SearchCerts(&certspotter.CertQuery{Domain: "example.com", IncludeSubdomains: true, IssuedAfter: &after, Offset: 1, Limit: 2}); err != nil {
+			t.Fatal(err)
+		} else if fingerprints(page) != fingerprints(records[4:6]) {
+			t.Errorf("SearchCerts with conditions returned %q, expected %q", fingerprints(page), fingerprints(records[4:6]))
+		}
+		if page, err := store.SearchCerts(&certspotter.CertQuery{Domain: "2.EXAMPLE.com"}); err != nil || fingerprints(page) != all[2].Fingerprint {
+			t.Errorf("SearchCerts by domain returned %q, %v", fingerprints(page), err)
+		}
+		if page, err := store.SearchCerts(&certspotter.CertQuery{Fingerprint: strings.ToUpper(all[0].Fingerprint)}); err != nil || fingerprints(page) != all[0].Fingerprint {
+			t.Errorf("SearchCerts by fingerprint returned %q, %v", fingerprints(page), err)
+		}
+		if page, err := store.SearchCerts(&certspotter.CertQuery{Issuer: "TEST_CA"}); err != nil || len(page) != 0 {
+			t.Errorf("SearchCerts treated _ in the issuer as a wildcard: %q, %v", fingerprints(page), err)
+		}
+		if page, err := store.SearchCerts(&certspotter.CertQuery{Issuer: "test ca", Limit: 4}); err != nil || len(page) != 4 {
+			t.Errorf("SearchCerts by issuer returned %d certificates, %v", len(page), err)
+		}
+	})
+}
+
+func TestLogState(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, raw *Store, open func() *Store) {
+		logInfo := &certspotter.LogInfo{Key: []byte("test log key"), Url: "ct.example.com/"}
+		store := open()
+		logStore, err := store.OpenLogState(logInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if sth, err := logStore.GetVerifiedSTH(); err != nil || sth != nil {
+			t.Errorf("GetVerifiedSTH of a new log returned %v, %v", sth, err)
+		}
+		if tree, err := logStore.GetTree(); err != nil || tree != nil {
+			t.Errorf("GetTree of a new log returned %v, %v", tree, err)
+		}
+
+		verified := &ct.SignedTreeHead{TreeSize: 3, Timestamp: 1000, SHA256RootHash: ct.SHA256Hash{1}}
+		if err := logStore.StoreVerifiedSTH(verified); err != nil {
+			t.Fatal(err)
+		}
+		tree := certspotter.EmptyCollapsedMerkleTree()
+		for i := byte(0); i < 3; i++ {
+			tree.Add(ct.MerkleTreeNode(bytes.Repeat([]byte{i}, 32)))
+		}
+		if err := logStore.StoreTree(tree); err != nil {
+			t.Fatal(err)
+		}
+		// Stored out of order to check that they're returned by tree size
+		sths := []*ct.SignedTreeHead{
+			{TreeSize: 300, Timestamp: 3000, SHA256RootHash: ct.SHA256Hash{3}},
+			{TreeSize: 5, Timestamp: 2000, SHA256RootHash: ct.SHA256Hash{2}},
+			{TreeSize: 20, Timestamp: 2500, SHA256RootHash: ct.SHA256Hash{4}},
+		}
+		for _, sth := range sths {
+			if err := logStore.StoreUnverifiedSTH(sth); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := logStore.RemoveUnverifiedSTH(sths[2]); err != nil {
+			t.Fatal(err)
+		}
+		pendings := []*certspotter.PendingSCT{
+			{LeafHash: bytes.Repeat([]byte{1}, 32), Fingerprint: "one"},
+			{LeafHash: bytes.Repeat([]byte{2}, 32), Fingerprint: "two"},
+		}
+		for _, pending := range pendings {
+			if err := logStore.StorePendingSCT(pending); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := logStore.RemovePendingSCT(pendings[0]); err != nil {
+			t.Fatal(err)
+		}
+		store.Close()
+
+		store = open()
+		if logStore, err = store.OpenLogState(logInfo); err != nil {
+			t.Fatal(err)
+		}
+		if sth, err := logStore.GetVerifiedSTH(); err != nil {
+			t.Fatal(err)
+		} else if sth == nil || sth.TreeSize != verified.TreeSize || sth.Timestamp != verified.Timestamp || sth.SHA256RootHash != verified.SHA256RootHash {
+			t.Errorf("GetVerifiedSTH returned %+v, expected %+v", sth, verified)
+		}
+		if stored, err := logStore.GetTree(); err != nil {
+			t.Fatal(err)
+		} else if stored == nil || stored.GetSize() != 3 || !bytes.Equal(stored.CalculateRoot(), tree.CalculateRoot()) {
+			t.Errorf("GetTree returned %+v, expected a tree of size 3 with root %x", stored, tree.CalculateRoot())
+		}
+		if unverified, err := logStore.GetUnverifiedSTHs(); err != nil {
+			t.Fatal(err)
+		} else if len(unverified) != 2 || unverified[0].TreeSize != 5 || unverified[1].TreeSize != 300 {
+			t.Errorf("GetUnverifiedSTHs returned %+v, expected tree sizes 5 and 300", unverified)
+		}
+		if stored, err := logStore.GetPendingSCTs(); err != nil {
+			t.Fatal(err)
+		} else if len(stored) != 1 || stored[0].Fingerprint != "two" {
+			t.Errorf("GetPendingSCTs returned %+v, expected only the second SCT", stored)
+		}
+
+		// Each log has its own state
+		other, err := store.OpenLogState(&certspotter.LogInfo{Key: []byte("other log key"), Url: "other.example.com/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sth, err := other.GetVerifiedSTH(); err != nil || sth != nil {
+			t.Errorf("GetVerifiedSTH of another log returned %v, %v", sth, err)
+		}
+		if unverified, err := other.GetUnverifiedSTHs(); err != nil || len(unverified) != 0 {
+			t.Errorf("GetUnverifiedSTHs of another log returned %+v, %v", unverified, err)
+		}
+	})
+}
+
+func TestLock(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, raw *Store, open func() *Store) {
+		first, second := open(), open()
+		if locked, err := first.Lock(); err != nil || !locked {
+			t.Fatalf("First Lock returned %v, %v", locked, err)
+		}
+		if locked, err := second.Lock(); err != nil || locked {
+			t.Errorf("Lock of a locked database returned %v, %v", locked, err)
+		}
+		if owner, err := second.LockOwner(); err != nil || owner != certspotter.LockOwnerName() {
+			t.Errorf("LockOwner returned %q, %v, expected %q", owner, err, certspotter.LockOwnerName())
+		}
+		if err := first.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+		if locked, err := second.Lock(); err != nil || !locked {
+			t.Errorf("Lock after Unlock returned %v, %v", locked, err)
+		}
+	})
+}
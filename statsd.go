@@ -0,0 +1,152 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Largest UDP packet which StatsDExporter sends, to avoid fragmentation
+const maxStatsDPacket = 1432
+
+// StatsDExporter sends the metrics of a Collector to a StatsD server over
+// UDP each time Flush is called.  Counters are sent as StatsD counters of
+// how much they've gone up since the last Flush, gauges and untyped metrics
+// as gauges, and histograms as counters of their _sum and _count.  With
+// DogStatsD, labels are sent as tags; otherwise, their values are appended
+// to the metric name, separated by dots.  Flush mustn't be called
+// concurrently.
+type StatsDExporter struct {
+	collector *Collector
+	conn      net.Conn
+	DogStatsD bool
+	sent      map[string]float64 // counters' values as of the last Flush, by line prefix
+}
+
+// NewStatsDExporter returns a StatsDExporter which sends |collector|'s
+// metrics to the StatsD server at |addr| (HOST:PORT)
+func NewStatsDExporter(collector *Collector, addr string) (*StatsDExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDExporter{collector: collector, conn: conn, sent: make(map[string]float64)}, nil
+}
+
+// Characters which can't appear in StatsD names and DogStatsD tag names
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "\n", "_", " ", "_")
+
+// Characters which can't appear in DogStatsD tag values
+var statsdTagEscaper = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_", " ", "_")
+
+// Makes |value| usable as a component of a plain StatsD name, which is
+// dot-separated
+var statsdNameEscaper = strings.NewReplacer(".", "_", "/", "_", ":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_", " ", "_")
+
+// statsdName returns the name (with tags for DogStatsD) of |name| with
+// |labels|, which precedes the value in a StatsD line
+func (e *StatsDExporter) statsdName(name string, labels []string) string {
+	var buf bytes.Buffer
+	buf.WriteString(statsdEscaper.Replace(name))
+	if !e.DogStatsD {
+		for i := 1; i < len(labels); i += 2 {
+			buf.WriteByte('.')
+			buf.WriteString(statsdNameEscaper.Replace(labels[i]))
+		}
+	}
+	return buf.String()
+}
+
+func (e *StatsDExporter) statsdTags(labels []string) string {
+	if !e.DogStatsD || len(labels) < 2 {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteString("|#")
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(statsdEscaper.Replace(labels[i]))
+		buf.WriteByte(':')
+		buf.WriteString(statsdTagEscaper.Replace(labels[i+1]))
+	}
+	return buf.String()
+}
+
+// counter returns the line for counter |name| whose total is |value|, or
+// the empty string if it hasn't changed since the last Flush
+func (e *StatsDExporter) counter(name string, labels []string, value float64) string {
+	key := name + e.statsdTags(labels)
+	delta := value - e.sent[key]
+	if delta < 0 {
+		// The counter was reset
+		delta = value
+	}
+	e.sent[key] = value
+	if delta == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s|c%s", name, formatValue(delta), e.statsdTags(labels))
+}
+
+func (e *StatsDExporter) lines() []string {
+	var lines []string
+	add := func(line string) {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	for _, sample := range e.collector.Gather() {
+		switch sample.Kind {
+		case "counter":
+			add(e.counter(e.statsdName(sample.Name, sample.Labels), sample.Labels, sample.Value))
+		case "histogram":
+			add(e.counter(e.statsdName(sample.Name+"_sum", sample.Labels), sample.Labels, sample.Value))
+			add(e.counter(e.statsdName(sample.Name+"_count", sample.Labels), sample.Labels, float64(sample.Count)))
+		default:
+			add(fmt.Sprintf("%s:%s|g%s", e.statsdName(sample.Name, sample.Labels), formatValue(sample.Value), e.statsdTags(sample.Labels)))
+		}
+	}
+	return lines
+}
+
+// Flush sends the current values of the metrics
+func (e *StatsDExporter) Flush() error {
+	var packet bytes.Buffer
+	send := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := e.conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+	for _, line := range e.lines() {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsDPacket {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return send()
+}
+
+// Close closes the connection to the StatsD server
+func (e *StatsDExporter) Close() error {
+	return e.conn.Close()
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func receiveStatsD(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, maxStatsDPacket)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestStatsDExporter(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	collector := &Collector{metrics: make(map[string]*metric)}
+	collector.Describe("test_total", "counter", "A test counter")
+	collector.Describe("test_gauge", "gauge", "A test gauge")
+	collector.DescribeHistogram("test_seconds", "A test histogram", []float64{1})
+	collector.Add("test_total", 2, "log", "https://ct.example.com/2025")
+	collector.Set("test_gauge", 7)
+	collector.Observe("test_seconds", 0.5)
+
+	for _, dogstatsd := range []bool{false, true} {
+		exporter, err := NewStatsDExporter(collector, server.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		exporter.DogStatsD = dogstatsd
+		expected := "test_gauge:7|g\ntest_seconds_sum:0.5|c\ntest_seconds_count:1|c\ntest_total.https___ct_example_com_2025:2|c"
+		if dogstatsd {
+			expected = "test_gauge:7|g\ntest_seconds_sum:0.5|c\ntest_seconds_count:1|c\ntest_total:2|c|#log:https://ct.example.com/2025"
+		}
+		if err := exporter.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if packet := receiveStatsD(t, server); packet != expected {
+			t.Errorf("Wrong packet (DogStatsD=%v):\n%s\nExpected:\n%s", dogstatsd, packet, expected)
+		}
+
+		// Only the changes to counters are sent
+		collector.Add("test_total", 1, "log", "https://ct.example.com/2025")
+		if err := exporter.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		expected = "test_gauge:7|g\ntest_total.https___ct_example_com_2025:1|c"
+		if dogstatsd {
+			expected = "test_gauge:7|g\ntest_total:1|c|#log:https://ct.example.com/2025"
+		}
+		if packet := receiveStatsD(t, server); packet != expected {
+			t.Errorf("Wrong second packet (DogStatsD=%v):\n%s\nExpected:\n%s", dogstatsd, packet, expected)
+		}
+		exporter.Close()
+		collector.Reset("test_total")
+		collector.Add("test_total", 2, "log", "https://ct.example.com/2025")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// Store persists the state of a monitor between runs.  The default
+// implementation stores state in a directory on the filesystem (see
+// cmd.OpenState), but any implementation may be used.
+type Store interface {
+	// Acquire exclusive access to the store.  Returns false if another
+	// instance already holds the lock.
+	Lock() (bool, error)
+	Unlock() error
+
+	// A store is in its first run until WriteOnceFile is called
+	IsFirstRun() bool
+	WriteOnceFile() error
+
+	// Save a certificate chain, returning true if it had already been saved
+	// (i.e. the certificate has been seen before), and a filename or other
+	// identifier for the saved copy.
+	SaveCert(isPrecert bool, certs [][]byte) (bool, string, error)
+
+	// Save evidence of log misbehavior, returning a filename or other identifier
+	StoreEvidence(*Evidence) (string, error)
+
+	// Notifications which have not yet been successfully delivered
+	QueueNotification(*PendingNotification) error
+	GetNotifications() ([]*PendingNotification, error)
+	RemoveNotification(*PendingNotification) error
+
+	OpenLogState(*LogInfo) (LogStore, error)
+}
+
+// Stores which can look up the certificates they've saved implement
+// CertQueryStore
+type CertQueryStore interface {
+	Store
+
+	// Return the certificate with the given fingerprint (in hex), or nil
+	// if it hasn't been saved
+	GetCert(fingerprint string) (*CertRecord, error)
+
+	// Return the certificates for the given DNS name, and if
+	// |includeSubdomains| is true, for any DNS name under it
+	FindCertsByDomain(domain string, includeSubdomains bool) ([]*CertRecord, error)
+}
+
+// Stores which can identify the instance holding their lock implement
+// LockOwnerStore, so that a helpful error can be shown if Lock fails
+type LockOwnerStore interface {
+	Store
+	// Returns an empty string if the owner is unknown
+	LockOwner() (string, error)
+}
+
+// LockOwnerName identifies this process in lock records, as PID@HOSTNAME
+func LockOwnerName() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%d@%s", os.Getpid(), hostname)
+}
+
+// LockOwnerIsDead returns whether |owner| (as returned by LockOwnerName)
+// is a process on this host which is no longer running.  Returns false if
+// that can't be determined, such as when the owner is on another host.
+func LockOwnerIsDead(owner string) bool {
+	pidString, host, found := strings.Cut(owner, "@")
+	if !found {
+		return false
+	}
+	pid, err := strconv.Atoi(pidString)
+	if err != nil || pid <= 0 {
+		return false
+	}
+	if hostname, err := os.Hostname(); err != nil || host != hostname {
+		return false
+	}
+	return !processExists(pid)
+}
+
+// Stores whose lock outlives the process holding it implement
+// ForceUnlockStore, so that a lock left behind by an instance which
+// crashed can be removed
+type ForceUnlockStore interface {
+	Store
+	// Remove the lock, regardless of who holds it
+	ForceUnlock() error
+}
+
+// LogStore persists the state of a single log
+type LogStore interface {
+	GetVerifiedSTH() (*ct.SignedTreeHead, error)
+	StoreVerifiedSTH(*ct.SignedTreeHead) error
+
+	GetUnverifiedSTHs() ([]*ct.SignedTreeHead, error)
+	StoreUnverifiedSTH(*ct.SignedTreeHead) error
+	RemoveUnverifiedSTH(*ct.SignedTreeHead) error
+
+	// The tree records the position up to which the log has been scanned
+	GetTree() (*CollapsedMerkleTree, error)
+	StoreTree(*CollapsedMerkleTree) error
+
+	GetPendingSCTs() ([]*PendingSCT, error)
+	StorePendingSCT(*PendingSCT) error
+	RemovePendingSCT(*PendingSCT) error
+}
+
+// A PendingNotification is a notification about a log entry which has not
+// yet been delivered to a particular channel (such as a hook script).
+type PendingNotification struct {
+	ID              string            `json:"id"`
+	Channel         string            `json:"channel"`
+	LogURI          string            `json:"log_uri"`
+	Index           int64             `json:"index"`
+	LeafInput       []byte            `json:"leaf_input"`
+	Chain           []ct.ASN1Cert     `json:"chain"`
+	Filename        string            `json:"filename,omitempty"`
+	SeenInLogs      []string          `json:"seen_in_logs,omitempty"`
+	Matches         []Match           `json:"matches,omitempty"`
+	Violations      []string          `json:"br_violations,omitempty"`
+	Revocation      *RevocationStatus `json:"revocation,omitempty"`
+	ChainValidation *ChainValidation  `json:"chain_validation,omitempty"`
+	DNSStatuses     []DNSStatus       `json:"dns_statuses,omitempty"`
+	Queued          time.Time         `json:"queued"`
+	Attempts        int               `json:"attempts"`
+	NextAttempt     time.Time         `json:"next_attempt"`
+}
+
+func NewPendingNotification(channel string, info *EntryInfo) *PendingNotification {
+	return &PendingNotification{
+		ID:              info.Fingerprint() + "-" + channel,
+		Channel:         channel,
+		LogURI:          info.LogUri,
+		Index:           info.Entry.Index,
+		LeafInput:       info.Entry.LeafBytes,
+		Chain:           info.Entry.Chain,
+		Filename:        info.Filename,
+		SeenInLogs:      info.SeenInLogs,
+		Matches:         info.Matches,
+		Violations:      info.Violations,
+		Revocation:      info.Revocation,
+		ChainValidation: info.ChainValidation,
+		DNSStatuses:     info.DNSStatuses,
+		Queued:          time.Now().UTC(),
+	}
+}
+
+// LogEntry reconstructs the log entry that the notification is about
+func (n *PendingNotification) LogEntry() (*ct.LogEntry, error) {
+	leaf, err := ct.ReadMerkleTreeLeaf(bytes.NewReader(n.LeafInput))
+	if err != nil {
+		return nil, err
+	}
+	return &ct.LogEntry{
+		Index:     n.Index,
+		Leaf:      *leaf,
+		Chain:     n.Chain,
+		LeafBytes: n.LeafInput,
+		LeafHash:  hashLeaf(n.LeafInput),
+	}, nil
+}
+
+// EntryInfo reconstructs the entry that the notification is about, with
+// its matches
+func (n *PendingNotification) EntryInfo() (*EntryInfo, error) {
+	entry, err := n.LogEntry()
+	if err != nil {
+		return nil, err
+	}
+	info := NewEntryInfo(n.LogURI, entry)
+	info.Filename = n.Filename
+	info.SeenInLogs = n.SeenInLogs
+	info.Matches = n.Matches
+	info.Violations = n.Violations
+	info.Revocation = n.Revocation
+	info.ChainValidation = n.ChainValidation
+	info.DNSStatuses = n.DNSStatuses
+	return info, nil
+}
+
+// CertRecord describes a certificate saved in a Store
+type CertRecord struct {
+	Fingerprint string     `json:"fingerprint"`
+	IsPrecert   bool       `json:"is_precert"`
+	Chain       [][]byte   `json:"chain"` // DER, starting with the certificate itself
+	Serial      string     `json:"serial,omitempty"`
+	Issuer      string     `json:"issuer,omitempty"`
+	Subject     string     `json:"subject,omitempty"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	FirstSeen   time.Time  `json:"first_seen"`
+	DNSNames    []string   `json:"dns_names"`
+}
+
+// MakeCertRecord creates a record for the given certificate chain (as passed to
+// Store.SaveCert).  The certificate is recorded no matter what, but the
+// searchable fields are only filled in if they can be parsed.
+func MakeCertRecord(isPrecert bool, certs [][]byte) *CertRecord {
+	record := &CertRecord{
+		Fingerprint: sha256hex(certs[0]),
+		IsPrecert:   isPrecert,
+		Chain:       certs,
+		FirstSeen:   time.Now().UTC(),
+		DNSNames:    []string{},
+	}
+
+	info, err := MakeCertInfoFromRawCert(certs[0])
+	if err != nil {
+		return record
+	}
+	if info.SerialNumberParseError == nil {
+		record.Serial = formatSerialNumber(info.SerialNumber)
+	}
+	if info.IssuerParseError == nil {
+		record.Issuer = info.Issuer.String()
+	}
+	if info.SubjectParseError == nil {
+		record.Subject = info.Subject.String()
+	}
+	record.NotBefore = info.NotBefore()
+	record.NotAfter = info.NotAfter()
+	if ids, err := info.ParseIdentifiers(); err == nil {
+		record.DNSNames = ids.DNSNames
+	}
+	return record
+}
+
+// ReverseDNSName reverses the order of the labels in |dnsName|, so that names
+// in the same domain sort together (e.g. www.example.com becomes com.example.www)
+func ReverseDNSName(dnsName string) string {
+	labels := strings.Split(dnsName, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".")
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestLockOwnerIsDead(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+	if LockOwnerIsDead(LockOwnerName()) {
+		t.Errorf("this process was reported dead")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	exited := fmt.Sprintf("%d@%s", cmd.Process.Pid, hostname)
+	if !LockOwnerIsDead(exited) {
+		t.Errorf("%s was not reported dead", exited)
+	}
+
+	for _, owner := range []string{
+		fmt.Sprintf("%d@%s.other", cmd.Process.Pid, hostname),
+		"",
+		"garbage",
+		"-1@" + hostname,
+		"x@" + hostname,
+	} {
+		if LockOwnerIsDead(owner) {
+			t.Errorf("%q was reported dead", owner)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package tiled
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+// noteKeyID returns the key ID of an RFC 6962 note signature made by the
+// log with ID |logID|, for a checkpoint with origin |origin|
+func noteKeyID(origin string, logID []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(origin))
+	h.Write([]byte{'\n', 0x05})
+	h.Write(logID)
+	return h.Sum(nil)[:4]
+}
+
+// parseCheckpoint parses a checkpoint signed by the log with ID |logID|.
+// The log's note signature contains the STH's timestamp and its RFC 6962
+// TreeHeadSignature.  Other signatures (e.g. by witnesses) are ignored.
+func parseCheckpoint(data []byte, logID []byte) (*ct.SignedTreeHead, error) {
+	text := string(data)
+	separator := strings.Index(text, "\n\n")
+	if separator == -1 {
+		return nil, fmt.Errorf("checkpoint has no signatures")
+	}
+	lines := strings.Split(text[:separator], "\n")
+	if len(lines) < 3 {
+		return nil, fmt.Errorf("checkpoint is too short")
+	}
+	origin := lines[0]
+	treeSize, err := strconv.ParseUint(lines[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint has invalid tree size: %s", err)
+	}
+	rootHash, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint has invalid root hash: %s", err)
+	}
+	if len(rootHash) != sha256.Size {
+		return nil, fmt.Errorf("checkpoint has invalid root hash (expected length %d got %d)", sha256.Size, len(rootHash))
+	}
+
+	keyID := noteKeyID(origin, logID)
+	for _, line := range strings.Split(strings.TrimSuffix(text[separator+2:], "\n"), "\n") {
+		// — <key name> <base64 signature>
+		if !strings.HasPrefix(line, "— ") {
+			return nil, fmt.Errorf("checkpoint has malformed signature line")
+		}
+		fields := strings.Split(line[len("— "):], " ")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("checkpoint has malformed signature line")
+		}
+		signature, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint has malformed signature: %s", err)
+		}
+		if len(signature) < 4 || !bytes.Equal(signature[:4], keyID) {
+			continue
+		}
+		r := bytes.NewReader(signature[4:])
+		sth := &ct.SignedTreeHead{Version: ct.V1, TreeSize: treeSize}
+		if err := binary.Read(r, binary.BigEndian, &sth.Timestamp); err != nil {
+			return nil, fmt.Errorf("checkpoint signature has no timestamp")
+		}
+		ds, err := ct.UnmarshalDigitallySigned(r)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint has malformed signature: %s", err)
+		}
+		sth.TreeHeadSignature = *ds
+		copy(sth.SHA256RootHash[:], rootHash)
+		copy(sth.LogID[:], logID)
+		return sth, nil
+	}
+	return nil, fmt.Errorf("checkpoint isn't signed by the log")
+}
+
+// tileLeaf is an entry in a data tile
+type tileLeaf struct {
+	entry          ct.LogEntry // without the chain
+	preCertificate ct.ASN1Cert // for precertificate entries
+	chain          [][32]byte  // fingerprints of the issuers
+}
+
+// parseDataTile parses the TileLeaf structures in a data tile.  The
+// TimestampedEntry of each one is the same as in an RFC 6962 MerkleTreeLeaf.
+func parseDataTile(data []byte) ([]tileLeaf, error) {
+	var leaves []tileLeaf
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		var leaf tileLeaf
+		leaf.entry.Leaf.Version = ct.V1
+		leaf.entry.Leaf.LeafType = ct.TimestampedEntryLeafType
+		entryStart := len(data) - r.Len()
+		if err := ct.ReadTimestampedEntryInto(r, &leaf.entry.Leaf.TimestampedEntry); err != nil {
+			return nil, fmt.Errorf("entry %d: %s", len(leaves), err)
+		}
+		entryBytes := data[entryStart : len(data)-r.Len()]
+		leaf.entry.LeafBytes = append([]byte{byte(ct.V1), byte(ct.TimestampedEntryLeafType)}, entryBytes...)
+
+		if leaf.entry.Leaf.TimestampedEntry.EntryType == ct.PrecertLogEntryType {
+			preCertificate, err := readVarBytes(r, ct.CertificateLengthBytes)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: pre_certificate: %s", len(leaves), err)
+			}
+			leaf.preCertificate = preCertificate
+		}
+		chain, err := readVarBytes(r, 2)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: certificate_chain: %s", len(leaves), err)
+		}
+		if len(chain)%32 != 0 {
+			return nil, fmt.Errorf("entry %d: certificate_chain has invalid length", len(leaves))
+		}
+		for i := 0; i < len(chain); i += 32 {
+			var fingerprint [32]byte
+			copy(fingerprint[:], chain[i:])
+			leaf.chain = append(leaf.chain, fingerprint)
+		}
+		leaves = append(leaves, leaf)
+	}
+	return leaves, nil
+}
+
+func readVarBytes(r io.Reader, numLenBytes int) ([]byte, error) {
+	var length uint64
+	for i := 0; i < numLenBytes; i++ {
+		var b [1]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		length = length<<8 | uint64(b[0])
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("short read: expected %d bytes", length)
+	}
+	return data, nil
+}
@@ -0,0 +1,450 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package tiled is a client for logs implementing the static-ct-api
+// <https://c2sp.org/static-ct-api>, such as Sunlight logs, which serve their
+// checkpoint, Merkle tree, entries, and issuers as static files instead of
+// implementing the RFC 6962 API.
+package tiled
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/ct/client"
+)
+
+// TileWidth is the number of entries or hashes in a full tile
+const TileWidth = 256
+
+// DefaultRequestTimeout is the maximum time a request to the log may take
+const DefaultRequestTimeout = 60 * time.Second
+
+var errNotFound = errors.New("not found")
+
+// Client represents a client for a static-ct-api log
+type Client struct {
+	bytesDownloaded uint64 // accessed atomically, so first for alignment
+
+	uri        string // the monitoring prefix of the log, e.g. https://rome2025h1.fly.storage.tigris.dev
+	logID      []byte
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	treeSize uint64                   // size of the latest checkpoint, for finding partial tiles
+	issuers  map[[32]byte]ct.ASN1Cert // by fingerprint
+}
+
+// New constructs a Client for the log with monitoring prefix |uri| and ID
+// |logID|.  Requests time out after |requestTimeout|, or DefaultRequestTimeout
+// if it's 0.
+func New(uri string, logID []byte, requestTimeout time.Duration) *Client {
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeout
+	}
+	return &Client{
+		uri:        strings.TrimSuffix(uri, "/"),
+		logID:      logID,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		issuers:    make(map[[32]byte]ct.ASN1Cert),
+	}
+}
+
+// BytesDownloaded returns the number of bytes of tiles, checkpoints, and
+// issuers received from the log
+func (c *Client) BytesDownloaded() uint64 {
+	return atomic.LoadUint64(&c.bytesDownloaded)
+}
+
+func (c *Client) get(path string) ([]byte, error) {
+	uri := c.uri + "/" + path
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: Sending request failed: %s", uri, err)
+	}
+	req.Header.Set("User-Agent", "certspotter")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	atomic.AddUint64(&c.bytesDownloaded, uint64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: Reading response failed: %w", uri, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, client.StatusError(resp.StatusCode, "GET %s: %s", uri, resp.Status)
+	}
+	return data, nil
+}
+
+func (c *Client) latestTreeSize() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.treeSize
+}
+
+// GetSTH retrieves the log's checkpoint, and converts it to a SignedTreeHead
+// whose signature can be verified like an RFC 6962 STH's
+func (c *Client) GetSTH() (*ct.SignedTreeHead, error) {
+	data, err := c.get("checkpoint")
+	if err == errNotFound {
+		return nil, client.StatusError(http.StatusNotFound, "GET %s/checkpoint: 404 Not Found", c.uri)
+	} else if err != nil {
+		return nil, err
+	}
+	sth, err := parseCheckpoint(data, c.logID)
+	if err != nil {
+		return nil, client.Errorf(client.ErrorParse, "%s/checkpoint: %s", c.uri, err)
+	}
+	c.mu.Lock()
+	if sth.TreeSize > c.treeSize {
+		c.treeSize = sth.TreeSize
+	}
+	c.mu.Unlock()
+	return sth, nil
+}
+
+// tileWidth returns the number of entries or hashes in tile |index| at
+// |level| when the tree has |treeSize| entries
+func tileWidth(level uint, index uint64, treeSize uint64) int {
+	count := (treeSize >> (8 * level)) - index*TileWidth
+	if count > TileWidth {
+		return TileWidth
+	}
+	return int(count)
+}
+
+// getTile fetches a tile with at least |width| entries.  The partial tile
+// of that width is no longer served once the tile is full, so the full tile
+// is tried if it's not found.
+func (c *Client) getTile(level string, index uint64, width int) ([]byte, error) {
+	data, err := c.get(tilePath(level, index, width))
+	if err == errNotFound && width < TileWidth {
+		data, err = c.get(tilePath(level, index, TileWidth))
+	}
+	if err == errNotFound {
+		return nil, client.StatusError(http.StatusNotFound, "GET %s/%s: 404 Not Found", c.uri, tilePath(level, index, width))
+	}
+	return data, err
+}
+
+// tilePath returns the path of a tile, e.g. tile/0/x001/x234/067.p/8
+func tilePath(level string, index uint64, width int) string {
+	path := "tile/" + level + "/" + encodeTileIndex(index)
+	if width < TileWidth {
+		path += ".p/" + strconv.Itoa(width)
+	}
+	return path
+}
+
+// encodeTileIndex encodes |index| as groups of three digits, all but the
+// last prefixed with an x, e.g. 1234067 as x001/x234/067
+func encodeTileIndex(index uint64) string {
+	str := strconv.FormatUint(index, 10)
+	for len(str)%3 != 0 {
+		str = "0" + str
+	}
+	var groups []string
+	for i := 0; i < len(str); i += 3 {
+		groups = append(groups, "x"+str[i:i+3])
+	}
+	groups[len(groups)-1] = groups[len(groups)-1][1:]
+	return strings.Join(groups, "/")
+}
+
+// GetEntries fetches the entries in [|start|, |end|] from the log.  Only the
+// data tile containing |start| is fetched, so fewer entries may be returned.
+func (c *Client) GetEntries(start, end int64) ([]ct.LogEntry, error) {
+	if start < 0 {
+		return nil, errors.New("GetEntries: start should be >= 0")
+	}
+	if end < start {
+		return nil, errors.New("GetEntries: start should be <= end")
+	}
+	treeSize := c.latestTreeSize()
+	if uint64(end)+1 > treeSize {
+		treeSize = uint64(end) + 1
+	}
+	tileIndex := uint64(start) / TileWidth
+	width := tileWidth(0, tileIndex, treeSize)
+	data, err := c.getTile("data", tileIndex, width)
+	if err != nil {
+		return nil, err
+	}
+	leaves, err := parseDataTile(data)
+	if err != nil {
+		return nil, client.Errorf(client.ErrorParse, "Parsing data tile %d failed: %s", tileIndex, err)
+	}
+	if len(leaves) < width {
+		return nil, client.Errorf(client.ErrorParse, "Data tile %d has %d entries instead of %d", tileIndex, len(leaves), width)
+	}
+
+	first := int(uint64(start) - tileIndex*TileWidth)
+	last := width - 1
+	if int64(tileIndex*TileWidth)+int64(last) > end {
+		last = int(uint64(end) - tileIndex*TileWidth)
+	}
+	entries := make([]ct.LogEntry, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		entry := leaves[i].entry
+		entry.Index = int64(tileIndex*TileWidth) + int64(i)
+		if leaves[i].preCertificate != nil {
+			entry.Chain = append(entry.Chain, leaves[i].preCertificate)
+		}
+		for _, fingerprint := range leaves[i].chain {
+			issuer, err := c.getIssuer(fingerprint)
+			if err != nil {
+				return nil, fmt.Errorf("Error fetching issuer of entry %d: %w", entry.Index, err)
+			}
+			entry.Chain = append(entry.Chain, issuer)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (c *Client) getIssuer(fingerprint [32]byte) (ct.ASN1Cert, error) {
+	c.mu.Lock()
+	issuer, cached := c.issuers[fingerprint]
+	c.mu.Unlock()
+	if cached {
+		return issuer, nil
+	}
+	path := "issuer/" + hex.EncodeToString(fingerprint[:])
+	data, err := c.get(path)
+	if err == errNotFound {
+		return nil, client.StatusError(http.StatusNotFound, "GET %s/%s: 404 Not Found", c.uri, path)
+	} else if err != nil {
+		return nil, err
+	}
+	if sha256.Sum256(data) != fingerprint {
+		return nil, client.Errorf(client.ErrorParse, "%s/%s: issuer doesn't match fingerprint", c.uri, path)
+	}
+	c.mu.Lock()
+	c.issuers[fingerprint] = data
+	c.mu.Unlock()
+	return data, nil
+}
+
+// GetConsistencyProof computes a consistency proof between the trees of
+// size |first| and |second| from the log's hash tiles
+func (c *Client) GetConsistencyProof(first, second int64) (ct.ConsistencyProof, error) {
+	if first < 0 {
+		return nil, errors.New("GetConsistencyProof: first should be >= 0")
+	}
+	if second < first {
+		return nil, errors.New("GetConsistencyProof: first should be <= second")
+	}
+	if first == 0 || first == second {
+		return []ct.MerkleTreeNode{}, nil
+	}
+	return newHashReader(c, uint64(second)).consistencyProof(uint64(first), 0, uint64(second), true)
+}
+
+// GetInclusionProof computes the inclusion proof of the entry at |index|
+// in the tree of size |treeSize| from the log's hash tiles
+func (c *Client) GetInclusionProof(index uint64, treeSize uint64) (ct.AuditPath, error) {
+	if index >= treeSize {
+		return nil, fmt.Errorf("GetInclusionProof: index %d is not in tree of size %d", index, treeSize)
+	}
+	return newHashReader(c, treeSize).inclusionProof(index, 0, treeSize)
+}
+
+// GetAuditProof always fails, since static-ct-api logs can't look up
+// entries by hash.  Use GetInclusionProof instead.
+func (c *Client) GetAuditProof(hash ct.MerkleTreeNode, treeSize uint64) (ct.AuditPath, uint64, error) {
+	return nil, 0, errors.New("Tiled logs don't support looking up entries by hash")
+}
+
+// hashReader computes the hashes of the tree of size |treeSize| from the
+// log's hash tiles, caching the tiles it fetches
+type hashReader struct {
+	client   *Client
+	treeSize uint64
+	tiles    map[string][]byte
+}
+
+func newHashReader(client *Client, treeSize uint64) *hashReader {
+	return &hashReader{client: client, treeSize: treeSize, tiles: make(map[string][]byte)}
+}
+
+// node returns the hash of the complete subtree of height |height| at
+// |index|, which covers entries [|index|<<|height|, (|index|+1)<<|height|)
+func (r *hashReader) node(height uint, index uint64) (ct.MerkleTreeNode, error) {
+	level := height / 8
+	first := index << (height % 8) // in the tiles at level
+	count := uint64(1) << (height % 8)
+	tileIndex := first / TileWidth
+	width := tileWidth(level, tileIndex, r.treeSize)
+
+	levelName := strconv.FormatUint(uint64(level), 10)
+	key := tilePath(levelName, tileIndex, width)
+	tile, cached := r.tiles[key]
+	if !cached {
+		var err error
+		if tile, err = r.client.getTile(levelName, tileIndex, width); err != nil {
+			return nil, err
+		}
+		r.tiles[key] = tile
+	}
+
+	offset := first - tileIndex*TileWidth
+	if uint64(len(tile)) < (offset+count)*sha256.Size {
+		return nil, client.Errorf(client.ErrorParse, "Hash tile %d at level %d is too short", tileIndex, level)
+	}
+	hashes := make([]ct.MerkleTreeNode, count)
+	for i := range hashes {
+		start := (offset + uint64(i)) * sha256.Size
+		hashes[i] = tile[start : start+sha256.Size]
+	}
+	for len(hashes) > 1 {
+		for i := 0; i < len(hashes)/2; i++ {
+			hashes[i] = hashChildren(hashes[2*i], hashes[2*i+1])
+		}
+		hashes = hashes[:len(hashes)/2]
+	}
+	return hashes[0], nil
+}
+
+// split returns the largest power of two less than |n| (see RFC 6962 section 2.1)
+func split(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// subtree returns the hash of the entries in [|start|, |end|)
+func (r *hashReader) subtree(start, end uint64) (ct.MerkleTreeNode, error) {
+	n := end - start
+	if n&(n-1) == 0 && start%n == 0 {
+		height := uint(0)
+		for uint64(1)<<height < n {
+			height++
+		}
+		return r.node(height, start>>height)
+	}
+	k := split(n)
+	left, err := r.subtree(start, start+k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := r.subtree(start+k, end)
+	if err != nil {
+		return nil, err
+	}
+	return hashChildren(left, right), nil
+}
+
+// inclusionProof returns PATH(|index|-|start|, D[|start|:|end|]) from RFC
+// 6962 section 2.1.1
+func (r *hashReader) inclusionProof(index, start, end uint64) ([]ct.MerkleTreeNode, error) {
+	if end-start <= 1 {
+		return []ct.MerkleTreeNode{}, nil
+	}
+	k := split(end - start)
+	var proof []ct.MerkleTreeNode
+	var sibling ct.MerkleTreeNode
+	var err error
+	if index < start+k {
+		if proof, err = r.inclusionProof(index, start, start+k); err != nil {
+			return nil, err
+		}
+		sibling, err = r.subtree(start+k, end)
+	} else {
+		if proof, err = r.inclusionProof(index, start+k, end); err != nil {
+			return nil, err
+		}
+		sibling, err = r.subtree(start, start+k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, sibling), nil
+}
+
+// consistencyProof returns SUBPROOF(|m|-|start|, D[|start|:|end|], |b|)
+// from RFC 6962 section 2.1.2
+func (r *hashReader) consistencyProof(m, start, end uint64, b bool) ([]ct.MerkleTreeNode, error) {
+	if m == end {
+		if b {
+			return []ct.MerkleTreeNode{}, nil
+		}
+		hash, err := r.subtree(start, end)
+		if err != nil {
+			return nil, err
+		}
+		return []ct.MerkleTreeNode{hash}, nil
+	}
+	k := split(end - start)
+	var proof []ct.MerkleTreeNode
+	var sibling ct.MerkleTreeNode
+	var err error
+	if m <= start+k {
+		if proof, err = r.consistencyProof(m, start, start+k, b); err != nil {
+			return nil, err
+		}
+		sibling, err = r.subtree(start+k, end)
+	} else {
+		if proof, err = r.consistencyProof(m, start+k, end, false); err != nil {
+			return nil, err
+		}
+		sibling, err = r.subtree(start, start+k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, sibling), nil
+}
+
+func hashChildren(left ct.MerkleTreeNode, right ct.MerkleTreeNode) ct.MerkleTreeNode {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// LeafIndex returns the index of the entry in the leaf_index extension of an
+// SCT issued by a static-ct-api log, or false if there isn't one
+func LeafIndex(extensions ct.CTExtensions) (uint64, bool) {
+	for len(extensions) >= 3 {
+		extensionType := extensions[0]
+		length := int(extensions[1])<<8 | int(extensions[2])
+		if len(extensions) < 3+length {
+			return 0, false
+		}
+		data := extensions[3 : 3+length]
+		extensions = extensions[3+length:]
+		if extensionType == 0 && length == 5 {
+			var index uint64
+			for _, b := range data {
+				index = index<<8 | uint64(b)
+			}
+			return index, true
+		}
+	}
+	return 0, false
+}
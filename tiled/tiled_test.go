@@ -0,0 +1,317 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package tiled_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/certspotter"
+	"software.sslmate.com/src/certspotter/ct"
+	"software.sslmate.com/src/certspotter/tiled"
+)
+
+// testLog serves a static-ct-api log with |size| entries
+type testLog struct {
+	key      *ecdsa.PrivateKey
+	logID    []byte
+	issuer   []byte
+	tiles    map[string][]byte // by path
+	sths     map[uint64]*ct.SignedTreeHead
+	size     uint64
+	requests []string
+}
+
+func hashLeaf(data []byte) []byte {
+	sum := sha256.Sum256(append([]byte{0x00}, data...))
+	return sum[:]
+}
+
+func hashChildren(left, right []byte) []byte {
+	sum := sha256.Sum256(append(append([]byte{0x01}, left...), right...))
+	return sum[:]
+}
+
+// rootHash computes MTH(hashes) the slow way
+func rootHash(hashes [][]byte) []byte {
+	if len(hashes) == 1 {
+		return hashes[0]
+	}
+	k := 1
+	for k<<1 < len(hashes) {
+		k <<= 1
+	}
+	return hashChildren(rootHash(hashes[:k]), rootHash(hashes[k:]))
+}
+
+func tileName(level string, index uint64, width int) string {
+	var groups []string
+	for index >= 1000 {
+		groups = append([]string{fmt.Sprintf("x%03d", index%1000)}, groups...)
+		index /= 1000
+	}
+	groups = append([]string{fmt.Sprintf("x%03d", index)}, groups...)
+	groups[len(groups)-1] = groups[len(groups)-1][1:]
+	name := "tile/" + level + "/" + strings.Join(groups, "/")
+	if width < tiled.TileWidth {
+		name += ".p/" + strconv.Itoa(width)
+	}
+	return name
+}
+
+func newTestLog(t *testing.T, size uint64) *testLog {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(pkix)
+	log := &testLog{
+		key:    key,
+		logID:  logID[:],
+		issuer: []byte("issuer certificate"),
+		tiles:  make(map[string][]byte),
+		sths:   make(map[uint64]*ct.SignedTreeHead),
+		size:   size,
+	}
+	issuerFingerprint := sha256.Sum256(log.issuer)
+
+	var data []byte
+	var hashes [][]byte
+	for i := uint64(0); i < size; i++ {
+		var entry bytes.Buffer
+		binary.Write(&entry, binary.BigEndian, uint64(1500000000000+i))
+		if i%2 == 0 {
+			cert := []byte(fmt.Sprintf("certificate %d", i))
+			binary.Write(&entry, binary.BigEndian, uint16(ct.X509LogEntryType))
+			entry.Write([]byte{0, 0, byte(len(cert))})
+			entry.Write(cert)
+		} else {
+			tbs := []byte(fmt.Sprintf("tbs %d", i))
+			binary.Write(&entry, binary.BigEndian, uint16(ct.PrecertLogEntryType))
+			entry.Write(make([]byte, 32))
+			entry.Write([]byte{0, 0, byte(len(tbs))})
+			entry.Write(tbs)
+		}
+		entry.Write([]byte{0, 8, 0, 0, 5, 0, 0, 0, byte(i >> 8), byte(i)}) // leaf_index extension
+		hashes = append(hashes, hashLeaf(append([]byte{0, 0}, entry.Bytes()...)))
+
+		data = append(data, entry.Bytes()...)
+		if i%2 == 1 {
+			precert := []byte(fmt.Sprintf("precertificate %d", i))
+			data = append(data, 0, 0, byte(len(precert)))
+			data = append(data, precert...)
+		}
+		data = append(data, 0, 32)
+		data = append(data, issuerFingerprint[:]...)
+		if (i+1)%tiled.TileWidth == 0 || i+1 == size {
+			width := int((i % tiled.TileWidth) + 1)
+			log.tiles[tileName("data", i/tiled.TileWidth, width)] = data
+			data = nil
+		}
+	}
+
+	for level := 0; len(hashes) > 0; level++ {
+		var next [][]byte
+		for start := 0; start < len(hashes); start += tiled.TileWidth {
+			end := start + tiled.TileWidth
+			if end > len(hashes) {
+				end = len(hashes)
+			}
+			var tile []byte
+			for _, hash := range hashes[start:end] {
+				tile = append(tile, hash...)
+			}
+			log.tiles[tileName(strconv.Itoa(level), uint64(start/tiled.TileWidth), end-start)] = tile
+			if end-start == tiled.TileWidth {
+				next = append(next, rootHash(hashes[start:end]))
+			}
+		}
+		hashes = next
+	}
+	return log
+}
+
+func (log *testLog) leafHashes() [][]byte {
+	var hashes [][]byte
+	for i := uint64(0); i < log.size; i += tiled.TileWidth {
+		width := tiled.TileWidth
+		if log.size-i < tiled.TileWidth {
+			width = int(log.size - i)
+		}
+		tile := log.tiles[tileName("0", i/tiled.TileWidth, width)]
+		for j := 0; j < len(tile); j += 32 {
+			hashes = append(hashes, tile[j:j+32])
+		}
+	}
+	return hashes
+}
+
+func (log *testLog) checkpoint(t *testing.T, origin string) []byte {
+	sth := ct.SignedTreeHead{Version: ct.V1, TreeSize: log.size, Timestamp: 1500000001000}
+	copy(sth.SHA256RootHash[:], rootHash(log.leafHashes()))
+	input, err := ct.SerializeSTHSignatureInput(sth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(input)
+	r, s, err := ecdsa.Sign(rand.Reader, log.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	ds, _ := ct.MarshalDigitallySigned(ct.DigitallySigned{HashAlgorithm: ct.SHA256, SignatureAlgorithm: ct.ECDSA, Signature: der})
+
+	keyID := sha256.Sum256(append([]byte(origin+"\n\x05"), log.logID...))
+	var signature bytes.Buffer
+	signature.Write(keyID[:4])
+	binary.Write(&signature, binary.BigEndian, sth.Timestamp)
+	signature.Write(ds)
+
+	return []byte(fmt.Sprintf("%s\n%d\n%s\n\n— witness AAAAAAAA\n— %s %s\n", origin, sth.TreeSize, base64.StdEncoding.EncodeToString(sth.SHA256RootHash[:]), origin, base64.StdEncoding.EncodeToString(signature.Bytes())))
+}
+
+func (log *testLog) serve(t *testing.T) *httptest.Server {
+	checkpoint := log.checkpoint(t, "example.com/log")
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.TrimPrefix(req.URL.Path, "/")
+		log.requests = append(log.requests, path)
+		if path == "checkpoint" {
+			w.Write(checkpoint)
+		} else if tile, exists := log.tiles[path]; exists {
+			w.Write(tile)
+		} else if sum := sha256.Sum256(log.issuer); path == "issuer/"+hex.EncodeToString(sum[:]) {
+			w.Write(log.issuer)
+		} else {
+			http.NotFound(w, req)
+		}
+	}))
+}
+
+func TestClient(t *testing.T) {
+	log := newTestLog(t, 70000)
+	server := log.serve(t)
+	defer server.Close()
+	client := tiled.New(server.URL, log.logID, 0)
+
+	sth, err := client.GetSTH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := ct.NewSignatureVerifier(&log.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifySTHSignature(*sth); err != nil {
+		t.Fatalf("STH signature is invalid: %s", err)
+	}
+	if sth.TreeSize != 70000 || sth.Timestamp != 1500000001000 {
+		t.Fatalf("Wrong STH: %+v", sth)
+	}
+
+	entries, err := client.GetEntries(69900, 69999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 69888 is the start of the last, partial tile
+	if len(entries) != 100 || entries[0].Index != 69900 || entries[99].Index != 69999 {
+		t.Fatalf("Wrong entries: %d", len(entries))
+	}
+	precert := entries[1]
+	if precert.Leaf.TimestampedEntry.EntryType != ct.PrecertLogEntryType || string(precert.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate) != "tbs 69901" {
+		t.Errorf("Wrong precert entry: %+v", precert.Leaf)
+	}
+	if len(precert.Chain) != 2 || string(precert.Chain[0]) != "precertificate 69901" || !bytes.Equal(precert.Chain[1], log.issuer) {
+		t.Errorf("Wrong precert chain: %q", precert.Chain)
+	}
+	if !bytes.Equal(hashLeaf(precert.LeafBytes), log.leafHashes()[69901]) {
+		t.Errorf("Wrong leaf bytes")
+	}
+	if index, ok := tiled.LeafIndex(precert.Leaf.TimestampedEntry.Extensions); !ok || index != 69901&0xffff {
+		t.Errorf("Wrong leaf index: %d %v", index, ok)
+	}
+
+	entries, err = client.GetEntries(250, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 6 || string(entries[0].Leaf.TimestampedEntry.X509Entry) != "certificate 250" || len(entries[0].Chain) != 1 {
+		t.Fatalf("Wrong entries: %d", len(entries))
+	}
+
+	for _, index := range []uint64{0, 255, 256, 65535, 65536, 69887, 69999} {
+		proof, err := client.GetInclusionProof(index, sth.TreeSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !certspotter.VerifyInclusionProof(proof, index, log.leafHashes()[index], sth) {
+			t.Errorf("Inclusion proof for %d doesn't verify", index)
+		}
+	}
+
+	for _, size := range []uint64{1, 256, 1000, 65536, 69999} {
+		first := &ct.SignedTreeHead{TreeSize: size}
+		copy(first.SHA256RootHash[:], rootHash(log.leafHashes()[:size]))
+		proof, err := client.GetConsistencyProof(int64(size), int64(sth.TreeSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !certspotter.VerifyConsistencyProof(proof, first, sth) {
+			t.Errorf("Consistency proof from %d doesn't verify", size)
+		}
+	}
+}
+
+func TestPartialTileReplaced(t *testing.T) {
+	log := newTestLog(t, 300)
+	server := log.serve(t)
+	defer server.Close()
+	client := tiled.New(server.URL, log.logID, 0)
+
+	// Entry 10 was once in partial tile 0.p/11, which has since been
+	// replaced by the full tile
+	entries, err := client.GetEntries(10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Index != 10 {
+		t.Fatalf("Wrong entries: %d", len(entries))
+	}
+	if got := strings.Join(log.requests, " "); !strings.HasPrefix(got, "tile/data/000.p/11 tile/data/000 ") {
+		t.Errorf("Wrong requests: %s", got)
+	}
+}
+
+func TestCheckpointNotSignedByLog(t *testing.T) {
+	log := newTestLog(t, 10)
+	server := log.serve(t)
+	defer server.Close()
+	if _, err := tiled.New(server.URL, make([]byte, 32), 0).GetSTH(); err == nil {
+		t.Errorf("Checkpoint with no signature from the log was accepted")
+	}
+}
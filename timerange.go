@@ -0,0 +1,87 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"fmt"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+func (s *Scanner) getEntryTimestamp(index int64) (time.Time, error) {
+	entries, err := s.logClient.GetEntries(index, index)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(entries) == 0 {
+		return time.Time{}, fmt.Errorf("Log did not return entry %d", index)
+	}
+	return entryTimestamp(&entries[0]), nil
+}
+
+// FindTimestamp uses binary search to find the index of the first entry, in
+// a tree of size |treeSize|, whose leaf timestamp is at or after |t|.  Logs
+// may incorporate entries up to one Maximum Merge Delay after timestamping
+// them, so timestamps are not strictly in order, and the result is only
+// approximate (see ScanTimeRange).
+func (s *Scanner) FindTimestamp(t time.Time, treeSize int64) (int64, error) {
+	low, high := int64(0), treeSize
+	for low < high {
+		mid := low + (high-low)/2
+		timestamp, err := s.getEntryTimestamp(mid)
+		if err != nil {
+			return 0, fmt.Errorf("Error fetching entry %d: %s", mid, err)
+		}
+		if timestamp.Before(t) {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low, nil
+}
+
+// ScanTimeRange scans the entries, in the tree described by |sth|, whose
+// leaf timestamps are in [|start|, |end|).  A zero |start| or |end| means
+// the window is unbounded on that side.
+//
+// An entry timestamped at T is incorporated between T and T+|mmd|, so every
+// entry incorporated before start is timestamped after start-|mmd|, and
+// searching for start-|mmd| finds an index before every entry in the window.
+// Likewise for end+|mmd|.  Entries in the extra margin are skipped.
+//
+// The entries are not checked against the STH, since only part of the log is
+// downloaded.
+func (s *Scanner) ScanTimeRange(start time.Time, end time.Time, mmd time.Duration, sth *ct.SignedTreeHead, processCert ProcessCallback) error {
+	startIndex := int64(0)
+	endIndex := int64(sth.TreeSize)
+	var err error
+	if !start.IsZero() {
+		if startIndex, err = s.FindTimestamp(start.Add(-mmd), endIndex); err != nil {
+			return err
+		}
+	}
+	if !end.IsZero() {
+		if endIndex, err = s.FindTimestamp(end.Add(mmd), endIndex); err != nil {
+			return err
+		}
+	}
+	s.debug("Found entries in time range", "start", startIndex, "end", endIndex)
+	if startIndex >= endIndex {
+		return nil
+	}
+	return s.Scan(startIndex, endIndex, func(scanner *Scanner, entry *ct.LogEntry) {
+		timestamp := entryTimestamp(entry)
+		if (start.IsZero() || !timestamp.Before(start)) && (end.IsZero() || timestamp.Before(end)) {
+			processCert(scanner, entry)
+		}
+	}, nil)
+}
@@ -0,0 +1,256 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Spans are sent once this many have ended, or every FlushInterval
+	BatchSize     = 512
+	FlushInterval = 5 * time.Second
+
+	// Spans which end while this many are waiting to be sent are dropped
+	maxQueuedSpans = 8192
+)
+
+// Exporter sends spans to an OpenTelemetry collector using OTLP/HTTP, with
+// the JSON encoding
+type Exporter struct {
+	url         string // of the traces endpoint, e.g. http://localhost:4318/v1/traces
+	serviceName string
+	httpClient  *http.Client
+
+	spans    chan *Span
+	done     chan struct{}
+	finished sync.WaitGroup
+
+	// Called with errors sending spans; they're ignored if nil
+	OnError func(error)
+}
+
+// NewExporter returns an Exporter which sends spans to the OTLP/HTTP
+// collector at |endpoint|, e.g. http://localhost:4318, as the service
+// |serviceName|.  Spans are POSTed to the /v1/traces path under |endpoint|.
+func NewExporter(endpoint string, serviceName string) *Exporter {
+	e := &Exporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		spans:       make(chan *Span, maxQueuedSpans),
+		done:        make(chan struct{}),
+	}
+	e.finished.Add(1)
+	go e.run()
+	return e
+}
+
+func (e *Exporter) add(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+		// The collector isn't keeping up; tracing mustn't slow the scan
+	}
+}
+
+// Close sends the spans which haven't been sent yet, and stops the exporter
+func (e *Exporter) Close() {
+	close(e.done)
+	e.finished.Wait()
+}
+
+func (e *Exporter) run() {
+	defer e.finished.Done()
+	ticker := time.NewTicker(FlushInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) < BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.done:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			e.send(batch)
+			return
+		}
+		e.send(batch)
+		batch = nil
+	}
+}
+
+func (e *Exporter) send(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := e.post(batch); err != nil && e.OnError != nil {
+		e.OnError(err)
+	}
+}
+
+func (e *Exporter) post(batch []*Span) error {
+	body, err := json.Marshal(makeTracesRequest(e.serviceName, batch))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("POST %s: %s", e.url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "certspotter")
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("POST %s: %s", e.url, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s (%s)", e.url, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// The OTLP ExportTraceServiceRequest, in its JSON encoding, in which IDs are
+// hex and 64-bit integers are strings
+type tracesRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource struct {
+		Attributes []keyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"` // 2 for an error
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func makeValue(value interface{}) anyValue {
+	var v anyValue
+	intValue := func(s string) anyValue { return anyValue{IntValue: &s} }
+	switch value := value.(type) {
+	case bool:
+		v.BoolValue = &value
+	case int:
+		return intValue(strconv.FormatInt(int64(value), 10))
+	case int64:
+		return intValue(strconv.FormatInt(value, 10))
+	case uint64:
+		return intValue(strconv.FormatUint(value, 10))
+	case float64:
+		v.DoubleValue = &value
+	case []byte:
+		s := hex.EncodeToString(value)
+		v.StringValue = &s
+	case error:
+		s := value.Error()
+		v.StringValue = &s
+	case fmt.Stringer:
+		s := value.String()
+		v.StringValue = &s
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return v
+}
+
+func makeAttributes(fields []interface{}) []keyValue {
+	var attributes []keyValue
+	for i := 0; i+1 < len(fields); i += 2 {
+		key, ok := fields[i].(string)
+		if !ok {
+			key = "!BADKEY"
+		}
+		attributes = append(attributes, keyValue{Key: key, Value: makeValue(fields[i+1])})
+	}
+	return attributes
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func makeTracesRequest(serviceName string, batch []*Span) *tracesRequest {
+	var scope scopeSpans
+	scope.Scope.Name = "software.sslmate.com/src/certspotter"
+	for _, s := range batch {
+		converted := span{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: unixNano(s.start),
+			EndTimeUnixNano:   unixNano(s.end),
+			Attributes:        makeAttributes(s.fields),
+		}
+		if s.parentID != [8]byte{} {
+			converted.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			converted.Status = &status{Code: 2, Message: s.err.Error()}
+		}
+		scope.Spans = append(scope.Spans, converted)
+	}
+	var resource resourceSpans
+	resource.Resource.Attributes = makeAttributes([]interface{}{"service.name", serviceName})
+	resource.ScopeSpans = []scopeSpans{scope}
+	return &tracesRequest{ResourceSpans: []resourceSpans{resource}}
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+// Package tracing records spans, which time operations such as fetching
+// entries from a log, and exports them to an OpenTelemetry collector using
+// OTLP over HTTP.  Tracing is off until Enable is called; until then, no
+// spans are made, and the methods of a nil *Span do nothing, so code can be
+// instrumented unconditionally.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	mathrand "math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// The kinds of span
+const (
+	KindInternal = 1
+	KindClient   = 3 // a request to another service, such as a log
+)
+
+// Span is a timed operation in a trace.  A Span's methods mustn't be called
+// concurrently, but its children may be started and ended by other
+// goroutines.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // zero for the root of a trace
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	fields   []interface{} // alternating keys and values
+	err      error
+}
+
+// The *Exporter which spans are sent to, or a nil one if tracing isn't
+// enabled.  It's checked for every entry scanned, so it's an atomic.Value
+// rather than behind a mutex.
+var exporter atomic.Value
+
+func currentExporter() *Exporter {
+	e, _ := exporter.Load().(*Exporter)
+	return e
+}
+
+// Enabled returns true if spans are being recorded
+func Enabled() bool {
+	return currentExporter() != nil
+}
+
+// Enable starts recording spans and sending them to |e|
+func Enable(e *Exporter) {
+	exporter.Store(e)
+}
+
+// Disable stops recording spans, and sends the ones which haven't been sent
+// yet
+func Disable() {
+	e := currentExporter()
+	exporter.Store((*Exporter)(nil))
+	if e != nil {
+		e.Close()
+	}
+}
+
+// Sample returns true with probability |rate|, if tracing is enabled, for
+// deciding whether to trace frequent operations
+func Sample(rate float64) bool {
+	return rate > 0 && Enabled() && mathrand.Float64() < rate
+}
+
+func randomID(id []byte) {
+	if _, err := rand.Read(id); err != nil {
+		// Fall back on math/rand; IDs needn't be unpredictable
+		for i := 0; i < len(id); i += 8 {
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], uint64(mathrand.Int63()))
+			copy(id[i:], buf[:])
+		}
+	}
+}
+
+// Start starts a span named |name|, with |fields| (alternating keys and
+// values) as its attributes.  It's a child of |parent|, or the root of a new
+// trace if |parent| is nil.  Returns nil if tracing isn't enabled.
+func Start(parent *Span, name string, fields ...interface{}) *Span {
+	if !Enabled() {
+		return nil
+	}
+	span := &Span{name: name, kind: KindInternal, start: time.Now(), fields: fields}
+	if parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		randomID(span.traceID[:])
+	}
+	randomID(span.spanID[:])
+	return span
+}
+
+// Child starts a child of |parent|, like Start.  Returns nil if |parent| is
+// nil, so that the children of an operation which isn't traced aren't either.
+func (parent *Span) Child(name string, fields ...interface{}) *Span {
+	if parent == nil {
+		return nil
+	}
+	return Start(parent, name, fields...)
+}
+
+// SetKind sets the kind of the span, e.g. KindClient
+func (span *Span) SetKind(kind int) {
+	if span != nil {
+		span.kind = kind
+	}
+}
+
+// SetFields adds |fields| (alternating keys and values) to the span's
+// attributes
+func (span *Span) SetFields(fields ...interface{}) {
+	if span != nil {
+		span.fields = append(span.fields, fields...)
+	}
+}
+
+// SetError marks the span as failed with |err|, unless |err| is nil
+func (span *Span) SetError(err error) {
+	if span != nil && err != nil {
+		span.err = err
+	}
+}
+
+// End ends the span, and queues it to be exported
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+	span.end = time.Now()
+	if e := currentExporter(); e != nil {
+		e.add(span)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestDisabled(t *testing.T) {
+	span := Start(nil, "Test")
+	if span != nil {
+		t.Fatalf("Span was started with tracing disabled")
+	}
+	// These mustn't panic
+	span.Child("Child").End()
+	span.SetFields("key", "value")
+	span.SetError(errors.New("error"))
+	span.End()
+	if Sample(1) {
+		t.Errorf("Sampled with tracing disabled")
+	}
+}
+
+func TestExport(t *testing.T) {
+	var mu sync.Mutex
+	var requests []tracesRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" || req.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "wrong request", 400)
+			return
+		}
+		var request tracesRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, request)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	exporter := NewExporter(server.URL+"/", "test-service")
+	exporter.OnError = func(err error) { t.Error(err) }
+	Enable(exporter)
+	root := Start(nil, "Scan", "log", "https://ct.example.com", "start", int64(10))
+	child := root.Child("GetEntries", "entries", 5)
+	child.SetKind(KindClient)
+	child.SetError(errors.New("HTTP 500"))
+	child.End()
+	root.SetFields("stopped", true)
+	root.End()
+	Disable()
+
+	if Enabled() {
+		t.Fatalf("Still enabled after Disable")
+	}
+	if len(requests) != 1 {
+		t.Fatalf("Wrong number of requests: %d", len(requests))
+	}
+	resource := requests[0].ResourceSpans[0]
+	if attr := resource.Resource.Attributes[0]; attr.Key != "service.name" || *attr.Value.StringValue != "test-service" {
+		t.Errorf("Wrong resource attribute: %+v", attr)
+	}
+	spans := resource.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Wrong number of spans: %d", len(spans))
+	}
+	childSpan, rootSpan := spans[0], spans[1]
+	if rootSpan.Name != "Scan" || rootSpan.ParentSpanID != "" || rootSpan.Kind != KindInternal || rootSpan.Status != nil || len(rootSpan.TraceID) != 32 {
+		t.Errorf("Wrong root span: %+v", rootSpan)
+	}
+	if childSpan.TraceID != rootSpan.TraceID || childSpan.ParentSpanID != rootSpan.SpanID || childSpan.Kind != KindClient {
+		t.Errorf("Child span isn't a child of the root: %+v", childSpan)
+	}
+	if childSpan.Status == nil || childSpan.Status.Code != 2 || childSpan.Status.Message != "HTTP 500" {
+		t.Errorf("Wrong child status: %+v", childSpan.Status)
+	}
+	if len(rootSpan.Attributes) != 3 || *rootSpan.Attributes[1].Value.IntValue != "10" || !*rootSpan.Attributes[2].Value.BoolValue {
+		t.Errorf("Wrong root attributes: %+v", rootSpan.Attributes)
+	}
+	if rootSpan.EndTimeUnixNano < rootSpan.StartTimeUnixNano {
+		t.Errorf("Span ends before it starts")
+	}
+}
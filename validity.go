@@ -0,0 +1,125 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"fmt"
+	"time"
+
+	"software.sslmate.com/src/certspotter/ct"
+)
+
+const MatchValidity = "validity"
+
+const (
+	// The CA/Browser Forum Baseline Requirements permit backdating of up
+	// to 48 hours
+	DefaultMaxBackdate = 48 * time.Hour
+
+	// Allowance for clock skew between the CA and the log
+	DefaultMaxPostdate = 1 * time.Hour
+)
+
+// The longest validity periods permitted by the Baseline Requirements for
+// subscriber certificates, by the notBefore date from which each applies,
+// latest first (ballots SC-31 and SC-081)
+var brMaxValidities = []struct {
+	from        time.Time
+	maxValidity time.Duration
+}{
+	{time.Date(2029, time.March, 15, 0, 0, 0, 0, time.UTC), 47 * 24 * time.Hour},
+	{time.Date(2027, time.March, 15, 0, 0, 0, 0, time.UTC), 100 * 24 * time.Hour},
+	{time.Date(2026, time.March, 15, 0, 0, 0, 0, time.UTC), 200 * 24 * time.Hour},
+	{time.Date(2020, time.September, 1, 0, 0, 0, 0, time.UTC), 398 * 24 * time.Hour},
+	{time.Time{}, 825 * 24 * time.Hour},
+}
+
+// BRMaxValidity returns the longest validity period permitted by the
+// Baseline Requirements for a subscriber certificate whose notBefore is
+// |notBefore|
+func BRMaxValidity(notBefore time.Time) time.Duration {
+	for _, limit := range brMaxValidities {
+		if !notBefore.Before(limit.from) {
+			return limit.maxValidity
+		}
+	}
+	return brMaxValidities[len(brMaxValidities)-1].maxValidity
+}
+
+// ValidityFilter matches certificates whose validity period is anomalous,
+// which is a strong sign of misissuance.  The log entry's timestamp is used
+// as the time of issuance, so the backdating check is only done for
+// precertificates, which are logged when they're issued.
+type ValidityFilter struct {
+	MaxValidity time.Duration // longest permitted validity period, or 0 for BRMaxValidity
+	MaxBackdate time.Duration // how far before issuance notBefore may be
+	MaxPostdate time.Duration // how far after issuance notBefore may be
+}
+
+func NewValidityFilter() *ValidityFilter {
+	return &ValidityFilter{
+		MaxBackdate: DefaultMaxBackdate,
+		MaxPostdate: DefaultMaxPostdate,
+	}
+}
+
+func entryTimestamp(entry *ct.LogEntry) time.Time {
+	timestamp := entry.Leaf.TimestampedEntry.Timestamp
+	return time.Unix(int64(timestamp/1000), int64(timestamp%1000)*1000000)
+}
+
+func formatDays(d time.Duration) string {
+	return fmt.Sprintf("%.1f days", d.Hours()/24)
+}
+
+func (filter *ValidityFilter) maxValidity(validity *CertValidity) time.Duration {
+	if filter.MaxValidity != 0 {
+		return filter.MaxValidity
+	}
+	return BRMaxValidity(validity.NotBefore)
+}
+
+// Anomalies returns a description of each anomaly in |validity|, given that
+// the certificate was logged at |logged|.  The period isn't checked for CA
+// certificates, which aren't subject to the same limit.
+func (filter *ValidityFilter) Anomalies(validity *CertValidity, logged time.Time, isPrecert bool, isCA bool) []string {
+	var anomalies []string
+	// RFC 5280 validity periods include both the notBefore and notAfter seconds
+	period := validity.NotAfter.Sub(validity.NotBefore) + time.Second
+	if period <= 0 {
+		anomalies = append(anomalies, "notAfter is before notBefore")
+	} else if maxValidity := filter.maxValidity(validity); period > maxValidity && !isCA {
+		anomalies = append(anomalies, "validity period of "+formatDays(period)+" exceeds "+formatDays(maxValidity))
+	}
+	if isPrecert {
+		if backdate := logged.Sub(validity.NotBefore); backdate > filter.MaxBackdate {
+			anomalies = append(anomalies, "notBefore is backdated by "+formatDays(backdate))
+		}
+	}
+	if postdate := validity.NotBefore.Sub(logged); postdate > filter.MaxPostdate {
+		anomalies = append(anomalies, "notBefore is "+formatDays(postdate)+" after the certificate was logged")
+	}
+	return anomalies
+}
+
+func (filter *ValidityFilter) Match(info *EntryInfo) []Match {
+	if info.CertInfo == nil {
+		return nil
+	}
+	if info.CertInfo.ValidityParseError != nil {
+		return []Match{{Category: MatchValidity, ID: MatchValidity, Value: info.CertInfo.ValidityParseError.Error()}}
+	}
+	isCA := info.CertInfo.IsCAParseError == nil && info.CertInfo.IsCA != nil && *info.CertInfo.IsCA
+	var matches []Match
+	for _, anomaly := range filter.Anomalies(info.CertInfo.Validity, entryTimestamp(info.Entry), info.IsPrecert, isCA) {
+		matches = append(matches, Match{Category: MatchValidity, ID: MatchValidity, Value: anomaly})
+	}
+	return matches
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidityAnomalies(t *testing.T) {
+	filter := NewValidityFilter()
+	logged := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		notBefore time.Time
+		notAfter  time.Time
+		isPrecert bool
+		isCA      bool
+		anomalies int
+	}{
+		{logged.Add(-1 * time.Hour), logged.Add(90 * day), true, false, 0},
+		{logged.Add(-1 * time.Hour), logged.Add(398*day - 1*time.Hour - time.Second), true, false, 0},
+		{logged.Add(-1 * time.Hour), logged.Add(398 * day), true, false, 1},
+		{logged.Add(-1 * time.Hour), logged.Add(3650 * day), true, true, 0},
+		{logged.Add(-3 * day), logged.Add(90 * day), true, false, 1},
+		{logged.Add(-3 * day), logged.Add(90 * day), false, false, 0},
+		{logged.Add(2 * day), logged.Add(90 * day), false, false, 1},
+		{logged, logged.Add(-1 * day), true, false, 1},
+	}
+	for i, test := range tests {
+		validity := &CertValidity{NotBefore: test.notBefore, NotAfter: test.notAfter}
+		if anomalies := filter.Anomalies(validity, logged, test.isPrecert, test.isCA); len(anomalies) != test.anomalies {
+			t.Errorf("test %d: expected %d anomalies, got %v", i, test.anomalies, anomalies)
+		}
+	}
+}
+
+func TestValidityAnomaliesSchedule(t *testing.T) {
+	filter := NewValidityFilter()
+	day := 24 * time.Hour
+
+	tests := []struct {
+		notBefore time.Time
+		period    time.Duration
+		anomalies int
+	}{
+		{time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC), 825 * day, 0},
+		{time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC), 826 * day, 1},
+		{time.Date(2020, 8, 31, 23, 59, 59, 0, time.UTC), 825 * day, 0},
+		{time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC), 398 * day, 0},
+		{time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC), 399 * day, 1},
+		{time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), 200 * day, 0},
+		{time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), 201 * day, 1},
+		{time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), 398 * day, 1},
+		{time.Date(2027, 6, 1, 0, 0, 0, 0, time.UTC), 100 * day, 0},
+		{time.Date(2027, 6, 1, 0, 0, 0, 0, time.UTC), 101 * day, 1},
+		{time.Date(2027, 6, 1, 0, 0, 0, 0, time.UTC), 200 * day, 1},
+	}
+	for i, test := range tests {
+		validity := &CertValidity{NotBefore: test.notBefore, NotAfter: test.notBefore.Add(test.period - time.Second)}
+		if anomalies := filter.Anomalies(validity, test.notBefore, true, false); len(anomalies) != test.anomalies {
+			t.Errorf("test %d: expected %d anomalies, got %v", i, test.anomalies, anomalies)
+		}
+	}
+
+	filter.MaxValidity = 398 * day
+	validity := &CertValidity{NotBefore: tests[0].notBefore, NotAfter: tests[0].notBefore.Add(825*day - time.Second)}
+	if anomalies := filter.Anomalies(validity, tests[0].notBefore, true, false); len(anomalies) != 1 {
+		t.Errorf("explicit MaxValidity was not used: %v", anomalies)
+	}
+}
@@ -0,0 +1,485 @@
+// Copyright (C) 2016-2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+type WatchlistItem struct {
+	ID           string // identifies the item in matches; defaults to Pattern
+	Pattern      string // as originally written
+	Domain       []string
+	AcceptSuffix bool           // also match subdomains of Domain
+	Regexp       *regexp.Regexp // for regular expression items, instead of Domain
+	IPNet        *net.IPNet     // for IP address and CIDR items, instead of Domain
+
+	// Domain is followed by any public suffix, according to the Public
+	// Suffix List (e.g. example matches example.com and example.co.uk)
+	AnyPublicSuffix bool
+
+	// Email items match email addresses whose domain matches Domain, and
+	// whose local part equals Mailbox (or any local part, if it's empty)
+	Email   bool
+	Mailbox string
+
+	// URI items match URIs which start with URIPrefix, or if it's empty,
+	// whose host matches Domain
+	URI       bool
+	URIPrefix string
+}
+
+// A Watchlist matches entries containing DNS names that are on the list.
+//
+// Each item is one of:
+//
+//	www.example.com		matches only www.example.com
+//	.example.com		matches example.com and all of its subdomains
+//	*.example.com		matches all subdomains of example.com, but not example.com
+//	example.*		matches example followed by any suffix (example.com, example.co.uk)
+//	paypal*.com		matches paypal.com, paypal-login.com, etc.
+//	example.+		matches example under any public suffix (example.com,
+//				example.co.uk, but not example.example.com), according
+//				to the Public Suffix List
+//	.example.+		matches the above and all of their subdomains
+//	/regexp/		matches DNS names matched by regexp, which is anchored
+//				at both ends and case-insensitive
+//	192.0.2.1		matches the IP address 192.0.2.1 (IPv6 addresses work too)
+//	192.0.2.0/24		matches IP addresses in 192.0.2.0/24
+//	user@example.com	matches the email address user@example.com
+//	@.example.com		matches email addresses at example.com or any of its
+//				subdomains (any domain pattern may follow the @)
+//	uri:.example.com	matches URIs whose host is example.com or any of its
+//				subdomains (any domain pattern may follow uri:)
+//	uri:spiffe://example.com/	matches URIs starting with spiffe://example.com/
+//	.			matches everything
+//
+// A label consisting only of * matches one or more labels, and a * within a
+// label matches zero or more characters in that label.
+type Watchlist struct {
+	Items []WatchlistItem
+}
+
+func isRegexpPattern(str string) bool {
+	return len(str) >= 2 && strings.HasPrefix(str, "/") && strings.HasSuffix(str, "/")
+}
+
+// ParseWatchlistItem parses a single watchlist pattern (see Watchlist)
+func ParseWatchlistItem(str string) (WatchlistItem, error) {
+	if str == "." { // "." as in root zone (matches everything)
+		return WatchlistItem{
+			ID:           str,
+			Pattern:      str,
+			Domain:       []string{},
+			AcceptSuffix: true,
+		}, nil
+	} else if isRegexpPattern(str) {
+		re, err := regexp.Compile("(?i)^(?:" + str[1:len(str)-1] + ")$")
+		if err != nil {
+			return WatchlistItem{}, fmt.Errorf("Invalid regular expression `%s': %s", str, err)
+		}
+		return WatchlistItem{
+			ID:      str,
+			Pattern: str,
+			Regexp:  re,
+		}, nil
+	} else if strings.HasPrefix(str, "uri:") {
+		return parseURIItem(str)
+	} else if ipnet := parseIPNet(str); ipnet != nil {
+		return WatchlistItem{
+			ID:      str,
+			Pattern: str,
+			IPNet:   ipnet,
+		}, nil
+	} else if strings.Contains(str, "/") {
+		return WatchlistItem{}, fmt.Errorf("Invalid CIDR range `%s'", str)
+	} else if at := strings.LastIndex(str, "@"); at != -1 {
+		item, err := parseDomainItem(str, str[at+1:])
+		if err != nil {
+			return WatchlistItem{}, err
+		}
+		item.Email = true
+		item.Mailbox = str[:at]
+		return item, nil
+	} else {
+		return parseDomainItem(str, str)
+	}
+}
+
+// parseDomainItem parses the domain pattern |str|, which is part of |pattern|
+func parseDomainItem(pattern string, str string) (WatchlistItem, error) {
+	if str == "." {
+		return WatchlistItem{
+			ID:           pattern,
+			Pattern:      pattern,
+			Domain:       []string{},
+			AcceptSuffix: true,
+		}, nil
+	}
+	acceptSuffix := false
+	if strings.HasPrefix(str, ".") {
+		acceptSuffix = true
+		str = str[1:]
+	}
+	anyPublicSuffix := false
+	if strings.HasSuffix(str, ".+") {
+		anyPublicSuffix = true
+		str = str[:len(str)-2]
+	}
+	asciiDomain, err := NormalizeDNSName(str)
+	if err != nil {
+		return WatchlistItem{}, fmt.Errorf("Invalid domain `%s': %s", str, err)
+	}
+	if asciiDomain == "" {
+		return WatchlistItem{}, fmt.Errorf("Invalid domain `%s': empty", pattern)
+	}
+	return WatchlistItem{
+		ID:              pattern,
+		Pattern:         pattern,
+		Domain:          strings.Split(asciiDomain, "."),
+		AcceptSuffix:    acceptSuffix,
+		AnyPublicSuffix: anyPublicSuffix,
+	}, nil
+}
+
+// parseURIItem parses a uri: item, which is either a URI prefix or a domain
+// pattern for the URI's host
+func parseURIItem(pattern string) (WatchlistItem, error) {
+	str := strings.TrimPrefix(pattern, "uri:")
+	if strings.Contains(str, "://") {
+		return WatchlistItem{
+			ID:        pattern,
+			Pattern:   pattern,
+			URI:       true,
+			URIPrefix: normalizeURI(str),
+		}, nil
+	}
+	item, err := parseDomainItem(pattern, str)
+	if err != nil {
+		return WatchlistItem{}, err
+	}
+	item.URI = true
+	return item, nil
+}
+
+// parseIPNet parses an IP address or CIDR range, returning nil if |str| is neither
+func parseIPNet(str string) *net.IPNet {
+	if ip := net.ParseIP(str); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+	}
+	if _, ipnet, err := net.ParseCIDR(str); err == nil {
+		return ipnet
+	}
+	return nil
+}
+
+// Add parses |pattern| and adds it to the watchlist
+func (watchlist *Watchlist) Add(pattern string) error {
+	return watchlist.AddWithID(pattern, "")
+}
+
+// AddWithID is like Add, but matches of the item are identified by |id|
+// instead of the pattern
+func (watchlist *Watchlist) AddWithID(pattern string, id string) error {
+	item, err := ParseWatchlistItem(pattern)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		item.ID = id
+	}
+	watchlist.Items = append(watchlist.Items, item)
+	return nil
+}
+
+// AddDomain adds |domain| to the watchlist, along with its subdomains if
+// |includeSubdomains| is true
+func (watchlist *Watchlist) AddDomain(domain string, includeSubdomains bool) error {
+	if includeSubdomains && !strings.HasPrefix(domain, ".") {
+		domain = "." + domain
+	}
+	return watchlist.Add(domain)
+}
+
+// ParseWatchlistLine splits a watchlist line into the pattern and the
+// (optional) ID following it
+func ParseWatchlistLine(line string) (string, string, error) {
+	if strings.HasPrefix(line, "/") {
+		// Regular expressions may contain spaces, so the ID is whatever
+		// follows the closing slash
+		end := strings.LastIndex(line, "/")
+		if end == 0 {
+			return "", "", fmt.Errorf("Unterminated regular expression `%s'", line)
+		}
+		return line[:end+1], strings.TrimSpace(line[end+1:]), nil
+	}
+	fields := strings.Fields(line)
+	switch len(fields) {
+	case 1:
+		return fields[0], "", nil
+	case 2:
+		return fields[0], fields[1], nil
+	default:
+		return "", "", fmt.Errorf("Invalid watchlist item `%s': too many fields", line)
+	}
+}
+
+// ReadWatchlist reads a watchlist with one item per line.  Each item may be
+// followed by whitespace and an ID.  Empty lines and lines starting with #
+// are ignored.
+func ReadWatchlist(reader io.Reader) (*Watchlist, error) {
+	watchlist := new(Watchlist)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, id, err := ParseWatchlistLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if err := watchlist.AddWithID(pattern, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return watchlist, nil
+}
+
+// LoadWatchlist reads a watchlist from the file named by |filename|, or from
+// stdin if |filename| is "-"
+func LoadWatchlist(filename string) (*Watchlist, error) {
+	if filename == "-" {
+		watchlist, err := ReadWatchlist(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("(stdin): %s", err)
+		}
+		return watchlist, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	watchlist, err := ReadWatchlist(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return watchlist, nil
+}
+
+func dnsLabelMatches(certLabel string, watchLabel string) bool {
+	// For fail-safe behavior, if a label was unparsable, it matches everything.
+	// Similarly, redacted labels match everything, since the label _might_ be
+	// for a name we're interested in.
+
+	return certLabel == "*" ||
+		certLabel == "?" ||
+		certLabel == UnparsableDNSLabelPlaceholder ||
+		MatchesWildcard(watchLabel, certLabel) ||
+		(strings.Contains(watchLabel, "*") && MatchesWildcard(certLabel, watchLabel))
+}
+
+func dnsNameMatches(dnsName []string, watchDomain []string, acceptSuffix bool) bool {
+	for len(dnsName) > 0 && len(watchDomain) > 0 {
+		certLabel := dnsName[len(dnsName)-1]
+		watchLabel := watchDomain[len(watchDomain)-1]
+
+		if watchLabel == "*" {
+			// Matches one or more labels
+			for n := 1; n <= len(dnsName); n++ {
+				if dnsNameMatches(dnsName[:len(dnsName)-n], watchDomain[:len(watchDomain)-1], acceptSuffix) {
+					return true
+				}
+			}
+			return false
+		}
+		if !dnsLabelMatches(certLabel, watchLabel) {
+			return false
+		}
+
+		dnsName = dnsName[:len(dnsName)-1]
+		watchDomain = watchDomain[:len(watchDomain)-1]
+	}
+
+	return len(watchDomain) == 0 && (acceptSuffix || len(dnsName) == 0)
+}
+
+// MatchDNSName returns the first item on the watchlist that matches |dnsName|,
+// or nil.  |dnsName| is normalized first (see NormalizeDNSName), so it may be
+// in Unicode or ASCII form.
+func (watchlist *Watchlist) MatchDNSName(dnsName string) *WatchlistItem {
+	dnsName = normalizeDomainPart(dnsName)
+	// Regular expressions may be written in terms of either form
+	unicodeName, err := idna.ToUnicode(dnsName)
+	if err != nil {
+		unicodeName = dnsName
+	}
+
+	labels := strings.Split(dnsName, ".")
+	for i := range watchlist.Items {
+		item := &watchlist.Items[i]
+		if item.IPNet != nil || item.Email || item.URI {
+			continue
+		} else if item.Regexp != nil {
+			if item.Regexp.MatchString(dnsName) || item.Regexp.MatchString(unicodeName) {
+				return item
+			}
+		} else if item.matchesDomain(dnsName, labels) {
+			return item
+		}
+	}
+	return nil
+}
+
+func (item *WatchlistItem) matchesDomain(dnsName string, labels []string) bool {
+	if item.AnyPublicSuffix {
+		nameLabels := withoutPublicSuffix(dnsName)
+		return nameLabels != nil && dnsNameMatches(nameLabels, item.Domain, item.AcceptSuffix)
+	}
+	return dnsNameMatches(labels, item.Domain, item.AcceptSuffix)
+}
+
+// normalizeDomainPart normalizes a DNS name for matching against a domain
+// item.  Names which NormalizeDNSName rejects are only case-folded.
+func normalizeDomainPart(dnsName string) string {
+	if normalized, err := NormalizeDNSName(dnsName); err == nil {
+		return normalized
+	}
+	return strings.ToLower(trimTrailingDots(dnsName))
+}
+
+// MatchEmailAddress returns the first email item on the watchlist that
+// matches |address|, or nil
+func (watchlist *Watchlist) MatchEmailAddress(address string) *WatchlistItem {
+	at := strings.LastIndex(address, "@")
+	if at == -1 {
+		return nil
+	}
+	mailbox := address[:at]
+	domain := normalizeDomainPart(address[at+1:])
+	labels := strings.Split(domain, ".")
+	for i := range watchlist.Items {
+		item := &watchlist.Items[i]
+		if item.Email && (item.Mailbox == "" || strings.EqualFold(item.Mailbox, mailbox)) && item.matchesDomain(domain, labels) {
+			return item
+		}
+	}
+	return nil
+}
+
+// normalizeURI lower-cases the scheme and host of |uri|, which are
+// case-insensitive
+func normalizeURI(uri string) string {
+	schemeEnd := strings.Index(uri, "://")
+	if schemeEnd == -1 {
+		return uri
+	}
+	authorityEnd := len(uri)
+	if end := strings.IndexAny(uri[schemeEnd+3:], "/?#"); end != -1 {
+		authorityEnd = schemeEnd + 3 + end
+	}
+	authority := uri[schemeEnd+3 : authorityEnd]
+	userinfoEnd := strings.LastIndex(authority, "@") + 1
+	return strings.ToLower(uri[:schemeEnd+3]) + authority[:userinfoEnd] + strings.ToLower(authority[userinfoEnd:]) + uri[authorityEnd:]
+}
+
+// MatchURI returns the first URI item on the watchlist that matches |uri|,
+// or nil
+func (watchlist *Watchlist) MatchURI(uri string) *WatchlistItem {
+	normalized := normalizeURI(uri)
+	var host string
+	if u, err := url.Parse(uri); err == nil {
+		host = normalizeDomainPart(u.Hostname())
+	}
+	labels := strings.Split(host, ".")
+	for i := range watchlist.Items {
+		item := &watchlist.Items[i]
+		if !item.URI {
+			continue
+		} else if item.URIPrefix != "" {
+			if strings.HasPrefix(normalized, item.URIPrefix) {
+				return item
+			}
+		} else if host != "" && item.matchesDomain(host, labels) {
+			return item
+		}
+	}
+	return nil
+}
+
+// withoutPublicSuffix returns the labels of |dnsName| that precede its public
+// suffix, or nil if |dnsName| is itself a public suffix
+func withoutPublicSuffix(dnsName string) []string {
+	suffix, _ := publicsuffix.PublicSuffix(dnsName)
+	if len(dnsName) <= len(suffix)+1 {
+		return nil
+	}
+	return strings.Split(dnsName[:len(dnsName)-len(suffix)-1], ".")
+}
+
+// MatchIPAddress returns the first item on the watchlist that contains |ipaddr|, or nil
+func (watchlist *Watchlist) MatchIPAddress(ipaddr net.IP) *WatchlistItem {
+	for i := range watchlist.Items {
+		if item := &watchlist.Items[i]; item.IPNet != nil && item.IPNet.Contains(ipaddr) {
+			return item
+		}
+	}
+	return nil
+}
+
+func (watchlist *Watchlist) Match(info *EntryInfo) []Match {
+	// Fail safe behavior: if info.Identifiers is nil (which is caused by a
+	// parse error), report the certificate because we can't say for sure it
+	// doesn't match a domain we care about.  We try very hard to make sure
+	// parsing identifiers always succeeds, so false alarms should be rare.
+	if info.Identifiers == nil {
+		return []Match{{Category: MatchUnparsable}}
+	}
+
+	var matches []Match
+	for _, dnsName := range info.Identifiers.DNSNames {
+		if item := watchlist.MatchDNSName(dnsName); item != nil {
+			matches = append(matches, Match{Category: MatchDNSName, ID: item.ID, Pattern: item.Pattern, Value: dnsName, Anomalies: info.Identifiers.DNSNameAnomalies[dnsName]})
+		}
+	}
+	for _, ipaddr := range info.Identifiers.IPAddrs {
+		if item := watchlist.MatchIPAddress(ipaddr); item != nil {
+			matches = append(matches, Match{Category: MatchIPAddress, ID: item.ID, Pattern: item.Pattern, Value: ipaddr.String()})
+		}
+	}
+	for _, address := range info.Identifiers.EmailAddrs {
+		if item := watchlist.MatchEmailAddress(address); item != nil {
+			matches = append(matches, Match{Category: MatchEmailAddress, ID: item.ID, Pattern: item.Pattern, Value: address})
+		}
+	}
+	for _, uri := range info.Identifiers.URIs {
+		if item := watchlist.MatchURI(uri); item != nil {
+			matches = append(matches, Match{Category: MatchURI, ID: item.ID, Pattern: item.Pattern, Value: uri})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func doWatchlistTest(t *testing.T, watchlist *Watchlist, dnsName string, expected string) {
+	item := watchlist.MatchDNSName(dnsName)
+	if item == nil && expected != "" {
+		t.Errorf("%q doesn't match, but should match %q", dnsName, expected)
+	} else if item != nil && item.Pattern != expected {
+		t.Errorf("%q matches %q, but should match %q", dnsName, item.Pattern, expected)
+	}
+}
+
+func TestWatchlist(t *testing.T) {
+	watchlist, err := ReadWatchlist(strings.NewReader("# comment\n\nwww.example.com\n.example.org\nEXAMPLE.NET.\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doWatchlistTest(t, watchlist, "www.example.com", "www.example.com")
+	doWatchlistTest(t, watchlist, "example.com", "")
+	doWatchlistTest(t, watchlist, "a.www.example.com", "")
+	doWatchlistTest(t, watchlist, "example.org", ".example.org")
+	doWatchlistTest(t, watchlist, "a.b.example.org", ".example.org")
+	doWatchlistTest(t, watchlist, "badexample.org", "")
+	doWatchlistTest(t, watchlist, "example.net", "EXAMPLE.NET.")
+	doWatchlistTest(t, watchlist, "*.example.com", "www.example.com")
+	doWatchlistTest(t, watchlist, "?.example.org", ".example.org")
+
+	everything := new(Watchlist)
+	everything.Add(".")
+	doWatchlistTest(t, everything, "example.com", ".")
+}
+
+func TestWatchlistMatch(t *testing.T) {
+	watchlist := new(Watchlist)
+	watchlist.AddDomain("example.com", true)
+
+	info := &EntryInfo{Identifiers: &Identifiers{DNSNames: []string{"www.example.com", "example.org"}}}
+	matches := watchlist.Match(info)
+	if len(matches) != 1 || matches[0].Value != "www.example.com" || matches[0].Pattern != ".example.com" {
+		t.Errorf("Wrong matches: %v", matches)
+	}
+
+	// Entries whose identifiers can't be parsed match, to be safe
+	if matches := watchlist.Match(&EntryInfo{}); len(matches) != 1 || matches[0].Category != MatchUnparsable {
+		t.Errorf("Unparsable entry should match, but got: %v", matches)
+	}
+}
+
+func TestWatchlistPatterns(t *testing.T) {
+	watchlist, err := ReadWatchlist(strings.NewReader("*.example.com wildcard\nexample.* brand\npaypal*.net\n/.*-l[o0]gin\\.org/ regexp\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doWatchlistTest(t, watchlist, "www.example.com", "*.example.com")
+	doWatchlistTest(t, watchlist, "a.b.example.com", "*.example.com")
+	doWatchlistTest(t, watchlist, "example.co.uk", "example.*")
+	doWatchlistTest(t, watchlist, "www.example.co.uk", "")
+	doWatchlistTest(t, watchlist, "paypal.net", "paypal*.net")
+	doWatchlistTest(t, watchlist, "paypal-secure.net", "paypal*.net")
+	doWatchlistTest(t, watchlist, "www.paypal.net", "")
+	doWatchlistTest(t, watchlist, "bank-l0gin.org", "/.*-l[o0]gin\\.org/")
+	doWatchlistTest(t, watchlist, "BANK-LOGIN.ORG", "/.*-l[o0]gin\\.org/")
+	doWatchlistTest(t, watchlist, "bank-login.org.evil", "")
+
+	if id := watchlist.MatchDNSName("www.example.com").ID; id != "wildcard" {
+		t.Errorf("Wrong ID: %q", id)
+	}
+	if id := watchlist.MatchDNSName("paypal.net").ID; id != "paypal*.net" {
+		t.Errorf("Wrong default ID: %q", id)
+	}
+}
+
+func TestWatchlistEmailAndURI(t *testing.T) {
+	watchlist, err := ReadWatchlist(strings.NewReader("ceo@example.com\n@.example.org\nuri:.example.net\nuri:spiffe://Example.COM/prod/\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailTests := map[string]string{
+		"ceo@example.com":      "ceo@example.com",
+		"CEO@EXAMPLE.COM":      "ceo@example.com",
+		"cfo@example.com":      "",
+		"anyone@example.org":   "@.example.org",
+		"anyone@a.example.org": "@.example.org",
+		"anyone@example.net":   "",
+	}
+	for address, expected := range emailTests {
+		item := watchlist.MatchEmailAddress(address)
+		if (item == nil && expected != "") || (item != nil && item.Pattern != expected) {
+			t.Errorf("MatchEmailAddress(%q) returned %v, expected %q", address, item, expected)
+		}
+	}
+	uriTests := map[string]string{
+		"https://www.example.net/path":      "uri:.example.net",
+		"spiffe://example.com/prod/service": "uri:spiffe://Example.COM/prod/",
+		"spiffe://example.com/dev/service":  "",
+		"https://example.com/":              "",
+	}
+	for uri, expected := range uriTests {
+		item := watchlist.MatchURI(uri)
+		if (item == nil && expected != "") || (item != nil && item.Pattern != expected) {
+			t.Errorf("MatchURI(%q) returned %v, expected %q", uri, item, expected)
+		}
+	}
+	// Email and URI items don't match DNS names
+	doWatchlistTest(t, watchlist, "www.example.org", "")
+	doWatchlistTest(t, watchlist, "www.example.net", "")
+}
+
+func TestWatchlistNormalization(t *testing.T) {
+	watchlist, err := ReadWatchlist(strings.NewReader("Example.COM.\n.xn--bcher-kva.example\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doWatchlistTest(t, watchlist, "example.com", "Example.COM.")
+	doWatchlistTest(t, watchlist, "EXAMPLE.com..", "Example.COM.")
+	doWatchlistTest(t, watchlist, "example。com", "Example.COM.")
+	doWatchlistTest(t, watchlist, "WWW.XN--BCHER-KVA.EXAMPLE", ".xn--bcher-kva.example")
+}
+
+func TestWatchlistPublicSuffix(t *testing.T) {
+	watchlist, err := ReadWatchlist(strings.NewReader("example.+\n.brand.+\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doWatchlistTest(t, watchlist, "example.com", "example.+")
+	doWatchlistTest(t, watchlist, "example.co.uk", "example.+")
+	doWatchlistTest(t, watchlist, "www.example.co.uk", "")
+	doWatchlistTest(t, watchlist, "example.example.com", "")
+	doWatchlistTest(t, watchlist, "brand.co.uk", ".brand.+")
+	doWatchlistTest(t, watchlist, "login.brand.org", ".brand.+")
+	doWatchlistTest(t, watchlist, "co.uk", "")
+}
+
+func TestWatchlistIPAddresses(t *testing.T) {
+	watchlist, err := ReadWatchlist(strings.NewReader("192.0.2.1\n198.51.100.0/24 office\n2001:db8::/32\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ipaddr, expected := range map[string]string{
+		"192.0.2.1":        "192.0.2.1",
+		"192.0.2.2":        "",
+		"198.51.100.77":    "198.51.100.0/24",
+		"::ffff:192.0.2.1": "192.0.2.1",
+		"2001:db8::1":      "2001:db8::/32",
+		"2001:db9::1":      "",
+	} {
+		item := watchlist.MatchIPAddress(net.ParseIP(ipaddr))
+		if item == nil && expected != "" {
+			t.Errorf("%s doesn't match, but should match %q", ipaddr, expected)
+		} else if item != nil && item.Pattern != expected {
+			t.Errorf("%s matches %q, but should match %q", ipaddr, item.Pattern, expected)
+		}
+	}
+	doWatchlistTest(t, watchlist, "192.0.2.1", "")
+
+	if _, err := ParseWatchlistItem("192.0.2.0/33"); err == nil {
+		t.Errorf("Invalid CIDR range was accepted")
+	}
+}
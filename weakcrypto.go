@@ -0,0 +1,79 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"encoding/asn1"
+)
+
+const MatchWeakCrypto = "weak_crypto"
+
+// Signature algorithms using MD2, MD5, or SHA-1, which are vulnerable to
+// collisions
+var weakSignatureAlgorithms = []asn1.ObjectIdentifier{
+	{1, 2, 840, 113549, 1, 1, 2}, // md2WithRSAEncryption
+	{1, 2, 840, 113549, 1, 1, 4}, // md5WithRSAEncryption
+	{1, 2, 840, 113549, 1, 1, 5}, // sha1WithRSAEncryption
+	{1, 3, 14, 3, 2, 29},         // sha1WithRSASignature (OIW)
+	{1, 2, 840, 10045, 4, 1},     // ecdsa-with-SHA1
+	{1, 2, 840, 10040, 4, 3},     // dsa-with-sha1
+}
+
+// Curves which the Web PKI still accepts.  Keys on other named curves, like
+// P-192, P-224, and secp256k1, are deprecated.
+var acceptedCurves = []string{"P-256", "P-384", "P-521"}
+
+// WeakCryptoFilter matches certificates signed with a weak hash algorithm,
+// or whose ECDSA key is on a deprecated curve.  Explicit and unrecognized
+// curves are left to WeakKeyFilter.
+type WeakCryptoFilter struct{}
+
+// Weaknesses returns a description of each weak algorithm used by |cert|
+func (filter *WeakCryptoFilter) Weaknesses(cert *CertInfo) []string {
+	var weaknesses []string
+	if algorithm, err := cert.TBS.ParseSignatureAlgorithm(); err != nil {
+		weaknesses = append(weaknesses, err.Error())
+	} else if isWeakSignatureAlgorithm(algorithm) {
+		weaknesses = append(weaknesses, "signed with "+signatureAlgorithmName(algorithm))
+	}
+	if key := cert.PublicKey; cert.PublicKeyParseError == nil && key.Algorithm == KeyAlgorithmECDSA && key.Bits != 0 && !isAcceptedCurve(key.Curve) {
+		weaknesses = append(weaknesses, "ECDSA curve "+key.Curve+" is deprecated")
+	}
+	return weaknesses
+}
+
+func (filter *WeakCryptoFilter) Match(info *EntryInfo) []Match {
+	if info.CertInfo == nil {
+		return nil
+	}
+	var matches []Match
+	for _, weakness := range filter.Weaknesses(info.CertInfo) {
+		matches = append(matches, Match{Category: MatchWeakCrypto, ID: MatchWeakCrypto, Value: weakness})
+	}
+	return matches
+}
+
+func isWeakSignatureAlgorithm(oid asn1.ObjectIdentifier) bool {
+	for _, algorithm := range weakSignatureAlgorithms {
+		if algorithm.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAcceptedCurve(name string) bool {
+	for _, curve := range acceptedCurves {
+		if curve == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"encoding/asn1"
+	"testing"
+)
+
+func TestWeakCrypto(t *testing.T) {
+	filter := &WeakCryptoFilter{}
+
+	cert := makeTestCertInfo(t, nil, nil, nil)
+	if weaknesses := filter.Weaknesses(cert); len(weaknesses) != 0 {
+		t.Errorf("ECDSA P-256 SHA-256 certificate has weaknesses %v", weaknesses)
+	}
+
+	algorithm, err := asn1.Marshal(algorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.TBS.SignatureAlgorithm.FullBytes = algorithm
+	cert.PublicKey.Curve = "secp256k1"
+	matches := filter.Match(&EntryInfo{CertInfo: cert})
+	if len(matches) != 2 || matches[0].Value != "signed with md5WithRSAEncryption" || matches[1].Value != "ECDSA curve secp256k1 is deprecated" {
+		t.Fatalf("Wrong matches: %+v", matches)
+	}
+	if matches[0].Category != MatchWeakCrypto {
+		t.Errorf("Wrong category: %s", matches[0].Category)
+	}
+
+	// Unrecognized curves are WeakKeyFilter's business
+	cert.PublicKey.Curve = "1.3.36.3.3.2.8.1.1.7"
+	cert.PublicKey.Bits = 0
+	if weaknesses := filter.Weaknesses(cert); len(weaknesses) != 1 {
+		t.Errorf("Unrecognized curve has weaknesses %v", weaknesses)
+	}
+}
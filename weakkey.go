@@ -0,0 +1,118 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"math/big"
+)
+
+const MatchWeakKey = "weak_key"
+
+const (
+	DefaultMinRSABits = 2048
+	minDLBits         = 2048 // DSA and DH
+	minCurveBits      = 224
+)
+
+var smallPrimes = []int64{3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97}
+
+func ellipticCurve(name string) elliptic.Curve {
+	switch name {
+	case "P-224":
+		return elliptic.P224()
+	case "P-256":
+		return elliptic.P256()
+	case "P-384":
+		return elliptic.P384()
+	case "P-521":
+		return elliptic.P521()
+	default:
+		return nil
+	}
+}
+
+// WeakKeyFilter matches certificates whose public key is too small, has
+// bad parameters, or is in Blocklist
+type WeakKeyFilter struct {
+	MinRSABits int
+	Blocklist  *PubkeyMatcher // optional
+}
+
+func NewWeakKeyFilter() *WeakKeyFilter {
+	return &WeakKeyFilter{MinRSABits: DefaultMinRSABits}
+}
+
+// Weaknesses returns a description of each weakness in |key|
+func (filter *WeakKeyFilter) Weaknesses(key *PublicKeyInfo) []string {
+	var weaknesses []string
+	switch key.Algorithm {
+	case KeyAlgorithmRSA:
+		if key.Bits < filter.MinRSABits {
+			weaknesses = append(weaknesses, fmt.Sprintf("RSA key is only %d bits", key.Bits))
+		}
+		if key.RSAExponent.Cmp(big.NewInt(3)) < 0 || key.RSAExponent.Bit(0) == 0 {
+			weaknesses = append(weaknesses, fmt.Sprintf("RSA exponent %s is invalid", key.RSAExponent))
+		}
+		if key.RSAModulus.Bit(0) == 0 {
+			weaknesses = append(weaknesses, "RSA modulus is even")
+		} else {
+			remainder := new(big.Int)
+			for _, prime := range smallPrimes {
+				if remainder.Mod(key.RSAModulus, big.NewInt(prime)).Sign() == 0 {
+					weaknesses = append(weaknesses, fmt.Sprintf("RSA modulus is divisible by %d", prime))
+					break
+				}
+			}
+		}
+	case KeyAlgorithmDSA, KeyAlgorithmDH:
+		if key.Bits < minDLBits {
+			weaknesses = append(weaknesses, fmt.Sprintf("%s prime is only %d bits", key.Algorithm, key.Bits))
+		}
+		if !key.Prime.ProbablyPrime(20) {
+			weaknesses = append(weaknesses, fmt.Sprintf("%s modulus is not prime", key.Algorithm))
+		}
+	case KeyAlgorithmECDSA:
+		if key.Curve == CurveExplicit {
+			weaknesses = append(weaknesses, "ECDSA curve parameters are explicit rather than a named curve")
+		} else if key.Bits == 0 {
+			weaknesses = append(weaknesses, fmt.Sprintf("ECDSA curve %s is not recognized", key.Curve))
+		} else if key.Bits < minCurveBits {
+			weaknesses = append(weaknesses, fmt.Sprintf("ECDSA curve %s is only %d bits", key.Curve, key.Bits))
+		}
+		if curve := ellipticCurve(key.Curve); curve != nil {
+			if x, _ := elliptic.Unmarshal(curve, key.ECPoint); x == nil {
+				weaknesses = append(weaknesses, "ECDSA public key is not a valid point on "+key.Curve)
+			}
+		}
+	}
+	return weaknesses
+}
+
+func (filter *WeakKeyFilter) Match(info *EntryInfo) []Match {
+	if info.CertInfo == nil {
+		return nil
+	}
+	var matches []Match
+	if filter.Blocklist != nil {
+		for _, match := range filter.Blocklist.Match(info) {
+			matches = append(matches, Match{Category: MatchWeakKey, ID: match.ID, Value: "key is compromised"})
+		}
+	}
+	if info.CertInfo.PublicKeyParseError != nil {
+		matches = append(matches, Match{Category: MatchWeakKey, ID: MatchWeakKey, Value: info.CertInfo.PublicKeyParseError.Error()})
+		return matches
+	}
+	for _, weakness := range filter.Weaknesses(info.CertInfo.PublicKey) {
+		matches = append(matches, Match{Category: MatchWeakKey, ID: MatchWeakKey, Value: weakness})
+	}
+	return matches
+}
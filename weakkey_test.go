@@ -0,0 +1,67 @@
+// Copyright (C) 2017 Opsmate, Inc.
+//
+// This Source Code Form is subject to the terms of the Mozilla
+// Public License, v. 2.0. If a copy of the MPL was not distributed
+// with this file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// This software is distributed WITHOUT A WARRANTY OF ANY KIND.
+// See the Mozilla Public License for details.
+
+package certspotter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+)
+
+func parseTestKey(t *testing.T, pub interface{}) *PublicKeyInfo {
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %s", err)
+	}
+	key, err := ParsePublicKeyInfo(spki)
+	if err != nil {
+		t.Fatalf("ParsePublicKeyInfo: %s", err)
+	}
+	return key
+}
+
+func TestWeakKeys(t *testing.T) {
+	filter := NewWeakKeyFilter()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := parseTestKey(t, &rsaKey.PublicKey)
+	if key.Algorithm != KeyAlgorithmRSA || key.Bits != 1024 {
+		t.Errorf("RSA key parsed as %s %d", key.Algorithm, key.Bits)
+	}
+	if weaknesses := filter.Weaknesses(key); len(weaknesses) != 1 {
+		t.Errorf("1024 bit RSA key has weaknesses %v", weaknesses)
+	}
+	filter.MinRSABits = 1024
+	if weaknesses := filter.Weaknesses(key); len(weaknesses) != 0 {
+		t.Errorf("RSA key has weaknesses %v with MinRSABits=1024", weaknesses)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key = parseTestKey(t, &ecKey.PublicKey)
+	if key.Algorithm != KeyAlgorithmECDSA || key.Curve != "P-256" || key.Bits != 256 {
+		t.Errorf("ECDSA key parsed as %s %s %d", key.Algorithm, key.Curve, key.Bits)
+	}
+	if weaknesses := filter.Weaknesses(key); len(weaknesses) != 0 {
+		t.Errorf("P-256 key has weaknesses %v", weaknesses)
+	}
+	key.ECPoint[len(key.ECPoint)-1] ^= 1
+	if weaknesses := filter.Weaknesses(key); len(weaknesses) != 1 {
+		t.Errorf("P-256 key with invalid point has weaknesses %v", weaknesses)
+	}
+}
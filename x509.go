@@ -126,6 +126,26 @@ func (rdns RDNSequence) ParseCNs() ([]string, error) {
 	return cns, nil
 }
 
+func (rdns RDNSequence) ParseOrganizations() ([]string, error) {
+	var orgs []string
+
+	for _, rdn := range rdns {
+		if len(rdn) == 0 {
+			continue
+		}
+		atv := rdn[0]
+		if atv.Type.Equal(oidOrganization) {
+			orgString, err := decodeASN1String(&atv.Value)
+			if err != nil {
+				return nil, errors.New("Error decoding O: " + err.Error())
+			}
+			orgs = append(orgs, orgString)
+		}
+	}
+
+	return orgs, nil
+}
+
 func rdnLabel(oid asn1.ObjectIdentifier) string {
 	switch {
 	case oid.Equal(oidCountry):